@@ -4,11 +4,14 @@ package mssql
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
 )
 
 func TestNewAccessTokenConnector(t *testing.T) {
@@ -90,3 +93,42 @@ func TestAccessTokenConnectorFailsToConnectIfNoAccessToken(t *testing.T) {
 		t.Fatalf("expected error to contain %q, but got %q", errorText, err)
 	}
 }
+
+func TestConnectorTokenProvider(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.RowsAffected(0)}
+	})
+	defer srv.Close()
+	srv.Authenticate = func(login *mssqltest.Login) error {
+		if login.AccessToken != "plugged-in" {
+			return fmt.Errorf("unexpected token %q", login.AccessToken)
+		}
+		return nil
+	}
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	c.TokenProvider = TokenProviderFunc(func(ctx context.Context) (string, error) {
+		calls++
+		return "plugged-in", nil
+	})
+	db := sql.OpenDB(c)
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected one token request, got %d", calls)
+	}
+
+	c.TokenProvider = TokenProviderFunc(func(ctx context.Context) (string, error) {
+		return "", errors.New("no credential available")
+	})
+	db2 := sql.OpenDB(c)
+	defer db2.Close()
+	if err = db2.Ping(); err == nil || !strings.Contains(err.Error(), "no credential available") {
+		t.Errorf("expected the provider error, got %v", err)
+	}
+}
@@ -0,0 +1,611 @@
+package mssql
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+)
+
+// ColumnEncryptionKeyProvider decrypts Always Encrypted column encryption
+// keys (CEK) that are protected by a column master key (CMK) held in an
+// external key store such as Azure Key Vault, a certificate store, a PFX
+// file or an HSM.
+//
+// Providers are registered on a Connector by key store provider name,
+// the same name recorded in the column master key metadata on the server.
+// The keys are used when the columnencryption connection parameter is
+// set.
+type ColumnEncryptionKeyProvider interface {
+	// DecryptColumnEncryptionKey decrypts encryptedCEK with the column master
+	// key found at masterKeyPath using the given key encryption algorithm.
+	DecryptColumnEncryptionKey(ctx context.Context, masterKeyPath, algorithm string, encryptedCEK []byte) ([]byte, error)
+}
+
+// cekValue is a column encryption key encrypted by one column master key.
+type cekValue struct {
+	encryptedKey []byte
+	keyStoreName string
+	keyPath      string
+	algorithm    string
+}
+
+// cekTableEntry describes a single column encryption key.
+//
+// While a column master key is being rotated the server keeps the CEK
+// encrypted under both the old and the new CMK, so an entry may carry
+// more than one encrypted value. Any of them decrypts to the same key.
+type cekTableEntry struct {
+	databaseID int32
+	keyID      int32
+	keyVersion int32
+	mdVersion  []byte
+	values     []cekValue
+}
+
+type cekCacheKey struct {
+	databaseID int32
+	keyID      int32
+	keyVersion int32
+	mdVersion  string
+}
+
+func (e *cekTableEntry) cacheKey() cekCacheKey {
+	return cekCacheKey{e.databaseID, e.keyID, e.keyVersion, string(e.mdVersion)}
+}
+
+// cekCache holds decrypted column encryption keys shared by all
+// connections of a connector.
+type cekCache struct {
+	mu   sync.Mutex
+	keys map[cekCacheKey][]byte
+}
+
+func (c *cekCache) get(k cekCacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.keys[k]
+	return key, ok
+}
+
+func (c *cekCache) put(k cekCacheKey, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[cekCacheKey][]byte)
+	}
+	c.keys[k] = key
+}
+
+// evict drops every cached version of the given key, which is what
+// has to happen once the server metadata for it is known to be stale.
+func (c *cekCache) evict(databaseID, keyID int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.keys {
+		if k.databaseID == databaseID && k.keyID == keyID {
+			delete(c.keys, k)
+		}
+	}
+}
+
+// KeyRotationError is returned when none of the encrypted values of a
+// column encryption key could be decrypted, which typically means a column
+// master key was rotated or removed from the key store.
+//
+// It is also passed to Connector.KeyRotationHook; in that case Recovered
+// reports whether decryption succeeded after refreshing the key metadata.
+type KeyRotationError struct {
+	DatabaseID int32
+	KeyID      int32
+	KeyVersion int32
+	// KeyPaths lists the column master key paths that were tried, in order.
+	KeyPaths []string
+	// Errs holds the error returned for each entry of KeyPaths.
+	Errs []error
+	// Recovered is set when the key was decrypted after a metadata refresh.
+	Recovered bool
+}
+
+func (e *KeyRotationError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = fmt.Sprintf("%s: %v", e.KeyPaths[i], err)
+	}
+	return fmt.Sprintf("mssql: failed to decrypt column encryption key %d (version %d) in database %d: %s",
+		e.KeyID, e.KeyVersion, e.DatabaseID, strings.Join(msgs, "; "))
+}
+
+// decryptCEK returns the plaintext key for entry, trying each encrypted
+// value in turn until one of the registered providers accepts it.
+func (c *Connector) decryptCEK(ctx context.Context, entry *cekTableEntry) ([]byte, error) {
+	ck := entry.cacheKey()
+	if key, ok := c.cekCache.get(ck); ok {
+		return key, nil
+	}
+	rerr := &KeyRotationError{
+		DatabaseID: entry.databaseID,
+		KeyID:      entry.keyID,
+		KeyVersion: entry.keyVersion,
+	}
+	for _, v := range entry.values {
+		var err error
+		provider, ok := c.ColumnEncryptionKeyProviders[v.keyStoreName]
+		if ok {
+			var key []byte
+			key, err = provider.DecryptColumnEncryptionKey(ctx, v.keyPath, v.algorithm, v.encryptedKey)
+			if err == nil {
+				c.cekCache.put(ck, key)
+				return key, nil
+			}
+		} else {
+			err = fmt.Errorf("no column encryption key provider registered for key store %q", v.keyStoreName)
+		}
+		rerr.KeyPaths = append(rerr.KeyPaths, v.keyPath)
+		rerr.Errs = append(rerr.Errs, err)
+	}
+	if len(rerr.Errs) == 0 {
+		rerr.Errs = append(rerr.Errs, fmt.Errorf("no encrypted values"))
+		rerr.KeyPaths = append(rerr.KeyPaths, "")
+	}
+	return nil, rerr
+}
+
+// resolveCEK decrypts entry. If that fails and refresh is not nil, cached
+// keys for it are dropped and refresh is called to fetch current metadata
+// from the server before trying once more. KeyRotationHook is notified of
+// every failure, including ones that the refresh recovered from.
+func (c *Connector) resolveCEK(ctx context.Context, entry *cekTableEntry, refresh func(ctx context.Context) (*cekTableEntry, error)) ([]byte, error) {
+	key, err := c.decryptCEK(ctx, entry)
+	if err == nil {
+		return key, nil
+	}
+	rerr, ok := err.(*KeyRotationError)
+	if !ok || refresh == nil {
+		c.notifyKeyRotation(rerr)
+		return nil, err
+	}
+	c.cekCache.evict(entry.databaseID, entry.keyID)
+	fresh, ferr := refresh(ctx)
+	if ferr != nil {
+		c.notifyKeyRotation(rerr)
+		return nil, ferr
+	}
+	key, err = c.decryptCEK(ctx, fresh)
+	if err != nil {
+		if ferr, ok := err.(*KeyRotationError); ok {
+			rerr = ferr
+		}
+		c.notifyKeyRotation(rerr)
+		return nil, err
+	}
+	rerr.Recovered = true
+	c.notifyKeyRotation(rerr)
+	return key, nil
+}
+
+func (c *Connector) notifyKeyRotation(err *KeyRotationError) {
+	if err != nil && c.KeyRotationHook != nil {
+		c.KeyRotationHook(err)
+	}
+}
+
+// cryptoMetadata describes the encryption of a column, parameter or
+// return value.
+type cryptoMetadata struct {
+	// entry is the column encryption key, nil for return values until it
+	// is matched with the parameter
+	entry *cekTableEntry
+	// baseTI is the type of the plaintext, cipherTI the type of the
+	// ciphertext on the wire
+	baseTI      typeInfo
+	cipherTI    typeInfo
+	algorithm   byte
+	encType     byte
+	normVersion byte
+}
+
+// parseCekTable reads the column encryption keys of a COLMETADATA token.
+func parseCekTable(r *tdsBuffer) []*cekTableEntry {
+	table := make([]*cekTableEntry, r.uint16())
+	for i := range table {
+		e := &cekTableEntry{
+			databaseID: r.int32(),
+			keyID:      r.int32(),
+			keyVersion: r.int32(),
+			mdVersion:  make([]byte, 8),
+		}
+		r.ReadFull(e.mdVersion)
+		e.values = make([]cekValue, r.byte())
+		for j := range e.values {
+			v := &e.values[j]
+			v.encryptedKey = make([]byte, r.uint16())
+			r.ReadFull(v.encryptedKey)
+			v.keyStoreName = r.BVarChar()
+			v.keyPath = r.UsVarChar()
+			v.algorithm = r.BVarChar()
+		}
+		table[i] = e
+	}
+	return table
+}
+
+// parseCryptoMetadata reads the CryptoMetaData of an encrypted column, its
+// ordinal refers to cekTable.
+func parseCryptoMetadata(r *tdsBuffer, cekTable []*cekTableEntry) *cryptoMetadata {
+	ordinal := int(r.uint16())
+	if ordinal >= len(cekTable) {
+		badStreamPanicf("invalid column encryption key ordinal %d", ordinal)
+	}
+	m := readCryptoMetadata(r)
+	m.entry = cekTable[ordinal]
+	return m
+}
+
+// readCryptoMetadata reads the CryptoMetaData of a return value, which has
+// no ordinal.
+func readCryptoMetadata(r *tdsBuffer) *cryptoMetadata {
+	m := &cryptoMetadata{}
+	r.uint32() // UserType
+	m.baseTI = readTypeInfo(r)
+	m.algorithm = r.byte()
+	if m.algorithm == cipherAlgorithmCustom {
+		r.BVarChar() // algorithm name
+	}
+	m.encType = r.byte()
+	m.normVersion = r.byte()
+	return m
+}
+
+// decryptValue decrypts the ciphertext of a value described by m.
+func decryptValue(sess *tdsSession, m *cryptoMetadata, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	cell, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("mssql: unexpected ciphertext of type %T", value)
+	}
+	if m.algorithm != cipherAlgorithmAEAD {
+		return nil, fmt.Errorf("mssql: unsupported column encryption algorithm %d", m.algorithm)
+	}
+	if m.entry == nil || sess.decryptKey == nil {
+		return nil, errors.New("mssql: no column encryption key for an encrypted value")
+	}
+	key, err := sess.decryptKey(m.entry)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decryptCell(key, cell)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCell(m.baseTI, plaintext)
+}
+
+// decryptRow decrypts the values of the encrypted columns of a row.
+func decryptRow(sess *tdsSession, columns []columnStruct, row []interface{}) (err error) {
+	for i := range columns {
+		if m := columns[i].cryptoMeta; m != nil {
+			if row[i], err = decryptValue(sess, m, row[i]); err != nil {
+				return fmt.Errorf("mssql: cannot decrypt column %s: %v", columns[i].ColName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parameterEncryption is the description of the parameters of a
+// statement by sp_describe_parameter_encryption.
+type parameterEncryption struct {
+	// params holds the encryption of the encrypted parameters by name,
+	// parameters sent in plaintext are left out
+	params map[string]*cryptoMetadata
+	// enclaveKeys are the column encryption keys the secure enclave
+	// needs to run the statement
+	enclaveKeys []*cekTableEntry
+}
+
+// describeParameterEncryption describes the encryption of the parameters
+// of query, declared by decls. On servers with a secure enclave, it also
+// attests the enclave unless a session with it is established.
+func (c *Conn) describeParameterEncryption(ctx context.Context, query, decls string) (*parameterEncryption, error) {
+	// keep the output parameters of the statement being sent
+	outs := c.outs
+	c.outs = outputs{}
+	defer func() { c.outs = outs }()
+
+	args := []namedValue{
+		{Name: "tsql", Ordinal: 1, Value: query},
+		{Name: "params", Ordinal: 2, Value: decls},
+	}
+	var attestation *enclaveAttestation
+	if c.sess.enclaveType != "" && c.connector.enclaves.get(c.enclaveSessionKey()) == nil {
+		var err error
+		if attestation, err = c.startAttestation(ctx); err != nil {
+			return nil, err
+		}
+		args = append(args, namedValue{Name: "attestationParameters", Ordinal: 3, Value: attestation.request})
+	}
+	stmt, err := c.prepareContext(ctx, "sp_describe_parameter_encryption")
+	if err != nil {
+		return nil, err
+	}
+	res, err := stmt.queryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	rows := res.(*Rows)
+	defer rows.Close()
+
+	// the column encryption keys: ordinal, database id, key id, key
+	// version, metadata version, encrypted key, key store, key path,
+	// algorithm and, on servers with an enclave, whether the enclave
+	// needs the key, a row per encrypted value
+	desc := &parameterEncryption{params: map[string]*cryptoMetadata{}}
+	keys := map[int64]*cekTableEntry{}
+	dest := make([]driver.Value, len(rows.cols))
+	if len(dest) < 9 {
+		return nil, errors.New("mssql: unexpected response of sp_describe_parameter_encryption")
+	}
+	for {
+		if err = rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ordinal, _ := dest[0].(int64)
+		e := keys[ordinal]
+		if e == nil {
+			e = &cekTableEntry{}
+			dbID, _ := dest[1].(int64)
+			keyID, _ := dest[2].(int64)
+			keyVersion, _ := dest[3].(int64)
+			e.databaseID, e.keyID, e.keyVersion = int32(dbID), int32(keyID), int32(keyVersion)
+			e.mdVersion, _ = dest[4].([]byte)
+			keys[ordinal] = e
+			if len(dest) >= 10 {
+				if requested, _ := dest[9].(bool); requested {
+					desc.enclaveKeys = append(desc.enclaveKeys, e)
+				}
+			}
+		}
+		var v cekValue
+		v.encryptedKey, _ = dest[5].([]byte)
+		v.keyStoreName, _ = dest[6].(string)
+		v.keyPath, _ = dest[7].(string)
+		v.algorithm, _ = dest[8].(string)
+		e.values = append(e.values, v)
+	}
+
+	// the parameters: ordinal, name, algorithm, encryption type, key
+	// ordinal and normalization rule version
+	if rows.NextResultSet() != nil || len(rows.cols) < 6 {
+		return nil, errors.New("mssql: unexpected response of sp_describe_parameter_encryption")
+	}
+	dest = make([]driver.Value, len(rows.cols))
+	for {
+		if err = rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name, _ := dest[1].(string)
+		algorithm, _ := dest[2].(int64)
+		encType, _ := dest[3].(int64)
+		ordinal, _ := dest[4].(int64)
+		normVersion, _ := dest[5].(int64)
+		if encType == encryptionTypePlaintext {
+			continue
+		}
+		e := keys[ordinal]
+		if e == nil {
+			return nil, fmt.Errorf("mssql: no column encryption key for parameter %s", name)
+		}
+		desc.params[name] = &cryptoMetadata{
+			entry:       e,
+			algorithm:   byte(algorithm),
+			encType:     byte(encType),
+			normVersion: byte(normVersion),
+		}
+	}
+
+	// the attestation info of the enclave, when attestation parameters
+	// were sent and the statement needs the enclave
+	if attestation == nil || rows.NextResultSet() != nil {
+		return desc, nil
+	}
+	dest = make([]driver.Value, len(rows.cols))
+	if len(dest) < 1 || rows.Next(dest) != nil {
+		return nil, errors.New("mssql: unexpected enclave attestation info")
+	}
+	info, _ := dest[0].([]byte)
+	session, err := attestation.finish(ctx, info)
+	if err != nil {
+		return nil, fmt.Errorf("mssql: enclave attestation failed: %v", err)
+	}
+	c.connector.enclaves.put(c.enclaveSessionKey(), session)
+	return desc, nil
+}
+
+// encryptParams encrypts the parameters of the statement that target
+// encrypted columns, as described by the server. It returns the package
+// of the keys the secure enclave needs, nil if the statement does not use
+// the enclave.
+func (s *Stmt) encryptParams(ctx context.Context, params []param, decls []string) (enclavePackage []byte, err error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	desc, err := s.c.describeParameterEncryption(ctx, s.query, strings.Join(decls, ","))
+	if err != nil {
+		return nil, err
+	}
+	for i := range params {
+		m, ok := desc.params[params[i].Name]
+		if !ok {
+			continue
+		}
+		entry := m.entry
+		refresh := func(ctx context.Context) (*cekTableEntry, error) {
+			return s.refreshKey(ctx, decls, entry)
+		}
+		if err = s.c.encryptParam(ctx, &params[i], m, refresh); err != nil {
+			return nil, fmt.Errorf("mssql: cannot encrypt parameter %s: %v", params[i].Name, err)
+		}
+	}
+	s.c.outs.encrypted = desc.params
+	if len(desc.enclaveKeys) == 0 {
+		return nil, nil
+	}
+	session := s.c.connector.enclaves.get(s.c.enclaveSessionKey())
+	if session == nil {
+		return nil, errors.New("mssql: the statement needs the secure enclave, but no enclave session is established")
+	}
+	return s.c.enclavePackage(ctx, session, s.query, desc.enclaveKeys, func(ctx context.Context, entry *cekTableEntry) (*cekTableEntry, error) {
+		return s.refreshKey(ctx, decls, entry)
+	})
+}
+
+// refreshKey describes the statement again when the key of entry cannot be
+// decrypted, see resolveCEK, and updates entry with the current values of
+// the key. The parameters and the enclave keys of the statement share the
+// entries of the keys.
+func (s *Stmt) refreshKey(ctx context.Context, decls []string, entry *cekTableEntry) (*cekTableEntry, error) {
+	desc, err := s.c.describeParameterEncryption(ctx, s.query, strings.Join(decls, ","))
+	if err != nil {
+		return nil, err
+	}
+	fresh := desc.enclaveKeys
+	for _, m := range desc.params {
+		fresh = append(fresh, m.entry)
+	}
+	for _, e := range fresh {
+		if e.databaseID == entry.databaseID && e.keyID == entry.keyID {
+			*entry = *e
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("mssql: the statement no longer uses the column encryption key %d of database %d", entry.keyID, entry.databaseID)
+}
+
+// cekValuesQuery reads the encrypted values of the column encryption key
+// @p2 from the catalog of the database @p1.
+const cekValuesQuery = `declare @db nvarchar(258) = quotename(db_name(@p1))
+declare @sql nvarchar(max) = N'select v.encrypted_value, m.key_store_provider_name, m.key_path, v.encryption_algorithm_name from ' +
+	@db + N'.sys.column_encryption_key_values v join ' + @db + N'.sys.column_master_keys m ' +
+	N'on m.column_master_key_id = v.column_master_key_id where v.column_encryption_key_id = @key'
+exec sp_executesql @sql, N'@key int', @p2`
+
+// readCEKValues reads the current values of the key of entry, when the key
+// of a result set cannot be decrypted, see resolveCEK. The result set is
+// read by the session, the values are read on a side connection.
+func (d *Driver) readCEKValues(c *Connector, params msdsn.Config, entry *cekTableEntry) (*cekTableEntry, error) {
+	ctx, cancel := sideConnectionContext(params)
+	defer cancel()
+	// the parameters of the query are not encrypted
+	params.ColumnEncryption = false
+	conn, err := d.sideConnection(ctx, c, params)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stmt := &Stmt{c: conn, query: cekValuesQuery}
+	res, err := stmt.queryContext(ctx, []namedValue{
+		{Ordinal: 1, Value: int64(entry.databaseID)},
+		{Ordinal: 2, Value: int64(entry.keyID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows := res.(*Rows)
+	defer rows.Close()
+	fresh := &cekTableEntry{
+		databaseID: entry.databaseID,
+		keyID:      entry.keyID,
+		keyVersion: entry.keyVersion,
+		mdVersion:  entry.mdVersion,
+	}
+	dest := make([]driver.Value, 4)
+	if len(rows.cols) != len(dest) {
+		return nil, errors.New("mssql: unexpected column encryption key values")
+	}
+	for {
+		if err = rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var v cekValue
+		v.encryptedKey, _ = dest[0].([]byte)
+		v.keyStoreName, _ = dest[1].(string)
+		v.keyPath, _ = dest[2].(string)
+		v.algorithm, _ = dest[3].(string)
+		fresh.values = append(fresh.values, v)
+	}
+	if len(fresh.values) == 0 {
+		return nil, fmt.Errorf("mssql: no values of the column encryption key %d of database %d", entry.keyID, entry.databaseID)
+	}
+	return fresh, nil
+}
+
+func (c *Conn) encryptParam(ctx context.Context, p *param, m *cryptoMetadata, refresh func(ctx context.Context) (*cekTableEntry, error)) error {
+	if m.algorithm != cipherAlgorithmAEAD {
+		return fmt.Errorf("unsupported column encryption algorithm %d", m.algorithm)
+	}
+	if m.normVersion != normalizationVersion {
+		return fmt.Errorf("unsupported normalization rule version %d", m.normVersion)
+	}
+	if p.stream != nil {
+		return errors.New("a streamed value cannot be encrypted")
+	}
+	m.baseTI = p.ti
+	var cell []byte
+	if p.buffer != nil {
+		// a NULL stays NULL
+		key, err := c.connector.resolveCEK(ctx, m.entry, refresh)
+		if err != nil {
+			return err
+		}
+		cell, err = encryptCell(key, normalizeCell(p.ti, p.buffer), m.encType == encryptionTypeDeterministic)
+		if err != nil {
+			return err
+		}
+	}
+	p.ti = typeInfo{TypeId: typeBigVarBin, Size: len(cell)}
+	switch {
+	case cell == nil:
+		p.ti.Size = 8000
+	case len(cell) > 8000:
+		p.ti.Size = 0 // varbinary(max)
+	}
+	p.buffer = cell
+	p.Flags |= fEncrypted
+	p.crypto = m
+	return nil
+}
+
+// writeParamCipherInfo writes the ParamCipherInfo that follows the value of
+// an encrypted parameter.
+func writeParamCipherInfo(w io.Writer, m *cryptoMetadata) error {
+	if err := writeTypeInfo(w, &m.baseTI); err != nil {
+		return err
+	}
+	e := m.entry
+	hdr := make([]byte, 2+12, 2+12+8+1)
+	hdr[0], hdr[1] = m.algorithm, m.encType
+	binary.LittleEndian.PutUint32(hdr[2:], uint32(e.databaseID))
+	binary.LittleEndian.PutUint32(hdr[6:], uint32(e.keyID))
+	binary.LittleEndian.PutUint32(hdr[10:], uint32(e.keyVersion))
+	mdVersion := make([]byte, 8)
+	copy(mdVersion, e.mdVersion)
+	hdr = append(hdr, mdVersion...)
+	hdr = append(hdr, m.normVersion)
+	_, err := w.Write(hdr)
+	return err
+}
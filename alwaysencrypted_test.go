@@ -0,0 +1,421 @@
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+type testCEKProvider struct {
+	// keys maps a master key path to the plaintext key it yields
+	keys  map[string][]byte
+	calls int
+}
+
+func (p *testCEKProvider) DecryptColumnEncryptionKey(ctx context.Context, masterKeyPath, algorithm string, encryptedCEK []byte) ([]byte, error) {
+	p.calls++
+	key, ok := p.keys[masterKeyPath]
+	if !ok {
+		return nil, errors.New("master key not found")
+	}
+	return key, nil
+}
+
+func testCEKEntry(paths ...string) *cekTableEntry {
+	e := &cekTableEntry{databaseID: 5, keyID: 1, keyVersion: 1, mdVersion: []byte{1}}
+	for _, p := range paths {
+		e.values = append(e.values, cekValue{
+			encryptedKey: []byte(p),
+			keyStoreName: "TEST_STORE",
+			keyPath:      p,
+			algorithm:    "RSA_OAEP",
+		})
+	}
+	return e
+}
+
+func TestDecryptCEKTriesEveryValue(t *testing.T) {
+	provider := &testCEKProvider{keys: map[string][]byte{"cmk2": []byte("secret")}}
+	c := &Connector{ColumnEncryptionKeyProviders: map[string]ColumnEncryptionKeyProvider{"TEST_STORE": provider}}
+
+	key, err := c.decryptCEK(context.Background(), testCEKEntry("cmk1", "cmk2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, []byte("secret")) {
+		t.Errorf("got key %q", key)
+	}
+	if provider.calls != 2 {
+		t.Errorf("expected 2 provider calls, got %d", provider.calls)
+	}
+
+	// second lookup is served from the cache
+	if _, err = c.decryptCEK(context.Background(), testCEKEntry("cmk1", "cmk2")); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 2 {
+		t.Errorf("expected cached key, got %d provider calls", provider.calls)
+	}
+}
+
+func TestDecryptCEKFailure(t *testing.T) {
+	c := &Connector{ColumnEncryptionKeyProviders: map[string]ColumnEncryptionKeyProvider{
+		"TEST_STORE": &testCEKProvider{},
+	}}
+	_, err := c.decryptCEK(context.Background(), testCEKEntry("cmk1", "cmk2"))
+	rerr, ok := err.(*KeyRotationError)
+	if !ok {
+		t.Fatalf("expected KeyRotationError, got %v", err)
+	}
+	if len(rerr.Errs) != 2 || rerr.KeyPaths[0] != "cmk1" || rerr.KeyPaths[1] != "cmk2" {
+		t.Errorf("unexpected error details: %v", rerr)
+	}
+}
+
+func TestResolveCEKRefreshesMetadata(t *testing.T) {
+	provider := &testCEKProvider{keys: map[string][]byte{"cmk2": []byte("secret")}}
+	var hooked []*KeyRotationError
+	c := &Connector{
+		ColumnEncryptionKeyProviders: map[string]ColumnEncryptionKeyProvider{"TEST_STORE": provider},
+		KeyRotationHook: func(err *KeyRotationError) {
+			hooked = append(hooked, err)
+		},
+	}
+	refreshed := 0
+	refresh := func(ctx context.Context) (*cekTableEntry, error) {
+		refreshed++
+		e := testCEKEntry("cmk2")
+		e.mdVersion = []byte{2}
+		return e, nil
+	}
+
+	key, err := c.resolveCEK(context.Background(), testCEKEntry("cmk1"), refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, []byte("secret")) {
+		t.Errorf("got key %q", key)
+	}
+	if refreshed != 1 {
+		t.Errorf("expected one metadata refresh, got %d", refreshed)
+	}
+	if len(hooked) != 1 || !hooked[0].Recovered {
+		t.Errorf("expected a single recovered hook notification, got %v", hooked)
+	}
+
+	// without a refresh function the failure is reported as is
+	hooked = nil
+	_, err = c.resolveCEK(context.Background(), testCEKEntry("cmk3"), nil)
+	if _, ok := err.(*KeyRotationError); !ok {
+		t.Fatalf("expected KeyRotationError, got %v", err)
+	}
+	if len(hooked) != 1 || hooked[0].Recovered {
+		t.Errorf("expected a single failed hook notification, got %v", hooked)
+	}
+}
+
+func TestEncryptedColumnDecryption(t *testing.T) {
+	cell, err := encryptCell(testCellKey, normalizeCell(typeInfo{TypeId: typeIntN, Size: 4}, []byte{42, 0, 0, 0}), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w bytes.Buffer
+	le := func(v interface{}) { binary.Write(&w, binary.LittleEndian, v) }
+	bVarChar := func(s string) {
+		w.WriteByte(byte(len(s)))
+		w.Write(str2ucs2(s))
+	}
+	w.WriteByte(byte(tokenColMetadata))
+	le(uint16(1))
+	// column encryption key table
+	le(uint16(1))
+	le([]int32{5, 1, 1})
+	w.Write(make([]byte, 8))
+	w.WriteByte(1)
+	le(uint16(4))
+	w.WriteString("cmk1")
+	bVarChar("TEST_STORE")
+	le(uint16(4))
+	w.Write(str2ucs2("cmk1"))
+	bVarChar("RSA_OAEP")
+	// an encrypted int column
+	le(uint32(0))
+	le(uint16(colFlagEncrypted | colFlagNullable))
+	w.WriteByte(typeBigVarBin)
+	le(uint16(8000))
+	le(uint16(0))
+	le(uint32(0))
+	w.Write([]byte{typeIntN, 4, cipherAlgorithmAEAD, encryptionTypeDeterministic, normalizationVersion})
+	bVarChar("a")
+	// a row
+	w.WriteByte(byte(tokenRow))
+	le(uint16(len(cell)))
+	w.Write(cell)
+	w.WriteByte(byte(tokenDone))
+	w.Write(make([]byte, 12))
+
+	data := append([]byte{byte(packReply), 1, 0, 0, 0, 0, 1, 0}, w.Bytes()...)
+	binary.BigEndian.PutUint16(data[2:], uint16(len(data)))
+	var keys int
+	sess := &tdsSession{
+		buf:              makeBuf(uint16(len(data)), data),
+		columnEncryption: true,
+		decryptKey: func(e *cekTableEntry) ([]byte, error) {
+			keys++
+			if e.keyID != 1 || len(e.values) != 1 || e.values[0].keyPath != "cmk1" {
+				t.Errorf("unexpected column encryption key %+v", e)
+			}
+			return testCellKey, nil
+		},
+	}
+	ch := make(chan tokenStruct, 5)
+	go processSingleResponse(sess, ch, outputs{})
+	var row []interface{}
+	for tok := range ch {
+		switch tok := tok.(type) {
+		case []columnStruct:
+			if tok[0].ti.TypeId != typeIntN || tok[0].ColName != "a" {
+				t.Errorf("unexpected column %+v", tok[0])
+			}
+		case []interface{}:
+			row = tok
+		case error:
+			t.Fatal(tok)
+		}
+	}
+	if len(row) != 1 || row[0] != int64(42) {
+		t.Errorf("got row %v", row)
+	}
+	if keys != 1 {
+		t.Errorf("expected 1 key lookup, got %d", keys)
+	}
+}
+
+// describeEncryption answers sp_describe_parameter_encryption for a
+// statement whose @p1 is encrypted with the key 7 of database 5, encrypted
+// by the master key at keyPath, and whose @p2 is sent in plaintext.
+func describeEncryption(keyPath string) []mssqltest.Response {
+	return []mssqltest.Response{
+		mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "column_encryption_key_ordinal", Type: mssqltest.Int},
+				{Name: "database_id", Type: mssqltest.Int},
+				{Name: "column_encryption_key_id", Type: mssqltest.Int},
+				{Name: "column_encryption_key_version", Type: mssqltest.Int},
+				{Name: "column_encryption_key_metadata_version", Type: mssqltest.VarBinary},
+				{Name: "column_encryption_key_encrypted_value", Type: mssqltest.VarBinary},
+				{Name: "column_master_key_store_provider_name", Type: mssqltest.NVarChar},
+				{Name: "column_master_key_path", Type: mssqltest.NVarChar},
+				{Name: "column_encryption_key_encryption_algorithm_name", Type: mssqltest.NVarChar},
+			},
+			Rows: [][]interface{}{{1, 5, 7, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte(keyPath), "TEST_STORE", keyPath, "RSA_OAEP"}},
+		},
+		mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "parameter_ordinal", Type: mssqltest.Int},
+				{Name: "parameter_name", Type: mssqltest.NVarChar},
+				{Name: "column_encryption_algorithm", Type: mssqltest.Int},
+				{Name: "column_encryption_type", Type: mssqltest.Int},
+				{Name: "column_encryption_key_ordinal", Type: mssqltest.Int},
+				{Name: "column_encryption_normalization_rule_version", Type: mssqltest.Int},
+			},
+			Rows: [][]interface{}{
+				{1, "@p1", cipherAlgorithmAEAD, encryptionTypeDeterministic, 1, normalizationVersion},
+				{2, "@p2", 0, encryptionTypePlaintext, 0, normalizationVersion},
+			},
+		},
+	}
+}
+
+func TestEncryptParameters(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if req.Proc != "sp_describe_parameter_encryption" {
+			return nil
+		}
+		return describeEncryption("cmk1")
+	})
+	defer srv.Close()
+	srv.ColumnEncryption = true
+
+	c, err := NewConnector(srv.DSN() + "&columnencryption=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ColumnEncryptionKeyProviders = map[string]ColumnEncryptionKeyProvider{
+		"TEST_STORE": &testCEKProvider{keys: map[string][]byte{"cmk1": testCellKey}},
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	if _, err = db.Exec("insert into t (ssn, name) values (@p1, @p2)", "123-45-6789", "joe"); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 2 || reqs[0].Proc != "sp_describe_parameter_encryption" {
+		t.Fatalf("expected the parameter encryption to be described first, got %+v", reqs)
+	}
+	if tsql, _ := reqs[0].Param("@tsql").Value.(string); tsql != "insert into t (ssn, name) values (@p1, @p2)" {
+		t.Errorf("described %q", tsql)
+	}
+	p1, p2 := reqs[1].Param("@p1"), reqs[1].Param("@p2")
+	if p2.Encryption != nil || p2.Value != "joe" {
+		t.Errorf("expected @p2 in plaintext, got %+v", p2)
+	}
+	e := p1.Encryption
+	if e == nil {
+		t.Fatal("expected @p1 to be encrypted")
+	}
+	if e.TypeID != typeNVarChar || e.Algorithm != cipherAlgorithmAEAD || e.EncryptionType != encryptionTypeDeterministic ||
+		e.DatabaseID != 5 || e.KeyID != 7 || e.KeyVersion != 1 || !bytes.Equal(e.KeyMDVersion, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("unexpected cipher info %+v", e)
+	}
+	plaintext, err := decryptCell(testCellKey, p1.Value.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ucs22str(plaintext); s != "123-45-6789" {
+		t.Errorf("got plaintext %q", s)
+	}
+}
+
+func TestEncryptParametersAfterKeyRotation(t *testing.T) {
+	// the master key was rotated since the key was first described
+	describes := 0
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if req.Proc != "sp_describe_parameter_encryption" {
+			return nil
+		}
+		if describes++; describes == 1 {
+			return describeEncryption("cmk1")
+		}
+		return describeEncryption("cmk2")
+	})
+	defer srv.Close()
+	srv.ColumnEncryption = true
+
+	c, err := NewConnector(srv.DSN() + "&columnencryption=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ColumnEncryptionKeyProviders = map[string]ColumnEncryptionKeyProvider{
+		"TEST_STORE": &testCEKProvider{keys: map[string][]byte{"cmk2": testCellKey}},
+	}
+	var hooked []*KeyRotationError
+	c.KeyRotationHook = func(err *KeyRotationError) {
+		hooked = append(hooked, err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	if _, err = db.Exec("insert into t (ssn, name) values (@p1, @p2)", "123-45-6789", "joe"); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 3 || reqs[1].Proc != "sp_describe_parameter_encryption" {
+		t.Fatalf("expected the statement to be described again, got %+v", reqs)
+	}
+	if len(hooked) != 1 || !hooked[0].Recovered || hooked[0].KeyID != 7 {
+		t.Errorf("expected a recovered rotation of the key 7, got %v", hooked)
+	}
+	plaintext, err := decryptCell(testCellKey, reqs[2].Param("@p1").Value.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ucs22str(plaintext); s != "123-45-6789" {
+		t.Errorf("got plaintext %q", s)
+	}
+}
+
+func TestEncryptedColumnAfterKeyRotation(t *testing.T) {
+	cell, err := encryptCell(testCellKey, normalizeCell(typeInfo{TypeId: typeIntN, Size: 4}, []byte{42, 0, 0, 0}), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the result set still names the master key cmk1, the catalog the
+	// master key cmk2 it was rotated to
+	key := &mssqltest.EncryptionKey{
+		DatabaseID:   5,
+		KeyID:        7,
+		KeyVersion:   1,
+		KeyMDVersion: []byte{1},
+		Values:       []mssqltest.EncryptionKeyValue{{EncryptedKey: []byte("cmk1"), KeyStoreName: "TEST_STORE", KeyPath: "cmk1", Algorithm: "RSA_OAEP"}},
+	}
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		switch {
+		case req.SQL == "select ssn from t":
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "ssn", Type: mssqltest.Int, Encryption: &mssqltest.ColumnEncryption{
+					Key:            key,
+					Algorithm:      cipherAlgorithmAEAD,
+					EncryptionType: encryptionTypeDeterministic,
+					NormVersion:    normalizationVersion,
+				}}},
+				Rows: [][]interface{}{{cell}},
+			}}
+		case req.SQL == cekValuesQuery:
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{
+					{Name: "encrypted_value", Type: mssqltest.VarBinary},
+					{Name: "key_store_provider_name", Type: mssqltest.NVarChar},
+					{Name: "key_path", Type: mssqltest.NVarChar},
+					{Name: "encryption_algorithm_name", Type: mssqltest.NVarChar},
+				},
+				Rows: [][]interface{}{{[]byte("cmk2"), "TEST_STORE", "cmk2", "RSA_OAEP"}},
+			}}
+		}
+		return nil
+	})
+	defer srv.Close()
+	srv.ColumnEncryption = true
+
+	c, err := NewConnector(srv.DSN() + "&columnencryption=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ColumnEncryptionKeyProviders = map[string]ColumnEncryptionKeyProvider{
+		"TEST_STORE": &testCEKProvider{keys: map[string][]byte{"cmk2": testCellKey}},
+	}
+	var hooked []*KeyRotationError
+	c.KeyRotationHook = func(err *KeyRotationError) {
+		hooked = append(hooked, err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	var ssn int
+	if err = db.QueryRow("select ssn from t").Scan(&ssn); err != nil {
+		t.Fatal(err)
+	}
+	if ssn != 42 {
+		t.Errorf("got %d", ssn)
+	}
+
+	var query, values *mssqltest.Request
+	for _, req := range srv.Requests() {
+		switch req.SQL {
+		case "select ssn from t":
+			query = req
+		case cekValuesQuery:
+			values = req
+		}
+	}
+	if query == nil || values == nil {
+		t.Fatalf("expected the query and the key values to be read, got %v and %v", query, values)
+	}
+	if values.SessionID == query.SessionID {
+		t.Error("expected the key values to be read on a side connection")
+	}
+	if db, _ := values.Param("@p1").Value.(int64); db != 5 {
+		t.Errorf("expected the values of database 5, got %v", values.Param("@p1").Value)
+	}
+	if key, _ := values.Param("@p2").Value.(int64); key != 7 {
+		t.Errorf("expected the values of key 7, got %v", values.Param("@p2").Value)
+	}
+	if len(hooked) != 1 || !hooked[0].Recovered {
+		t.Errorf("expected a recovered rotation, got %v", hooked)
+	}
+}
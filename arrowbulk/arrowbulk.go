@@ -0,0 +1,185 @@
+// Package arrowbulk bulk copies Apache Arrow record batches to SQL Server,
+// converting the Arrow columns to the values of the bulk copy of the
+// driver. It is a separate module, so that the driver does not depend on
+// Arrow.
+//
+// The fields of the schema are copied to the destination columns of the
+// same name:
+//
+//	bulk := conn.CreateBulkContext(ctx, "dbo.events", arrowbulk.Columns(reader.Schema()))
+//	n, err := arrowbulk.Copy(bulk, reader)
+//
+// Integers are copied as int64, floating point numbers as float64, strings
+// and binaries as is, dates, times and timestamps as time.Time in UTC and
+// decimals as their decimal string. Other types, such as lists, structs and
+// dictionaries, are not supported.
+package arrowbulk
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// Columns returns the names of the fields of schema, the columns of a bulk
+// copy of its records.
+func Columns(schema *arrow.Schema) []string {
+	names := make([]string, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Copy copies the rows of the record batches of r to bulk and finishes the
+// copy, it returns the number of rows copied. See mssql.Bulk.CopyFrom for
+// the failures that abort the copy.
+func Copy(bulk *mssql.Bulk, r array.RecordReader) (int64, error) {
+	src, err := NewSource(r)
+	if err != nil {
+		return 0, err
+	}
+	return bulk.CopyFrom(src)
+}
+
+// Source is a mssql.BulkRowSource reading the rows of record batches.
+type Source struct {
+	r       array.RecordReader
+	convert []converter
+	rec     arrow.Record
+	// next is the index of the next row of rec
+	next int
+	row  []interface{}
+}
+
+// NewSource returns a source of the rows of the record batches of r. It
+// fails if a field of the schema has an unsupported type.
+func NewSource(r array.RecordReader) (*Source, error) {
+	fields := r.Schema().Fields()
+	s := &Source{r: r, convert: make([]converter, len(fields)), row: make([]interface{}, len(fields))}
+	for i, f := range fields {
+		c, err := converterFor(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("arrowbulk: field %s: %v", f.Name, err)
+		}
+		s.convert[i] = c
+	}
+	return s, nil
+}
+
+// Next returns the values of the next row, the slice is reused by the next
+// call. It returns io.EOF after the last row of the last record batch.
+func (s *Source) Next() ([]interface{}, error) {
+	for s.rec == nil || s.next >= int(s.rec.NumRows()) {
+		if !s.r.Next() {
+			if r, ok := s.r.(interface{ Err() error }); ok && r.Err() != nil {
+				return nil, r.Err()
+			}
+			return nil, io.EOF
+		}
+		s.rec, s.next = s.r.Record(), 0
+	}
+	for i, convert := range s.convert {
+		col := s.rec.Column(i)
+		if col.IsNull(s.next) {
+			s.row[i] = nil
+			continue
+		}
+		v, err := convert(col, s.next)
+		if err != nil {
+			return nil, fmt.Errorf("arrowbulk: field %s: %v", s.rec.ColumnName(i), err)
+		}
+		s.row[i] = v
+	}
+	s.next++
+	return s.row, nil
+}
+
+// converter returns the bulk copy value of the non-null row i of a column.
+type converter func(a arrow.Array, i int) (interface{}, error)
+
+func converterFor(dt arrow.DataType) (converter, error) {
+	switch dt.ID() {
+	case arrow.BOOL:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.Boolean).Value(i), nil }, nil
+	case arrow.INT8:
+		return func(a arrow.Array, i int) (interface{}, error) { return int64(a.(*array.Int8).Value(i)), nil }, nil
+	case arrow.INT16:
+		return func(a arrow.Array, i int) (interface{}, error) { return int64(a.(*array.Int16).Value(i)), nil }, nil
+	case arrow.INT32:
+		return func(a arrow.Array, i int) (interface{}, error) { return int64(a.(*array.Int32).Value(i)), nil }, nil
+	case arrow.INT64:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.Int64).Value(i), nil }, nil
+	case arrow.UINT8:
+		return func(a arrow.Array, i int) (interface{}, error) { return int64(a.(*array.Uint8).Value(i)), nil }, nil
+	case arrow.UINT16:
+		return func(a arrow.Array, i int) (interface{}, error) { return int64(a.(*array.Uint16).Value(i)), nil }, nil
+	case arrow.UINT32:
+		return func(a arrow.Array, i int) (interface{}, error) { return int64(a.(*array.Uint32).Value(i)), nil }, nil
+	case arrow.UINT64:
+		return func(a arrow.Array, i int) (interface{}, error) {
+			v := a.(*array.Uint64).Value(i)
+			if v > math.MaxInt64 {
+				return nil, fmt.Errorf("value %d overflows bigint", v)
+			}
+			return int64(v), nil
+		}, nil
+	case arrow.FLOAT32:
+		return func(a arrow.Array, i int) (interface{}, error) { return float64(a.(*array.Float32).Value(i)), nil }, nil
+	case arrow.FLOAT64:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.Float64).Value(i), nil }, nil
+	case arrow.STRING:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.String).Value(i), nil }, nil
+	case arrow.LARGE_STRING:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.LargeString).Value(i), nil }, nil
+	case arrow.BINARY:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.Binary).Value(i), nil }, nil
+	case arrow.LARGE_BINARY:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.LargeBinary).Value(i), nil }, nil
+	case arrow.FIXED_SIZE_BINARY:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.FixedSizeBinary).Value(i), nil }, nil
+	case arrow.DATE32:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.Date32).Value(i).ToTime(), nil }, nil
+	case arrow.DATE64:
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.Date64).Value(i).ToTime(), nil }, nil
+	case arrow.TIMESTAMP:
+		unit := dt.(*arrow.TimestampType).Unit
+		return func(a arrow.Array, i int) (interface{}, error) {
+			return a.(*array.Timestamp).Value(i).ToTime(unit), nil
+		}, nil
+	case arrow.TIME32:
+		unit := dt.(*arrow.Time32Type).Unit
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.Time32).Value(i).ToTime(unit), nil }, nil
+	case arrow.TIME64:
+		unit := dt.(*arrow.Time64Type).Unit
+		return func(a arrow.Array, i int) (interface{}, error) { return a.(*array.Time64).Value(i).ToTime(unit), nil }, nil
+	case arrow.DECIMAL128:
+		scale := int(dt.(*arrow.Decimal128Type).Scale)
+		return func(a arrow.Array, i int) (interface{}, error) {
+			return decimalString(a.(*array.Decimal128).Value(i).BigInt(), scale), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", dt)
+}
+
+// decimalString formats the unscaled value v of a decimal with scale
+// digits after the point.
+func decimalString(v *big.Int, scale int) string {
+	s := new(big.Int).Abs(v).String()
+	if scale > 0 {
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if v.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+package arrowbulk
+
+import (
+	"io"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/decimal128"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+)
+
+func TestSource(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "created", Type: &arrow.TimestampType{Unit: arrow.Millisecond}},
+		{Name: "price", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}},
+	}, nil)
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+	created := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	b.Field(0).(*array.Int32Builder).AppendValues([]int32{1, 2}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"a", ""}, []bool{true, false})
+	b.Field(2).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{arrow.Timestamp(created.UnixNano() / 1e6), 0}, nil)
+	b.Field(3).(*array.Decimal128Builder).AppendValues([]decimal128.Num{decimal128.FromI64(12345), decimal128.FromI64(-5)}, nil)
+	rec := b.NewRecord()
+	defer rec.Release()
+	r, err := array.NewRecordReader(schema, []arrow.Record{rec, rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Release()
+
+	if cols := Columns(schema); !reflect.DeepEqual(cols, []string{"id", "name", "created", "price"}) {
+		t.Errorf("unexpected columns %v", cols)
+	}
+	src, err := NewSource(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]interface{}{
+		{int64(1), "a", created, "123.45"},
+		{int64(2), nil, time.Unix(0, 0).UTC(), "-0.05"},
+	}
+	for i := 0; i < 4; i++ {
+		row, err := src.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(row, expected[i%2]) {
+			t.Errorf("row %d: expected %v, got %v", i, expected[i%2], row)
+		}
+	}
+	if _, err := src.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF after the last row, got %v", err)
+	}
+}
+
+func TestUnsupportedType(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)}}, nil)
+	r, err := array.NewRecordReader(schema, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSource(r); err == nil {
+		t.Error("expected lists to be unsupported")
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	tests := []struct {
+		v     int64
+		scale int
+		s     string
+	}{
+		{12345, 2, "123.45"},
+		{-5, 2, "-0.05"},
+		{7, 0, "7"},
+		{0, 3, "0.000"},
+	}
+	for _, tt := range tests {
+		if s := decimalString(big.NewInt(tt.v), tt.scale); s != tt.s {
+			t.Errorf("decimalString(%d, %d) = %s, expected %s", tt.v, tt.scale, s, tt.s)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// +build !windows
+
+package mssql
+
+import "github.com/denisenkom/go-mssqldb/msdsn"
+
+// getAuth returns the default integrated authentication, NTLM outside of
+// Windows.
+func getAuth(p msdsn.Config) (auth, bool) {
+	return getNTLMAuth(p)
+}
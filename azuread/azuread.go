@@ -0,0 +1,139 @@
+// Package azuread registers the "azuresql" driver, which logs in to Azure
+// SQL with Azure Active Directory access tokens that it acquires and
+// refreshes itself, so that no token needs to be minted outside of the
+// application.
+//
+// The connection string takes the parameters of the sqlserver driver and
+// a fedauth parameter selecting how tokens are acquired:
+//
+//	ActiveDirectoryManagedIdentity, ActiveDirectoryMSI
+//		The managed identity of the Azure VM, App Service, Functions app or
+//		AKS pod. The system assigned identity is used unless the client id
+//		of a user assigned identity is given as user id, or its Azure
+//		resource id as resource id.
+//
+//	ActiveDirectoryServicePrincipal
+//		An application registration, given as user id in the form
+//		clientid@tenantid. It authenticates with the client secret given as
+//		password or, if aadcertpath names a PEM file with a certificate and
+//		its RSA private key, with the certificate.
+//
+//	ActiveDirectoryDeviceCode
+//		A user who signs in interactively in a browser, on this or any other
+//		device, with a device code. The application registration is given
+//		as applicationclientid and the tenant as tenant id, which defaults
+//		to the organizations of work and school accounts. The code is
+//		presented by Options.DeviceCodePrompt and the refresh token of the
+//		sign-in is kept in Options.RefreshTokenCache for later connections.
+//		The sign-in must complete within the connection timeout.
+//
+// The authorityhost parameter changes the Azure AD endpoint of service
+// principals and device code sign-ins from
+// https://login.microsoftonline.com/, e.g. for national clouds.
+//
+// For example:
+//
+//	db, err := sql.Open(azuread.DriverName,
+//		"sqlserver://myserver.database.windows.net?database=sales&fedauth=ActiveDirectoryManagedIdentity")
+package azuread
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/msdsn"
+)
+
+// DriverName is the name the driver is registered with.
+const DriverName = "azuresql"
+
+// Authentication methods of the fedauth parameter.
+const (
+	ActiveDirectoryManagedIdentity  = "ActiveDirectoryManagedIdentity"
+	ActiveDirectoryMSI              = "ActiveDirectoryMSI"
+	ActiveDirectoryServicePrincipal = "ActiveDirectoryServicePrincipal"
+	ActiveDirectoryDeviceCode       = "ActiveDirectoryDeviceCode"
+)
+
+// sqlResource is the resource Azure SQL access tokens are issued for.
+const sqlResource = "https://database.windows.net/"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver opens connections authenticated with Azure Active Directory.
+type Driver struct{}
+
+// Open opens a new connection, see NewConnector for the DSN.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector implements driver.DriverContext.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	return NewConnector(dsn)
+}
+
+// Options configure the acquisition of tokens beyond what the connection
+// string can express.
+type Options struct {
+	// DeviceCodePrompt presents the code of a device code sign-in to the
+	// user. It defaults to printing the message of Azure AD to stderr.
+	DeviceCodePrompt func(ctx context.Context, code DeviceCode) error
+	// RefreshTokenCache keeps the refresh tokens of device code sign-ins.
+	// It defaults to a cache in memory shared by the connectors of the
+	// process.
+	RefreshTokenCache RefreshTokenCache
+}
+
+// NewConnector creates a connector that acquires access tokens as selected
+// by the fedauth parameter of dsn. Tokens are cached and refreshed shortly
+// before they expire, the connector is meant to be long lived, e.g. with
+// sql.OpenDB.
+func NewConnector(dsn string) (*mssql.Connector, error) {
+	return NewConnectorOptions(dsn, Options{})
+}
+
+// NewConnectorOptions is NewConnector with options.
+func NewConnectorOptions(dsn string, opts Options) (*mssql.Connector, error) {
+	config, params, err := msdsn.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	var source tokenSource
+	switch fedauth := params["fedauth"]; strings.ToLower(fedauth) {
+	case strings.ToLower(ActiveDirectoryManagedIdentity), strings.ToLower(ActiveDirectoryMSI):
+		source = &managedIdentity{
+			clientID:   config.User,
+			resourceID: params["resource id"],
+		}
+	case strings.ToLower(ActiveDirectoryServicePrincipal):
+		source, err = newServicePrincipal(config.User, config.Password, params["aadcertpath"], params["authorityhost"])
+		if err != nil {
+			return nil, err
+		}
+	case strings.ToLower(ActiveDirectoryDeviceCode):
+		source, err = newDeviceCodeFlow(params["applicationclientid"], params["tenant id"], params["authorityhost"], opts)
+		if err != nil {
+			return nil, err
+		}
+	case "":
+		return nil, fmt.Errorf("azuread: the fedauth parameter is required")
+	default:
+		return nil, fmt.Errorf("azuread: unsupported fedauth '%s'", fedauth)
+	}
+	// the token identifies the login, user id and password are not sent
+	config.User = ""
+	config.Password = ""
+	cache := &tokenCache{source: source}
+	return mssql.NewSecurityTokenConnector(config, cache.token)
+}
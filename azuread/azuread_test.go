@@ -0,0 +1,113 @@
+package azuread
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+// fakeIMDS serves managed identity tokens and counts the requests.
+func fakeIMDS(t *testing.T, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != sqlResource {
+			http.Error(w, `{"error":"invalid_request","error_description":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%s","expires_on":"%d","token_type":"Bearer"}`,
+			r.URL.Query().Get("client_id"), time.Now().Add(time.Hour).Unix())
+	}))
+}
+
+func TestManagedIdentity(t *testing.T) {
+	os.Unsetenv("IDENTITY_ENDPOINT")
+	var requests int32
+	imds := fakeIMDS(t, &requests)
+	defer imds.Close()
+	defer func(endpoint string) { imdsEndpoint = endpoint }(imdsEndpoint)
+	imdsEndpoint = imds.URL
+
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.RowsAffected(0)}
+	})
+	defer srv.Close()
+	srv.Authenticate = func(login *mssqltest.Login) error {
+		if login.AccessToken != "token-clientid" || login.User != "" {
+			return fmt.Errorf("unexpected token %q or user %q", login.AccessToken, login.User)
+		}
+		return nil
+	}
+	db, err := sql.Open(DriverName, srv.DSN()+"&fedauth=ActiveDirectoryManagedIdentity&user+id=clientid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxIdleConns(0)
+	for i := 0; i < 3; i++ {
+		if _, err = db.Exec("select 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(srv.Logins()) != 3 {
+		t.Errorf("expected 3 logins, got %d", len(srv.Logins()))
+	}
+	if requests != 1 {
+		t.Errorf("expected the token to be cached, got %d token requests", requests)
+	}
+}
+
+func TestNewConnectorErrors(t *testing.T) {
+	for _, dsn := range []string{
+		"sqlserver://db.example.com",
+		"sqlserver://db.example.com?fedauth=ActiveDirectoryMagic",
+	} {
+		if _, err := NewConnector(dsn); err == nil {
+			t.Errorf("expected an error for %s", dsn)
+		}
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	source := &fakeSource{expiresIn: 2 * time.Minute}
+	c := &tokenCache{source: source}
+	for i := 0; i < 2; i++ {
+		if _, err := c.token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if source.calls != 2 {
+		t.Errorf("a token about to expire was reused, %d calls", source.calls)
+	}
+}
+
+type fakeSource struct {
+	expiresIn time.Duration
+	calls     int
+}
+
+func (s *fakeSource) token(ctx context.Context) (string, time.Time, error) {
+	s.calls++
+	return "token", time.Now().Add(s.expiresIn), nil
+}
+
+func TestTokenResponseErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided."}`))
+	}))
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	_, err := requestToken(http.DefaultClient, req)
+	if err == nil || !strings.Contains(err.Error(), "AADSTS7000215") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package azuread
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeviceCode is the code of a device code sign-in, which the user enters
+// at VerificationURL in a browser on any device.
+type DeviceCode struct {
+	UserCode        string
+	VerificationURL string
+	// Message is the sign-in instruction of Azure AD to present to the
+	// user, it includes the code and URL.
+	Message string
+	// ExpiresIn is how long the code is valid.
+	ExpiresIn time.Duration
+}
+
+// RefreshTokenCache stores the refresh tokens of device code sign-ins, so
+// that later connections do not prompt the user again. A cache that
+// persists the tokens, e.g. in the keychain of the user, avoids the prompt
+// in later runs of the application as well.
+type RefreshTokenCache interface {
+	// Load returns the refresh token stored under key, or an empty string.
+	Load(key string) (string, error)
+	Store(key, refreshToken string) error
+}
+
+// memoryCache is the default RefreshTokenCache, shared by the connectors
+// of the process.
+type memoryCache struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+var defaultRefreshTokenCache = &memoryCache{tokens: map[string]string{}}
+
+func (c *memoryCache) Load(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens[key], nil
+}
+
+func (c *memoryCache) Store(key, refreshToken string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[key] = refreshToken
+	return nil
+}
+
+// printDeviceCode is the default prompt of device code sign-ins.
+func printDeviceCode(ctx context.Context, code DeviceCode) error {
+	_, err := fmt.Fprintln(os.Stderr, code.Message)
+	return err
+}
+
+// defaultPollInterval is used when Azure AD does not give an interval.
+const defaultPollInterval = 5 * time.Second
+
+var deviceCodeClient = &http.Client{Timeout: 30 * time.Second}
+
+// deviceCodeFlow acquires tokens of a user who signs in with a device code,
+// and later with the refresh token of the sign-in.
+type deviceCodeFlow struct {
+	authorityHost string
+	tenantID      string
+	clientID      string
+	prompt        func(ctx context.Context, code DeviceCode) error
+	cache         RefreshTokenCache
+}
+
+func newDeviceCodeFlow(clientID, tenantID, authorityHost string, opts Options) (*deviceCodeFlow, error) {
+	if clientID == "" {
+		return nil, errors.New("azuread: device code sign-in requires applicationclientid")
+	}
+	if tenantID == "" {
+		tenantID = "organizations"
+	}
+	if authorityHost == "" {
+		authorityHost = defaultAuthorityHost
+	}
+	d := &deviceCodeFlow{
+		authorityHost: strings.TrimSuffix(authorityHost, "/") + "/",
+		tenantID:      tenantID,
+		clientID:      clientID,
+		prompt:        opts.DeviceCodePrompt,
+		cache:         opts.RefreshTokenCache,
+	}
+	if d.prompt == nil {
+		d.prompt = printDeviceCode
+	}
+	if d.cache == nil {
+		d.cache = defaultRefreshTokenCache
+	}
+	return d, nil
+}
+
+func (d *deviceCodeFlow) endpoint(name string) string {
+	return d.authorityHost + url.PathEscape(d.tenantID) + "/oauth2/v2.0/" + name
+}
+
+// cacheKey identifies the sign-ins of the same application in a tenant.
+func (d *deviceCodeFlow) cacheKey() string {
+	return d.authorityHost + d.tenantID + "/" + d.clientID
+}
+
+func (d *deviceCodeFlow) form() url.Values {
+	form := url.Values{}
+	form.Set("client_id", d.clientID)
+	form.Set("scope", sqlScope+" offline_access")
+	return form
+}
+
+func (d *deviceCodeFlow) token(ctx context.Context) (string, time.Time, error) {
+	refreshToken, err := d.cache.Load(d.cacheKey())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if refreshToken != "" {
+		form := d.form()
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refreshToken)
+		r, err := postTokenRequest(ctx, deviceCodeClient, d.endpoint("token"), form)
+		if err == nil {
+			return d.received(r)
+		}
+		if _, ok := err.(*tokenError); !ok {
+			return "", time.Time{}, err
+		}
+		// the refresh token expired or was revoked, sign in again
+	}
+	r, err := d.signIn(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return d.received(r)
+}
+
+// received caches the refresh token of a token response.
+func (d *deviceCodeFlow) received(r *tokenResponse) (string, time.Time, error) {
+	if r.RefreshToken != "" {
+		if err := d.cache.Store(d.cacheKey(), r.RefreshToken); err != nil {
+			return "", time.Time{}, err
+		}
+	}
+	return r.AccessToken, r.expiry(), nil
+}
+
+// signIn prompts the user with a device code and waits for the sign-in.
+func (d *deviceCodeFlow) signIn(ctx context.Context) (*tokenResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, d.endpoint("devicecode"), strings.NewReader(d.form().Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := deviceCodeClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("azuread: device code request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("azuread: device code request failed: %v", err)
+	}
+	var dc struct {
+		DeviceCode       string `json:"device_code"`
+		UserCode         string `json:"user_code"`
+		VerificationURI  string `json:"verification_uri"`
+		ExpiresIn        int    `json:"expires_in"`
+		Interval         int    `json:"interval"`
+		Message          string `json:"message"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.Unmarshal(body, &dc); err != nil {
+		return nil, fmt.Errorf("azuread: invalid device code response with status %s", resp.Status)
+	}
+	if dc.Error != "" {
+		return nil, &tokenError{Code: dc.Error, Description: dc.ErrorDescription}
+	}
+	expiresIn := time.Duration(dc.ExpiresIn) * time.Second
+	err = d.prompt(ctx, DeviceCode{
+		UserCode:        dc.UserCode,
+		VerificationURL: dc.VerificationURI,
+		Message:         dc.Message,
+		ExpiresIn:       expiresIn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	deadline := time.Now().Add(expiresIn)
+	form := d.form()
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+	form.Set("device_code", dc.DeviceCode)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		r, err := postTokenRequest(ctx, deviceCodeClient, d.endpoint("token"), form)
+		if err == nil {
+			return r, nil
+		}
+		te, ok := err.(*tokenError)
+		switch {
+		case ok && te.Code == "authorization_pending" && time.Now().Before(deadline):
+		case ok && te.Code == "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package azuread
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+// fakeDeviceCodeAAD signs in with the device code "dc" on the second poll
+// and accepts the refresh tokens it issued.
+func fakeDeviceCodeAAD(t *testing.T) *httptest.Server {
+	var (
+		mu     sync.Mutex
+		polls  int
+		issued = map[string]bool{}
+	)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		r.ParseForm()
+		f := r.PostForm
+		if f.Get("client_id") != "devtool" || f.Get("scope") != sqlScope+" offline_access" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":"invalid_request","error_description":%q}`, f.Encode())
+			return
+		}
+		switch {
+		case r.URL.Path == "/organizations/oauth2/v2.0/devicecode":
+			fmt.Fprint(w, `{"device_code":"dc","user_code":"ABC123","verification_uri":"https://microsoft.com/devicelogin",`+
+				`"expires_in":60,"interval":1,"message":"To sign in, enter the code ABC123"}`)
+		case f.Get("grant_type") == "urn:ietf:params:oauth:grant-type:device_code" && f.Get("device_code") == "dc":
+			if polls++; polls < 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"authorization_pending","error_description":"pending"}`)
+				return
+			}
+			issued["rt1"] = true
+			fmt.Fprint(w, `{"access_token":"user-token","refresh_token":"rt1","expires_in":3599}`)
+		case f.Get("grant_type") == "refresh_token" && issued[f.Get("refresh_token")]:
+			issued["rt2"] = true
+			fmt.Fprint(w, `{"access_token":"user-token","refresh_token":"rt2","expires_in":3599}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"AADSTS70000: The refresh token has expired."}`)
+		}
+	}))
+}
+
+func TestDeviceCode(t *testing.T) {
+	aad := fakeDeviceCodeAAD(t)
+	defer aad.Close()
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.RowsAffected(0)}
+	})
+	defer srv.Close()
+	srv.Authenticate = func(login *mssqltest.Login) error {
+		if login.AccessToken != "user-token" {
+			return fmt.Errorf("unexpected token %q", login.AccessToken)
+		}
+		return nil
+	}
+	dsn := srv.DSN() + "&fedauth=ActiveDirectoryDeviceCode&applicationclientid=devtool&authorityhost=" + url.QueryEscape(aad.URL)
+
+	var prompts []DeviceCode
+	cache := &memoryCache{tokens: map[string]string{}}
+	opts := Options{
+		DeviceCodePrompt: func(ctx context.Context, code DeviceCode) error {
+			prompts = append(prompts, code)
+			return nil
+		},
+		RefreshTokenCache: cache,
+	}
+	ping := func() {
+		t.Helper()
+		c, err := NewConnectorOptions(dsn, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := sql.OpenDB(c)
+		defer db.Close()
+		if err = db.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ping()
+	if len(prompts) != 1 || prompts[0].UserCode != "ABC123" || prompts[0].VerificationURL != "https://microsoft.com/devicelogin" {
+		t.Fatalf("unexpected prompts %+v", prompts)
+	}
+	// a new connector signs in with the cached refresh token
+	ping()
+	if len(prompts) != 1 {
+		t.Errorf("the refresh token was not used, %d prompts", len(prompts))
+	}
+	for _, rt := range cache.tokens {
+		if rt != "rt2" {
+			t.Errorf("the cached refresh token was not replaced, got %q", rt)
+		}
+	}
+}
+
+func TestDeviceCodeRequiresClientID(t *testing.T) {
+	if _, err := NewConnector("sqlserver://db?fedauth=ActiveDirectoryDeviceCode"); err == nil {
+		t.Error("expected an error without applicationclientid")
+	}
+}
@@ -0,0 +1,56 @@
+package azuread
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// imdsEndpoint is the token endpoint of the Azure Instance Metadata
+// Service, which serves the managed identity of VMs and AKS pods.
+var imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// managedIdentity acquires tokens of the managed identity of the Azure
+// resource the application runs on.
+type managedIdentity struct {
+	// clientID or resourceID select a user assigned identity.
+	clientID   string
+	resourceID string
+}
+
+var managedIdentityClient = &http.Client{Timeout: 30 * time.Second}
+
+func (m *managedIdentity) token(ctx context.Context) (string, time.Time, error) {
+	q := url.Values{}
+	q.Set("resource", sqlResource)
+	var endpoint string
+	header := http.Header{}
+	// App Service and Functions provide their own endpoint
+	if ep, secret := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"); ep != "" && secret != "" {
+		endpoint = ep
+		q.Set("api-version", "2019-08-01")
+		header.Set("X-IDENTITY-HEADER", secret)
+	} else {
+		endpoint = imdsEndpoint
+		q.Set("api-version", "2018-02-01")
+		header.Set("Metadata", "true")
+	}
+	if m.clientID != "" {
+		q.Set("client_id", m.clientID)
+	}
+	if m.resourceID != "" {
+		q.Set("mi_res_id", m.resourceID)
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header = header
+	r, err := requestToken(managedIdentityClient, req.WithContext(ctx))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return r.AccessToken, r.expiry(), nil
+}
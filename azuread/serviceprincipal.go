@@ -0,0 +1,147 @@
+package azuread
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultAuthorityHost is the Azure AD endpoint of the public cloud.
+const defaultAuthorityHost = "https://login.microsoftonline.com/"
+
+// sqlScope is the OAuth 2.0 scope of Azure SQL access tokens.
+const sqlScope = sqlResource + ".default"
+
+var servicePrincipalClient = &http.Client{Timeout: 30 * time.Second}
+
+// servicePrincipal acquires tokens of an application registration with
+// the client credentials flow, authenticating with a secret or with a
+// certificate.
+type servicePrincipal struct {
+	authorityHost string
+	tenantID      string
+	clientID      string
+	secret        string
+	cert          *tls.Certificate
+}
+
+// newServicePrincipal returns the service principal of the user id
+// "clientid@tenantid", authenticated with the certificate and RSA key in
+// the PEM file certPath, if given, or else with the secret.
+func newServicePrincipal(userID, secret, certPath, authorityHost string) (*servicePrincipal, error) {
+	i := strings.LastIndex(userID, "@")
+	if i <= 0 || i == len(userID)-1 {
+		return nil, errors.New("azuread: the user id of a service principal must be clientid@tenantid")
+	}
+	if authorityHost == "" {
+		authorityHost = defaultAuthorityHost
+	}
+	sp := &servicePrincipal{
+		authorityHost: strings.TrimSuffix(authorityHost, "/") + "/",
+		clientID:      userID[:i],
+		tenantID:      userID[i+1:],
+		secret:        secret,
+	}
+	if certPath != "" {
+		data, err := ioutil.ReadFile(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("azuread: cannot read the service principal certificate: %v", err)
+		}
+		cert, err := tls.X509KeyPair(data, data)
+		if err != nil {
+			return nil, fmt.Errorf("azuread: invalid service principal certificate %s: %v", certPath, err)
+		}
+		if _, ok := cert.PrivateKey.(*rsa.PrivateKey); !ok {
+			return nil, errors.New("azuread: the service principal certificate must have an RSA key")
+		}
+		sp.cert = &cert
+	} else if secret == "" {
+		return nil, errors.New("azuread: a service principal requires a password or aadcertpath")
+	}
+	return sp, nil
+}
+
+func (sp *servicePrincipal) tokenEndpoint() string {
+	return sp.authorityHost + url.PathEscape(sp.tenantID) + "/oauth2/v2.0/token"
+}
+
+func (sp *servicePrincipal) token(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", sp.clientID)
+	form.Set("scope", sqlScope)
+	if sp.cert != nil {
+		assertion, err := sp.clientAssertion(time.Now())
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", assertion)
+	} else {
+		form.Set("client_secret", sp.secret)
+	}
+	r, err := postTokenRequest(ctx, servicePrincipalClient, sp.tokenEndpoint(), form)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return r.AccessToken, r.expiry(), nil
+}
+
+// clientAssertion returns a JWT signed with the certificate key, which
+// proves the identity of the application to Azure AD.
+func (sp *servicePrincipal) clientAssertion(now time.Time) (string, error) {
+	thumbprint := sha1.Sum(sp.cert.Certificate[0])
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+	var jti [16]byte
+	if _, err = rand.Read(jti[:]); err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": sp.tokenEndpoint(),
+		"iss": sp.clientID,
+		"sub": sp.clientID,
+		"jti": fmt.Sprintf("%x", jti),
+		"nbf": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sp.cert.PrivateKey.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// postTokenRequest posts a form to an Azure AD token endpoint.
+func postTokenRequest(ctx context.Context, client *http.Client, endpoint string, form url.Values) (*tokenResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return requestToken(client, req.WithContext(ctx))
+}
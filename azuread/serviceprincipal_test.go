@@ -0,0 +1,178 @@
+package azuread
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+// fakeAAD is a token endpoint of the tenant "tenant" that accepts the
+// client "app" with the secret "s3cret" or a JWT signed by cert.
+func fakeAAD(t *testing.T, cert *x509.Certificate) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fail := func(msg string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"error":"invalid_client","error_description":%q}`, msg)
+		}
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			fail("unexpected path " + r.URL.Path)
+			return
+		}
+		r.ParseForm()
+		f := r.PostForm
+		if f.Get("grant_type") != "client_credentials" || f.Get("client_id") != "app" || f.Get("scope") != sqlScope {
+			fail("unexpected request " + f.Encode())
+			return
+		}
+		if assertion := f.Get("client_assertion"); assertion != "" {
+			if err := verifyAssertion(assertion, cert, srv.URL+r.URL.Path); err != nil {
+				fail(err.Error())
+				return
+			}
+		} else if f.Get("client_secret") != "s3cret" {
+			fail("invalid secret")
+			return
+		}
+		fmt.Fprint(w, `{"token_type":"Bearer","expires_in":3599,"access_token":"sp-token"}`)
+	}))
+	return srv
+}
+
+func verifyAssertion(assertion string, cert *x509.Certificate, aud string) error {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed assertion")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+		return err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Iss string `json:"iss"`
+		Exp int64  `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+	if claims.Aud != aud || claims.Iss != "app" || claims.Exp < time.Now().Unix() {
+		return fmt.Errorf("unexpected claims %s", payload)
+	}
+	return nil
+}
+
+// writeRSACertificate writes a self-signed certificate and its key to a
+// PEM file.
+func writeRSACertificate(t *testing.T, dir string) (string, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "app"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+	path := filepath.Join(dir, "app.pem")
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, cert
+}
+
+func TestServicePrincipal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azuread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, cert := writeRSACertificate(t, dir)
+	aad := fakeAAD(t, cert)
+	defer aad.Close()
+
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.RowsAffected(0)}
+	})
+	defer srv.Close()
+	srv.Authenticate = func(login *mssqltest.Login) error {
+		if login.AccessToken != "sp-token" || login.Password != "" {
+			return fmt.Errorf("unexpected token %q", login.AccessToken)
+		}
+		return nil
+	}
+	base := srv.DSN() + "&fedauth=ActiveDirectoryServicePrincipal&user+id=app%40tenant&authorityhost=" + url.QueryEscape(aad.URL)
+	for _, c := range []struct {
+		name string
+		dsn  string
+		ok   bool
+	}{
+		{"secret", base + "&password=s3cret", true},
+		{"wrong secret", base + "&password=wrong", false},
+		{"certificate", base + "&aadcertpath=" + url.QueryEscape(certPath), true},
+	} {
+		db, err := sql.Open(DriverName, c.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Ping()
+		db.Close()
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.ok && (err == nil || !strings.Contains(err.Error(), "invalid_client")) {
+			t.Errorf("%s: expected a token error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestServicePrincipalErrors(t *testing.T) {
+	for _, dsn := range []string{
+		"sqlserver://db?fedauth=ActiveDirectoryServicePrincipal&user+id=app&password=x",
+		"sqlserver://db?fedauth=ActiveDirectoryServicePrincipal&user+id=app%40tenant",
+		"sqlserver://db?fedauth=ActiveDirectoryServicePrincipal&user+id=app%40tenant&aadcertpath=/nonexistent.pem",
+	} {
+		if _, err := NewConnector(dsn); err == nil {
+			t.Errorf("expected an error for %s", dsn)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package azuread
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshBefore is how long before its expiry a cached token is replaced.
+const refreshBefore = 5 * time.Minute
+
+// tokenSource acquires new access tokens for Azure SQL.
+type tokenSource interface {
+	token(ctx context.Context) (accessToken string, expires time.Time, err error)
+}
+
+// tokenCache reuses the token of a source until shortly before it expires.
+type tokenCache struct {
+	source tokenSource
+
+	mu      sync.Mutex
+	cached  string
+	expires time.Time
+}
+
+func (c *tokenCache) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached != "" && time.Until(c.expires) > refreshBefore {
+		return c.cached, nil
+	}
+	token, expires, err := c.source.token(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.cached, c.expires = token, expires
+	return token, nil
+}
+
+// tokenResponse is the token response of Azure AD and the managed
+// identity endpoints.
+type tokenResponse struct {
+	AccessToken      string
+	RefreshToken     string
+	ExpiresIn        string
+	ExpiresOn        string
+	Error            string
+	ErrorDescription string
+
+	// issued is when the token was requested.
+	issued time.Time
+}
+
+// UnmarshalJSON accepts the numbers as JSON numbers or, as the managed
+// identity endpoints send them, as strings.
+func (r *tokenResponse) UnmarshalJSON(b []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	str := func(key string) string {
+		switch v := raw[key].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return ""
+	}
+	r.AccessToken = str("access_token")
+	r.RefreshToken = str("refresh_token")
+	r.ExpiresIn = str("expires_in")
+	r.ExpiresOn = str("expires_on")
+	r.Error = str("error")
+	r.ErrorDescription = str("error_description")
+	return nil
+}
+
+// expiry returns when the token expires, by default after an hour.
+func (r *tokenResponse) expiry() time.Time {
+	if on, err := strconv.ParseInt(r.ExpiresOn, 10, 64); err == nil {
+		return time.Unix(on, 0)
+	}
+	if in, err := strconv.ParseInt(r.ExpiresIn, 10, 64); err == nil {
+		return r.issued.Add(time.Duration(in) * time.Second)
+	}
+	return r.issued.Add(time.Hour)
+}
+
+// tokenError is an OAuth 2.0 error returned by a token endpoint.
+type tokenError struct {
+	Code        string
+	Description string
+}
+
+func (e *tokenError) Error() string {
+	return fmt.Sprintf("azuread: token request failed with %s: %s", e.Code, e.Description)
+}
+
+// requestToken sends a token request and decodes the response. Errors
+// reported by the endpoint are returned as *tokenError.
+func requestToken(client *http.Client, req *http.Request) (*tokenResponse, error) {
+	r := &tokenResponse{issued: time.Now()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("azuread: token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("azuread: token request failed: %v", err)
+	}
+	if err = json.Unmarshal(body, r); err != nil {
+		return nil, fmt.Errorf("azuread: invalid token response with status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if r.Error != "" {
+		return nil, &tokenError{Code: r.Error, Description: r.ErrorDescription}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("azuread: token request failed with status %s", resp.Status)
+	}
+	return r, nil
+}
@@ -0,0 +1,397 @@
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupType selects the kind of backup made by BackupDatabase.
+type BackupType int
+
+const (
+	FullBackup BackupType = iota
+	DifferentialBackup
+	LogBackup
+)
+
+// BackupProgress is an informational message of a running backup or
+// restore.
+type BackupProgress struct {
+	// Percent is set by the "n percent processed" messages and is -1 for
+	// other messages.
+	Percent int
+	// Number is the message number, e.g. 3211 for progress and 3014 for
+	// the completion message.
+	Number  int32
+	Message string
+}
+
+// BackupOptions describes a backup.
+type BackupOptions struct {
+	Database string
+	Type     BackupType
+	// To lists the backup devices. Names starting with https:// are backed
+	// up to URL, others to DISK.
+	To []string
+	// Init overwrites the backup sets of the devices, they are appended to
+	// otherwise.
+	Init        bool
+	CopyOnly    bool
+	Compression bool
+	Checksum    bool
+	// Stats is the percentage interval of progress messages, it defaults
+	// to 10.
+	Stats int
+	// Progress receives the informational messages of the backup.
+	Progress func(BackupProgress)
+	// Timeout bounds the backup, in addition to the context. Zero means no
+	// limit, backups of large databases routinely take hours.
+	Timeout time.Duration
+}
+
+// RestoreOptions describes a restore.
+type RestoreOptions struct {
+	Database string
+	From     []string
+	// File is the position of the backup set on the device, the first
+	// one is used when zero.
+	File int
+	// Log restores a transaction log backup instead of a database backup.
+	Log bool
+	// NoRecovery leaves the database restoring, to apply more backups.
+	NoRecovery bool
+	Replace    bool
+	Checksum   bool
+	// Move relocates the database files.
+	Move []FileMove
+	// Stats is the percentage interval of progress messages, it defaults
+	// to 10.
+	Stats    int
+	Progress func(BackupProgress)
+	Timeout  time.Duration
+}
+
+// FileMove relocates a database file on restore.
+type FileMove struct {
+	LogicalName  string
+	PhysicalName string
+}
+
+// BackupHeader is a backup set as returned by RESTORE HEADERONLY.
+type BackupHeader struct {
+	BackupName        string
+	BackupDescription string
+	// BackupType is 1 for database, 2 for log and 5 for differential
+	// backups.
+	BackupType           int
+	Position             int
+	DatabaseName         string
+	ServerName           string
+	UserName             string
+	RecoveryModel        string
+	BackupSize           int64
+	CompressedBackupSize int64
+	Compressed           bool
+	CopyOnly             bool
+	FirstLSN             string
+	LastLSN              string
+	CheckpointLSN        string
+	DatabaseBackupLSN    string
+	BackupStartDate      time.Time
+	BackupFinishDate     time.Time
+}
+
+// BackupFile is a database file in a backup set as returned by RESTORE
+// FILELISTONLY.
+type BackupFile struct {
+	LogicalName  string
+	PhysicalName string
+	// Type is "D" for data, "L" for log, "F" for full text catalog and
+	// "S" for FILESTREAM files.
+	Type          string
+	FileGroupName string
+	FileID        int64
+	Size          int64
+}
+
+var percentRe = regexp.MustCompile(`^\s*(\d+) percent processed`)
+
+func backupContext(ctx context.Context, timeout time.Duration, progress func(BackupProgress)) (context.Context, context.CancelFunc) {
+	cancel := func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	if progress != nil {
+		ctx = withMessageFunc(ctx, func(e Error) {
+			p := BackupProgress{Percent: -1, Number: e.Number, Message: e.Message}
+			if m := percentRe.FindStringSubmatch(e.Message); m != nil {
+				p.Percent, _ = strconv.Atoi(m[1])
+			}
+			progress(p)
+		})
+	}
+	return ctx, cancel
+}
+
+func appendDevices(b *strings.Builder, args []interface{}, devices []string) []interface{} {
+	for i, d := range devices {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		kind := "DISK"
+		if strings.HasPrefix(strings.ToLower(d), "https://") {
+			kind = "URL"
+		}
+		args = append(args, d)
+		fmt.Fprintf(b, "%s = @p%d", kind, len(args))
+	}
+	return args
+}
+
+func statsOption(stats int) string {
+	if stats <= 0 {
+		stats = 10
+	}
+	return fmt.Sprintf("STATS = %d", stats)
+}
+
+// BackupDatabase backs up a database. Progress messages are passed to
+// opts.Progress as they arrive. Cancelling the context aborts the backup
+// on the server.
+//
+// The "connection timeout" of the connection string limits the time
+// between two reads, a small Stats interval keeps messages flowing during
+// long backups.
+func BackupDatabase(ctx context.Context, db *sql.DB, opts BackupOptions) error {
+	if opts.Database == "" || len(opts.To) == 0 {
+		return errors.New("mssql: a database and a backup device are required")
+	}
+	var b strings.Builder
+	args := []interface{}{opts.Database}
+	if opts.Type == LogBackup {
+		b.WriteString("BACKUP LOG @p1 TO ")
+	} else {
+		b.WriteString("BACKUP DATABASE @p1 TO ")
+	}
+	args = appendDevices(&b, args, opts.To)
+	with := []string{statsOption(opts.Stats)}
+	if opts.Type == DifferentialBackup {
+		with = append(with, "DIFFERENTIAL")
+	}
+	if opts.Init {
+		with = append(with, "INIT")
+	}
+	if opts.CopyOnly {
+		with = append(with, "COPY_ONLY")
+	}
+	if opts.Compression {
+		with = append(with, "COMPRESSION")
+	}
+	if opts.Checksum {
+		with = append(with, "CHECKSUM")
+	}
+	b.WriteString(" WITH ")
+	b.WriteString(strings.Join(with, ", "))
+
+	ctx, cancel := backupContext(ctx, opts.Timeout, opts.Progress)
+	defer cancel()
+	_, err := db.ExecContext(ctx, b.String(), args...)
+	return err
+}
+
+// RestoreDatabase restores a database or log backup.
+func RestoreDatabase(ctx context.Context, db *sql.DB, opts RestoreOptions) error {
+	if opts.Database == "" || len(opts.From) == 0 {
+		return errors.New("mssql: a database and a backup device are required")
+	}
+	var b strings.Builder
+	args := []interface{}{opts.Database}
+	if opts.Log {
+		b.WriteString("RESTORE LOG @p1 FROM ")
+	} else {
+		b.WriteString("RESTORE DATABASE @p1 FROM ")
+	}
+	args = appendDevices(&b, args, opts.From)
+	with := []string{statsOption(opts.Stats)}
+	if opts.File > 0 {
+		args = append(args, opts.File)
+		with = append(with, fmt.Sprintf("FILE = @p%d", len(args)))
+	}
+	for _, m := range opts.Move {
+		args = append(args, m.LogicalName, m.PhysicalName)
+		with = append(with, fmt.Sprintf("MOVE @p%d TO @p%d", len(args)-1, len(args)))
+	}
+	if opts.NoRecovery {
+		with = append(with, "NORECOVERY")
+	} else {
+		with = append(with, "RECOVERY")
+	}
+	if opts.Replace {
+		with = append(with, "REPLACE")
+	}
+	if opts.Checksum {
+		with = append(with, "CHECKSUM")
+	}
+	b.WriteString(" WITH ")
+	b.WriteString(strings.Join(with, ", "))
+
+	ctx, cancel := backupContext(ctx, opts.Timeout, opts.Progress)
+	defer cancel()
+	_, err := db.ExecContext(ctx, b.String(), args...)
+	return err
+}
+
+// VerifyBackup checks that a backup set is complete and readable with
+// RESTORE VERIFYONLY. A file of zero verifies the first backup set.
+func VerifyBackup(ctx context.Context, db *sql.DB, from []string, file int, progress func(BackupProgress)) error {
+	query, args := restoreOnly("VERIFYONLY", from, file)
+	ctx, cancel := backupContext(ctx, 0, progress)
+	defer cancel()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func restoreOnly(kind string, from []string, file int) (string, []interface{}) {
+	var b strings.Builder
+	b.WriteString("RESTORE ")
+	b.WriteString(kind)
+	b.WriteString(" FROM ")
+	args := appendDevices(&b, nil, from)
+	if file > 0 {
+		args = append(args, file)
+		fmt.Fprintf(&b, " WITH FILE = @p%d", len(args))
+	}
+	return b.String(), args
+}
+
+// ReadBackupHeaders lists the backup sets of the devices with RESTORE
+// HEADERONLY.
+func ReadBackupHeaders(ctx context.Context, db *sql.DB, from []string) ([]BackupHeader, error) {
+	query, args := restoreOnly("HEADERONLY", from, 0)
+	rows, err := queryMaps(ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]BackupHeader, len(rows))
+	for i, r := range rows {
+		res[i] = BackupHeader{
+			BackupName:           r.str("BackupName"),
+			BackupDescription:    r.str("BackupDescription"),
+			BackupType:           int(r.int("BackupType")),
+			Position:             int(r.int("Position")),
+			DatabaseName:         r.str("DatabaseName"),
+			ServerName:           r.str("ServerName"),
+			UserName:             r.str("UserName"),
+			RecoveryModel:        r.str("RecoveryModel"),
+			BackupSize:           r.int("BackupSize"),
+			CompressedBackupSize: r.int("CompressedBackupSize"),
+			Compressed:           r.int("Compressed") != 0,
+			CopyOnly:             r.int("IsCopyOnly") != 0,
+			FirstLSN:             r.str("FirstLSN"),
+			LastLSN:              r.str("LastLSN"),
+			CheckpointLSN:        r.str("CheckpointLSN"),
+			DatabaseBackupLSN:    r.str("DatabaseBackupLSN"),
+			BackupStartDate:      r.time("BackupStartDate"),
+			BackupFinishDate:     r.time("BackupFinishDate"),
+		}
+	}
+	return res, nil
+}
+
+// ReadBackupFiles lists the database files of a backup set with RESTORE
+// FILELISTONLY. A file of zero reads the first backup set.
+func ReadBackupFiles(ctx context.Context, db *sql.DB, from []string, file int) ([]BackupFile, error) {
+	query, args := restoreOnly("FILELISTONLY", from, file)
+	rows, err := queryMaps(ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]BackupFile, len(rows))
+	for i, r := range rows {
+		res[i] = BackupFile{
+			LogicalName:   r.str("LogicalName"),
+			PhysicalName:  r.str("PhysicalName"),
+			Type:          r.str("Type"),
+			FileGroupName: r.str("FileGroupName"),
+			FileID:        r.int("FileID"),
+			Size:          r.int("Size"),
+		}
+	}
+	return res, nil
+}
+
+// rowMap is a row by column name.
+type rowMap map[string]interface{}
+
+func queryMaps(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]rowMap, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var res []rowMap
+	vals := make([]interface{}, len(cols))
+	for rows.Next() {
+		for i := range vals {
+			vals[i] = new(interface{})
+		}
+		if err = rows.Scan(vals...); err != nil {
+			return nil, err
+		}
+		m := make(rowMap, len(cols))
+		for i, c := range cols {
+			m[c] = *(vals[i].(*interface{}))
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+func (r rowMap) str(name string) string {
+	switch v := r[name].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// int converts integer, bit and numeric columns.
+func (r rowMap) int(name string) int64 {
+	switch v := r[name].(type) {
+	case int64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	case float64:
+		return int64(v)
+	case []byte:
+		n, _ := strconv.ParseInt(string(v), 10, 64)
+		return n
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+func (r rowMap) time(name string) time.Time {
+	t, _ := r[name].(time.Time)
+	return t
+}
@@ -0,0 +1,123 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestBackupDatabaseProgress(t *testing.T) {
+	db, srv := openTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{
+			mssqltest.Message{Number: 3211, Class: 10, Message: "10 percent processed."},
+			mssqltest.Message{Number: 3211, Class: 10, Message: "100 percent processed."},
+			mssqltest.Message{Number: 3014, Class: 10, Message: "BACKUP DATABASE successfully processed 338 pages in 0.123 seconds (21.440 MB/sec)."},
+			mssqltest.RowsAffected(0),
+		}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	var progress []BackupProgress
+	err := BackupDatabase(context.Background(), db, BackupOptions{
+		Database:    "sales",
+		To:          []string{`C:\backup\sales.bak`, "https://acct.blob.core.windows.net/c/sales.bak"},
+		Type:        DifferentialBackup,
+		Compression: true,
+		Stats:       5,
+		Progress:    func(p BackupProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	req := reqs[len(reqs)-1]
+	want := "BACKUP DATABASE @p1 TO DISK = @p2, URL = @p3 WITH STATS = 5, DIFFERENTIAL, COMPRESSION"
+	if req.SQL != want {
+		t.Errorf("got %q, want %q", req.SQL, want)
+	}
+	if len(progress) != 3 || progress[0].Percent != 10 || progress[1].Percent != 100 ||
+		progress[2].Percent != -1 || progress[2].Number != 3014 {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+
+	// queries without a callback are unaffected
+	if _, err = db.Exec("select 1 from t"); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != 3 {
+		t.Error("messages of another query were reported")
+	}
+}
+
+func TestRestoreDatabase(t *testing.T) {
+	db, srv := openTestServer(t, nil)
+	defer srv.Close()
+	defer db.Close()
+	err := RestoreDatabase(context.Background(), db, RestoreOptions{
+		Database:   "sales_copy",
+		From:       []string{"sales.bak"},
+		File:       2,
+		Move:       []FileMove{{"sales", "sales_copy.mdf"}, {"sales_log", "sales_copy.ldf"}},
+		NoRecovery: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	req := reqs[len(reqs)-1]
+	want := "RESTORE DATABASE @p1 FROM DISK = @p2 WITH STATS = 10, FILE = @p3, MOVE @p4 TO @p5, MOVE @p6 TO @p7, NORECOVERY"
+	if req.SQL != want {
+		t.Errorf("got %q, want %q", req.SQL, want)
+	}
+	if len(req.Params) != 7 || req.Params[5].Value != "sales_log" {
+		t.Errorf("unexpected parameters %+v", req.Params)
+	}
+}
+
+func TestReadBackupHeaders(t *testing.T) {
+	start := time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)
+	db, srv := openTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		if !strings.HasPrefix(req.SQL, "RESTORE HEADERONLY FROM DISK = @p1") {
+			return []mssqltest.Response{mssqltest.Error{Number: 102, Class: 15, Message: "unexpected " + req.SQL}}
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "BackupName", Type: mssqltest.NVarChar},
+				{Name: "BackupType", Type: mssqltest.Int},
+				{Name: "Position", Type: mssqltest.Int},
+				{Name: "DatabaseName", Type: mssqltest.NVarChar},
+				{Name: "BackupSize", Type: mssqltest.BigInt},
+				{Name: "Compressed", Type: mssqltest.Bit},
+				{Name: "BackupStartDate", Type: mssqltest.DateTime2},
+			},
+			Rows: [][]interface{}{
+				{"full", int64(1), int64(1), "sales", int64(2048), true, start},
+				{"log", int64(2), int64(2), "sales", int64(512), false, start},
+			},
+		}}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	headers, err := ReadBackupHeaders(context.Background(), db, []string{"sales.bak"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("got %d headers", len(headers))
+	}
+	h := headers[0]
+	if h.BackupName != "full" || h.BackupType != 1 || h.DatabaseName != "sales" || h.BackupSize != 2048 ||
+		!h.Compressed || !h.BackupStartDate.Equal(start) {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if headers[1].Position != 2 || headers[1].Compressed {
+		t.Errorf("unexpected header %+v", headers[1])
+	}
+}
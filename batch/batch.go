@@ -2,8 +2,12 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// bach splits a single script containing multiple batches separated by
-// a keyword into multiple scripts.
+// Package batch splits a script containing multiple batches separated by
+// a keyword, such as the GO command of sqlcmd, into multiple scripts.
+//
+// The separator is recognized on a line of its own, optionally followed by
+// a repeat count, as in "GO 5", and a line comment. Separators in strings,
+// quoted identifiers and comments, which may nest, do not split the script.
 package batch
 
 import (
@@ -21,6 +25,32 @@ func Split(sql, separator string) []string {
 	if len(separator) == 0 || len(sql) < len(separator) {
 		return []string{sql}
 	}
+	return split(sql, separator).Batch
+}
+
+// Batch is a batch of a script.
+type Batch struct {
+	Text string
+	// Line is the line of the script the batch starts on, counted from 1.
+	// Line numbers the server reports for the batch are relative to it.
+	Line int
+}
+
+// SplitLines is like Split but also returns the line each batch starts
+// on, so that errors can be traced back to the script.
+func SplitLines(sql, separator string) []Batch {
+	if len(separator) == 0 || len(sql) < len(separator) {
+		return []Batch{{Text: sql, Line: 1}}
+	}
+	l := split(sql, separator)
+	res := make([]Batch, len(l.Batch))
+	for i, text := range l.Batch {
+		res[i] = Batch{Text: text, Line: l.Lines[i]}
+	}
+	return res
+}
+
+func split(sql, separator string) *lexer {
 	l := &lexer{
 		Sql: sql,
 		Sep: separator,
@@ -31,7 +61,7 @@ func Split(sql, separator string) []string {
 		state = state(l)
 	}
 	l.AddCurrent(1)
-	return l.Batch
+	return l
 }
 
 const debugPrintStateName = false
@@ -58,13 +88,15 @@ type lexer struct {
 	Skip []int
 
 	Batch []string
+	Lines []int
 }
 
-func (l *lexer) Add(b string) {
+func (l *lexer) Add(b string, line int) {
 	if len(b) == 0 {
 		return
 	}
 	l.Batch = append(l.Batch, b)
+	l.Lines = append(l.Lines, line)
 }
 
 func (l *lexer) Next() bool {
@@ -85,7 +117,7 @@ func (l *lexer) AddCurrent(count int64) bool {
 		nextSkipIndex := 0
 		nextSkip := l.Skip[nextSkipIndex]
 		for i, r := range text {
-			if i == nextSkip {
+			if l.Start+i == nextSkip {
 				nextSkipIndex++
 				if nextSkipIndex < len(l.Skip) {
 					nextSkip = l.Skip[nextSkipIndex]
@@ -101,8 +133,9 @@ func (l *lexer) AddCurrent(count int64) bool {
 	if count > 1000 {
 		count = 1000
 	}
+	line := 1 + strings.Count(l.Sql[:l.Start], "\n")
 	for i := int64(0); i < count; i++ {
-		l.Add(text)
+		l.Add(text, line)
 	}
 	l.At += len(l.Sep)
 	l.Start = l.At
@@ -117,65 +150,44 @@ const (
 	rightComment = "*/"
 )
 
+// stateSep is entered at a separator word at the start of a line. It is a
+// separator when the rest of the line is blank, a line comment, or a repeat
+// count optionally followed by a line comment, as in "GO 5 -- five times".
 func stateSep(l *lexer) stateFn {
 	printStateName("sep", l)
-	if l.At+len(l.Sep) >= len(l.Sql) {
-		return nil
+	rest := l.Sql[l.At+len(l.Sep):]
+	end := strings.IndexAny(rest, "\r\n")
+	if end < 0 {
+		end = len(rest)
 	}
-	s := l.Sql[l.At+len(l.Sep):]
-
-	parseNumberStart := -1
-loop:
-	for i, r := range s {
-		switch {
-		case r == '\n', r == '\r':
-			l.AddCurrent(1)
-			return stateWhitespace
-		case unicode.IsSpace(r):
-		case unicode.IsNumber(r):
-			parseNumberStart = i
-			break loop
-		}
-	}
-	if parseNumberStart < 0 {
-		return nil
+	line := rest[:end]
+	if i := strings.Index(line, lineComment); i >= 0 {
+		line = line[:i]
 	}
-
-	parseNumberCount := 0
-numLoop:
-	for i, r := range s[parseNumberStart:] {
-		switch {
-		case unicode.IsNumber(r):
-			parseNumberCount = i
-		default:
-			break numLoop
+	count := int64(1)
+	if arg := strings.TrimSpace(line); arg != "" {
+		// the separator is a word of its own, unlike in GOTO
+		if !unicode.IsSpace(rune(line[0])) || strings.TrimLeft(arg, "0123456789") != "" {
+			return stateText
 		}
-	}
-	parseNumberEnd := parseNumberStart + parseNumberCount + 1
-
-	count, err := strconv.ParseInt(s[parseNumberStart:parseNumberEnd], 10, 64)
-	if err != nil {
-		return stateText
-	}
-	for _, r := range s[parseNumberEnd:] {
-		switch {
-		case r == '\n', r == '\r':
-			l.AddCurrent(count)
-			l.At += parseNumberEnd
-			l.Start = l.At
-			return stateWhitespace
-		case unicode.IsSpace(r):
-		default:
+		var err error
+		if count, err = strconv.ParseInt(arg, 10, 64); err != nil {
 			return stateText
 		}
 	}
-
-	return nil
+	l.AddCurrent(count)
+	// the end of the line starts the next batch
+	l.At += end
+	l.Start = l.At
+	return stateWhitespace
 }
 
 func stateText(l *lexer) stateFn {
 	printStateName("text", l)
 	for {
+		if l.At >= len(l.Sql) {
+			return nil
+		}
 		ch := l.Sql[l.At]
 
 		switch {
@@ -188,6 +200,12 @@ func stateText(l *lexer) stateFn {
 		case ch == '\'':
 			l.At += 1
 			return stateString
+		case ch == '"':
+			l.At += 1
+			return stateQuoted('"')
+		case ch == '[':
+			l.At += 1
+			return stateQuoted(']')
 		case ch == '\r', ch == '\n':
 			l.At += 1
 			return stateWhitespace
@@ -237,13 +255,23 @@ func stateLineComment(l *lexer) stateFn {
 	}
 }
 
+// stateMultiComment is entered inside a block comment, which may nest
+// other block comments.
 func stateMultiComment(l *lexer) stateFn {
 	printStateName("multi-line-comment", l)
+	depth := 1
 	for {
 		switch {
 		case strings.HasPrefix(l.Sql[l.At:], rightComment):
+			l.At += len(rightComment)
+			depth--
+			if depth == 0 {
+				// a separator must start its line
+				return stateText
+			}
+		case strings.HasPrefix(l.Sql[l.At:], leftComment):
 			l.At += len(leftComment)
-			return stateWhitespace
+			depth++
 		default:
 			if l.Next() == false {
 				return nil
@@ -252,6 +280,28 @@ func stateMultiComment(l *lexer) stateFn {
 	}
 }
 
+// stateQuoted returns the state inside a quoted identifier that ends with
+// end, which is escaped by doubling it.
+func stateQuoted(end byte) stateFn {
+	return func(l *lexer) stateFn {
+		printStateName("quoted-identifier", l)
+		for {
+			if l.At >= len(l.Sql) {
+				return nil
+			}
+			switch {
+			case l.Sql[l.At] != end:
+				l.At++
+			case l.At+1 < len(l.Sql) && l.Sql[l.At+1] == end:
+				l.At += 2
+			default:
+				l.At++
+				return stateText
+			}
+		}
+	}
+}
+
 func stateString(l *lexer) stateFn {
 	printStateName("string", l)
 	for {
@@ -277,7 +327,7 @@ func stateString(l *lexer) stateFn {
 			l.At += 2
 		case ch == '\'' && chNext != '\'':
 			l.At += 1
-			return stateWhitespace
+			return stateText
 		default:
 			if l.Next() == false {
 				return nil
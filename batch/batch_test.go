@@ -72,12 +72,19 @@ select top 1 1`,
 		testItem{Sql: "--", Expect: []string{"--"}},
 		testItem{Sql: "GO", Expect: nil},
 		testItem{Sql: "/*", Expect: []string{"/*"}},
-		testItem{Sql: "gO\x01\x00O550655490663051008\n", Expect: []string{"\n"}},
+		testItem{Sql: "gO\x01\x00O550655490663051008\n", Expect: []string{"gO\x01\x00O550655490663051008\n"}},
 		testItem{Sql: "select 1;\nGO  2\nselect 2;", Expect: []string{"select 1;\n", "select 1;\n", "\nselect 2;"}},
 		testItem{Sql: "select 'hi\\\n-hello';", Expect: []string{"select 'hi-hello';"}},
 		testItem{Sql: "select 'hi\\\r\n-hello';", Expect: []string{"select 'hi-hello';"}},
 		testItem{Sql: "select 'hi\\\r-hello';", Expect: []string{"select 'hi-hello';"}},
 		testItem{Sql: "select 'hi\\\n\nhello';", Expect: []string{"select 'hi\nhello';"}},
+		testItem{Sql: "goto done\ngo", Expect: []string{"goto done\n"}},
+		testItem{Sql: "select 1\nGO -- first\nselect 2\nGO 2 -- twice\n", Expect: []string{"select 1\n", "\nselect 2\n", "\nselect 2\n", "\n"}},
+		testItem{Sql: "select 1\ngo 2x\n", Expect: []string{"select 1\ngo 2x\n"}},
+		testItem{Sql: "select 'a' go\nselect 1 /* b */ go\n", Expect: []string{"select 'a' go\nselect 1 /* b */ go\n"}},
+		testItem{Sql: "/* /* nested */\ngo\n*/\ngo\nselect 1", Expect: []string{"/* /* nested */\ngo\n*/\n", "\nselect 1"}},
+		testItem{Sql: "select [a\ngo\n]]], \"b\ngo\n\"\"\"\ngo\nselect 1", Expect: []string{"select [a\ngo\n]]], \"b\ngo\n\"\"\"\n", "\nselect 1"}},
+		testItem{Sql: "select 1\ngo\nselect 'a\\\nb'", Expect: []string{"select 1\n", "\nselect 'ab'"}},
 	}
 
 	index := -1
@@ -118,3 +125,22 @@ func TestHasPrefixFold(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitLines(t *testing.T) {
+	sql := "use DB\ngo\n\nselect 1\nselect 2\nGO 2\nselect 3"
+	got := SplitLines(sql, "go")
+	want := []Batch{
+		{Text: "use DB\n", Line: 1},
+		{Text: "\n\nselect 1\nselect 2\n", Line: 2},
+		{Text: "\n\nselect 1\nselect 2\n", Line: 2},
+		{Text: "\nselect 3", Line: 6},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expect %d batches, got %d %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("batch index %d; expect %q, got %q", i, want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Package broker sends and receives Service Broker messages.
+//
+// The functions take a Querier, which is satisfied by *sql.DB, *sql.Conn and
+// *sql.Tx. Receiving inside a transaction and committing once the messages
+// were processed makes consumption reliable, the messages return to the
+// queue when the transaction rolls back:
+//
+//	tx, err := db.BeginTx(ctx, nil)
+//	...
+//	msgs, err := broker.Receive(ctx, tx, "dbo.OrderQueue", broker.ReceiveOptions{Max: 10})
+//	...
+//	for _, m := range msgs {
+//		var order Order
+//		if err := m.DecodeJSON(&order); err != nil {
+//			...
+//		}
+//	}
+//	err = tx.Commit()
+package broker
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// Querier runs statements, it is implemented by *sql.DB, *sql.Conn and
+// *sql.Tx.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Message types defined by SQL Server.
+const (
+	DefaultMessageType   = "DEFAULT"
+	EndDialogMessageType = "http://schemas.microsoft.com/SQL/ServiceBroker/EndDialog"
+	ErrorMessageType     = "http://schemas.microsoft.com/SQL/ServiceBroker/Error"
+	DialogTimerType      = "http://schemas.microsoft.com/SQL/ServiceBroker/DialogTimer"
+)
+
+// DialogOptions describes the conversation started by BeginDialog.
+type DialogOptions struct {
+	// FromService is the initiating service.
+	FromService string
+	// ToService is the target service.
+	ToService string
+	// BrokerInstance optionally selects the broker of the target service.
+	BrokerInstance string
+	// Contract is the contract of the conversation, the DEFAULT contract is
+	// used when empty.
+	Contract string
+	// Lifetime is the maximum lifetime of the dialog, zero leaves it
+	// unlimited.
+	Lifetime time.Duration
+	// Encryption requires the messages to be encrypted when they leave the
+	// instance.
+	Encryption bool
+	// RelatedConversation adds the dialog to the conversation group of
+	// the given conversation.
+	RelatedConversation *mssql.UniqueIdentifier
+}
+
+// BeginDialog starts a conversation and returns its handle.
+func BeginDialog(ctx context.Context, q Querier, opts DialogOptions) (mssql.UniqueIdentifier, error) {
+	var handle mssql.UniqueIdentifier
+	if opts.FromService == "" || opts.ToService == "" {
+		return handle, errors.New("broker: both services of a dialog are required")
+	}
+	var b strings.Builder
+	args := []interface{}{sql.Named("to", opts.ToService)}
+	b.WriteString("DECLARE @h UNIQUEIDENTIFIER; BEGIN DIALOG @h FROM SERVICE ")
+	b.WriteString(quoteName(opts.FromService))
+	b.WriteString(" TO SERVICE @to")
+	if opts.BrokerInstance != "" {
+		b.WriteString(", @broker")
+		args = append(args, sql.Named("broker", opts.BrokerInstance))
+	}
+	if opts.Contract != "" {
+		b.WriteString(" ON CONTRACT ")
+		b.WriteString(quoteName(opts.Contract))
+	}
+	if opts.Encryption {
+		b.WriteString(" WITH ENCRYPTION = ON")
+	} else {
+		b.WriteString(" WITH ENCRYPTION = OFF")
+	}
+	if opts.RelatedConversation != nil {
+		b.WriteString(", RELATED_CONVERSATION = @related")
+		args = append(args, sql.Named("related", *opts.RelatedConversation))
+	}
+	if opts.Lifetime > 0 {
+		b.WriteString(", LIFETIME = @lifetime")
+		secs := int64((opts.Lifetime + time.Second - 1) / time.Second)
+		args = append(args, sql.Named("lifetime", secs))
+	}
+	b.WriteString("; SELECT @h")
+	rows, err := q.QueryContext(ctx, b.String(), args...)
+	if err != nil {
+		return handle, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = errors.New("broker: BEGIN DIALOG returned no handle")
+		}
+		return handle, err
+	}
+	if err = rows.Scan(&handle); err != nil {
+		return handle, err
+	}
+	return handle, rows.Close()
+}
+
+// Send sends body on the conversation. An empty messageType sends a
+// message of the DEFAULT type.
+func Send(ctx context.Context, q Querier, handle mssql.UniqueIdentifier, messageType string, body []byte) error {
+	query := "SEND ON CONVERSATION @p1 (@p2)"
+	if messageType != "" {
+		query = "SEND ON CONVERSATION @p1 MESSAGE TYPE " + quoteName(messageType) + " (@p2)"
+	}
+	if body == nil {
+		// a nil slice would be sent as NULL, which is a message without a body
+		body = []byte{}
+	}
+	_, err := q.ExecContext(ctx, query, handle, body)
+	return err
+}
+
+// SendJSON sends v encoded as JSON.
+func SendJSON(ctx context.Context, q Querier, handle mssql.UniqueIdentifier, messageType string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return Send(ctx, q, handle, messageType, body)
+}
+
+// SendXML sends v encoded as XML.
+func SendXML(ctx context.Context, q Querier, handle mssql.UniqueIdentifier, messageType string, v interface{}) error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return Send(ctx, q, handle, messageType, body)
+}
+
+// EndConversation ends the conversation.
+func EndConversation(ctx context.Context, q Querier, handle mssql.UniqueIdentifier) error {
+	_, err := q.ExecContext(ctx, "END CONVERSATION @p1", handle)
+	return err
+}
+
+// EndConversationWithError ends the conversation and sends an error
+// message to the remote service. The code must be positive.
+func EndConversationWithError(ctx context.Context, q Querier, handle mssql.UniqueIdentifier, code int, description string) error {
+	_, err := q.ExecContext(ctx, "END CONVERSATION @p1 WITH ERROR = @p2 DESCRIPTION = @p3", handle, code, description)
+	return err
+}
+
+// ReceiveOptions controls Receive.
+type ReceiveOptions struct {
+	// Max is the most messages returned, it defaults to one.
+	Max int
+	// Conversation restricts the messages to one conversation.
+	Conversation *mssql.UniqueIdentifier
+	// Timeout is how long to wait for a message. When it elapses Receive
+	// returns no messages and no error. Zero waits until a message arrives
+	// or the context is done, a negative value does not wait at all.
+	Timeout time.Duration
+}
+
+// Message is a message received from a queue.
+type Message struct {
+	ConversationHandle  mssql.UniqueIdentifier
+	ConversationGroupID mssql.UniqueIdentifier
+	MessageType         string
+	ServiceName         string
+	ContractName        string
+	SequenceNumber      int64
+	Body                []byte
+}
+
+// IsEndDialog reports whether the remote service ended the conversation.
+func (m *Message) IsEndDialog() bool {
+	return m.MessageType == EndDialogMessageType
+}
+
+// IsError reports whether the message reports a conversation error, see
+// Err for its details.
+func (m *Message) IsError() bool {
+	return m.MessageType == ErrorMessageType
+}
+
+// DecodeJSON decodes the body as JSON into v.
+func (m *Message) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(m.Body, v)
+}
+
+// DecodeXML decodes the body as XML into v. Bodies validated as XML by the
+// server are stored as UTF-16 and are decoded as well.
+func (m *Message) DecodeXML(v interface{}) error {
+	return xml.Unmarshal(xmlBody(m.Body), v)
+}
+
+// Error is the content of a Service Broker error message.
+type Error struct {
+	Code        int
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("broker: conversation error %d: %s", e.Code, e.Description)
+}
+
+// Err returns the error carried by an error message and nil for other
+// message types.
+func (m *Message) Err() error {
+	if !m.IsError() {
+		return nil
+	}
+	var body struct {
+		Code        int    `xml:"Code"`
+		Description string `xml:"Description"`
+	}
+	if err := m.DecodeXML(&body); err != nil {
+		return fmt.Errorf("broker: invalid error message: %v", err)
+	}
+	return &Error{Code: body.Code, Description: body.Description}
+}
+
+// Receive receives messages from queue, waiting for them to arrive.
+//
+// The wait is bounded by the context: when it has a deadline the server
+// stops waiting at the deadline and Receive returns the context error,
+// when it is cancelled the running statement is cancelled. The queue name
+// is used verbatim and may be schema qualified.
+func Receive(ctx context.Context, q Querier, queue string, opts ReceiveOptions) ([]Message, error) {
+	if opts.Max <= 0 {
+		opts.Max = 1
+	}
+	timeout, bounded := opts.Timeout, opts.Timeout != 0
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		if !bounded || timeout > remaining {
+			timeout, bounded = remaining, true
+		}
+	}
+
+	var b strings.Builder
+	args := []interface{}{opts.Max}
+	fmt.Fprintf(&b, "RECEIVE TOP (@p1) conversation_handle, conversation_group_id, message_type_name, "+
+		"service_name, service_contract_name, message_sequence_number, message_body FROM %s", queue)
+	if opts.Conversation != nil {
+		b.WriteString(" WHERE conversation_handle = @p2")
+		args = append(args, *opts.Conversation)
+	}
+	query := b.String()
+	if timeout >= 0 {
+		query = "WAITFOR (" + query + ")"
+		if bounded {
+			ms := int64(timeout / time.Millisecond)
+			if ms < 1 {
+				ms = 1
+			}
+			query += fmt.Sprintf(", TIMEOUT %d", ms)
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []Message
+	for rows.Next() {
+		var m Message
+		err = rows.Scan(&m.ConversationHandle, &m.ConversationGroupID, &m.MessageType,
+			&m.ServiceName, &m.ContractName, &m.SequenceNumber, &m.Body)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	if err = rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if len(msgs) == 0 && hasDeadline && !time.Now().Before(deadline.Add(-timeoutSlack)) {
+		// the server gave up at the context deadline rather than at the
+		// requested timeout
+		if err = ctx.Err(); err == nil {
+			err = context.DeadlineExceeded
+		}
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// timeoutSlack allows for the server ending the wait a little before the
+// client side deadline.
+const timeoutSlack = 50 * time.Millisecond
+
+func quoteName(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
+
+// xmlBody converts UTF-16 encoded XML to UTF-8.
+func xmlBody(b []byte) []byte {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		b = b[2:]
+	case len(b) >= 2 && b[0] == '<' && b[1] == 0:
+	default:
+		return b
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return []byte(string(utf16.Decode(u)))
+}
@@ -0,0 +1,192 @@
+package broker_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/broker"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+var handleBytes = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+var messageColumns = []mssqltest.Column{
+	{Name: "conversation_handle", Type: mssqltest.UniqueIdentifier},
+	{Name: "conversation_group_id", Type: mssqltest.UniqueIdentifier},
+	{Name: "message_type_name", Type: mssqltest.NVarChar},
+	{Name: "service_name", Type: mssqltest.NVarChar},
+	{Name: "service_contract_name", Type: mssqltest.NVarChar},
+	{Name: "message_sequence_number", Type: mssqltest.BigInt},
+	{Name: "message_body", Type: mssqltest.VarBinary},
+}
+
+func open(t *testing.T, handler mssqltest.Handler) (*sql.DB, *mssqltest.Server) {
+	srv := mssqltest.NewServer(handler)
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return db, srv
+}
+
+func lastRequest(srv *mssqltest.Server) *mssqltest.Request {
+	reqs := srv.Requests()
+	return reqs[len(reqs)-1]
+}
+
+func TestBeginDialogAndSend(t *testing.T) {
+	db, srv := open(t, func(req *mssqltest.Request) []mssqltest.Response {
+		if strings.Contains(req.SQL, "BEGIN DIALOG") {
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Type: mssqltest.UniqueIdentifier}},
+				Rows:    [][]interface{}{{handleBytes}},
+			}}
+		}
+		return []mssqltest.Response{mssqltest.RowsAffected(0)}
+	})
+	defer srv.Close()
+	defer db.Close()
+	ctx := context.Background()
+
+	h, err := broker.BeginDialog(ctx, db, broker.DialogOptions{
+		FromService: "Orders]Out",
+		ToService:   "OrdersIn",
+		Contract:    "OrderContract",
+		Lifetime:    90 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want mssql.UniqueIdentifier
+	if err = want.Scan(handleBytes); err != nil {
+		t.Fatal(err)
+	}
+	if h != want {
+		t.Errorf("got handle %v, want %v", h, want)
+	}
+	req := lastRequest(srv)
+	for _, s := range []string{"FROM SERVICE [Orders]]Out] TO SERVICE @to", "ON CONTRACT [OrderContract]", "ENCRYPTION = OFF", "LIFETIME = @lifetime"} {
+		if !strings.Contains(req.SQL, s) {
+			t.Errorf("%q is missing from %q", s, req.SQL)
+		}
+	}
+	if p := req.Param("@to"); p == nil || p.Value != "OrdersIn" {
+		t.Errorf("unexpected @to parameter %+v", p)
+	}
+	if p := req.Param("@lifetime"); p == nil || p.Value != int64(90) {
+		t.Errorf("unexpected @lifetime parameter %+v", p)
+	}
+
+	if err = broker.SendJSON(ctx, db, h, "//orders/new", map[string]int{"id": 7}); err != nil {
+		t.Fatal(err)
+	}
+	req = lastRequest(srv)
+	if req.SQL != "SEND ON CONVERSATION @p1 MESSAGE TYPE [//orders/new] (@p2)" {
+		t.Errorf("unexpected statement %q", req.SQL)
+	}
+	if body, _ := req.Params[1].Value.([]byte); string(body) != `{"id":7}` {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	if err = broker.EndConversationWithError(ctx, db, h, 1, "bad order"); err != nil {
+		t.Fatal(err)
+	}
+	if req = lastRequest(srv); !strings.HasPrefix(req.SQL, "END CONVERSATION @p1 WITH ERROR") {
+		t.Errorf("unexpected statement %q", req.SQL)
+	}
+}
+
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return b
+}
+
+func TestReceive(t *testing.T) {
+	errBody := append([]byte{0xff, 0xfe}, utf16Bytes(`<Error xmlns="http://schemas.microsoft.com/SQL/ServiceBroker/Error"><Code>-8489</Code><Description>The dialog has exceeded the specified LIFETIME.</Description></Error>`)...)
+	db, srv := open(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: messageColumns,
+			Rows: [][]interface{}{
+				{handleBytes, handleBytes, "//orders/new", "OrdersIn", "OrderContract", int64(0), []byte(`{"id":7}`)},
+				{handleBytes, handleBytes, broker.ErrorMessageType, "OrdersIn", "OrderContract", int64(1), errBody},
+			},
+		}}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	conv := mssql.UniqueIdentifier{1}
+	msgs, err := broker.Receive(context.Background(), db, "dbo.OrderQueue", broker.ReceiveOptions{Max: 5, Conversation: &conv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := lastRequest(srv)
+	if !strings.HasPrefix(req.SQL, "WAITFOR (RECEIVE TOP (@p1)") || !strings.HasSuffix(req.SQL, "FROM dbo.OrderQueue WHERE conversation_handle = @p2)") {
+		t.Errorf("unexpected statement %q", req.SQL)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	var order struct{ ID int }
+	if err = msgs[0].DecodeJSON(&order); err != nil || order.ID != 7 {
+		t.Errorf("decoding JSON failed: %v %+v", err, order)
+	}
+	if msgs[0].Err() != nil || msgs[0].SequenceNumber != 0 {
+		t.Errorf("unexpected first message %+v", msgs[0])
+	}
+	if !msgs[1].IsError() {
+		t.Fatal("expected an error message")
+	}
+	e, ok := msgs[1].Err().(*broker.Error)
+	if !ok || e.Code != -8489 || !strings.Contains(e.Description, "LIFETIME") {
+		t.Errorf("unexpected error %v", msgs[1].Err())
+	}
+}
+
+func TestReceiveTimeouts(t *testing.T) {
+	timeoutRe := regexp.MustCompile(`TIMEOUT (\d+)$`)
+	db, srv := open(t, func(req *mssqltest.Request) []mssqltest.Response {
+		empty := mssqltest.ResultSet{Columns: messageColumns}
+		if strings.Contains(req.SQL, "TIMEOUT") {
+			return []mssqltest.Response{mssqltest.Delay(time.Minute), empty}
+		}
+		return []mssqltest.Response{empty}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	// without waiting
+	msgs, err := broker.Receive(context.Background(), db, "q", broker.ReceiveOptions{Timeout: -1})
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("got %v, %v", msgs, err)
+	}
+	if req := lastRequest(srv); strings.HasPrefix(req.SQL, "WAITFOR") {
+		t.Errorf("unexpected statement %q", req.SQL)
+	}
+
+	// the context deadline becomes the server side timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = broker.Receive(ctx, db, "q", broker.ReceiveOptions{Timeout: time.Hour})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	m := timeoutRe.FindStringSubmatch(lastRequest(srv).SQL)
+	if m == nil {
+		t.Fatalf("no timeout in %q", lastRequest(srv).SQL)
+	}
+	if ms, _ := strconv.Atoi(m[1]); ms <= 0 || ms > 300 {
+		t.Errorf("unexpected timeout %d", ms)
+	}
+}
@@ -0,0 +1,118 @@
+package mssql
+
+import (
+	"bytes"
+	"io"
+)
+
+// In browse mode, queries with FOR BROWSE and all queries of sessions with
+// SET NO_BROWSETABLE ON, the COLMETADATA token is followed by a TABNAME
+// token listing the base tables of the result set and a COLINFO token that
+// maps every column to its table. The server adds the key columns of the
+// tables the select list left out as hidden columns.
+//
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-tds/140e3348-da08-409a-b6c3-f0fc9cee2d6e
+
+// COLINFO status bits
+const (
+	colInfoExpression    = 0x04
+	colInfoKey           = 0x08
+	colInfoHidden        = 0x10
+	colInfoDifferentName = 0x20
+)
+
+// ColumnSource is the base table column a result set column was read from,
+// known in browse mode. The parts of the table name the server did not send
+// are empty.
+type ColumnSource struct {
+	Server  string
+	Catalog string
+	Schema  string
+	Table   string
+	// Column is the name of the column in its table, which differs from
+	// the name in the result set when the column is aliased.
+	Column string
+	// Expression is set for columns computed by the query, which have no
+	// base table.
+	Expression bool
+}
+
+// parseTabName reads the TABNAME token, the multi-part names of the base
+// tables of a result set.
+func parseTabName(r *tdsBuffer) [][]string {
+	buf := make([]byte, r.uint16())
+	r.ReadFull(buf)
+	br := bytes.NewReader(buf)
+	var tables [][]string
+	for br.Len() > 0 {
+		n, err := br.ReadByte()
+		if err != nil {
+			badStreamPanic(err)
+		}
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = readUsVarCharOrPanic(br)
+		}
+		tables = append(tables, parts)
+	}
+	return tables
+}
+
+// parseColInfo reads the COLINFO token and sets the source of columns from
+// the tables of the TABNAME token. The key and hidden bits are added to the
+// flags of the columns.
+func parseColInfo(r *tdsBuffer, columns []columnStruct, tables [][]string) {
+	buf := make([]byte, r.uint16())
+	r.ReadFull(buf)
+	br := bytes.NewReader(buf)
+	for br.Len() > 0 {
+		var hdr [3]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			badStreamPanic(err)
+		}
+		colNum, tableNum, status := int(hdr[0]), int(hdr[1]), hdr[2]
+		src := &ColumnSource{Expression: status&colInfoExpression != 0}
+		if status&colInfoDifferentName != 0 {
+			src.Column = readBVarCharOrPanic(br)
+		}
+		if colNum < 1 || colNum > len(columns) {
+			continue
+		}
+		col := &columns[colNum-1]
+		if !src.Expression && src.Column == "" {
+			src.Column = col.ColName
+		}
+		if tableNum >= 1 && tableNum <= len(tables) {
+			// the table is the last part of the name, preceded by the
+			// schema, catalog and server
+			parts := tables[tableNum-1]
+			for i, p := range []*string{&src.Table, &src.Schema, &src.Catalog, &src.Server} {
+				if i < len(parts) {
+					*p = parts[len(parts)-1-i]
+				}
+			}
+		}
+		if status&colInfoKey != 0 {
+			col.Flags |= colFlagKey
+		}
+		if status&colInfoHidden != 0 {
+			col.Flags |= colFlagHidden
+		}
+		col.source = src
+	}
+}
+
+// setColumnSources sets the column sources argument of the query, if any,
+// to the sources of the columns of a result set.
+func (o outputs) setColumnSources(cols []columnStruct) {
+	if o.columnSources == nil {
+		return
+	}
+	sources := make([]ColumnSource, len(cols))
+	for i, col := range cols {
+		if col.source != nil {
+			sources[i] = *col.source
+		}
+	}
+	*o.columnSources = sources
+}
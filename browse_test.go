@@ -0,0 +1,98 @@
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestColumnSources(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "customer", Type: mssqltest.NVarChar, Browse: &mssqltest.Browse{Table: "sales.dbo.customers", Name: "name"}},
+				{Name: "total", Type: mssqltest.Int, Browse: &mssqltest.Browse{}},
+				{Name: "order_date", Type: mssqltest.NVarChar, Browse: &mssqltest.Browse{Table: "orders"}},
+				{Name: "id", Type: mssqltest.Int, Browse: &mssqltest.Browse{Table: "sales.dbo.customers", Key: true, Hidden: true}},
+			},
+			Rows: [][]interface{}{{"a", 1, "2020-01-01", 7}},
+		}}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var sources []ColumnSource
+	var flags []ColumnFlags
+	rows, err := db.Query("select c.name as customer, o.qty * o.price as total, o.order_date from customers c join orders o on o.customer_id = c.id for browse", &sources, &flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	expected := []ColumnSource{
+		{Catalog: "sales", Schema: "dbo", Table: "customers", Column: "name"},
+		{Expression: true},
+		{Table: "orders", Column: "order_date"},
+		{Catalog: "sales", Schema: "dbo", Table: "customers", Column: "id"},
+	}
+	if len(sources) != len(expected) {
+		t.Fatalf("expected %d column sources, got %v", len(expected), sources)
+	}
+	for i, src := range sources {
+		if src != expected[i] {
+			t.Errorf("column %d: expected source %+v, got %+v", i, expected[i], src)
+		}
+	}
+	if !flags[3].Key() || !flags[3].Hidden() || flags[0].Key() || flags[0].Hidden() {
+		t.Errorf("unexpected column flags %v", flags)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestColumnTypeSource(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "id", Type: mssqltest.Int, Browse: &mssqltest.Browse{Table: "dbo.t", Key: true}},
+				{Name: "n", Type: mssqltest.Int},
+			},
+			Rows: [][]interface{}{{1, 2}},
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stmt, err := conn.(*Conn).prepareContext(context.Background(), "select id, n from t for browse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	r, err := stmt.queryContext(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	rows := r.(*Rows)
+	if src, ok := rows.ColumnTypeSource(0); !ok || src != (ColumnSource{Schema: "dbo", Table: "t", Column: "id"}) {
+		t.Errorf("unexpected source of column id %+v %v", src, ok)
+	}
+	if _, ok := rows.ColumnTypeSource(1); ok {
+		t.Error("expected column n to have no source")
+	}
+}
@@ -38,6 +38,8 @@ type tdsBuffer struct {
 	rsize       int
 	final       bool
 	rPacketType packetType
+	// spid is the server session id of the last packet read.
+	spid uint16
 
 	// afterFirst is assigned to right after tdsBuffer is created and
 	// before the first use. It is executed after the first packet is
@@ -166,6 +168,7 @@ func (r *tdsBuffer) readNextPacket() error {
 	r.rsize = int(h.Size)
 	r.final = h.Status != 0
 	r.rPacketType = h.PacketType
+	r.spid = h.Spid
 	return nil
 }
 
@@ -192,6 +195,19 @@ func (r *tdsBuffer) ReadByte() (res byte, err error) {
 	return res, nil
 }
 
+// peekByte returns the next byte without consuming it.
+func (r *tdsBuffer) peekByte() (byte, error) {
+	if r.rpos == r.rsize {
+		if r.final {
+			return 0, io.EOF
+		}
+		if err := r.readNextPacket(); err != nil {
+			return 0, err
+		}
+	}
+	return r.rbuf[r.rpos], nil
+}
+
 func (r *tdsBuffer) byte() byte {
 	b, err := r.ReadByte()
 	if err != nil {
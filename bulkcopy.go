@@ -5,11 +5,15 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/denisenkom/go-mssqldb/internal/decimal"
 )
@@ -27,19 +31,106 @@ type Bulk struct {
 	columnsName []string
 	tablename   string
 	numRows     int
+	// rowIndex is the index of the next row added
+	rowIndex  int
+	rowErrors []BulkRowError
+	// query is the INSERT BULK statement receiving the rows
+	query string
+	// batch is the rows sent to the current INSERT BULK statement when
+	// Options.MaxErrors is set, to send them again when the server rejects
+	// some of them
+	batch []bulkBatchRow
+	// rowCount is the number of rows copied by the finished INSERT BULK
+	// statements of the batches of Options.MaxErrors
+	rowCount int64
+	// loading is set while an INSERT BULK statement receives rows
+	loading bool
 
 	headerSent bool
 	Options    BulkOptions
 	Debug      bool
 }
+
+// BulkOptions are the hints of the INSERT BULK statement of a bulk copy.
 type BulkOptions struct {
-	CheckConstraints  bool
-	FireTriggers      bool
-	KeepNulls         bool
+	// CheckConstraints checks the constraints of the destination table,
+	// which are ignored otherwise.
+	CheckConstraints bool
+	// FireTriggers runs the insert triggers of the destination table.
+	FireTriggers bool
+	// KeepNulls keeps the null values of the rows instead of replacing
+	// them with the column defaults.
+	KeepNulls bool
+	// KeepIdentity copies the values of identity columns instead of
+	// letting the server generate them. The identity columns of the
+	// destination table are then copied by default.
+	KeepIdentity bool
+	// AllowEncryptedValueModifications copies the ciphertext of Always
+	// Encrypted columns as is, to move encrypted data between tables
+	// without decrypting it.
+	AllowEncryptedValueModifications bool
+	// KilobytesPerBatch and RowsPerBatch are estimates of the size of
+	// the copy, used by the server to plan it.
 	KilobytesPerBatch int
 	RowsPerBatch      int
-	Order             []string
-	Tablock           bool
+	// Order lists the columns the rows are sorted by, such as "id ASC".
+	Order []string
+	// Tablock takes a table lock for the duration of the copy, which
+	// allows minimal logging.
+	Tablock bool
+	// MaxErrors is the number of bad rows to skip, like the -m flag of
+	// bcp: the rows that cannot be converted to the types of the
+	// destination columns and the rows the server rejects, for constraint
+	// violations for example. The copy fails at the first bad row past
+	// it, the skipped rows are reported by RowErrors.
+	//
+	// With MaxErrors, the rows are copied by INSERT BULK statements of
+	// up to 1000 rows, which commit on their own outside of a transaction.
+	// The rows of a statement the server fails with a row error, such as a
+	// conversion error, a constraint violation or a truncation, are copied
+	// again in halves, down to the rejected rows. Other errors, such as a
+	// deadlock, fail the copy. The rows are kept in memory until their
+	// statement completes, the io.Reader values are read whole.
+	//
+	// In a transaction, as in Connector.ParallelBulkCopy, the rows the
+	// server rejects fail the copy, the error may have rolled the
+	// transaction back. Only the rows that cannot be converted are skipped.
+	MaxErrors int
+}
+
+// maxErrorsBatchRows is the number of rows of the INSERT BULK statements
+// of a copy with Options.MaxErrors.
+const maxErrorsBatchRows = 1000
+
+// bulkBatchRow is an encoded row of an INSERT BULK statement.
+type bulkBatchRow struct {
+	index int
+	data  []byte
+}
+
+// BulkRowError is the error of a row of a bulk copy that could not be
+// converted to the types of the destination columns, or that the server
+// rejected.
+type BulkRowError struct {
+	// Row is the index of the row among the rows added to the copy.
+	Row int
+	// Column is the column whose value could not be converted, empty if
+	// the row has a wrong number of values or if the server rejected it.
+	Column string
+	// Err is the conversion error, or the Error of the server.
+	Err error
+}
+
+func (e BulkRowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("bulkcopy: row %d: %v", e.Row, e.Err)
+	}
+	return fmt.Sprintf("bulkcopy: row %d column %s: %v", e.Row, e.Column, e.Err)
+}
+
+// Unwrap returns the conversion error.
+func (e BulkRowError) Unwrap() error {
+	return e.Err
 }
 
 type DataValue interface{}
@@ -50,6 +141,11 @@ const (
 	sqlTimeFormat     = "15:04:05.9999999"
 )
 
+// CreateBulk prepares a bulk copy of rows to table. The values of the rows
+// are given in the order of columns, which name a subset of the columns of
+// the table, matched case-insensitively. Without columns, all the columns
+// of the table but identity and computed columns are copied, in the order
+// of the table definition. Set the Options before adding the first row.
 func (cn *Conn) CreateBulk(table string, columns []string) (_ *Bulk) {
 	b := Bulk{ctx: context.Background(), cn: cn, tablename: table, headerSent: false, columnsName: columns}
 	b.Debug = false
@@ -70,26 +166,32 @@ func (b *Bulk) sendBulkCommand(ctx context.Context) (err error) {
 	}
 
 	//match the columns
-	for _, colname := range b.columnsName {
-		var bulkCol *columnStruct
-
+	if len(b.columnsName) == 0 {
+		// the server generates the values of identity and computed columns
+		generated := uint16(colFlagIdentity | colFlagComputed)
+		if b.Options.KeepIdentity {
+			generated = colFlagComputed
+		}
 		for _, m := range b.metadata {
-			if m.ColName == colname {
-				bulkCol = &m
-				break
+			if m.Flags&generated == 0 {
+				b.columnsName = append(b.columnsName, m.ColName)
 			}
 		}
-		if bulkCol != nil {
-
-			if bulkCol.ti.TypeId == typeUdt {
-				//send udt as binary
-				bulkCol.ti.TypeId = typeBigVarBin
-			}
-			b.bulkColumns = append(b.bulkColumns, *bulkCol)
-			b.dlogf("Adding column %s %s %#x", colname, bulkCol.ColName, bulkCol.ti.TypeId)
-		} else {
+	}
+	for _, colname := range b.columnsName {
+		bulkCol := b.metadataColumn(colname)
+		if bulkCol == nil {
 			return fmt.Errorf("column %s does not exist in destination table %s", colname, b.tablename)
 		}
+		if bulkCol.Flags&colFlagComputed != 0 {
+			return fmt.Errorf("column %s of destination table %s is computed and cannot be copied", colname, b.tablename)
+		}
+		if bulkCol.ti.TypeId == typeUdt {
+			//send udt as binary
+			bulkCol.ti.TypeId = typeBigVarBin
+		}
+		b.bulkColumns = append(b.bulkColumns, *bulkCol)
+		b.dlogf("Adding column %s %s %#x", colname, bulkCol.ColName, bulkCol.ti.TypeId)
 	}
 
 	//create the bulk command
@@ -115,6 +217,12 @@ func (b *Bulk) sendBulkCommand(ctx context.Context) (err error) {
 	if b.Options.KeepNulls {
 		with_opts = append(with_opts, "KEEP_NULLS")
 	}
+	if b.Options.KeepIdentity {
+		with_opts = append(with_opts, "KEEP_IDENTITY")
+	}
+	if b.Options.AllowEncryptedValueModifications {
+		with_opts = append(with_opts, "ALLOW_ENCRYPTED_VALUE_MODIFICATIONS")
+	}
 	if b.Options.KilobytesPerBatch > 0 {
 		with_opts = append(with_opts, fmt.Sprintf("KILOBYTES_PER_BATCH = %d", b.Options.KilobytesPerBatch))
 	}
@@ -132,20 +240,26 @@ func (b *Bulk) sendBulkCommand(ctx context.Context) (err error) {
 		with_part = fmt.Sprintf("WITH (%s)", strings.Join(with_opts, ","))
 	}
 
-	query := fmt.Sprintf("INSERT BULK %s (%s) %s", b.tablename, col_defs.String(), with_part)
+	b.query = fmt.Sprintf("INSERT BULK %s (%s) %s", b.tablename, col_defs.String(), with_part)
+	b.headerSent = true
+	return
+}
 
-	stmt, err := b.cn.PrepareContext(ctx, query)
+// startLoad runs the INSERT BULK statement and sends the metadata of the
+// rows that follow.
+func (b *Bulk) startLoad(ctx context.Context) error {
+	stmt, err := b.cn.PrepareContext(ctx, b.query)
 	if err != nil {
 		return fmt.Errorf("Prepare failed: %s", err.Error())
 	}
-	b.dlogf(query)
+	b.dlogf(b.query)
 
 	_, err = stmt.(*Stmt).ExecContext(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	b.headerSent = true
+	b.loading = true
 
 	var buf = b.cn.sess.buf
 	buf.BeginPacket(packBulkLoadBCP, false)
@@ -153,12 +267,16 @@ func (b *Bulk) sendBulkCommand(ctx context.Context) (err error) {
 	// Send the columns metadata.
 	columnMetadata := b.createColMetadata()
 	_, err = buf.Write(columnMetadata)
-
-	return
+	return err
 }
 
 // AddRow immediately writes the row to the destination table.
 // The arguments are the row values in the order they were specified.
+//
+// The io.Reader values of varbinary(max), varchar(max) and nvarchar(max)
+// columns are streamed to the server while the row is written, the text of
+// the character columns is read as UTF-8. A failing reader aborts the copy
+// and closes the connection. The readers of other columns are read whole.
 func (b *Bulk) AddRow(row []interface{}) (err error) {
 	if !b.headerSent {
 		err = b.sendBulkCommand(b.ctx)
@@ -166,27 +284,296 @@ func (b *Bulk) AddRow(row []interface{}) (err error) {
 			return
 		}
 	}
+	if !b.loading {
+		if err = b.startLoad(b.ctx); err != nil {
+			return
+		}
+	}
 
+	index := b.rowIndex
+	b.rowIndex++
+	var parts []bulkRowPart
 	if len(row) != len(b.bulkColumns) {
-		return fmt.Errorf("row does not have the same number of columns than the destination table %d %d",
-			len(row), len(b.bulkColumns))
+		err = BulkRowError{Row: index, Err: fmt.Errorf("row does not have the same number of columns than the destination table %d %d",
+			len(row), len(b.bulkColumns))}
+	} else {
+		parts, err = b.makeRowData(index, row)
+	}
+	if err == nil && b.Options.MaxErrors > 0 {
+		// the row is kept to be sent again
+		parts, err = bufferRow(index, parts)
 	}
-
-	bytes, err := b.makeRowData(row)
 	if err != nil {
+		if rowErr, ok := err.(BulkRowError); ok && len(b.rowErrors) < b.Options.MaxErrors {
+			b.dlogf("skipping %v", rowErr)
+			b.rowErrors = append(b.rowErrors, rowErr)
+			return nil
+		}
 		return
 	}
 
-	_, err = b.cn.sess.buf.Write(bytes)
-	if err != nil {
-		return
+	for _, part := range parts {
+		if _, err = b.cn.sess.buf.Write(part.data); err != nil {
+			return
+		}
+		if part.stream != nil {
+			if err = writePLPStream(b.cn.sess.buf, part.stream, _UNKNOWN_PLP_LEN, part.ucs2); err != nil {
+				return b.abort(fmt.Errorf("bulkcopy: row %d: streaming a value failed, the copy cannot continue: %v", index, err))
+			}
+		}
 	}
 
 	b.numRows = b.numRows + 1
+	if b.Options.MaxErrors > 0 {
+		b.batch = append(b.batch, bulkBatchRow{index: index, data: parts[0].data})
+		if len(b.batch) >= maxErrorsBatchRows {
+			return b.flushBatch()
+		}
+	}
 	return
 }
 
-func (b *Bulk) makeRowData(row []interface{}) ([]byte, error) {
+// bufferRow returns the parts of a row as a single part, with the values
+// of its streams read.
+func bufferRow(index int, parts []bulkRowPart) ([]bulkRowPart, error) {
+	var buf bytes.Buffer
+	for _, part := range parts {
+		buf.Write(part.data)
+		if part.stream != nil {
+			if err := writePLPStream(&buf, part.stream, _UNKNOWN_PLP_LEN, part.ucs2); err != nil {
+				return nil, BulkRowError{Row: index, Err: err}
+			}
+		}
+	}
+	return []bulkRowPart{{data: buf.Bytes()}}, nil
+}
+
+// flushBatch finishes the INSERT BULK statement of the rows of the batch.
+func (b *Bulk) flushBatch() error {
+	if !b.loading {
+		return nil
+	}
+	rows := b.batch
+	b.batch = nil
+	inTran := b.cn.sess.tranid != 0
+	n, err := b.finishLoad()
+	if err != nil {
+		if inTran {
+			// the rows copied again would not be part of a transaction
+			// the error rolled back
+			return err
+		}
+		return b.skipRejectedRows(rows, err)
+	}
+	b.rowCount += n
+	return nil
+}
+
+// rowErrorNumbers are the numbers of the errors of the server caused by
+// the values of a row: conversion errors, constraint violations and
+// truncations.
+var rowErrorNumbers = map[int32]bool{
+	220: true, 232: true, 241: true, 242: true, 244: true, 245: true,
+	248: true, 295: true, 515: true, 547: true, 2601: true, 2627: true,
+	2628: true, 4863: true, 4864: true, 8114: true, 8115: true, 8152: true,
+}
+
+// skipRejectedRows copies the rows the server failed with err again, in
+// halves, to skip the rows it rejects up to Options.MaxErrors.
+func (b *Bulk) skipRejectedRows(rows []bulkBatchRow, err error) error {
+	if sqlErr, ok := err.(Error); !ok || !rowErrorNumbers[sqlErr.Number] || len(rows) == 0 {
+		// the copy failed, rather than rows
+		return err
+	}
+	if len(rows) == 1 {
+		rowErr := BulkRowError{Row: rows[0].index, Err: err}
+		if len(b.rowErrors) >= b.Options.MaxErrors {
+			return rowErr
+		}
+		b.dlogf("skipping %v", rowErr)
+		b.rowErrors = append(b.rowErrors, rowErr)
+		return nil
+	}
+	half := len(rows) / 2
+	for _, part := range [][]bulkBatchRow{rows[:half], rows[half:]} {
+		if err = b.startLoad(b.ctx); err != nil {
+			return err
+		}
+		for _, row := range part {
+			if _, err = b.cn.sess.buf.Write(row.data); err != nil {
+				return err
+			}
+		}
+		n, err := b.finishLoad()
+		if err != nil {
+			if err = b.skipRejectedRows(part, err); err != nil {
+				return err
+			}
+			continue
+		}
+		b.rowCount += n
+	}
+	return nil
+}
+
+// bulkRowPart is a part of an encoded row: data followed by a value
+// streamed from a reader, if any. Rows are split at the io.Reader values of
+// max columns, which are read while the row is written.
+type bulkRowPart struct {
+	data   []byte
+	stream io.Reader
+	// ucs2 is set to convert the UTF-8 text of stream
+	ucs2 bool
+}
+
+// streamsPLP reports whether the io.Reader values of col are streamed in
+// PLP chunks, it is true for the varbinary(max), varchar(max) and
+// nvarchar(max) columns.
+func streamsPLP(col columnStruct) bool {
+	switch col.ti.TypeId {
+	case typeBigVarBin, typeBigVarChar, typeNVarChar:
+		return col.ti.Size == 0xffff
+	}
+	return false
+}
+
+// writePLPStream writes the value read from r as PLP chunks, converting
+// UTF-8 text to UCS-2 when ucs2 is set. length is the total length sent
+// ahead of the chunks, _UNKNOWN_PLP_LEN if it is not known, and checked
+// against the length of the value otherwise.
+func writePLPStream(w io.Writer, r io.Reader, length uint64, ucs2 bool) error {
+	if err := binary.Write(w, binary.LittleEndian, length); err != nil {
+		return err
+	}
+	var written uint64
+	writeChunk := func(chunk []byte) error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		written += uint64(len(chunk))
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(chunk))); err != nil {
+			return err
+		}
+		_, err := w.Write(chunk)
+		return err
+	}
+	buf := make([]byte, 8000)
+	// pending is an incomplete UTF-8 sequence at the end of the last read
+	var pending []byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			if ucs2 {
+				text := append(pending, chunk...)
+				cut := len(text)
+				for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+					if utf8.RuneStart(text[i]) {
+						if !utf8.FullRune(text[i:]) {
+							cut = i
+						}
+						break
+					}
+				}
+				pending = append([]byte(nil), text[cut:]...)
+				chunk = str2ucs2(string(text[:cut]))
+			}
+			if werr := writeChunk(chunk); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := writeChunk(str2ucs2(string(pending))); err != nil {
+		return err
+	}
+	if length != _UNKNOWN_PLP_LEN && written != length {
+		return fmt.Errorf("the value is %d bytes long instead of %d", written, length)
+	}
+	return binary.Write(w, binary.LittleEndian, uint32(_PLP_TERMINATOR))
+}
+
+// AddRowMap writes a row given as values by column name, matched
+// case-insensitively. The columns of the bulk copy without a value are
+// sent as nulls, which the server replaces with the column defaults unless
+// KeepNulls is set.
+func (b *Bulk) AddRowMap(values map[string]interface{}) (err error) {
+	if !b.headerSent {
+		err = b.sendBulkCommand(b.ctx)
+		if err != nil {
+			return
+		}
+	}
+	row := make([]interface{}, len(b.bulkColumns))
+	for name, v := range values {
+		i := b.columnIndex(name)
+		if i < 0 {
+			return fmt.Errorf("column %s is not copied to destination table %s", name, b.tablename)
+		}
+		row[i] = v
+	}
+	return b.AddRow(row)
+}
+
+// metadataColumn returns a copy of the column of the destination table
+// with the given name, nil if there is none. An exact match is preferred
+// to a case-insensitive one.
+func (b *Bulk) metadataColumn(name string) *columnStruct {
+	name = unquoteColumnName(name)
+	var match *columnStruct
+	for i := range b.metadata {
+		m := b.metadata[i]
+		if m.ColName == name {
+			return &m
+		}
+		if match == nil && strings.EqualFold(m.ColName, name) {
+			match = &m
+		}
+	}
+	return match
+}
+
+// columnIndex returns the index of the copied column with the given name,
+// -1 if it is not copied.
+func (b *Bulk) columnIndex(name string) int {
+	name = unquoteColumnName(name)
+	match := -1
+	for i, col := range b.bulkColumns {
+		if col.ColName == name {
+			return i
+		}
+		if match < 0 && strings.EqualFold(col.ColName, name) {
+			match = i
+		}
+	}
+	return match
+}
+
+// unquoteColumnName removes the brackets around a column name.
+func unquoteColumnName(name string) string {
+	if len(name) >= 2 && name[0] == '[' && name[len(name)-1] == ']' {
+		return strings.Replace(name[1:len(name)-1], "]]", "]", -1)
+	}
+	return name
+}
+
+// RowErrors returns the errors of the rows skipped because of
+// Options.MaxErrors, in the order of the rows. The rows the server rejects
+// are known once their INSERT BULK statement completes.
+func (b *Bulk) RowErrors() []BulkRowError {
+	sort.SliceStable(b.rowErrors, func(i, j int) bool {
+		return b.rowErrors[i].Row < b.rowErrors[j].Row
+	})
+	return b.rowErrors
+}
+
+func (b *Bulk) makeRowData(index int, row []interface{}) ([]bulkRowPart, error) {
+	var parts []bulkRowPart
 	buf := new(bytes.Buffer)
 	buf.WriteByte(byte(tokenRow))
 
@@ -196,9 +583,14 @@ func (b *Bulk) makeRowData(row []interface{}) ([]byte, error) {
 		if b.Debug {
 			logcol.WriteString(fmt.Sprintf(" col[%d]='%v' ", i, row[i]))
 		}
+		if r, ok := row[i].(io.Reader); ok && streamsPLP(col) {
+			parts = append(parts, bulkRowPart{data: buf.Bytes(), stream: r, ucs2: col.ti.TypeId == typeNVarChar})
+			buf = new(bytes.Buffer)
+			continue
+		}
 		param, err := b.makeParam(row[i], col)
 		if err != nil {
-			return nil, fmt.Errorf("bulkcopy: %s", err.Error())
+			return nil, BulkRowError{Row: index, Column: col.ColName, Err: err}
 		}
 
 		if col.ti.Writer == nil {
@@ -207,20 +599,87 @@ func (b *Bulk) makeRowData(row []interface{}) ([]byte, error) {
 		}
 		err = col.ti.Writer(buf, param.ti, param.buffer)
 		if err != nil {
-			return nil, fmt.Errorf("bulkcopy: %s", err.Error())
+			return nil, BulkRowError{Row: index, Column: col.ColName, Err: err}
 		}
 	}
 
-	b.dlogf("row[%d] %s\n", b.numRows, logcol.String())
+	b.dlogf("row[%d] %s\n", index, logcol.String())
+
+	return append(parts, bulkRowPart{data: buf.Bytes()}), nil
+}
+
+// BulkRowSource streams the rows of a bulk copy, see Bulk.CopyFrom.
+type BulkRowSource interface {
+	// Next returns the values of the next row, in the order of the
+	// columns of the copy, and io.EOF after the last row.
+	Next() ([]interface{}, error)
+}
+
+// BulkRowSourceFunc is a BulkRowSource calling the function for every row.
+type BulkRowSourceFunc func() ([]interface{}, error)
+
+func (f BulkRowSourceFunc) Next() ([]interface{}, error) {
+	return f()
+}
+
+// CopyFrom copies the rows of src and finishes the copy, it returns the
+// number of rows copied. Rows are sent as src returns them, a packet at a
+// time, so src is not read faster than the server accepts the rows and
+// the copy holds a single packet in memory.
+//
+// An error of src or of a row, or the cancellation of the context of the
+// copy, aborts it: none of the rows are inserted and the connection, in
+// the middle of the copy, is closed. With Options.MaxErrors, the rows of
+// the INSERT BULK statements that completed stay inserted.
+func (b *Bulk) CopyFrom(src BulkRowSource) (rowcount int64, err error) {
+	for {
+		if err = b.ctx.Err(); err != nil {
+			return 0, b.abort(err)
+		}
+		var row []interface{}
+		row, err = src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, b.abort(err)
+		}
+		if err = b.AddRow(row); err != nil {
+			return 0, b.abort(err)
+		}
+	}
+	return b.Done()
+}
 
-	return buf.Bytes(), nil
+// abort abandons a copy whose rows are partly sent by closing the
+// connection, the server rolls back the copy.
+func (b *Bulk) abort(err error) error {
+	if b.loading {
+		b.cn.connectionGood = false
+		b.cn.Close()
+	}
+	return err
 }
 
+// Done finishes the copy and returns the number of rows copied. With
+// Options.MaxErrors, the rows of the INSERT BULK statements that completed
+// are counted when the copy fails.
 func (b *Bulk) Done() (rowcount int64, err error) {
-	if !b.headerSent {
+	if !b.loading {
 		//no rows had been sent
-		return 0, nil
+		return b.rowCount, nil
+	}
+	if b.Options.MaxErrors > 0 {
+		err = b.flushBatch()
+		return b.rowCount, err
 	}
+	return b.finishLoad()
+}
+
+// finishLoad ends the rows of the INSERT BULK statement and returns the
+// number of rows the server copied.
+func (b *Bulk) finishLoad() (rowcount int64, err error) {
+	b.loading = false
 	var buf = b.cn.sess.buf
 	buf.WriteByte(byte(tokenDone))
 
@@ -317,6 +776,20 @@ func (b *Bulk) makeParam(val DataValue, col columnStruct) (res param, err error)
 		res.ti.Size = 0
 		return
 	}
+	if r, ok := val.(io.Reader); ok {
+		// the values of columns that are not streamed are read whole
+		data, rerr := ioutil.ReadAll(r)
+		if rerr != nil {
+			err = rerr
+			return
+		}
+		switch col.ti.TypeId {
+		case typeNVarChar, typeNText, typeNChar, typeVarChar, typeBigVarChar, typeText, typeChar, typeBigChar:
+			val = string(data)
+		default:
+			val = data
+		}
+	}
 
 	switch col.ti.TypeId {
 
@@ -500,7 +973,30 @@ func (b *Bulk) makeParam(val DataValue, col columnStruct) (res param, err error)
 			err = fmt.Errorf("mssql: invalid type for time column: %T %s", val, val)
 			return
 		}
-	// case typeMoney, typeMoney4, typeMoneyN:
+	case typeMoney, typeMoney4, typeMoneyN:
+		var m int64
+		switch v := val.(type) {
+		case Money:
+			m = int64(v)
+		case SmallMoney:
+			m = int64(v)
+		case string:
+			if m, err = parseMoney(v); err != nil {
+				return
+			}
+		default:
+			return res, fmt.Errorf("unknown value for money: %T %#v", v, v)
+		}
+		if col.ti.TypeId == typeMoney4 || col.ti.Size == 4 {
+			if m < math.MinInt32 || m > math.MaxInt32 {
+				return res, fmt.Errorf("mssql: %s is out of the range of smallmoney", formatMoney(m))
+			}
+			res.buffer = make([]byte, 4)
+			binary.LittleEndian.PutUint32(res.buffer, uint32(m))
+		} else {
+			res.buffer = encodeMoney(m)
+		}
+		res.ti.Size = len(res.buffer)
 	case typeDecimal, typeDecimalN, typeNumeric, typeNumericN:
 		prec := col.ti.Prec
 		scale := col.ti.Scale
@@ -522,6 +1018,8 @@ func (b *Bulk) makeParam(val DataValue, col columnStruct) (res param, err error)
 			dec, err = decimal.Float64ToDecimalScale(float64(v), scale)
 		case string:
 			dec, err = decimal.StringToDecimalScale(v, scale)
+		case Decimal:
+			dec, err = decimal.StringToDecimalScale(v.String(), scale)
 		default:
 			return res, fmt.Errorf("unknown value for decimal: %T %#v", v, v)
 		}
@@ -564,7 +1062,7 @@ func (b *Bulk) makeParam(val DataValue, col columnStruct) (res param, err error)
 			buf[i] = ub[j]
 		}
 		res.buffer = buf
-	case typeBigVarBin, typeBigBinary:
+	case typeBigVarBin, typeBigBinary, typeImage:
 		switch val := val.(type) {
 		case []byte:
 			res.ti.Size = len(val)
@@ -578,6 +1076,14 @@ func (b *Bulk) makeParam(val DataValue, col columnStruct) (res param, err error)
 		case []byte:
 			res.ti.Size = len(val)
 			res.buffer = val
+		case UniqueIdentifier:
+			res = val.param()
+		case string:
+			var u UniqueIdentifier
+			if u, err = ParseUniqueIdentifier(val); err != nil {
+				return
+			}
+			res = u.param()
 		default:
 			err = fmt.Errorf("mssql: invalid type for Guid column: %T %s", val, val)
 			return
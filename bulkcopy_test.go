@@ -1,16 +1,24 @@
-// +build go1.9
+// +build go1.10
 
 package mssql
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"math"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
 )
 
 func TestBulkcopy(t *testing.T) {
@@ -188,6 +196,445 @@ func TestBulkcopy(t *testing.T) {
 	}
 }
 
+// bulkTestTable is the destination table of the bulk copies to the fake
+// servers of bulkTestHandler.
+var bulkTestTable = []mssqltest.Column{
+	{Name: "id", Type: mssqltest.Int, Flags: colFlagIdentity},
+	{Name: "name", Type: mssqltest.NVarChar, Flags: colFlagNullable},
+	{Name: "qty", Type: mssqltest.Int, Flags: colFlagNullable},
+	{Name: "total", Type: mssqltest.Int, Flags: colFlagNullable | colFlagComputed},
+	{Name: "note", Type: mssqltest.NVarChar, Flags: colFlagNullable},
+}
+
+// bulkTestHandler answers the query of the columns of the destination
+// table of a bulk copy with bulkTestTable.
+func bulkTestHandler(req *mssqltest.Request) []mssqltest.Response {
+	if strings.HasPrefix(req.SQL, "select * from ") {
+		return []mssqltest.Response{mssqltest.ResultSet{Columns: bulkTestTable}}
+	}
+	return nil
+}
+
+// bulkLoads returns the bulk load requests received by srv.
+func bulkLoads(srv *mssqltest.Server) []*mssqltest.Bulk {
+	var loads []*mssqltest.Bulk
+	for _, req := range srv.Requests() {
+		if req.Type == mssqltest.BulkLoad {
+			loads = append(loads, req.Bulk)
+		}
+	}
+	return loads
+}
+
+func TestBulkDefaultColumns(t *testing.T) {
+	srv, conn := connectTestServer(t, bulkTestHandler)
+	defer srv.Close()
+	defer conn.Close()
+
+	bulk := conn.CreateBulk("t", nil)
+	if err := bulk.AddRow([]interface{}{"a", 1, "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bulk.AddRowMap(map[string]interface{}{"Name": "b", "[qty]": 2}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := bulk.Done()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 rows copied, got %d", n)
+	}
+	var insert string
+	for _, req := range srv.Requests() {
+		if strings.HasPrefix(req.SQL, "INSERT BULK") {
+			insert = req.SQL
+		}
+	}
+	if !strings.Contains(insert, "([name] nvarchar") || strings.Contains(insert, "[id]") || strings.Contains(insert, "[total]") {
+		t.Errorf("expected the identity and computed columns to be skipped, got %q", insert)
+	}
+	loads := bulkLoads(srv)
+	if len(loads) != 1 {
+		t.Fatalf("expected 1 bulk load, got %d", len(loads))
+	}
+	if got := strings.Join(loads[0].Columns, ","); got != "name,qty,note" {
+		t.Errorf("unexpected bulk columns %s", got)
+	}
+	expected := [][]interface{}{{"a", int64(1), "x"}, {"b", int64(2), nil}}
+	if !reflect.DeepEqual(loads[0].Rows, expected) {
+		t.Errorf("expected rows %v, got %v", expected, loads[0].Rows)
+	}
+}
+
+func TestBulkColumnMapping(t *testing.T) {
+	srv, conn := connectTestServer(t, bulkTestHandler)
+	defer srv.Close()
+	defer conn.Close()
+
+	bulk := conn.CreateBulk("t", []string{"NOTE", "[Name]"})
+	if err := bulk.AddRow([]interface{}{"x", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bulk.AddRowMap(map[string]interface{}{"qty": 1}); err == nil {
+		t.Error("expected an error for a column that is not copied")
+	}
+	if _, err := bulk.Done(); err != nil {
+		t.Fatal(err)
+	}
+	loads := bulkLoads(srv)
+	if len(loads) != 1 || strings.Join(loads[0].Columns, ",") != "note,name" {
+		t.Fatalf("unexpected bulk loads %v", loads)
+	}
+
+	for _, columns := range [][]string{{"name", "total"}, {"missing"}} {
+		bulk = conn.CreateBulk("t", columns)
+		if err := bulk.AddRow([]interface{}{"a", 1}); err == nil {
+			t.Errorf("expected columns %v to be rejected", columns)
+		}
+	}
+}
+
+func TestBulkOptions(t *testing.T) {
+	srv, conn := connectTestServer(t, bulkTestHandler)
+	defer srv.Close()
+	defer conn.Close()
+
+	bulk := conn.CreateBulk("t", nil)
+	bulk.Options = BulkOptions{
+		CheckConstraints:                 true,
+		FireTriggers:                     true,
+		KeepNulls:                        true,
+		KeepIdentity:                     true,
+		AllowEncryptedValueModifications: true,
+		KilobytesPerBatch:                64,
+		RowsPerBatch:                     1000,
+		Order:                            []string{"id ASC"},
+		Tablock:                          true,
+	}
+	if err := bulk.AddRow([]interface{}{7, "a", 1, nil}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bulk.Done(); err != nil {
+		t.Fatal(err)
+	}
+	var insert string
+	for _, req := range srv.Requests() {
+		if strings.HasPrefix(req.SQL, "INSERT BULK") {
+			insert = req.SQL
+		}
+	}
+	const hints = "WITH (CHECK_CONSTRAINTS,FIRE_TRIGGERS,KEEP_NULLS,KEEP_IDENTITY,ALLOW_ENCRYPTED_VALUE_MODIFICATIONS," +
+		"KILOBYTES_PER_BATCH = 64,ROWS_PER_BATCH = 1000,ORDER(id ASC),TABLOCK)"
+	if !strings.HasSuffix(insert, hints) {
+		t.Errorf("expected the hints %s, got %q", hints, insert)
+	}
+	loads := bulkLoads(srv)
+	if len(loads) != 1 || strings.Join(loads[0].Columns, ",") != "id,name,qty,note" {
+		t.Fatalf("expected the identity column to be copied, got %v", loads)
+	}
+	if loads[0].Rows[0][0] != int64(7) {
+		t.Errorf("expected the identity value 7, got %v", loads[0].Rows[0][0])
+	}
+}
+
+func TestBulkRowErrors(t *testing.T) {
+	srv, conn := connectTestServer(t, bulkTestHandler)
+	defer srv.Close()
+	defer conn.Close()
+
+	bulk := conn.CreateBulk("t", []string{"name", "qty"})
+	bulk.Options.MaxErrors = 2
+	rows := [][]interface{}{
+		{"a", 1},
+		{"b", "not a number"},
+		{"c"},
+		{"d", 4},
+		{"e", true},
+	}
+	var err error
+	for _, row := range rows {
+		if err = bulk.AddRow(row); err != nil {
+			break
+		}
+	}
+	rowErr, ok := err.(BulkRowError)
+	if !ok || rowErr.Row != 4 || rowErr.Column != "qty" {
+		t.Fatalf("expected the copy to fail at row 4 column qty, got %v", err)
+	}
+	if !strings.HasPrefix(rowErr.Error(), "bulkcopy: row 4 column qty: ") {
+		t.Errorf("unexpected error message %q", rowErr.Error())
+	}
+	skipped := bulk.RowErrors()
+	if len(skipped) != 2 || skipped[0].Row != 1 || skipped[0].Column != "qty" || skipped[1].Row != 2 || skipped[1].Column != "" {
+		t.Errorf("unexpected skipped rows %v", skipped)
+	}
+	n, err := bulk.Done()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 rows copied, got %d", n)
+	}
+	loads := bulkLoads(srv)
+	expected := [][]interface{}{{"a", int64(1)}, {"d", int64(4)}}
+	if len(loads) != 1 || !reflect.DeepEqual(loads[0].Rows, expected) {
+		t.Errorf("expected rows %v to be copied, got %v", expected, loads)
+	}
+}
+
+// rejectNegativeQty is bulkTestHandler failing the bulk loads of rows with
+// a negative qty, as a CHECK constraint would.
+func rejectNegativeQty(req *mssqltest.Request) []mssqltest.Response {
+	if req.Type == mssqltest.BulkLoad {
+		for _, row := range req.Bulk.Rows {
+			if qty, ok := row[1].(int64); ok && qty < 0 {
+				return []mssqltest.Response{mssqltest.Error{Number: 547, Class: 16, Message: "The INSERT statement conflicted with the CHECK constraint \"ck_qty\"."}}
+			}
+		}
+	}
+	return bulkTestHandler(req)
+}
+
+func TestBulkRejectedRows(t *testing.T) {
+	srv, conn := connectTestServer(t, rejectNegativeQty)
+	defer srv.Close()
+	defer conn.Close()
+
+	bulk := conn.CreateBulk("t", []string{"name", "qty"})
+	bulk.Options.MaxErrors = 3
+	const rows = 1200
+	for i := 0; i < rows; i++ {
+		var qty interface{} = i
+		switch i {
+		case 3, 1100:
+			qty = -1
+		case 5:
+			qty = "x"
+		}
+		if err := bulk.AddRow([]interface{}{fmt.Sprint(i), qty}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := bulk.Done()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != rows-3 {
+		t.Errorf("expected %d rows copied, got %d", rows-3, n)
+	}
+	skipped := bulk.RowErrors()
+	if len(skipped) != 3 || skipped[0].Row != 3 || skipped[1].Row != 5 || skipped[2].Row != 1100 {
+		t.Fatalf("expected rows 3, 5 and 1100 to be skipped, got %v", skipped)
+	}
+	for _, rowErr := range []BulkRowError{skipped[0], skipped[2]} {
+		if sqlErr, ok := rowErr.Err.(Error); !ok || sqlErr.Number != 547 || rowErr.Column != "" {
+			t.Errorf("expected the row to be rejected by the server, got %v", rowErr)
+		}
+	}
+	copied := 0
+	for _, load := range bulkLoads(srv) {
+		if rejectNegativeQty(&mssqltest.Request{Type: mssqltest.BulkLoad, Bulk: load}) == nil {
+			copied += len(load.Rows)
+		}
+	}
+	if copied != rows-3 {
+		t.Errorf("expected the server to keep %d rows, got %d", rows-3, copied)
+	}
+}
+
+func TestBulkRejectedRowsPastMaxErrors(t *testing.T) {
+	srv, conn := connectTestServer(t, rejectNegativeQty)
+	defer srv.Close()
+	defer conn.Close()
+
+	bulk := conn.CreateBulk("t", []string{"name", "qty"})
+	bulk.Options.MaxErrors = 1
+	for i, qty := range []int{1, -2, 3, -4, 5} {
+		if err := bulk.AddRow([]interface{}{fmt.Sprint(i), qty}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := bulk.Done()
+	rowErr, ok := err.(BulkRowError)
+	if !ok || rowErr.Row != 3 {
+		t.Fatalf("expected the copy to fail at row 3, got %v", err)
+	}
+	if sqlErr, ok := rowErr.Err.(Error); !ok || sqlErr.Number != 547 {
+		t.Errorf("expected the error of the server, got %v", rowErr.Err)
+	}
+	if n != 2 {
+		t.Errorf("expected the 2 rows copied before the failure, got %d", n)
+	}
+	if skipped := bulk.RowErrors(); len(skipped) != 1 || skipped[0].Row != 1 {
+		t.Errorf("expected row 1 to be skipped, got %v", skipped)
+	}
+}
+
+func TestBulkRejectedRowsOtherErrors(t *testing.T) {
+	srv, conn := connectTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		if req.Type == mssqltest.BulkLoad {
+			return []mssqltest.Response{mssqltest.Error{Number: 1205, Class: 13, Message: "Transaction was deadlocked"}}
+		}
+		return bulkTestHandler(req)
+	})
+	defer srv.Close()
+	defer conn.Close()
+
+	bulk := conn.CreateBulk("t", []string{"name", "qty"})
+	bulk.Options.MaxErrors = 10
+	for i := 0; i < 4; i++ {
+		if err := bulk.AddRow([]interface{}{fmt.Sprint(i), i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := bulk.Done()
+	if sqlErr, ok := err.(Error); !ok || sqlErr.Number != 1205 {
+		t.Fatalf("expected the deadlock error, got %v", err)
+	}
+	if loads := bulkLoads(srv); len(loads) != 1 {
+		t.Errorf("expected the rows not to be copied again, got %d bulk loads", len(loads))
+	}
+	if skipped := bulk.RowErrors(); len(skipped) != 0 {
+		t.Errorf("expected no row to be skipped, got %v", skipped)
+	}
+}
+
+func TestBulkRejectedRowsInTransaction(t *testing.T) {
+	srv, conn := connectTestServer(t, rejectNegativeQty)
+	defer srv.Close()
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(context.Background(), driver.TxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	bulk := conn.CreateBulk("t", []string{"name", "qty"})
+	bulk.Options.MaxErrors = 10
+	for i, qty := range []interface{}{1, "x", -3, 4} {
+		if err := bulk.AddRow([]interface{}{fmt.Sprint(i), qty}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = bulk.Done()
+	if sqlErr, ok := err.(Error); !ok || sqlErr.Number != 547 {
+		t.Fatalf("expected the constraint violation, got %v", err)
+	}
+	if loads := bulkLoads(srv); len(loads) != 1 {
+		t.Errorf("expected the rows not to be copied again, got %d bulk loads", len(loads))
+	}
+	if skipped := bulk.RowErrors(); len(skipped) != 1 || skipped[0].Row != 1 {
+		t.Errorf("expected only the row that cannot be converted to be skipped, got %v", skipped)
+	}
+}
+
+func TestBulkCopyFrom(t *testing.T) {
+	srv, conn := connectTestServer(t, bulkTestHandler)
+	defer srv.Close()
+	defer conn.Close()
+
+	const count = 5000
+	i := 0
+	src := BulkRowSourceFunc(func() ([]interface{}, error) {
+		if i == count {
+			return nil, io.EOF
+		}
+		i++
+		return []interface{}{fmt.Sprintf("row %d", i), i}, nil
+	})
+	n, err := conn.CreateBulk("t", []string{"name", "qty"}).CopyFrom(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != count {
+		t.Errorf("expected %d rows copied, got %d", count, n)
+	}
+	loads := bulkLoads(srv)
+	if len(loads) != 1 || len(loads[0].Rows) != count {
+		t.Fatalf("expected a bulk load of %d rows", count)
+	}
+	if last := loads[0].Rows[count-1]; last[0] != "row 5000" || last[1] != int64(count) {
+		t.Errorf("unexpected last row %v", last)
+	}
+}
+
+func TestBulkCopyFromAbort(t *testing.T) {
+	srv, conn := connectTestServer(t, bulkTestHandler)
+	defer srv.Close()
+	defer conn.Close()
+
+	errSource := errors.New("source failed")
+	i := 0
+	src := BulkRowSourceFunc(func() ([]interface{}, error) {
+		if i == 1000 {
+			return nil, errSource
+		}
+		i++
+		return []interface{}{"a", i}, nil
+	})
+	if _, err := conn.CreateBulk("t", []string{"name", "qty"}).CopyFrom(src); err != errSource {
+		t.Fatalf("expected the error of the source, got %v", err)
+	}
+	if conn.connectionGood {
+		t.Error("expected the connection to be discarded")
+	}
+	if loads := bulkLoads(srv); len(loads) != 0 {
+		t.Errorf("expected the bulk load to be abandoned, got %d", len(loads))
+	}
+}
+
+func TestBulkStreamedValues(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if strings.HasPrefix(req.SQL, "select * from ") {
+			return []mssqltest.Response{mssqltest.ResultSet{Columns: []mssqltest.Column{
+				{Name: "id", Type: mssqltest.Int},
+				{Name: "doc", Type: mssqltest.NVarChar},
+				{Name: "blob", Type: mssqltest.VarBinary},
+			}}}
+		}
+		return nil
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	doc := strings.Repeat("ab©Ď☀", 5000)
+	blob := bytes.Repeat([]byte{1, 2, 3}, 10000)
+	bulk := conn.(*Conn).CreateBulk("t", nil)
+	// one byte reads split the UTF-8 sequences
+	if err := bulk.AddRow([]interface{}{1, iotest.OneByteReader(strings.NewReader(doc)), bytes.NewReader(blob)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bulk.AddRow([]interface{}{2, strings.NewReader(""), nil}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bulk.Done(); err != nil {
+		t.Fatal(err)
+	}
+	loads := bulkLoads(srv)
+	if len(loads) != 1 || len(loads[0].Rows) != 2 {
+		t.Fatalf("unexpected bulk loads %v", loads)
+	}
+	rows := loads[0].Rows
+	if rows[0][1] != doc {
+		t.Error("the streamed nvarchar(max) value differs")
+	}
+	if !bytes.Equal(rows[0][2].([]byte), blob) {
+		t.Error("the streamed varbinary(max) value differs")
+	}
+	if rows[1][1] != "" || rows[1][2] != nil {
+		t.Errorf("unexpected second row %v", rows[1])
+	}
+}
+
 func compareValue(a interface{}, expected interface{}) bool {
 	if got, ok := a.([]uint8); ok {
 		if _, ok := expected.([]uint8); !ok {
@@ -0,0 +1,141 @@
+package mssql
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ExportBulk runs query, such as "select * from t", and calls fn with the
+// values of every row of its first result set, the counterpart of a bulk
+// copy. Rows are passed to fn as they are read, so a table of any size is
+// exported with bounded memory; row is reused for the next row. An error of
+// fn stops the export and is returned. Values are of the types Rows.Next
+// returns.
+func (cn *Conn) ExportBulk(ctx context.Context, query string, fn func(row []interface{}) error) (rowcount int64, err error) {
+	return cn.exportBulk(ctx, query, func(cols []columnStruct, row []interface{}) error {
+		return fn(row)
+	})
+}
+
+// BulkCharFormat is the character format of bcp -c: fields are written as
+// text, separated by FieldTerminator, and every row ends with RowTerminator.
+// Nulls are written as empty fields and binary values in hexadecimal.
+type BulkCharFormat struct {
+	// FieldTerminator defaults to a tab.
+	FieldTerminator string
+	// RowTerminator defaults to a newline.
+	RowTerminator string
+}
+
+// ExportBulkChar runs query and writes the rows of its first result set to
+// w in the character format f, see ExportBulk.
+func (cn *Conn) ExportBulkChar(ctx context.Context, query string, w io.Writer, f BulkCharFormat) (rowcount int64, err error) {
+	if f.FieldTerminator == "" {
+		f.FieldTerminator = "\t"
+	}
+	if f.RowTerminator == "" {
+		f.RowTerminator = "\n"
+	}
+	bw := bufio.NewWriter(w)
+	var field []byte
+	rowcount, err = cn.exportBulk(ctx, query, func(cols []columnStruct, row []interface{}) error {
+		for i, v := range row {
+			if i > 0 {
+				bw.WriteString(f.FieldTerminator)
+			}
+			field = appendCharField(field[:0], cols[i].ti, v)
+			bw.Write(field)
+		}
+		_, err := bw.WriteString(f.RowTerminator)
+		return err
+	})
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return rowcount, err
+}
+
+func (cn *Conn) exportBulk(ctx context.Context, query string, fn func(cols []columnStruct, row []interface{}) error) (rowcount int64, err error) {
+	stmt, err := cn.prepareContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	r, err := stmt.queryContext(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	rows := r.(*Rows)
+	defer rows.Close()
+	dest := make([]driver.Value, len(rows.cols))
+	row := make([]interface{}, len(rows.cols))
+	for {
+		if err = rows.Next(dest); err == io.EOF {
+			return rowcount, nil
+		}
+		if err != nil {
+			return rowcount, err
+		}
+		for i, v := range dest {
+			row[i] = v
+		}
+		if err = fn(rows.cols, row); err != nil {
+			return rowcount, err
+		}
+		rowcount++
+	}
+}
+
+// appendCharField appends the character format of a value of type ti.
+func appendCharField(b []byte, ti typeInfo, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return b
+	case string:
+		return append(b, v...)
+	case bool:
+		if v {
+			return append(b, '1')
+		}
+		return append(b, '0')
+	case int64:
+		return strconv.AppendInt(b, v, 10)
+	case float64:
+		bits := 64
+		if ti.Size == 4 {
+			bits = 32
+		}
+		return strconv.AppendFloat(b, v, 'g', -1, bits)
+	case []byte:
+		switch makeGoLangTypeName(ti) {
+		case "UNIQUEIDENTIFIER":
+			var u UniqueIdentifier
+			if u.Scan(v) == nil {
+				return append(b, u.String()...)
+			}
+		case "VARBINARY", "BINARY", "IMAGE":
+			return append(b, hex.EncodeToString(v)...)
+		}
+		// decimal, money and XML values
+		return append(b, v...)
+	case time.Time:
+		switch makeGoLangTypeName(ti) {
+		case "DATE":
+			return v.AppendFormat(b, "2006-01-02")
+		case "TIME":
+			return v.AppendFormat(b, "15:04:05.0000000")
+		case "SMALLDATETIME", "DATETIME":
+			return v.AppendFormat(b, "2006-01-02 15:04:05.000")
+		case "DATETIMEOFFSET":
+			return v.AppendFormat(b, "2006-01-02 15:04:05.0000000 -07:00")
+		}
+		return v.AppendFormat(b, "2006-01-02 15:04:05.0000000")
+	}
+	return append(b, fmt.Sprint(v)...)
+}
@@ -0,0 +1,80 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+// exportTestHandler answers every query with the rows of the exported
+// table.
+func exportTestHandler(req *mssqltest.Request) []mssqltest.Response {
+	return []mssqltest.Response{mssqltest.ResultSet{
+		Columns: []mssqltest.Column{
+			{Name: "id", Type: mssqltest.Int},
+			{Name: "name", Type: mssqltest.NVarChar},
+			{Name: "active", Type: mssqltest.Bit},
+			{Name: "score", Type: mssqltest.Float},
+			{Name: "data", Type: mssqltest.VarBinary},
+			{Name: "created", Type: mssqltest.DateTime2},
+			{Name: "guid", Type: mssqltest.UniqueIdentifier},
+		},
+		Rows: [][]interface{}{
+			{1, "a", true, 1.5, []byte{0xca, 0xfe}, time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC),
+				[]byte{0xff, 0x19, 0x96, 0x6f, 0x86, 0x8b, 0x11, 0xd0, 0xb4, 0x2d, 0x00, 0xc0, 0x4f, 0xc9, 0x64, 0xff}},
+			{2, nil, false, nil, nil, nil, nil},
+		},
+	}}
+}
+
+func TestExportBulk(t *testing.T) {
+	srv, conn := connectTestServer(t, exportTestHandler)
+	defer srv.Close()
+	defer conn.Close()
+
+	var ids []int64
+	n, err := conn.ExportBulk(context.Background(), "select * from t", func(row []interface{}) error {
+		ids = append(ids, row[0].(int64))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("expected rows 1 and 2, got %d rows %v", n, ids)
+	}
+
+	errStop := errors.New("stop")
+	n, err = conn.ExportBulk(context.Background(), "select * from t", func(row []interface{}) error {
+		return errStop
+	})
+	if err != errStop || n != 0 {
+		t.Errorf("expected the export to stop at the first row, got %d rows and %v", n, err)
+	}
+}
+
+func TestExportBulkChar(t *testing.T) {
+	srv, conn := connectTestServer(t, exportTestHandler)
+	defer srv.Close()
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	n, err := conn.ExportBulkChar(context.Background(), "select * from t", &buf, BulkCharFormat{FieldTerminator: ","})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 rows, got %d", n)
+	}
+	const expected = "1,a,1,1.5,cafe,2020-01-02 03:04:05.6000000,6F9619FF-8B86-D011-B42D-00C04FC964FF\n" +
+		"2,,0,,,,\n"
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
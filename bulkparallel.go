@@ -0,0 +1,190 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ParallelBulkOptions configures Connector.ParallelBulkCopy.
+type ParallelBulkOptions struct {
+	BulkOptions
+	// Workers is the number of connections loading rows concurrently,
+	// 4 by default.
+	Workers int
+}
+
+// ParallelBulkCopy copies the rows of src to table over several
+// connections of the connector, each copying its share of the rows with
+// INSERT BULK in a transaction. The transactions are committed once all
+// the connections copied their rows, and rolled back if any of them fails,
+// it returns the number of rows copied. The columns are given as with
+// Conn.CreateBulk.
+//
+// TABLOCK is added to the options when table is a heap: the bulk update
+// locks of concurrent loads of a heap are compatible, and allow minimal
+// logging. On tables with indexes the table locks would serialize the
+// loads, Options.Tablock is left as set.
+//
+// src is only read by one goroutine, it may reuse the slice of the values.
+// An error of src or of a row aborts the copy, see Bulk.CopyFrom. With
+// Options.MaxErrors, only the rows that cannot be converted are skipped,
+// the rows the server rejects fail the copy.
+func (c *Connector) ParallelBulkCopy(ctx context.Context, table string, columns []string, opts ParallelBulkOptions, src BulkRowSource) (rowcount int64, err error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	loads := make([]*parallelLoad, workers)
+	defer func() {
+		for _, l := range loads {
+			if l != nil {
+				l.conn.Close()
+			}
+		}
+	}()
+	for i := range loads {
+		conn, err := c.Connect(ctx)
+		if err != nil {
+			return 0, err
+		}
+		loads[i] = &parallelLoad{conn: conn.(*Conn)}
+	}
+	heap, err := loads[0].conn.isHeap(ctx, table)
+	if err != nil {
+		return 0, err
+	}
+	options := opts.BulkOptions
+	if heap {
+		options.Tablock = true
+	}
+	for _, l := range loads {
+		if l.tx, err = l.conn.BeginTx(ctx, driver.TxOptions{}); err != nil {
+			return 0, err
+		}
+		l.bulk = l.conn.CreateBulkContext(ctx, table, columns)
+		l.bulk.Options = options
+	}
+
+	rows := make(chan []interface{}, workers)
+	var wg sync.WaitGroup
+	for _, l := range loads {
+		wg.Add(1)
+		go func(l *parallelLoad) {
+			defer wg.Done()
+			if l.err = l.copy(ctx, rows); l.err != nil {
+				cancel()
+			}
+		}(l)
+	}
+	srcErr := dispatchRows(ctx, src, rows)
+	if srcErr != nil {
+		cancel()
+	}
+	wg.Wait()
+
+	if err = srcErr; err == nil {
+		for _, l := range loads {
+			if l.err != nil && l.err != context.Canceled {
+				err = l.err
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	for _, l := range loads {
+		if l.err != nil || !l.conn.connectionGood {
+			// aborted, the server rolled back the transaction
+			continue
+		}
+		if err != nil {
+			l.tx.Rollback()
+			continue
+		}
+		if err = l.tx.Commit(); err != nil {
+			return rowcount, fmt.Errorf("mssql: parallel bulk copy committed %d rows before failing: %v", rowcount, err)
+		}
+		rowcount += l.rowcount
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rowcount, nil
+}
+
+// parallelLoad is the copy of a connection of a parallel bulk copy.
+type parallelLoad struct {
+	conn     *Conn
+	tx       driver.Tx
+	bulk     *Bulk
+	rowcount int64
+	err      error
+}
+
+// copy copies the rows received from rows until it is closed.
+func (l *parallelLoad) copy(ctx context.Context, rows <-chan []interface{}) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return l.bulk.abort(ctx.Err())
+		case row, ok := <-rows:
+			if !ok {
+				var err error
+				l.rowcount, err = l.bulk.Done()
+				return err
+			}
+			if err := l.bulk.AddRow(row); err != nil {
+				return l.bulk.abort(err)
+			}
+		}
+	}
+}
+
+// dispatchRows sends copies of the rows of src to rows, and closes it once
+// src is exhausted.
+func dispatchRows(ctx context.Context, src BulkRowSource, rows chan<- []interface{}) error {
+	defer close(rows)
+	for {
+		row, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case rows <- append([]interface{}(nil), row...):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// isHeap reports whether table has no clustered index nor any other index.
+func (c *Conn) isHeap(ctx context.Context, table string) (bool, error) {
+	stmt, err := c.prepareContext(ctx, "select count(*) from sys.indexes where object_id = object_id(@p1) and type <> 0")
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+	r, err := stmt.queryContext(ctx, []namedValue{{Name: "p1", Ordinal: 1, Value: table}})
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	dest := make([]driver.Value, 1)
+	if err = r.Next(dest); err != nil {
+		return false, err
+	}
+	n, _ := dest[0].(int64)
+	return n == 0, nil
+}
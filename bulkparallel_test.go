@@ -0,0 +1,112 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+// parallelBulkTestHandler answers the queries of a parallel bulk copy to
+// a table with the given number of indexes.
+func parallelBulkTestHandler(indexes int) mssqltest.Handler {
+	return func(req *mssqltest.Request) []mssqltest.Response {
+		switch {
+		case strings.Contains(req.SQL, "sys.indexes"):
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "", Type: mssqltest.Int}},
+				Rows:    [][]interface{}{{indexes}},
+			}}
+		case strings.HasPrefix(req.SQL, "select * from "):
+			return []mssqltest.Response{mssqltest.ResultSet{Columns: bulkTestTable}}
+		}
+		return nil
+	}
+}
+
+// countRequests returns the number of requests of srv of type typ.
+func countRequests(srv *mssqltest.Server, typ mssqltest.RequestType) int {
+	n := 0
+	for _, req := range srv.Requests() {
+		if req.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func TestParallelBulkCopy(t *testing.T) {
+	srv, c := startTestServer(t, parallelBulkTestHandler(0))
+	defer srv.Close()
+
+	const count = 1000
+	i := 0
+	row := make([]interface{}, 2)
+	src := BulkRowSourceFunc(func() ([]interface{}, error) {
+		if i == count {
+			return nil, io.EOF
+		}
+		i++
+		// the slice is reused like the rows of a streaming source
+		row[0], row[1] = "a", i
+		return row, nil
+	})
+	n, err := c.ParallelBulkCopy(context.Background(), "t", []string{"name", "qty"}, ParallelBulkOptions{Workers: 3}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != count {
+		t.Errorf("expected %d rows copied, got %d", count, n)
+	}
+
+	seen := make(map[int64]bool)
+	for _, load := range bulkLoads(srv) {
+		for _, r := range load.Rows {
+			seen[r[1].(int64)] = true
+		}
+	}
+	if len(seen) != count {
+		t.Errorf("expected %d distinct rows loaded, got %d", count, len(seen))
+	}
+	for _, req := range srv.Requests() {
+		if strings.HasPrefix(req.SQL, "INSERT BULK") && !strings.Contains(req.SQL, "TABLOCK") {
+			t.Errorf("expected TABLOCK for a heap, got %q", req.SQL)
+		}
+	}
+	if begins, commits := countRequests(srv, mssqltest.BeginTran), countRequests(srv, mssqltest.CommitTran); begins != 3 || commits != 3 {
+		t.Errorf("expected 3 transactions committed, got %d begun and %d committed", begins, commits)
+	}
+}
+
+func TestParallelBulkCopyRollback(t *testing.T) {
+	srv, c := startTestServer(t, parallelBulkTestHandler(1))
+	defer srv.Close()
+
+	i := 0
+	src := BulkRowSourceFunc(func() ([]interface{}, error) {
+		if i == 500 {
+			return nil, io.EOF
+		}
+		i++
+		if i == 250 {
+			return []interface{}{"a", "not a number"}, nil
+		}
+		return []interface{}{"a", i}, nil
+	})
+	_, err := c.ParallelBulkCopy(context.Background(), "t", []string{"name", "qty"}, ParallelBulkOptions{Workers: 2}, src)
+	if _, ok := err.(BulkRowError); !ok {
+		t.Fatalf("expected the row error, got %v", err)
+	}
+	if commits := countRequests(srv, mssqltest.CommitTran); commits != 0 {
+		t.Errorf("expected no transaction to be committed, got %d", commits)
+	}
+	for _, req := range srv.Requests() {
+		if strings.HasPrefix(req.SQL, "INSERT BULK") && strings.Contains(req.SQL, "TABLOCK") {
+			t.Errorf("expected no TABLOCK for an indexed table, got %q", req.SQL)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package mssql
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Always Encrypted protects values with AEAD_AES_256_CBC_HMAC_SHA256, the
+// only algorithm supported by SQL Server:
+// https://docs.microsoft.com/en-us/sql/relational-databases/security/encryption/always-encrypted-cryptography
+const (
+	cipherAlgorithmCustom = 0
+	cipherAlgorithmAEAD   = 2
+
+	encryptionTypePlaintext     = 0
+	encryptionTypeDeterministic = 1
+	encryptionTypeRandomized    = 2
+
+	// normalizationVersion is the version of the rules that serialize
+	// plaintext values
+	normalizationVersion = 1
+
+	cellVersion = 0x01
+	// cellHeaderSize is the size of the version byte, the MAC and the IV
+	// that precede the ciphertext
+	cellHeaderSize = 1 + sha256.Size + aes.BlockSize
+)
+
+var errCellAuthentication = errors.New("mssql: the authentication tag of an encrypted value does not match, the value or its column encryption key is wrong")
+
+// cellKeys are the keys derived from a column encryption key.
+type cellKeys struct {
+	enc, mac, iv []byte
+}
+
+func deriveCellKeys(cek []byte) (cellKeys, error) {
+	if len(cek) != 32 {
+		return cellKeys{}, fmt.Errorf("mssql: column encryption key has %d bytes, expected 32", len(cek))
+	}
+	derive := func(purpose string) []byte {
+		h := hmac.New(sha256.New, cek)
+		h.Write(str2ucs2("Microsoft SQL Server cell " + purpose + " key with encryption algorithm:AEAD_AES_256_CBC_HMAC_SHA256 and key length:256"))
+		return h.Sum(nil)
+	}
+	return cellKeys{enc: derive("encryption"), mac: derive("MAC"), iv: derive("IV")}, nil
+}
+
+func (k cellKeys) tag(iv, ciphertext []byte) []byte {
+	h := hmac.New(sha256.New, k.mac)
+	h.Write([]byte{cellVersion})
+	h.Write(iv)
+	h.Write(ciphertext)
+	h.Write([]byte{1}) // length of the version
+	return h.Sum(nil)
+}
+
+// encryptCell encrypts plaintext with the column encryption key cek. The
+// IV of deterministic encryption is derived from the plaintext, so equal
+// values have equal ciphertexts.
+func encryptCell(cek, plaintext []byte, deterministic bool) ([]byte, error) {
+	k, err := deriveCellKeys(cek)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if deterministic {
+		h := hmac.New(sha256.New, k.iv)
+		h.Write(plaintext)
+		copy(iv, h.Sum(nil))
+	} else if _, err = rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k.enc)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	cell := make([]byte, cellHeaderSize, cellHeaderSize+len(plaintext)+pad)
+	cell = append(cell, plaintext...)
+	cell = append(cell, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	ciphertext := cell[cellHeaderSize:]
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	cell[0] = cellVersion
+	copy(cell[1:], k.tag(iv, ciphertext))
+	copy(cell[1+sha256.Size:], iv)
+	return cell, nil
+}
+
+// decryptCell authenticates and decrypts a value encrypted with cek.
+func decryptCell(cek, cell []byte) ([]byte, error) {
+	k, err := deriveCellKeys(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(cell) < cellHeaderSize+aes.BlockSize || (len(cell)-cellHeaderSize)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("mssql: encrypted value has an invalid length %d", len(cell))
+	}
+	if cell[0] != cellVersion {
+		return nil, fmt.Errorf("mssql: encrypted value has an unsupported version %d", cell[0])
+	}
+	iv, ciphertext := cell[1+sha256.Size:cellHeaderSize], cell[cellHeaderSize:]
+	if !hmac.Equal(cell[1:1+sha256.Size], k.tag(iv, ciphertext)) {
+		return nil, errCellAuthentication
+	}
+	block, err := aes.NewCipher(k.enc)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	pad := int(plaintext[len(plaintext)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errCellAuthentication
+	}
+	return plaintext[:len(plaintext)-pad], nil
+}
+
+// normalizeCell serializes a parameter value of type ti, encoded as on the
+// wire in buf, to the plaintext that is encrypted: integers and bits take 8
+// bytes and decimals 17, other types keep their wire encoding.
+func normalizeCell(ti typeInfo, buf []byte) []byte {
+	switch ti.TypeId {
+	case typeInt1, typeInt2, typeInt4, typeInt8, typeIntN, typeBit, typeBitN:
+		var v int64
+		switch len(buf) {
+		case 1:
+			v = int64(buf[0])
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(buf)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(buf)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(buf))
+		}
+		res := make([]byte, 8)
+		binary.LittleEndian.PutUint64(res, uint64(v))
+		return res
+	case typeDecimal, typeDecimalN, typeNumeric, typeNumericN:
+		// the sign and a 16 bytes magnitude
+		res := make([]byte, 17)
+		copy(res, buf)
+		return res
+	}
+	return buf
+}
+
+// decodeCell decodes the plaintext of a value of type ti, serialized as by
+// normalizeCell.
+func decodeCell(ti typeInfo, buf []byte) (interface{}, error) {
+	switch ti.TypeId {
+	case typeInt1, typeInt2, typeInt4, typeInt8, typeIntN:
+		if len(buf) == 8 {
+			return int64(binary.LittleEndian.Uint64(buf)), nil
+		}
+	case typeBit, typeBitN:
+		if len(buf) == 8 {
+			return binary.LittleEndian.Uint64(buf) != 0, nil
+		}
+	case typeFlt4, typeFlt8, typeFltN:
+		switch len(buf) {
+		case 4:
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(buf))), nil
+		case 8:
+			return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+		}
+	case typeMoney, typeMoney4, typeMoneyN:
+		switch len(buf) {
+		case 4:
+			return decodeMoney4(buf), nil
+		case 8:
+			return decodeMoney(buf), nil
+		}
+	case typeDecimal, typeDecimalN, typeNumeric, typeNumericN:
+		if len(buf) == 17 {
+			return decodeDecimal(ti.Prec, ti.Scale, buf), nil
+		}
+	case typeDateTim4:
+		if len(buf) == 4 {
+			return decodeDateTim4(buf), nil
+		}
+	case typeDateTime, typeDateTimeN:
+		switch len(buf) {
+		case 4:
+			return decodeDateTim4(buf), nil
+		case 8:
+			return decodeDateTime(buf), nil
+		}
+	case typeDateN:
+		if len(buf) == 3 {
+			return decodeDate(buf), nil
+		}
+	case typeTimeN:
+		return decodeTime(ti.Scale, buf), nil
+	case typeDateTime2N:
+		return decodeDateTime2(ti.Scale, buf), nil
+	case typeDateTimeOffsetN:
+		return decodeDateTimeOffset(ti.Scale, buf), nil
+	case typeGuid:
+		if len(buf) == 16 {
+			return decodeGuid(buf), nil
+		}
+	case typeBigVarChar, typeBigChar, typeVarChar, typeChar, typeText:
+		return decodeChar(ti.Collation, buf), nil
+	case typeNVarChar, typeNChar, typeNText:
+		return ucs22str(buf)
+	case typeBigVarBin, typeBigBinary, typeVarBinary, typeBinary, typeImage:
+		return buf, nil
+	}
+	return nil, fmt.Errorf("mssql: cannot decode a decrypted value of type 0x%x and size %d", ti.TypeId, len(buf))
+}
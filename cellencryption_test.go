@@ -0,0 +1,74 @@
+package mssql
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testCellKey = bytes.Repeat([]byte{0x42}, 32)
+
+func TestCellEncryptionRoundTrip(t *testing.T) {
+	for _, deterministic := range []bool{false, true} {
+		plaintext := []byte("sensitive")
+		cell1, err := encryptCell(testCellKey, plaintext, deterministic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cell2, err := encryptCell(testCellKey, plaintext, deterministic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(cell1, cell2) != deterministic {
+			t.Errorf("deterministic=%v: ciphertexts equal=%v", deterministic, bytes.Equal(cell1, cell2))
+		}
+		res, err := decryptCell(testCellKey, cell1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, plaintext) {
+			t.Errorf("deterministic=%v: got %q", deterministic, res)
+		}
+	}
+}
+
+func TestCellEncryptionTamper(t *testing.T) {
+	cell, err := encryptCell(testCellKey, []byte("sensitive"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell[len(cell)-1] ^= 1
+	if _, err = decryptCell(testCellKey, cell); err != errCellAuthentication {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+	cell[len(cell)-1] ^= 1
+	if _, err = decryptCell(bytes.Repeat([]byte{0x43}, 32), cell); err != errCellAuthentication {
+		t.Errorf("expected an authentication error with the wrong key, got %v", err)
+	}
+	if _, err = decryptCell(testCellKey, cell[:cellHeaderSize]); err == nil {
+		t.Error("expected an error for a truncated value")
+	}
+}
+
+func TestNormalizeCell(t *testing.T) {
+	ti := typeInfo{TypeId: typeIntN, Size: 4}
+	buf := normalizeCell(ti, []byte{0xfe, 0xff, 0xff, 0xff})
+	if len(buf) != 8 {
+		t.Fatalf("expected 8 bytes, got %d", len(buf))
+	}
+	v, err := decodeCell(ti, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != int64(-2) {
+		t.Errorf("got %v", v)
+	}
+
+	ti = typeInfo{TypeId: typeNVarChar, Size: 20}
+	v, err = decodeCell(ti, normalizeCell(ti, str2ucs2("abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "abc" {
+		t.Errorf("got %v", v)
+	}
+}
@@ -0,0 +1,126 @@
+package changetracking
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CDC column names added to the captured columns.
+const (
+	cdcStartLSN   = "__$start_lsn"
+	cdcSeqVal     = "__$seqval"
+	cdcOperation  = "__$operation"
+	cdcUpdateMask = "__$update_mask"
+)
+
+// PollCDC returns the changes of a capture instance made since the last
+// committed batch, using cdc.fn_cdc_get_all_changes_<instance>. It returns
+// a *ResyncError when they are not available.
+func (t *Tracker) PollCDC(ctx context.Context, captureInstance string) (*Batch, error) {
+	key := "cdc:" + captureInstance
+	pos, err := t.Store.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := t.DB.BeginTx(ctx, t.TxOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var minLSN, maxLSN []byte
+	err = queryRow(ctx, tx, "SELECT sys.fn_cdc_get_min_lsn(@p1), sys.fn_cdc_get_max_lsn()",
+		[]interface{}{captureInstance}, &minLSN, &maxLSN)
+	if err != nil {
+		return nil, err
+	}
+	if len(minLSN) == 0 || isZero(minLSN) {
+		return nil, fmt.Errorf("changetracking: capture instance %s does not exist", captureInstance)
+	}
+	if pos == nil {
+		return nil, &ResyncError{Key: key, MinValid: minLSN}
+	}
+	var from []byte
+	if err = queryRow(ctx, tx, "SELECT sys.fn_cdc_increment_lsn(@p1)", []interface{}{pos}, &from); err != nil {
+		return nil, err
+	}
+	if bytes.Compare(from, minLSN) < 0 {
+		return nil, &ResyncError{Key: key, Position: pos, MinValid: minLSN}
+	}
+	batch := &Batch{Key: key, Position: pos}
+	if bytes.Compare(from, maxLSN) > 0 {
+		// nothing was captured since the last poll
+		return batch, tx.Commit()
+	}
+	if strings.ContainsAny(captureInstance, "[]") {
+		return nil, errors.New("changetracking: invalid capture instance name")
+	}
+	query := fmt.Sprintf("SELECT * FROM cdc.[fn_cdc_get_all_changes_%s](@p1, @p2, N'all') ORDER BY %s, %s",
+		captureInstance, quoteName(cdcStartLSN), quoteName(cdcSeqVal))
+	rows, err := tx.QueryContext(ctx, query, from, maxLSN)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	err = scanChanges(rows, func(vals map[string]interface{}) (Change, error) {
+		var c Change
+		c.LSN, _ = vals[cdcStartLSN].([]byte)
+		c.SeqVal, _ = vals[cdcSeqVal].([]byte)
+		switch op, _ := vals[cdcOperation].(int64); op {
+		case 1:
+			c.Operation = Delete
+		case 2:
+			c.Operation = Insert
+		case 4:
+			c.Operation = Update
+		default:
+			return c, fmt.Errorf("changetracking: unexpected CDC operation %d", op)
+		}
+		for _, name := range []string{cdcStartLSN, cdcSeqVal, cdcOperation, cdcUpdateMask} {
+			delete(vals, name)
+		}
+		c.Values = vals
+		return c, nil
+	}, &batch.Changes)
+	if err != nil {
+		return nil, err
+	}
+	batch.Position = maxLSN
+	return batch, tx.Commit()
+}
+
+// ResyncCDC reloads the source table of a capture instance after a
+// *ResyncError. See Resync.
+func (t *Tracker) ResyncCDC(ctx context.Context, captureInstance string, load func(ctx context.Context, q Querier) error) error {
+	tx, err := t.DB.BeginTx(ctx, t.TxOptions)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var maxLSN []byte
+	if err = queryRow(ctx, tx, "SELECT sys.fn_cdc_get_max_lsn()", nil, &maxLSN); err != nil {
+		return err
+	}
+	if len(maxLSN) == 0 {
+		return errors.New("changetracking: change data capture is not enabled for the database")
+	}
+	if err = load(ctx, tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	return t.Store.Save(ctx, "cdc:"+captureInstance, maxLSN)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,424 @@
+// Package changetracking polls SQL Server change tracking and change data
+// capture for incremental changes.
+//
+// A Tracker remembers the last synchronized position of every table in a
+// Store and returns the changes made since then:
+//
+//	tr := &changetracking.Tracker{DB: db, Store: store}
+//	for {
+//		batch, err := tr.Poll(ctx, changetracking.Table{Name: "dbo.Orders"})
+//		if _, ok := err.(*changetracking.ResyncError); ok {
+//			// changes were cleaned up before they were synchronized,
+//			// reload the whole table
+//			err = tr.Resync(ctx, "dbo.Orders", reload)
+//			continue
+//		}
+//		...
+//		for _, c := range batch.Changes {
+//			var o Order
+//			err = c.Decode(&o)
+//			...
+//		}
+//		err = tr.Commit(ctx, batch)
+//	}
+//
+// CDC works the same way for capture instances.
+package changetracking
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Operation is the kind of a change.
+type Operation int
+
+const (
+	Insert Operation = iota + 1
+	Update
+	Delete
+)
+
+func (o Operation) String() string {
+	switch o {
+	case Insert:
+		return "Insert"
+	case Update:
+		return "Update"
+	case Delete:
+		return "Delete"
+	}
+	return fmt.Sprintf("Operation(%d)", int(o))
+}
+
+// Change is a changed row.
+type Change struct {
+	Operation Operation
+	// Version is the change tracking version of the change, it is zero for
+	// CDC changes.
+	Version int64
+	// LSN and SeqVal order CDC changes, they are nil for change tracking.
+	LSN    []byte
+	SeqVal []byte
+	// Values holds the row by column name. For change tracking deletes it
+	// only holds the primary key.
+	Values map[string]interface{}
+}
+
+// Batch is the result of a poll.
+type Batch struct {
+	// Key identifies the table or capture instance in the Store.
+	Key     string
+	Changes []Change
+	// Position is stored by Tracker.Commit once the changes were applied.
+	Position []byte
+}
+
+// Store keeps the last synchronized position of each table.
+type Store interface {
+	// Load returns the stored position or nil when there is none.
+	Load(ctx context.Context, key string) ([]byte, error)
+	Save(ctx context.Context, key string, pos []byte) error
+}
+
+// MemoryStore is a Store that keeps positions in memory.
+type MemoryStore struct {
+	mu  sync.Mutex
+	pos map[string][]byte
+}
+
+func (s *MemoryStore) Load(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pos[key], nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, key string, pos []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pos == nil {
+		s.pos = make(map[string][]byte)
+	}
+	s.pos[key] = append([]byte(nil), pos...)
+	return nil
+}
+
+// TableStore is a Store that keeps positions in a database table with the
+// columns sync_key nvarchar(450) primary key and position varbinary(16).
+type TableStore struct {
+	DB *sql.DB
+	// Table is the name of the table, used verbatim.
+	Table string
+}
+
+// CreateTable creates the table of the store unless it exists.
+func (s *TableStore) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf("IF OBJECT_ID(@p1, 'U') IS NULL "+
+		"CREATE TABLE %s (sync_key NVARCHAR(450) NOT NULL PRIMARY KEY, position VARBINARY(16) NOT NULL)", s.Table), s.Table)
+	return err
+}
+
+func (s *TableStore) Load(ctx context.Context, key string) ([]byte, error) {
+	var pos []byte
+	err := s.DB.QueryRowContext(ctx, "SELECT position FROM "+s.Table+" WHERE sync_key = @p1", key).Scan(&pos)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return pos, err
+}
+
+func (s *TableStore) Save(ctx context.Context, key string, pos []byte) error {
+	_, err := s.DB.ExecContext(ctx, "UPDATE "+s.Table+" SET position = @p2 WHERE sync_key = @p1; "+
+		"IF @@ROWCOUNT = 0 INSERT INTO "+s.Table+" (sync_key, position) VALUES (@p1, @p2)", key, pos)
+	return err
+}
+
+// ResyncError is returned by Poll when changes the caller has not seen yet
+// were already removed by the cleanup of the server, or when the table was
+// never synchronized. The caller reloads the table with Tracker.Resync.
+type ResyncError struct {
+	Key string
+	// Position is the last synchronized position, nil if there is none.
+	Position []byte
+	// MinValid is the oldest position the server still has changes for.
+	MinValid []byte
+}
+
+func (e *ResyncError) Error() string {
+	if e.Position == nil {
+		return fmt.Sprintf("changetracking: %s was never synchronized", e.Key)
+	}
+	return fmt.Sprintf("changetracking: changes of %s since %x were cleaned up, the oldest available position is %x",
+		e.Key, e.Position, e.MinValid)
+}
+
+// Querier runs queries, it is implemented by *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Tracker polls changes.
+type Tracker struct {
+	DB    *sql.DB
+	Store Store
+	// TxOptions are used for the transactions of Poll and Resync. Snapshot
+	// isolation gives consistent results and is recommended by the
+	// documentation of change tracking.
+	TxOptions *sql.TxOptions
+}
+
+// Table selects a table with change tracking enabled.
+type Table struct {
+	// Name is the table name, optionally schema qualified.
+	Name string
+	// Keys are the primary key columns. They are read from the catalog
+	// when empty.
+	Keys []string
+	// Columns are the columns returned for inserts and updates, all
+	// columns are returned when empty.
+	Columns []string
+}
+
+func encodeVersion(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func decodeVersion(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, errors.New("changetracking: invalid stored version")
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+// Poll returns the changes of the table made since the last committed
+// batch. It returns a *ResyncError when they are not available.
+func (t *Tracker) Poll(ctx context.Context, tbl Table) (*Batch, error) {
+	pos, err := t.Store.Load(ctx, tbl.Name)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := t.DB.BeginTx(ctx, t.TxOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var cur, minValid sql.NullInt64
+	err = queryRow(ctx, tx, "SELECT CHANGE_TRACKING_CURRENT_VERSION(), CHANGE_TRACKING_MIN_VALID_VERSION(OBJECT_ID(@p1))",
+		[]interface{}{tbl.Name}, &cur, &minValid)
+	if err != nil {
+		return nil, err
+	}
+	if !cur.Valid || !minValid.Valid {
+		return nil, fmt.Errorf("changetracking: change tracking is not enabled for %s", tbl.Name)
+	}
+	if pos == nil {
+		return nil, &ResyncError{Key: tbl.Name, MinValid: encodeVersion(minValid.Int64)}
+	}
+	last, err := decodeVersion(pos)
+	if err != nil {
+		return nil, err
+	}
+	if last < minValid.Int64 {
+		return nil, &ResyncError{Key: tbl.Name, Position: pos, MinValid: encodeVersion(minValid.Int64)}
+	}
+
+	keys := tbl.Keys
+	if len(keys) == 0 {
+		if keys, err = primaryKey(ctx, tx, tbl.Name); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := tx.QueryContext(ctx, changesQuery(tbl, keys), last, cur.Int64)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	batch := &Batch{Key: tbl.Name, Position: encodeVersion(cur.Int64)}
+	err = scanChanges(rows, func(vals map[string]interface{}) (Change, error) {
+		var c Change
+		c.Version, _ = vals["SYS_CHANGE_VERSION"].(int64)
+		switch op, _ := vals["SYS_CHANGE_OPERATION"].(string); strings.TrimSpace(op) {
+		case "I":
+			c.Operation = Insert
+		case "U":
+			c.Operation = Update
+		case "D":
+			c.Operation = Delete
+		default:
+			return c, fmt.Errorf("changetracking: unknown change operation %q", op)
+		}
+		delete(vals, "SYS_CHANGE_VERSION")
+		delete(vals, "SYS_CHANGE_OPERATION")
+		if c.Operation == Delete {
+			// the joined row no longer exists, keep the key only
+			for name := range vals {
+				if !containsFold(keys, name) {
+					delete(vals, name)
+				}
+			}
+		}
+		c.Values = vals
+		return c, nil
+	}, &batch.Changes)
+	if err != nil {
+		return nil, err
+	}
+	return batch, tx.Commit()
+}
+
+func changesQuery(tbl Table, keys []string) string {
+	var b strings.Builder
+	b.WriteString("SELECT CT.SYS_CHANGE_VERSION, CT.SYS_CHANGE_OPERATION")
+	for _, k := range keys {
+		b.WriteString(", CT.")
+		b.WriteString(quoteName(k))
+	}
+	if len(tbl.Columns) == 0 {
+		b.WriteString(", T.*")
+	}
+	for _, c := range tbl.Columns {
+		if containsFold(keys, c) {
+			continue
+		}
+		b.WriteString(", T.")
+		b.WriteString(quoteName(c))
+	}
+	fmt.Fprintf(&b, " FROM CHANGETABLE(CHANGES %s, @p1) AS CT LEFT OUTER JOIN %s AS T ON ", tbl.Name, tbl.Name)
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(" AND ")
+		}
+		fmt.Fprintf(&b, "T.%s = CT.%s", quoteName(k), quoteName(k))
+	}
+	b.WriteString(" WHERE CT.SYS_CHANGE_VERSION <= @p2 ORDER BY CT.SYS_CHANGE_VERSION")
+	return b.String()
+}
+
+func primaryKey(ctx context.Context, q Querier, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT c.name FROM sys.indexes i "+
+		"JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id "+
+		"JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id "+
+		"WHERE i.is_primary_key = 1 AND i.object_id = OBJECT_ID(@p1) ORDER BY ic.key_ordinal", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("changetracking: %s has no primary key", table)
+	}
+	return keys, nil
+}
+
+// Commit stores the position of the batch, the next Poll returns the
+// changes made after it.
+func (t *Tracker) Commit(ctx context.Context, b *Batch) error {
+	return t.Store.Save(ctx, b.Key, b.Position)
+}
+
+// Resync reloads a table after a *ResyncError. The current version is
+// taken in the same transaction load runs in and stored once load returns
+// without an error, so that no change is lost in between.
+func (t *Tracker) Resync(ctx context.Context, table string, load func(ctx context.Context, q Querier) error) error {
+	tx, err := t.DB.BeginTx(ctx, t.TxOptions)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var cur sql.NullInt64
+	if err = queryRow(ctx, tx, "SELECT CHANGE_TRACKING_CURRENT_VERSION()", nil, &cur); err != nil {
+		return err
+	}
+	if !cur.Valid {
+		return errors.New("changetracking: change tracking is not enabled for the database")
+	}
+	if err = load(ctx, tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	return t.Store.Save(ctx, table, encodeVersion(cur.Int64))
+}
+
+func queryRow(ctx context.Context, q Querier, query string, args []interface{}, dest ...interface{}) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// scanChanges reads every row into a map by column name and converts it
+// with conv.
+func scanChanges(rows *sql.Rows, conv func(map[string]interface{}) (Change, error), changes *[]Change) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	vals := make([]interface{}, len(cols))
+	for rows.Next() {
+		for i := range vals {
+			vals[i] = new(interface{})
+		}
+		if err = rows.Scan(vals...); err != nil {
+			return err
+		}
+		m := make(map[string]interface{}, len(cols))
+		for i, name := range cols {
+			// the key columns of CHANGETABLE come first, keep them when
+			// the joined row repeats the name
+			if _, ok := m[name]; !ok {
+				m[name] = *(vals[i].(*interface{}))
+			}
+		}
+		c, err := conv(m)
+		if err != nil {
+			return err
+		}
+		*changes = append(*changes, c)
+	}
+	return rows.Err()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteName(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
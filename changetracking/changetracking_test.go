@@ -0,0 +1,195 @@
+package changetracking
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func open(t *testing.T, handler mssqltest.Handler) (*sql.DB, *mssqltest.Server) {
+	srv := mssqltest.NewServer(handler)
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return db, srv
+}
+
+func versions(cur, minValid int64) mssqltest.ResultSet {
+	return mssqltest.ResultSet{
+		Columns: []mssqltest.Column{{Type: mssqltest.BigInt}, {Type: mssqltest.BigInt}},
+		Rows:    [][]interface{}{{cur, minValid}},
+	}
+}
+
+func TestPoll(t *testing.T) {
+	var changesSQL string
+	db, srv := open(t, func(req *mssqltest.Request) []mssqltest.Response {
+		switch {
+		case strings.HasPrefix(req.SQL, "SELECT CHANGE_TRACKING_CURRENT_VERSION(), "):
+			return []mssqltest.Response{versions(12, 3)}
+		case req.SQL == "SELECT CHANGE_TRACKING_CURRENT_VERSION()":
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Type: mssqltest.BigInt}},
+				Rows:    [][]interface{}{{int64(12)}},
+			}}
+		case strings.HasPrefix(req.SQL, "SELECT c.name FROM sys.indexes"):
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "name", Type: mssqltest.NVarChar}},
+				Rows:    [][]interface{}{{"ID"}},
+			}}
+		case strings.HasPrefix(req.SQL, "SELECT CT.SYS_CHANGE_VERSION"):
+			changesSQL = req.SQL
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{
+					{Name: "SYS_CHANGE_VERSION", Type: mssqltest.BigInt},
+					{Name: "SYS_CHANGE_OPERATION", Type: mssqltest.NVarChar},
+					{Name: "ID", Type: mssqltest.Int},
+					{Name: "ID", Type: mssqltest.Int},
+					{Name: "Name", Type: mssqltest.NVarChar},
+				},
+				Rows: [][]interface{}{
+					{int64(8), "I", int64(1), int64(1), "first"},
+					{int64(11), "D", int64(2), nil, nil},
+				},
+			}}
+		}
+		return []mssqltest.Response{mssqltest.RowsAffected(0)}
+	})
+	defer srv.Close()
+	defer db.Close()
+	ctx := context.Background()
+	store := &MemoryStore{}
+	tr := &Tracker{DB: db, Store: store}
+
+	_, err := tr.Poll(ctx, Table{Name: "dbo.Orders"})
+	if rerr, ok := err.(*ResyncError); !ok || rerr.Position != nil {
+		t.Fatalf("expected a resync error for an unsynchronized table, got %v", err)
+	}
+	reloaded := false
+	err = tr.Resync(ctx, "dbo.Orders", func(ctx context.Context, q Querier) error {
+		reloaded = true
+		return nil
+	})
+	if err != nil || !reloaded {
+		t.Fatalf("resync failed: %v", err)
+	}
+	pos, _ := store.Load(ctx, "dbo.Orders")
+	if v, _ := decodeVersion(pos); v != 12 {
+		t.Errorf("resync stored version %d", v)
+	}
+
+	store.Save(ctx, "dbo.Orders", encodeVersion(5))
+	batch, err := tr.Poll(ctx, Table{Name: "dbo.Orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(changesSQL, "CHANGETABLE(CHANGES dbo.Orders, @p1) AS CT LEFT OUTER JOIN dbo.Orders AS T ON T.[ID] = CT.[ID]") {
+		t.Errorf("unexpected query %q", changesSQL)
+	}
+	if len(batch.Changes) != 2 {
+		t.Fatalf("got %d changes", len(batch.Changes))
+	}
+	var order struct {
+		ID    int
+		Title string `db:"Name"`
+	}
+	ins, del := batch.Changes[0], batch.Changes[1]
+	if err = ins.Decode(&order); err != nil {
+		t.Fatal(err)
+	}
+	if ins.Operation != Insert || ins.Version != 8 || order.ID != 1 || order.Title != "first" {
+		t.Errorf("unexpected insert %+v %+v", ins, order)
+	}
+	if _, ok := del.Values["Name"]; del.Operation != Delete || ok || del.Values["ID"] != int64(2) {
+		t.Errorf("unexpected delete %+v", del)
+	}
+	if err = tr.Commit(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	pos, _ = store.Load(ctx, "dbo.Orders")
+	if v, _ := decodeVersion(pos); v != 12 {
+		t.Errorf("commit stored version %d", v)
+	}
+
+	// versions before the minimum valid one were cleaned up
+	store.Save(ctx, "dbo.Orders", encodeVersion(2))
+	if _, err = tr.Poll(ctx, Table{Name: "dbo.Orders", Keys: []string{"ID"}}); err == nil {
+		t.Fatal("expected an error")
+	} else if rerr, ok := err.(*ResyncError); !ok || rerr.Position == nil {
+		t.Errorf("expected a resync error, got %v", err)
+	}
+}
+
+func TestPollCDC(t *testing.T) {
+	minLSN := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	maxLSN := []byte{0, 0, 0, 9, 0, 0, 0, 0, 0, 0}
+	var changesSQL string
+	db, srv := open(t, func(req *mssqltest.Request) []mssqltest.Response {
+		switch {
+		case strings.HasPrefix(req.SQL, "SELECT sys.fn_cdc_get_min_lsn"):
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Type: mssqltest.VarBinary}, {Type: mssqltest.VarBinary}},
+				Rows:    [][]interface{}{{minLSN, maxLSN}},
+			}}
+		case strings.HasPrefix(req.SQL, "SELECT sys.fn_cdc_increment_lsn"):
+			lsn := append([]byte(nil), req.Params[0].Value.([]byte)...)
+			lsn[9]++
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Type: mssqltest.VarBinary}},
+				Rows:    [][]interface{}{{lsn}},
+			}}
+		case strings.HasPrefix(req.SQL, "SELECT * FROM cdc."):
+			changesSQL = req.SQL
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{
+					{Name: "__$start_lsn", Type: mssqltest.VarBinary},
+					{Name: "__$seqval", Type: mssqltest.VarBinary},
+					{Name: "__$operation", Type: mssqltest.Int},
+					{Name: "__$update_mask", Type: mssqltest.VarBinary},
+					{Name: "ID", Type: mssqltest.Int},
+				},
+				Rows: [][]interface{}{
+					{maxLSN, maxLSN, int64(4), []byte{1}, int64(3)},
+				},
+			}}
+		}
+		return []mssqltest.Response{mssqltest.RowsAffected(0)}
+	})
+	defer srv.Close()
+	defer db.Close()
+	ctx := context.Background()
+	store := &MemoryStore{}
+	tr := &Tracker{DB: db, Store: store}
+
+	store.Save(ctx, "cdc:dbo_Orders", minLSN)
+	batch, err := tr.PollCDC(ctx, "dbo_Orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(changesSQL, "SELECT * FROM cdc.[fn_cdc_get_all_changes_dbo_Orders](@p1, @p2, N'all')") {
+		t.Errorf("unexpected query %q", changesSQL)
+	}
+	if len(batch.Changes) != 1 || batch.Changes[0].Operation != Update || batch.Changes[0].Values["ID"] != int64(3) {
+		t.Fatalf("unexpected changes %+v", batch.Changes)
+	}
+	if _, ok := batch.Changes[0].Values["__$operation"]; ok {
+		t.Error("CDC columns were not removed")
+	}
+	if string(batch.Position) != string(maxLSN) {
+		t.Errorf("unexpected position %x", batch.Position)
+	}
+
+	// the stored position is older than the cleanup low water mark
+	store.Save(ctx, "cdc:dbo_Orders", make([]byte, 10))
+	if _, err = tr.PollCDC(ctx, "dbo_Orders"); err == nil {
+		t.Fatal("expected an error")
+	} else if _, ok := err.(*ResyncError); !ok {
+		t.Errorf("expected a resync error, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package changetracking
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// Decode stores the values of the change in the struct v points to.
+//
+// Columns are matched to exported fields by the name given in a db tag,
+// e.g. `db:"order_id"`, or else by the field name ignoring case. Fields
+// without a matching column are left unchanged and a db tag of "-" skips a
+// field. Fields implementing sql.Scanner are scanned, other values are
+// assigned or converted to the field type.
+func (c *Change) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("changetracking: Decode requires a pointer to a struct")
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("db")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		val, ok := c.lookup(name)
+		if !ok {
+			continue
+		}
+		if err := assign(rv.Field(i), val); err != nil {
+			return fmt.Errorf("changetracking: column %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Change) lookup(name string) (interface{}, bool) {
+	if v, ok := c.Values[name]; ok {
+		return v, true
+	}
+	for k, v := range c.Values {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func assign(field reflect.Value, val interface{}) error {
+	if field.CanAddr() && field.Addr().Type().Implements(scannerType) {
+		return field.Addr().Interface().(sql.Scanner).Scan(val)
+	}
+	if val == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if field.Kind() == reflect.Ptr {
+		p := reflect.New(field.Type().Elem())
+		if err := assign(p.Elem(), val); err != nil {
+			return err
+		}
+		field.Set(p)
+		return nil
+	}
+	v := reflect.ValueOf(val)
+	b, isBytes := val.([]byte)
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case isBytes && field.Kind() == reflect.String:
+		// varchar values are returned as []byte
+		field.SetString(string(b))
+	case isNumber(v.Kind()) && isNumber(field.Kind()):
+		field.Set(v.Convert(field.Type()))
+	default:
+		return fmt.Errorf("cannot store %T in %s", val, field.Type())
+	}
+	return nil
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package mssql
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+
+	// hash functions of the tls-server-end-point channel binding
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// channelBinder is implemented by the authenticators that support Extended
+// Protection, which binds the login to the TLS channel it is sent over.
+type channelBinder interface {
+	setChannelBindings(applicationData []byte)
+}
+
+// tlsServerEndPoint returns the application data of the tls-server-end-point
+// channel binding of RFC 5929, the one SQL Server checks, or nil without a
+// server certificate.
+func tlsServerEndPoint(state tls.ConnectionState) []byte {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	h := crypto.SHA256
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		h = crypto.SHA384
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		h = crypto.SHA512
+	}
+	hash := h.New()
+	hash.Write(cert.Raw)
+	return hash.Sum([]byte("tls-server-end-point:"))
+}
+
+// channelBindingsHash returns the MD5 hash of the gss_channel_bindings_struct
+// with the given application data and no addresses, as sent by NTLM and
+// Kerberos.
+func channelBindingsHash(applicationData []byte) []byte {
+	// initiator and acceptor address types and lengths are zero
+	b := make([]byte, 20, 20+len(applicationData))
+	binary.LittleEndian.PutUint32(b[16:], uint32(len(applicationData)))
+	sum := md5.Sum(append(b, applicationData...))
+	return sum[:]
+}
@@ -0,0 +1,50 @@
+package mssql
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+)
+
+func TestTLSServerEndPoint(t *testing.T) {
+	raw := []byte("certificate")
+	sha256Sum := sha256.Sum256(raw)
+	sha384Sum := sha512.Sum384(raw)
+	sha512Sum := sha512.Sum512(raw)
+	tests := []struct {
+		alg  x509.SignatureAlgorithm
+		hash []byte
+	}{
+		{x509.SHA1WithRSA, sha256Sum[:]},
+		{x509.SHA256WithRSA, sha256Sum[:]},
+		{x509.ECDSAWithSHA384, sha384Sum[:]},
+		{x509.SHA512WithRSA, sha512Sum[:]},
+	}
+	for _, test := range tests {
+		state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: raw, SignatureAlgorithm: test.alg}}}
+		expected := append([]byte("tls-server-end-point:"), test.hash...)
+		if got := tlsServerEndPoint(state); !bytes.Equal(got, expected) {
+			t.Errorf("%v: got %x, expected %x", test.alg, got, expected)
+		}
+	}
+	if got := tlsServerEndPoint(tls.ConnectionState{}); got != nil {
+		t.Errorf("expected no channel bindings without a certificate, got %x", got)
+	}
+}
+
+func TestChannelBindingsHash(t *testing.T) {
+	appData := []byte("tls-server-end-point:abc")
+	bindings := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, // initiator address
+		0, 0, 0, 0, 0, 0, 0, 0, // acceptor address
+		byte(len(appData)), 0, 0, 0,
+	}
+	expected := md5.Sum(append(bindings, appData...))
+	if got := channelBindingsHash(appData); !bytes.Equal(got, expected[:]) {
+		t.Errorf("got %x, expected %x", got, expected)
+	}
+}
@@ -0,0 +1,200 @@
+// Command gosqlcmd is a small sqlcmd compatible client built on the driver.
+//
+// Usage:
+//
+//	gosqlcmd -S server[\instance] [-U login -P password | -E] [-d db]
+//	         [-Q "query" | -q "query" | -i file[,file...]] [-o file]
+//	         [-s separator] [-h headers] [-W] [-b] [-l timeout] [-N] [-C]
+//
+// Without -Q or -i statements are read from standard input; a line holding
+// only GO, optionally followed by a repeat count, runs the statements typed
+// so far. EXIT and QUIT leave the program, RESET discards the current batch.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type options struct {
+	server       string
+	user         string
+	password     string
+	trusted      bool
+	database     string
+	query        string
+	initialQuery string
+	inputs       string
+	output       string
+	separator    string
+	headers      int
+	trim         bool
+	exitOnError  bool
+	loginTimeout int
+	encrypt      bool
+	trustCert    bool
+}
+
+func parseFlags(args []string, stderr io.Writer) (*options, error) {
+	o := &options{}
+	fs := flag.NewFlagSet("gosqlcmd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.server, "S", "localhost", "server_name[\\instance_name][,port]")
+	fs.StringVar(&o.user, "U", "", "login id")
+	fs.StringVar(&o.password, "P", "", "password, defaults to the SQLCMDPASSWORD environment variable")
+	fs.BoolVar(&o.trusted, "E", false, "use a trusted connection")
+	fs.StringVar(&o.database, "d", "", "database name")
+	fs.StringVar(&o.query, "Q", "", "run query and exit")
+	fs.StringVar(&o.initialQuery, "q", "", "run query and continue interactively")
+	fs.StringVar(&o.inputs, "i", "", "comma separated list of input files")
+	fs.StringVar(&o.output, "o", "", "output file")
+	fs.StringVar(&o.separator, "s", " ", "column separator")
+	fs.IntVar(&o.headers, "h", 0, "rows between column headers, -1 prints no headers")
+	fs.BoolVar(&o.trim, "W", false, "remove trailing spaces and padding")
+	fs.BoolVar(&o.exitOnError, "b", false, "exit with an error code when a batch fails")
+	fs.IntVar(&o.loginTimeout, "l", 8, "login timeout in seconds")
+	fs.BoolVar(&o.encrypt, "N", false, "encrypt the connection")
+	fs.BoolVar(&o.trustCert, "C", false, "trust the server certificate")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if o.password == "" {
+		o.password = os.Getenv("SQLCMDPASSWORD")
+	}
+	if o.query != "" && o.inputs != "" {
+		return nil, fmt.Errorf("the -Q and -i options are mutually exclusive")
+	}
+	return o, nil
+}
+
+// dsn builds a connection URL from the command line options.
+func (o *options) dsn() string {
+	u := &url.URL{Scheme: "sqlserver"}
+	host := o.server
+	if strings.HasPrefix(strings.ToLower(host), "tcp:") {
+		host = host[len("tcp:"):]
+	}
+	port := ""
+	if i := strings.LastIndex(host, ","); i >= 0 {
+		host, port = host[:i], host[i+1:]
+	}
+	if i := strings.Index(host, `\`); i >= 0 {
+		u.Path = host[i+1:]
+		host = host[:i]
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	if !o.trusted && o.user != "" {
+		u.User = url.UserPassword(o.user, o.password)
+	}
+	q := url.Values{}
+	if o.database != "" {
+		q.Set("database", o.database)
+	}
+	q.Set("app name", "gosqlcmd")
+	q.Set("dial timeout", fmt.Sprint(o.loginTimeout))
+	if o.encrypt {
+		q.Set("encrypt", "true")
+	}
+	if o.trustCert {
+		q.Set("TrustServerCertificate", "true")
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	o, err := parseFlags(args, stderr)
+	if err != nil {
+		return 1
+	}
+	out := stdout
+	if o.output != "" {
+		f, err := os.Create(o.output)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	connector, err := mssql.NewConnector(o.dsn())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	// session state such as USE and SET must survive between batches, they
+	// all run on one connection, which the pool would reset between them
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(o.loginTimeout)*time.Second)
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		err = conn.PingContext(ctx)
+	}
+	cancel()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	c := &cmd{
+		conn:        conn,
+		out:         out,
+		separator:   o.separator,
+		headers:     o.headers,
+		trim:        o.trim,
+		exitOnError: o.exitOnError,
+	}
+	switch {
+	case o.query != "":
+		return c.runScript(context.Background(), o.query)
+	case o.inputs != "":
+		for _, name := range strings.Split(o.inputs, ",") {
+			script, err := readFile(name)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return 1
+			}
+			if code := c.runScript(context.Background(), script); code != 0 {
+				return code
+			}
+		}
+		return 0
+	}
+	if o.initialQuery != "" {
+		if code := c.runScript(context.Background(), o.initialQuery); code != 0 {
+			return code
+		}
+	}
+	return c.interactive(context.Background(), stdin, stdout)
+}
+
+func readFile(name string) (string, error) {
+	f, err := os.Open(strings.TrimSpace(name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var b strings.Builder
+	if _, err = io.Copy(&b, f); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/batch"
+)
+
+type cmd struct {
+	conn        *sql.Conn
+	out         io.Writer
+	separator   string
+	headers     int
+	trim        bool
+	exitOnError bool
+}
+
+// runScript splits script on GO and runs each batch, it returns the
+// process exit code.
+func (c *cmd) runScript(ctx context.Context, script string) int {
+	for _, b := range batch.Split(script, "GO") {
+		if strings.TrimSpace(b) == "" {
+			continue
+		}
+		if err := c.runBatch(ctx, b); err != nil {
+			c.printError(err)
+			if c.exitOnError {
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// interactive reads batches from in until EOF or EXIT.
+func (c *cmd) interactive(ctx context.Context, in io.Reader, prompt io.Writer) int {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var buf []string
+	line := 1
+	for {
+		fmt.Fprintf(prompt, "%d> ", line)
+		if !scanner.Scan() {
+			break
+		}
+		text := scanner.Text()
+		fields := strings.Fields(text)
+		cmd := ""
+		if len(fields) > 0 {
+			cmd = strings.ToUpper(fields[0])
+		}
+		switch {
+		case (cmd == "EXIT" || cmd == "QUIT") && len(fields) == 1:
+			return 0
+		case cmd == "RESET" && len(fields) == 1:
+			buf = nil
+			line = 1
+			continue
+		case cmd == "GO" && len(fields) <= 2:
+			count := 1
+			if len(fields) == 2 {
+				n, err := strconv.Atoi(fields[1])
+				if err != nil || n < 1 {
+					buf = append(buf, text)
+					line++
+					continue
+				}
+				count = n
+			}
+			script := strings.Join(buf, "\n")
+			buf = nil
+			line = 1
+			for i := 0; i < count && strings.TrimSpace(script) != ""; i++ {
+				if err := c.runBatch(ctx, script); err != nil {
+					c.printError(err)
+					if c.exitOnError {
+						return 1
+					}
+				}
+			}
+			continue
+		}
+		buf = append(buf, text)
+		line++
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(c.out, err)
+		return 1
+	}
+	return 0
+}
+
+// runBatch runs a single batch and prints every result set it returns.
+func (c *cmd) runBatch(ctx context.Context, text string) error {
+	rows, err := c.conn.QueryContext(ctx, text)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for {
+		cols, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if len(cols) > 0 {
+			types, err := rows.ColumnTypes()
+			if err != nil {
+				return err
+			}
+			if err = c.printResultSet(rows, cols, types); err != nil {
+				return err
+			}
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+func (c *cmd) printResultSet(rows *sql.Rows, cols []string, types []*sql.ColumnType) error {
+	var table [][]string
+	vals := make([]interface{}, len(cols))
+	for i := range vals {
+		vals[i] = new(interface{})
+	}
+	for rows.Next() {
+		if err := rows.Scan(vals...); err != nil {
+			return err
+		}
+		row := make([]string, len(cols))
+		for i, v := range vals {
+			row[i] = formatValue(*(v.(*interface{})), types[i].DatabaseTypeName())
+		}
+		table = append(table, row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	widths := make([]int, len(cols))
+	if !c.trim {
+		for i, col := range cols {
+			widths[i] = utf8.RuneCountInString(col)
+		}
+		for _, row := range table {
+			for i, v := range row {
+				if n := utf8.RuneCountInString(v); n > widths[i] {
+					widths[i] = n
+				}
+			}
+		}
+	}
+	printHeader := func() {
+		c.printRow(cols, widths)
+		dashes := make([]string, len(cols))
+		for i := range cols {
+			n := widths[i]
+			if c.trim {
+				n = utf8.RuneCountInString(cols[i])
+			}
+			dashes[i] = strings.Repeat("-", n)
+		}
+		c.printRow(dashes, widths)
+	}
+	if c.headers >= 0 {
+		printHeader()
+	}
+	for i, row := range table {
+		if c.headers > 0 && i > 0 && i%c.headers == 0 {
+			fmt.Fprintln(c.out)
+			printHeader()
+		}
+		c.printRow(row, widths)
+	}
+	fmt.Fprintln(c.out)
+	if len(table) == 1 {
+		fmt.Fprintln(c.out, "(1 row affected)")
+	} else {
+		fmt.Fprintf(c.out, "(%d rows affected)\n", len(table))
+	}
+	return nil
+}
+
+func (c *cmd) printRow(row []string, widths []int) {
+	var b strings.Builder
+	for i, v := range row {
+		if i > 0 {
+			b.WriteString(c.separator)
+		}
+		b.WriteString(v)
+		if !c.trim && i < len(row)-1 {
+			b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(v)))
+		}
+	}
+	fmt.Fprintln(c.out, b.String())
+}
+
+func formatValue(v interface{}, typeName string) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		switch typeName {
+		case "BINARY", "VARBINARY", "IMAGE", "TIMESTAMP":
+			return "0x" + strings.ToUpper(hex.EncodeToString(v))
+		}
+		return string(v)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.000")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// printError prints SQL Server errors in the format used by sqlcmd.
+func (c *cmd) printError(err error) {
+	if e, ok := err.(mssql.Error); ok {
+		for _, e := range e.All {
+			fmt.Fprintf(c.out, "Msg %d, Level %d, State %d, Server %s, Line %d\n%s\n",
+				e.Number, e.Class, e.State, e.ServerName, e.LineNo, e.Message)
+		}
+		return
+	}
+	fmt.Fprintln(c.out, err)
+}
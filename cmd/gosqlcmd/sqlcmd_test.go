@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func testServer() *mssqltest.Server {
+	return mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		switch {
+		case strings.Contains(req.SQL, "fail"):
+			return []mssqltest.Response{mssqltest.Error{Number: 208, Class: 16, State: 1, LineNo: 1, Message: "Invalid object name 'fail'."}}
+		case strings.Contains(req.SQL, "select"):
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "id", Type: mssqltest.Int}, {Name: "name", Type: mssqltest.NVarChar}},
+				Rows:    [][]interface{}{{1, "alpha"}, {22, nil}},
+			}}
+		}
+		return []mssqltest.Response{mssqltest.RowsAffected(1)}
+	})
+}
+
+func server(srv *mssqltest.Server) string {
+	return fmt.Sprintf("127.0.0.1,%d", srv.Addr().Port)
+}
+
+func TestQueryFlag(t *testing.T) {
+	srv := testServer()
+	defer srv.Close()
+	var out, errOut bytes.Buffer
+	code := run([]string{"-S", server(srv), "-Q", "select id, name from t"}, nil, &out, &errOut)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut.String())
+	}
+	want := "id name\n-- -----\n1  alpha\n22 NULL\n\n(2 rows affected)\n"
+	if out.String() != want {
+		t.Errorf("got\n%q\nwant\n%q", out.String(), want)
+	}
+}
+
+func TestInputFileWithGo(t *testing.T) {
+	srv := testServer()
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "gosqlcmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "script.sql")
+	script := "insert into t values (1)\nGO\nupdate t set a = 1\nGO 2\nselect fail\nGO\n"
+	if err = ioutil.WriteFile(name, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-S", server(srv), "-i", name}, nil, &out, &errOut); code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut.String())
+	}
+	// the first request is the connection check
+	reqs := srv.Requests()
+	if n := len(reqs) - 1; n != 4 {
+		t.Errorf("expected 4 batches, got %d", n)
+	}
+	// USE and SET of a batch apply to the next ones
+	for _, req := range reqs[1:] {
+		if req.Reset || req.SessionID != reqs[0].SessionID {
+			t.Errorf("batch %q reset the session or ran on another one", req.SQL)
+		}
+	}
+	if !strings.Contains(out.String(), "Msg 208, Level 16, State 1") {
+		t.Errorf("error not printed: %q", out.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"-S", server(srv), "-b", "-i", name}, nil, &out, &errOut); code != 1 {
+		t.Errorf("expected exit code 1 with -b, got %d", code)
+	}
+}
+
+func TestInteractive(t *testing.T) {
+	srv := testServer()
+	defer srv.Close()
+	in := strings.NewReader("select id,\nname from t\nGO\nselect 1\nRESET\nupdate t set a = 1\ngo 3\nexit\nselect fail\nGO\n")
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-S", server(srv), "-W", "-s", ",", "-h", "-1"}, in, &out, &errOut); code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut.String())
+	}
+	reqs := srv.Requests()[1:]
+	if len(reqs) != 4 {
+		t.Fatalf("expected 4 batches, got %d", len(reqs))
+	}
+	if reqs[0].SQL != "select id,\nname from t" {
+		t.Errorf("unexpected first batch %q", reqs[0].SQL)
+	}
+	if !strings.Contains(out.String(), "1,alpha\n22,NULL\n") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
@@ -0,0 +1,117 @@
+package mssql
+
+import "github.com/denisenkom/go-mssqldb/internal/cp"
+
+// Collation is the collation of a character column, which schema tools
+// need to create the column alike. A query given a *[]Collation argument
+// sets it to the collations of the columns of its result sets, the zero
+// value for the columns which are not char, varchar, text, nchar, nvarchar
+// or ntext:
+//
+//	var collations []mssql.Collation
+//	rows, err := db.QueryContext(ctx, "select name from t", &collations)
+type Collation struct {
+	// Name is the name of the collation, such as Latin1_General_CI_AS or
+	// SQL_Latin1_General_CP1_CI_AS, empty when the driver does not know
+	// it. The server does not send the _SC and _VSS options, they are
+	// left out but for the UTF-8 collations, which all have _SC.
+	Name string
+	// LCID is the locale id of the collation, its bits 16-19 tell the
+	// alternate sorts of a language apart, such as 0x20804 for
+	// Chinese_PRC_Stroke.
+	LCID int
+	// SortID is the sort order of SQL collations, zero for Windows
+	// collations.
+	SortID int
+	// Version is the version of Windows collations, 1 for the _90
+	// collations, 2 for the _100 ones and 3 for the _140 ones.
+	Version int
+	Flags   CollationFlags
+	// CodePage is the code page of the char, varchar and text values,
+	// 65001 for UTF-8 collations and zero for Unicode-only collations.
+	CodePage int
+}
+
+// CollationFlags are the comparison flags of a collation.
+type CollationFlags uint8
+
+const (
+	collationIgnoreCase = 1 << iota
+	collationIgnoreAccent
+	collationIgnoreWidth
+	collationIgnoreKana
+	collationBinary
+	collationBinary2
+	collationUTF8
+)
+
+// IgnoreCase reports whether the collation is case insensitive, _CI.
+func (f CollationFlags) IgnoreCase() bool { return f&collationIgnoreCase != 0 }
+
+// IgnoreAccent reports whether the collation is accent insensitive, _AI.
+func (f CollationFlags) IgnoreAccent() bool { return f&collationIgnoreAccent != 0 }
+
+// IgnoreWidth reports whether the collation is width insensitive, the
+// collations without _WS.
+func (f CollationFlags) IgnoreWidth() bool { return f&collationIgnoreWidth != 0 }
+
+// IgnoreKana reports whether the collation is kana insensitive, the
+// collations without _KS.
+func (f CollationFlags) IgnoreKana() bool { return f&collationIgnoreKana != 0 }
+
+// Binary reports whether the collation is a _BIN collation.
+func (f CollationFlags) Binary() bool { return f&collationBinary != 0 }
+
+// Binary2 reports whether the collation is a _BIN2 collation, which
+// compares code points.
+func (f CollationFlags) Binary2() bool { return f&collationBinary2 != 0 }
+
+// UTF8 reports whether the collation is a _UTF8 collation, which stores
+// char and varchar text as UTF-8.
+func (f CollationFlags) UTF8() bool { return f&collationUTF8 != 0 }
+
+func makeCollation(col cp.Collation) Collation {
+	return Collation{
+		Name:     col.Name(),
+		LCID:     int(col.LCID()),
+		SortID:   int(col.SortId),
+		Version:  int(col.Version()),
+		Flags:    CollationFlags(col.Flags()),
+		CodePage: col.CodePage(),
+	}
+}
+
+func isCharType(typeID uint8) bool {
+	switch typeID {
+	case typeChar, typeVarChar, typeBigChar, typeBigVarChar, typeText,
+		typeNChar, typeNVarChar, typeNText:
+		return true
+	}
+	return false
+}
+
+// ColumnTypeCollation returns the collation of a character column, ok is
+// false for the other columns. With database/sql, pass a *[]Collation as
+// a query argument.
+func (r *Rows) ColumnTypeCollation(index int) (collation Collation, ok bool) {
+	ti := r.cols[index].ti
+	if !isCharType(ti.TypeId) {
+		return Collation{}, false
+	}
+	return makeCollation(ti.Collation), true
+}
+
+// setCollations sets the collations argument of the query, if any, to the
+// collations of the columns of a result set.
+func (o outputs) setCollations(cols []columnStruct) {
+	if o.collations == nil {
+		return
+	}
+	collations := make([]Collation, len(cols))
+	for i, col := range cols {
+		if isCharType(col.ti.TypeId) {
+			collations[i] = makeCollation(col.ti.Collation)
+		}
+	}
+	*o.collations = collations
+}
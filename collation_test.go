@@ -0,0 +1,55 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestCollations(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "id", Type: mssqltest.Int},
+				{Name: "name", Type: mssqltest.NVarChar},
+				{Name: "code", Type: mssqltest.VarChar},
+			},
+			Rows: [][]interface{}{{1, "a", "b"}},
+		}}
+	})
+	srv.UTF8 = true
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	var collations []Collation
+	rows, err := db.Query("select id, name, code from t", &collations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	expected := []Collation{
+		{},
+		{Name: "SQL_Latin1_General_CP1_CI_AS", LCID: 0x0409, SortID: 0x34, Flags: 0x0d, CodePage: 1252},
+		{Name: "Latin1_General_100_CI_AS_SC_UTF8", LCID: 0x0409, Version: 2, Flags: 0x4d, CodePage: 65001},
+	}
+	if len(collations) != len(expected) {
+		t.Fatalf("expected %d collations, got %v", len(expected), collations)
+	}
+	for i := range expected {
+		if collations[i] != expected[i] {
+			t.Errorf("column %d: expected %+v, got %+v", i, expected[i], collations[i])
+		}
+	}
+	f := collations[2].Flags
+	if !f.IgnoreCase() || f.IgnoreAccent() || !f.IgnoreWidth() || !f.IgnoreKana() || f.Binary() || f.Binary2() || !f.UTF8() {
+		t.Errorf("unexpected flags %x", f)
+	}
+}
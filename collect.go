@@ -0,0 +1,293 @@
+package mssql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/golang-sql/civil"
+)
+
+// Collect reads the remaining rows of the current result set into a
+// slice. It does not close rows or advance to the next result set.
+//
+// Structs are filled by matching the columns to exported fields, by the
+// name given in a `db:"name"` tag or else by the case-insensitive field
+// name. Fields tagged `db:"-"` are skipped. Every column must match a
+// field. Other types, including time.Time and types implementing
+// sql.Scanner, are read from a single column.
+//
+// Values are converted as by sql.Rows.Scan, and by the type of their
+// column to the types Scan does not convert to: uniqueidentifier columns
+// to strings in their usual form, date and time columns to civil.Date,
+// civil.Time and civil.DateTime, and time columns to time.Duration.
+//
+//	rows, err := db.QueryContext(ctx, "select id, name from users")
+//	...
+//	defer rows.Close()
+//	users, err := mssql.Collect[User](rows)
+func Collect[T any](rows *sql.Rows) ([]T, error) {
+	res := []T{}
+	s, err := newRowScanner[T](rows)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var v T
+		if err = s.scan(rows, &v); err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, rows.Err()
+}
+
+// MultiResult reads the result sets of a query one after another, e.g. the
+// result sets of a procedure, with NextResult.
+//
+//	rows, err := db.QueryContext(ctx, "exec dbo.GetOrder @id", sql.Named("id", id))
+//	...
+//	m := mssql.NewMultiResult(rows)
+//	defer m.Close()
+//	orders, err := mssql.NextResult[Order](m)
+//	...
+//	lines, err := mssql.NextResult[OrderLine](m)
+type MultiResult struct {
+	rows    *sql.Rows
+	started bool
+}
+
+// ErrNoMoreResults is returned by NextResult when all result sets were
+// read.
+var ErrNoMoreResults = errors.New("mssql: no more result sets")
+
+// NewMultiResult returns a MultiResult reading rows, which is positioned on
+// its first result set.
+func NewMultiResult(rows *sql.Rows) *MultiResult {
+	return &MultiResult{rows: rows}
+}
+
+// NextResult reads the next result set of m into a slice, as Collect
+// does. It returns ErrNoMoreResults after the last result set.
+func NextResult[T any](m *MultiResult) ([]T, error) {
+	if m.started && !m.rows.NextResultSet() {
+		if err := m.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoMoreResults
+	}
+	m.started = true
+	return Collect[T](m.rows)
+}
+
+// Close closes the rows, discarding the result sets that were not read.
+func (m *MultiResult) Close() error {
+	return m.rows.Close()
+}
+
+var (
+	scannerType       = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	civilDateType     = reflect.TypeOf(civil.Date{})
+	civilTimeType     = reflect.TypeOf(civil.Time{})
+	civilDateTimeType = reflect.TypeOf(civil.DateTime{})
+)
+
+// rowScanner scans the rows of a result set into values of T.
+type rowScanner[T any] struct {
+	cols []*sql.ColumnType
+	// fields are the indexes of the fields of the columns, nil when the
+	// value is read from a single column
+	fields [][]int
+	// converters convert the values of the columns that Scan does not
+	// convert, nil for the others
+	converters []valueConverter
+	dest, raw  []interface{}
+}
+
+// valueConverter stores the value of a column in dst.
+type valueConverter func(src interface{}, dst reflect.Value) error
+
+func newRowScanner[T any](rows *sql.Rows) (*rowScanner[T], error) {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	s := &rowScanner[T]{
+		cols:       cols,
+		converters: make([]valueConverter, len(cols)),
+		dest:       make([]interface{}, len(cols)),
+		raw:        make([]interface{}, len(cols)),
+	}
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if !isStruct(t) {
+		if len(cols) != 1 {
+			return nil, fmt.Errorf("mssql: cannot read %d columns into %v", len(cols), t)
+		}
+		if s.converters[0], err = columnConverter(cols[0], t); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	s.fields = make([][]int, len(cols))
+	for i, c := range cols {
+		if s.fields[i] = fieldIndex(t, c.Name()); s.fields[i] == nil {
+			return nil, fmt.Errorf("mssql: column %q of type %s has no matching field in %v", c.Name(), c.DatabaseTypeName(), t)
+		}
+		if s.converters[i], err = columnConverter(c, t.FieldByIndex(s.fields[i]).Type); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// isStruct reports whether t is a struct whose fields are read from the
+// columns, rather than a value read from a single column.
+func isStruct(t reflect.Type) bool {
+	switch t {
+	case timeType, civilDateType, civilTimeType, civilDateTimeType:
+		return false
+	}
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(scannerType)
+}
+
+func (s *rowScanner[T]) scan(rows *sql.Rows, v *T) error {
+	rv := reflect.ValueOf(v).Elem()
+	for i := range s.dest {
+		if s.converters[i] != nil {
+			s.dest[i] = &s.raw[i]
+		} else {
+			s.dest[i] = s.field(rv, i).Addr().Interface()
+		}
+	}
+	if err := rows.Scan(s.dest...); err != nil {
+		return err
+	}
+	for i, convert := range s.converters {
+		if convert == nil {
+			continue
+		}
+		if err := convert(s.raw[i], s.field(rv, i)); err != nil {
+			return fmt.Errorf("mssql: column %q: %v", s.cols[i].Name(), err)
+		}
+	}
+	return nil
+}
+
+func (s *rowScanner[T]) field(rv reflect.Value, i int) reflect.Value {
+	if s.fields == nil {
+		return rv
+	}
+	return rv.FieldByIndex(s.fields[i])
+}
+
+// columnConverter returns the converter of the values of col to t, nil if
+// Scan converts them. Pointers are set to nil for NULL.
+func columnConverter(col *sql.ColumnType, t reflect.Type) (valueConverter, error) {
+	base := t
+	if base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+	typeName := col.DatabaseTypeName()
+	var convert valueConverter
+	switch {
+	case typeName == "UNIQUEIDENTIFIER" && base.Kind() == reflect.String:
+		convert = func(src interface{}, dst reflect.Value) error {
+			var u UniqueIdentifier
+			if err := u.Scan(src); err != nil {
+				return err
+			}
+			dst.SetString(u.String())
+			return nil
+		}
+	case base == durationType:
+		if typeName != "TIME" {
+			return nil, fmt.Errorf("mssql: column %q of type %s cannot be read into %v", col.Name(), typeName, t)
+		}
+		convert = timeConverter(func(t time.Time) interface{} {
+			y, m, d := t.Date()
+			return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+		})
+	case base == civilDateType || base == civilTimeType || base == civilDateTimeType:
+		switch typeName {
+		case "DATE", "TIME", "SMALLDATETIME", "DATETIME", "DATETIME2", "DATETIMEOFFSET":
+		default:
+			return nil, fmt.Errorf("mssql: column %q of type %s cannot be read into %v", col.Name(), typeName, t)
+		}
+		convert = timeConverter(func(t time.Time) interface{} {
+			switch base {
+			case civilDateType:
+				return civil.DateOf(t)
+			case civilTimeType:
+				return civil.TimeOf(t)
+			}
+			return civil.DateTimeOf(t)
+		})
+	default:
+		return nil, nil
+	}
+	if base == t {
+		return func(src interface{}, dst reflect.Value) error {
+			if src == nil {
+				return fmt.Errorf("converting NULL to %v is unsupported", t)
+			}
+			return convert(src, dst)
+		}, nil
+	}
+	return func(src interface{}, dst reflect.Value) error {
+		if src == nil {
+			dst.Set(reflect.Zero(t))
+			return nil
+		}
+		v := reflect.New(base)
+		if err := convert(src, v.Elem()); err != nil {
+			return err
+		}
+		dst.Set(v)
+		return nil
+	}, nil
+}
+
+// timeConverter returns the converter of the time values of a column by
+// convert.
+func timeConverter(convert func(t time.Time) interface{}) valueConverter {
+	return func(src interface{}, dst reflect.Value) error {
+		t, ok := src.(time.Time)
+		if !ok {
+			return fmt.Errorf("unexpected value of type %T", src)
+		}
+		dst.Set(reflect.ValueOf(convert(t)))
+		return nil
+	}
+}
+
+// fieldIndex returns the index of the field of t that a column maps to, or
+// nil if there is none.
+func fieldIndex(t reflect.Type, column string) []int {
+	var byName []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			if tag == column {
+				return f.Index
+			}
+			continue
+		}
+		if byName == nil && strings.EqualFold(f.Name, column) {
+			byName = f.Index
+		}
+	}
+	return byName
+}
@@ -0,0 +1,151 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+	"github.com/golang-sql/civil"
+)
+
+func TestNextResult(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	db, srv := openTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{
+			mssqltest.ResultSet{
+				Columns: []mssqltest.Column{
+					{Name: "order_id", Type: mssqltest.Int},
+					{Name: "Customer", Type: mssqltest.NVarChar},
+					{Name: "created", Type: mssqltest.DateTime2},
+				},
+				Rows: [][]interface{}{{int64(7), "acme", created}},
+			},
+			mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "product", Type: mssqltest.NVarChar}},
+				Rows:    [][]interface{}{{"bolt"}, {"nut"}},
+			},
+		}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	type order struct {
+		ID       int `db:"order_id"`
+		Customer string
+		Created  time.Time
+		Note     string `db:"-"`
+	}
+	rows, err := db.QueryContext(context.Background(), "exec dbo.GetOrder 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMultiResult(rows)
+	defer m.Close()
+
+	orders, err := NextResult[order](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].ID != 7 || orders[0].Customer != "acme" || !orders[0].Created.Equal(created) {
+		t.Errorf("unexpected orders %+v", orders)
+	}
+	products, err := NextResult[string](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 2 || products[0] != "bolt" || products[1] != "nut" {
+		t.Errorf("unexpected products %v", products)
+	}
+	if _, err = NextResult[string](m); err != ErrNoMoreResults {
+		t.Errorf("expected ErrNoMoreResults, got %v", err)
+	}
+}
+
+func TestCollectUnmatchedColumn(t *testing.T) {
+	db, srv := openTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "id", Type: mssqltest.Int}, {Name: "extra", Type: mssqltest.Int}},
+			Rows:    [][]interface{}{{int64(1), int64(2)}},
+		}}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	rows, err := db.Query("select id, extra from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if _, err = Collect[struct{ ID int }](rows); err == nil {
+		t.Error("expected an error for the unmatched column")
+	}
+}
+
+func TestCollectConversions(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	guid := []byte{0x04, 0x03, 0x02, 0x01, 0x06, 0x05, 0x08, 0x07, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+	db, srv := openTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "id", Type: mssqltest.UniqueIdentifier},
+				{Name: "parent", Type: mssqltest.UniqueIdentifier},
+				{Name: "created", Type: mssqltest.DateTime2},
+				{Name: "day", Type: mssqltest.DateTime2},
+				{Name: "shipped", Type: mssqltest.DateTime2},
+			},
+			Rows: [][]interface{}{{guid, nil, created, created, nil}},
+		}}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	type item struct {
+		ID      string
+		Parent  *string
+		Created civil.DateTime
+		Day     civil.Date
+		Shipped *civil.DateTime
+	}
+	rows, err := db.Query("select id, parent, created, day, shipped from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	items, err := Collect[item](rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(items))
+	}
+	it := items[0]
+	if it.ID != "01020304-0506-0708-090A-0B0C0D0E0F10" || it.Parent != nil || it.Shipped != nil {
+		t.Errorf("unexpected item %+v", it)
+	}
+	if it.Created != civil.DateTimeOf(created) || it.Day != civil.DateOf(created) {
+		t.Errorf("unexpected dates %v and %v", it.Created, it.Day)
+	}
+}
+
+func TestCollectUnconvertibleColumn(t *testing.T) {
+	db, srv := openTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "n", Type: mssqltest.Int}},
+			Rows:    [][]interface{}{{int64(1)}},
+		}}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	rows, err := db.Query("select n from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if _, err = Collect[civil.Date](rows); err == nil {
+		t.Error("expected an error for an int column read into civil.Date")
+	}
+}
@@ -0,0 +1,50 @@
+package mssql
+
+// ColumnFlags are the flags of a result set column from its COLMETADATA.
+// Hidden and key columns are only sent in browse mode.
+type ColumnFlags uint16
+
+// Nullable reports whether the column allows nulls.
+func (f ColumnFlags) Nullable() bool { return f&colFlagNullable != 0 }
+
+// CaseSensitive reports whether the column has a case sensitive collation.
+func (f ColumnFlags) CaseSensitive() bool { return f&colFlagCaseSensitive != 0 }
+
+// Updatable reports whether the column can be updated, it is false for
+// read-only columns and columns whose updatability is unknown.
+func (f ColumnFlags) Updatable() bool { return f&colFlagUpdateable == colFlagReadWrite }
+
+// Identity reports whether the column is an identity column, whose values
+// are generated by the server.
+func (f ColumnFlags) Identity() bool { return f&colFlagIdentity != 0 }
+
+// Computed reports whether the column is a computed column.
+func (f ColumnFlags) Computed() bool { return f&colFlagComputed != 0 }
+
+// SparseColumnSet reports whether the column is the XML column set of the
+// sparse columns of a table.
+func (f ColumnFlags) SparseColumnSet() bool { return f&colFlagSparseColumnSet != 0 }
+
+// Encrypted reports whether the column is encrypted with Always Encrypted.
+func (f ColumnFlags) Encrypted() bool { return f&colFlagEncrypted != 0 }
+
+// Hidden reports whether the column was added to the result set by the
+// server, such as the key of a browse mode query the select list left out.
+func (f ColumnFlags) Hidden() bool { return f&colFlagHidden != 0 }
+
+// Key reports whether the column is part of the key of its table, in
+// browse mode.
+func (f ColumnFlags) Key() bool { return f&colFlagKey != 0 }
+
+// setColumnFlags sets the column flags argument of the query, if any, to
+// the flags of the columns of a result set.
+func (o outputs) setColumnFlags(cols []columnStruct) {
+	if o.columnFlags == nil {
+		return
+	}
+	flags := make([]ColumnFlags, len(cols))
+	for i, col := range cols {
+		flags[i] = ColumnFlags(col.Flags)
+	}
+	*o.columnFlags = flags
+}
@@ -0,0 +1,72 @@
+package mssql
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestColumnFlags(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "id", Type: mssqltest.Int, Flags: colFlagIdentity | colFlagReadWrite},
+				{Name: "total", Type: mssqltest.Int, Flags: colFlagNullable | colFlagComputed},
+				{Name: "name", Type: mssqltest.NVarChar, Flags: colFlagNullable | colFlagCaseSensitive | colFlagReadWrite},
+				{Name: "pk", Type: mssqltest.Int, Flags: colFlagHidden | colFlagKey | colFlagNullableUnknown},
+			},
+			Rows: [][]interface{}{{1, 2, "a", 3}},
+		}}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var flags []ColumnFlags
+	rows, err := db.Query("select id, total, name from t for browse", &flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if len(flags) != 4 {
+		t.Fatalf("expected the flags of 4 columns, got %v", flags)
+	}
+	tests := []struct {
+		name                                 string
+		nullable, identity, computed, hidden bool
+		key, caseSensitive, updatable        bool
+	}{
+		{name: "id", identity: true, updatable: true},
+		{name: "total", nullable: true, computed: true},
+		{name: "name", nullable: true, caseSensitive: true, updatable: true},
+		{name: "pk", hidden: true, key: true},
+	}
+	for i, tt := range tests {
+		f := flags[i]
+		got := [...]bool{f.Nullable(), f.Identity(), f.Computed(), f.Hidden(), f.Key(), f.CaseSensitive(), f.Updatable()}
+		want := [...]bool{tt.nullable, tt.identity, tt.computed, tt.hidden, tt.key, tt.caseSensitive, tt.updatable}
+		if got != want {
+			t.Errorf("column %s: got flags %v, expected %v", tt.name, got, want)
+		}
+	}
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nullable, ok := types[0].Nullable(); nullable || !ok {
+		t.Errorf("expected column id to be known not nullable, got %v %v", nullable, ok)
+	}
+	if _, ok := types[3].Nullable(); ok {
+		t.Error("expected the nullability of column pk to be unknown")
+	}
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,11 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package mssql
+
+import "net"
+
+// connAlive reports whether the peer has not closed an idle connection,
+// it cannot be checked on this platform without blocking.
+func connAlive(conn net.Conn) bool {
+	return true
+}
@@ -0,0 +1,32 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package mssql
+
+import (
+	"net"
+	"syscall"
+)
+
+// connAlive reports whether the peer has not closed an idle connection and
+// sent nothing unsolicited, by peeking at the socket without blocking.
+// Connections other than TCP ones are assumed alive.
+func connAlive(conn net.Conn) bool {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return true
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	alive := false
+	err = rc.Read(func(fd uintptr) bool {
+		var b [1]byte
+		_, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		// nothing to read on an open connection, EOF or pending data
+		// otherwise
+		alive = err == syscall.EAGAIN || err == syscall.EWOULDBLOCK
+		return true
+	})
+	return err == nil && alive
+}
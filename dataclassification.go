@@ -0,0 +1,134 @@
+package mssql
+
+// Columns classified with ADD SENSITIVITY CLASSIFICATION are described by
+// a DATACLASSIFICATION token that follows the COLMETADATA token of the
+// result set, for clients that negotiated the DATACLASSIFICATION feature
+// extension.
+//
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-tds/2ca7a8e6-7d5e-4d62-9b83-fc7c2b1e5a1b
+
+// dataClassificationVersion is the highest version of the DATACLASSIFICATION
+// feature extension supported, version 2 adds the sensitivity ranks.
+const dataClassificationVersion = 2
+
+// SensitivityRank is the sensitivity of classified data.
+type SensitivityRank int32
+
+const (
+	SensitivityRankNotDefined SensitivityRank = -1
+	SensitivityRankNone       SensitivityRank = 0
+	SensitivityRankLow        SensitivityRank = 10
+	SensitivityRankMedium     SensitivityRank = 20
+	SensitivityRankHigh       SensitivityRank = 30
+	SensitivityRankCritical   SensitivityRank = 40
+)
+
+// SensitivityLabel is a sensitivity label, such as Confidential.
+type SensitivityLabel struct {
+	Name string
+	ID   string
+}
+
+// InformationType is the type of classified information, such as
+// Financial or Contact Info.
+type InformationType struct {
+	Name string
+	ID   string
+}
+
+// SensitivityProperty is one classification of a column. Label and
+// InformationType are nil when the classification has none.
+type SensitivityProperty struct {
+	Label           *SensitivityLabel
+	InformationType *InformationType
+	Rank            SensitivityRank
+}
+
+// ColumnSensitivity holds the classifications of a column, the columns a
+// value of the result set is computed from may have several.
+type ColumnSensitivity struct {
+	Properties []SensitivityProperty
+}
+
+// DataClassification describes the sensitive data of a result set: the
+// labels and information types it uses, the overall rank and the
+// classification of every column, in the order of the columns.
+//
+// The classification of the current result set is returned by
+// Rows.DataClassification. With database/sql, pass a *DataClassification
+// as a query argument: it is set to the classification of each result set
+// when the result set starts, and cleared for result sets without one.
+//
+//	var dc mssql.DataClassification
+//	rows, err := db.Query("select ssn from customers", &dc)
+type DataClassification struct {
+	Labels           []SensitivityLabel
+	InformationTypes []InformationType
+	Rank             SensitivityRank
+	Columns          []ColumnSensitivity
+}
+
+// featureExtDataClassification asks for the sensitivity classification of
+// result sets.
+type featureExtDataClassification struct{}
+
+func (featureExtDataClassification) featureID() byte {
+	return featExtDATACLASSIFICATION
+}
+
+func (featureExtDataClassification) toBytes() []byte {
+	return []byte{dataClassificationVersion}
+}
+
+// parseDataClassification reads a DATACLASSIFICATION token of the given
+// version of the feature extension.
+func parseDataClassification(r *tdsBuffer, version byte) *DataClassification {
+	dc := &DataClassification{Rank: SensitivityRankNotDefined}
+	dc.Labels = make([]SensitivityLabel, r.uint16())
+	for i := range dc.Labels {
+		dc.Labels[i].Name = r.UsVarChar()
+		dc.Labels[i].ID = r.UsVarChar()
+	}
+	dc.InformationTypes = make([]InformationType, r.uint16())
+	for i := range dc.InformationTypes {
+		dc.InformationTypes[i].Name = r.UsVarChar()
+		dc.InformationTypes[i].ID = r.UsVarChar()
+	}
+	if version >= 2 {
+		dc.Rank = SensitivityRank(r.int32())
+	}
+	dc.Columns = make([]ColumnSensitivity, r.uint16())
+	for i := range dc.Columns {
+		props := make([]SensitivityProperty, r.uint16())
+		for j := range props {
+			p := &props[j]
+			// the indexes are out of range for classifications
+			// without a label or information type
+			if label := int(r.uint16()); label < len(dc.Labels) {
+				p.Label = &dc.Labels[label]
+			}
+			if infoType := int(r.uint16()); infoType < len(dc.InformationTypes) {
+				p.InformationType = &dc.InformationTypes[infoType]
+			}
+			p.Rank = SensitivityRankNotDefined
+			if version >= 2 {
+				p.Rank = SensitivityRank(r.int32())
+			}
+		}
+		dc.Columns[i].Properties = props
+	}
+	return dc
+}
+
+// setDataClassification sets the classification argument of the query, if
+// any, to the classification of a result set.
+func (o outputs) setDataClassification(dc *DataClassification) {
+	if o.dataClassification == nil {
+		return
+	}
+	if dc == nil {
+		*o.dataClassification = DataClassification{}
+		return
+	}
+	*o.dataClassification = *dc
+}
@@ -0,0 +1,132 @@
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestDataClassification(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{
+			mssqltest.ResultSet{
+				Columns: []mssqltest.Column{
+					{Name: "id", Type: mssqltest.Int},
+					{Name: "ssn", Type: mssqltest.NVarChar, Sensitivity: []mssqltest.Sensitivity{{
+						Label: "Confidential", LabelID: "l1",
+						InformationType: "National ID", InformationTypeID: "t1",
+						Rank: 30,
+					}}},
+					{Name: "email", Type: mssqltest.NVarChar, Sensitivity: []mssqltest.Sensitivity{{
+						InformationType: "Contact Info", InformationTypeID: "t2",
+						Rank: 10,
+					}}},
+				},
+				Rows: [][]interface{}{{1, "123-45-6789", "a@example.com"}},
+			},
+			mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "n", Type: mssqltest.Int}},
+				Rows:    [][]interface{}{{1}},
+			},
+		}
+	})
+	defer srv.Close()
+	srv.DataClassification = true
+
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var dc DataClassification
+	rows, err := db.Query("select id, ssn, email from customers; select 1", &dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if v := srv.Logins()[0].DataClassificationVersion; v != dataClassificationVersion {
+		t.Errorf("expected data classification version %d, got %d", dataClassificationVersion, v)
+	}
+
+	if dc.Rank != SensitivityRankHigh {
+		t.Errorf("expected rank %d, got %d", SensitivityRankHigh, dc.Rank)
+	}
+	if len(dc.Labels) != 1 || dc.Labels[0] != (SensitivityLabel{Name: "Confidential", ID: "l1"}) {
+		t.Errorf("unexpected labels %v", dc.Labels)
+	}
+	if len(dc.InformationTypes) != 2 {
+		t.Errorf("unexpected information types %v", dc.InformationTypes)
+	}
+	if len(dc.Columns) != 3 {
+		t.Fatalf("expected 3 columns, got %d", len(dc.Columns))
+	}
+	if len(dc.Columns[0].Properties) != 0 {
+		t.Errorf("expected column id to be unclassified, got %v", dc.Columns[0].Properties)
+	}
+	ssn := dc.Columns[1].Properties
+	if len(ssn) != 1 || ssn[0].Label == nil || ssn[0].Label.Name != "Confidential" ||
+		ssn[0].InformationType == nil || ssn[0].InformationType.Name != "National ID" || ssn[0].Rank != SensitivityRankHigh {
+		t.Errorf("unexpected classification of column ssn %+v", ssn)
+	}
+	email := dc.Columns[2].Properties
+	if len(email) != 1 || email[0].Label != nil ||
+		email[0].InformationType == nil || email[0].InformationType.Name != "Contact Info" || email[0].Rank != SensitivityRankLow {
+		t.Errorf("unexpected classification of column email %+v", email)
+	}
+
+	for rows.Next() {
+	}
+	if !rows.NextResultSet() {
+		t.Fatal("expected a second result set")
+	}
+	if dc.Columns != nil || dc.Labels != nil {
+		t.Errorf("expected the classification to be cleared for the second result set, got %+v", dc)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataClassificationRows(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "ssn", Type: mssqltest.NVarChar, Sensitivity: []mssqltest.Sensitivity{{
+				Label: "Confidential", LabelID: "l1", Rank: 40,
+			}}}},
+			Rows: [][]interface{}{{"123-45-6789"}},
+		}}
+	})
+	defer srv.Close()
+	srv.DataClassification = true
+
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stmt, err := conn.(*Conn).prepareContext(context.Background(), "select ssn from customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	r, err := stmt.queryContext(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	rows := r.(*Rows)
+	dc := rows.DataClassification()
+	if dc == nil || dc.Rank != SensitivityRankCritical || len(dc.Columns) != 1 ||
+		len(dc.Columns[0].Properties) != 1 || dc.Columns[0].Properties[0].InformationType != nil {
+		t.Errorf("unexpected classification %+v", dc)
+	}
+}
@@ -0,0 +1,318 @@
+// Package dbcc runs DBCC commands and parses their output.
+//
+// Commands that support it run WITH TABLERESULTS, so that their output is
+// read from result sets rather than from informational messages.
+package dbcc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Querier runs queries, it is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// CheckDBOptions controls CheckDB.
+type CheckDBOptions struct {
+	// NoIndex skips the checks of nonclustered indexes.
+	NoIndex bool
+	// PhysicalOnly limits the checks to the physical structures.
+	PhysicalOnly bool
+	// DataPurity checks column values for values outside of their domain.
+	DataPurity bool
+	// ExtendedLogicalChecks checks indexed views, XML and spatial indexes.
+	ExtendedLogicalChecks bool
+	// AllMessages includes the informational messages of every object,
+	// only errors and the summary are returned otherwise.
+	AllMessages bool
+}
+
+// CheckDBMessage is a row of the output of DBCC CHECKDB.
+type CheckDBMessage struct {
+	Error   int
+	Level   int
+	State   int
+	Message string
+	// RepairLevel is the minimum repair level that fixes the error, empty
+	// for informational messages.
+	RepairLevel string
+	ObjectID    int64
+	IndexID     int64
+	PartitionID int64
+	File        int
+	Page        int
+	Slot        int
+}
+
+// IsError reports whether the message reports a consistency error.
+func (m *CheckDBMessage) IsError() bool {
+	return m.Level > 10
+}
+
+// CheckDB runs DBCC CHECKDB on a database and returns its output. The
+// server also raises an error when corruption was found, it is returned
+// along with the messages.
+func CheckDB(ctx context.Context, q Querier, database string, opts CheckDBOptions) ([]CheckDBMessage, error) {
+	query := "DBCC CHECKDB (" + quoteName(database)
+	if opts.NoIndex {
+		query += ", NOINDEX"
+	}
+	query += ") WITH TABLERESULTS"
+	if !opts.AllMessages {
+		query += ", NO_INFOMSGS"
+	}
+	if opts.PhysicalOnly {
+		query += ", PHYSICAL_ONLY"
+	}
+	if opts.DataPurity {
+		query += ", DATA_PURITY"
+	}
+	if opts.ExtendedLogicalChecks {
+		query += ", EXTENDED_LOGICAL_CHECKS"
+	}
+	rows, err := queryMaps(ctx, q, query)
+	res := make([]CheckDBMessage, len(rows))
+	for i, r := range rows {
+		res[i] = CheckDBMessage{
+			Error:       int(r.int("Error")),
+			Level:       int(r.int("Level")),
+			State:       int(r.int("State")),
+			Message:     r.str("MessageText"),
+			RepairLevel: r.str("RepairLevel"),
+			ObjectID:    r.int("ObjectId"),
+			IndexID:     r.int("IndexId"),
+			PartitionID: r.int("PartitionId"),
+			File:        int(r.int("File")),
+			Page:        int(r.int("Page")),
+			Slot:        int(r.int("Slot")),
+		}
+	}
+	return res, err
+}
+
+// LogSpace is the log usage of a database as reported by DBCC
+// SQLPERF(LOGSPACE).
+type LogSpace struct {
+	Database    string
+	SizeMB      float64
+	UsedPercent float64
+	Status      int
+}
+
+// SQLPerfLogSpace returns the log usage of every database.
+func SQLPerfLogSpace(ctx context.Context, q Querier) ([]LogSpace, error) {
+	rows, err := queryMaps(ctx, q, "DBCC SQLPERF(LOGSPACE) WITH NO_INFOMSGS")
+	if err != nil {
+		return nil, err
+	}
+	res := make([]LogSpace, len(rows))
+	for i, r := range rows {
+		res[i] = LogSpace{
+			Database:    r.str("Database Name"),
+			SizeMB:      r.float("Log Size (MB)"),
+			UsedPercent: r.float("Log Space Used (%)"),
+			Status:      int(r.int("Status")),
+		}
+	}
+	return res, nil
+}
+
+// InputBuffer is the last statement sent by a session.
+type InputBuffer struct {
+	// EventType is e.g. "Language Event" or "RPC Event".
+	EventType  string
+	Parameters int
+	EventInfo  string
+}
+
+// GetInputBuffer returns the last statement sent by a session.
+func GetInputBuffer(ctx context.Context, q Querier, sessionID int) (*InputBuffer, error) {
+	rows, err := queryMaps(ctx, q, fmt.Sprintf("DBCC INPUTBUFFER(%d) WITH NO_INFOMSGS", sessionID))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("dbcc: no input buffer for session %d", sessionID)
+	}
+	r := rows[0]
+	return &InputBuffer{
+		EventType:  r.str("EventType"),
+		Parameters: int(r.int("Parameters")),
+		EventInfo:  r.str("EventInfo"),
+	}, nil
+}
+
+// OpenTransaction is the oldest active transaction of a database.
+type OpenTransaction struct {
+	SessionID int
+	Name      string
+	LSN       string
+	StartTime time.Time
+	// Values holds every reported value by name, including the details
+	// of replicated transactions.
+	Values map[string]string
+}
+
+// OldestOpenTransaction returns the oldest active transaction of a
+// database, or nil if there is none.
+func OldestOpenTransaction(ctx context.Context, q Querier, database string) (*OpenTransaction, error) {
+	rows, err := queryMaps(ctx, q, "DBCC OPENTRAN ("+quoteName(database)+") WITH TABLERESULTS, NO_INFOMSGS")
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(rows))
+	for _, r := range rows {
+		values[r.str("Transaction Property")] = strings.TrimSpace(r.str("Transaction Value"))
+	}
+	spid, ok := values["OLDACT_SPID"]
+	if !ok {
+		return nil, nil
+	}
+	tr := &OpenTransaction{
+		Name:   values["OLDACT_NAME"],
+		LSN:    values["OLDACT_LSN"],
+		Values: values,
+	}
+	tr.SessionID, _ = strconv.Atoi(spid)
+	// the start time is formatted like "May 14 2021  9:05:01:483AM", with a
+	// colon before the milliseconds
+	start := strings.Join(strings.Fields(values["OLDACT_STARTTIME"]), " ")
+	if i := strings.LastIndexByte(start, ':'); i >= 0 {
+		start = start[:i] + "." + start[i+1:]
+	}
+	if t, err := time.Parse("Jan 2 2006 3:04:05.000PM", start); err == nil {
+		tr.StartTime = t
+	}
+	return tr, nil
+}
+
+// TraceFlag is the state of a trace flag.
+type TraceFlag struct {
+	Flag    int
+	Enabled bool
+	Global  bool
+	Session bool
+}
+
+// TraceStatus returns the enabled trace flags.
+func TraceStatus(ctx context.Context, q Querier) ([]TraceFlag, error) {
+	rows, err := queryMaps(ctx, q, "DBCC TRACESTATUS(-1) WITH NO_INFOMSGS")
+	if err != nil {
+		return nil, err
+	}
+	res := make([]TraceFlag, len(rows))
+	for i, r := range rows {
+		res[i] = TraceFlag{
+			Flag:    int(r.int("TraceFlag")),
+			Enabled: r.int("Status") != 0,
+			Global:  r.int("Global") != 0,
+			Session: r.int("Session") != 0,
+		}
+	}
+	return res, nil
+}
+
+// UserOptions returns the SET options of the session, e.g. "textsize" or
+// "isolation level", as reported by DBCC USEROPTIONS.
+func UserOptions(ctx context.Context, q Querier) (map[string]string, error) {
+	rows, err := queryMaps(ctx, q, "DBCC USEROPTIONS WITH NO_INFOMSGS")
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(rows))
+	for _, r := range rows {
+		res[r.str("Set Option")] = r.str("Value")
+	}
+	return res, nil
+}
+
+func quoteName(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
+
+// rowMap is a row by column name.
+type rowMap map[string]interface{}
+
+// queryMaps reads the rows of the first result set. It returns the rows
+// read before an error along with it.
+func queryMaps(ctx context.Context, q Querier, query string, args ...interface{}) ([]rowMap, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var res []rowMap
+	vals := make([]interface{}, len(cols))
+	for rows.Next() {
+		for i := range vals {
+			vals[i] = new(interface{})
+		}
+		if err = rows.Scan(vals...); err != nil {
+			return res, err
+		}
+		m := make(rowMap, len(cols))
+		for i, c := range cols {
+			m[c] = *(vals[i].(*interface{}))
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+func (r rowMap) str(name string) string {
+	switch v := r[name].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (r rowMap) int(name string) int64 {
+	switch v := r[name].(type) {
+	case int64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	case float64:
+		return int64(v)
+	case []byte:
+		n, _ := strconv.ParseInt(string(v), 10, 64)
+		return n
+	case string:
+		n, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return n
+	}
+	return 0
+}
+
+func (r rowMap) float(name string) float64 {
+	switch v := r[name].(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case []byte:
+		f, _ := strconv.ParseFloat(string(v), 64)
+		return f
+	}
+	return 0
+}
@@ -0,0 +1,124 @@
+package dbcc
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func openTestDB(t *testing.T, handler mssqltest.Handler) (*sql.DB, *mssqltest.Server) {
+	srv := mssqltest.NewServer(handler)
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return db, srv
+}
+
+func TestCheckDB(t *testing.T) {
+	db, srv := openTestDB(t, func(req *mssqltest.Request) []mssqltest.Response {
+		if req.SQL != "DBCC CHECKDB ([sales]) WITH TABLERESULTS, NO_INFOMSGS, PHYSICAL_ONLY" {
+			return []mssqltest.Response{mssqltest.Error{Number: 102, Class: 15, Message: "unexpected " + req.SQL}}
+		}
+		return []mssqltest.Response{
+			mssqltest.ResultSet{
+				Columns: []mssqltest.Column{
+					{Name: "Error", Type: mssqltest.Int},
+					{Name: "Level", Type: mssqltest.Int},
+					{Name: "State", Type: mssqltest.Int},
+					{Name: "MessageText", Type: mssqltest.NVarChar},
+					{Name: "RepairLevel", Type: mssqltest.NVarChar},
+					{Name: "ObjectId", Type: mssqltest.Int},
+					{Name: "Page", Type: mssqltest.Int},
+				},
+				Rows: [][]interface{}{
+					{int64(8928), int64(16), int64(1), "Object ID 581577110: Page (1:153) could not be processed.", "repair_allow_data_loss", int64(581577110), int64(153)},
+				},
+			},
+			mssqltest.Error{Number: 8990, Class: 16, Message: "CHECKDB found 0 allocation errors and 1 consistency errors."},
+		}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	msgs, err := CheckDB(context.Background(), db, "sales", CheckDBOptions{PhysicalOnly: true})
+	if err == nil || !strings.Contains(err.Error(), "consistency errors") {
+		t.Errorf("expected the summary error, got %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	m := msgs[0]
+	if !m.IsError() || m.Error != 8928 || m.ObjectID != 581577110 || m.Page != 153 || m.RepairLevel != "repair_allow_data_loss" {
+		t.Errorf("unexpected message %+v", m)
+	}
+}
+
+func TestSQLPerfLogSpace(t *testing.T) {
+	db, srv := openTestDB(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "Database Name", Type: mssqltest.NVarChar},
+				{Name: "Log Size (MB)", Type: mssqltest.Float},
+				{Name: "Log Space Used (%)", Type: mssqltest.Float},
+				{Name: "Status", Type: mssqltest.Int},
+			},
+			Rows: [][]interface{}{
+				{"master", 1.99, 42.5, int64(0)},
+				{"sales", 512.0, 3.25, int64(0)},
+			},
+		}}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	space, err := SQLPerfLogSpace(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(space) != 2 || space[1].Database != "sales" || space[1].SizeMB != 512 || space[1].UsedPercent != 3.25 {
+		t.Errorf("unexpected log space %+v", space)
+	}
+}
+
+func TestOldestOpenTransaction(t *testing.T) {
+	rows := [][]interface{}{
+		{"OLDACT_SPID", "57"},
+		{"OLDACT_UID", "1"},
+		{"OLDACT_NAME", "user_transaction"},
+		{"OLDACT_LSN", "(41:432:1)"},
+		{"OLDACT_STARTTIME", "May 14 2021  9:05:01:483AM"},
+	}
+	db, srv := openTestDB(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "Transaction Property", Type: mssqltest.NVarChar},
+				{Name: "Transaction Value", Type: mssqltest.NVarChar},
+			},
+			Rows: rows,
+		}}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	tr, err := OldestOpenTransaction(context.Background(), db, "sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr == nil || tr.SessionID != 57 || tr.Name != "user_transaction" || tr.LSN != "(41:432:1)" {
+		t.Fatalf("unexpected transaction %+v", tr)
+	}
+	if tr.StartTime.Hour() != 9 || tr.StartTime.Day() != 14 || tr.StartTime.Nanosecond() != 483e6 {
+		t.Errorf("unexpected start time %v", tr.StartTime)
+	}
+
+	rows = nil
+	if tr, err = OldestOpenTransaction(context.Background(), db, "sales"); err != nil || tr != nil {
+		t.Errorf("expected no transaction, got %+v, %v", tr, err)
+	}
+}
@@ -0,0 +1,205 @@
+package mssql
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/denisenkom/go-mssqldb/internal/decimal"
+)
+
+// maxDecimalDigits is the largest precision of decimal and numeric.
+const maxDecimalDigits = 38
+
+// Decimal is an exact decimal number of up to 38 digits, for the values of
+// decimal and numeric columns and parameters without the rounding of
+// float64. Scan nullable columns into a NullDecimal. As a parameter it is
+// sent as decimal(38, s), s being its scale. An output parameter is
+// declared with the scale of the value of its destination, the digits of
+// the output beyond it are rounded:
+//
+//	total, _ := mssql.ParseDecimal("0.0000")
+//	_, err = db.ExecContext(ctx, "sp_total", sql.Named("total", sql.Out{Dest: &total}))
+type Decimal struct {
+	dec decimal.Decimal
+}
+
+// NewDecimal returns the decimal unscaled * 10^-scale. It fails when the
+// unscaled value has more than 38 digits or the scale is out of 0..38.
+func NewDecimal(unscaled *big.Int, scale int) (Decimal, error) {
+	if scale < 0 || scale > maxDecimalDigits {
+		return Decimal{}, fmt.Errorf("mssql: decimal scale %d is out of range", scale)
+	}
+	digits := new(big.Int).Abs(unscaled).String()
+	if len(digits) > maxDecimalDigits {
+		return Decimal{}, fmt.Errorf("mssql: decimal %s has more than %d digits", unscaled, maxDecimalDigits)
+	}
+	if unscaled.Sign() < 0 {
+		digits = "-" + digits
+	}
+	dec, err := decimal.StringToDecimalScale(string(decimal.ScaleBytes(digits, uint8(scale))), uint8(scale))
+	if err != nil {
+		return Decimal{}, err
+	}
+	return Decimal{dec}, nil
+}
+
+// ParseDecimal parses a decimal number such as -123.4500, its scale is
+// the number of digits after the point.
+func ParseDecimal(s string) (Decimal, error) {
+	digits := s
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	scale := 0
+	if point := strings.IndexByte(digits, '.'); point != -1 {
+		scale = len(digits) - point - 1
+		digits = digits[:point] + digits[point+1:]
+	}
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("mssql: cannot parse %q as a decimal number", s)
+	}
+	var unscaled big.Int
+	unscaled.SetString(digits, 10)
+	if s[0] == '-' {
+		unscaled.Neg(&unscaled)
+	}
+	return NewDecimal(&unscaled, scale)
+}
+
+// Unscaled returns the unscaled value of d.
+func (d Decimal) Unscaled() *big.Int {
+	i := d.dec.BigInt()
+	return &i
+}
+
+// Scale returns the number of digits of d after the point.
+func (d Decimal) Scale() int {
+	return int(d.dec.Scale())
+}
+
+// Rat returns the value of d as a fraction.
+func (d Decimal) Rat() *big.Rat {
+	r := new(big.Rat).SetInt(d.Unscaled())
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.Scale())), nil)
+	return r.Quo(r, new(big.Rat).SetInt(scale))
+}
+
+// Float64 returns the nearest float64 to d.
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+// String formats d with its scale, such as -123.4500.
+func (d Decimal) String() string {
+	return d.dec.String()
+}
+
+// Scan implements the sql.Scanner interface, it accepts the decimal
+// numbers as the driver reads them, integers and strings.
+func (d *Decimal) Scan(src interface{}) (err error) {
+	switch v := src.(type) {
+	case []byte:
+		*d, err = ParseDecimal(string(v))
+	case string:
+		*d, err = ParseDecimal(v)
+	case int64:
+		*d, err = NewDecimal(big.NewInt(v), 0)
+	case float64:
+		*d, err = ParseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+	case nil:
+		return errors.New("mssql: cannot scan NULL into a Decimal, scan into a *Decimal")
+	default:
+		return fmt.Errorf("mssql: cannot scan a value of type %T into a Decimal", src)
+	}
+	return err
+}
+
+// param returns the parameter of d.
+func (d Decimal) param() param {
+	var res param
+	res.ti.TypeId = typeDecimalN
+	res.ti.Prec = maxDecimalDigits
+	res.ti.Scale = d.dec.Scale()
+	res.ti.Size = 17
+	res.buffer = make([]byte, 17)
+	if d.Unscaled().Sign() >= 0 {
+		res.buffer[0] = 1
+	}
+	// the unscaled magnitude in little endian order
+	ub := new(big.Int).Abs(d.Unscaled()).Bytes()
+	for i, j := 1, len(ub)-1; j >= 0; i, j = i+1, j-1 {
+		res.buffer[i] = ub[j]
+	}
+	return res
+}
+
+// rescale returns d with scale digits after the point, rounded half away
+// from zero. It fails when the result has more than precision digits.
+func (d Decimal) rescale(precision, scale int) (Decimal, error) {
+	u := d.Unscaled()
+	if diff := scale - d.Scale(); diff >= 0 {
+		u.Mul(u, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(diff)), nil))
+	} else {
+		div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-diff)), nil)
+		var r big.Int
+		u.QuoRem(u, div, &r)
+		if r.Abs(&r).Lsh(&r, 1).Cmp(div) >= 0 {
+			u.Add(u, big.NewInt(int64(d.Unscaled().Sign())))
+		}
+	}
+	if len(new(big.Int).Abs(u).String()) > precision {
+		return Decimal{}, fmt.Errorf("mssql: decimal %s does not fit decimal(%d, %d)", d, precision, scale)
+	}
+	return NewDecimal(u, scale)
+}
+
+// NullDecimal is a Decimal that may be NULL. As an output parameter its
+// Decimal declares the scale of the parameter, as a Decimal does.
+type NullDecimal struct {
+	Decimal Decimal
+	Valid   bool // Valid is true if Decimal is not NULL
+}
+
+// Scan implements the sql.Scanner interface.
+func (n *NullDecimal) Scan(src interface{}) error {
+	if src == nil {
+		*n = NullDecimal{}
+		return nil
+	}
+	n.Valid = true
+	return n.Decimal.Scan(src)
+}
+
+// param returns the parameter of n, a NULL decimal(38, s) when n is not
+// valid.
+func (n NullDecimal) param() param {
+	res := n.Decimal.param()
+	if !n.Valid {
+		res.buffer = []byte{}
+	}
+	return res
+}
+
+// isDecimalType reports whether the values of a type are read as the text
+// of decimal numbers.
+func isDecimalType(typeID uint8) bool {
+	switch typeID {
+	case typeDecimal, typeDecimalN, typeNumeric, typeNumericN, typeMoney, typeMoney4, typeMoneyN:
+		return true
+	}
+	return false
+}
+
+// decimalsToStrings converts the decimal values of row to strings, see
+// msdsn.Config.DecimalAsString.
+func decimalsToStrings(columns []columnStruct, row []interface{}) {
+	for i := range columns {
+		if b, ok := row[i].([]byte); ok && isDecimalType(columns[i].ti.TypeId) {
+			row[i] = string(b)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"database/sql"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		s        string
+		unscaled string
+		scale    int
+		str      string
+	}{
+		{"0", "0", 0, "0"},
+		{"-123.4500", "-1234500", 4, "-123.4500"},
+		{"+.5", "5", 1, "0.5"},
+		{"12345678901234567890.123456789012345678", "12345678901234567890123456789012345678", 18, "12345678901234567890.123456789012345678"},
+		{"-0.00000000000000000000000000000000000001", "-1", 38, "-0.00000000000000000000000000000000000001"},
+	}
+	for _, tt := range tests {
+		d, err := ParseDecimal(tt.s)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q) failed: %v", tt.s, err)
+			continue
+		}
+		if d.Unscaled().String() != tt.unscaled || d.Scale() != tt.scale || d.String() != tt.str {
+			t.Errorf("ParseDecimal(%q) = %s * 10^-%d (%s), expected %s * 10^-%d (%s)", tt.s, d.Unscaled(), d.Scale(), d, tt.unscaled, tt.scale, tt.str)
+		}
+	}
+	for _, s := range []string{"", "-", ".", "1.2.3", "1e5", "--1", "abc", strings.Repeat("9", 39)} {
+		if _, err := ParseDecimal(s); err == nil {
+			t.Errorf("expected ParseDecimal(%q) to fail", s)
+		}
+	}
+}
+
+func TestDecimalRat(t *testing.T) {
+	d, err := ParseDecimal("-0.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := d.Rat(); r.Cmp(big.NewRat(-1, 10)) != 0 {
+		t.Errorf("expected -1/10, got %s", r)
+	}
+	if f := d.Float64(); f != -0.1 {
+		t.Errorf("expected -0.1, got %v", f)
+	}
+	if _, err := NewDecimal(big.NewInt(1), 39); err == nil {
+		t.Error("expected an error for a scale out of range")
+	}
+}
+
+func TestDecimalScan(t *testing.T) {
+	var d Decimal
+	// decimal values are read as their text
+	if err := d.Scan(decodeDecimal(38, 2, []byte{0, 0x39, 0x30, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})); err != nil {
+		t.Fatal(err)
+	}
+	if d.String() != "-123.45" {
+		t.Errorf("expected -123.45, got %s", d)
+	}
+	if err := d.Scan(int64(42)); err != nil || d.String() != "42" {
+		t.Errorf("expected 42, got %s and error %v", d, err)
+	}
+	if err := d.Scan(nil); err == nil {
+		t.Error("expected an error scanning NULL")
+	}
+}
+
+func TestDecimalRescale(t *testing.T) {
+	for _, tt := range []struct {
+		in    string
+		scale int
+		out   string
+	}{
+		{"1.25", 1, "1.3"},
+		{"-1.25", 1, "-1.3"},
+		{"1.24", 1, "1.2"},
+		{"-0.04", 1, "0.0"},
+		{"1.5", 3, "1.500"},
+		{"99.5", 0, "100"},
+	} {
+		d, err := ParseDecimal(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := d.rescale(maxDecimalDigits, tt.scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.String() != tt.out {
+			t.Errorf("%s to scale %d: expected %s, got %s", tt.in, tt.scale, tt.out, r)
+		}
+	}
+	d, _ := ParseDecimal("99.5")
+	if _, err := d.rescale(2, 0); err == nil {
+		t.Error("expected 100 not to fit decimal(2, 0)")
+	}
+}
+
+func TestDecimalParam(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	const value = "-12345678901234567890.123456789012345678"
+	d, err := ParseDecimal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert into t values (@p1)", d); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	if v := reqs[len(reqs)-1].Param("@p1").Value; v != value {
+		t.Errorf("expected %s, got %v", value, v)
+	}
+}
+
+func TestDecimalAsString(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "d", Type: mssqltest.Decimal}, {Name: "m", Type: mssqltest.Money}, {Name: "n", Type: mssqltest.Decimal}},
+			Rows:    [][]interface{}{{"-123.4500", "922337203685477.5807", nil}},
+		}}
+	})
+	defer srv.Close()
+	for _, asString := range []bool{false, true} {
+		dsn := srv.DSN()
+		if asString {
+			dsn += "&decimalasstring=true"
+		}
+		c, err := NewConnector(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := sql.OpenDB(c)
+		defer db.Close()
+
+		rows, err := db.Query("select d, m, n from t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d, m, n interface{}
+		if !rows.Next() {
+			t.Fatal(rows.Err())
+		}
+		if err := rows.Scan(&d, &m, &n); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		if asString {
+			if d != "-123.4500" || m != "922337203685477.5807" || n != nil {
+				t.Errorf("expected strings, got %#v, %#v and %#v", d, m, n)
+			}
+			if st := types[0].ScanType(); st.Kind() != reflect.String {
+				t.Errorf("expected the scan type string, got %v", st)
+			}
+		} else if b, ok := d.([]byte); !ok || string(b) != "-123.4500" {
+			t.Errorf("expected []byte, got %#v", d)
+		}
+	}
+}
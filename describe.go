@@ -0,0 +1,247 @@
+package mssql
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// describedParam is the type sp_describe_undeclared_parameters suggests
+// for a parameter of a statement.
+type describedParam struct {
+	typeID    int
+	maxLength int // in bytes, -1 for max
+	precision int
+	scale     int
+}
+
+// the system type ids of the suggested types
+const (
+	sysTypeDate          = 40
+	sysTypeTime          = 41
+	sysTypeDateTime2     = 42
+	sysTypeTinyInt       = 48
+	sysTypeSmallInt      = 52
+	sysTypeInt           = 56
+	sysTypeSmallDateTime = 58
+	sysTypeReal          = 59
+	sysTypeDateTime      = 61
+	sysTypeDecimal       = 106
+	sysTypeNumeric       = 108
+	sysTypeVarBinary     = 165
+	sysTypeVarChar       = 167
+	sysTypeChar          = 175
+	sysTypeNVarChar      = 231
+	sysTypeNChar         = 239
+)
+
+// maxDescribedQueries bounds the number of statements whose parameters a
+// connection keeps, the cache is emptied when it is full.
+const maxDescribedQueries = 256
+
+// describeParams returns the suggested types of the parameters of query by
+// name, such as @p1, described once by connection. The statements the
+// server cannot describe, such as those using temporary tables, have no
+// suggested types.
+func (c *Conn) describeParams(ctx context.Context, query string) (map[string]describedParam, error) {
+	if params, ok := c.describedParams[query]; ok {
+		return params, nil
+	}
+	params, err := c.describeUndeclaredParameters(ctx, query)
+	if err != nil {
+		if _, ok := err.(Error); !ok {
+			return nil, err
+		}
+		params = nil
+	}
+	if c.describedParams == nil || len(c.describedParams) >= maxDescribedQueries {
+		c.describedParams = make(map[string]map[string]describedParam)
+	}
+	c.describedParams[query] = params
+	return params, nil
+}
+
+func (c *Conn) describeUndeclaredParameters(ctx context.Context, query string) (map[string]describedParam, error) {
+	// keep the output parameters of the statement being sent
+	outs := c.outs
+	c.outs = outputs{}
+	defer func() { c.outs = outs }()
+
+	stmt, err := c.prepareContext(ctx, "sp_describe_undeclared_parameters")
+	if err != nil {
+		return nil, err
+	}
+	res, err := stmt.queryContext(ctx, []namedValue{{Name: "tsql", Ordinal: 1, Value: query}})
+	if err != nil {
+		return nil, err
+	}
+	rows := res.(*Rows)
+	defer rows.Close()
+
+	columns := map[string]int{}
+	for i, col := range rows.cols {
+		columns[col.ColName] = i
+	}
+	for _, name := range []string{"name", "suggested_system_type_id", "suggested_max_length", "suggested_precision", "suggested_scale"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.New("mssql: unexpected response of sp_describe_undeclared_parameters")
+		}
+	}
+	intColumn := func(dest []driver.Value, name string) int {
+		n, _ := dest[columns[name]].(int64)
+		return int(n)
+	}
+	params := map[string]describedParam{}
+	dest := make([]driver.Value, len(rows.cols))
+	for {
+		if err = rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name, _ := dest[columns["name"]].(string)
+		params[name] = describedParam{
+			typeID:    intColumn(dest, "suggested_system_type_id"),
+			maxLength: intColumn(dest, "suggested_max_length"),
+			precision: intColumn(dest, "suggested_precision"),
+			scale:     intColumn(dest, "suggested_scale"),
+		}
+	}
+	return params, nil
+}
+
+// makeDescribedParam returns the parameter of val as the type d, reporting
+// false when val is not a plain Go value converting to it, which keeps the
+// default type of val.
+func (s *Stmt) makeDescribedParam(val driver.Value, d describedParam) (res param, ok bool) {
+	var v driver.Value
+	switch val := val.(type) {
+	case string:
+		switch d.typeID {
+		case sysTypeVarChar, sysTypeChar:
+			// the text is sent as is, as UTF-8
+			if !isASCII(val) && !s.c.sess.varcharCollation().IsUTF8() {
+				return res, false
+			}
+			v = VarChar(val)
+		case sysTypeNVarChar, sysTypeNChar:
+			v = val
+		}
+	case []byte:
+		if d.typeID == sysTypeVarBinary {
+			v = val
+		}
+	case int64:
+		switch d.typeID {
+		case sysTypeTinyInt, sysTypeSmallInt, sysTypeInt:
+			return makeIntParam(val, d.typeID)
+		case sysTypeDecimal, sysTypeNumeric:
+			dec, err := NewDecimal(big.NewInt(val), 0)
+			if err != nil {
+				return res, false
+			}
+			v = dec
+		}
+	case float64:
+		switch d.typeID {
+		case sysTypeReal:
+			res.ti.TypeId = typeFltN
+			res.ti.Size = 4
+			res.buffer = make([]byte, 4)
+			binary.LittleEndian.PutUint32(res.buffer, math.Float32bits(float32(val)))
+			return res, true
+		case sysTypeDecimal, sysTypeNumeric:
+			if math.IsInf(val, 0) || math.IsNaN(val) {
+				return res, false
+			}
+			// the floats with more than 38 digits, or fractional digits,
+			// are sent as floats for the server to convert
+			dec, err := ParseDecimal(strconv.FormatFloat(val, 'f', -1, 64))
+			if err != nil {
+				return res, false
+			}
+			v = dec
+		}
+	case Decimal:
+		if d.typeID == sysTypeDecimal || d.typeID == sysTypeNumeric {
+			v = val
+		}
+	case time.Time:
+		switch d.typeID {
+		case sysTypeDate:
+			v = DateOnly(val)
+		case sysTypeTime:
+			v = Time{Value: val, Scale: d.scale}
+		case sysTypeDateTime2:
+			v = DateTime2{Value: val, Scale: d.scale}
+		case sysTypeSmallDateTime:
+			v = SmallDateTime(val)
+		case sysTypeDateTime:
+			v = DateTime1(val)
+		}
+	}
+	if v == nil {
+		return res, false
+	}
+	if dec, isDec := v.(Decimal); isDec {
+		var err error
+		if v, err = dec.rescale(d.precision, d.scale); err != nil {
+			return res, false
+		}
+	}
+	res, err := s.makeParam(v)
+	if err != nil {
+		return res, false
+	}
+	switch res.ti.TypeId {
+	case typeDecimalN:
+		res.ti.Prec = uint8(d.precision)
+	case typeNVarChar, typeBigVarChar, typeBigVarBin:
+		// the declared length of the target, unless the value is longer
+		switch {
+		case d.maxLength == -1:
+			res.ti.Size = 0
+		case len(res.buffer) <= d.maxLength:
+			res.ti.Size = d.maxLength
+		}
+	}
+	return res, true
+}
+
+// makeIntParam returns the tinyint, smallint or int parameter of val,
+// reporting false when val is out of the range of the type.
+func makeIntParam(val int64, typeID int) (res param, ok bool) {
+	var min, max int64
+	switch typeID {
+	case sysTypeTinyInt:
+		res.ti.Size, min, max = 1, 0, math.MaxUint8
+	case sysTypeSmallInt:
+		res.ti.Size, min, max = 2, math.MinInt16, math.MaxInt16
+	default:
+		res.ti.Size, min, max = 4, math.MinInt32, math.MaxInt32
+	}
+	if val < min || val > max {
+		return res, false
+	}
+	res.ti.TypeId = typeIntN
+	res.buffer = make([]byte, 8)
+	binary.LittleEndian.PutUint64(res.buffer, uint64(val))
+	res.buffer = res.buffer[:res.ti.Size]
+	return res, true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestDescribeParameters(t *testing.T) {
+	describes := 0
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if req.Proc != "sp_describe_undeclared_parameters" {
+			return nil
+		}
+		describes++
+		tsql, _ := req.Param("@tsql").Value.(string)
+		if strings.Contains(tsql, "#tmp") {
+			return []mssqltest.Response{mssqltest.Error{Number: 208, Class: 16, Message: "Invalid object name '#tmp'."}}
+		}
+		row := func(ordinal int, name string, typeID int, typeName string, maxLength, precision, scale int) []interface{} {
+			return []interface{}{ordinal, name, typeID, typeName, maxLength, precision, scale}
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "parameter_ordinal", Type: mssqltest.Int},
+				{Name: "name", Type: mssqltest.NVarChar},
+				{Name: "suggested_system_type_id", Type: mssqltest.Int},
+				{Name: "suggested_system_type_name", Type: mssqltest.NVarChar},
+				{Name: "suggested_max_length", Type: mssqltest.Int},
+				{Name: "suggested_precision", Type: mssqltest.Int},
+				{Name: "suggested_scale", Type: mssqltest.Int},
+			},
+			Rows: [][]interface{}{
+				row(1, "@p1", 167, "varchar(10)", 10, 0, 0),
+				row(2, "@p2", 52, "smallint", 2, 5, 0),
+				row(3, "@p3", 40, "date", 3, 10, 0),
+				row(4, "@p4", 106, "decimal(10,2)", 9, 10, 2),
+				row(5, "@p5", 231, "nvarchar(max)", -1, 0, 0),
+				row(6, "@p6", 167, "varchar(10)", 10, 0, 0),
+				row(7, "@p7", 56, "int", 4, 10, 0),
+				row(8, "@p8", 42, "datetime2(3)", 8, 23, 3),
+			},
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN() + "&describeparameters=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	const query = "select * from t where a = @p1 and b = @p2 and c = @p3 and d = @p4 and e = @p5 and f = @p6 and g = @p7 and h = @p8"
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec(query, "abc", 7, when, 1.5, "x", "é", int64(1)<<40, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if describes != 1 {
+		t.Errorf("expected the statement described once, got %d", describes)
+	}
+	reqs := srv.Requests()
+	req := reqs[len(reqs)-1]
+	const decls = "@p1 varchar(10),@p2 smallint,@p3 date,@p4 decimal(10, 2),@p5 nvarchar(max),@p6 nvarchar(1),@p7 bigint,@p8 datetime2(3)"
+	if req.ParamDecls != decls {
+		t.Errorf("expected the declarations\n%s\ngot\n%s", decls, req.ParamDecls)
+	}
+	if v := req.Param("@p4").Value; v != "1.50" {
+		t.Errorf("expected the decimal 1.50, got %v", v)
+	}
+	if v := req.Param("@p2").Value; v != int64(7) {
+		t.Errorf("expected the smallint 7, got %v", v)
+	}
+
+	// the statements the server cannot describe keep the default types
+	if _, err := db.Exec("select * from #tmp where a = @p1", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	reqs = srv.Requests()
+	if decls := reqs[len(reqs)-1].ParamDecls; decls != "@p1 nvarchar(3)" {
+		t.Errorf("expected the default declaration, got %s", decls)
+	}
+}
+
+func TestDescribedDecimalFromFloat(t *testing.T) {
+	s := &Stmt{c: &Conn{sess: &tdsSession{}}}
+	d := describedParam{typeID: sysTypeDecimal, precision: 38, scale: 30}
+	p, ok := s.makeDescribedParam(1.5, d)
+	if !ok || p.ti.TypeId != typeDecimalN || p.ti.Scale != 30 {
+		t.Errorf("expected 1.5 as decimal(38, 30), got %v %+v", ok, p.ti)
+	}
+	// too many digits for a decimal
+	for _, f := range []float64{1e40, 1.2345678901234567e-25} {
+		if p, ok := s.makeDescribedParam(f, d); ok {
+			t.Errorf("expected %v to keep its float type, got %+v %x", f, p.ti, p.buffer)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package mssql
+
+import "context"
+
+type directExecutionKey struct{}
+
+// WithDirectExecution returns a context that runs the statements run with
+// it directly in the batch of the request, rather than wrapped in
+// sp_executesql, or not when direct is false, overriding the
+// directexecution connection parameter. Some statements behave differently
+// in sp_executesql, which runs them in a scope of their own: the SET
+// options they change and the temporary tables they create are gone when
+// it returns.
+//
+// Statements without parameters are sent as they are, even when their text
+// looks like the name of a stored procedure, such as CHECKPOINT. TDS only
+// runs a parameterized text in a procedure, statements with parameters
+// are still sent through sp_executesql, and stored procedures called by
+// name are called with their parameters.
+func WithDirectExecution(ctx context.Context, direct bool) context.Context {
+	return context.WithValue(ctx, directExecutionKey{}, direct)
+}
+
+// directExecution reports whether the statement runs directly in the batch
+// of the request.
+func (s *Stmt) directExecution(ctx context.Context) bool {
+	if direct, ok := ctx.Value(directExecutionKey{}).(bool); ok {
+		return direct
+	}
+	return s.c.connector != nil && s.c.connector.params.DirectExecution
+}
@@ -0,0 +1,81 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestDirectExecution(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := WithDirectExecution(context.Background(), true)
+	if _, err = db.ExecContext(ctx, "CHECKPOINT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.ExecContext(ctx, "set language @p1; select @n", "us_english", sql.Named("n", 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.ExecContext(ctx, "sp_test", sql.Named("a", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(reqs))
+	}
+	if reqs[0].Type != mssqltest.SQLBatch || reqs[0].SQL != "CHECKPOINT" {
+		t.Errorf("expected the batch CHECKPOINT, got %v %q %s", reqs[0].Type, reqs[0].SQL, reqs[0].Proc)
+	}
+	if reqs[1].Type != mssqltest.RPC || reqs[1].Proc != "sp_executesql" || reqs[1].SQL != "set language @p1; select @n" {
+		t.Errorf("expected the statement in sp_executesql, got %v %s %q", reqs[1].Type, reqs[1].Proc, reqs[1].SQL)
+	}
+	if reqs[2].Type != mssqltest.RPC || reqs[2].Proc != "sp_test" || reqs[2].Param("@a") == nil {
+		t.Errorf("expected the call of sp_test, got %v %s", reqs[2].Type, reqs[2].Proc)
+	}
+}
+
+func TestDirectExecutionParameter(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN()+"&directexecution=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("select @p1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.ExecContext(WithDirectExecution(context.Background(), false), "sp_who"); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(reqs))
+	}
+	if reqs[0].Type != mssqltest.SQLBatch || reqs[0].SQL != "select 1" {
+		t.Errorf("expected a direct batch, got %v %q", reqs[0].Type, reqs[0].SQL)
+	}
+	if reqs[1].Type != mssqltest.RPC || reqs[1].Proc != "sp_executesql" {
+		t.Errorf("expected an sp_executesql call, got %v %s", reqs[1].Type, reqs[1].Proc)
+	}
+	if reqs[2].Type != mssqltest.RPC || reqs[2].Proc != "sp_who" {
+		t.Errorf("expected the call of sp_who, got %v %s", reqs[2].Type, reqs[2].Proc)
+	}
+}
@@ -0,0 +1,270 @@
+package mssql
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"sync/atomic"
+)
+
+// Always Encrypted columns of a server with a secure enclave, configured
+// with enclave computations, can be compared with LIKE and ranges: the
+// server evaluates the comparison inside the enclave, to which the client
+// sends the column encryption keys. Before the keys are sent, the client
+// attests that the enclave runs the expected code and establishes a
+// session key with it, by an ECDH key exchange on P-384.
+//
+// https://docs.microsoft.com/en-us/sql/relational-databases/security/encryption/always-encrypted-enclaves
+
+// attestation protocols, as sent in the attestation parameters
+const (
+	attestationProtocolAAS  = 1
+	attestationProtocolNone = 2
+	attestationProtocolHGS  = 3
+)
+
+// eccPublicKeyMagic is the magic of a BCRYPT_ECCPUBLIC_BLOB holding an
+// ECDH P-384 public key, the format of the keys exchanged with the enclave.
+const (
+	eccPublicKeyMagic = 0x334b4345 // ECK3
+	eccKeySize        = 48
+)
+
+// EnclaveAttestationVerifier attests the secure enclave of a server for
+// the hgs and aas attestation protocols, with the Host Guardian Service or
+// Microsoft Azure Attestation. Without a verifier, the connections of
+// these protocols cannot use the enclave.
+type EnclaveAttestationVerifier interface {
+	// Parameters returns the protocol specific attestation parameters sent
+	// to the server, such as a nonce, for the attestation service at url.
+	Parameters(ctx context.Context, protocol, url string) ([]byte, error)
+	// Verify checks the attestation info the server returned in response
+	// to parameters. It returns the public key of the enclave, as a
+	// BCRYPT_ECCPUBLIC_BLOB, once verified to be signed by the attested
+	// enclave, and the id of the enclave session.
+	Verify(ctx context.Context, protocol, url string, parameters, info []byte) (enclaveKey []byte, sessionID uint64, err error)
+}
+
+// noAttestation verifies the enclaves of the none attestation protocol,
+// which only establishes a session with the enclave. Its attestation info
+// is the size of the public key of the enclave as a ULONG, the key and the
+// session id as a ULONGLONG.
+type noAttestation struct{}
+
+func (noAttestation) Parameters(ctx context.Context, protocol, url string) ([]byte, error) {
+	return nil, nil
+}
+
+func (noAttestation) Verify(ctx context.Context, protocol, url string, parameters, info []byte) ([]byte, uint64, error) {
+	if len(info) < 4 {
+		return nil, 0, errors.New("mssql: invalid enclave attestation info")
+	}
+	size := binary.LittleEndian.Uint32(info)
+	if uint64(len(info)) != 4+uint64(size)+8 {
+		return nil, 0, errors.New("mssql: invalid enclave attestation info")
+	}
+	return info[4 : 4+size], binary.LittleEndian.Uint64(info[4+size:]), nil
+}
+
+// enclaveSession is an established session with a secure enclave.
+type enclaveSession struct {
+	id  uint64
+	key []byte
+	// counter numbers the packages sent to the enclave, which rejects
+	// replayed packages
+	counter uint64
+}
+
+// enclaveSessionCache holds the enclave sessions of a Connector by server,
+// database and attestation URL, so that attestation happens once for all
+// the connections of a pool.
+type enclaveSessionCache struct {
+	mu       sync.Mutex
+	sessions map[string]*enclaveSession
+}
+
+func (c *enclaveSessionCache) get(key string) *enclaveSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessions[key]
+}
+
+func (c *enclaveSessionCache) put(key string, s *enclaveSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessions == nil {
+		c.sessions = map[string]*enclaveSession{}
+	}
+	c.sessions[key] = s
+}
+
+// enclaveAttestation is an attestation in progress, started by sending
+// the parameters with sp_describe_parameter_encryption.
+type enclaveAttestation struct {
+	verifier   EnclaveAttestationVerifier
+	protocol   string
+	url        string
+	parameters []byte
+	// private is the ephemeral ECDH key of the client
+	private []byte
+	// request is the attestation parameters sent to the server
+	request []byte
+}
+
+// enclaveSessionKey returns the key of the enclave sessions of the
+// connection in enclaveSessionCache.
+func (c *Conn) enclaveSessionKey() string {
+	p := c.connector.params
+	return serverName(p) + "/" + c.sess.database + "/" + p.EnclaveAttestationURL
+}
+
+// startAttestation makes the attestation parameters of a new enclave
+// session: the protocol, its parameters and the public key of the client.
+func (c *Conn) startAttestation(ctx context.Context) (*enclaveAttestation, error) {
+	p := c.connector.params
+	a := &enclaveAttestation{
+		verifier: c.connector.EnclaveAttestationVerifier,
+		protocol: p.EnclaveAttestationProtocol,
+		url:      p.EnclaveAttestationURL,
+	}
+	var id uint32
+	switch a.protocol {
+	case "hgs":
+		id = attestationProtocolHGS
+	case "aas":
+		id = attestationProtocolAAS
+	default:
+		id = attestationProtocolNone
+		a.verifier = noAttestation{}
+	}
+	if a.verifier == nil {
+		return nil, fmt.Errorf("mssql: enclave attestation protocol %s requires Connector.EnclaveAttestationVerifier", a.protocol)
+	}
+	var err error
+	if a.parameters, err = a.verifier.Parameters(ctx, a.protocol, a.url); err != nil {
+		return nil, err
+	}
+	private, x, y, err := elliptic.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	a.private = private
+	public := eccPublicKeyBlob(x, y)
+
+	req := make([]byte, 8, 8+len(a.parameters)+4+len(public))
+	binary.LittleEndian.PutUint32(req, id)
+	binary.LittleEndian.PutUint32(req[4:], uint32(len(a.parameters)))
+	req = append(req, a.parameters...)
+	req = append(req, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(req[len(req)-4:], uint32(len(public)))
+	a.request = append(req, public...)
+	return a, nil
+}
+
+// finish verifies the attestation info of the server and derives the
+// session key from the public key of the enclave.
+func (a *enclaveAttestation) finish(ctx context.Context, info []byte) (*enclaveSession, error) {
+	enclaveKey, id, err := a.verifier.Verify(ctx, a.protocol, a.url, a.parameters, info)
+	if err != nil {
+		return nil, err
+	}
+	x, y, err := parseECCPublicKeyBlob(enclaveKey)
+	if err != nil {
+		return nil, err
+	}
+	sx, _ := elliptic.P384().ScalarMult(x, y, a.private)
+	secret := make([]byte, eccKeySize)
+	putBigEndian(secret, sx)
+	key := sha256.Sum256(secret)
+	return &enclaveSession{id: id, key: key[:]}, nil
+}
+
+func eccPublicKeyBlob(x, y *big.Int) []byte {
+	blob := make([]byte, 8+2*eccKeySize)
+	binary.LittleEndian.PutUint32(blob, eccPublicKeyMagic)
+	binary.LittleEndian.PutUint32(blob[4:], eccKeySize)
+	putBigEndian(blob[8:8+eccKeySize], x)
+	putBigEndian(blob[8+eccKeySize:], y)
+	return blob
+}
+
+// putBigEndian writes v to b, padded with leading zeros.
+func putBigEndian(b []byte, v *big.Int) {
+	buf := v.Bytes()
+	copy(b[len(b)-len(buf):], buf)
+}
+
+func parseECCPublicKeyBlob(blob []byte) (x, y *big.Int, err error) {
+	if len(blob) != 8+2*eccKeySize || binary.LittleEndian.Uint32(blob) != eccPublicKeyMagic ||
+		binary.LittleEndian.Uint32(blob[4:]) != eccKeySize {
+		return nil, nil, errors.New("mssql: invalid enclave public key")
+	}
+	x = new(big.Int).SetBytes(blob[8 : 8+eccKeySize])
+	y = new(big.Int).SetBytes(blob[8+eccKeySize:])
+	if !elliptic.P384().IsOnCurve(x, y) {
+		return nil, nil, errors.New("mssql: invalid enclave public key")
+	}
+	return x, y, nil
+}
+
+// enclavePackage returns the package that sends the column encryption keys
+// of entries to the enclave for query: the id of the enclave session
+// followed by the counter of the package, the SHA-256 hash of the query
+// and the keys, encrypted with the session key. Every key is given by the
+// database id, key id, key version and metadata version of its entry.
+// Refresh updates an entry whose key cannot be decrypted, see resolveCEK.
+func (c *Conn) enclavePackage(ctx context.Context, session *enclaveSession, query string, entries []*cekTableEntry, refresh func(ctx context.Context, entry *cekTableEntry) (*cekTableEntry, error)) ([]byte, error) {
+	plaintext := make([]byte, 8, 8+sha256.Size+len(entries)*(20+32))
+	binary.LittleEndian.PutUint64(plaintext, atomic.AddUint64(&session.counter, 1))
+	hash := sha256.Sum256(str2ucs2(query))
+	plaintext = append(plaintext, hash[:]...)
+	for _, e := range entries {
+		e := e
+		key, err := c.connector.resolveCEK(ctx, e, func(ctx context.Context) (*cekTableEntry, error) {
+			return refresh(ctx, e)
+		})
+		if err != nil {
+			return nil, err
+		}
+		var hdr [20]byte
+		binary.LittleEndian.PutUint32(hdr[0:], uint32(e.databaseID))
+		binary.LittleEndian.PutUint32(hdr[4:], uint32(e.keyID))
+		binary.LittleEndian.PutUint32(hdr[8:], uint32(e.keyVersion))
+		copy(hdr[12:], e.mdVersion)
+		plaintext = append(plaintext, hdr[:]...)
+		plaintext = append(plaintext, key...)
+	}
+	cell, err := encryptCell(session.key, plaintext, false)
+	if err != nil {
+		return nil, err
+	}
+	pkg := make([]byte, 8, 8+len(cell))
+	binary.LittleEndian.PutUint64(pkg, session.id)
+	return append(pkg, cell...), nil
+}
+
+// writeEnclavePackage writes the EnclavePackage that follows the headers
+// of the requests of sessions with a secure enclave. A nil package is not
+// written, an empty one tells that the request does not use the enclave.
+func writeEnclavePackage(w io.Writer, pkg []byte) error {
+	if pkg == nil {
+		return nil
+	}
+	if len(pkg) > 0xffff {
+		return fmt.Errorf("mssql: enclave package of %d bytes is too large", len(pkg))
+	}
+	var size [2]byte
+	binary.LittleEndian.PutUint16(size[:], uint16(len(pkg)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(pkg)
+	return err
+}
@@ -0,0 +1,180 @@
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+// testEnclave answers sp_describe_parameter_encryption like a server with
+// a VBS enclave attested by the none protocol, it needs the key of the
+// encrypted parameter.
+type testEnclave struct {
+	t           *testing.T
+	mu          sync.Mutex
+	attestation int
+	sessionKey  []byte
+}
+
+const testEnclaveSessionID = 0x1122334455667788
+
+func (e *testEnclave) handle(req *mssqltest.Request) []mssqltest.Response {
+	if req.Proc != "sp_describe_parameter_encryption" {
+		return nil
+	}
+	keys := mssqltest.ResultSet{
+		Columns: []mssqltest.Column{
+			{Name: "column_encryption_key_ordinal", Type: mssqltest.Int},
+			{Name: "database_id", Type: mssqltest.Int},
+			{Name: "column_encryption_key_id", Type: mssqltest.Int},
+			{Name: "column_encryption_key_version", Type: mssqltest.Int},
+			{Name: "column_encryption_key_metadata_version", Type: mssqltest.VarBinary},
+			{Name: "column_encryption_key_encrypted_value", Type: mssqltest.VarBinary},
+			{Name: "column_master_key_store_provider_name", Type: mssqltest.NVarChar},
+			{Name: "column_master_key_path", Type: mssqltest.NVarChar},
+			{Name: "column_encryption_key_encryption_algorithm_name", Type: mssqltest.NVarChar},
+			{Name: "column_encryption_key_requested_by_enclave", Type: mssqltest.Bit},
+		},
+		Rows: [][]interface{}{{1, 5, 7, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte("cmk1"), "TEST_STORE", "cmk1", "RSA_OAEP", true}},
+	}
+	params := mssqltest.ResultSet{
+		Columns: []mssqltest.Column{
+			{Name: "parameter_ordinal", Type: mssqltest.Int},
+			{Name: "parameter_name", Type: mssqltest.NVarChar},
+			{Name: "column_encryption_algorithm", Type: mssqltest.Int},
+			{Name: "column_encryption_type", Type: mssqltest.Int},
+			{Name: "column_encryption_key_ordinal", Type: mssqltest.Int},
+			{Name: "column_encryption_normalization_rule_version", Type: mssqltest.Int},
+		},
+		Rows: [][]interface{}{{1, "@p1", cipherAlgorithmAEAD, encryptionTypeRandomized, 1, normalizationVersion}},
+	}
+	p := req.Param("@attestationParameters")
+	if p == nil {
+		return []mssqltest.Response{keys, params}
+	}
+
+	// protocol, parameters and the public key of the client
+	b := p.Value.([]byte)
+	if protocol := binary.LittleEndian.Uint32(b); protocol != attestationProtocolNone {
+		e.t.Errorf("got attestation protocol %d", protocol)
+	}
+	b = b[8+binary.LittleEndian.Uint32(b[4:]):]
+	x, y, err := parseECCPublicKeyBlob(b[4:])
+	if err != nil {
+		e.t.Error(err)
+		return nil
+	}
+	private, ex, ey, err := elliptic.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		e.t.Error(err)
+		return nil
+	}
+	sx, _ := elliptic.P384().ScalarMult(x, y, private)
+	secret := make([]byte, eccKeySize)
+	putBigEndian(secret, sx)
+	key := sha256.Sum256(secret)
+	e.mu.Lock()
+	e.attestation++
+	e.sessionKey = key[:]
+	e.mu.Unlock()
+
+	public := eccPublicKeyBlob(ex, ey)
+	info := make([]byte, 4, 4+len(public)+8)
+	binary.LittleEndian.PutUint32(info, uint32(len(public)))
+	info = append(info, public...)
+	info = append(info, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(info[4+len(public):], testEnclaveSessionID)
+	return []mssqltest.Response{keys, params, mssqltest.ResultSet{
+		Columns: []mssqltest.Column{{Name: "attestation_info", Type: mssqltest.VarBinary}},
+		Rows:    [][]interface{}{{info}},
+	}}
+}
+
+func TestEnclaveSession(t *testing.T) {
+	enclave := &testEnclave{t: t}
+	srv := mssqltest.NewServer(enclave.handle)
+	defer srv.Close()
+	srv.ColumnEncryption = true
+	srv.EnclaveType = "VBS"
+
+	c, err := NewConnector(srv.DSN() + "&columnencryption=true&enclaveattestationprotocol=none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ColumnEncryptionKeyProviders = map[string]ColumnEncryptionKeyProvider{
+		"TEST_STORE": &testCEKProvider{keys: map[string][]byte{"cmk1": testCellKey}},
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	const query = "select id from t where name like @p1"
+	for i := 0; i < 2; i++ {
+		rows, err := db.QueryContext(context.Background(), query, "jo%")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	if v := srv.Logins()[0].ColumnEncryptionVersion; v != 3 {
+		t.Errorf("expected column encryption version 3, got %d", v)
+	}
+	if enclave.attestation != 1 {
+		t.Errorf("expected the enclave to be attested once, got %d", enclave.attestation)
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(reqs))
+	}
+	if reqs[0].EnclavePackage == nil || len(reqs[0].EnclavePackage) != 0 {
+		t.Errorf("expected an empty enclave package for sp_describe_parameter_encryption, got %x", reqs[0].EnclavePackage)
+	}
+	if reqs[2].Param("@attestationParameters") != nil {
+		t.Error("expected the enclave session to be reused")
+	}
+	for i, req := range []*mssqltest.Request{reqs[1], reqs[3]} {
+		pkg := req.EnclavePackage
+		if len(pkg) < 8 || binary.LittleEndian.Uint64(pkg) != testEnclaveSessionID {
+			t.Fatalf("unexpected enclave package %x", pkg)
+		}
+		plaintext, err := decryptCell(enclave.sessionKey, pkg[8:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counter := binary.LittleEndian.Uint64(plaintext); counter != uint64(i+1) {
+			t.Errorf("expected counter %d, got %d", i+1, counter)
+		}
+		hash := sha256.Sum256(str2ucs2(query))
+		if !bytes.Equal(plaintext[8:8+sha256.Size], hash[:]) {
+			t.Error("the enclave package does not hash the query")
+		}
+		keys := plaintext[8+sha256.Size:]
+		if len(keys) != 20+32 || binary.LittleEndian.Uint32(keys[4:]) != 7 || !bytes.Equal(keys[20:], testCellKey) {
+			t.Errorf("unexpected enclave keys %x", keys)
+		}
+	}
+}
+
+func TestEnclaveAttestationVerifierRequired(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	srv.ColumnEncryption = true
+	srv.EnclaveType = "VBS"
+	db, err := sql.Open("sqlserver", srv.DSN()+"&columnencryption=true&enclaveattestationprotocol=hgs&enclaveattestationurl=https://hgs.example.com/Attestation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec("insert into t values (@p1)", 1)
+	if err == nil || !strings.Contains(err.Error(), "EnclaveAttestationVerifier") {
+		t.Fatalf("expected hgs attestation to fail without a verifier, got %v", err)
+	}
+}
@@ -55,6 +55,29 @@ func (e Error) SQLErrorLineNo() int32 {
 	return e.LineNo
 }
 
+// PasswordExpiredError is returned when the server refuses a login because
+// the password of the SQL login expired or must be changed. The login
+// succeeds with the newpassword option, which also sets the new password.
+type PasswordExpiredError struct {
+	// Err is the login error of the server.
+	Err Error
+}
+
+func (e PasswordExpiredError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the login error of the server.
+func (e PasswordExpiredError) Unwrap() error {
+	return e.Err
+}
+
+// isPasswordExpired reports whether err is error 18487, the password of the
+// login expired, or 18488, the password must be changed.
+func isPasswordExpired(err Error) bool {
+	return err.Number == 18487 || err.Number == 18488
+}
+
 type StreamError struct {
 	Message string
 }
@@ -0,0 +1,149 @@
+package mssql
+
+// ErrorInfo describes an SQL Server error number.
+type ErrorInfo struct {
+	Number int32
+	// Class is the severity the server usually raises the error with.
+	Class uint8
+	// Description explains the error and, where there is one, what usually
+	// fixes it.
+	Description string
+	// Retryable is set for transient errors, such as deadlocks, lock
+	// timeouts and the unavailability of Azure SQL databases during
+	// reconfiguration, after which running the transaction again can
+	// succeed.
+	Retryable bool
+}
+
+// LookupError returns the catalog entry of an error number. The catalog
+// covers the errors applications commonly handle, it is not a copy of
+// sys.messages.
+func LookupError(number int32) (ErrorInfo, bool) {
+	info, ok := errorCatalog[number]
+	return info, ok
+}
+
+// Info returns the catalog entry of the error number, see LookupError.
+func (e Error) Info() (ErrorInfo, bool) {
+	return LookupError(e.Number)
+}
+
+// Retryable reports whether the error is transient according to the
+// catalog. Errors that were raised along with it, listed in All, are
+// considered as well.
+func (e Error) Retryable() bool {
+	if info, ok := LookupError(e.Number); ok && info.Retryable {
+		return true
+	}
+	for _, ae := range e.All {
+		if info, ok := LookupError(ae.Number); ok && info.Retryable {
+			return true
+		}
+	}
+	return false
+}
+
+var errorCatalog = map[int32]ErrorInfo{}
+
+func init() {
+	for _, info := range errorList {
+		errorCatalog[info.Number] = info
+	}
+}
+
+var errorList = []ErrorInfo{
+	// syntax and name resolution
+	{102, 15, "Incorrect syntax, the statement could not be parsed.", false},
+	{105, 15, "Unclosed quotation mark after a string, check for unescaped quotes.", false},
+	{137, 15, "A variable is used without being declared, or a parameter was not passed.", false},
+	{156, 15, "Incorrect syntax near a keyword, reserved words used as names must be quoted with brackets.", false},
+	{201, 16, "A procedure expects a parameter that was not supplied.", false},
+	{207, 16, "Invalid column name.", false},
+	{208, 16, "Invalid object name, the table or view does not exist or is in another schema or database.", false},
+	{2714, 16, "An object with the same name already exists in the database.", false},
+	{2812, 16, "The stored procedure could not be found.", false},
+	{3701, 11, "The object cannot be dropped because it does not exist or permission is missing.", false},
+	{8144, 16, "Too many arguments were passed to a procedure or function.", false},
+
+	// data errors
+	{241, 16, "Conversion failed when converting a date or time from a string, pass time.Time values instead of strings.", false},
+	{245, 16, "Conversion failed when converting a value to a numeric type.", false},
+	{515, 16, "A NULL was inserted into a column that does not allow nulls.", false},
+	{547, 16, "The statement conflicts with a foreign key or check constraint.", false},
+	{2601, 14, "A duplicate key row was inserted into a unique index.", false},
+	{2627, 14, "A primary key or unique constraint was violated by a duplicate key.", false},
+	{2628, 16, "String or binary data would be truncated in a column.", false},
+	{4863, 16, "Bulk load data conversion error, a value is too long for its column.", false},
+	{4864, 16, "Bulk load data conversion error, a value does not match the type of its column.", false},
+	{8114, 16, "Error converting a value to the type of a column or parameter.", false},
+	{8115, 16, "Arithmetic overflow, a value does not fit its type.", false},
+	{8134, 16, "Divide by zero.", false},
+	{8152, 16, "String or binary data would be truncated.", false},
+	{50000, 16, "A user defined message raised by RAISERROR or THROW.", false},
+
+	// transactions and concurrency
+	{266, 16, "The transaction count after EXECUTE differs from the count before it, a procedure left a transaction open or closed one it did not begin.", false},
+	{601, 12, "A NOLOCK scan could not continue because of data movement.", true},
+	{1204, 19, "The server ran out of lock resources.", true},
+	{1205, 13, "The transaction was chosen as a deadlock victim, run it again.", true},
+	{1222, 16, "The lock request timed out.", true},
+	{3902, 16, "COMMIT TRANSACTION has no corresponding BEGIN TRANSACTION.", false},
+	{3903, 16, "ROLLBACK TRANSACTION has no corresponding BEGIN TRANSACTION.", false},
+	{3930, 16, "The transaction is doomed and cannot be committed, it must be rolled back.", false},
+	{3952, 16, "Snapshot isolation is not allowed in the database.", false},
+	{3960, 16, "A snapshot isolation transaction aborted on an update conflict with another transaction.", true},
+	{3961, 16, "A snapshot isolation transaction failed because an object it read was changed by a concurrent DDL statement.", true},
+	{41301, 16, "A dependency of a memory optimized transaction failed to commit.", true},
+	{41302, 16, "A memory optimized row was updated by another transaction.", true},
+	{41305, 16, "Repeatable read validation of a memory optimized transaction failed.", true},
+	{41325, 16, "Serializable validation of a memory optimized transaction failed.", true},
+	{41839, 16, "A memory optimized transaction exceeded the maximum number of commit dependencies.", true},
+
+	// permissions and logins
+	{229, 14, "Permission was denied on an object.", false},
+	{230, 14, "Permission was denied on a column.", false},
+	{262, 14, "Permission was denied in the database, e.g. to create objects.", false},
+	{916, 14, "The login cannot access the database under the current security context.", false},
+	{4060, 11, "The database requested by the login cannot be opened, it may not exist or be unavailable.", true},
+	{4064, 11, "The default database of the login cannot be opened.", false},
+	{18401, 14, "Login failed, the server is in script upgrade mode.", true},
+	{18452, 14, "Login failed, the login is from an untrusted domain and cannot be used with Windows authentication.", false},
+	{18456, 14, "Login failed, check the user name, password and default database.", false},
+	{18486, 14, "Login failed, the account is locked out.", false},
+	{18487, 14, "Login failed, the password has expired.", false},
+	{18488, 14, "Login failed, the password must be changed.", false},
+
+	// resources
+	{701, 17, "There is insufficient system memory to run the query.", true},
+	{1105, 17, "Space could not be allocated because the filegroup is full.", false},
+	{6005, 14, "The server is shutting down.", true},
+	{8623, 16, "The query processor ran out of internal resources, the query is too complex.", false},
+	{8645, 17, "A timeout occurred while waiting for memory resources to run the query.", true},
+	{8651, 17, "The requested memory grant is not available.", true},
+	{9002, 17, "The transaction log of the database is full.", false},
+
+	// connections and Azure SQL
+	{64, 20, "The connection was closed by the server during login or while running a request.", true},
+	{233, 20, "The connection was closed before the login completed.", true},
+	{4221, 16, "Login to a readable secondary failed while the replica was starting row versioning.", true},
+	{10053, 20, "The connection was aborted by the client network stack.", true},
+	{10054, 20, "The connection was reset by the server.", true},
+	{10060, 20, "The connection attempt timed out.", true},
+	{10928, 20, "The resource limit of the database, such as its worker or session limit, was reached.", true},
+	{10929, 20, "The minimum resource guarantee of the database cannot be met, the server is too busy.", true},
+	{40143, 16, "The service encountered an error processing the request.", true},
+	{40197, 20, "The service encountered an error processing the request, usually because of a reconfiguration or failover.", true},
+	{40501, 20, "The service is currently busy.", true},
+	{40540, 20, "The service encountered an error processing the request.", true},
+	{40544, 20, "The database has reached its size quota.", false},
+	{40549, 16, "The session was terminated because of a long running transaction.", false},
+	{40551, 16, "The session was terminated because of excessive tempdb usage.", false},
+	{40552, 16, "The session was terminated because of excessive transaction log usage.", false},
+	{40553, 16, "The session was terminated because of excessive memory usage.", false},
+	{40613, 17, "The database is not currently available, usually during a reconfiguration or failover.", true},
+	{42108, 16, "The database is paused and cannot accept connections until it is resumed.", true},
+	{42109, 16, "The database is resuming and cannot accept connections yet.", true},
+	{49918, 16, "The request cannot be processed, there are not enough resources.", true},
+	{49919, 16, "The request cannot be processed, too many operations are in progress for the subscription.", true},
+	{49920, 16, "The request cannot be processed, too many operations are in progress.", true},
+}
@@ -0,0 +1,35 @@
+package mssql
+
+import "testing"
+
+func TestLookupError(t *testing.T) {
+	info, ok := LookupError(1205)
+	if !ok || !info.Retryable || info.Class != 13 {
+		t.Errorf("unexpected entry %+v, %v", info, ok)
+	}
+	if _, ok = LookupError(-1); ok {
+		t.Error("found an unknown error number")
+	}
+	seen := map[int32]bool{}
+	for _, info := range errorList {
+		if seen[info.Number] {
+			t.Errorf("error %d is listed twice", info.Number)
+		}
+		seen[info.Number] = true
+	}
+}
+
+func TestErrorRetryable(t *testing.T) {
+	deadlock := Error{Number: 1205, Message: "Transaction was deadlocked"}
+	if !deadlock.Retryable() {
+		t.Error("a deadlock is retryable")
+	}
+	// the last error of a batch is not always the cause
+	err := Error{Number: 3621, Message: "The statement has been terminated.", All: []Error{deadlock}}
+	if !err.Retryable() {
+		t.Error("an error raised along with a deadlock is retryable")
+	}
+	if (Error{Number: 2627}).Retryable() {
+		t.Error("a duplicate key is not retryable")
+	}
+}
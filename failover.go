@@ -0,0 +1,113 @@
+package mssql
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+)
+
+// failoverCache remembers, for the connections of a Connector, which
+// server of a database mirroring pair was the principal the last time a
+// connection was made and the partner the servers advertise. New
+// connections then go to the principal first, even after a failover
+// promoted the partner of the connection string.
+type failoverCache struct {
+	mu sync.Mutex
+	// principal is the server of the last connection, partner is the
+	// partner that server advertised, as host or host\instance
+	principal string
+	partner   string
+}
+
+// targets returns the configurations of the servers to try, the principal
+// first. The partner of the connection string is used until a server
+// advertises one.
+func (f *failoverCache) targets(p msdsn.Config) []msdsn.Config {
+	if p.AdminConnection {
+		// the admin connection is meant for this server only
+		return []msdsn.Config{p}
+	}
+	f.mu.Lock()
+	principal, partner := f.principal, f.partner
+	f.mu.Unlock()
+
+	primary := p
+	if principal != "" && principal != serverName(p) {
+		// the partner was promoted
+		primary = failoverConfig(p, principal, 0)
+	}
+	if partner == "" {
+		if p.FailOverPartner == "" {
+			return []msdsn.Config{primary}
+		}
+		return []msdsn.Config{primary, failoverConfig(p, p.FailOverPartner, p.FailOverPort)}
+	}
+	if partner == serverName(p) {
+		return []msdsn.Config{primary, p}
+	}
+	port := uint64(0)
+	if partner == p.FailOverPartner {
+		port = p.FailOverPort
+	}
+	return []msdsn.Config{primary, failoverConfig(p, partner, port)}
+}
+
+// connected records a connection to the server of p that advertised the
+// given partner.
+func (f *failoverCache) connected(p msdsn.Config, partner string) {
+	if p.AdminConnection {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.principal = serverName(p)
+	if partner != "" {
+		f.partner = partner
+	}
+}
+
+// partnerChanged records a partner advertised during a session of the
+// server of p.
+func (f *failoverCache) partnerChanged(p msdsn.Config, partner string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.principal == serverName(p) && partner != "" {
+		f.partner = partner
+	}
+}
+
+// serverName returns the host or host\instance of p.
+func serverName(p msdsn.Config) string {
+	if p.Instance != "" {
+		return p.Host + `\` + p.Instance
+	}
+	return p.Host
+}
+
+// failoverConfig returns a copy of p for the server given as host or
+// host\instance, with the port of the instance resolved by the SQL Server
+// Browser service. A host without an instance uses port, or the port of p
+// if zero.
+func failoverConfig(p msdsn.Config, server string, port uint64) msdsn.Config {
+	host, instance := server, ""
+	if i := strings.IndexByte(server, '\\'); i >= 0 {
+		host, instance = server[:i], server[i+1:]
+	}
+	if p.ServerSPN == msdsn.ExpandServerSPN(msdsn.DefaultServerSPNTemplate, p.Host, p.Port, p.Instance) {
+		// the default SPN follows the server
+		p.ServerSPN = msdsn.DefaultServerSPNTemplate
+	}
+	p.Host, p.Instance = host, instance
+	switch {
+	case instance != "":
+		p.Port = 0
+	case port != 0:
+		p.Port = port
+	}
+	if p.TLSConfig != nil && !p.HostInCertificateProvided {
+		p.TLSConfig = p.TLSConfig.Clone()
+		p.TLSConfig.ServerName = host
+	}
+	return p
+}
@@ -0,0 +1,112 @@
+package mssql
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+// hostsDialer dials the address registered for the host of addr, hosts
+// without an address are unreachable.
+type hostsDialer struct {
+	mu    sync.Mutex
+	hosts map[string]string
+}
+
+func (d *hostsDialer) set(host, addr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts[host] = addr
+}
+
+func (d *hostsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	target, ok := d.hosts[host]
+	d.mu.Unlock()
+	if !ok {
+		return nil, errors.New("unreachable host " + host)
+	}
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, target)
+}
+
+func (d *hostsDialer) HostName() string { return "" }
+
+func TestFailoverPartnerCache(t *testing.T) {
+	handler := func(req *mssqltest.Request) []mssqltest.Response { return nil }
+	primary := mssqltest.NewServer(handler)
+	defer primary.Close()
+	primary.Partner = "mirror"
+	mirror := mssqltest.NewServer(handler)
+	defer mirror.Close()
+	mirror.Partner = "principal"
+
+	d := &hostsDialer{hosts: map[string]string{
+		"principal": primary.Addr().String(),
+		"mirror":    mirror.Addr().String(),
+	}}
+	// the connection string names no partner, it is learnt from the server
+	c, err := NewConnector("sqlserver://principal?encrypt=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Dialer = d
+	connect := func() *Conn {
+		conn, err := c.Connect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn.(*Conn)
+	}
+
+	conn := connect()
+	if conn.Server() != "principal" || conn.FailoverPartner() != "mirror" {
+		t.Errorf("got server %q and partner %q", conn.Server(), conn.FailoverPartner())
+	}
+	conn.Close()
+
+	// the principal fails, the advertised partner takes over
+	d.set("principal", "127.0.0.1:1")
+	conn = connect()
+	if conn.Server() != "mirror" || conn.FailoverPartner() != "principal" {
+		t.Errorf("got server %q and partner %q", conn.Server(), conn.FailoverPartner())
+	}
+	conn.Close()
+
+	// the promoted mirror stays the first choice once the principal is back
+	d.set("principal", primary.Addr().String())
+	before := len(primary.Logins())
+	conn = connect()
+	if conn.Server() != "mirror" {
+		t.Errorf("expected the promoted mirror, got %q", conn.Server())
+	}
+	conn.Close()
+	if n := len(primary.Logins()); n != before {
+		t.Errorf("expected no login on the former principal, got %d", n-before)
+	}
+}
+
+func TestFailoverPartnerInstance(t *testing.T) {
+	c, err := NewConnector("sqlserver://principal?failoverpartner=mirror%5CINST&failoverport=1500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := c.failover.targets(c.params)
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(targets))
+	}
+	if p := targets[1]; p.Host != "mirror" || p.Instance != "INST" || p.Port != 0 {
+		t.Errorf("got partner host %q, instance %q and port %d", p.Host, p.Instance, p.Port)
+	}
+	if p := targets[1]; p.ServerSPN != "MSSQLSvc/%host%:%port%" {
+		t.Errorf("expected the default SPN template, got %q", p.ServerSPN)
+	}
+}
@@ -36,12 +36,12 @@ const (
 	fedAuthADALWorkflowMSI = 0x03
 )
 
-// newSecurityTokenConnector creates a new connector from a Config and a token provider.
+// NewSecurityTokenConnector creates a new connector from a Config and a token provider.
 // When invoked, token provider implementations should contact the security token
 // service specified and obtain the appropriate token, or return an error
 // to indicate why a token is not available.
 // The returned connector may be used with sql.OpenDB.
-func newSecurityTokenConnector(config msdsn.Config, tokenProvider func(ctx context.Context) (string, error)) (*Connector, error) {
+func NewSecurityTokenConnector(config msdsn.Config, tokenProvider func(ctx context.Context) (string, error)) (*Connector, error) {
 	if tokenProvider == nil {
 		return nil, errors.New("mssql: tokenProvider cannot be nil")
 	}
@@ -0,0 +1,187 @@
+package mssql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HierarchyID is a hierarchyid value as its path, such as /1/3/2/ or
+// /1.1/ for a node inserted between /1/ and /2/, / being the root. It is
+// scanned from the binary form of hierarchyid columns and sent as
+// a parameter in that form, which the server converts to hierarchyid
+// implicitly. Scan nullable columns into a *HierarchyID.
+type HierarchyID string
+
+// hierarchyPattern is the bit pattern of the integers of a range of
+// a hierarchyid path: the prefix, then the bits of the layout, x being the
+// bits of the integer less min from the most significant, followed by the
+// bit telling whether the integer ends its level.
+type hierarchyPattern struct {
+	min, max int64
+	prefix   string
+	layout   string
+}
+
+var hierarchyPatterns = []hierarchyPattern{
+	{-281479271682120, -4294971465, "000100", strings.Repeat("x", 35) + "0xxxxxx0xxx0x1xxx"},
+	{-4294971464, -4169, "000101", strings.Repeat("x", 19) + "0xxxxxx0xxx0x1xxx"},
+	{-4168, -73, "000110", "xxxxx0xxx0x1xxx"},
+	{-72, -9, "0010", "xx0x1xxx"},
+	{-8, -1, "00111", "xxx"},
+	{0, 3, "01", "xx"},
+	{4, 7, "100", "xx"},
+	{8, 15, "101", "xxx"},
+	{16, 79, "110", "xx0x1xxx"},
+	{80, 1103, "1110", "xxx0xxx0x1xxx"},
+	{1104, 5199, "11110", "xxxxx0xxx0x1xxx"},
+	{5200, 4294972495, "111110", strings.Repeat("x", 19) + "0xxxxxx0xxx0x1xxx"},
+	{4294972496, 281479271683151, "111111", strings.Repeat("x", 35) + "0xxxxxx0xxx0x1xxx"},
+}
+
+// Scan implements the sql.Scanner interface, it accepts the binary form of
+// hierarchyid values.
+func (h *HierarchyID) Scan(src interface{}) error {
+	switch b := src.(type) {
+	case []byte:
+		path, err := decodeHierarchyID(b)
+		if err != nil {
+			return err
+		}
+		*h = HierarchyID(path)
+		return nil
+	case nil:
+		return errors.New("mssql: cannot scan NULL into a HierarchyID, scan into a *HierarchyID")
+	}
+	return fmt.Errorf("mssql: cannot scan a value of type %T into a HierarchyID", src)
+}
+
+// param returns the varbinary parameter of the binary form of h.
+func (h HierarchyID) param() (res param, err error) {
+	b, err := encodeHierarchyID(string(h))
+	if err != nil {
+		return res, err
+	}
+	res.ti.TypeId = typeBigVarBin
+	res.buffer = b
+	res.ti.Size = len(b)
+	return res, nil
+}
+
+// encodeHierarchyID encodes the path of a hierarchyid. The integers but the
+// last of a level are encoded incremented, so that /1.1/ sorts after /1/.
+func encodeHierarchyID(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "/") || !strings.HasSuffix(path, "/") {
+		return nil, fmt.Errorf("mssql: invalid hierarchyid %q, it must start and end with /", path)
+	}
+	var bits []byte
+	if path != "/" {
+		for _, level := range strings.Split(path[1:len(path)-1], "/") {
+			labels := strings.Split(level, ".")
+			for i, label := range labels {
+				n, err := strconv.ParseInt(label, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("mssql: invalid hierarchyid %q: %v", path, err)
+				}
+				last := i == len(labels)-1
+				if !last {
+					n++
+				}
+				if bits, err = appendHierarchyLabel(bits, n, last); err != nil {
+					return nil, fmt.Errorf("mssql: invalid hierarchyid %q: %v", path, err)
+				}
+			}
+		}
+	}
+	b := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		b[i/8] |= bit << uint(7-i%8)
+	}
+	return b, nil
+}
+
+func appendHierarchyLabel(bits []byte, n int64, last bool) ([]byte, error) {
+	for _, p := range hierarchyPatterns {
+		if n < p.min || n > p.max {
+			continue
+		}
+		for _, c := range p.prefix {
+			bits = append(bits, byte(c-'0'))
+		}
+		v := uint64(n - p.min)
+		shift := uint(strings.Count(p.layout, "x"))
+		for _, c := range p.layout {
+			if c == 'x' {
+				shift--
+				bits = append(bits, byte(v>>shift&1))
+			} else {
+				bits = append(bits, byte(c-'0'))
+			}
+		}
+		if last {
+			return append(bits, 1), nil
+		}
+		return append(bits, 0), nil
+	}
+	return nil, fmt.Errorf("%d is out of range", n)
+}
+
+// decodeHierarchyID decodes the binary form of a hierarchyid to its path.
+func decodeHierarchyID(b []byte) (string, error) {
+	bit := func(i int) byte {
+		return b[i/8] >> uint(7-i%8) & 1
+	}
+	// the bits after the last level are zero
+	end := len(b) * 8
+	for end > 0 && bit(end-1) == 0 {
+		end--
+	}
+	path := []byte{'/'}
+	errInvalid := fmt.Errorf("mssql: invalid hierarchyid 0x%X", b)
+	for pos := 0; pos < end; {
+		var p *hierarchyPattern
+		for i := range hierarchyPatterns {
+			prefix := hierarchyPatterns[i].prefix
+			if pos+len(prefix) > end {
+				continue
+			}
+			match := true
+			for j, c := range prefix {
+				if bit(pos+j) != byte(c-'0') {
+					match = false
+					break
+				}
+			}
+			if match {
+				p = &hierarchyPatterns[i]
+				break
+			}
+		}
+		if p == nil || pos+len(p.prefix)+len(p.layout)+1 > end {
+			return "", errInvalid
+		}
+		pos += len(p.prefix)
+		var v uint64
+		for _, c := range p.layout {
+			switch {
+			case c == 'x':
+				v = v<<1 | uint64(bit(pos))
+			case bit(pos) != byte(c-'0'):
+				return "", errInvalid
+			}
+			pos++
+		}
+		n := p.min + int64(v)
+		if bit(pos) == 1 {
+			path = append(strconv.AppendInt(path, n, 10), '/')
+		} else {
+			path = append(strconv.AppendInt(path, n-1, 10), '.')
+		}
+		pos++
+	}
+	if path[len(path)-1] != '/' {
+		return "", errInvalid
+	}
+	return string(path), nil
+}
@@ -0,0 +1,92 @@
+package mssql
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHierarchyID(t *testing.T) {
+	values := []struct {
+		path, hex string
+	}{
+		{"/", ""},
+		{"/1/", "58"},
+		{"/2/", "68"},
+		{"/1/1/", "5AC0"},
+		{"/1/2/3/", "5B5E"},
+		{"/-1/", "3F80"},
+		{"/1.1/", "62C0"},
+	}
+	for _, v := range values {
+		b, err := encodeHierarchyID(v.path)
+		if err != nil {
+			t.Errorf("%s: %v", v.path, err)
+			continue
+		}
+		if expected, _ := hex.DecodeString(v.hex); !bytes.Equal(b, expected) {
+			t.Errorf("%s: expected 0x%s, got 0x%X", v.path, v.hex, b)
+		}
+		var h HierarchyID
+		if err := h.Scan(b); err != nil {
+			t.Errorf("%s: %v", v.path, err)
+		} else if string(h) != v.path {
+			t.Errorf("expected %s, got %s", v.path, h)
+		}
+	}
+}
+
+func TestHierarchyIDRoundTrip(t *testing.T) {
+	labels := []string{"-281479271682120", "-4294971465", "-4294971464", "-4169", "-4168",
+		"-73", "-72", "-9", "-8", "-1", "0", "3", "4", "7", "8", "15", "16", "79", "80",
+		"1103", "1104", "5199", "5200", "4294972495", "4294972496", "281479271683150"}
+	for _, l := range labels {
+		for _, path := range []string{"/" + l + "/", "/3/" + l + "." + l + "/7/", "/" + l + ".0.5/"} {
+			b, err := encodeHierarchyID(path)
+			if err != nil {
+				t.Errorf("%s: %v", path, err)
+				continue
+			}
+			got, err := decodeHierarchyID(b)
+			if err != nil {
+				t.Errorf("%s: %v", path, err)
+			} else if got != path {
+				t.Errorf("expected %s, got %s", path, got)
+			}
+		}
+	}
+	// the encoding sorts as the nodes
+	ordered := []string{"/", "/-1/", "/0/", "/1/", "/1/1/", "/1.1/", "/2/", "/80/", "/5200/"}
+	for i := 1; i < len(ordered); i++ {
+		a, _ := encodeHierarchyID(ordered[i-1])
+		b, _ := encodeHierarchyID(ordered[i])
+		if bytes.Compare(a, b) >= 0 {
+			t.Errorf("expected %s to sort before %s", ordered[i-1], ordered[i])
+		}
+	}
+}
+
+func TestHierarchyIDErrors(t *testing.T) {
+	for _, path := range []string{"", "1/", "/1", "/a/", "//", "/1..2/", "/281479271683152/"} {
+		if _, err := HierarchyID(path).param(); err == nil {
+			t.Errorf("expected an error for %q", path)
+		}
+	}
+	var h HierarchyID
+	if err := h.Scan(nil); err == nil {
+		t.Error("expected an error scanning NULL into a HierarchyID")
+	}
+	if err := h.Scan([]byte{0x50}); err == nil {
+		t.Error("expected an error for a truncated value")
+	}
+	if err := h.Scan(int64(1)); err == nil {
+		t.Error("expected an error scanning an int64")
+	}
+	p, err := HierarchyID("/1/").param()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ti.TypeId != typeBigVarBin || p.ti.Size != 1 || p.buffer[0] != 0x58 {
+		t.Errorf("unexpected parameter %+v", p)
+	}
+}
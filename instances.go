@@ -0,0 +1,106 @@
+package mssql
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// browserBroadcastWait bounds how long ListInstances collects the replies
+// to a broadcast when ctx has no deadline.
+const browserBroadcastWait = 2 * time.Second
+
+// Instance is an instance of SQL Server advertised by the SQL Server
+// Browser service.
+type Instance struct {
+	ServerName   string
+	InstanceName string
+	IsClustered  bool
+	Version      string
+	// TCPPort is the port of the instance, zero if TCP is disabled.
+	TCPPort int
+	// PipeName is the named pipe of the instance, if named pipes are
+	// enabled.
+	PipeName string
+	// Properties holds all the properties of the instance, including
+	// those of other protocols such as "via" or "rpc".
+	Properties map[string]string
+}
+
+// ListInstances asks the SQL Server Browser service of host for its
+// instances. An empty host broadcasts the request on the local network and
+// returns the instances of every server that replies before ctx is done,
+// or within two seconds if ctx has no deadline.
+func ListInstances(ctx context.Context, host string) ([]Instance, error) {
+	if host == "" {
+		return broadcastInstances(ctx)
+	}
+	return listInstances(ctx, &net.Dialer{}, host)
+}
+
+func listInstances(ctx context.Context, d Dialer, host string) ([]Instance, error) {
+	instances, err := getInstances(ctx, d, host)
+	if err != nil {
+		return nil, err
+	}
+	return newInstances(instances), nil
+}
+
+func broadcastInstances(ctx context.Context) ([]Instance, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(browserBroadcastWait)
+	}
+	conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	// CLNT_BCAST_EX
+	if _, err = conn.WriteTo([]byte{2}, &net.UDPAddr{IP: net.IPv4bcast, Port: 1434}); err != nil {
+		return nil, err
+	}
+	var res []Instance
+	resp := make([]byte, 16*1024-1)
+	for {
+		n, _, err := conn.ReadFrom(resp)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return res, nil
+			}
+			return res, err
+		}
+		res = append(res, newInstances(parseInstances(resp[:n]))...)
+	}
+}
+
+func newInstances(instances map[string]map[string]string) []Instance {
+	res := make([]Instance, 0, len(instances))
+	for _, props := range instances {
+		port, _ := strconv.Atoi(props["tcp"])
+		res = append(res, Instance{
+			ServerName:   props["ServerName"],
+			InstanceName: props["InstanceName"],
+			IsClustered:  strings.EqualFold(props["IsClustered"], "Yes"),
+			Version:      props["Version"],
+			TCPPort:      port,
+			PipeName:     props["np"],
+			Properties:   props,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].InstanceName < res[j].InstanceName })
+	return res
+}
@@ -0,0 +1,45 @@
+package mssql
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestListInstances(t *testing.T) {
+	browser, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer browser.Close()
+	go func() {
+		buf := make([]byte, 512)
+		_, addr, err := browser.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		resp := "ServerName;DB1;InstanceName;SQLEXPRESS;IsClustered;No;Version;15.0.2000.5;tcp;50105;np;\\\\DB1\\pipe\\MSSQL$SQLEXPRESS\\sql\\query;;" +
+			"ServerName;DB1;InstanceName;MSSQLSERVER;IsClustered;Yes;Version;16.0.1000.6;np;\\\\DB1\\pipe\\sql\\query;;"
+		browser.WriteTo(append([]byte{5, byte(len(resp)), byte(len(resp) >> 8)}, resp...), addr)
+	}()
+
+	d := &browserDialer{browser: browser.LocalAddr().String()}
+	instances, err := listInstances(context.Background(), d, "db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Instance{
+		{ServerName: "DB1", InstanceName: "MSSQLSERVER", IsClustered: true, Version: "16.0.1000.6", PipeName: `\\DB1\pipe\sql\query`},
+		{ServerName: "DB1", InstanceName: "SQLEXPRESS", Version: "15.0.2000.5", TCPPort: 50105, PipeName: `\\DB1\pipe\MSSQL$SQLEXPRESS\sql\query`},
+	}
+	if len(instances) != len(expected) {
+		t.Fatalf("expected %d instances, got %+v", len(expected), instances)
+	}
+	for i := range expected {
+		instances[i].Properties = nil
+		if !reflect.DeepEqual(instances[i], expected[i]) {
+			t.Errorf("got %+v, expected %+v", instances[i], expected[i])
+		}
+	}
+}
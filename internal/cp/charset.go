@@ -1,11 +1,16 @@
 package cp
 
+import "unicode/utf8"
+
 type charsetMap struct {
 	sb [256]rune    // single byte runes, -1 for a double byte character lead byte
 	db map[int]rune // double byte runes
 }
 
 func collation2charset(col Collation) *charsetMap {
+	if col.IsUTF8() {
+		return nil
+	}
 	// http://msdn.microsoft.com/en-us/library/ms144250.aspx
 	// http://msdn.microsoft.com/en-us/library/ms144250(v=sql.105).aspx
 	switch col.SortId {
@@ -53,45 +58,72 @@ func collation2charset(col Collation) *charsetMap {
 		return cp1252
 	}
 	// http://technet.microsoft.com/en-us/library/aa176553(v=sql.80).aspx
-	switch col.getLcid() {
+	lcid := col.LCID()
+	if cm, ok := lcid2charset(lcid); ok {
+		return cm
+	}
+	// the alternate sorts of a language, such as the stroke order of
+	// Chinese_PRC_Stroke, are in bits 16-19 and keep its code page
+	if cm, ok := lcid2charset(lcid & 0xffff); ok {
+		return cm
+	}
+	return cp1252
+}
+
+// lcid2charset returns the code page of the Windows collations of the
+// locale lcid, false for the locales of Latin1_General, whose code page is
+// 1252, and unknown locales. It returns nil for the Unicode-only
+// collations, which have no code page.
+func lcid2charset(lcid uint32) (*charsetMap, bool) {
+	// https://docs.microsoft.com/en-us/sql/relational-databases/collations/collation-and-unicode-support
+	switch lcid {
 	case 0x001e, 0x041e:
-		return cp874
+		return cp874, true
 	case 0x0411, 0x10411:
-		return cp932
+		return cp932, true
 	case 0x0804, 0x1004, 0x20804:
-		return cp936
+		return cp936, true
 	case 0x0012, 0x0412:
-		return cp949
+		return cp949, true
 	case 0x0404, 0x1404, 0x0c04, 0x7c04, 0x30404:
-		return cp950
-	case 0x041c, 0x041a, 0x0405, 0x040e, 0x104e, 0x0415, 0x0418, 0x041b, 0x0424, 0x1040e:
-		return cp1250
-	case 0x0423, 0x0402, 0x042f, 0x0419, 0x081a, 0x0c1a, 0x0422, 0x043f, 0x0444, 0x082c:
-		return cp1251
+		return cp950, true
+	case 0x041c, 0x041a, 0x0405, 0x040e, 0x104e, 0x0415, 0x0418, 0x041b, 0x0424, 0x1040e,
+		0x081a, 0x101a, 0x141a, 0x181a, 0x241a, 0x0442:
+		return cp1250, true
+	case 0x0423, 0x0402, 0x042f, 0x0419, 0x0c1a, 0x0422, 0x043f, 0x0444, 0x082c,
+		0x0450, 0x046d, 0x0485, 0x1c1a, 0x201a, 0x281a, 0x0843, 0x0440:
+		return cp1251, true
 	case 0x0408:
-		return cp1253
+		return cp1253, true
 	case 0x041f, 0x042c, 0x0443:
-		return cp1254
+		return cp1254, true
 	case 0x040d:
-		return cp1255
-	case 0x0401, 0x0801, 0xc01, 0x1001, 0x1401, 0x1801, 0x1c01, 0x2001, 0x2401, 0x2801, 0x2c01, 0x3001, 0x3401, 0x3801, 0x3c01, 0x4001, 0x0429, 0x0420:
-		return cp1256
+		return cp1255, true
+	case 0x0401, 0x0801, 0xc01, 0x1001, 0x1401, 0x1801, 0x1c01, 0x2001, 0x2401, 0x2801, 0x2c01, 0x3001, 0x3401, 0x3801, 0x3c01, 0x4001, 0x0429, 0x0420,
+		0x0463, 0x0480, 0x048c:
+		return cp1256, true
 	case 0x0425, 0x0426, 0x0427, 0x0827:
-		return cp1257
+		return cp1257, true
 	case 0x042a:
-		return cp1258
-	case 0x0439, 0x045a, 0x0465:
-		return nil
+		return cp1258, true
+	case 0x0439, 0x045a, 0x0465,
+		0x043a, 0x0445, 0x044d, 0x0451, 0x0452, 0x0453, 0x0454, 0x0461, 0x0481:
+		return nil, true
 	}
-	return cp1252
+	return nil, false
 }
 
 func CharsetToUTF8(col Collation, s []byte) string {
+	return string(AppendUTF8(make([]byte, 0, len(s)), col, s))
+}
+
+// AppendUTF8 appends the UTF-8 encoding of the text s to dst.
+func AppendUTF8(dst []byte, col Collation, s []byte) []byte {
 	cm := collation2charset(col)
 	if cm == nil {
-		return string(s)
+		return append(dst, s...)
 	}
-	buf := make([]rune, 0, len(s))
+	var enc [utf8.UTFMax]byte
 	for i := 0; i < len(s); i++ {
 		ch := cm.sb[s[i]]
 		if ch == -1 {
@@ -107,7 +139,41 @@ func CharsetToUTF8(col Collation, s []byte) string {
 				}
 			}
 		}
-		buf = append(buf, ch)
+		if ch < utf8.RuneSelf {
+			dst = append(dst, byte(ch))
+			continue
+		}
+		n := utf8.EncodeRune(enc[:], ch)
+		dst = append(dst, enc[:n]...)
+	}
+	return dst
+}
+
+// CharsetToUTF8Prefix converts the complete characters of s to UTF-8, it
+// returns them and their length in s, which leaves out a trailing lead
+// byte of a double byte character or the incomplete trailing character of
+// UTF-8 text.
+func CharsetToUTF8Prefix(col Collation, s []byte) (string, int) {
+	n := len(s)
+	if col.IsUTF8() {
+		// the start of the last character is among the last 4 bytes
+		for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(s[i]) {
+				if !utf8.FullRune(s[i:]) {
+					n = i
+				}
+				break
+			}
+		}
+	} else if cm := collation2charset(col); cm != nil {
+		for i := 0; i < len(s); i++ {
+			if cm.sb[s[i]] == -1 {
+				if i+1 == len(s) {
+					n = i
+				}
+				i++
+			}
+		}
 	}
-	return string(buf)
+	return CharsetToUTF8(col, s[:n]), n
 }
@@ -0,0 +1,111 @@
+package cp
+
+import "testing"
+
+func TestCharsetToUTF8(t *testing.T) {
+	tests := []struct {
+		col  Collation
+		s    []byte
+		utf8 string
+	}{
+		// Windows collations by locale
+		{Collation{LcidAndFlags: 0x0409}, []byte{0x80, 0xe9}, "€é"},
+		{Collation{LcidAndFlags: 0x0405}, []byte{0xe8, 0x8a}, "čŠ"},
+		{Collation{LcidAndFlags: 0x081a}, []byte{0xe8}, "č"},
+		{Collation{LcidAndFlags: 0x0419}, []byte{0xcf, 0xf0, 0xe8}, "При"},
+		{Collation{LcidAndFlags: 0x201a}, []byte{0xcf}, "П"},
+		{Collation{LcidAndFlags: 0x0408}, []byte{0xc1}, "Α"},
+		{Collation{LcidAndFlags: 0x041f}, []byte{0xf0}, "ğ"},
+		{Collation{LcidAndFlags: 0x040d}, []byte{0xf9}, "ש"},
+		{Collation{LcidAndFlags: 0x0429}, []byte{0xc7}, "ا"},
+		{Collation{LcidAndFlags: 0x0426}, []byte{0xe0}, "ą"},
+		{Collation{LcidAndFlags: 0x042a}, []byte{0xd0}, "Đ"},
+		{Collation{LcidAndFlags: 0x041e}, []byte{0xa1}, "ก"},
+		{Collation{LcidAndFlags: 0x0411}, []byte{0x93, 0xfa, 0x96, 0x7b, 0xb1}, "日本ｱ"},
+		{Collation{LcidAndFlags: 0x40411}, []byte{0x93, 0xfa}, "日"},
+		{Collation{LcidAndFlags: 0x0804}, []byte{0xd6, 0xd0, 0xce, 0xc4}, "中文"},
+		{Collation{LcidAndFlags: 0x20804}, []byte{0xd6, 0xd0}, "中"},
+		{Collation{LcidAndFlags: 0x0412}, []byte{0xc7, 0xd1, 0xb1, 0xb9}, "한국"},
+		{Collation{LcidAndFlags: 0x0404}, []byte{0xa4, 0xa4, 0xa4, 0xe5}, "中文"},
+		{Collation{LcidAndFlags: 0x21404}, []byte{0xa4, 0xa4}, "中"},
+		// the flags and version of the collation are left out
+		{Collation{LcidAndFlags: 0x2d00419}, []byte{0xcf}, "П"},
+		// SQL collations by sort id
+		{Collation{LcidAndFlags: 0x0409, SortId: 52}, []byte{0x80}, "€"},
+		{Collation{LcidAndFlags: 0x0409, SortId: 106}, []byte{0xcf}, "П"},
+		{Collation{LcidAndFlags: 0x0409, SortId: 30}, []byte{0x82}, "é"},
+		// double byte characters missing their trail byte
+		{Collation{LcidAndFlags: 0x0411}, []byte{0x41, 0x93}, "A�"},
+	}
+	for _, tt := range tests {
+		if s := CharsetToUTF8(tt.col, tt.s); s != tt.utf8 {
+			t.Errorf("%+v: expected %q, got %q", tt.col, tt.utf8, s)
+		}
+	}
+}
+
+func TestCharsetToUTF8Prefix(t *testing.T) {
+	col := Collation{LcidAndFlags: 0x0804}
+	s, n := CharsetToUTF8Prefix(col, []byte{0x41, 0xd6, 0xd0, 0xce})
+	if s != "A中" || n != 3 {
+		t.Errorf("expected A中 of 3 bytes, got %q of %d bytes", s, n)
+	}
+}
+
+func TestUnicodeOnlyCollation(t *testing.T) {
+	if cm := collation2charset(Collation{LcidAndFlags: 0x0439}); cm != nil {
+		t.Error("expected no code page for Indic_General")
+	}
+}
+
+func TestUTF8Collation(t *testing.T) {
+	// Latin1_General_100_CI_AS_SC_UTF8
+	col := Collation{LcidAndFlags: 0x24d00409}
+	if !col.IsUTF8() || (Collation{LcidAndFlags: 0x00d00409}).IsUTF8() {
+		t.Error("expected only the UTF-8 collation to be UTF-8")
+	}
+	if s := CharsetToUTF8(col, []byte("h€llo")); s != "h€llo" {
+		t.Errorf("expected h€llo, got %q", s)
+	}
+	euro := []byte("€")
+	for i := 0; i <= len(euro); i++ {
+		s, n := CharsetToUTF8Prefix(col, append([]byte("a"), euro[:i]...))
+		if i < len(euro) && (s != "a" || n != 1) || i == len(euro) && (s != "a€" || n != 4) {
+			t.Errorf("%d bytes of €: got %q of %d bytes", i, s, n)
+		}
+	}
+}
+
+func TestCollationName(t *testing.T) {
+	tests := []struct {
+		col      Collation
+		name     string
+		codePage int
+	}{
+		{Collation{LcidAndFlags: 0x00d00409}, "Latin1_General_CI_AS", 1252},
+		{Collation{LcidAndFlags: 0x00d00409, SortId: 52}, "SQL_Latin1_General_CP1_CI_AS", 1252},
+		{Collation{LcidAndFlags: 0x00000419, SortId: 106}, "SQL_Latin1_General_CP1251_CI_AS", 1251},
+		{Collation{LcidAndFlags: 0x20f00409}, "Latin1_General_100_CI_AI", 1252},
+		{Collation{LcidAndFlags: 0x24d00409}, "Latin1_General_100_CI_AS_SC_UTF8", 65001},
+		{Collation{LcidAndFlags: 0x26000409}, "Latin1_General_100_BIN2_UTF8", 65001},
+		{Collation{LcidAndFlags: 0x01000409}, "Latin1_General_BIN", 1252},
+		{Collation{LcidAndFlags: 0x00000411}, "Japanese_CS_AS_KS_WS", 932},
+		{Collation{LcidAndFlags: 0x10d00411}, "Japanese_90_CI_AS", 932},
+		{Collation{LcidAndFlags: 0x20d00411}, "Japanese_XJIS_100_CI_AS", 932},
+		{Collation{LcidAndFlags: 0x30d00411}, "Japanese_XJIS_140_CI_AS", 932},
+		{Collation{LcidAndFlags: 0x00d20804}, "Chinese_PRC_Stroke_CI_AS", 936},
+		{Collation{LcidAndFlags: 0x20d20804}, "Chinese_Simplified_Stroke_Order_100_CI_AS", 936},
+		{Collation{LcidAndFlags: 0x10d00439}, "Indic_General_90_CI_AS", 0},
+		{Collation{LcidAndFlags: 0x00d0081a}, "Serbian_Latin_CI_AS", 1250},
+		{Collation{LcidAndFlags: 0x00d00477}, "", 1252},
+		{Collation{LcidAndFlags: 0x00d00409, SortId: 250}, "", 1252},
+	}
+	for _, tt := range tests {
+		if name := tt.col.Name(); name != tt.name {
+			t.Errorf("%+v: expected the name %q, got %q", tt.col, tt.name, name)
+		}
+		if cp := tt.col.CodePage(); cp != tt.codePage {
+			t.Errorf("%+v: expected the code page %d, got %d", tt.col, tt.codePage, cp)
+		}
+	}
+}
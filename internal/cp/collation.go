@@ -7,14 +7,40 @@ type Collation struct {
 	SortId       uint8
 }
 
-func (c Collation) getLcid() uint32 {
+// LCID returns the locale id of the collation, its bits 16-19 tell the
+// alternate sorts of a language apart.
+func (c Collation) LCID() uint32 {
 	return c.LcidAndFlags & 0x000fffff
 }
 
-func (c Collation) getFlags() uint32 {
+// Flags returns the comparison flags of the collation.
+func (c Collation) Flags() uint32 {
 	return (c.LcidAndFlags & 0x0ff00000) >> 20
 }
 
-func (c Collation) getVersion() uint32 {
+// Version returns the version of the collation, 1 for the _90 collations,
+// 2 for the _100 ones and 3 for the _140 ones.
+func (c Collation) Version() uint32 {
 	return (c.LcidAndFlags & 0xf0000000) >> 28
 }
+
+// comparison flags
+const (
+	fIgnoreCase   = 0x01
+	fIgnoreAccent = 0x02
+	fIgnoreWidth  = 0x04
+	fIgnoreKana   = 0x08
+	fBinary       = 0x10
+	fBinary2      = 0x20
+	// fUTF8 is the flag of the UTF-8 collations of SQL Server 2019, such
+	// as Latin1_General_100_CI_AS_SC_UTF8. The server sends it to the
+	// clients that support UTF-8 only, it converts the text to the code
+	// page of the locale of the collation for the others.
+	fUTF8 = 0x40
+)
+
+// IsUTF8 tells whether c is a UTF-8 collation, which stores char and
+// varchar text as UTF-8.
+func (c Collation) IsUTF8() bool {
+	return c.Flags()&fUTF8 != 0
+}
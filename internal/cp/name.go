@@ -0,0 +1,231 @@
+package cp
+
+import "strings"
+
+// windowsCollations are the names of the Windows collations by locale.
+var windowsCollations = map[uint32]string{
+	0x0401:  "Arabic",
+	0x0405:  "Czech",
+	0x0406:  "Danish_Norwegian",
+	0x0408:  "Greek",
+	0x0409:  "Latin1_General",
+	0x040a:  "Traditional_Spanish",
+	0x040b:  "Finnish_Swedish",
+	0x040c:  "French",
+	0x040d:  "Hebrew",
+	0x040e:  "Hungarian",
+	0x1040e: "Hungarian_Technical",
+	0x040f:  "Icelandic",
+	0x0411:  "Japanese",
+	0x10411: "Japanese_Unicode",
+	0x40411: "Japanese_Bushu_Kakusu",
+	0x0412:  "Korean_Wansung",
+	0x0415:  "Polish",
+	0x0418:  "Romanian",
+	0x0419:  "Cyrillic_General",
+	0x041a:  "Croatian",
+	0x041b:  "Slovak",
+	0x041c:  "Albanian",
+	0x041e:  "Thai",
+	0x041f:  "Turkish",
+	0x0420:  "Urdu",
+	0x0422:  "Ukrainian",
+	0x0424:  "Slovenian",
+	0x0425:  "Estonian",
+	0x0426:  "Latvian",
+	0x0427:  "Lithuanian",
+	0x0827:  "Lithuanian_Classic",
+	0x0429:  "Persian",
+	0x042a:  "Vietnamese",
+	0x042c:  "Azeri_Latin",
+	0x082c:  "Azeri_Cyrillic",
+	0x042f:  "Macedonian_FYROM",
+	0x0439:  "Indic_General",
+	0x043f:  "Kazakh",
+	0x0443:  "Uzbek_Latin",
+	0x0444:  "Tatar",
+	0x045a:  "Syriac",
+	0x0465:  "Divehi",
+	0x0804:  "Chinese_PRC",
+	0x20804: "Chinese_PRC_Stroke",
+	0x0404:  "Chinese_Taiwan_Stroke",
+	0x30404: "Chinese_Taiwan_Bopomofo",
+	0x0c04:  "Chinese_Hong_Kong_Stroke",
+	0x0c0a:  "Modern_Spanish",
+	0x10407: "German_PhoneBook",
+	0x081a:  "Serbian_Latin",
+	0x0c1a:  "Serbian_Cyrillic",
+	0x141a:  "Bosnian_Latin",
+	0x201a:  "Bosnian_Cyrillic",
+	0x0442:  "Turkmen",
+	0x046d:  "Bashkir",
+	0x0485:  "Yakut",
+	0x0450:  "Mongolian",
+	0x0463:  "Pashto",
+	0x0480:  "Uighur",
+	0x048c:  "Dari",
+	0x043a:  "Maltese",
+	0x0445:  "Bengali",
+	0x044d:  "Assamese",
+	0x0451:  "Tibetan",
+	0x0452:  "Welsh",
+	0x0453:  "Khmer",
+	0x0454:  "Lao",
+	0x0461:  "Nepali",
+	0x0481:  "Maori",
+}
+
+// renamedCollations are the names of the collations of version 2 and up
+// whose locale name changed.
+var renamedCollations = map[uint32]string{
+	0x0804:  "Chinese_Simplified_Pinyin",
+	0x20804: "Chinese_Simplified_Stroke_Order",
+	0x0404:  "Chinese_Traditional_Stroke_Count",
+	0x30404: "Chinese_Traditional_Bopomofo",
+	0x0411:  "Japanese_XJIS",
+	0x0412:  "Korean",
+}
+
+// sqlCollations are the names of the SQL collations by sort id.
+var sqlCollations = map[uint8]string{
+	30:  "SQL_Latin1_General_CP437_BIN",
+	31:  "SQL_Latin1_General_CP437_CS_AS",
+	32:  "SQL_Latin1_General_CP437_CI_AS",
+	33:  "SQL_Latin1_General_Pref_CP437_CI_AS",
+	34:  "SQL_Latin1_General_CP437_CI_AI",
+	40:  "SQL_Latin1_General_CP850_BIN",
+	41:  "SQL_Latin1_General_CP850_CS_AS",
+	42:  "SQL_Latin1_General_CP850_CI_AS",
+	43:  "SQL_Latin1_General_Pref_CP850_CI_AS",
+	44:  "SQL_Latin1_General_CP850_CI_AI",
+	49:  "SQL_1xCompat_CP850_CI_AS",
+	51:  "SQL_Latin1_General_CP1_CS_AS",
+	52:  "SQL_Latin1_General_CP1_CI_AS",
+	53:  "SQL_Latin1_General_Pref_CP1_CI_AS",
+	54:  "SQL_Latin1_General_CP1_CI_AI",
+	55:  "SQL_AltDiction_CP850_CS_AS",
+	56:  "SQL_AltDiction_Pref_CP850_CI_AS",
+	57:  "SQL_AltDiction_CP850_CI_AI",
+	58:  "SQL_Scandinavian_Pref_CP850_CI_AS",
+	59:  "SQL_Scandinavian_CP850_CS_AS",
+	60:  "SQL_Scandinavian_CP850_CI_AS",
+	61:  "SQL_AltDiction_CP850_CI_AS",
+	81:  "SQL_Latin1_General_CP1250_CS_AS",
+	82:  "SQL_Latin1_General_CP1250_CI_AS",
+	83:  "SQL_Czech_CP1250_CS_AS",
+	84:  "SQL_Czech_CP1250_CI_AS",
+	85:  "SQL_Hungarian_CP1250_CS_AS",
+	86:  "SQL_Hungarian_CP1250_CI_AS",
+	87:  "SQL_Polish_CP1250_CS_AS",
+	88:  "SQL_Polish_CP1250_CI_AS",
+	89:  "SQL_Romanian_CP1250_CS_AS",
+	90:  "SQL_Romanian_CP1250_CI_AS",
+	91:  "SQL_Croatian_CP1250_CS_AS",
+	92:  "SQL_Croatian_CP1250_CI_AS",
+	93:  "SQL_Slovak_CP1250_CS_AS",
+	94:  "SQL_Slovak_CP1250_CI_AS",
+	95:  "SQL_Slovenian_CP1250_CS_AS",
+	96:  "SQL_Slovenian_CP1250_CI_AS",
+	105: "SQL_Latin1_General_CP1251_CS_AS",
+	106: "SQL_Latin1_General_CP1251_CI_AS",
+	107: "SQL_Ukrainian_Cp1251_CS_AS",
+	108: "SQL_Ukrainian_Cp1251_CI_AS",
+	113: "SQL_Latin1_General_CP1253_CS_AS",
+	114: "SQL_Latin1_General_CP1253_CI_AS",
+	129: "SQL_Latin1_General_CP1254_CS_AS",
+	130: "SQL_Latin1_General_CP1254_CI_AS",
+	137: "SQL_Latin1_General_CP1255_CS_AS",
+	138: "SQL_Latin1_General_CP1255_CI_AS",
+	145: "SQL_Latin1_General_CP1256_CS_AS",
+	146: "SQL_Latin1_General_CP1256_CI_AS",
+	153: "SQL_Latin1_General_CP1257_CS_AS",
+	154: "SQL_Latin1_General_CP1257_CI_AS",
+	155: "SQL_Estonian_CP1257_CS_AS",
+	156: "SQL_Estonian_CP1257_CI_AS",
+	157: "SQL_Latvian_CP1257_CS_AS",
+	158: "SQL_Latvian_CP1257_CI_AS",
+	159: "SQL_Lithuanian_CP1257_CS_AS",
+	160: "SQL_Lithuanian_CP1257_CI_AS",
+	183: "SQL_Danish_Pref_CP1_CI_AS",
+	184: "SQL_SwedishPhone_Pref_CP1_CI_AS",
+	185: "SQL_SwedishStd_Pref_CP1_CI_AS",
+	186: "SQL_Icelandic_Pref_CP1_CI_AS",
+	210: "SQL_EBCDIC037_CP1_CS_AS",
+	211: "SQL_EBCDIC273_CP1_CS_AS",
+	212: "SQL_EBCDIC277_CP1_CS_AS",
+	213: "SQL_EBCDIC278_CP1_CS_AS",
+	214: "SQL_EBCDIC280_CP1_CS_AS",
+	215: "SQL_EBCDIC284_CP1_CS_AS",
+	216: "SQL_EBCDIC285_CP1_CS_AS",
+	217: "SQL_EBCDIC297_CP1_CS_AS",
+}
+
+var versionSuffixes = [...]string{1: "_90", 2: "_100", 3: "_140"}
+
+// Name returns the name of the collation, such as
+// SQL_Latin1_General_CP1_CI_AS or Latin1_General_100_CI_AS, empty when it
+// is unknown. The _SC and _VSS options are not sent by the server, they
+// are left out but for UTF-8 collations, which all have _SC.
+func (c Collation) Name() string {
+	if c.SortId != 0 {
+		return sqlCollations[c.SortId]
+	}
+	lcid, version := c.LCID(), c.Version()
+	name, ok := windowsCollations[lcid]
+	if !ok || version >= uint32(len(versionSuffixes)) {
+		return ""
+	}
+	if renamed, ok := renamedCollations[lcid]; ok && version >= 2 {
+		name = renamed
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString(versionSuffixes[version])
+	flags := c.Flags()
+	switch {
+	case flags&fBinary2 != 0:
+		b.WriteString("_BIN2")
+	case flags&fBinary != 0:
+		b.WriteString("_BIN")
+	default:
+		if flags&fIgnoreCase != 0 {
+			b.WriteString("_CI")
+		} else {
+			b.WriteString("_CS")
+		}
+		if flags&fIgnoreAccent != 0 {
+			b.WriteString("_AI")
+		} else {
+			b.WriteString("_AS")
+		}
+		if flags&fIgnoreKana == 0 {
+			b.WriteString("_KS")
+		}
+		if flags&fIgnoreWidth == 0 {
+			b.WriteString("_WS")
+		}
+	}
+	if flags&fUTF8 != 0 {
+		if flags&(fBinary|fBinary2) == 0 {
+			b.WriteString("_SC")
+		}
+		b.WriteString("_UTF8")
+	}
+	return b.String()
+}
+
+var codePages = map[*charsetMap]int{
+	cp437: 437, cp850: 850, cp874: 874, cp932: 932, cp936: 936, cp949: 949,
+	cp950: 950, cp1250: 1250, cp1251: 1251, cp1252: 1252, cp1253: 1253,
+	cp1254: 1254, cp1255: 1255, cp1256: 1256, cp1257: 1257, cp1258: 1258,
+}
+
+// CodePage returns the code page of the char, varchar and text values of
+// the collation, 65001 for UTF-8 collations and zero for the Unicode-only
+// collations.
+func (c Collation) CodePage() int {
+	if c.IsUTF8() {
+		return 65001
+	}
+	return codePages[collation2charset(c)]
+}
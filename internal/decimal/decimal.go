@@ -56,6 +56,11 @@ func (d *Decimal) SetScale(scale uint8) {
 	d.scale = scale
 }
 
+// Scale returns the scale member
+func (d Decimal) Scale() uint8 {
+	return d.scale
+}
+
 // IsPositive returns true if the Decimal is positive
 func (d *Decimal) IsPositive() bool {
 	return d.positive
@@ -0,0 +1,401 @@
+// Package spatial converts the geometry and geography values of SQL Server
+// between their CLR serialization, described in [MS-SSCLRT], and the Well
+// Known Text and Binary representations of the OpenGIS Simple Features.
+//
+// Only the version 1 serialization is supported, which has the shapes of
+// SQL Server 2008. The circular arcs of the version 2 serialization have
+// no Simple Features representation.
+package spatial
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Type is the OpenGIS type of a shape.
+type Type byte
+
+const (
+	Point              Type = 1
+	LineString         Type = 2
+	Polygon            Type = 3
+	MultiPoint         Type = 4
+	MultiLineString    Type = 5
+	MultiPolygon       Type = 6
+	GeometryCollection Type = 7
+)
+
+var typeNames = [...]string{
+	Point:              "POINT",
+	LineString:         "LINESTRING",
+	Polygon:            "POLYGON",
+	MultiPoint:         "MULTIPOINT",
+	MultiLineString:    "MULTILINESTRING",
+	MultiPolygon:       "MULTIPOLYGON",
+	GeometryCollection: "GEOMETRYCOLLECTION",
+}
+
+func (t Type) String() string {
+	if t >= Point && t <= GeometryCollection {
+		return typeNames[t]
+	}
+	return fmt.Sprintf("Type(%d)", t)
+}
+
+// XY are the coordinates of a point. Geography points have the longitude
+// as X and the latitude as Y. Z and M are NaN when absent.
+type XY struct {
+	X, Y, Z, M float64
+}
+
+// Shape is a geometry or geography shape.
+type Shape struct {
+	Type Type
+	// Figures are the points of a point, a linestring or the rings of a
+	// polygon, the exterior ring first. It is empty for an empty shape.
+	Figures [][]XY
+	// Shapes are the members of a multi shape or a collection.
+	Shapes []*Shape
+}
+
+// Value is a geometry or geography value.
+type Value struct {
+	Shape *Shape
+	// HasZ and HasM tell whether the points have Z and M values.
+	HasZ, HasM bool
+}
+
+const (
+	flagHasZ    = 0x01
+	flagHasM    = 0x02
+	flagIsValid = 0x04
+	flagPoint   = 0x08
+	flagLine    = 0x10
+)
+
+// figure attributes of the version 1 serialization
+const (
+	figureInteriorRing = 0
+	figureStroke       = 1
+	figureExteriorRing = 2
+)
+
+var errTruncated = errors.New("spatial: truncated serialization")
+
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = errTruncated
+		return make([]byte, n)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	return d.next(1)[0]
+}
+
+func (d *decoder) int32() int {
+	b := d.next(4)
+	if d.err != nil {
+		return 0
+	}
+	return int(int32(binary.LittleEndian.Uint32(b)))
+}
+
+func (d *decoder) float64() float64 {
+	b := d.next(8)
+	if d.err != nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// Decode decodes the CLR serialization of a geometry, or of a geography
+// which has its points stored latitude first.
+func Decode(b []byte, geography bool) (srid int, v *Value, err error) {
+	d := &decoder{buf: b}
+	srid = d.int32()
+	if version := d.byte(); d.err == nil && version != 1 {
+		return 0, nil, fmt.Errorf("spatial: unsupported serialization version %d", version)
+	}
+	flags := d.byte()
+	v = &Value{HasZ: flags&flagHasZ != 0, HasM: flags&flagHasM != 0}
+	numPoints := 0
+	switch {
+	case flags&flagPoint != 0:
+		numPoints = 1
+	case flags&flagLine != 0:
+		numPoints = 2
+	default:
+		numPoints = d.int32()
+	}
+	if numPoints < 0 || numPoints > len(d.buf)/16 {
+		return 0, nil, errTruncated
+	}
+	points := make([]XY, numPoints)
+	for i := range points {
+		a, b := d.float64(), d.float64()
+		if geography {
+			a, b = b, a
+		}
+		points[i] = XY{X: a, Y: b, Z: math.NaN(), M: math.NaN()}
+	}
+	if v.HasZ {
+		for i := range points {
+			points[i].Z = d.float64()
+		}
+	}
+	if v.HasM {
+		for i := range points {
+			points[i].M = d.float64()
+		}
+	}
+	switch {
+	case flags&flagPoint != 0:
+		v.Shape = &Shape{Type: Point, Figures: [][]XY{points}}
+	case flags&flagLine != 0:
+		v.Shape = &Shape{Type: LineString, Figures: [][]XY{points}}
+	default:
+		v.Shape, err = d.shapes(points)
+	}
+	if err == nil {
+		err = d.err
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return srid, v, nil
+}
+
+// shapes decodes the figures and the shapes of a serialization.
+func (d *decoder) shapes(points []XY) (*Shape, error) {
+	numFigures := d.int32()
+	if numFigures < 0 || numFigures > len(d.buf)/5 {
+		return nil, errTruncated
+	}
+	figureStart := make([]int, numFigures+1)
+	for i := 0; i < numFigures; i++ {
+		d.byte() // attribute, the rings are told apart by their order
+		figureStart[i] = d.int32()
+	}
+	figureStart[numFigures] = len(points)
+	numShapes := d.int32()
+	if d.err != nil || numShapes < 1 || numShapes > len(d.buf)/9 {
+		return nil, errTruncated
+	}
+	type entry struct {
+		parent, figure int
+		typ            Type
+	}
+	entries := make([]entry, numShapes)
+	shapes := make([]*Shape, numShapes)
+	for i := range entries {
+		entries[i] = entry{parent: d.int32(), figure: d.int32(), typ: Type(d.byte())}
+		if entries[i].typ < Point || entries[i].typ > GeometryCollection {
+			return nil, fmt.Errorf("spatial: unsupported shape type %d", entries[i].typ)
+		}
+		shapes[i] = &Shape{Type: entries[i].typ}
+	}
+	for i, e := range entries {
+		if i > 0 {
+			if e.parent < 0 || e.parent >= i {
+				return nil, fmt.Errorf("spatial: invalid parent %d of shape %d", e.parent, i)
+			}
+			shapes[e.parent].Shapes = append(shapes[e.parent].Shapes, shapes[i])
+		}
+		if e.figure < 0 {
+			continue
+		}
+		switch e.typ {
+		case Point, LineString, Polygon:
+		default:
+			continue
+		}
+		// the figures of a shape run to those of the next shape that has any
+		end := numFigures
+		for _, next := range entries[i+1:] {
+			if next.figure >= 0 {
+				end = next.figure
+				break
+			}
+		}
+		if e.figure > end || end > numFigures {
+			return nil, fmt.Errorf("spatial: invalid figures of shape %d", i)
+		}
+		for f := e.figure; f < end; f++ {
+			start, stop := figureStart[f], figureStart[f+1]
+			if start < 0 || start > stop || stop > len(points) {
+				return nil, fmt.Errorf("spatial: invalid points of figure %d", f)
+			}
+			shapes[i].Figures = append(shapes[i].Figures, points[start:stop])
+		}
+	}
+	return shapes[0], nil
+}
+
+// Encode encodes v in the CLR serialization, the points of a geography
+// latitude first. The serialization is flagged valid, the server does not
+// check it again.
+func Encode(srid int, v *Value, geography bool) []byte {
+	var points []XY
+	var figures []byte
+	var shapes []byte
+	var walk func(s *Shape, parent int)
+	numShapes := 0
+	walk = func(s *Shape, parent int) {
+		index := numShapes
+		numShapes++
+		firstFigure := len(figures) / 5
+		shapes = appendInt32(shapes, parent)
+		shapes = appendInt32(shapes, firstFigure)
+		shapes = append(shapes, byte(s.Type))
+		for i, fig := range s.Figures {
+			attr := byte(figureStroke)
+			if s.Type == Polygon {
+				// geography rings are oriented, not exterior or interior
+				attr = figureExteriorRing
+				if i > 0 && !geography {
+					attr = figureInteriorRing
+				}
+			}
+			figures = append(figures, attr)
+			figures = appendInt32(figures, len(points))
+			points = append(points, fig...)
+		}
+		for _, child := range s.Shapes {
+			walk(child, index)
+		}
+		if len(figures)/5 == firstFigure {
+			// an empty shape has no figures
+			binary.LittleEndian.PutUint32(shapes[9*index+4:], math.MaxUint32)
+		}
+	}
+	walk(v.Shape, -1)
+
+	flags := byte(flagIsValid)
+	if v.HasZ {
+		flags |= flagHasZ
+	}
+	if v.HasM {
+		flags |= flagHasM
+	}
+	single := numShapes == 1 && len(v.Shape.Figures) == 1
+	switch {
+	case single && v.Shape.Type == Point && len(points) == 1:
+		flags |= flagPoint
+	case single && v.Shape.Type == LineString && len(points) == 2:
+		flags |= flagLine
+	default:
+		single = false
+	}
+
+	b := appendInt32(nil, srid)
+	b = append(b, 1, flags)
+	if !single {
+		b = appendInt32(b, len(points))
+	}
+	for _, p := range points {
+		if geography {
+			b = appendFloat64(appendFloat64(b, p.Y), p.X)
+		} else {
+			b = appendFloat64(appendFloat64(b, p.X), p.Y)
+		}
+	}
+	if v.HasZ {
+		for _, p := range points {
+			b = appendFloat64(b, p.Z)
+		}
+	}
+	if v.HasM {
+		for _, p := range points {
+			b = appendFloat64(b, p.M)
+		}
+	}
+	if !single {
+		b = appendInt32(b, len(figures)/5)
+		b = append(b, figures...)
+		b = appendInt32(b, numShapes)
+		b = append(b, shapes...)
+	}
+	return b
+}
+
+func appendInt32(b []byte, v int) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendFloat64(b []byte, f float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	return append(b, buf[:]...)
+}
+
+// WKB returns the Well Known Binary of v, little endian, with the ISO type
+// codes of the points with Z and M values.
+func (v *Value) WKB() []byte {
+	code := uint32(0)
+	if v.HasZ {
+		code += 1000
+	}
+	if v.HasM {
+		code += 2000
+	}
+	return v.appendWKB(nil, v.Shape, code)
+}
+
+func (v *Value) appendWKB(b []byte, s *Shape, code uint32) []byte {
+	var typ [4]byte
+	binary.LittleEndian.PutUint32(typ[:], code+uint32(s.Type))
+	b = append(append(b, 1), typ[:]...)
+	appendPoint := func(b []byte, p XY) []byte {
+		b = appendFloat64(appendFloat64(b, p.X), p.Y)
+		if v.HasZ {
+			b = appendFloat64(b, p.Z)
+		}
+		if v.HasM {
+			b = appendFloat64(b, p.M)
+		}
+		return b
+	}
+	switch s.Type {
+	case Point:
+		if len(s.Figures) == 0 || len(s.Figures[0]) == 0 {
+			// an empty point has NaN coordinates
+			nan := math.NaN()
+			return appendPoint(b, XY{nan, nan, nan, nan})
+		}
+		return appendPoint(b, s.Figures[0][0])
+	case LineString:
+		if len(s.Figures) == 0 {
+			return appendInt32(b, 0)
+		}
+		b = appendInt32(b, len(s.Figures[0]))
+		for _, p := range s.Figures[0] {
+			b = appendPoint(b, p)
+		}
+		return b
+	case Polygon:
+		b = appendInt32(b, len(s.Figures))
+		for _, ring := range s.Figures {
+			b = appendInt32(b, len(ring))
+			for _, p := range ring {
+				b = appendPoint(b, p)
+			}
+		}
+		return b
+	}
+	b = appendInt32(b, len(s.Shapes))
+	for _, child := range s.Shapes {
+		b = v.appendWKB(b, child, code)
+	}
+	return b
+}
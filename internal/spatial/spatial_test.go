@@ -0,0 +1,143 @@
+package spatial
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		hex       string
+		geography bool
+		srid      int
+		wkt       string
+	}{
+		// geography::Point(47.651, -122.349, 4326)
+		{"E6100000010C17D9CEF753D347407593180456965EC0", true, 4326, "POINT (-122.349 47.651)"},
+		// geometry::STGeomFromText('LINESTRING (1 1, 2 2)', 0)
+		{"000000000114000000000000F03F000000000000F03F00000000000000400000000000000040", false, 0, "LINESTRING (1 1, 2 2)"},
+	}
+	for _, tt := range tests {
+		b, err := hex.DecodeString(tt.hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srid, v, err := Decode(b, tt.geography)
+		if err != nil {
+			t.Errorf("%s: %v", tt.wkt, err)
+			continue
+		}
+		if srid != tt.srid || v.WKT() != tt.wkt {
+			t.Errorf("expected %s with SRID %d, got %s with SRID %d", tt.wkt, tt.srid, v.WKT(), srid)
+		}
+		v, err = ParseWKT(tt.wkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc := Encode(tt.srid, v, tt.geography); !bytes.Equal(enc, b) {
+			t.Errorf("%s: expected %X, got %X", tt.wkt, b, enc)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, wkt := range []string{
+		"POINT (1.5 -2)",
+		"POINT (1 2 3)",
+		"POINT (1 2 NULL 4)",
+		"POINT (1 2 3 4)",
+		"POINT EMPTY",
+		"LINESTRING (0 0, 1 1, 2 0)",
+		"LINESTRING EMPTY",
+		"POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0), (2 2, 2 3, 3 3, 2 2))",
+		"MULTIPOINT ((0 0), (1 1))",
+		"MULTILINESTRING ((0 0, 1 1), (2 2, 3 3))",
+		"MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)), ((5 5, 6 5, 6 6, 5 5), (5.2 5.1, 5.8 5.1, 5.8 5.7, 5.2 5.1)))",
+		"GEOMETRYCOLLECTION (POINT (1 1), LINESTRING EMPTY, POLYGON ((0 0, 1 0, 1 1, 0 0)), MULTIPOINT ((2 2)))",
+		"GEOMETRYCOLLECTION EMPTY",
+	} {
+		v, err := ParseWKT(wkt)
+		if err != nil {
+			t.Errorf("%s: %v", wkt, err)
+			continue
+		}
+		for _, geography := range []bool{false, true} {
+			srid, decoded, err := Decode(Encode(4326, v, geography), geography)
+			if err != nil {
+				t.Errorf("%s: %v", wkt, err)
+				continue
+			}
+			if srid != 4326 || decoded.WKT() != wkt {
+				t.Errorf("expected %s, got %s with SRID %d", wkt, decoded.WKT(), srid)
+			}
+		}
+	}
+}
+
+func TestParseWKT(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"point(1 2)", "POINT (1 2)"},
+		{"POINT Z (1 2 3)", "POINT (1 2 3)"},
+		{"POINT M (1 2 3)", "POINT (1 2 NULL 3)"},
+		{"POINT ZM (1 2 3 4)", "POINT (1 2 3 4)"},
+		{"MULTIPOINT (0 0, 1 1)", "MULTIPOINT ((0 0), (1 1))"},
+		{" LINESTRING(0 0,1 1) ", "LINESTRING (0 0, 1 1)"},
+	}
+	for _, tt := range tests {
+		v, err := ParseWKT(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if v.WKT() != tt.out {
+			t.Errorf("%s: expected %s, got %s", tt.in, tt.out, v.WKT())
+		}
+	}
+	for _, wkt := range []string{
+		"", "POINT", "POINT (1)", "POINT (1 2", "POINT (1 2) x", "CIRCULARSTRING (0 0, 1 1, 2 0)",
+		"LINESTRING (0 0, 1 1 1)", "POLYGON (0 0, 1 1)", "MULTIPOINT ((0 0, 1 1))", "POINT (a b)",
+	} {
+		if _, err := ParseWKT(wkt); err == nil {
+			t.Errorf("expected %q to fail", wkt)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	v, err := ParseWKT("POLYGON ((0 0, 10 0, 10 10, 0 0))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := Encode(0, v, false)
+	for n := 0; n < len(b); n++ {
+		if _, _, err := Decode(b[:n], false); err == nil {
+			t.Errorf("expected the serialization truncated to %d bytes to fail", n)
+		}
+	}
+	b[4] = 2
+	if _, _, err := Decode(b, false); err == nil {
+		t.Error("expected the version 2 serialization to fail")
+	}
+}
+
+func TestWKB(t *testing.T) {
+	v, err := ParseWKT("LINESTRING (1 2, 3 4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := hex.DecodeString("010200000002000000000000000000F03F000000000000004000000000000008400000000000001040")
+	if b := v.WKB(); !bytes.Equal(b, expected) {
+		t.Errorf("expected %X, got %X", expected, b)
+	}
+	v, err = ParseWKT("MULTIPOINT Z ((1 2 3))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ = hex.DecodeString("01EC0300000100000001E9030000000000000000F03F00000000000000400000000000000840")
+	if b := v.WKB(); !bytes.Equal(b, expected) {
+		t.Errorf("expected %X, got %X", expected, b)
+	}
+}
@@ -0,0 +1,349 @@
+package spatial
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WKT formats v as Well Known Text the way STAsText does, such as
+// POINT (1 2), with the Z and M values following X and Y and a missing Z
+// written NULL.
+func (v *Value) WKT() string {
+	var b strings.Builder
+	v.writeShape(&b, v.Shape)
+	return b.String()
+}
+
+func (v *Value) writeShape(b *strings.Builder, s *Shape) {
+	b.WriteString(s.Type.String())
+	switch s.Type {
+	case Point, LineString:
+		if len(s.Figures) == 0 || len(s.Figures[0]) == 0 {
+			b.WriteString(" EMPTY")
+			return
+		}
+		b.WriteByte(' ')
+		v.writePoints(b, s.Figures[0])
+		return
+	case Polygon:
+		if len(s.Figures) == 0 {
+			b.WriteString(" EMPTY")
+			return
+		}
+		b.WriteByte(' ')
+		v.writeRings(b, s.Figures)
+		return
+	}
+	if len(s.Shapes) == 0 {
+		b.WriteString(" EMPTY")
+		return
+	}
+	b.WriteString(" (")
+	for i, child := range s.Shapes {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		switch s.Type {
+		case MultiPoint, MultiLineString:
+			if len(child.Figures) == 0 || len(child.Figures[0]) == 0 {
+				b.WriteString("EMPTY")
+			} else {
+				v.writePoints(b, child.Figures[0])
+			}
+		case MultiPolygon:
+			if len(child.Figures) == 0 {
+				b.WriteString("EMPTY")
+			} else {
+				v.writeRings(b, child.Figures)
+			}
+		default:
+			v.writeShape(b, child)
+		}
+	}
+	b.WriteByte(')')
+}
+
+func (v *Value) writeRings(b *strings.Builder, rings [][]XY) {
+	b.WriteByte('(')
+	for i, ring := range rings {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		v.writePoints(b, ring)
+	}
+	b.WriteByte(')')
+}
+
+func (v *Value) writePoints(b *strings.Builder, points []XY) {
+	b.WriteByte('(')
+	for i, p := range points {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(formatFloat(p.X))
+		b.WriteByte(' ')
+		b.WriteString(formatFloat(p.Y))
+		if v.HasZ || v.HasM {
+			b.WriteByte(' ')
+			b.WriteString(formatFloat(p.Z))
+		}
+		if v.HasM {
+			b.WriteByte(' ')
+			b.WriteString(formatFloat(p.M))
+		}
+	}
+	b.WriteByte(')')
+}
+
+func formatFloat(f float64) string {
+	if math.IsNaN(f) {
+		return "NULL"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ParseWKT parses the Well Known Text of a shape, such as
+// POINT (1 2) or POLYGON ((0 0, 1 0, 1 1, 0 0)). The points may have Z and
+// M values, following the coordinates as in STAsText or marked by the Z, M
+// or ZM tag of the type.
+func ParseWKT(s string) (*Value, error) {
+	p := &wktParser{s: s}
+	v := &Value{}
+	shape, err := p.shape(v)
+	if err == nil && p.token() != "" {
+		err = p.errorf("unexpected %q after the shape", p.tok)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.Shape = shape
+	return v, nil
+}
+
+type wktParser struct {
+	s   string
+	pos int
+	// tok is the last token read, peeked is set when it is unread
+	tok    string
+	peeked bool
+	// dims is the number of values of the points, once known
+	dims int
+}
+
+func (p *wktParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("spatial: invalid WKT at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// token returns the next word, number, parenthesis or comma, empty at the
+// end of the text.
+func (p *wktParser) token() string {
+	if p.peeked {
+		p.peeked = false
+		return p.tok
+	}
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos < len(p.s) && strings.IndexByte("(),", p.s[p.pos]) >= 0 {
+		p.pos++
+	} else {
+		for p.pos < len(p.s) && strings.IndexByte(" \t\r\n(),", p.s[p.pos]) < 0 {
+			p.pos++
+		}
+	}
+	p.tok = strings.ToUpper(p.s[start:p.pos])
+	return p.tok
+}
+
+func (p *wktParser) peek() string {
+	tok := p.token()
+	p.peeked = true
+	return tok
+}
+
+func (p *wktParser) expect(tok string) error {
+	if got := p.token(); got != tok {
+		return p.errorf("expected %q, got %q", tok, got)
+	}
+	return nil
+}
+
+// empty reads the EMPTY of an empty shape or the opening parenthesis of
+// its contents.
+func (p *wktParser) empty() (bool, error) {
+	if p.peek() == "EMPTY" {
+		p.token()
+		return true, nil
+	}
+	return false, p.expect("(")
+}
+
+func (p *wktParser) shape(v *Value) (*Shape, error) {
+	name := p.token()
+	var s *Shape
+	for t := Point; t <= GeometryCollection; t++ {
+		if typeNames[t] == name {
+			s = &Shape{Type: t}
+		}
+	}
+	if s == nil {
+		return nil, p.errorf("unknown shape %q", name)
+	}
+	switch p.peek() {
+	case "Z":
+		v.HasZ = true
+		p.token()
+	case "M":
+		v.HasM = true
+		p.token()
+	case "ZM":
+		v.HasZ, v.HasM = true, true
+		p.token()
+	}
+	empty, err := p.empty()
+	if err != nil || empty {
+		return s, err
+	}
+	switch s.Type {
+	case Point:
+		point, err := p.points(v, true)
+		if err == nil {
+			err = p.expect(")")
+		}
+		s.Figures = [][]XY{point}
+		return s, err
+	case LineString:
+		points, err := p.points(v, false)
+		s.Figures = [][]XY{points}
+		return s, err
+	case Polygon:
+		s.Figures, err = p.rings(v, true)
+		return s, err
+	}
+	for {
+		var child *Shape
+		switch s.Type {
+		case MultiPoint:
+			child = &Shape{Type: Point}
+			// the points of a multipoint may omit their parentheses
+			if p.peek() == "(" || p.peek() == "EMPTY" {
+				if empty, err = p.empty(); err == nil && !empty {
+					child.Figures, err = p.rings(v, false)
+				}
+			} else {
+				var point []XY
+				point, err = p.points(v, true)
+				child.Figures = [][]XY{point}
+			}
+		case MultiLineString:
+			child = &Shape{Type: LineString}
+			if empty, err = p.empty(); err == nil && !empty {
+				child.Figures, err = p.rings(v, false)
+			}
+		case MultiPolygon:
+			child = &Shape{Type: Polygon}
+			if empty, err = p.empty(); err == nil && !empty {
+				child.Figures, err = p.rings(v, true)
+			}
+		default:
+			child, err = p.shape(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s.Type == MultiPoint && len(child.Figures) > 0 && len(child.Figures[0]) != 1 {
+			return nil, p.errorf("a point of a multipoint has %d coordinates", len(child.Figures[0]))
+		}
+		s.Shapes = append(s.Shapes, child)
+		if tok := p.token(); tok == ")" {
+			return s, nil
+		} else if tok != "," {
+			return nil, p.errorf("expected \",\" or \")\", got %q", tok)
+		}
+	}
+}
+
+// rings reads the point lists following an opening parenthesis up to the
+// closing one, the rings of a polygon or a single list otherwise. For
+// a single list the opening parenthesis is already read.
+func (p *wktParser) rings(v *Value, polygon bool) ([][]XY, error) {
+	if !polygon {
+		points, err := p.points(v, false)
+		return [][]XY{points}, err
+	}
+	var rings [][]XY
+	for {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		ring, err := p.points(v, false)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+		if tok := p.token(); tok == ")" {
+			return rings, nil
+		} else if tok != "," {
+			return nil, p.errorf("expected \",\" or \")\", got %q", tok)
+		}
+	}
+}
+
+// points reads the points of a list up to its closing parenthesis, or
+// a single point not followed by the parenthesis.
+func (p *wktParser) points(v *Value, single bool) ([]XY, error) {
+	var points []XY
+	for {
+		var values []float64
+		for {
+			tok := p.peek()
+			if tok == "," || tok == ")" || tok == "" {
+				break
+			}
+			p.token()
+			f := math.NaN()
+			if tok != "NULL" {
+				var err error
+				if f, err = strconv.ParseFloat(tok, 64); err != nil {
+					return nil, p.errorf("invalid coordinate %q", tok)
+				}
+			}
+			values = append(values, f)
+		}
+		if len(values) < 2 || len(values) > 4 || math.IsNaN(values[0]) || math.IsNaN(values[1]) {
+			return nil, p.errorf("a point has %d coordinates", len(values))
+		}
+		if p.dims == 0 {
+			p.dims = len(values)
+			switch {
+			case len(values) == 4:
+				v.HasZ, v.HasM = true, true
+			case len(values) == 3 && !v.HasM:
+				v.HasZ = true
+			}
+		} else if len(values) != p.dims {
+			return nil, p.errorf("the points have %d and %d coordinates", p.dims, len(values))
+		}
+		pt := XY{X: values[0], Y: values[1], Z: math.NaN(), M: math.NaN()}
+		switch {
+		case len(values) == 4:
+			pt.Z, pt.M = values[2], values[3]
+		case len(values) == 3 && v.HasM && !v.HasZ:
+			pt.M = values[2]
+		case len(values) == 3:
+			pt.Z = values[2]
+		}
+		points = append(points, pt)
+		if single {
+			return points, nil
+		}
+		if tok := p.token(); tok == ")" {
+			return points, nil
+		} else if tok != "," {
+			return nil, p.errorf("expected \",\" or \")\", got %q", tok)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package mssql
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// krb5Auth authenticates with Kerberos through SPNEGO, without relying on
+// the GSSAPI libraries of the system.
+type krb5Auth struct {
+	client *client.Client
+	spn    string
+	// channelBindings is the application data of the TLS channel binding
+	channelBindings []byte
+}
+
+// krb5Clients are shared by the connections with the same credentials, so
+// that a pool logs in once and its TGT is renewed in the background.
+var krb5Clients = struct {
+	sync.Mutex
+	m map[string]krb5Client
+}{m: map[string]krb5Client{}}
+
+type krb5Client struct {
+	*client.Client
+	// modTime is the modification time of the credential cache the client
+	// was loaded from.
+	modTime time.Time
+}
+
+// newKerberosAuth returns the Kerberos authenticator of p. The user
+// authenticates with a keytab, a password or, without either, the
+// credential cache of a previous kinit.
+func newKerberosAuth(p msdsn.Config) (auth, error) {
+	cfg, err := loadKerberosConfig(p.Kerberos.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	user, realm := p.User, p.Kerberos.Realm
+	if i := strings.LastIndex(user, "@"); i >= 0 {
+		if realm == "" {
+			realm = user[i+1:]
+		}
+		user = user[:i]
+	}
+	if realm == "" {
+		realm = cfg.LibDefaults.DefaultRealm
+	}
+	realm = strings.ToUpper(realm)
+
+	var key string
+	var modTime time.Time
+	var newClient func() (*client.Client, error)
+	switch {
+	case p.Kerberos.KeytabFile != "":
+		if user == "" || realm == "" {
+			return nil, fmt.Errorf("mssql: Kerberos authentication with a keytab requires a user id and a realm")
+		}
+		key = fmt.Sprintf("keytab\x00%s\x00%s\x00%s", p.Kerberos.KeytabFile, user, realm)
+		newClient = func() (*client.Client, error) {
+			kt, err := keytab.Load(p.Kerberos.KeytabFile)
+			if err != nil {
+				return nil, fmt.Errorf("mssql: cannot load Kerberos keytab %s: %v", p.Kerberos.KeytabFile, err)
+			}
+			return client.NewWithKeytab(user, realm, kt, cfg, client.DisablePAFXFAST(true)), nil
+		}
+	case p.Password != "":
+		if user == "" || realm == "" {
+			return nil, fmt.Errorf("mssql: Kerberos authentication requires a user id and a realm")
+		}
+		key = fmt.Sprintf("password\x00%s\x00%s\x00%x", user, realm, sha256.Sum256([]byte(p.Password)))
+		newClient = func() (*client.Client, error) {
+			return client.NewWithPassword(user, realm, p.Password, cfg, client.DisablePAFXFAST(true)), nil
+		}
+	default:
+		path := credCachePath(p.Kerberos.CredCacheFile)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("mssql: cannot read Kerberos credential cache: %v", err)
+		}
+		// the client is reloaded when kinit renews the tickets
+		key = "ccache\x00" + path
+		modTime = info.ModTime()
+		newClient = func() (cl *client.Client, err error) {
+			// the parser panics on some malformed files
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("mssql: cannot load Kerberos credential cache %s: %v", path, r)
+				}
+			}()
+			cc, err := credentials.LoadCCache(path)
+			if err != nil {
+				return nil, fmt.Errorf("mssql: cannot load Kerberos credential cache %s: %v", path, err)
+			}
+			return client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
+		}
+	}
+	key = fmt.Sprintf("%s\x00%s", key, p.Kerberos.ConfigFile)
+
+	krb5Clients.Lock()
+	defer krb5Clients.Unlock()
+	cl, ok := krb5Clients.m[key]
+	if !ok || !cl.modTime.Equal(modTime) {
+		c, err := newClient()
+		if err != nil {
+			return nil, err
+		}
+		cl = krb5Client{Client: c, modTime: modTime}
+		krb5Clients.m[key] = cl
+	}
+	return &krb5Auth{client: cl.Client, spn: kerberosSPN(p.ServerSPN)}, nil
+}
+
+func loadKerberosConfig(path string) (*config.Config, error) {
+	if path == "" {
+		path = os.Getenv("KRB5_CONFIG")
+	}
+	if path == "" {
+		path = "/etc/krb5.conf"
+		if runtime.GOOS == "windows" {
+			path = filepath.Join(os.Getenv("ProgramData"), "MIT", "Kerberos5", "krb5.ini")
+		}
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("mssql: cannot load Kerberos configuration %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// credCachePath returns the file of the credential cache, only FILE caches
+// are supported.
+func credCachePath(path string) string {
+	if path == "" {
+		path = os.Getenv("KRB5CCNAME")
+	}
+	if path == "" {
+		return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+	return strings.TrimPrefix(path, "FILE:")
+}
+
+// kerberosSPN strips the realm from an SPN, tickets are requested in the
+// realm the host maps to.
+func kerberosSPN(spn string) string {
+	if i := strings.LastIndex(spn, "@"); i >= 0 {
+		return spn[:i]
+	}
+	return spn
+}
+
+func (a *krb5Auth) InitialBytes() ([]byte, error) {
+	if err := a.client.AffirmLogin(); err != nil {
+		return nil, fmt.Errorf("mssql: Kerberos login failed: %v", err)
+	}
+	tkt, key, err := a.client.GetServiceTicket(a.spn)
+	if err != nil {
+		return nil, fmt.Errorf("mssql: cannot get a Kerberos ticket for %s: %v", a.spn, err)
+	}
+	authenticator, err := types.NewAuthenticator(a.client.Credentials.Domain(), a.client.Credentials.CName())
+	if err != nil {
+		return nil, err
+	}
+	authenticator.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  krb5AuthenticatorChecksum(a.channelBindings),
+	}
+	apReq, err := messages.NewAPReq(tkt, key, authenticator)
+	if err != nil {
+		return nil, err
+	}
+	mechToken, err := krb5MechToken(apReq)
+	if err != nil {
+		return nil, err
+	}
+	token := spnego.NegTokenInit{
+		MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+		MechTokenBytes: mechToken,
+	}
+	return token.Marshal()
+}
+
+// krb5AuthenticatorChecksum returns the GSS checksum of RFC 4121 4.1.1 that
+// carries the channel bindings.
+func krb5AuthenticatorChecksum(channelBindings []byte) []byte {
+	cksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(cksum, 16)
+	if channelBindings != nil {
+		copy(cksum[4:20], channelBindingsHash(channelBindings))
+	}
+	binary.LittleEndian.PutUint32(cksum[20:], uint32(gssapi.ContextFlagInteg|gssapi.ContextFlagConf))
+	return cksum
+}
+
+// krb5MechToken frames an AP-REQ as the initial context token of RFC 1964.
+func krb5MechToken(apReq messages.APReq) ([]byte, error) {
+	oid, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	if err != nil {
+		return nil, err
+	}
+	req, err := apReq.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	b := append(oid, 0x01, 0x00) // TOK_ID of KRB_AP_REQ
+	return asn1tools.AddASNAppTag(append(b, req...), 0), nil
+}
+
+func (a *krb5Auth) setChannelBindings(applicationData []byte) {
+	a.channelBindings = applicationData
+}
+
+func (a *krb5Auth) NextBytes(bytes []byte) ([]byte, error) {
+	var resp spnego.NegTokenResp
+	if err := resp.Unmarshal(bytes); err != nil {
+		return nil, fmt.Errorf("mssql: invalid Kerberos response: %v", err)
+	}
+	if resp.State() == spnego.NegStateReject {
+		return nil, fmt.Errorf("mssql: the server rejected the Kerberos authentication")
+	}
+	return nil, nil
+}
+
+// Free keeps the client, it is shared with the other connections.
+func (a *krb5Auth) Free() {
+}
@@ -0,0 +1,204 @@
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// writeKrb5Conf writes a configuration whose KDC refuses connections.
+func writeKrb5Conf(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "krb5.conf")
+	conf := `[libdefaults]
+  default_realm = EXAMPLE.COM
+  dns_lookup_kdc = false
+  udp_preference_limit = 1
+
+[realms]
+  EXAMPLE.COM = {
+    kdc = 127.0.0.1:1
+  }
+`
+	if err := ioutil.WriteFile(path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewKerberosAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krb5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := writeKrb5Conf(t, dir)
+
+	for _, c := range []struct {
+		user, realm string
+		wantUser    string
+		wantRealm   string
+	}{
+		{"alice", "", "alice", "EXAMPLE.COM"},
+		{"alice@corp.example.com", "", "alice", "CORP.EXAMPLE.COM"},
+		{"alice@corp.example.com", "OTHER.EXAMPLE.COM", "alice", "OTHER.EXAMPLE.COM"},
+	} {
+		a, err := newKerberosAuth(msdsn.Config{
+			User:      c.user,
+			Password:  "pwd",
+			ServerSPN: "MSSQLSvc/db.example.com:1433@EXAMPLE.COM",
+			Kerberos:  msdsn.Kerberos{ConfigFile: conf, Realm: c.realm},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := a.(*krb5Auth)
+		if k.client.Credentials.UserName() != c.wantUser || k.client.Credentials.Domain() != c.wantRealm {
+			t.Errorf("%s: got %s@%s", c.user, k.client.Credentials.UserName(), k.client.Credentials.Domain())
+		}
+		if k.spn != "MSSQLSvc/db.example.com:1433" {
+			t.Errorf("unexpected SPN %s", k.spn)
+		}
+	}
+
+	if _, err = newKerberosAuth(msdsn.Config{Kerberos: msdsn.Kerberos{ConfigFile: conf}}); err == nil {
+		t.Error("expected an error without a user id")
+	}
+	if _, err = newKerberosAuth(msdsn.Config{User: "alice", Kerberos: msdsn.Kerberos{ConfigFile: filepath.Join(dir, "missing.conf")}}); err == nil {
+		t.Error("expected an error for a missing configuration")
+	}
+}
+
+func TestKerberosLoginFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krb5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := writeKrb5Conf(t, dir)
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer srv.Close()
+
+	c, err := NewConnector(srv.DSN() + "&authenticator=krb5&user+id=alice&password=pwd&krb5-configfile=" + conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Kerberos login failed") {
+		t.Errorf("expected the KDC to be unreachable, got %v", err)
+	}
+}
+
+func TestKerberosCredentialSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krb5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := writeKrb5Conf(t, dir)
+
+	kt := keytab.New()
+	if err = kt.AddEntry("svc-app", "EXAMPLE.COM", "secret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	ktData, err := kt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ktPath := filepath.Join(dir, "app.keytab")
+	if err = ioutil.WriteFile(ktPath, ktData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := msdsn.Config{
+		User:      "svc-app",
+		ServerSPN: "MSSQLSvc/db.example.com:1433",
+		Kerberos:  msdsn.Kerberos{ConfigFile: conf, KeytabFile: ktPath},
+	}
+	a1, err := newKerberosAuth(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := newKerberosAuth(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a1.(*krb5Auth).client.Credentials.HasKeytab() {
+		t.Error("the keytab was not used")
+	}
+	if a1.(*krb5Auth).client != a2.(*krb5Auth).client {
+		t.Error("connections with the same credentials do not share the client")
+	}
+
+	p.Kerberos.KeytabFile = ""
+	p.Kerberos.CredCacheFile = "FILE:" + filepath.Join(dir, "krb5cc_missing")
+	if _, err = newKerberosAuth(p); err == nil || !strings.Contains(err.Error(), "krb5cc_missing") {
+		t.Errorf("expected an error for the missing credential cache, got %v", err)
+	}
+	p.Kerberos.CredCacheFile = ktPath
+	if _, err = newKerberosAuth(p); err == nil || !strings.Contains(err.Error(), "credential cache") {
+		t.Errorf("expected an error for an invalid credential cache, got %v", err)
+	}
+}
+
+func TestCredCachePath(t *testing.T) {
+	defer os.Setenv("KRB5CCNAME", os.Getenv("KRB5CCNAME"))
+	os.Setenv("KRB5CCNAME", "FILE:/tmp/krb5cc_app")
+	if path := credCachePath(""); path != "/tmp/krb5cc_app" {
+		t.Errorf("unexpected path %s", path)
+	}
+	if path := credCachePath("/var/run/app.ccache"); path != "/var/run/app.ccache" {
+		t.Errorf("unexpected path %s", path)
+	}
+}
+
+func TestKerberosChannelBindings(t *testing.T) {
+	cksum := krb5AuthenticatorChecksum([]byte("tls-server-end-point:abc"))
+	if len(cksum) != 24 || binary.LittleEndian.Uint32(cksum) != 16 {
+		t.Fatalf("invalid checksum %x", cksum)
+	}
+	if !bytes.Equal(cksum[4:20], channelBindingsHash([]byte("tls-server-end-point:abc"))) {
+		t.Errorf("the checksum should carry the channel bindings hash, got %x", cksum[4:20])
+	}
+	if !bytes.Equal(krb5AuthenticatorChecksum(nil)[4:20], make([]byte, 16)) {
+		t.Error("the channel bindings hash should be zero without TLS")
+	}
+
+	key := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	tkt := messages.Ticket{TktVNO: 5, Realm: "EXAMPLE.COM", SName: types.NewPrincipalName(2, "MSSQLSvc/db.example.com:1433")}
+	tkt.EncPart = types.EncryptedData{EType: etypeID.AES256_CTS_HMAC_SHA1_96, Cipher: []byte{1}}
+	authenticator, err := types.NewAuthenticator("EXAMPLE.COM", types.NewPrincipalName(1, "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.Cksum = types.Checksum{CksumType: chksumtype.GSSAPI, Checksum: cksum}
+	apReq, err := messages.NewAPReq(tkt, key, authenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := krb5MechToken(apReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed spnego.KRB5Token
+	if err := parsed.Unmarshal(token); err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.IsAPReq() || parsed.APReq.Ticket.SName.PrincipalNameString() != "MSSQLSvc/db.example.com:1433" {
+		t.Errorf("unexpected token %+v", parsed)
+	}
+}
@@ -0,0 +1,301 @@
+package mssql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	"github.com/denisenkom/go-mssqldb/internal/cp"
+)
+
+// StreamLOBs is a query argument which makes the value of a
+// varbinary(max), varchar(max), nvarchar(max), xml, text, ntext or image
+// column read lazily when the column is the last one of the result set,
+// instead of being read whole into memory:
+//
+//	rows, err := db.QueryContext(ctx, "select name, content from files", mssql.StreamLOBs{})
+//	...
+//	var content mssql.LOB
+//	err = rows.Scan(&name, &content)
+//	_, err = io.Copy(w, &content)
+//
+// The value must be scanned into a LOB, and read before the next call to
+// Next or Close of the rows, which discard the rest of it. The values of
+// the other columns are read as usual.
+type StreamLOBs struct{}
+
+// LOB is a scan destination reading the value of a varbinary(max),
+// varchar(max), nvarchar(max), xml, text, ntext or image column as
+// a stream, see StreamLOBs.
+// Text is read as UTF-8. The values of the columns that are not streamed
+// can be scanned into a LOB too.
+type LOB struct {
+	r    io.Reader
+	null bool
+}
+
+// Scan implements the sql.Scanner interface.
+func (l *LOB) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case *lobStream:
+		*l = LOB{r: v}
+	case []byte:
+		*l = LOB{r: bytes.NewReader(v)}
+	case string:
+		*l = LOB{r: bytes.NewReader([]byte(v))}
+	case nil:
+		*l = LOB{null: true}
+	default:
+		return fmt.Errorf("mssql: cannot scan a LOB from a value of type %T", src)
+	}
+	return nil
+}
+
+// Read reads the value, it returns io.EOF at its end or when it is null.
+func (l *LOB) Read(p []byte) (int, error) {
+	if l.r == nil {
+		return 0, io.EOF
+	}
+	return l.r.Read(p)
+}
+
+// Null reports whether the value is null.
+func (l *LOB) Null() bool {
+	return l.null
+}
+
+// streamsLastColumn reports whether the value of the last of columns can
+// be streamed: it is sent in PLP chunks, or is a text, ntext or image
+// value, and not encrypted.
+func streamsLastColumn(columns []columnStruct) bool {
+	if len(columns) == 0 {
+		return false
+	}
+	col := columns[len(columns)-1]
+	if col.cryptoMeta != nil {
+		return false
+	}
+	switch col.ti.TypeId {
+	case typeXml, typeText, typeNText, typeImage:
+		return true
+	case typeBigVarBin, typeBigVarChar, typeNVarChar:
+		return col.ti.Size == 0xffff
+	}
+	return false
+}
+
+// lobStream is the value of a streamed column. It reads the PLP chunks of
+// the value from the response while the goroutine reading the response
+// waits, until the end of the value or Close.
+type lobStream struct {
+	r  *tdsBuffer
+	ti *typeInfo
+	// left is the number of bytes left in the current chunk. long is set
+	// for text, ntext and image values, which are a single chunk without
+	// terminator.
+	left uint32
+	long bool
+	// raw holds the bytes of text not decoded yet, decoded the decoded
+	// text not read yet
+	raw     []byte
+	decoded []byte
+	end     bool
+	err     error
+	done    chan struct{}
+}
+
+// newLOBStream reads the length of a PLP value, or the text pointer and
+// the length of a text, ntext or image value, and returns a stream of it,
+// nil when it is null.
+func newLOBStream(r *tdsBuffer, ti *typeInfo) *lobStream {
+	switch ti.TypeId {
+	case typeText, typeNText, typeImage:
+		textptr := r.byte()
+		if textptr == 0 {
+			return nil
+		}
+		r.ReadFull(make([]byte, textptr))
+		r.uint64() // timestamp
+		size := r.int32()
+		if size == -1 {
+			return nil
+		}
+		if size < 0 {
+			badStreamPanicf("Invalid size %d of a %s value", size, makeDecl(*ti))
+		}
+		s := &lobStream{r: r, ti: ti, left: uint32(size), long: true, done: make(chan struct{})}
+		if size == 0 {
+			s.finish(nil)
+		}
+		return s
+	}
+	if r.uint64() == _PLP_NULL {
+		return nil
+	}
+	return &lobStream{r: r, ti: ti, done: make(chan struct{})}
+}
+
+func (s *lobStream) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(s.decoded) == 0 {
+		if s.end {
+			if s.err != nil {
+				return 0, s.err
+			}
+			return 0, io.EOF
+		}
+		if s.ti.TypeId == typeBigVarBin || s.ti.TypeId == typeImage {
+			n, err := s.readChunk(p)
+			if err != nil {
+				return 0, s.fail(err)
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		var buf [4096]byte
+		n, err := s.readChunk(buf[:])
+		if err != nil {
+			return 0, s.fail(err)
+		}
+		s.raw = append(s.raw, buf[:n]...)
+		s.decode()
+	}
+	n := copy(p, s.decoded)
+	s.decoded = s.decoded[n:]
+	return n, nil
+}
+
+// readChunk reads the next bytes of the value into p, it finishes the
+// stream at the terminator of the chunks.
+func (s *lobStream) readChunk(p []byte) (int, error) {
+	if s.left == 0 && s.long {
+		s.finish(nil)
+		return 0, nil
+	}
+	if s.left == 0 {
+		var size [4]byte
+		if _, err := io.ReadFull(s.r, size[:]); err != nil {
+			return 0, err
+		}
+		s.left = binary.LittleEndian.Uint32(size[:])
+		if s.left == 0 {
+			s.finish(nil)
+			return 0, nil
+		}
+	}
+	if uint32(len(p)) > s.left {
+		p = p[:s.left]
+	}
+	n, err := s.r.Read(p)
+	s.left -= uint32(n)
+	return n, err
+}
+
+// decode decodes the complete characters of raw, all of them at the end
+// of the value.
+func (s *lobStream) decode() {
+	switch s.ti.TypeId {
+	case typeBigVarChar, typeText:
+		text, n := cp.CharsetToUTF8Prefix(s.ti.Collation, s.raw)
+		if s.end {
+			text, n = cp.CharsetToUTF8(s.ti.Collation, s.raw), len(s.raw)
+		}
+		s.decoded = append(s.decoded, text...)
+		s.raw = s.raw[n:]
+	default:
+		units := make([]uint16, len(s.raw)/2)
+		for i := range units {
+			units[i] = binary.LittleEndian.Uint16(s.raw[2*i:])
+		}
+		// a high surrogate waits for the low surrogate of the next chunk
+		if k := len(units); !s.end && k > 0 && units[k-1] >= 0xd800 && units[k-1] < 0xdc00 {
+			units = units[:k-1]
+		}
+		s.decoded = append(s.decoded, string(utf16.Decode(units))...)
+		s.raw = s.raw[2*len(units):]
+	}
+}
+
+// fail finishes the stream with err, the response cannot be read further.
+func (s *lobStream) fail(err error) error {
+	s.finish(StreamError{Message: "reading a streamed value failed: " + err.Error()})
+	return s.err
+}
+
+func (s *lobStream) finish(err error) {
+	if s.end {
+		return
+	}
+	s.end, s.err = true, err
+	if err == nil && len(s.raw) > 0 {
+		s.decode()
+	}
+	close(s.done)
+}
+
+// Close discards the rest of the value.
+func (s *lobStream) Close() error {
+	var buf [4096]byte
+	for !s.end {
+		if _, err := s.readChunk(buf[:]); err != nil {
+			return s.fail(err)
+		}
+	}
+	s.decoded = nil
+	return s.err
+}
+
+// wait waits for the end of the value, read by the receiver of the row,
+// and returns the error reading it.
+func (s *lobStream) wait() error {
+	<-s.done
+	return s.err
+}
+
+// closeLOBs discards the streamed value of a row that is not returned.
+func closeLOBs(row []interface{}) {
+	if len(row) > 0 {
+		if s, ok := row[len(row)-1].(*lobStream); ok {
+			s.Close()
+		}
+	}
+}
+
+// LOBParam is a parameter value streamed from a reader as varbinary(max),
+// or as nvarchar(max) when Text is set, so that large values need not be
+// held in memory. The io.Reader parameter values that are not
+// driver.Valuer are sent as a LOBParam of the reader:
+//
+//	f, err := os.Open("image.png")
+//	...
+//	_, err = db.ExecContext(ctx, "insert into images (name, data) values (@p1, @p2)", "image.png", f)
+//
+// Statements with streamed parameters are not retried. A reader failing
+// while the request is sent leaves the connection unusable.
+type LOBParam struct {
+	// R reads the value, UTF-8 text when Text is set. A nil R sends NULL.
+	R io.Reader
+	// Size is the length in bytes of a binary value, sent ahead of it
+	// when it is not zero. R must then provide exactly Size bytes.
+	Size int64
+	// Text sends the value as nvarchar(max).
+	Text bool
+}
+
+// write writes the value as PLP chunks.
+func (p *LOBParam) write(w io.Writer) error {
+	length := uint64(_UNKNOWN_PLP_LEN)
+	if p.Size > 0 && !p.Text {
+		length = uint64(p.Size)
+	}
+	if err := writePLPStream(w, p.R, length, p.Text); err != nil {
+		return StreamError{Message: "streaming a parameter value failed: " + err.Error()}
+	}
+	return nil
+}
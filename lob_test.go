@@ -0,0 +1,190 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestStreamLOBs(t *testing.T) {
+	doc := strings.Repeat("ab©☀😀", 3000)
+	data := bytes.Repeat([]byte{1, 2, 3}, 10000)
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if strings.Contains(req.SQL, "data") {
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "id", Type: mssqltest.Int}, {Name: "data", Type: mssqltest.VarBinary}},
+				Rows:    [][]interface{}{{1, data}},
+			}}
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "id", Type: mssqltest.Int}, {Name: "doc", Type: mssqltest.NVarChar}},
+			Rows:    [][]interface{}{{1, doc}, {2, nil}, {3, doc}, {4, "end"}},
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	rows, err := db.Query("select id, doc from t", StreamLOBs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	var lob LOB
+	for rows.Next() {
+		if err := rows.Scan(&id, &lob); err != nil {
+			t.Fatal(err)
+		}
+		switch id {
+		case 1:
+			b, err := ioutil.ReadAll(&lob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != doc {
+				t.Error("the streamed nvarchar(max) value differs")
+			}
+		case 2:
+			if !lob.Null() {
+				t.Error("expected a null value")
+			}
+		case 3:
+			// not read, discarded by Next
+		case 4:
+			if b, _ := ioutil.ReadAll(&lob); string(b) != "end" {
+				t.Errorf("expected end, got %q", b)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Errorf("expected 4 rows, the last one is %d", id)
+	}
+
+	rows, err = db.Query("select id, data from t", StreamLOBs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := rows.Scan(&id, &lob); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(&lob); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("the streamed varbinary(max) value differs, err %v", err)
+	}
+	if rows.Next() {
+		t.Error("expected a single row")
+	}
+
+	// the connection is usable after closing rows with a value left unread
+	rows, err = db.Query("select id, doc from t", StreamLOBs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := db.QueryRow("select id, doc from t").Scan(&id, &s); err != nil || s != doc {
+		t.Errorf("expected the first row, got %d and error %v", id, err)
+	}
+}
+
+func TestLOBScan(t *testing.T) {
+	var lob LOB
+	if err := lob.Scan("text"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(&lob); string(b) != "text" || lob.Null() {
+		t.Errorf("expected text, got %q", b)
+	}
+	if err := lob.Scan(nil); err != nil || !lob.Null() {
+		t.Errorf("expected a null value, got error %v", err)
+	}
+	if err := lob.Scan(int64(1)); err == nil {
+		t.Error("expected an error scanning an integer")
+	}
+}
+
+func TestLOBParams(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	doc := strings.Repeat("ab©☀😀", 3000)
+	data := bytes.Repeat([]byte{1, 2, 3}, 10000)
+	_, err = db.Exec("insert into t values (@p1, @p2, @p3, @p4)",
+		bytes.NewReader(data),
+		LOBParam{R: strings.NewReader(doc), Text: true},
+		LOBParam{R: bytes.NewReader(data), Size: int64(len(data))},
+		LOBParam{Text: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	req := reqs[len(reqs)-1]
+	if v, ok := req.Param("@p1").Value.([]byte); !ok || !bytes.Equal(v, data) {
+		t.Error("the streamed reader differs")
+	}
+	if v := req.Param("@p2").Value; v != doc {
+		t.Error("the streamed text differs")
+	}
+	if v, ok := req.Param("@p3").Value.([]byte); !ok || !bytes.Equal(v, data) {
+		t.Error("the streamed value of known size differs")
+	}
+	if v := req.Param("@p4").Value; v != nil {
+		t.Errorf("expected a null, got %v", v)
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	errRead := errors.New("read failed")
+	r := io.MultiReader(bytes.NewReader(data), failingReader{errRead})
+	if _, err = conn.ExecContext(context.Background(), "insert into t values (@p1)", r); err == nil {
+		t.Fatal("expected the failing reader to fail the statement")
+	}
+	if _, err = conn.ExecContext(context.Background(), "select 1"); err != driver.ErrBadConn {
+		t.Errorf("expected the connection to be unusable, got %v", err)
+	}
+	if _, err = db.Exec("insert into t values (@p1)", LOBParam{R: bytes.NewReader(data), Size: 10}); err == nil {
+		t.Error("expected an error for a value longer than Size")
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,9 @@
+// +build !windows
+
+package mssql
+
+import "errors"
+
+func localDBPipe(instance string) (string, error) {
+	return "", errors.New("LocalDB is only available on Windows")
+}
@@ -0,0 +1,135 @@
+package mssql
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	_ERROR_NO_MORE_ITEMS syscall.Errno = 259
+	// _LOCALDB_MAX_SQLCONNECTION_BUFFER_SIZE is the size of the connection
+	// string buffer of LocalDBStartInstance, in characters.
+	_LOCALDB_MAX_SQLCONNECTION_BUFFER_SIZE = 260
+	localDBVersionsKey                     = `SOFTWARE\Microsoft\Microsoft SQL Server Local DB\Installed Versions`
+)
+
+var localDBAPI struct {
+	once          sync.Once
+	startInstance *syscall.LazyProc
+	err           error
+}
+
+// loadLocalDBAPI loads the instance API of the newest installed version of
+// LocalDB.
+func loadLocalDBAPI() (*syscall.LazyProc, error) {
+	localDBAPI.once.Do(func() {
+		path, err := localDBInstanceAPIPath()
+		if err != nil {
+			localDBAPI.err = fmt.Errorf("LocalDB is not installed: %v", err)
+			return
+		}
+		proc := syscall.NewLazyDLL(path).NewProc("LocalDBStartInstance")
+		if err = proc.Find(); err != nil {
+			localDBAPI.err = fmt.Errorf("cannot load the LocalDB instance API %s: %v", path, err)
+			return
+		}
+		localDBAPI.startInstance = proc
+	})
+	return localDBAPI.startInstance, localDBAPI.err
+}
+
+// localDBInstanceAPIPath returns the InstanceAPIPath of the newest version
+// listed in the registry.
+func localDBInstanceAPIPath() (string, error) {
+	var versions syscall.Handle
+	err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, syscall.StringToUTF16Ptr(localDBVersionsKey),
+		0, syscall.KEY_READ|syscall.KEY_WOW64_64KEY, &versions)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.RegCloseKey(versions)
+
+	// subkeys must be enumerated from the same thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var newest string
+	for i := uint32(0); ; i++ {
+		name := make([]uint16, 64)
+		n := uint32(len(name))
+		err = syscall.RegEnumKeyEx(versions, i, &name[0], &n, nil, nil, nil, nil)
+		if err == _ERROR_NO_MORE_ITEMS {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if v := syscall.UTF16ToString(name[:n]); newest == "" || compareVersions(v, newest) > 0 {
+			newest = v
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no version in %s", localDBVersionsKey)
+	}
+
+	var version syscall.Handle
+	err = syscall.RegOpenKeyEx(versions, syscall.StringToUTF16Ptr(newest), 0, syscall.KEY_READ|syscall.KEY_WOW64_64KEY, &version)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.RegCloseKey(version)
+	path := make([]uint16, syscall.MAX_PATH)
+	n := uint32(len(path) * 2)
+	var typ uint32
+	err = syscall.RegQueryValueEx(version, syscall.StringToUTF16Ptr("InstanceAPIPath"), nil, &typ,
+		(*byte)(unsafe.Pointer(&path[0])), &n)
+	if err != nil {
+		return "", err
+	}
+	return syscall.UTF16ToString(path[:n/2]), nil
+}
+
+// compareVersions compares dotted version numbers such as 13.0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// localDBPipe starts a LocalDB instance, if it is not running, and returns
+// its named pipe.
+func localDBPipe(instance string) (string, error) {
+	start, err := loadLocalDBAPI()
+	if err != nil {
+		return "", err
+	}
+	name, err := syscall.UTF16PtrFromString(instance)
+	if err != nil {
+		return "", err
+	}
+	conn := make([]uint16, _LOCALDB_MAX_SQLCONNECTION_BUFFER_SIZE+1)
+	n := uint32(len(conn))
+	hr, _, _ := start.Call(
+		uintptr(unsafe.Pointer(name)),
+		0,
+		uintptr(unsafe.Pointer(&conn[0])),
+		uintptr(unsafe.Pointer(&n)))
+	if hr != 0 {
+		return "", fmt.Errorf("cannot start LocalDB instance %s: HRESULT 0x%08x", instance, uint32(hr))
+	}
+	// the connection string is the pipe, np:\\.\pipe\LOCALDB#...\tsql\query
+	return strings.TrimPrefix(syscall.UTF16ToString(conn), "np:"), nil
+}
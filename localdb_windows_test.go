@@ -0,0 +1,20 @@
+package mssql
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"13.0", "11.0", 1},
+		{"11.0", "13.0", -1},
+		{"15.0", "15.0", 0},
+		{"15.0.1", "15.0", 1},
+	}
+	for _, test := range tests {
+		if cmp := compareVersions(test.a, test.b); cmp != test.cmp {
+			t.Errorf("compareVersions(%s, %s) = %d, expected %d", test.a, test.b, cmp, test.cmp)
+		}
+	}
+}
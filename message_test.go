@@ -0,0 +1,72 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestMessageHandler(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{
+			mssqltest.Message{Number: 50000, State: 1, Class: 10, Message: "10 percent done", ProcName: "sp_maintain", LineNo: 7},
+			mssqltest.RowsAffected(1),
+		}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []Error
+	c.MessageHandler = func(msg Error) {
+		msgs = append(msgs, msg)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	if _, err = db.Exec("sp_maintain"); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %+v", msgs)
+	}
+	msg := msgs[0]
+	if msg.Number != 50000 || msg.State != 1 || msg.Class != 10 || msg.Message != "10 percent done" || msg.ProcName != "sp_maintain" || msg.LineNo != 7 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	var ctxMsgs []Error
+	ctx := WithMessageHandler(context.Background(), func(msg Error) {
+		ctxMsgs = append(ctxMsgs, msg)
+	})
+	if _, err = db.ExecContext(ctx, "print 'x'"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctxMsgs) != 1 || len(msgs) != 1 {
+		t.Errorf("expected the message to go to the handler of the context only, got %d and %d", len(ctxMsgs), len(msgs))
+	}
+
+	if _, err = db.ExecContext(WithMessageHandler(context.Background(), nil), "print 'x'"); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Errorf("expected no message with a nil handler, got %d", len(msgs))
+	}
+
+	// the messages the driver reads itself are passed on
+	var driverMsgs int
+	ctx = withMessageFunc(ctx, func(Error) {
+		driverMsgs++
+	})
+	if _, err = db.ExecContext(ctx, "print 'x'"); err != nil {
+		t.Fatal(err)
+	}
+	if driverMsgs != 1 || len(ctxMsgs) != 2 {
+		t.Errorf("expected the message to go to the driver and the handler, got %d and %d", driverMsgs, len(ctxMsgs)-1)
+	}
+}
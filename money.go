@@ -0,0 +1,142 @@
+package mssql
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// moneyScale is the number of digits of money values after the point.
+const moneyScale = 4
+
+// Money is a money value in ten-thousandths of the currency unit, so that
+// Money(12345) is 1.2345. It is sent as a money parameter and money and
+// smallmoney columns are scanned into it exactly, without the rounding of
+// float64. Scan nullable columns into a *Money.
+type Money int64
+
+// SmallMoney is a smallmoney value in ten-thousandths of the currency unit,
+// see Money. It is sent as a smallmoney parameter.
+type SmallMoney int32
+
+// ParseMoney parses a decimal number such as -123.45 with up to 4 digits
+// after the point as a Money.
+func ParseMoney(s string) (Money, error) {
+	m, err := parseMoney(s)
+	return Money(m), err
+}
+
+// ParseSmallMoney parses a decimal number such as -123.45 with up to 4
+// digits after the point as a SmallMoney.
+func ParseSmallMoney(s string) (SmallMoney, error) {
+	m, err := parseMoney(s)
+	if err != nil {
+		return 0, err
+	}
+	if m < math.MinInt32 || m > math.MaxInt32 {
+		return 0, fmt.Errorf("mssql: %s is out of the range of smallmoney", s)
+	}
+	return SmallMoney(m), nil
+}
+
+func parseMoney(s string) (int64, error) {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		return 0, err
+	}
+	if d.Scale() > moneyScale {
+		return 0, fmt.Errorf("mssql: %s has more than %d digits after the point", s, moneyScale)
+	}
+	m := d.Unscaled()
+	m.Mul(m, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(moneyScale-d.Scale())), nil))
+	if !m.IsInt64() {
+		return 0, fmt.Errorf("mssql: %s is out of the range of money", s)
+	}
+	return m.Int64(), nil
+}
+
+// String formats m with 4 digits after the point, such as -123.4500.
+func (m Money) String() string {
+	return formatMoney(int64(m))
+}
+
+// String formats m with 4 digits after the point, such as -123.4500.
+func (m SmallMoney) String() string {
+	return formatMoney(int64(m))
+}
+
+func formatMoney(m int64) string {
+	d, _ := NewDecimal(big.NewInt(m), moneyScale)
+	return d.String()
+}
+
+// Scan implements the sql.Scanner interface, it accepts the money and
+// decimal numbers as the driver reads them, integers and strings.
+func (m *Money) Scan(src interface{}) error {
+	v, err := scanMoney(src, "Money")
+	if err != nil {
+		return err
+	}
+	*m = Money(v)
+	return nil
+}
+
+// Scan implements the sql.Scanner interface, it accepts the money and
+// decimal numbers as the driver reads them, integers and strings.
+func (m *SmallMoney) Scan(src interface{}) error {
+	v, err := scanMoney(src, "SmallMoney")
+	if err != nil {
+		return err
+	}
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return fmt.Errorf("mssql: %s is out of the range of smallmoney", formatMoney(v))
+	}
+	*m = SmallMoney(v)
+	return nil
+}
+
+func scanMoney(src interface{}, name string) (int64, error) {
+	switch v := src.(type) {
+	case []byte:
+		return parseMoney(string(v))
+	case string:
+		return parseMoney(v)
+	case int64:
+		if v < math.MinInt64/10000 || v > math.MaxInt64/10000 {
+			return 0, fmt.Errorf("mssql: %d is out of the range of money", v)
+		}
+		return v * 10000, nil
+	case nil:
+		return 0, errors.New("mssql: cannot scan NULL into a " + name + ", scan into a *" + name)
+	}
+	return 0, fmt.Errorf("mssql: cannot scan a value of type %T into a %s", src, name)
+}
+
+// param returns the parameter of m.
+func (m Money) param() param {
+	var res param
+	res.ti.TypeId = typeMoneyN
+	res.ti.Size = 8
+	res.buffer = encodeMoney(int64(m))
+	return res
+}
+
+// param returns the parameter of m.
+func (m SmallMoney) param() param {
+	var res param
+	res.ti.TypeId = typeMoneyN
+	res.ti.Size = 4
+	res.buffer = make([]byte, 4)
+	binary.LittleEndian.PutUint32(res.buffer, uint32(m))
+	return res
+}
+
+// encodeMoney encodes a money value, the high 32 bits first.
+func encodeMoney(m int64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, uint32(m>>32))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(m))
+	return buf
+}
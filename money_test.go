@@ -0,0 +1,110 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		s string
+		m Money
+	}{
+		{"0", 0},
+		{"1.2345", 12345},
+		{"-0.01", -100},
+		{"922337203685477.5807", 9223372036854775807},
+		{"-922337203685477.5808", -9223372036854775808},
+	}
+	for _, tt := range tests {
+		m, err := ParseMoney(tt.s)
+		if err != nil || m != tt.m {
+			t.Errorf("ParseMoney(%q) = %d, %v, expected %d", tt.s, m, err, tt.m)
+		}
+	}
+	for _, s := range []string{"", "1.23456", "922337203685477.5808", "abc"} {
+		if _, err := ParseMoney(s); err == nil {
+			t.Errorf("expected ParseMoney(%q) to fail", s)
+		}
+	}
+	if _, err := ParseSmallMoney("214749"); err == nil {
+		t.Error("expected an error for a value out of the range of smallmoney")
+	}
+	if s := Money(-100).String(); s != "-0.0100" {
+		t.Errorf("expected -0.0100, got %s", s)
+	}
+	if s := SmallMoney(12345).String(); s != "1.2345" {
+		t.Errorf("expected 1.2345, got %s", s)
+	}
+}
+
+func TestMoney(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if len(req.Params) > 0 {
+			return nil
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "m", Type: mssqltest.Money}, {Name: "n", Type: mssqltest.Money}},
+			Rows:    [][]interface{}{{"-123.4500", nil}},
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	var m Money
+	var sm SmallMoney
+	var n *Money
+	if err := db.QueryRow("select m, n from t").Scan(&m, &n); err != nil {
+		t.Fatal(err)
+	}
+	if m != -1234500 || n != nil {
+		t.Errorf("expected -123.4500 and NULL, got %s and %v", m, n)
+	}
+	if err := db.QueryRow("select m, n from t").Scan(&sm, &n); err != nil || sm != -1234500 {
+		t.Errorf("expected -123.4500, got %s and error %v", sm, err)
+	}
+	if err := db.QueryRow("select m, n from t").Scan(&m, &m); err == nil {
+		t.Error("expected an error scanning NULL into a Money")
+	}
+
+	if _, err := db.Exec("insert into t values (@p1, @p2)", Money(9223372036854775807), SmallMoney(-100)); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	req := reqs[len(reqs)-1]
+	if v := req.Param("@p1").Value; v != "922337203685477.5807" {
+		t.Errorf("expected 922337203685477.5807, got %v", v)
+	}
+	if v := req.Param("@p2").Value; v != "-0.0100" {
+		t.Errorf("expected -0.0100, got %v", v)
+	}
+}
+
+func TestBulkMoney(t *testing.T) {
+	b := &Bulk{}
+	money := columnStruct{ti: typeInfo{TypeId: typeMoneyN, Size: 8}}
+	res, err := b.makeParam(Money(-2), money)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.buffer, []byte{0xff, 0xff, 0xff, 0xff, 0xfe, 0xff, 0xff, 0xff}) {
+		t.Errorf("unexpected encoding % x", res.buffer)
+	}
+	small := columnStruct{ti: typeInfo{TypeId: typeMoney4, Size: 4}}
+	if res, err = b.makeParam("1.5", small); err != nil || !bytes.Equal(res.buffer, []byte{0x98, 0x3a, 0, 0}) {
+		t.Errorf("unexpected encoding % x, error %v", res.buffer, err)
+	}
+	if _, err = b.makeParam(Money(1<<40), small); err == nil {
+		t.Error("expected an error for a value out of the range of smallmoney")
+	}
+}
@@ -2,6 +2,7 @@ package mssql
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/binary"
@@ -10,6 +11,7 @@ import (
 	"io"
 	"math"
 	"net"
+	"net/http"
 	"reflect"
 	"strings"
 	"time"
@@ -99,7 +101,21 @@ func NewConnector(dsn string) (*Connector, error) {
 
 // NewConnectorConfig creates a new Connector for a DSN Config struct.
 // The returned connector may be used with sql.OpenDB.
+//
+// The config may be returned by msdsn.Parse or built in code, zero fields
+// take the defaults of connection strings:
+//
+//	connector := mssql.NewConnectorConfig(msdsn.Config{
+//		Host:        "db.example.com",
+//		Database:    "sales",
+//		User:        "app",
+//		Password:    password,
+//		Encryption:  msdsn.EncryptionRequired,
+//		DialTimeout: 5 * time.Second,
+//	})
+//	db := sql.OpenDB(connector)
 func NewConnectorConfig(config msdsn.Config) *Connector {
+	config.SetDefaults()
 	return &Connector{
 		params: config,
 		driver: driverInstanceNoProcess,
@@ -150,13 +166,144 @@ type Connector struct {
 
 	// Dialer sets a custom dialer for all network operations.
 	// If Dialer is not set, normal net dialers are used.
+	//
+	// Host names are resolved locally and the dialer is given IP
+	// addresses, unless it implements HostDialer.
 	Dialer Dialer
+
+	// TLSConfig, if set, is used for encrypted connections instead of the
+	// configuration built from the certificate, TrustServerCertificate and
+	// hostNameInCertificate parameters of the connection string, e.g. to
+	// set a custom pool of root certificates or a VerifyPeerCertificate
+	// callback. A copy is used, its ServerName defaults to the host the
+	// driver connects to, including the host of a routed connection.
+	TLSConfig *tls.Config
+
+	// ClientCertificate, if set, is presented to the server during the TLS
+	// handshake, in place of the clientcertpath and clientkeypath files of
+	// the connection string.
+	ClientCertificate *tls.Certificate
+
+	// RevocationClient makes the OCSP and CRL requests of the revocationcheck
+	// connection string parameter, http.DefaultClient is used if nil.
+	RevocationClient *http.Client
+
+	// KeyLogWriter, if set, receives the TLS master secrets of encrypted
+	// connections in NSS key log format, so that captured traffic can be
+	// decrypted with tools such as Wireshark. It compromises the security
+	// of the connections and is meant for troubleshooting only, it cannot
+	// be set from the connection string.
+	KeyLogWriter io.Writer
+
+	// TokenProvider, if set, supplies the access token of every login,
+	// which then uses Azure Active Directory federated authentication in
+	// place of the user id and password of the connection string.
+	TokenProvider TokenProvider
+
+	// Credentials, if set, is called for every new physical connection and
+	// returns the user id and password to log in with, in place of those of
+	// the connection string. Secrets can then be fetched from a vault when
+	// connecting and rotated without recreating the pool.
+	Credentials func(ctx context.Context) (user, password string, err error)
+
+	// RetryPolicy, if set, decides which failed connections are retried
+	// and when, in place of the connectretrycount and connectretryinterval
+	// parameters of the connection string.
+	RetryPolicy RetryPolicy
+
+	// StatementRetryPolicy, if set, retries the queries that only read,
+	// those made of a single SELECT statement that does not select INTO a
+	// table, take NEXT VALUE FOR a sequence or query a linked server, when
+	// they fail outside of a transaction before returning rows and the
+	// policy classifies the error as transient. Batches of several
+	// statements are not retried, nor are queries containing a semicolon.
+	// Functions the query calls are assumed not to write. See
+	// WithStatementRetry to retry other statements.
+	StatementRetryPolicy RetryPolicy
+
+	// Location, if set, is the location of the values of the columns of
+	// the types without a time zone offset: date, time, smalldatetime,
+	// datetime and datetime2. Their time.Time values then have the date
+	// and clock read from the server in Location, rather than in UTC.
+	Location *time.Location
+
+	// MessageHandler, if set, receives the informational messages of the
+	// queries of the connector, such as the output of PRINT, unless their
+	// context sets another handler. See WithMessageHandler.
+	MessageHandler func(msg Error)
+
+	failover failoverCache
+
+	// EnclaveAttestationVerifier attests the secure enclaves of the hgs
+	// and aas enclaveattestationprotocol connection parameters.
+	EnclaveAttestationVerifier EnclaveAttestationVerifier
+	enclaves                   enclaveSessionCache
+
+	// ColumnEncryptionKeyProviders maps key store provider names, such as
+	// "AZURE_KEY_VAULT" or "MSSQL_CERTIFICATE_STORE", to the providers used
+	// to decrypt Always Encrypted column encryption keys.
+	ColumnEncryptionKeyProviders map[string]ColumnEncryptionKeyProvider
+
+	// KeyRotationHook is called whenever a column encryption key cannot be
+	// decrypted with the metadata at hand, which usually indicates that
+	// a column master key is being rotated. The metadata is then refreshed
+	// from the server: the parameters of the statement are described again
+	// and the keys of a result set are read from the catalog of their
+	// database on a new connection. The error's Recovered field is set when
+	// the refreshed metadata resolved the problem.
+	KeyRotationHook func(err *KeyRotationError)
+
+	cekCache cekCache
+
+	stmtCacheCounters stmtCacheCounters
+}
+
+// TokenProvider supplies access tokens for federated authentication. It
+// lets any credential be plugged in without the driver depending on an SDK,
+// e.g. an azidentity credential:
+//
+//	type credential struct{ azcore.TokenCredential }
+//
+//	func (c credential) GetToken(ctx context.Context) (string, error) {
+//		t, err := c.TokenCredential.GetToken(ctx, policy.TokenRequestOptions{
+//			Scopes: []string{"https://database.windows.net/.default"},
+//		})
+//		return t.Token, err
+//	}
+//
+//	cred, err := azidentity.NewDefaultAzureCredential(nil)
+//	...
+//	connector.TokenProvider = credential{cred}
+//
+// GetToken is called for every new connection, implementations should cache
+// tokens until they are about to expire.
+type TokenProvider interface {
+	GetToken(ctx context.Context) (string, error)
+}
+
+// TokenProviderFunc adapts a function to the TokenProvider interface.
+type TokenProviderFunc func(ctx context.Context) (string, error)
+
+// GetToken calls f.
+func (f TokenProviderFunc) GetToken(ctx context.Context) (string, error) {
+	return f(ctx)
 }
 
 type Dialer interface {
 	DialContext(ctx context.Context, network string, addr string) (net.Conn, error)
 }
 
+// HostDialer is a Dialer that is given host names rather than IP addresses,
+// for dialers that reach the server through a bastion tunnel or proxy where
+// names are resolved on the other side, or that connect in-memory pipes in
+// tests.
+type HostDialer interface {
+	Dialer
+	// HostName returns the host name to dial instead of the host of the
+	// connection string, or "" to dial the host of the connection string.
+	HostName() string
+}
+
 func (c *Connector) getDialer(p *msdsn.Config) Dialer {
 	if c != nil && c.Dialer != nil {
 		return c.Dialer
@@ -167,23 +314,88 @@ func (c *Connector) getDialer(p *msdsn.Config) Dialer {
 type Conn struct {
 	connector      *Connector
 	sess           *tdsSession
+	server         string
 	transactionCtx context.Context
 	resetSession   bool
 
 	processQueryText bool
 	connectionGood   bool
 
+	// tvpColumns caches the columns of the table types of TVPs whose
+	// fields are mapped by name, by type name
+	tvpColumns map[string][]string
+	// describedParams caches the suggested types of the parameters of the
+	// statements, by statement text, see msdsn.Config.DescribeParameters
+	describedParams map[string]map[string]describedParam
+	// stmtCache keeps the handles of the statements prepared on the
+	// server by text, see msdsn.Config.StatementCacheSize, evictedHandles
+	// are those to release before the next request
+	stmtCache      *stmtCache
+	evictedHandles []int32
+
 	outs outputs
 }
 
 type outputs struct {
 	params       map[string]interface{}
 	returnStatus *ReturnStatus
-}
-
-// IsValid satisfies the driver.Validator interface.
+	// msgFunc receives the informational messages of the response.
+	msgFunc func(Error)
+	// encrypted describes the encrypted parameters of the request by
+	// name, to decrypt their return values.
+	encrypted map[string]*cryptoMetadata
+	// dataClassification receives the classification of the result sets.
+	dataClassification *DataClassification
+	// columnFlags receives the flags of the columns of the result sets.
+	columnFlags *[]ColumnFlags
+	// columnSources receives the base table columns of the result sets.
+	columnSources *[]ColumnSource
+	// xmlSchemaCollections receives the XML schema collections of the
+	// columns of the result sets.
+	xmlSchemaCollections *[]XMLSchemaCollection
+	// collations receives the collations of the columns of the result
+	// sets.
+	collations *[]Collation
+	// streamLOBs streams the values of the last column, see StreamLOBs.
+	streamLOBs bool
+	// reuseRowBuffers reads the rows in reused buffers, see
+	// ReuseRowBuffers.
+	reuseRowBuffers bool
+	// prepared receives the handle of the statement prepared by an
+	// sp_prepexec call, its first return value.
+	prepared func(handle int32)
+	// statementResults receives the outcome of the statements of the
+	// response, see StatementResults.
+	statementResults *StatementResults
+	// rpc is set for the responses of RPC requests.
+	rpc bool
+}
+
+// Server returns the server of the connection, as host or host\instance.
+// It is the mirroring partner after a failover, see FailoverPartner.
+func (c *Conn) Server() string {
+	return c.server
+}
+
+// FailoverPartner returns the database mirroring partner the server
+// advertised, empty if the database is not mirrored. New connections of
+// the connector fall back to it when the server cannot be reached.
+func (c *Conn) FailoverPartner() string {
+	return c.sess.partner
+}
+
+// IsValid satisfies the driver.Validator interface. A connection the server
+// or the network closed, e.g. a session killed with KILL or a connection
+// dropped by a firewall while idle in the pool, is not valid, unless its
+// session is recovered on a new connection by the next request.
 func (c *Conn) IsValid() bool {
-	return c.connectionGood
+	if !c.connectionGood {
+		return false
+	}
+	if r := c.sess.recovery; r != nil && r.recoverable && c.sess.tranid == 0 {
+		return true
+	}
+	return connAlive(c.sess.conn)
 }
 
 func (c *Conn) checkBadConn(err error) error {
@@ -301,6 +513,9 @@ func (c *Conn) begin(ctx context.Context, tdsIsolation isoLevel) (tx driver.Tx,
 	if !c.connectionGood {
 		return nil, driver.ErrBadConn
 	}
+	if err = c.recoverSession(ctx); err != nil {
+		return nil, err
+	}
 	err = c.sendBeginRequest(ctx, tdsIsolation)
 	if err != nil {
 		return nil, c.checkBadConn(err)
@@ -344,38 +559,88 @@ func (d *Driver) open(ctx context.Context, dsn string) (*Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	c := &Connector{params: params}
-	return d.connect(ctx, c, params)
+	c := &Connector{params: params, driver: d}
+	return d.connect(ctx, c, params, nil)
 }
 
 // connect to the server, using the provided context for dialing only.
-func (d *Driver) connect(ctx context.Context, c *Connector, params msdsn.Config) (*Conn, error) {
-	sess, err := connect(ctx, c, d.log, params)
-	if err != nil {
-		// main server failed, try fail-over partner
-		if params.FailOverPartner == "" {
-			return nil, err
+// Recovery, if set, recovers a session whose connection broke.
+func (d *Driver) connect(ctx context.Context, c *Connector, params msdsn.Config, recovery *featureExtSessionRecovery) (*Conn, error) {
+	policy := c.RetryPolicy
+	if policy == nil {
+		policy = ExponentialBackoff{Retries: params.ConnectRetryCount, Interval: params.ConnectRetryInterval}
+	}
+	for attempt := 0; ; attempt++ {
+		conn, err := d.connectOnce(ctx, c, params, recovery)
+		if err == nil || attempt >= policy.MaxRetries() || !policy.Retryable(err) {
+			return conn, err
 		}
-
-		params.Host = params.FailOverPartner
-		if params.FailOverPort != 0 {
-			params.Port = params.FailOverPort
+		delay := policy.Delay(attempt)
+		if uint64(params.LogFlags)&logDebug != 0 {
+			d.log.Printf("connection failed with a transient error, retrying in %v: %v", delay, err)
 		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
 
-		sess, err = connect(ctx, c, d.log, params)
+// connectOnce connects to the server, or to its fail-over partner if the
+// server cannot be reached.
+func (d *Driver) connectOnce(ctx context.Context, c *Connector, params msdsn.Config, recovery *featureExtSessionRecovery) (*Conn, error) {
+	if c.Credentials != nil {
+		var err error
+		params.User, params.Password, err = c.Credentials(ctx)
 		if err != nil {
-			// fail-over partner also failed, now fail
 			return nil, err
 		}
 	}
+	var sess *tdsSession
+	var err error
+	// the principal, if it cannot be reached its mirroring partner
+	for _, target := range c.failover.targets(params) {
+		if sess, err = connectSession(ctx, c, d.log, target, recovery); err == nil {
+			params = target
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.failover.connected(params, sess.partner)
+	sess.partnerChanged = func(partner string) {
+		c.failover.partnerChanged(params, partner)
+	}
+	// the messages of the login, such as the change of database, are not
+	// those of a query
+	sess.messageHandler = c.MessageHandler
 
 	conn := &Conn{
 		connector:        c,
 		sess:             sess,
+		server:           serverName(params),
 		transactionCtx:   context.Background(),
 		processQueryText: d.processQueryText,
 		connectionGood:   true,
 	}
+	// KILL is sent to the server of the session, the listener or the
+	// gateway of a routed session may route it elsewhere. The session id is
+	// that of the login response, the reader of a query writes it again.
+	routed, spid := sess.params, sess.buf.spid
+	sess.killSession = func() error {
+		return d.killSession(c, routed, spid)
+	}
+	sess.decryptKey = func(entry *cekTableEntry) ([]byte, error) {
+		// rows are decrypted while the response is read, without the
+		// context of the query; the keys of its parameters are cached
+		return c.resolveCEK(context.Background(), entry, func(context.Context) (*cekTableEntry, error) {
+			return d.readCEKValues(c, routed, entry)
+		})
+	}
 
 	return conn, nil
 }
@@ -389,6 +654,11 @@ type Stmt struct {
 	query      string
 	paramCount int
 	notifSub   *queryNotifSub
+
+	// prep holds the handles of the statement prepared on the server
+	prep *stmtHandles
+	// sentHandle is the handle executed by the last request, zero if none
+	sentHandle int32
 }
 
 type queryNotifSub struct {
@@ -412,11 +682,12 @@ func (c *Conn) prepareContext(ctx context.Context, query string) (*Stmt, error)
 	if c.processQueryText {
 		query, paramCount = querytext.ParseParams(query)
 	}
-	return &Stmt{c, query, paramCount, nil}, nil
+	return &Stmt{c: c, query: query, paramCount: paramCount}, nil
 }
 
+// Close releases the handles of the statement prepared on the server.
 func (s *Stmt) Close() error {
-	return nil
+	return s.unprepare(context.Background())
 }
 
 func (s *Stmt) SetQueryNotification(id, options string, timeout time.Duration) {
@@ -434,20 +705,40 @@ func (s *Stmt) NumInput() int {
 	return s.paramCount
 }
 
-func (s *Stmt) sendQuery(args []namedValue) (err error) {
+func (s *Stmt) sendQuery(ctx context.Context, args []namedValue) (err error) {
+	s.sentHandle = 0
+	direct := s.directExecution(ctx)
+	// the statements the cache evicts for this one are released first
+	prepare := !direct && !isProc(s.query) && s.prepareOnServer(args)
+	if err = s.c.releaseHandles(ctx); err != nil {
+		return
+	}
+	if err = s.lookupTVPColumns(ctx, args); err != nil {
+		return
+	}
+	var described map[string]describedParam
+	if len(args) > 0 && s.c.connector != nil && s.c.connector.params.DescribeParameters && !isProc(s.query) {
+		if described, err = s.c.describeParams(ctx, s.query); err != nil {
+			return
+		}
+	}
 	headers := []headerStruct{
 		{hdrtype: dataStmHdrTransDescr,
 			data: transDescrHdr{s.c.sess.tranid, 1}.pack()},
 	}
 
-	if s.notifSub != nil {
+	notifSub := s.notifSub
+	if notifSub == nil {
+		notifSub = queryNotificationFromContext(ctx)
+	}
+	if notifSub != nil {
 		headers = append(headers,
 			headerStruct{
 				hdrtype: dataStmHdrQueryNotif,
 				data: queryNotifHdr{
-					s.notifSub.msgText,
-					s.notifSub.options,
-					s.notifSub.timeout,
+					notifSub.msgText,
+					notifSub.options,
+					notifSub.timeout,
 				}.pack(),
 			})
 	}
@@ -468,11 +759,19 @@ func (s *Stmt) sendQuery(args []namedValue) (err error) {
 		}
 	}
 
+	var enclave []byte
+	if conn.sess.enclaveType != "" {
+		// requests of sessions with a secure enclave carry an enclave
+		// package, empty unless the statement uses the enclave
+		enclave = []byte{}
+	}
+	// statements without parameters run directly are sent as batches, even
+	// when they look like the name of a stored procedure
+	isProc := isProc(s.query) && !(direct && len(args) == 0)
 	reset := conn.resetSession
 	conn.resetSession = false
-	isProc := isProc(s.query)
 	if len(args) == 0 && !isProc {
-		if err = sendSqlBatch72(conn.sess.buf, s.query, headers, reset); err != nil {
+		if err = sendSqlBatch72(conn.sess.buf, s.query, headers, enclave, reset); err != nil {
 			if conn.sess.logFlags&logErrors != 0 {
 				conn.sess.log.Printf("Failed to send SqlBatch with %v", err)
 			}
@@ -484,20 +783,35 @@ func (s *Stmt) sendQuery(args []namedValue) (err error) {
 		var params []param
 		if isProc {
 			proc.name = s.query
-			params, _, err = s.makeRPCParams(args, true)
+			params, _, err = s.makeRPCParams(args, true, nil, false)
 			if err != nil {
 				return
 			}
 		} else {
 			var decls []string
-			params, decls, err = s.makeRPCParams(args, false)
+			params, decls, err = s.makeRPCParams(args, false, described, prepare)
 			if err != nil {
 				return
 			}
-			params[0] = makeStrParam(s.query)
-			params[1] = makeStrParam(strings.Join(decls, ","))
+			if conn.sess.columnEncryption {
+				// stored procedures called by name are sent in plaintext
+				var pkg []byte
+				if pkg, err = s.encryptParams(ctx, params, decls); err != nil {
+					return
+				}
+				if pkg != nil {
+					enclave = pkg
+				}
+			}
+			if prepare {
+				proc, params = s.preparedCall(params, strings.Join(decls, ","))
+			} else {
+				params[0] = makeStrParam(s.query)
+				params[1] = makeStrParam(strings.Join(decls, ","))
+			}
 		}
-		if err = sendRpc(conn.sess.buf, headers, proc, 0, params, reset); err != nil {
+		conn.outs.rpc = true
+		if err = sendRpc(conn.sess.buf, headers, enclave, proc, 0, params, reset); err != nil {
 			if conn.sess.logFlags&logErrors != 0 {
 				conn.sess.log.Printf("Failed to send Rpc with %v", err)
 			}
@@ -562,7 +876,12 @@ func isProc(s string) bool {
 	return true
 }
 
-func (s *Stmt) makeRPCParams(args []namedValue, isProc bool) ([]param, []string, error) {
+// makeRPCParams returns the parameters of args and their declarations.
+// The parameters with a described type are sent as that type when their
+// value converts to it. The input parameters of statements prepared on the
+// server are declared with the maximum length of their type, for the
+// declarations to stay the same between executions.
+func (s *Stmt) makeRPCParams(args []namedValue, isProc bool, described map[string]describedParam, prepare bool) ([]param, []string, error) {
 	var err error
 	var offset int
 	if !isProc {
@@ -581,11 +900,18 @@ func (s *Stmt) makeRPCParams(args []namedValue, isProc bool) ([]param, []string,
 		} else if !isProc {
 			name = fmt.Sprintf("@p%d", val.Ordinal)
 		}
+		if d, ok := described[name]; ok {
+			if p, ok := s.makeDescribedParam(val.Value, d); ok {
+				params[i+offset] = p
+			}
+		}
 		params[i+offset].Name = name
 		const outputSuffix = " output"
 		var output string
 		if isOutputValue(val.Value) {
 			output = outputSuffix
+		} else if prepare {
+			widenParam(&params[i+offset])
 		}
 		decls[i] = fmt.Sprintf("%s %s%s", name, makeDecl(params[i+offset].ti), output)
 
@@ -620,7 +946,26 @@ func (s *Stmt) queryContext(ctx context.Context, args []namedValue) (rows driver
 	if !s.c.connectionGood {
 		return nil, driver.ErrBadConn
 	}
-	if err = s.sendQuery(args); err != nil {
+	policy, outs := s.statementRetryPolicy(ctx, true, args), s.c.outs
+	for attempt := 0; ; attempt++ {
+		s.c.outs = outs
+		rows, err = s.queryOnce(ctx, args)
+		if s.handleLost(err) {
+			// the server released the handle, prepare the statement again
+			s.c.outs = outs
+			rows, err = s.queryOnce(ctx, args)
+		}
+		if !s.retryStatement(ctx, policy, attempt, err) {
+			return rows, err
+		}
+	}
+}
+
+func (s *Stmt) queryOnce(ctx context.Context, args []namedValue) (rows driver.Rows, err error) {
+	if err = s.c.recoverSession(ctx); err != nil {
+		return nil, err
+	}
+	if err = s.sendQuery(ctx, args); err != nil {
 		return nil, s.c.checkBadConn(err)
 	}
 	return s.processQueryResponse(ctx)
@@ -632,6 +977,7 @@ func (s *Stmt) processQueryResponse(ctx context.Context) (res driver.Rows, err e
 	s.c.clearOuts()
 	// process metadata
 	var cols []columnStruct
+	var classification *DataClassification
 loop:
 	for {
 		tok, err := reader.nextToken()
@@ -647,10 +993,13 @@ loop:
 				// see TestIgnoreEmptyResults test
 				//case doneStruct:
 				//break loop
+				case *DataClassification:
+					classification = token
 				case []columnStruct:
 					cols = token
 					break loop
 				case doneStruct:
+					classification = nil
 					if token.isError() {
 						// need to cleanup cancellable context
 						cancel()
@@ -668,7 +1017,12 @@ loop:
 			return nil, s.c.checkBadConn(err)
 		}
 	}
-	res = &Rows{stmt: s, reader: reader, cols: cols, cancel: cancel}
+	reader.outs.setDataClassification(classification)
+	reader.outs.setColumnFlags(cols)
+	reader.outs.setColumnSources(cols)
+	reader.outs.setXMLSchemaCollections(cols)
+	reader.outs.setCollations(cols)
+	res = &Rows{stmt: s, reader: reader, cols: cols, classification: classification, cancel: cancel}
 	return
 }
 
@@ -682,7 +1036,26 @@ func (s *Stmt) exec(ctx context.Context, args []namedValue) (res driver.Result,
 	if !s.c.connectionGood {
 		return nil, driver.ErrBadConn
 	}
-	if err = s.sendQuery(args); err != nil {
+	policy, outs := s.statementRetryPolicy(ctx, false, args), s.c.outs
+	for attempt := 0; ; attempt++ {
+		s.c.outs = outs
+		res, err = s.execOnce(ctx, args)
+		if s.handleLost(err) {
+			// the server released the handle, prepare the statement again
+			s.c.outs = outs
+			res, err = s.execOnce(ctx, args)
+		}
+		if !s.retryStatement(ctx, policy, attempt, err) {
+			return res, err
+		}
+	}
+}
+
+func (s *Stmt) execOnce(ctx context.Context, args []namedValue) (res driver.Result, err error) {
+	if err = s.c.recoverSession(ctx); err != nil {
+		return nil, err
+	}
+	if err = s.sendQuery(ctx, args); err != nil {
 		return nil, s.c.checkBadConn(err)
 	}
 	if res, err = s.processExec(ctx); err != nil {
@@ -706,14 +1079,31 @@ type Rows struct {
 	cols     []columnStruct
 	reader   *tokenProcessor
 	nextCols []columnStruct
+	// classification is the data classification of the current result
+	// set, nextClassification the one of the next result set
+	classification     *DataClassification
+	nextClassification *DataClassification
+	// lob is the streamed value of the current row, if any
+	lob *lobStream
 
 	cancel func()
 }
 
+// closeLOB discards the rest of the streamed value of the current row.
+func (rc *Rows) closeLOB() error {
+	if rc.lob == nil {
+		return nil
+	}
+	err := rc.lob.Close()
+	rc.lob = nil
+	return err
+}
+
 func (rc *Rows) Close() error {
 	// need to add a test which returns lots of rows
 	// and check closing after reading only few rows
 	rc.cancel()
+	rc.closeLOB()
 
 	for {
 		tok, err := rc.reader.nextToken()
@@ -722,6 +1112,9 @@ func (rc *Rows) Close() error {
 				return nil
 			} else {
 				// continue consuming tokens
+				if row, ok := tok.([]interface{}); ok {
+					closeLOBs(row)
+				}
 				continue
 			}
 		} else {
@@ -749,6 +1142,9 @@ func (rc *Rows) Next(dest []driver.Value) error {
 	if rc.nextCols != nil {
 		return io.EOF
 	}
+	if err := rc.closeLOB(); err != nil {
+		return rc.stmt.c.checkBadConn(err)
+	}
 	for {
 		tok, err := rc.reader.nextToken()
 		if err == nil {
@@ -756,13 +1152,19 @@ func (rc *Rows) Next(dest []driver.Value) error {
 				return io.EOF
 			} else {
 				switch tokdata := tok.(type) {
+				case *DataClassification:
+					rc.nextClassification = tokdata
 				case []columnStruct:
 					rc.nextCols = tokdata
 					return io.EOF
 				case []interface{}:
+					if err := decodeUDTs(rc.cols, tokdata); err != nil {
+						return err
+					}
 					for i := range dest {
 						dest[i] = tokdata[i]
 					}
+					rc.lob, _ = tokdata[len(tokdata)-1].(*lobStream)
 					return nil
 				case doneStruct:
 					if tokdata.isError() {
@@ -788,16 +1190,35 @@ func (rc *Rows) HasNextResultSet() bool {
 func (rc *Rows) NextResultSet() error {
 	rc.cols = rc.nextCols
 	rc.nextCols = nil
+	rc.classification = rc.nextClassification
+	rc.nextClassification = nil
 	if rc.cols == nil {
 		return io.EOF
 	}
+	rc.reader.outs.setDataClassification(rc.classification)
+	rc.reader.outs.setColumnFlags(rc.cols)
+	rc.reader.outs.setColumnSources(rc.cols)
+	rc.reader.outs.setXMLSchemaCollections(rc.cols)
+	rc.reader.outs.setCollations(rc.cols)
 	return nil
 }
 
+// DataClassification returns the sensitivity classification of the
+// current result set, nil if the server sent none.
+func (rc *Rows) DataClassification() *DataClassification {
+	return rc.classification
+}
+
 // It should return
 // the value type that can be used to scan types into. For example, the database
 // column type "bigint" this should return "reflect.TypeOf(int64(0))".
 func (r *Rows) ColumnTypeScanType(index int) reflect.Type {
+	if r.stmt.c.sess.decimalAsString && isDecimalType(r.cols[index].ti.TypeId) {
+		return reflect.TypeOf("")
+	}
+	if udt := lookupUDT(r.cols[index].ti); udt != nil {
+		return udt.Type
+	}
 	return makeGoLangScanType(r.cols[index].ti)
 }
 
@@ -840,11 +1261,32 @@ func (r *Rows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
 // to be not nullable.
 // If the column nullability is unknown, ok should be false.
 func (r *Rows) ColumnTypeNullable(index int) (nullable, ok bool) {
-	nullable = r.cols[index].Flags&colFlagNullable != 0
-	ok = true
+	flags := r.cols[index].Flags
+	nullable = flags&colFlagNullable != 0
+	ok = flags&colFlagNullableUnknown == 0
 	return
 }
 
+// ColumnTypeFlags returns the flags of a column, which tell identity,
+// computed and hidden columns apart. With database/sql, pass a
+// *[]ColumnFlags as a query argument: it is set to the flags of the
+// columns of each result set when the result set starts.
+func (r *Rows) ColumnTypeFlags(index int) ColumnFlags {
+	return ColumnFlags(r.cols[index].Flags)
+}
+
+// ColumnTypeSource returns the base table column of a column, ok is false
+// outside browse mode. Queries are in browse mode with FOR BROWSE, or in
+// sessions with SET NO_BROWSETABLE ON. With database/sql, pass a
+// *[]ColumnSource as a query argument: it is set to the sources of the
+// columns of each result set when the result set starts.
+func (r *Rows) ColumnTypeSource(index int) (source ColumnSource, ok bool) {
+	if src := r.cols[index].source; src != nil {
+		return *src, true
+	}
+	return ColumnSource{}, false
+}
+
 func makeStrParam(val string) (res param) {
 	res.ti.TypeId = typeNVarChar
 	res.buffer = str2ucs2(val)
@@ -939,7 +1381,7 @@ func (c *Conn) Ping(ctx context.Context) error {
 	if !c.connectionGood {
 		return driver.ErrBadConn
 	}
-	stmt := &Stmt{c, `select 1;`, 0, nil}
+	stmt := &Stmt{c: c, query: `select 1;`}
 	_, err := stmt.ExecContext(ctx, nil)
 	return err
 }
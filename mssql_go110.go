@@ -11,11 +11,15 @@ import (
 var _ driver.Connector = &Connector{}
 var _ driver.SessionResetter = &Conn{}
 
+// ResetSession satisfies the driver.SessionResetter interface. It is called
+// before a pooled connection is reused and flags the next request to reset
+// the session on the server, unless the resetconnection parameter is false,
+// then runs Connector.SessionInitSQL.
 func (c *Conn) ResetSession(ctx context.Context) error {
-	if !c.connectionGood {
+	if !c.IsValid() {
 		return driver.ErrBadConn
 	}
-	c.resetSession = true
+	c.resetSession = c.connector == nil || !c.connector.params.DisableResetConnection
 
 	if c.connector == nil || len(c.connector.SessionInitSQL) == 0 {
 		return nil
@@ -35,7 +39,7 @@ func (c *Conn) ResetSession(ctx context.Context) error {
 
 // Connect to the server and return a TDS connection.
 func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
-	conn, err := c.driver.connect(ctx, c, c.params)
+	conn, err := c.driver.connect(ctx, c, c.params, nil)
 	if err == nil {
 		err = conn.ResetSession(ctx)
 	}
@@ -7,6 +7,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"time"
 
@@ -32,12 +33,65 @@ type VarChar string
 type NVarCharMax string
 type VarCharMax string
 
+// Text, NText and Image encode parameters to the legacy text, ntext and
+// image types, for the procedures and functions declaring them, which
+// varchar(max), nvarchar(max) and varbinary(max) parameters do not
+// convert to. A nil Image is NULL.
+type Text string
+type NText string
+type Image []byte
+
 // DateTime1 encodes parameters to original DateTime SQL types.
 type DateTime1 time.Time
 
 // DateTimeOffset encodes parameters to DateTimeOffset, preserving the UTC offset.
 type DateTimeOffset time.Time
 
+// NullDateTimeOffset is a datetimeoffset that may be NULL, such as the
+// destination of a nullable output parameter.
+type NullDateTimeOffset struct {
+	Time  time.Time
+	Valid bool // Valid is true if Time is not NULL
+}
+
+// Scan implements the sql.Scanner interface.
+func (n *NullDateTimeOffset) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*n = NullDateTimeOffset{Time: v, Valid: true}
+	case nil:
+		*n = NullDateTimeOffset{}
+	default:
+		return fmt.Errorf("mssql: cannot scan a value of type %T into a NullDateTimeOffset", src)
+	}
+	return nil
+}
+
+// DateOnly encodes parameters to date, with the date of the time.Time.
+type DateOnly time.Time
+
+// SmallDateTime encodes parameters to smalldatetime, with the date and clock
+// of the time.Time rounded to the minute.
+type SmallDateTime time.Time
+
+// DateTime2 encodes parameters to datetime2(Scale), with the date and clock
+// of Value. Declaring a parameter with the scale of the column it is
+// compared to spares an implicit conversion, which prevents index seeks.
+// The fractional seconds beyond Scale are truncated.
+type DateTime2 struct {
+	Value time.Time
+	// Scale is the number of digits of the fractional seconds, 0 to 7.
+	Scale int
+}
+
+// Time encodes parameters to time(Scale), with the clock of Value, see
+// DateTime2.
+type Time struct {
+	Value time.Time
+	// Scale is the number of digits of the fractional seconds, 0 to 7.
+	Scale int
+}
+
 func convertInputParameter(val interface{}) (interface{}, error) {
 	switch v := val.(type) {
 	case VarChar:
@@ -46,9 +100,15 @@ func convertInputParameter(val interface{}) (interface{}, error) {
 		return val, nil
 	case VarCharMax:
 		return val, nil
+	case Text, NText, Image:
+		return val, nil
 	case DateTime1:
 		return val, nil
-	case DateTimeOffset:
+	case DateTimeOffset, NullDateTimeOffset:
+		return val, nil
+	case DateTime2, Time:
+		return val, nil
+	case DateOnly, SmallDateTime:
 		return val, nil
 	case civil.Date:
 		return val, nil
@@ -56,62 +116,138 @@ func convertInputParameter(val interface{}) (interface{}, error) {
 		return val, nil
 	case civil.Time:
 		return val, nil
+	case LOBParam:
+		return val, nil
+	case Decimal, NullDecimal:
+		return val, nil
+	case Money, SmallMoney:
+		return val, nil
+	case UniqueIdentifier:
+		return val, nil
+	case RowVersion:
+		return val, nil
+	case XML:
+		return val, nil
+	case Geometry, Geography:
+		return val, nil
+	case HierarchyID:
+		return val, nil
+	case NullUniqueIdentifier:
+		if !v.Valid {
+			return nil, nil
+		}
+		return v.UUID, nil
+	case io.Reader:
+		if _, ok := v.(driver.Valuer); !ok {
+			return LOBParam{R: v}, nil
+		}
+		return driver.DefaultParameterConverter.ConvertValue(v)
 		// case *apd.Decimal:
 		// 	return nil
 	default:
+		if lookupUDTEncoder(v) != nil {
+			return val, nil
+		}
 		return driver.DefaultParameterConverter.ConvertValue(v)
 	}
 }
 
-func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
-	switch v := nv.Value.(type) {
-	case sql.Out:
-		if c.outs.params == nil {
-			c.outs.params = make(map[string]interface{})
-		}
-		c.outs.params[nv.Name] = v.Dest
+// checkOut registers the destination of an output parameter and returns
+// the value it points to, passed as input.
+func (c *Conn) checkOut(name string, dest interface{}) (interface{}, error) {
+	if c.outs.params == nil {
+		c.outs.params = make(map[string]interface{})
+	}
+	c.outs.params[name] = dest
 
-		if v.Dest == nil {
-			return errors.New("destination is a nil pointer")
-		}
+	if dest == nil {
+		return nil, errors.New("destination is a nil pointer")
+	}
 
-		dest_info := reflect.ValueOf(v.Dest)
-		if dest_info.Kind() != reflect.Ptr {
-			return errors.New("destination not a pointer")
-		}
+	dest_info := reflect.ValueOf(dest)
+	if dest_info.Kind() != reflect.Ptr {
+		return nil, errors.New("destination not a pointer")
+	}
 
-		if dest_info.IsNil() {
-			return errors.New("destination is a nil pointer")
-		}
+	if dest_info.IsNil() {
+		return nil, errors.New("destination is a nil pointer")
+	}
 
-		pointed_value := reflect.Indirect(dest_info)
+	pointed_value := reflect.Indirect(dest_info)
 
-		// don't allow pointer to a pointer, only pointer to a value can be handled
-		// correctly
-		if pointed_value.Kind() == reflect.Ptr {
-			return errors.New("destination is a pointer to a pointer")
-		}
+	// don't allow pointer to a pointer, only pointer to a value can be handled
+	// correctly
+	if pointed_value.Kind() == reflect.Ptr {
+		return nil, errors.New("destination is a pointer to a pointer")
+	}
 
-		// Unwrap the Out value and check the inner value.
-		val := pointed_value.Interface()
-		if val == nil {
-			return errors.New("MSSQL does not allow NULL value without type for OUTPUT parameters")
-		}
-		conv, err := convertInputParameter(val)
+	// Unwrap the Out value and check the inner value.
+	val := pointed_value.Interface()
+	if val == nil {
+		return nil, errors.New("MSSQL does not allow NULL value without type for OUTPUT parameters")
+	}
+	conv, err := convertInputParameter(val)
+	if err != nil {
+		return nil, err
+	}
+	if conv == nil {
+		// if we replace with nil we would lose type information
+		return val, nil
+	}
+	return conv, nil
+}
+
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch v := nv.Value.(type) {
+	case sql.Out:
+		val, err := c.checkOut(nv.Name, v.Dest)
 		if err != nil {
 			return err
 		}
-		if conv == nil {
-			// if we replace with nil we would lose type information
-			nv.Value = sql.Out{Dest: val}
-		} else {
-			nv.Value = sql.Out{Dest: conv}
+		nv.Value = sql.Out{Dest: val}
+		return nil
+	case OutParam:
+		val, err := c.checkOut(nv.Name, v.Dest)
+		if err != nil {
+			return err
 		}
+		v.Dest = val
+		nv.Value = v
 		return nil
 	case *ReturnStatus:
 		*v = 0 // By default the return value should be zero.
 		c.outs.returnStatus = v
 		return driver.ErrRemoveArgument
+	case *StatementResults:
+		*v = nil
+		c.outs.statementResults = v
+		return driver.ErrRemoveArgument
+	case *DataClassification:
+		*v = DataClassification{}
+		c.outs.dataClassification = v
+		return driver.ErrRemoveArgument
+	case *[]ColumnFlags:
+		*v = nil
+		c.outs.columnFlags = v
+		return driver.ErrRemoveArgument
+	case *[]ColumnSource:
+		*v = nil
+		c.outs.columnSources = v
+		return driver.ErrRemoveArgument
+	case *[]XMLSchemaCollection:
+		*v = nil
+		c.outs.xmlSchemaCollections = v
+		return driver.ErrRemoveArgument
+	case *[]Collation:
+		*v = nil
+		c.outs.collations = v
+		return driver.ErrRemoveArgument
+	case StreamLOBs:
+		c.outs.streamLOBs = true
+		return driver.ErrRemoveArgument
+	case ReuseRowBuffers:
+		c.outs.reuseRowBuffers = true
+		return driver.ErrRemoveArgument
 	case TVP:
 		return nil
 	default:
@@ -127,14 +263,29 @@ func (s *Stmt) makeParamExtra(val driver.Value) (res param, err error) {
 		res.ti.TypeId = typeBigVarChar
 		res.buffer = []byte(val)
 		res.ti.Size = len(res.buffer)
+		res.ti.Collation = s.c.sess.varcharCollation()
 	case VarCharMax:
 		res.ti.TypeId = typeBigVarChar
 		res.buffer = []byte(val)
 		res.ti.Size = 0 // currently zero forces varchar(max)
+		res.ti.Collation = s.c.sess.varcharCollation()
 	case NVarCharMax:
 		res.ti.TypeId = typeNVarChar
 		res.buffer = str2ucs2(string(val))
 		res.ti.Size = 0 // currently zero forces nvarchar(max)
+	case Text:
+		res.ti.TypeId = typeText
+		res.buffer = []byte(val)
+		res.ti.Size = 0x7fffffff
+		res.ti.Collation = s.c.sess.varcharCollation()
+	case NText:
+		res.ti.TypeId = typeNText
+		res.buffer = str2ucs2(string(val))
+		res.ti.Size = 0x7ffffffe
+	case Image:
+		res.ti.TypeId = typeImage
+		res.buffer = val
+		res.ti.Size = 0x7fffffff
 	case DateTime1:
 		t := time.Time(val)
 		res.ti.TypeId = typeDateTimeN
@@ -145,6 +296,38 @@ func (s *Stmt) makeParamExtra(val driver.Value) (res param, err error) {
 		res.ti.Scale = 7
 		res.buffer = encodeDateTimeOffset(time.Time(val), int(res.ti.Scale))
 		res.ti.Size = len(res.buffer)
+	case NullDateTimeOffset:
+		res.ti.TypeId = typeDateTimeOffsetN
+		res.ti.Scale = 7
+		res.buffer = []byte{}
+		if val.Valid {
+			res.buffer = encodeDateTimeOffset(val.Time, int(res.ti.Scale))
+		}
+		res.ti.Size = len(res.buffer)
+	case DateOnly:
+		res.ti.TypeId = typeDateN
+		res.buffer = encodeDate(time.Time(val))
+		res.ti.Size = len(res.buffer)
+	case SmallDateTime:
+		res.ti.TypeId = typeDateTimeN
+		res.buffer = encodeDateTim4(time.Time(val))
+		res.ti.Size = len(res.buffer)
+	case DateTime2:
+		if val.Scale < 0 || val.Scale > 7 {
+			return res, fmt.Errorf("mssql: invalid datetime2 scale %d, expected 0 to 7", val.Scale)
+		}
+		res.ti.TypeId = typeDateTime2N
+		res.ti.Scale = uint8(val.Scale)
+		res.buffer = encodeDateTime2(val.Value, val.Scale)
+		res.ti.Size = len(res.buffer)
+	case Time:
+		if val.Scale < 0 || val.Scale > 7 {
+			return res, fmt.Errorf("mssql: invalid time scale %d, expected 0 to 7", val.Scale)
+		}
+		res.ti.TypeId = typeTimeN
+		res.ti.Scale = uint8(val.Scale)
+		res.buffer = encodeTime(val.Value.Hour(), val.Value.Minute(), val.Value.Second(), val.Value.Nanosecond(), val.Scale)
+		res.ti.Size = len(res.buffer)
 	case civil.Date:
 		res.ti.TypeId = typeDateN
 		res.buffer = encodeDate(val.In(time.UTC))
@@ -159,9 +342,46 @@ func (s *Stmt) makeParamExtra(val driver.Value) (res param, err error) {
 		res.ti.Scale = 7
 		res.buffer = encodeTime(val.Hour, val.Minute, val.Second, val.Nanosecond, int(res.ti.Scale))
 		res.ti.Size = len(res.buffer)
+	case Decimal:
+		res = val.param()
+	case NullDecimal:
+		res = val.param()
+	case Money:
+		res = val.param()
+	case SmallMoney:
+		res = val.param()
+	case UniqueIdentifier:
+		res = val.param()
+	case NullUniqueIdentifier:
+		// only null values get here, the type of output parameters
+		res.ti.TypeId = typeGuid
+		res.ti.Size = 16
+		res.buffer = []byte{}
+	case RowVersion:
+		res = val.param()
+	case XML:
+		res = val.param()
+	case Geometry:
+		return val.param()
+	case Geography:
+		return val.param()
+	case HierarchyID:
+		return val.param()
+	case LOBParam:
+		if val.Text {
+			res.ti.TypeId = typeNVarChar
+		} else {
+			res.ti.TypeId = typeBigVarBin
+		}
+		res.ti.Size = 0 // streamed as varbinary(max) or nvarchar(max)
+		if val.R != nil {
+			res.stream = &val
+		}
 	case sql.Out:
 		res, err = s.makeParam(val.Dest)
 		res.Flags = fByRevValue
+	case OutParam:
+		return val.param(s)
 	case TVP:
 		err = val.check()
 		if err != nil {
@@ -174,7 +394,7 @@ func (s *Stmt) makeParamExtra(val driver.Value) (res param, err error) {
 		res.ti.UdtInfo.TypeName = name
 		res.ti.UdtInfo.SchemaName = schema
 		res.ti.TypeId = typeTvp
-		columnStr, tvpFieldIndexes, errCalTypes := val.columnTypes()
+		columnStr, tvpFieldIndexes, errCalTypes := val.columnTypes(s.c.tvpColumns[val.TypeName])
 		if errCalTypes != nil {
 			err = errCalTypes
 			return
@@ -186,6 +406,9 @@ func (s *Stmt) makeParamExtra(val driver.Value) (res param, err error) {
 		res.ti.Size = len(res.buffer)
 
 	default:
+		if udt := lookupUDTEncoder(val); udt != nil {
+			return udt.param(val)
+		}
 		err = fmt.Errorf("mssql: unknown type for %T", val)
 	}
 	return
@@ -196,6 +419,9 @@ func scanIntoOut(name string, fromServer, scanInto interface{}) error {
 }
 
 func isOutputValue(val driver.Value) bool {
-	_, out := val.(sql.Out)
-	return out
+	switch val.(type) {
+	case sql.Out, OutParam:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,75 @@
+// +build go1.9
+
+package mssql
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestScaledDateTimeParams(t *testing.T) {
+	tm := time.Date(2021, 2, 3, 4, 5, 6, 123456789, time.UTC)
+	s := &Stmt{}
+	tests := []struct {
+		val    interface{}
+		decl   string
+		buffer []byte
+	}{
+		// 14706.123 seconds in milliseconds, then the days since 0001-01-01
+		{DateTime2{tm, 3}, "datetime2(3)", []byte{0xcb, 0x65, 0xe0, 0x00, 0x1f, 0x42, 0x0b}},
+		// 14706 seconds
+		{DateTime2{tm, 0}, "datetime2(0)", []byte{0x72, 0x39, 0x00, 0x1f, 0x42, 0x0b}},
+		{Time{tm, 0}, "time(0)", []byte{0x72, 0x39, 0x00}},
+		// 147061234567 hundreds of nanoseconds
+		{Time{tm, 7}, "time(7)", []byte{0x87, 0x5b, 0x88, 0x3d, 0x22}},
+	}
+	for _, tt := range tests {
+		p, err := s.makeParamExtra(tt.val)
+		if err != nil {
+			t.Errorf("%v: %v", tt.val, err)
+			continue
+		}
+		if decl := makeDecl(p.ti); decl != tt.decl {
+			t.Errorf("%v: expected declaration %s, got %s", tt.val, tt.decl, decl)
+		}
+		if !bytes.Equal(p.buffer, tt.buffer) || p.ti.Size != len(tt.buffer) {
+			t.Errorf("%v: expected % x, got % x", tt.val, tt.buffer, p.buffer)
+		}
+	}
+	if _, err := s.makeParamExtra(Time{tm, 8}); err == nil {
+		t.Error("expected an error for scale 8")
+	}
+}
+
+func TestDateOnlyAndSmallDateTimeParams(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	s := &Stmt{}
+	tests := []struct {
+		val    interface{}
+		decl   string
+		buffer []byte
+	}{
+		// the date of the value in its location
+		{DateOnly(time.Date(2021, 2, 3, 23, 0, 0, 0, loc)), "date", []byte{0x1f, 0x42, 0x0b}},
+		// 44228 days since 1900-01-01 and 245 minutes
+		{SmallDateTime(time.Date(2021, 2, 3, 4, 5, 6, 0, loc)), "smalldatetime", []byte{0xc4, 0xac, 0xf5, 0x00}},
+		// rounded to the next minute
+		{SmallDateTime(time.Date(2021, 2, 3, 4, 5, 29, 999000000, loc)), "smalldatetime", []byte{0xc4, 0xac, 0xf6, 0x00}},
+		{SmallDateTime(time.Date(1899, 12, 31, 0, 0, 0, 0, loc)), "smalldatetime", []byte{0, 0, 0, 0}},
+		{SmallDateTime(time.Date(2080, 1, 1, 0, 0, 0, 0, loc)), "smalldatetime", []byte{0xff, 0xff, 0x9f, 0x05}},
+	}
+	for _, tt := range tests {
+		p, err := s.makeParamExtra(tt.val)
+		if err != nil {
+			t.Errorf("%v: %v", tt.val, err)
+			continue
+		}
+		if decl := makeDecl(p.ti); decl != tt.decl {
+			t.Errorf("%v: expected declaration %s, got %s", tt.val, tt.decl, decl)
+		}
+		if !bytes.Equal(p.buffer, tt.buffer) || p.ti.Size != len(tt.buffer) {
+			t.Errorf("%v: expected % x, got % x", tt.val, tt.buffer, p.buffer)
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package mssql
 
 import (
+	"context"
 	"database/sql/driver"
 	"fmt"
 )
@@ -18,3 +19,7 @@ func scanIntoOut(name string, fromServer, scanInto interface{}) error {
 func isOutputValue(val driver.Value) bool {
 	return false
 }
+
+func (s *Stmt) lookupTVPColumns(ctx context.Context, args []namedValue) error {
+	return nil
+}
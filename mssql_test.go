@@ -3,7 +3,12 @@ package mssql
 import (
 	"context"
 	"database/sql"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
 )
 
 func TestBadOpen(t *testing.T) {
@@ -69,3 +74,123 @@ func TestConvertIsolationLevel(t *testing.T) {
 		t.Fatal("must fail but it didn't")
 	}
 }
+
+func TestNewConnectorConfigDefaults(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	db := sql.OpenDB(NewConnectorConfig(msdsn.Config{
+		Host:        "127.0.0.1",
+		Port:        uint64(srv.Addr().Port),
+		User:        "app",
+		Password:    "p;w=d",
+		Encryption:  msdsn.EncryptionDisabled,
+		DialTimeout: 5 * time.Second,
+	}))
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	logins := srv.Logins()
+	if len(logins) != 1 || logins[0].User != "app" || logins[0].Password != "p;w=d" || logins[0].AppName != "go-mssqldb" {
+		t.Errorf("unexpected logins %+v", logins)
+	}
+}
+
+// tunnelDialer connects every address to a local server, like a tunnel
+// into another network would.
+type tunnelDialer struct {
+	target string
+	addrs  []string
+}
+
+func (d *tunnelDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.addrs = append(d.addrs, addr)
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, d.target)
+}
+
+func (d *tunnelDialer) HostName() string {
+	return ""
+}
+
+func TestHostDialer(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	d := &tunnelDialer{target: srv.Addr().String()}
+	connector := NewConnectorConfig(msdsn.Config{
+		Host:       "sql.internal.invalid",
+		Encryption: msdsn.EncryptionDisabled,
+	})
+	connector.Dialer = d
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.addrs) != 1 || d.addrs[0] != "sql.internal.invalid:1433" {
+		t.Errorf("expected the unresolved host to be dialed, got %v", d.addrs)
+	}
+}
+
+// pipeDialer connects named pipes to a local TCP server.
+type pipeDialer struct {
+	target        string
+	network, addr string
+}
+
+func (d *pipeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.network, d.addr = network, addr
+	var nd net.Dialer
+	return nd.DialContext(ctx, "tcp", d.target)
+}
+
+func TestNamedPipeDialer(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	d := &pipeDialer{target: srv.Addr().String()}
+	connector, err := NewConnector(`server=np:\\db\pipe\MSSQL$INST\sql\query;encrypt=disable`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector.Dialer = d
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if d.network != "np" || d.addr != `\\db\pipe\MSSQL$INST\sql\query` {
+		t.Errorf("expected the pipe to be dialed, got %s %s", d.network, d.addr)
+	}
+}
+
+func TestResetConnection(t *testing.T) {
+	for _, tc := range []struct {
+		dsn   string
+		reset bool
+	}{
+		{"", true},
+		{"&resetconnection=false", false},
+	} {
+		srv := mssqltest.NewServer(nil)
+		db, err := sql.Open("sqlserver", srv.DSN()+tc.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxOpenConns(1)
+		for i := 0; i < 2; i++ {
+			if _, err = db.Exec("select 1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.Close()
+		srv.Close()
+		reqs := srv.Requests()
+		if len(reqs) != 2 {
+			t.Fatalf("%q: expected 2 requests, got %d", tc.dsn, len(reqs))
+		}
+		// the first request is on a new session
+		if reqs[1].Reset != tc.reset {
+			t.Errorf("%q: expected reset %v after the checkout, got %v", tc.dsn, tc.reset, reqs[1].Reset)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package mssqltest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Faults describes the failures a FaultDialer injects into each connection.
+//
+// The connection stream is split into units: TDS packets while the
+// conversation is in clear text and TLS records once it is encrypted.
+// Units are counted per direction starting at 1.
+type Faults struct {
+	// DropAfter closes the connection once this many units were received
+	// from the server. Zero disables it.
+	DropAfter int
+
+	// Delay holds back client packets of the given TDS packet types, such as
+	// 6 for attention or 3 for RPC requests, before they are sent.
+	Delay map[byte]time.Duration
+
+	// TruncateTLSRecord delivers only the first half of the n-th TLS record
+	// received from the server and then closes the connection. Zero disables it.
+	TruncateTLSRecord int
+
+	// CorruptToken replaces the leading token of server packets that start
+	// with this token with an invalid one. Zero disables it.
+	CorruptToken byte
+
+	// ServerUnit, if set, is called with every unit received from the server
+	// and may return a modified unit. Returning nil closes the connection.
+	ServerUnit func(n int, unit []byte) []byte
+}
+
+// FaultDialer dials connections that misbehave as described by Faults.
+// It implements the driver's Dialer interface and can be set as the
+// Dialer of a connector.
+type FaultDialer struct {
+	// Dialer makes the underlying connections, a net.Dialer is used if nil.
+	Dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	}
+	Faults Faults
+}
+
+// DialContext dials addr and wraps TCP connections.
+func (d *FaultDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	} = &net.Dialer{}
+	if d.Dialer != nil {
+		dialer = d.Dialer
+	}
+	c, err := dialer.DialContext(ctx, network, addr)
+	if err != nil || (network != "tcp" && network != "tcp4" && network != "tcp6") {
+		return c, err
+	}
+	return &faultConn{Conn: c, faults: d.Faults}, nil
+}
+
+var errFaultInjected = errors.New("mssqltest: connection dropped by fault injection")
+
+type faultConn struct {
+	net.Conn
+	faults Faults
+
+	rmu     sync.Mutex
+	rbuf    []byte
+	rerr    error
+	rcount  int
+	tlsSeen int
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// unitLen returns the length of the unit at the start of b, or 0 if the
+// header is incomplete.
+func unitLen(b []byte) (n int, tlsRecord bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	if b[0] >= 0x14 && b[0] <= 0x17 {
+		if len(b) < 5 {
+			return 0, true
+		}
+		return 5 + int(binary.BigEndian.Uint16(b[3:])), true
+	}
+	if len(b) < headerSize {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint16(b[2:])), false
+}
+
+// readUnit reads the next complete unit from the server.
+func (c *faultConn) readUnit() ([]byte, bool, error) {
+	hdr := make([]byte, 1, headerSize)
+	if _, err := io.ReadFull(c.Conn, hdr); err != nil {
+		return nil, false, err
+	}
+	size := headerSize
+	if hdr[0] >= 0x14 && hdr[0] <= 0x17 {
+		size = 5
+	}
+	hdr = hdr[:size]
+	if _, err := io.ReadFull(c.Conn, hdr[1:]); err != nil {
+		return nil, false, err
+	}
+	n, tlsRecord := unitLen(hdr)
+	if n < size {
+		return nil, false, errors.New("mssqltest: invalid unit length")
+	}
+	unit := make([]byte, n)
+	copy(unit, hdr)
+	if _, err := io.ReadFull(c.Conn, unit[size:]); err != nil {
+		return nil, false, err
+	}
+	return unit, tlsRecord, nil
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.rbuf) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		unit, tlsRecord, err := c.readUnit()
+		if err != nil {
+			c.rerr = err
+			continue
+		}
+		c.rcount++
+		f := &c.faults
+		if tlsRecord {
+			c.tlsSeen++
+			if f.TruncateTLSRecord != 0 && c.tlsSeen == f.TruncateTLSRecord {
+				unit = unit[:len(unit)/2]
+				c.rerr = errFaultInjected
+				c.Conn.Close()
+			}
+		} else if f.CorruptToken != 0 && len(unit) > headerSize && unit[headerSize] == f.CorruptToken {
+			unit[headerSize] = 0x00
+		}
+		if f.ServerUnit != nil && c.rerr == nil {
+			if unit = f.ServerUnit(c.rcount, unit); unit == nil {
+				c.rerr = errFaultInjected
+				c.Conn.Close()
+				continue
+			}
+		}
+		if f.DropAfter != 0 && c.rcount > f.DropAfter {
+			c.rerr = errFaultInjected
+			c.Conn.Close()
+			continue
+		}
+		c.rbuf = unit
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if len(c.faults.Delay) == 0 {
+		return c.Conn.Write(p)
+	}
+	c.wbuf = append(c.wbuf, p...)
+	for {
+		n, tlsRecord := unitLen(c.wbuf)
+		if n == 0 || n > len(c.wbuf) {
+			return len(p), nil
+		}
+		if !tlsRecord {
+			if d, ok := c.faults.Delay[c.wbuf[0]]; ok {
+				time.Sleep(d)
+			}
+		}
+		if _, err := c.Conn.Write(c.wbuf[:n]); err != nil {
+			return 0, err
+		}
+		c.wbuf = c.wbuf[n:]
+	}
+}
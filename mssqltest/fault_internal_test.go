@@ -0,0 +1,27 @@
+package mssqltest
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestFaultTruncateTLSRecord(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		// a TDS packet followed by two TLS application data records
+		server.Write([]byte{packReply, 1, 0, 9, 0, 0, 1, 0, 0xfd})
+		server.Write([]byte{0x17, 3, 3, 0, 4, 1, 2, 3, 4})
+		server.Write([]byte{0x17, 3, 3, 0, 4, 5, 6, 7, 8})
+	}()
+	c := &faultConn{Conn: client, faults: Faults{TruncateTLSRecord: 2}}
+	got, err := ioutil.ReadAll(c)
+	if err != errFaultInjected {
+		t.Fatalf("expected injected fault, got %v", err)
+	}
+	// 9 bytes of TDS, 9 of the first record and half of the second one
+	if len(got) != 9+9+4 {
+		t.Errorf("got %d bytes: %v", len(got), got)
+	}
+}
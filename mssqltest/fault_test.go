@@ -0,0 +1,96 @@
+package mssqltest_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func openFaulty(t *testing.T, srv *mssqltest.Server, faults mssqltest.Faults) *sql.DB {
+	connector, err := mssql.NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector.Dialer = &mssqltest.FaultDialer{Faults: faults}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func selectOne(req *mssqltest.Request) []mssqltest.Response {
+	return []mssqltest.Response{mssqltest.ResultSet{
+		Columns: []mssqltest.Column{{Name: "n", Type: mssqltest.Int}},
+		Rows:    [][]interface{}{{1}},
+	}}
+}
+
+func TestFaultDropAfter(t *testing.T) {
+	srv := mssqltest.NewServer(selectOne)
+	defer srv.Close()
+	// prelogin and login replies are let through, the first query is not
+	db := openFaulty(t, srv, mssqltest.Faults{DropAfter: 2})
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow("select 1").Scan(&n); err == nil {
+		t.Fatal("expected the query to fail")
+	}
+}
+
+func TestFaultCorruptToken(t *testing.T) {
+	srv := mssqltest.NewServer(selectOne)
+	defer srv.Close()
+	db := openFaulty(t, srv, mssqltest.Faults{CorruptToken: 0x81})
+	defer db.Close()
+
+	var n int
+	err := db.QueryRow("select 1").Scan(&n)
+	if err == nil || !strings.Contains(err.Error(), "unknown token") {
+		t.Fatalf("expected an unknown token error, got %v", err)
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	srv := mssqltest.NewServer(selectOne)
+	defer srv.Close()
+	db := openFaulty(t, srv, mssqltest.Faults{Delay: map[byte]time.Duration{1: 200 * time.Millisecond}})
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	var n int
+	if err := db.QueryRow("select 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("SQL batch was not delayed, took %v", d)
+	}
+}
+
+func TestFaultServerUnit(t *testing.T) {
+	srv := mssqltest.NewServer(selectOne)
+	defer srv.Close()
+	seen := 0
+	db := openFaulty(t, srv, mssqltest.Faults{ServerUnit: func(n int, unit []byte) []byte {
+		seen = n
+		if n == 3 {
+			return nil
+		}
+		return unit
+	}})
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow("select 1").Scan(&n); err == nil {
+		t.Fatal("expected the query to fail")
+	}
+	if seen != 3 {
+		t.Errorf("expected 3 units, saw %d", seen)
+	}
+}
@@ -0,0 +1,349 @@
+package mssqltest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// other packet types that carry credentials
+const (
+	packFedAuthToken          = 8
+	featExtSESSIONRECOVERY    = 0x01
+	featExtFEDAUTH            = 0x02
+	featExtCOLUMNENCRYPTION   = 0x04
+	featExtAZURESQLSUPPORT    = 0x08
+	featExtDATACLASSIFICATION = 0x09
+	featExtUTF8SUPPORT        = 0x0a
+	featExtTERM               = 0xff
+)
+
+// RecordedMessage is a TDS message captured by a Recorder.
+type RecordedMessage struct {
+	FromClient bool   `json:"from_client"`
+	Type       byte   `json:"type"`
+	Status     byte   `json:"status"`
+	Data       []byte `json:"data"`
+}
+
+// Recording is the conversation of a single client connection.
+type Recording struct {
+	Messages []RecordedMessage `json:"messages"`
+}
+
+// Save writes the recording to w as JSON.
+func (rec *Recording) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(rec)
+}
+
+// LoadRecording reads a recording written by Save.
+func LoadRecording(r io.Reader) (*Recording, error) {
+	rec := &Recording{}
+	if err := json.NewDecoder(r).Decode(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Recorder is a proxy that records the TDS conversations between clients
+// and a real server. Clients connect to Addr instead of the server.
+//
+// The proxy turns off encryption during prelogin so it can read the
+// traffic, servers that force encryption cannot be recorded. Passwords,
+// federated authentication tokens and SSPI messages are blanked out in
+// the recordings, but still forwarded to the server unchanged.
+type Recorder struct {
+	target   string
+	listener net.Listener
+
+	mu         sync.Mutex
+	recordings []*Recording
+	err        error
+	conns      map[net.Conn]struct{}
+	wg         sync.WaitGroup
+}
+
+// NewRecorder starts a proxy on a random local port that forwards
+// connections to the server at target, given as "host:port".
+func NewRecorder(target string) (*Recorder, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{target: target, listener: l, conns: map[net.Conn]struct{}{}}
+	r.wg.Add(1)
+	go r.serve()
+	return r, nil
+}
+
+// Addr returns the address the proxy listens on.
+func (r *Recorder) Addr() *net.TCPAddr {
+	return r.listener.Addr().(*net.TCPAddr)
+}
+
+// Recordings returns one recording per proxied connection, in the order
+// the connections were accepted. Recordings of open connections may
+// still grow.
+func (r *Recorder) Recordings() []*Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Recording(nil), r.recordings...)
+}
+
+// Err returns the first error that caused a proxied connection to be
+// dropped, for example a server insisting on encryption.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close stops the proxy and closes all connections.
+func (r *Recorder) Close() {
+	r.listener.Close()
+	r.mu.Lock()
+	for c := range r.conns {
+		c.Close()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+func (r *Recorder) setErr(err error) {
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mu.Unlock()
+}
+
+func (r *Recorder) serve() {
+	defer r.wg.Done()
+	for {
+		client, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", r.target)
+		if err != nil {
+			r.setErr(err)
+			client.Close()
+			continue
+		}
+		rec := &Recording{}
+		r.mu.Lock()
+		r.recordings = append(r.recordings, rec)
+		r.conns[client] = struct{}{}
+		r.conns[server] = struct{}{}
+		r.mu.Unlock()
+		r.wg.Add(2)
+		go r.pipe(rec, client, server, true)
+		go r.pipe(rec, server, client, false)
+	}
+}
+
+// pipe forwards packets from src to dst until either side fails.
+func (r *Recorder) pipe(rec *Recording, src, dst net.Conn, fromClient bool) {
+	defer r.wg.Done()
+	defer func() {
+		src.Close()
+		dst.Close()
+		r.mu.Lock()
+		delete(r.conns, src)
+		delete(r.conns, dst)
+		r.mu.Unlock()
+	}()
+	hdr := make([]byte, headerSize)
+	var msg []byte
+	for {
+		if _, err := io.ReadFull(src, hdr); err != nil {
+			return
+		}
+		size := int(binary.BigEndian.Uint16(hdr[2:]))
+		if size < headerSize {
+			r.setErr(fmt.Errorf("mssqltest: invalid packet size %d", size))
+			return
+		}
+		packet := make([]byte, size)
+		copy(packet, hdr)
+		if _, err := io.ReadFull(src, packet[headerSize:]); err != nil {
+			return
+		}
+		if packet[0] == packPrelogin {
+			if err := disableEncryption(packet[headerSize:], fromClient); err != nil {
+				r.setErr(err)
+				return
+			}
+		}
+		if _, err := dst.Write(packet); err != nil {
+			return
+		}
+		msg = append(msg, packet[headerSize:]...)
+		if packet[1]&1 == 0 {
+			continue
+		}
+		m := RecordedMessage{FromClient: fromClient, Type: packet[0], Status: packet[1], Data: msg}
+		if fromClient {
+			redact(&m)
+		}
+		r.mu.Lock()
+		rec.Messages = append(rec.Messages, m)
+		r.mu.Unlock()
+		msg = nil
+	}
+}
+
+// disableEncryption asks for no encryption in a client prelogin request
+// and checks that the server agreed in its response.
+func disableEncryption(data []byte, request bool) error {
+	for i := 0; i+5 <= len(data) && data[i] != preloginTERMINATOR; i += 5 {
+		if data[i] != preloginENCRYPTION {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint16(data[i+1:]))
+		if offset >= len(data) {
+			return errors.New("mssqltest: invalid prelogin packet")
+		}
+		if request {
+			data[offset] = encryptNotSup
+		} else if data[offset] != encryptNotSup {
+			return errors.New("mssqltest: server requires encryption, the conversation cannot be recorded")
+		}
+	}
+	return nil
+}
+
+// redact blanks out credentials in a client message.
+func redact(m *RecordedMessage) {
+	switch m.Type {
+	case packSSPIMessage, packFedAuthToken:
+		m.Data = make([]byte, len(m.Data))
+	case packLogin7:
+		data := m.Data
+		if len(data) < 94 {
+			return
+		}
+		blank := func(pos int) {
+			offset := int(binary.LittleEndian.Uint16(data[pos:]))
+			length := int(binary.LittleEndian.Uint16(data[pos+2:])) * 2
+			if pos == 78 {
+				// SSPI length is in bytes
+				length /= 2
+			}
+			for i := offset; i < offset+length && i < len(data); i++ {
+				data[i] = 0
+			}
+		}
+		blank(44) // password
+		blank(78) // SSPI
+		blank(86) // new password
+		if data[27]&0x10 == 0 {
+			return
+		}
+		// feature extensions, the federated authentication token is in FEDAUTH
+		extOffset := int(binary.LittleEndian.Uint16(data[56:]))
+		if extOffset+4 > len(data) {
+			return
+		}
+		pos := int(binary.LittleEndian.Uint32(data[extOffset:]))
+		for pos < len(data) && data[pos] != featExtTERM && pos+5 <= len(data) {
+			id := data[pos]
+			length := int(binary.LittleEndian.Uint32(data[pos+1:]))
+			pos += 5
+			if id == featExtFEDAUTH {
+				for i := pos; i < pos+length && i < len(data); i++ {
+					data[i] = 0
+				}
+			}
+			pos += length
+		}
+	}
+}
+
+// NewReplayServer starts a server that replays recordings. Each accepted
+// connection replays the next recording, in order.
+//
+// Client messages are checked against the recording. Prelogin, login and
+// authentication messages are expected to differ and only their types
+// are compared; any other difference is answered with an error and the
+// connection is closed. Requests are recorded and available through
+// Requests as with any other Server.
+func NewReplayServer(recordings ...*Recording) *Server {
+	s := NewUnstartedServer(nil)
+	s.replaying = true
+	s.replay = recordings
+	s.Start()
+	return s
+}
+
+func (s *Server) nextRecording() *Recording {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.replay) == 0 {
+		return nil
+	}
+	rec := s.replay[0]
+	s.replay = s.replay[1:]
+	return rec
+}
+
+func (c *serverConn) replay(rec *Recording) {
+	msgs := rec.Messages
+	for n := 0; ; n++ {
+		m, ok := c.next()
+		if !ok {
+			return
+		}
+		if len(msgs) == 0 || !msgs[0].FromClient {
+			c.replayMismatch(n, "unexpected message")
+			return
+		}
+		want := msgs[0]
+		msgs = msgs[1:]
+		if m.typ != want.Type {
+			c.replayMismatch(n, fmt.Sprintf("got packet type %d, recorded %d", m.typ, want.Type))
+			return
+		}
+		switch m.typ {
+		case packPrelogin, packSSPIMessage, packFedAuthToken:
+		case packLogin7:
+			if login, err := parseLogin(m.data); err == nil {
+				c.srv.mu.Lock()
+				c.srv.logins = append(c.srv.logins, login)
+				c.srv.mu.Unlock()
+				if login.PacketSize >= 512 {
+					c.packetSize = login.PacketSize
+				}
+			}
+		default:
+			if string(m.data) != string(want.Data) {
+				c.replayMismatch(n, "request differs from the recording")
+				return
+			}
+			if req, err := parseRequest(m, c.enclave); err == nil {
+				c.srv.mu.Lock()
+				c.srv.requests = append(c.srv.requests, req)
+				c.srv.mu.Unlock()
+			}
+		}
+		for len(msgs) > 0 && !msgs[0].FromClient {
+			if writeMessage(c.conn, msgs[0].Type, c.spid, msgs[0].Data, c.packetSize) != nil {
+				return
+			}
+			msgs = msgs[1:]
+		}
+	}
+}
+
+func (c *serverConn) replayMismatch(n int, reason string) {
+	var w tokenWriter
+	Error{Number: 50000, Class: 20, Message: fmt.Sprintf("mssqltest: replay mismatch at message %d: %s", n, reason)}.writeToken(&w, tokenError)
+	w.done(tokenDone, doneError, 0)
+	c.reply(w.Bytes())
+}
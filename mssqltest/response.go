@@ -0,0 +1,853 @@
+package mssqltest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Type is the SQL Server data type of a result set column or output parameter.
+type Type int
+
+const (
+	// Int is a 32-bit integer column, values may be any Go integer type.
+	Int Type = iota + 1
+	// BigInt is a 64-bit integer column, values may be any Go integer type.
+	BigInt
+	// Bit is a bit column, values must be bool.
+	Bit
+	// Float is a float(53) column, values may be float32 or float64.
+	Float
+	// NVarChar is an nvarchar(max) column, values must be string.
+	NVarChar
+	// VarBinary is a varbinary(max) column, values must be []byte.
+	VarBinary
+	// DateTime2 is a datetime2(7) column, values must be time.Time.
+	DateTime2
+	// DateTimeOffset is a datetimeoffset(7) column, values must be time.Time.
+	DateTimeOffset
+	// UniqueIdentifier is a uniqueidentifier column, values must be
+	// a []byte or [16]byte in the wire byte order.
+	UniqueIdentifier
+	// Decimal is a decimal(38, 4) column, values must be strings such as
+	// -123.4500 with up to 4 digits after the point.
+	Decimal
+	// Money is a money column, values must be strings such as -123.4500
+	// with up to 4 digits after the point.
+	Money
+	// XML is an xml column, values must be string.
+	XML
+	// UDT is a column of the CLR user-defined type of Column.UDTType,
+	// values must be []byte.
+	UDT
+	// VarChar is a varchar(max) column, values must be string. Its
+	// collation is SQL_Latin1_General_CP1_CI_AS, the values then must be
+	// ASCII, or Latin1_General_100_CI_AS_SC_UTF8 for the clients that
+	// support UTF-8 when Server.UTF8 is set.
+	VarChar
+	// Text, NText and Image are text, ntext and image columns of the table
+	// dbo.t, values must be string for Text and NText, with the collation
+	// of VarChar for Text, and []byte for Image.
+	Text
+	NText
+	Image
+)
+
+var (
+	collationLatin1 = [5]byte{0x09, 0x04, 0xd0, 0x00, 0x34}
+	collationUTF8   = [5]byte{0x09, 0x04, 0xd0, 0x24, 0x00}
+)
+
+// Column describes a result set column.
+type Column struct {
+	Name string
+	Type Type
+	// Flags are the COLMETADATA flags of the column, such as 0x0010 for an
+	// identity column. Zero sends a nullable column.
+	Flags uint16
+	// Sensitivity is the data classification of the column, sent to
+	// clients that negotiated it with a Server that has
+	// DataClassification set.
+	Sensitivity []Sensitivity
+	// Browse is the browse mode metadata of the column. The TABNAME and
+	// COLINFO tokens are sent for result sets with browse mode columns.
+	Browse *Browse
+	// XMLSchemaCollection is the schema collection of an XML column, nil
+	// for untyped xml.
+	XMLSchemaCollection *XMLSchemaCollection
+	// UDTType is the user-defined type of a UDT column.
+	UDTType *UDTType
+	// Encryption, if set, makes the column an Always Encrypted column of
+	// the plaintext type Type, sent as varbinary(8000) to the clients that
+	// did not ask for column encryption. Values must be []byte
+	// ciphertexts.
+	Encryption *ColumnEncryption
+}
+
+// ColumnEncryption is the encryption of an Always Encrypted column.
+type ColumnEncryption struct {
+	// Key is the column encryption key, columns with the same key share
+	// its entry in the key table of the result set.
+	Key            *EncryptionKey
+	Algorithm      byte
+	EncryptionType byte
+	NormVersion    byte
+}
+
+// EncryptionKey is a column encryption key, with its values encrypted by
+// column master keys.
+type EncryptionKey struct {
+	DatabaseID   uint32
+	KeyID        uint32
+	KeyVersion   uint32
+	KeyMDVersion []byte
+	Values       []EncryptionKeyValue
+}
+
+// EncryptionKeyValue is a column encryption key encrypted by the column
+// master key at KeyPath of the key store KeyStoreName.
+type EncryptionKeyValue struct {
+	EncryptedKey []byte
+	KeyStoreName string
+	KeyPath      string
+	Algorithm    string
+}
+
+// UDTType is the CLR user-defined type of a column, such as sys.hierarchyid.
+type UDTType struct {
+	Database              string
+	Schema                string
+	Name                  string
+	AssemblyQualifiedName string
+}
+
+// XMLSchemaCollection is the schema collection of a typed xml column.
+type XMLSchemaCollection struct {
+	Database string
+	Schema   string
+	Name     string
+}
+
+// Browse is the base table of a column in browse mode.
+type Browse struct {
+	// Table is the name of the table, such as dbo.t, empty for an
+	// expression.
+	Table string
+	// Name is the name of the column in the table, when it differs.
+	Name   string
+	Key    bool
+	Hidden bool
+}
+
+// Sensitivity is a sensitivity classification of a column. The label or
+// information type is left out when its name is empty.
+type Sensitivity struct {
+	Label, LabelID                     string
+	InformationType, InformationTypeID string
+	Rank                               int32
+}
+
+// A Response is one element of the server's reply to a request.
+// Responses are sent in order; the final DONE token is added automatically.
+type Response interface {
+	write(w *tokenWriter) error
+}
+
+// ResultSet is a response that returns rows. A nil value in a row is sent as NULL.
+type ResultSet struct {
+	Columns []Column
+	Rows    [][]interface{}
+}
+
+func (rs ResultSet) write(w *tokenWriter) error {
+	w.byte(tokenColMetadata)
+	w.uint16(uint16(len(rs.Columns)))
+	var keys []*EncryptionKey
+	if w.columnEncryption {
+		keys = rs.writeKeyTable(w)
+	}
+	for _, col := range rs.Columns {
+		w.uint32(0) // user type
+		flags := col.Flags
+		if flags == 0 {
+			flags = 0x0001 // nullable
+		}
+		if col.Encryption != nil {
+			if w.columnEncryption {
+				flags |= 0x0800 // encrypted
+			}
+			w.uint16(flags)
+			w.byte(typeBigVarBin)
+			w.uint16(8000)
+			if w.columnEncryption {
+				if err := writeCryptoMetadata(w, col, keys); err != nil {
+					return err
+				}
+			}
+			w.bVarChar(col.Name)
+			continue
+		}
+		w.uint16(flags)
+		if col.Type == XML && col.XMLSchemaCollection != nil {
+			w.byte(typeXml)
+			w.byte(1)
+			w.bVarChar(col.XMLSchemaCollection.Database)
+			w.bVarChar(col.XMLSchemaCollection.Schema)
+			w.usVarChar(col.XMLSchemaCollection.Name)
+		} else if col.Type == UDT {
+			if col.UDTType == nil {
+				return fmt.Errorf("mssqltest: the UDT column %s has no UDTType", col.Name)
+			}
+			w.byte(typeUdt)
+			w.uint16(0xffff)
+			w.bVarChar(col.UDTType.Database)
+			w.bVarChar(col.UDTType.Schema)
+			w.bVarChar(col.UDTType.Name)
+			w.usVarChar(col.UDTType.AssemblyQualifiedName)
+		} else if err := writeTypeInfo(w, col.Type); err != nil {
+			return err
+		}
+		w.bVarChar(col.Name)
+	}
+	if w.dataClassification > 0 {
+		rs.writeDataClassification(w)
+	}
+	rs.writeBrowse(w)
+	for i, row := range rs.Rows {
+		if len(row) != len(rs.Columns) {
+			return fmt.Errorf("mssqltest: row %d has %d values, expected %d", i, len(row), len(rs.Columns))
+		}
+		w.byte(tokenRow)
+		for j, v := range row {
+			if rs.Columns[j].Encryption != nil {
+				if err := writeCiphertext(w, v); err != nil {
+					return fmt.Errorf("mssqltest: row %d column %q: %v", i, rs.Columns[j].Name, err)
+				}
+				continue
+			}
+			if err := writeValue(w, rs.Columns[j].Type, v); err != nil {
+				return fmt.Errorf("mssqltest: row %d column %q: %v", i, rs.Columns[j].Name, err)
+			}
+		}
+	}
+	w.done(tokenDone, doneMore|doneCount, uint64(len(rs.Rows)))
+	return nil
+}
+
+// writeKeyTable writes the column encryption key table of a result set and
+// returns its keys, in order.
+func (rs ResultSet) writeKeyTable(w *tokenWriter) []*EncryptionKey {
+	var keys []*EncryptionKey
+	for _, col := range rs.Columns {
+		if col.Encryption == nil || keyOrdinal(keys, col.Encryption.Key) >= 0 {
+			continue
+		}
+		keys = append(keys, col.Encryption.Key)
+	}
+	w.uint16(uint16(len(keys)))
+	for _, k := range keys {
+		w.uint32(k.DatabaseID)
+		w.uint32(k.KeyID)
+		w.uint32(k.KeyVersion)
+		md := make([]byte, 8)
+		copy(md, k.KeyMDVersion)
+		w.Write(md)
+		w.byte(byte(len(k.Values)))
+		for _, v := range k.Values {
+			w.uint16(uint16(len(v.EncryptedKey)))
+			w.Write(v.EncryptedKey)
+			w.bVarChar(v.KeyStoreName)
+			w.usVarChar(v.KeyPath)
+			w.bVarChar(v.Algorithm)
+		}
+	}
+	return keys
+}
+
+func keyOrdinal(keys []*EncryptionKey, key *EncryptionKey) int {
+	for i, k := range keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeCryptoMetadata writes the CryptoMetaData of an encrypted column:
+// the ordinal of its key, the type of its plaintext and its encryption.
+func writeCryptoMetadata(w *tokenWriter, col Column, keys []*EncryptionKey) error {
+	e := col.Encryption
+	w.uint16(uint16(keyOrdinal(keys, e.Key)))
+	w.uint32(0) // user type
+	if err := writeTypeInfo(w, col.Type); err != nil {
+		return err
+	}
+	w.byte(e.Algorithm)
+	w.byte(e.EncryptionType)
+	w.byte(e.NormVersion)
+	return nil
+}
+
+// writeCiphertext writes the value of an encrypted column.
+func writeCiphertext(w *tokenWriter, v interface{}) error {
+	if v == nil {
+		w.uint16(0xffff)
+		return nil
+	}
+	b, ok := v.([]byte)
+	if !ok || len(b) > 8000 {
+		return fmt.Errorf("expected a ciphertext of up to 8000 bytes, got %T", v)
+	}
+	w.uint16(uint16(len(b)))
+	w.Write(b)
+	return nil
+}
+
+// writeBrowse writes the TABNAME and COLINFO tokens of a result set with
+// browse mode columns.
+func (rs ResultSet) writeBrowse(w *tokenWriter) {
+	var tables []string
+	var info tokenWriter
+	for i, col := range rs.Columns {
+		b := col.Browse
+		if b == nil {
+			continue
+		}
+		var table int
+		for j, t := range tables {
+			if t == b.Table {
+				table = j + 1
+			}
+		}
+		if table == 0 && b.Table != "" {
+			tables = append(tables, b.Table)
+			table = len(tables)
+		}
+		var status byte
+		if table == 0 {
+			status |= 0x04 // expression
+		}
+		if b.Key {
+			status |= 0x08
+		}
+		if b.Hidden {
+			status |= 0x10
+		}
+		if b.Name != "" {
+			status |= 0x20 // different name
+		}
+		info.Write([]byte{byte(i + 1), byte(table), status})
+		if b.Name != "" {
+			info.bVarChar(b.Name)
+		}
+	}
+	if info.Len() == 0 {
+		return
+	}
+	var names tokenWriter
+	for _, t := range tables {
+		parts := strings.Split(t, ".")
+		names.byte(byte(len(parts)))
+		for _, p := range parts {
+			names.usVarChar(p)
+		}
+	}
+	w.byte(tokenTabName)
+	w.uint16(uint16(names.Len()))
+	w.Write(names.Bytes())
+	w.byte(tokenColInfo)
+	w.uint16(uint16(info.Len()))
+	w.Write(info.Bytes())
+}
+
+// writeDataClassification writes the DATACLASSIFICATION token of a result
+// set with classified columns.
+func (rs ResultSet) writeDataClassification(w *tokenWriter) {
+	classified := false
+	var labels, infoTypes [][2]string
+	index := func(list *[][2]string, name, id string) uint16 {
+		if name == "" {
+			return 0xffff
+		}
+		for i, e := range *list {
+			if e == [2]string{name, id} {
+				return uint16(i)
+			}
+		}
+		*list = append(*list, [2]string{name, id})
+		return uint16(len(*list) - 1)
+	}
+	type property struct {
+		label, infoType uint16
+		rank            int32
+	}
+	props := make([][]property, len(rs.Columns))
+	rank := int32(-1)
+	for i, col := range rs.Columns {
+		for _, s := range col.Sensitivity {
+			classified = true
+			props[i] = append(props[i], property{
+				label:    index(&labels, s.Label, s.LabelID),
+				infoType: index(&infoTypes, s.InformationType, s.InformationTypeID),
+				rank:     s.Rank,
+			})
+			if s.Rank > rank {
+				rank = s.Rank
+			}
+		}
+	}
+	if !classified {
+		return
+	}
+	w.byte(tokenDataClassification)
+	for _, list := range [][][2]string{labels, infoTypes} {
+		w.uint16(uint16(len(list)))
+		for _, e := range list {
+			w.usVarChar(e[0])
+			w.usVarChar(e[1])
+		}
+	}
+	if w.dataClassification >= 2 {
+		w.uint32(uint32(rank))
+	}
+	w.uint16(uint16(len(props)))
+	for _, col := range props {
+		w.uint16(uint16(len(col)))
+		for _, p := range col {
+			w.uint16(p.label)
+			w.uint16(p.infoType)
+			if w.dataClassification >= 2 {
+				w.uint32(uint32(p.rank))
+			}
+		}
+	}
+}
+
+// RowsAffected is a response that reports the number of rows changed
+// by a statement.
+type RowsAffected int64
+
+func (n RowsAffected) write(w *tokenWriter) error {
+	w.done(tokenDone, doneMore|doneCount, uint64(n))
+	return nil
+}
+
+// Error is a response that reports a server error. It may also be returned
+// from Server.Authenticate to reject a login with a specific error.
+type Error struct {
+	Number  int32
+	State   uint8
+	Class   uint8
+	Message string
+	// ProcName and LineNo are optional.
+	ProcName string
+	LineNo   int32
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+func (e Error) write(w *tokenWriter) error {
+	e.writeToken(w, tokenError)
+	w.done(tokenDone, doneMore|doneError, 0)
+	return nil
+}
+
+func (e Error) writeToken(w *tokenWriter, token byte) {
+	var body tokenWriter
+	body.uint32(uint32(e.Number))
+	body.byte(e.State)
+	body.byte(e.Class)
+	body.usVarChar(e.Message)
+	body.bVarChar("mssqltest")
+	body.bVarChar(e.ProcName)
+	body.uint32(uint32(e.LineNo))
+	w.byte(token)
+	w.uint16(uint16(body.Len()))
+	w.Write(body.Bytes())
+}
+
+// Message is a response that sends an informational message, such as
+// the output of PRINT.
+type Message struct {
+	Number  int32
+	Class   uint8
+	Message string
+	// State, ProcName and LineNo are optional.
+	State    uint8
+	ProcName string
+	LineNo   int32
+}
+
+func (m Message) write(w *tokenWriter) error {
+	Error{Number: m.Number, State: m.State, Class: m.Class, Message: m.Message, ProcName: m.ProcName, LineNo: m.LineNo}.writeToken(w, tokenInfo)
+	return nil
+}
+
+// ReturnStatus is a response that sets the return status of a procedure.
+type ReturnStatus int32
+
+func (rs ReturnStatus) write(w *tokenWriter) error {
+	w.byte(tokenReturnStatus)
+	w.uint32(uint32(rs))
+	return nil
+}
+
+// SessionState is a response that reports changed session states, by
+// state id, to a client that negotiated connection resiliency. A session
+// that is not Recoverable cannot be recovered by the client until a later
+// SessionState reports it recoverable again.
+type SessionState struct {
+	SeqNo       uint32
+	Recoverable bool
+	States      map[byte][]byte
+}
+
+func (ss SessionState) write(w *tokenWriter) error {
+	ids := make([]int, 0, len(ss.States))
+	length := 4 + 1
+	for id, value := range ss.States {
+		if len(value) >= 0xff {
+			return fmt.Errorf("mssqltest: session state %d is too long", id)
+		}
+		ids = append(ids, int(id))
+		length += 2 + len(value)
+	}
+	sort.Ints(ids)
+	w.byte(tokenSessionState)
+	w.uint32(uint32(length))
+	w.uint32(ss.SeqNo)
+	if ss.Recoverable {
+		w.byte(1)
+	} else {
+		w.byte(0)
+	}
+	for _, id := range ids {
+		value := ss.States[byte(id)]
+		w.byte(byte(id))
+		w.byte(byte(len(value)))
+		w.Write(value)
+	}
+	return nil
+}
+
+// OutputParam is a response that sets the value of an output parameter.
+// Name must include the leading "@".
+type OutputParam struct {
+	Name  string
+	Type  Type
+	Value interface{}
+}
+
+func (p OutputParam) write(w *tokenWriter) error {
+	w.byte(tokenReturnValue)
+	w.uint16(0) // ordinal
+	w.bVarChar(p.Name)
+	w.byte(1) // output parameter
+	w.uint32(0)
+	w.uint16(0x0001)
+	if err := writeTypeInfo(w, p.Type); err != nil {
+		return err
+	}
+	return writeValue(w, p.Type, p.Value)
+}
+
+// Delay is a response that pauses the reply. If the client cancels the
+// request while the reply is delayed, the rest of the reply is discarded
+// and the cancellation is acknowledged.
+type Delay time.Duration
+
+func (Delay) write(w *tokenWriter) error {
+	return nil
+}
+
+// Stall is a response that waits for the duration without acknowledging
+// attention signals, like a server busy in a part of a query that does not
+// check for cancellation. An attention signal received meanwhile is
+// acknowledged once the duration has passed.
+type Stall time.Duration
+
+func (Stall) write(w *tokenWriter) error {
+	return nil
+}
+
+// Disconnect is a response that closes the connection without replying.
+type Disconnect struct{}
+
+func (Disconnect) write(w *tokenWriter) error {
+	return nil
+}
+
+func writeTypeInfo(w *tokenWriter, t Type) error {
+	switch t {
+	case Int:
+		w.byte(typeIntN)
+		w.byte(4)
+	case BigInt:
+		w.byte(typeIntN)
+		w.byte(8)
+	case Bit:
+		w.byte(typeBitN)
+		w.byte(1)
+	case Float:
+		w.byte(typeFltN)
+		w.byte(8)
+	case NVarChar:
+		w.byte(typeNVarChar)
+		w.uint16(0xffff)
+		w.Write(collationLatin1[:])
+	case VarChar:
+		w.byte(typeBigVarChar)
+		w.uint16(0xffff)
+		if w.utf8 {
+			w.Write(collationUTF8[:])
+		} else {
+			w.Write(collationLatin1[:])
+		}
+	case VarBinary:
+		w.byte(typeBigVarBin)
+		w.uint16(0xffff)
+	case Text, NText, Image:
+		switch t {
+		case Text:
+			w.byte(typeText)
+			w.uint32(0x7fffffff)
+			if w.utf8 {
+				w.Write(collationUTF8[:])
+			} else {
+				w.Write(collationLatin1[:])
+			}
+		case NText:
+			w.byte(typeNText)
+			w.uint32(0x7ffffffe)
+			w.Write(collationLatin1[:])
+		default:
+			w.byte(typeImage)
+			w.uint32(0x7fffffff)
+		}
+		w.byte(2)
+		w.usVarChar("dbo")
+		w.usVarChar("t")
+	case XML:
+		w.byte(typeXml)
+		w.byte(0) // untyped
+	case DateTime2:
+		w.byte(typeDateTime2N)
+		w.byte(7)
+	case DateTimeOffset:
+		w.byte(typeDateTimeOffsetN)
+		w.byte(7)
+	case UniqueIdentifier:
+		w.byte(typeGuid)
+		w.byte(16)
+	case Decimal:
+		w.byte(typeDecimalN)
+		w.byte(17)
+		w.byte(38)
+		w.byte(4)
+	case Money:
+		w.byte(typeMoneyN)
+		w.byte(8)
+	default:
+		return fmt.Errorf("mssqltest: unknown column type %d", t)
+	}
+	return nil
+}
+
+// parseScaled parses the decimal number s as an integer of scale digits
+// after the point.
+func parseScaled(s string, scale int) (*big.Int, error) {
+	digits := strings.TrimPrefix(s, "-")
+	frac := ""
+	if point := strings.IndexByte(digits, '.'); point != -1 {
+		digits, frac = digits[:point], digits[point+1:]
+	}
+	if len(frac) > scale {
+		return nil, fmt.Errorf("%s has more than %d digits after the point", s, scale)
+	}
+	n, ok := new(big.Int).SetString(digits+frac+strings.Repeat("0", scale-len(frac)), 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("cannot parse %q as a decimal number", s)
+	}
+	if strings.HasPrefix(s, "-") {
+		n.Neg(n)
+	}
+	return n, nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func writeValue(w *tokenWriter, t Type, v interface{}) error {
+	plp := t == NVarChar || t == VarBinary || t == XML || t == UDT || t == VarChar
+	long := t == Text || t == NText || t == Image
+	if v == nil {
+		if plp {
+			w.uint64(math.MaxUint64)
+		} else {
+			w.byte(0)
+		}
+		return nil
+	}
+	var buf []byte
+	switch t {
+	case Int, BigInt:
+		n, ok := toInt64(v)
+		if !ok {
+			return fmt.Errorf("cannot send %T as an integer", v)
+		}
+		if t == Int {
+			buf = make([]byte, 4)
+			binary.LittleEndian.PutUint32(buf, uint32(int32(n)))
+		} else {
+			buf = make([]byte, 8)
+			binary.LittleEndian.PutUint64(buf, uint64(n))
+		}
+	case Bit:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("cannot send %T as bit", v)
+		}
+		buf = []byte{0}
+		if b {
+			buf[0] = 1
+		}
+	case Float:
+		var f float64
+		switch v := v.(type) {
+		case float64:
+			f = v
+		case float32:
+			f = float64(v)
+		default:
+			return fmt.Errorf("cannot send %T as float", v)
+		}
+		buf = make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(f))
+	case NVarChar, XML, NText:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("cannot send %T as nvarchar", v)
+		}
+		buf = str2ucs2(s)
+	case VarChar, Text:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("cannot send %T as varchar", v)
+		}
+		buf = []byte(s)
+	case VarBinary, UDT, Image:
+		b, ok := v.([]byte)
+		if !ok {
+			return fmt.Errorf("cannot send %T as varbinary", v)
+		}
+		buf = b
+	case DateTime2, DateTimeOffset:
+		tm, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("cannot send %T as a date", v)
+		}
+		if t == DateTimeOffset {
+			_, offset := tm.Zone()
+			tm = tm.UTC()
+			buf = append(encodeTime(tm), encodeDate(tm)...)
+			buf = append(buf, byte(offset/60), byte((offset/60)>>8))
+		} else {
+			buf = append(encodeTime(tm), encodeDate(tm)...)
+		}
+	case UniqueIdentifier:
+		switch v := v.(type) {
+		case []byte:
+			buf = v
+		case [16]byte:
+			buf = v[:]
+		default:
+			return fmt.Errorf("cannot send %T as uniqueidentifier", v)
+		}
+		if len(buf) != 16 {
+			return fmt.Errorf("uniqueidentifier must be 16 bytes long, got %d", len(buf))
+		}
+	case Decimal, Money:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("cannot send %T as a decimal", v)
+		}
+		n, err := parseScaled(s, 4)
+		if err != nil {
+			return err
+		}
+		if t == Money {
+			if !n.IsInt64() {
+				return fmt.Errorf("%s is out of the range of money", s)
+			}
+			m := n.Int64()
+			buf = make([]byte, 8)
+			binary.LittleEndian.PutUint32(buf, uint32(m>>32))
+			binary.LittleEndian.PutUint32(buf[4:], uint32(m))
+			break
+		}
+		mag := new(big.Int).Abs(n).Bytes()
+		if len(mag) > 16 {
+			return fmt.Errorf("%s is out of the range of decimal(38, 4)", s)
+		}
+		buf = make([]byte, 17)
+		if n.Sign() >= 0 {
+			buf[0] = 1
+		}
+		for i, j := 1, len(mag)-1; j >= 0; i, j = i+1, j-1 {
+			buf[i] = mag[j]
+		}
+	default:
+		return fmt.Errorf("unknown column type %d", t)
+	}
+	if plp {
+		// chunks of 8000 bytes, as sent by SQL Server
+		w.uint64(uint64(len(buf)))
+		for len(buf) > 0 {
+			n := len(buf)
+			if n > 8000 {
+				n = 8000
+			}
+			w.uint32(uint32(n))
+			w.Write(buf[:n])
+			buf = buf[n:]
+		}
+		w.uint32(0)
+		return nil
+	}
+	if long {
+		// textptr and timestamp
+		w.byte(16)
+		w.Write(make([]byte, 16+8))
+		w.uint32(uint32(len(buf)))
+		w.Write(buf)
+		return nil
+	}
+	w.byte(byte(len(buf)))
+	w.Write(buf)
+	return nil
+}
@@ -0,0 +1,1050 @@
+// Package mssqltest provides a scriptable in-process SQL Server for unit tests.
+//
+// The server speaks enough of the TDS protocol for the driver to log in,
+// send batches, RPC requests and transaction requests, and read back
+// result sets, row counts, errors, return statuses and output parameters.
+// Every request the server receives is recorded so tests can assert on the
+// SQL text and parameters that were sent.
+//
+//	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+//		return []mssqltest.Response{mssqltest.ResultSet{
+//			Columns: []mssqltest.Column{{Name: "n", Type: mssqltest.Int}},
+//			Rows:    [][]interface{}{{1}},
+//		}}
+//	})
+//	defer srv.Close()
+//	db, err := sql.Open("sqlserver", srv.DSN())
+//
+// The server does not support encryption, so it cannot be used with
+// connection strings that require it.
+//
+// Conversations with a real server can be captured with a Recorder and
+// played back later by a server created with NewReplayServer. Network
+// failures can be simulated by setting a FaultDialer as the connector's
+// Dialer.
+package mssqltest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestType identifies the kind of request sent by the client.
+type RequestType int
+
+const (
+	// SQLBatch is a plain batch of SQL text.
+	SQLBatch RequestType = iota + 1
+	// RPC is a remote procedure call, which is also how parameterized
+	// queries are sent.
+	RPC
+	// BeginTran, CommitTran and RollbackTran are transaction manager requests.
+	BeginTran
+	CommitTran
+	RollbackTran
+	// BulkLoad is the data of a bulk insert, sent after the INSERT BULK
+	// statement.
+	BulkLoad
+)
+
+func (t RequestType) String() string {
+	switch t {
+	case SQLBatch:
+		return "SQLBatch"
+	case RPC:
+		return "RPC"
+	case BeginTran:
+		return "BeginTran"
+	case CommitTran:
+		return "CommitTran"
+	case RollbackTran:
+		return "RollbackTran"
+	case BulkLoad:
+		return "BulkLoad"
+	}
+	return fmt.Sprintf("RequestType(%d)", int(t))
+}
+
+// Param is a parameter received in an RPC request.
+//
+// Values are decoded to int64, bool, float64, string, []byte or time.Time.
+// Decimal and money values are decoded to their string representation,
+// uniqueidentifier values to their 16 bytes in wire order and table-valued
+// parameters to TVP.
+type Param struct {
+	// Name includes the leading "@", it is empty for positional parameters.
+	Name   string
+	Output bool
+	// Value of an encrypted parameter is its ciphertext.
+	Value interface{}
+	// Collation is the 5 bytes collation of a character parameter.
+	Collation []byte
+	// Encryption describes the encryption of an Always Encrypted
+	// parameter, it is nil for parameters sent in plaintext.
+	Encryption *ParamEncryption
+}
+
+// ParamEncryption is the cipher information sent with an encrypted
+// parameter.
+type ParamEncryption struct {
+	// TypeID is the TDS type of the plaintext.
+	TypeID         byte
+	Algorithm      byte
+	EncryptionType byte
+	DatabaseID     uint32
+	KeyID          uint32
+	KeyVersion     uint32
+	KeyMDVersion   []byte
+	NormVersion    byte
+}
+
+// TVP is the value of a table-valued parameter.
+type TVP struct {
+	// TypeName is the table type, with the schema if the client sent one.
+	TypeName string
+	// Rows holds the column values of each row, decoded like parameter
+	// values.
+	Rows [][]interface{}
+}
+
+// Bulk is the data of a bulk load request.
+type Bulk struct {
+	// Columns are the names of the columns the client sent, in order.
+	Columns []string
+	// Rows holds the column values of each row, decoded like parameter
+	// values.
+	Rows [][]interface{}
+}
+
+// Request is a request received by the server.
+type Request struct {
+	Type RequestType
+	// SQL is the batch text. For sp_executesql calls, which is how the driver
+	// sends parameterized queries, it is the statement text, as for the
+	// statements prepared by sp_prepexec and executed by sp_execute.
+	SQL string
+	// Proc is the name of the called procedure. Procedures called by id,
+	// such as sp_executesql, are given their usual names.
+	Proc string
+	// Params lists the procedure parameters. For sp_executesql and
+	// sp_prepexec the statement, parameter declaration and handle are left
+	// out, as is the handle of sp_execute and sp_unprepare.
+	Params []Param
+	// ParamDecls is the parameter declaration of sp_executesql calls and
+	// prepared statements, such as "@p1 int,@p2 nvarchar(5)".
+	ParamDecls string
+	// Handle is the handle of the statement prepared by sp_prepexec, or
+	// executed by sp_execute or released by sp_unprepare. The server
+	// numbers the handles of a session from 1 and returns them to
+	// sp_prepexec, it answers the handles it does not know with error 8179.
+	Handle int32
+	// Reset is set when the client asked for the session to be reset
+	// before running the request.
+	Reset bool
+	// Notification is the query notification subscription sent with the
+	// request, if any.
+	Notification *Notification
+	// SessionID is the session the request was received on, as returned by
+	// @@SPID. Sessions are numbered from 51.
+	SessionID uint16
+	// EnclavePackage is the enclave package of a session with a secure
+	// enclave, empty if the request does not use the enclave.
+	EnclavePackage []byte
+	// Bulk is the data of a BulkLoad request. Without responses, the
+	// server reports its rows as inserted.
+	Bulk *Bulk
+}
+
+// Notification is a query notification request header.
+type Notification struct {
+	ID      string
+	Options string
+	Timeout time.Duration
+}
+
+// Param returns the parameter with the given name, including the "@",
+// or nil if it was not sent.
+func (r *Request) Param(name string) *Param {
+	for i := range r.Params {
+		if r.Params[i].Name == name {
+			return &r.Params[i]
+		}
+	}
+	return nil
+}
+
+// Login describes a login request received by the server.
+type Login struct {
+	User       string
+	Password   string
+	Database   string
+	AppName    string
+	HostName   string
+	ServerName string
+	PacketSize int
+	ReadOnly   bool
+	// AccessToken is the federated authentication security token the
+	// client logged in with, if any.
+	AccessToken string
+	// NewPassword is the password the client asked to change to, if any.
+	NewPassword string
+	// SessionRecovery is set when the client asked for connection
+	// resiliency. RecoveryData is the SessionRecoveryData of a client
+	// recovering a session, nil for a new session.
+	SessionRecovery bool
+	RecoveryData    []byte
+	// AzureSQLSupport is set when the client sent the AZURESQLSUPPORT
+	// feature extension.
+	AzureSQLSupport bool
+	// ColumnEncryption is set when the client asked for Always Encrypted.
+	// ColumnEncryptionVersion is the version it asked for, 2 and up
+	// support secure enclaves.
+	ColumnEncryption        bool
+	ColumnEncryptionVersion byte
+	// DataClassificationVersion is the version of the DATACLASSIFICATION
+	// feature extension the client sent, zero if it did not.
+	DataClassificationVersion byte
+	// UTF8Support is set when the client sent the UTF8_SUPPORT feature
+	// extension.
+	UTF8Support bool
+}
+
+// Handler produces the reply to a request.
+type Handler func(req *Request) []Response
+
+// Step is one expected exchange of a Script.
+type Step struct {
+	// SQL must be contained in the request SQL text, or match the procedure
+	// name for procedure calls. An empty SQL matches any request.
+	SQL       string
+	Responses []Response
+}
+
+// Script returns a handler that expects requests in the order of steps.
+// Requests that do not match the next step, or arrive after all steps
+// were used, are answered with an error. Transaction requests are
+// always accepted and do not consume steps.
+func Script(steps ...Step) Handler {
+	var mu sync.Mutex
+	next := 0
+	return func(req *Request) []Response {
+		switch req.Type {
+		case BeginTran, CommitTran, RollbackTran:
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if next >= len(steps) {
+			return []Response{Error{Number: 50000, Class: 16, Message: "mssqltest: unexpected request: " + req.describe()}}
+		}
+		step := steps[next]
+		if !(strings.Contains(req.SQL, step.SQL) || (req.SQL == "" && req.Proc == step.SQL)) {
+			return []Response{Error{Number: 50000, Class: 16, Message: fmt.Sprintf("mssqltest: step %d expected %q, got %s", next, step.SQL, req.describe())}}
+		}
+		next++
+		return step.Responses
+	}
+}
+
+func (r *Request) describe() string {
+	if r.SQL != "" {
+		return fmt.Sprintf("%v %q", r.Type, r.SQL)
+	}
+	if r.Bulk != nil {
+		return fmt.Sprintf("%v %d rows", r.Type, len(r.Bulk.Rows))
+	}
+	return fmt.Sprintf("%v %s", r.Type, r.Proc)
+}
+
+// Server is a fake SQL Server listening on a local TCP port.
+type Server struct {
+	// Handler replies to requests. A nil Handler answers every request
+	// with an empty successful reply.
+	Handler Handler
+
+	// Authenticate, if set, is called for every login. Returning an Error
+	// rejects the login with that error, any other error is reported as
+	// a login failure (error 18456).
+	Authenticate func(login *Login) error
+
+	// Route, if set, is called for every accepted login. Returning a
+	// non-empty server routes the client to server and port with a
+	// ROUTING ENVCHANGE, as a listener does for read-only routing, and
+	// closes the connection.
+	Route func(login *Login) (server string, port uint16)
+
+	// Partner, if set, is advertised at login as the database mirroring
+	// failover partner.
+	Partner string
+
+	// SessionRecovery acknowledges the requests for connection
+	// resiliency, the initial session state sent to the client holds the
+	// database of the login.
+	SessionRecovery bool
+
+	// ColumnEncryption acknowledges the requests for Always Encrypted,
+	// result sets of the sessions that use it are sent with an empty
+	// column encryption key table.
+	ColumnEncryption bool
+
+	// EnclaveType, such as VBS, is acknowledged with Always Encrypted to
+	// the clients that support secure enclaves. Their requests then carry
+	// an enclave package.
+	EnclaveType string
+
+	// DataClassification acknowledges the DATACLASSIFICATION feature
+	// extension, the sensitivity of result set columns is then sent to
+	// the clients that support it.
+	DataClassification bool
+
+	// UTF8 acknowledges the UTF8_SUPPORT feature extension. The database
+	// collation of the clients that support it is then the UTF-8 collation
+	// Latin1_General_100_CI_AS_SC_UTF8, which VarChar columns have.
+	UTF8 bool
+
+	listener net.Listener
+
+	mu       sync.Mutex
+	requests []*Request
+	logins   []*Login
+	conns    map[net.Conn]struct{}
+	sessions map[uint16]net.Conn
+	lastSPID uint16
+	closed   bool
+	wg       sync.WaitGroup
+
+	// replay is set for servers created by NewReplayServer
+	replaying bool
+	replay    []*Recording
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down. NewServer panics if it cannot listen on
+// a local port.
+func NewServer(handler Handler) *Server {
+	s := NewUnstartedServer(handler)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new Server that is not yet listening, so
+// its fields can be set before calling Start.
+func NewUnstartedServer(handler Handler) *Server {
+	return &Server{Handler: handler, conns: map[net.Conn]struct{}{}, sessions: map[uint16]net.Conn{}, lastSPID: 50}
+}
+
+// Start starts listening on a random local port.
+func (s *Server) Start() {
+	if s.listener != nil {
+		panic("mssqltest: server already started")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("mssqltest: failed to listen on a port: %v", err))
+	}
+	s.listener = l
+	s.wg.Add(1)
+	go s.serve()
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() *net.TCPAddr {
+	return s.listener.Addr().(*net.TCPAddr)
+}
+
+// DSN returns a connection string for the server.
+func (s *Server) DSN() string {
+	return fmt.Sprintf("sqlserver://127.0.0.1:%d?encrypt=disable", s.Addr().Port)
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Request(nil), s.requests...)
+}
+
+// Logins returns the logins received so far, in order.
+func (s *Server) Logins() []*Login {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Login(nil), s.logins...)
+}
+
+// DropConnections closes all client connections, as a network failure or a
+// reconfiguration of the server would, and keeps accepting new ones.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Close stops the server and closes all client connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.lastSPID++
+		spid := s.lastSPID
+		s.sessions[spid] = c
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			newServerConn(s, c, spid).serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			delete(s.sessions, spid)
+			s.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+type message struct {
+	typ    byte
+	status byte
+	data   []byte
+	err    error
+}
+
+type serverConn struct {
+	srv        *Server
+	conn       net.Conn
+	msgs       chan message
+	pending    *message
+	packetSize int
+	tranID     uint64
+	spid       uint16
+	// columnEncryption is set when the session uses Always Encrypted,
+	// enclave when it also uses the secure enclave
+	columnEncryption bool
+	enclave          bool
+	// dataClassification is the version of the DATACLASSIFICATION
+	// feature extension of the session, zero if not used
+	dataClassification byte
+	// utf8 is set when the session uses a UTF-8 collation
+	utf8 bool
+	// prepared holds the statements prepared by sp_prepexec by handle
+	prepared   map[int32]preparedStmt
+	lastHandle int32
+}
+
+type preparedStmt struct {
+	sql, decls string
+}
+
+func newServerConn(s *Server, c net.Conn, spid uint16) *serverConn {
+	return &serverConn{srv: s, conn: c, msgs: make(chan message, 1), packetSize: defaultPacketSize, spid: spid}
+}
+
+func (c *serverConn) readLoop() {
+	for {
+		typ, status, data, err := readMessage(c.conn)
+		c.msgs <- message{typ, status, data, err}
+		if err != nil {
+			close(c.msgs)
+			return
+		}
+	}
+}
+
+func (c *serverConn) next() (message, bool) {
+	if c.pending != nil {
+		m := *c.pending
+		c.pending = nil
+		return m, true
+	}
+	m, ok := <-c.msgs
+	return m, ok && m.err == nil
+}
+
+func (c *serverConn) serve() {
+	go c.readLoop()
+	defer func() {
+		c.conn.Close()
+		// drain so the reader can exit
+		for range c.msgs {
+		}
+	}()
+	if c.srv.replaying {
+		if rec := c.srv.nextRecording(); rec != nil {
+			c.replay(rec)
+		}
+		return
+	}
+	if !c.handshake() {
+		return
+	}
+	for {
+		m, ok := c.next()
+		if !ok {
+			return
+		}
+		if m.typ == packAttention {
+			if c.reply(ackAttention()) != nil {
+				return
+			}
+			continue
+		}
+		req, err := parseRequest(m, c.enclave)
+		if err != nil {
+			var w tokenWriter
+			Error{Number: 50000, Class: 16, Message: err.Error()}.writeToken(&w, tokenError)
+			w.done(tokenDone, doneError, 0)
+			if c.reply(w.Bytes()) != nil {
+				return
+			}
+			continue
+		}
+		req.SessionID = c.spid
+		known := c.prepare(req)
+		c.srv.mu.Lock()
+		c.srv.requests = append(c.srv.requests, req)
+		if spid, ok := killTarget(req); ok && c.srv.sessions[spid] != nil {
+			c.srv.sessions[spid].Close()
+		}
+		c.srv.mu.Unlock()
+		var responses []Response
+		if !known {
+			responses = []Response{Error{Number: 8179, Class: 16, Message: fmt.Sprintf("Could not find prepared statement with handle %d.", req.Handle)}}
+		} else if c.srv.Handler != nil {
+			responses = c.srv.Handler(req)
+		}
+		if req.Proc == "sp_prepexec" {
+			responses = append(responses, OutputParam{Type: Int, Value: int64(req.Handle)})
+		}
+		if !c.respond(req, responses) {
+			return
+		}
+	}
+}
+
+// prepare keeps the statements prepared by sp_prepexec and gives the
+// sp_execute calls the text and declaration of theirs, it reports false for
+// the handles it does not know.
+func (c *serverConn) prepare(req *Request) bool {
+	if req.Type != RPC {
+		return true
+	}
+	switch req.Proc {
+	case "sp_prepexec":
+		if c.prepared == nil {
+			c.prepared = map[int32]preparedStmt{}
+		}
+		c.lastHandle++
+		req.Handle = c.lastHandle
+		c.prepared[req.Handle] = preparedStmt{req.SQL, req.ParamDecls}
+	case "sp_execute":
+		p, ok := c.prepared[req.Handle]
+		if !ok {
+			return false
+		}
+		req.SQL, req.ParamDecls = p.sql, p.decls
+	case "sp_unprepare":
+		if _, ok := c.prepared[req.Handle]; !ok {
+			return false
+		}
+		delete(c.prepared, req.Handle)
+	}
+	return true
+}
+
+var killRe = regexp.MustCompile(`(?i)^\s*KILL\s+(\d+)\s*;?\s*$`)
+
+// killTarget returns the session killed by a KILL batch, the server closes
+// its connection like SQL Server does.
+func killTarget(req *Request) (uint16, bool) {
+	if req.Type != SQLBatch {
+		return 0, false
+	}
+	m := killRe.FindStringSubmatch(req.SQL)
+	if m == nil {
+		return 0, false
+	}
+	spid, err := strconv.ParseUint(m[1], 10, 16)
+	return uint16(spid), err == nil
+}
+
+func ackAttention() []byte {
+	var w tokenWriter
+	w.done(tokenDone, doneAttn, 0)
+	return w.Bytes()
+}
+
+func (c *serverConn) reply(data []byte) error {
+	return writeMessage(c.conn, packReply, c.spid, data, c.packetSize)
+}
+
+// respond writes the reply to req, it returns false if the connection
+// should be closed.
+func (c *serverConn) respond(req *Request, responses []Response) bool {
+	w := tokenWriter{columnEncryption: c.columnEncryption, dataClassification: c.dataClassification, utf8: c.utf8}
+	switch req.Type {
+	case BeginTran:
+		c.tranID++
+		id := make([]byte, 8)
+		binary.LittleEndian.PutUint64(id, c.tranID)
+		w.envChange(envTypBeginTran, id, nil)
+	case CommitTran, RollbackTran:
+		id := make([]byte, 8)
+		binary.LittleEndian.PutUint64(id, c.tranID)
+		typ := byte(envTypCommitTran)
+		if req.Type == RollbackTran {
+			typ = envTypRollbackTran
+		}
+		w.envChange(typ, nil, id)
+	case BulkLoad:
+		if len(responses) == 0 {
+			w.done(tokenDone, doneCount, uint64(len(req.Bulk.Rows)))
+		}
+	}
+	// the statements of a procedure end with DONEINPROC tokens, the
+	// procedure with a DONEPROC token
+	w.inProc = req.Type == RPC
+	for _, r := range responses {
+		switch r := r.(type) {
+		case Disconnect:
+			return false
+		case Delay:
+			if c.delay(time.Duration(r)) {
+				return c.reply(ackAttention()) == nil
+			}
+			continue
+		case Stall:
+			attn, ok := c.stall(time.Duration(r))
+			if !ok {
+				return false
+			}
+			if attn {
+				return c.reply(ackAttention()) == nil
+			}
+			continue
+		}
+		start, lastDone := w.Len(), w.lastDone
+		if err := r.write(&w); err != nil {
+			w.Truncate(start)
+			w.lastDone = lastDone
+			Error{Number: 50000, Class: 16, Message: err.Error()}.write(&w)
+		}
+	}
+	if w.inProc {
+		w.inProc = false
+		w.done(tokenDoneProc, 0, 0)
+	} else if w.lastDone > 0 && w.lastDone-1+13 == w.Len() {
+		// the reply already ends with a DONE token, clear its DONE_MORE flag
+		b := w.Bytes()[w.lastDone:]
+		binary.LittleEndian.PutUint16(b, binary.LittleEndian.Uint16(b)&^doneMore)
+	} else {
+		w.done(tokenDone, 0, 0)
+	}
+	return c.reply(w.Bytes()) == nil
+}
+
+// delay waits for d, it returns true if the client sent an attention
+// signal in the meantime.
+func (c *serverConn) delay(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return false
+	case m, ok := <-c.msgs:
+		if !ok {
+			return false
+		}
+		if m.typ == packAttention {
+			return true
+		}
+		c.pending = &m
+		<-t.C
+		return false
+	}
+}
+
+// stall waits for d without acknowledging attention signals. It reports
+// whether an attention signal was received and returns false for ok if the
+// connection was closed in the meantime.
+func (c *serverConn) stall(d time.Duration) (attn, ok bool) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			return attn, true
+		case m, more := <-c.msgs:
+			if !more || m.err != nil {
+				return attn, false
+			}
+			if m.typ == packAttention {
+				attn = true
+			} else {
+				c.pending = &m
+			}
+		}
+	}
+}
+
+func (c *serverConn) handshake() bool {
+	m, ok := c.next()
+	if !ok || m.typ != packPrelogin {
+		return false
+	}
+	var w tokenWriter
+	// three option tokens and a terminator precede the data
+	offset := uint16(5*3 + 1)
+	w.byte(preloginVERSION)
+	w.Write([]byte{byte(offset >> 8), byte(offset), 0, 6})
+	w.byte(preloginENCRYPTION)
+	w.Write([]byte{byte((offset + 6) >> 8), byte(offset + 6), 0, 1})
+	w.byte(preloginFEDAUTHREQUIRED)
+	w.Write([]byte{byte((offset + 7) >> 8), byte(offset + 7), 0, 1})
+	w.byte(preloginTERMINATOR)
+	w.Write([]byte{15, 0, 0x07, 0xd0, 0, 0}) // 15.0.2000
+	w.byte(encryptNotSup)
+	w.byte(0) // SQL and federated authentication are both allowed
+	if writeMessage(c.conn, packReply, c.spid, w.Bytes(), c.packetSize) != nil {
+		return false
+	}
+
+	m, ok = c.next()
+	if !ok || m.typ != packLogin7 {
+		return false
+	}
+	login, err := parseLogin(m.data)
+	if err != nil {
+		return false
+	}
+	c.srv.mu.Lock()
+	c.srv.logins = append(c.srv.logins, login)
+	c.srv.mu.Unlock()
+
+	w.Reset()
+	if c.srv.Authenticate != nil {
+		if err := c.srv.Authenticate(login); err != nil {
+			e, ok := err.(Error)
+			if !ok {
+				e = Error{Number: 18456, State: 1, Class: 14, Message: fmt.Sprintf("Login failed for user '%s'. %v", login.User, err)}
+			}
+			e.writeToken(&w, tokenError)
+			w.done(tokenDone, doneError, 0)
+			c.reply(w.Bytes())
+			return false
+		}
+	}
+	if login.Database != "" {
+		w.envChangeString(envTypDatabase, login.Database, "master")
+	}
+	if login.PacketSize >= 512 {
+		c.packetSize = login.PacketSize
+	}
+	w.envChangeString(envTypPacketSize, fmt.Sprint(c.packetSize), fmt.Sprint(c.packetSize))
+	if c.srv.Partner != "" {
+		w.envChangeString(envTypMirrorPartner, c.srv.Partner, "")
+	}
+	progName := str2ucs2("mssqltest")
+	w.byte(tokenLoginAck)
+	w.uint16(uint16(1 + 4 + 1 + len(progName) + 4))
+	w.byte(1) // SQL_TSQL
+	w.Write([]byte{0x74, 0, 0, 4})
+	w.byte(byte(len(progName) / 2))
+	w.Write(progName)
+	w.Write([]byte{15, 0, 0x07, 0xd0})
+	var acks []featureAck
+	if c.srv.SessionRecovery && login.SessionRecovery {
+		acks = append(acks, featureAck{featExtSESSIONRECOVERY, sessionRecoveryAck(login.Database)})
+	}
+	if c.srv.ColumnEncryption && login.ColumnEncryption {
+		c.columnEncryption = true
+		ack := []byte{1}
+		if c.srv.EnclaveType != "" && login.ColumnEncryptionVersion >= 2 {
+			c.enclave = true
+			name := str2ucs2(c.srv.EnclaveType)
+			ack = append([]byte{login.ColumnEncryptionVersion, byte(len(name) / 2)}, name...)
+		}
+		acks = append(acks, featureAck{featExtCOLUMNENCRYPTION, ack})
+	}
+	if c.srv.DataClassification && login.DataClassificationVersion > 0 {
+		c.dataClassification = login.DataClassificationVersion
+		if c.dataClassification > 2 {
+			c.dataClassification = 2
+		}
+		acks = append(acks, featureAck{featExtDATACLASSIFICATION, []byte{c.dataClassification, 1}})
+	}
+	if c.srv.UTF8 && login.UTF8Support {
+		c.utf8 = true
+		w.envChange(envTypSQLCollation, collationUTF8[:], nil)
+		acks = append(acks, featureAck{featExtUTF8SUPPORT, []byte{1}})
+	}
+	if len(acks) > 0 {
+		w.featureExtAck(acks)
+	}
+	if c.srv.Route != nil {
+		if server, port := c.srv.Route(login); server != "" {
+			w.envChangeRouting(server, port)
+			w.done(tokenDone, 0, 0)
+			c.reply(w.Bytes())
+			return false
+		}
+	}
+	w.done(tokenDone, 0, 0)
+	return c.reply(w.Bytes()) == nil
+}
+
+func parseLogin(data []byte) (*Login, error) {
+	if len(data) < 94 {
+		return nil, errors.New("mssqltest: login packet too short")
+	}
+	str := func(pos int) string {
+		offset := int(binary.LittleEndian.Uint16(data[pos:]))
+		length := int(binary.LittleEndian.Uint16(data[pos+2:])) * 2
+		if offset+length > len(data) {
+			return ""
+		}
+		s, _ := ucs22str(data[offset : offset+length])
+		return s
+	}
+	l := &Login{
+		PacketSize: int(binary.LittleEndian.Uint32(data[8:])),
+		ReadOnly:   data[26]&0x20 != 0,
+		HostName:   str(36),
+		User:       str(40),
+		AppName:    str(48),
+		ServerName: str(52),
+		Database:   str(68),
+	}
+	password := func(pos int) string {
+		offset := int(binary.LittleEndian.Uint16(data[pos:]))
+		length := int(binary.LittleEndian.Uint16(data[pos+2:])) * 2
+		if offset+length > len(data) {
+			return ""
+		}
+		pwd := make([]byte, length)
+		for i, ch := range data[offset : offset+length] {
+			ch ^= 0xA5
+			pwd[i] = ch<<4 | ch>>4
+		}
+		s, _ := ucs22str(pwd)
+		return s
+	}
+	l.Password = password(44)
+	if data[27]&0x01 != 0 {
+		l.NewPassword = password(86)
+	}
+	if data[27]&0x10 != 0 {
+		features := parseFeatures(data, int(binary.LittleEndian.Uint16(data[56:])))
+		if fedAuth, ok := features[featExtFEDAUTH]; ok {
+			l.AccessToken = parseFedAuthToken(fedAuth)
+		}
+		if dc, ok := features[featExtDATACLASSIFICATION]; ok && len(dc) >= 1 {
+			l.DataClassificationVersion = dc[0]
+		}
+		if ae, ok := features[featExtCOLUMNENCRYPTION]; ok {
+			if len(ae) >= 1 {
+				l.ColumnEncryption = ae[0] >= 1
+				l.ColumnEncryptionVersion = ae[0]
+			}
+		}
+		if _, ok := features[featExtUTF8SUPPORT]; ok {
+			l.UTF8Support = true
+		}
+		if azure, ok := features[featExtAZURESQLSUPPORT]; ok {
+			l.AzureSQLSupport = len(azure) == 1 && azure[0]&0x01 != 0
+		}
+		if recovery, ok := features[featExtSESSIONRECOVERY]; ok {
+			l.SessionRecovery = true
+			if len(recovery) > 0 {
+				l.RecoveryData = recovery
+			}
+		}
+	}
+	return l, nil
+}
+
+// parseFeatures returns the data of the feature extensions by id, the
+// offset of the extension block is stored at pos.
+func parseFeatures(data []byte, pos int) map[byte][]byte {
+	features := map[byte][]byte{}
+	if pos+4 > len(data) {
+		return features
+	}
+	for i := int(binary.LittleEndian.Uint32(data[pos:])); i+5 <= len(data) && data[i] != featExtTERM; {
+		id := data[i]
+		length := int(binary.LittleEndian.Uint32(data[i+1:]))
+		feature := data[i+5:]
+		if length > len(feature) {
+			return features
+		}
+		features[id] = feature[:length]
+		i += 5 + length
+	}
+	return features
+}
+
+// parseFedAuthToken returns the security token of the FEDAUTH feature
+// extension.
+func parseFedAuthToken(feature []byte) string {
+	// the options byte holds the library, 1 is security token
+	if len(feature) >= 5 && feature[0]>>1 == 1 {
+		n := int(binary.LittleEndian.Uint32(feature[1:]))
+		if 5+n <= len(feature) {
+			token, _ := ucs22str(feature[5 : 5+n])
+			return token
+		}
+	}
+	return ""
+}
+
+var procNames = map[uint16]string{
+	1:                "sp_cursor",
+	2:                "sp_cursoropen",
+	3:                "sp_cursorprepare",
+	4:                "sp_cursorexecute",
+	5:                "sp_cursorprepexec",
+	6:                "sp_cursorunprepare",
+	7:                "sp_cursorfetch",
+	8:                "sp_cursoroption",
+	9:                "sp_cursorclose",
+	procIDExecuteSQL: "sp_executesql",
+	procIDPrepare:    "sp_prepare",
+	procIDExecute:    "sp_execute",
+	procIDPrepExec:   "sp_prepexec",
+	14:               "sp_prepexecrpc",
+	procIDUnprepare:  "sp_unprepare",
+}
+
+func parseRequest(m message, enclave bool) (*Request, error) {
+	r := &reader{b: m.data}
+	req := &Request{Reset: m.status&0x08 != 0}
+	enclavePackage := func() {
+		if enclave {
+			req.EnclavePackage = append([]byte{}, r.next(int(r.uint16()))...)
+		}
+	}
+	switch m.typ {
+	case packSQLBatch:
+		req.Type = SQLBatch
+		req.Notification = r.allHeaders()
+		enclavePackage()
+		req.SQL = r.ucs2(len(r.b) / 2)
+	case packTransMgrReq:
+		req.Notification = r.allHeaders()
+		switch r.uint16() {
+		case tmBeginXact:
+			req.Type = BeginTran
+		case tmCommitXact:
+			req.Type = CommitTran
+		case tmRollbackXact:
+			req.Type = RollbackTran
+		default:
+			return nil, errors.New("mssqltest: unsupported transaction manager request")
+		}
+	case packRPCRequest:
+		req.Type = RPC
+		req.Notification = r.allHeaders()
+		enclavePackage()
+		if n := r.uint16(); n == 0xffff {
+			id := r.uint16()
+			req.Proc = procNames[id]
+			if req.Proc == "" {
+				req.Proc = fmt.Sprintf("proc#%d", id)
+			}
+		} else {
+			req.Proc = r.ucs2(int(n))
+		}
+		r.uint16() // option flags
+		for len(r.b) > 0 && r.err == nil {
+			if r.b[0] == 0x80 || r.b[0] == 0xff {
+				// batched RPC calls are not supported, ignore the rest
+				break
+			}
+			var p Param
+			p.Name = r.bVarChar()
+			status := r.byte()
+			p.Output = status&paramByRefValue != 0
+			ti, err := r.typeInfo()
+			if err != nil {
+				return nil, err
+			}
+			if p.Value, err = r.value(ti); err != nil {
+				return nil, err
+			}
+			p.Collation = ti.collation
+			if status&paramEncrypted != 0 {
+				if p.Encryption, err = r.paramCipherInfo(); err != nil {
+					return nil, err
+				}
+			}
+			req.Params = append(req.Params, p)
+		}
+		switch {
+		case req.Proc == "sp_executesql" && len(req.Params) >= 1:
+			req.SQL, _ = req.Params[0].Value.(string)
+			if len(req.Params) >= 2 {
+				req.ParamDecls, _ = req.Params[1].Value.(string)
+				req.Params = req.Params[2:]
+			} else {
+				req.Params = nil
+			}
+		case req.Proc == "sp_prepexec" && len(req.Params) >= 3:
+			// the handle output parameter, the declaration and the
+			// statement come first
+			req.ParamDecls, _ = req.Params[1].Value.(string)
+			req.SQL, _ = req.Params[2].Value.(string)
+			req.Params = req.Params[3:]
+		case (req.Proc == "sp_execute" || req.Proc == "sp_unprepare") && len(req.Params) >= 1:
+			handle, _ := req.Params[0].Value.(int64)
+			req.Handle = int32(handle)
+			req.Params = req.Params[1:]
+		}
+	case packBulkLoad:
+		req.Type = BulkLoad
+		bulk, err := r.bulk()
+		if err != nil {
+			return nil, err
+		}
+		req.Bulk = bulk
+	default:
+		return nil, fmt.Errorf("mssqltest: unsupported packet type %d", m.typ)
+	}
+	if r.err != nil {
+		if r.err == io.ErrUnexpectedEOF {
+			return nil, errors.New("mssqltest: truncated request")
+		}
+		return nil, r.err
+	}
+	return req, nil
+}
@@ -0,0 +1,215 @@
+package mssqltest_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func open(t *testing.T, srv *mssqltest.Server) *sql.DB {
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestResultSet(t *testing.T) {
+	when := time.Date(2021, 3, 4, 5, 6, 7, 800, time.UTC)
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "id", Type: mssqltest.BigInt},
+				{Name: "name", Type: mssqltest.NVarChar},
+				{Name: "flag", Type: mssqltest.Bit},
+				{Name: "at", Type: mssqltest.DateTime2},
+			},
+			Rows: [][]interface{}{
+				{1, "one", true, when},
+				{2, nil, false, nil},
+			},
+		}}
+	})
+	defer srv.Close()
+	db := open(t, srv)
+	defer db.Close()
+
+	rows, err := db.Query("select id, name, flag, at from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id int64
+		var name sql.NullString
+		var flag bool
+		var at interface{}
+		if err := rows.Scan(&id, &name, &flag, &at); err != nil {
+			t.Fatal(err)
+		}
+		if tm, _ := at.(time.Time); id == 1 && !tm.Equal(when) {
+			t.Errorf("got time %v, want %v", at, when)
+		}
+		got = append(got, name.String)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "one," {
+		t.Errorf("unexpected rows %q", got)
+	}
+}
+
+func TestParams(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.RowsAffected(3)}
+	})
+	defer srv.Close()
+	db := open(t, srv)
+	defer db.Close()
+
+	res, err := db.Exec("update t set a = @p1 where b = @b", 42, sql.Named("b", "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Errorf("expected 3 rows affected, got %d", n)
+	}
+	reqs := srv.Requests()
+	req := reqs[len(reqs)-1]
+	if req.Type != mssqltest.RPC || req.Proc != "sp_executesql" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if req.SQL != "update t set a = @p1 where b = @b" {
+		t.Errorf("unexpected SQL %q", req.SQL)
+	}
+	if p := req.Param("@p1"); p == nil || p.Value != int64(42) {
+		t.Errorf("unexpected @p1 %+v", p)
+	}
+	if p := req.Param("@b"); p == nil || p.Value != "x" {
+		t.Errorf("unexpected @b %+v", p)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.Error{Number: 2627, Class: 14, State: 1, Message: "Violation of PRIMARY KEY constraint"}}
+	})
+	defer srv.Close()
+	db := open(t, srv)
+	defer db.Close()
+
+	_, err := db.Exec("insert into t values (1)")
+	serr, ok := err.(mssql.Error)
+	if !ok {
+		t.Fatalf("expected mssql.Error, got %v", err)
+	}
+	if serr.Number != 2627 {
+		t.Errorf("unexpected error number %d", serr.Number)
+	}
+}
+
+func TestDelayCancel(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if strings.Contains(req.SQL, "slow") {
+			return []mssqltest.Response{mssqltest.Delay(10 * time.Second), mssqltest.RowsAffected(1)}
+		}
+		return []mssqltest.Response{mssqltest.RowsAffected(1)}
+	})
+	defer srv.Close()
+	db := open(t, srv)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := db.ExecContext(ctx, "select slow"); err == nil {
+		t.Fatal("expected the query to be cancelled")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("cancellation took too long")
+	}
+	// the connection is still usable after the cancellation
+	if _, err := db.Exec("select fast"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScriptAndTransactions(t *testing.T) {
+	srv := mssqltest.NewServer(mssqltest.Script(
+		mssqltest.Step{SQL: "insert", Responses: []mssqltest.Response{mssqltest.RowsAffected(1)}},
+		mssqltest.Step{SQL: "myproc", Responses: []mssqltest.Response{
+			mssqltest.OutputParam{Name: "@out", Type: mssqltest.NVarChar, Value: "result"},
+			mssqltest.ReturnStatus(7),
+		}},
+	))
+	defer srv.Close()
+	db := open(t, srv)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out string
+	var rs mssql.ReturnStatus
+	if _, err = db.Exec("myproc", sql.Named("out", sql.Out{Dest: &out}), &rs); err != nil {
+		t.Fatal(err)
+	}
+	if out != "result" || rs != 7 {
+		t.Errorf("got out=%q status=%d", out, rs)
+	}
+
+	if _, err = db.Exec("select 1"); err == nil || !strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("expected unexpected request error, got %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	srv := mssqltest.NewUnstartedServer(nil)
+	srv.Authenticate = func(l *mssqltest.Login) error {
+		if l.User != "sa" || l.Password != "secret" {
+			return errors.New("bad password")
+		}
+		return nil
+	}
+	srv.Start()
+	defer srv.Close()
+
+	db, err := sql.Open("sqlserver", strings.Replace(srv.DSN(), "//", "//sa:wrong@", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Ping(); err == nil {
+		t.Error("expected login to fail")
+	}
+	db.Close()
+
+	db, err = sql.Open("sqlserver", strings.Replace(srv.DSN(), "//", "//sa:secret@", 1)+"&database=app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	logins := srv.Logins()
+	if last := logins[len(logins)-1]; last.Database != "app" {
+		t.Errorf("unexpected login %+v", last)
+	}
+}
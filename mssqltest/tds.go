@@ -0,0 +1,804 @@
+package mssqltest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"time"
+	"unicode/utf16"
+)
+
+// packet types
+const (
+	packSQLBatch    = 1
+	packRPCRequest  = 3
+	packReply       = 4
+	packAttention   = 6
+	packBulkLoad    = 7
+	packTransMgrReq = 14
+	packLogin7      = 16
+	packSSPIMessage = 17
+	packPrelogin    = 18
+)
+
+const (
+	headerSize        = 8
+	defaultPacketSize = 4096
+)
+
+// tokens
+const (
+	tokenReturnStatus       = 0x79
+	tokenColMetadata        = 0x81
+	tokenDataClassification = 0xa3
+	tokenTabName            = 0xa4
+	tokenColInfo            = 0xa5
+	tokenError              = 0xAA
+	tokenInfo               = 0xAB
+	tokenReturnValue        = 0xAC
+	tokenLoginAck           = 0xAD
+	tokenFeatureExtAck      = 0xAE
+	tokenRow                = 0xD1
+	tokenEnvChange          = 0xE3
+	tokenSessionState       = 0xE4
+	tokenDone               = 0xFD
+	tokenDoneProc           = 0xFE
+	tokenDoneInProc         = 0xFF
+)
+
+// done flags
+const (
+	doneMore  = 0x01
+	doneError = 0x02
+	doneCount = 0x10
+	doneAttn  = 0x20
+)
+
+// env change types
+const (
+	envTypDatabase      = 1
+	envTypPacketSize    = 4
+	envTypSQLCollation  = 7
+	envTypBeginTran     = 8
+	envTypCommitTran    = 9
+	envTypRollbackTran  = 10
+	envTypMirrorPartner = 13
+	envTypRouting       = 20
+)
+
+const verTDS74 = 0x74000004
+
+// ALL_HEADERS header types
+const dataStmHdrQueryNotif = 1
+
+// prelogin fields
+const (
+	preloginVERSION         = 0
+	preloginENCRYPTION      = 1
+	preloginFEDAUTHREQUIRED = 6
+	preloginTERMINATOR      = 0xff
+	encryptNotSup           = 2
+)
+
+// transaction manager request types
+const (
+	tmBeginXact    = 5
+	tmCommitXact   = 7
+	tmRollbackXact = 8
+)
+
+// well known procedure ids
+const (
+	procIDExecuteSQL = 10
+	procIDPrepare    = 11
+	procIDExecute    = 12
+	procIDPrepExec   = 13
+	procIDUnprepare  = 15
+)
+
+// RPC parameter status flags
+const (
+	paramByRefValue = 1
+	paramEncrypted  = 8
+)
+
+// readMessage reads a complete TDS message, which may span several packets.
+func readMessage(r io.Reader) (typ byte, status byte, data []byte, err error) {
+	var buf bytes.Buffer
+	hdr := make([]byte, headerSize)
+	for first := true; ; first = false {
+		if _, err = io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		size := int(binary.BigEndian.Uint16(hdr[2:]))
+		if size < headerSize {
+			err = fmt.Errorf("mssqltest: invalid packet size %d", size)
+			return
+		}
+		if first {
+			typ = hdr[0]
+			status = hdr[1]
+		}
+		if _, err = io.CopyN(&buf, r, int64(size-headerSize)); err != nil {
+			return
+		}
+		if hdr[1]&1 != 0 {
+			return typ, status, buf.Bytes(), nil
+		}
+	}
+}
+
+// writeMessage writes data as a TDS message split into packets of at most packetSize bytes.
+func writeMessage(w io.Writer, typ byte, spid uint16, data []byte, packetSize int) error {
+	chunk := packetSize - headerSize
+	packet := make([]byte, 0, packetSize)
+	for seq := 1; ; seq++ {
+		n := len(data)
+		status := byte(1)
+		if n > chunk {
+			n = chunk
+			status = 0
+		}
+		packet = packet[:headerSize]
+		packet[0] = typ
+		packet[1] = status
+		binary.BigEndian.PutUint16(packet[2:], uint16(headerSize+n))
+		binary.BigEndian.PutUint16(packet[4:], spid)
+		packet[6] = byte(seq)
+		packet[7] = 0
+		packet = append(packet, data[:n]...)
+		if _, err := w.Write(packet); err != nil {
+			return err
+		}
+		data = data[n:]
+		if status == 1 {
+			return nil
+		}
+	}
+}
+
+func str2ucs2(s string) []byte {
+	res := utf16.Encode([]rune(s))
+	ucs2 := make([]byte, 2*len(res))
+	for i, ch := range res {
+		binary.LittleEndian.PutUint16(ucs2[2*i:], ch)
+	}
+	return ucs2
+}
+
+func ucs22str(b []byte) (string, error) {
+	if len(b)%2 != 0 {
+		return "", errors.New("mssqltest: illegal UCS2 string length")
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// tokenWriter accumulates a reply message.
+type tokenWriter struct {
+	bytes.Buffer
+
+	// lastDone is one past the offset of the last DONE token written
+	lastDone int
+	// columnEncryption is set to write the column encryption key table
+	// of result sets
+	columnEncryption bool
+	// dataClassification is the version of the DATACLASSIFICATION tokens
+	// written after the metadata of classified result sets, zero to leave
+	// them out
+	dataClassification byte
+	// utf8 is set to give VarChar columns a UTF-8 collation
+	utf8 bool
+	// inProc is set to end the statements with DONEINPROC tokens, as in
+	// the replies to RPC requests
+	inProc bool
+}
+
+func (w *tokenWriter) byte(b byte) {
+	w.WriteByte(b)
+}
+
+func (w *tokenWriter) uint16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *tokenWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *tokenWriter) uint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *tokenWriter) bVarChar(s string) {
+	u := str2ucs2(s)
+	w.byte(byte(len(u) / 2))
+	w.Write(u)
+}
+
+func (w *tokenWriter) usVarChar(s string) {
+	u := str2ucs2(s)
+	w.uint16(uint16(len(u) / 2))
+	w.Write(u)
+}
+
+func (w *tokenWriter) done(token byte, status uint16, rowCount uint64) {
+	if w.inProc && token == tokenDone {
+		token = tokenDoneInProc
+	}
+	w.lastDone = w.Len() + 1
+	w.byte(token)
+	w.uint16(status)
+	w.uint16(0)
+	w.uint64(rowCount)
+}
+
+// envChange writes an ENVCHANGE token with B_VARBYTE values.
+func (w *tokenWriter) envChange(typ byte, newValue, oldValue []byte) {
+	w.byte(tokenEnvChange)
+	w.uint16(uint16(1 + 1 + len(newValue) + 1 + len(oldValue)))
+	w.byte(typ)
+	w.byte(byte(len(newValue)))
+	w.Write(newValue)
+	w.byte(byte(len(oldValue)))
+	w.Write(oldValue)
+}
+
+// envChangeString writes an ENVCHANGE token with B_VARCHAR values.
+func (w *tokenWriter) envChangeString(typ byte, newValue, oldValue string) {
+	nv, ov := str2ucs2(newValue), str2ucs2(oldValue)
+	w.byte(tokenEnvChange)
+	w.uint16(uint16(1 + 1 + len(nv) + 1 + len(ov)))
+	w.byte(typ)
+	w.byte(byte(len(nv) / 2))
+	w.Write(nv)
+	w.byte(byte(len(ov) / 2))
+	w.Write(ov)
+}
+
+// envChangeRouting writes a ROUTING ENVCHANGE token to the TCP port of
+// server.
+func (w *tokenWriter) envChangeRouting(server string, port uint16) {
+	name := str2ucs2(server)
+	valueLen := 1 + 2 + 2 + len(name)
+	w.byte(tokenEnvChange)
+	w.uint16(uint16(1 + 2 + valueLen + 2))
+	w.byte(envTypRouting)
+	w.uint16(uint16(valueLen))
+	w.byte(0) // TCP
+	w.uint16(port)
+	w.uint16(uint16(len(name) / 2))
+	w.Write(name)
+	w.uint16(0) // old value
+}
+
+// featureAck is the acknowledgement of a feature extension.
+type featureAck struct {
+	id   byte
+	data []byte
+}
+
+// featureExtAck writes a FEATUREEXTACK token with the given
+// acknowledgements.
+func (w *tokenWriter) featureExtAck(acks []featureAck) {
+	w.byte(tokenFeatureExtAck)
+	for _, ack := range acks {
+		w.byte(ack.id)
+		w.uint32(uint32(len(ack.data)))
+		w.Write(ack.data)
+	}
+	w.byte(featExtTERM)
+}
+
+// sessionRecoveryAck returns the acknowledgement of connection
+// resiliency, with the initial session state of database.
+func sessionRecoveryAck(database string) []byte {
+	db := str2ucs2(database)
+	// RecoveryDatabase, RecoveryCollation and RecoveryLanguage
+	length := 1 + len(db) + 1 + 1
+	data := make([]byte, 4, 4+length)
+	binary.LittleEndian.PutUint32(data, uint32(length))
+	data = append(data, byte(len(db)/2))
+	data = append(data, db...)
+	return append(data, 0, 0) // no collation, no language
+}
+
+// reader is a cursor over a received message.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		r.b = nil
+		return make([]byte, n)
+	}
+	res := r.b[:n]
+	r.b = r.b[n:]
+	return res
+}
+
+func (r *reader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *reader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.next(2))
+}
+
+func (r *reader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.next(4))
+}
+
+func (r *reader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.next(8))
+}
+
+func (r *reader) ucs2(chars int) string {
+	s, err := ucs22str(r.next(chars * 2))
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return s
+}
+
+func (r *reader) bVarChar() string {
+	return r.ucs2(int(r.byte()))
+}
+
+func (r *reader) usVarChar() string {
+	return r.ucs2(int(r.uint16()))
+}
+
+// allHeaders reads the ALL_HEADERS section of batch, RPC and transaction
+// manager requests. It returns the query notification header, if any.
+func (r *reader) allHeaders() *Notification {
+	if len(r.b) < 4 {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	total := binary.LittleEndian.Uint32(r.b)
+	if int(total) > len(r.b) || total < 4 {
+		r.err = errors.New("mssqltest: invalid ALL_HEADERS length")
+		return nil
+	}
+	hr := &reader{b: r.next(int(total))[4:]}
+	var notif *Notification
+	for len(hr.b) > 0 && hr.err == nil {
+		length := hr.uint32()
+		if length < 6 {
+			hr.err = errors.New("mssqltest: invalid header length")
+			break
+		}
+		hdr := &reader{b: hr.next(int(length) - 4)}
+		if hdr.uint16() != dataStmHdrQueryNotif {
+			continue
+		}
+		notif = &Notification{}
+		notif.ID = hdr.ucs2(int(hdr.uint16()) / 2)
+		notif.Options = hdr.ucs2(int(hdr.uint16()) / 2)
+		if len(hdr.b) >= 4 {
+			notif.Timeout = time.Duration(hdr.uint32()) * time.Millisecond
+		}
+		if hdr.err != nil {
+			hr.err = hdr.err
+		}
+	}
+	if hr.err != nil {
+		r.err = hr.err
+	}
+	return notif
+}
+
+// types
+const (
+	typeNull            = 0x1f
+	typeInt1            = 0x30
+	typeBit             = 0x32
+	typeInt2            = 0x34
+	typeInt4            = 0x38
+	typeDateTim4        = 0x3a
+	typeFlt4            = 0x3b
+	typeMoney           = 0x3c
+	typeDateTime        = 0x3d
+	typeFlt8            = 0x3e
+	typeMoney4          = 0x7a
+	typeInt8            = 0x7f
+	typeGuid            = 0x24
+	typeIntN            = 0x26
+	typeBitN            = 0x68
+	typeDecimalN        = 0x6a
+	typeNumericN        = 0x6c
+	typeFltN            = 0x6d
+	typeMoneyN          = 0x6e
+	typeDateTimeN       = 0x6f
+	typeDateN           = 0x28
+	typeTimeN           = 0x29
+	typeDateTime2N      = 0x2a
+	typeDateTimeOffsetN = 0x2b
+	typeBigVarBin       = 0xa5
+	typeBigVarChar      = 0xa7
+	typeBigBinary       = 0xad
+	typeBigChar         = 0xaf
+	typeNVarChar        = 0xe7
+	typeNChar           = 0xef
+	typeXml             = 0xf1
+	typeUdt             = 0xf0
+	typeText            = 0x23
+	typeImage           = 0x22
+	typeNText           = 0x63
+	typeTvp             = 0xf3
+)
+
+type typeInfo struct {
+	id    byte
+	size  int
+	prec  uint8
+	scale uint8
+	// collation is the collation of character types
+	collation []byte
+	// typeName and columns describe a table-valued parameter
+	typeName string
+	columns  []typeInfo
+}
+
+// paramCipherInfo reads the cipher information of an encrypted parameter.
+func (r *reader) paramCipherInfo() (*ParamEncryption, error) {
+	ti, err := r.typeInfo()
+	if err != nil {
+		return nil, err
+	}
+	e := &ParamEncryption{TypeID: ti.id, Algorithm: r.byte()}
+	if e.Algorithm == 0 {
+		r.bVarChar() // custom algorithm name
+	}
+	e.EncryptionType = r.byte()
+	e.DatabaseID = r.uint32()
+	e.KeyID = r.uint32()
+	e.KeyVersion = r.uint32()
+	e.KeyMDVersion = append([]byte(nil), r.next(8)...)
+	e.NormVersion = r.byte()
+	return e, nil
+}
+
+func (r *reader) typeInfo() (ti typeInfo, err error) {
+	ti.id = r.byte()
+	switch ti.id {
+	case typeNull:
+	case typeInt1, typeBit:
+		ti.size = 1
+	case typeInt2:
+		ti.size = 2
+	case typeInt4, typeDateTim4, typeFlt4, typeMoney4:
+		ti.size = 4
+	case typeMoney, typeDateTime, typeFlt8, typeInt8:
+		ti.size = 8
+	case typeGuid, typeIntN, typeBitN, typeFltN, typeMoneyN, typeDateTimeN:
+		ti.size = int(r.byte())
+	case typeDecimalN, typeNumericN:
+		ti.size = int(r.byte())
+		ti.prec = r.byte()
+		ti.scale = r.byte()
+	case typeDateN:
+	case typeTimeN, typeDateTime2N, typeDateTimeOffsetN:
+		ti.scale = r.byte()
+	case typeBigVarBin, typeBigBinary:
+		ti.size = int(r.uint16())
+	case typeBigVarChar, typeBigChar, typeNVarChar, typeNChar:
+		ti.size = int(r.uint16())
+		ti.collation = append([]byte(nil), r.next(5)...)
+	case typeXml:
+		if r.byte() != 0 {
+			r.bVarChar()
+			r.bVarChar()
+			r.usVarChar()
+		}
+		ti.size = 0xffff
+	case typeText, typeNText, typeImage:
+		ti.size = int(r.uint32())
+		if ti.id != typeImage {
+			ti.collation = append([]byte(nil), r.next(5)...)
+		}
+	case typeTvp:
+		r.bVarChar() // database
+		schema, name := r.bVarChar(), r.bVarChar()
+		ti.typeName = name
+		if schema != "" {
+			ti.typeName = schema + "." + name
+		}
+		if n := r.uint16(); n != 0xffff {
+			for i := 0; i < int(n) && r.err == nil; i++ {
+				r.next(6) // UserType, Flags
+				col, err := r.typeInfo()
+				if err != nil {
+					return ti, err
+				}
+				r.bVarChar() // ColName
+				ti.columns = append(ti.columns, col)
+			}
+		}
+		if tok := r.byte(); tok != 0 && r.err == nil {
+			return ti, fmt.Errorf("mssqltest: unsupported TVP token 0x%x", tok)
+		}
+	default:
+		return ti, fmt.Errorf("mssqltest: unsupported parameter type 0x%x", ti.id)
+	}
+	return ti, r.err
+}
+
+// bulk reads the COLMETADATA, ROW and DONE tokens of a bulk load.
+func (r *reader) bulk() (*Bulk, error) {
+	if tok := r.byte(); tok != tokenColMetadata {
+		return nil, fmt.Errorf("mssqltest: unexpected bulk load token 0x%x", tok)
+	}
+	bulk := &Bulk{}
+	cols := make([]typeInfo, r.uint16())
+	for i := range cols {
+		r.next(6) // UserType, Flags
+		ti, err := r.typeInfo()
+		if err != nil {
+			return nil, err
+		}
+		if ti.id == typeText || ti.id == typeNText || ti.id == typeImage {
+			r.usVarChar() // TableName
+		}
+		cols[i] = ti
+		bulk.Columns = append(bulk.Columns, r.bVarChar())
+	}
+	for r.err == nil {
+		switch tok := r.byte(); tok {
+		case tokenRow:
+			row := make([]interface{}, len(cols))
+			for i, ti := range cols {
+				if ti.id == typeText || ti.id == typeNText || ti.id == typeImage {
+					// the text pointer and timestamp precede the value
+					if n := r.byte(); n == 0 {
+						continue
+					} else {
+						r.next(int(n) + 8)
+					}
+				}
+				v, err := r.value(ti)
+				if err != nil {
+					return nil, err
+				}
+				row[i] = v
+			}
+			bulk.Rows = append(bulk.Rows, row)
+		case tokenDone:
+			r.next(12)
+			return bulk, r.err
+		default:
+			return nil, fmt.Errorf("mssqltest: unexpected bulk load token 0x%x", tok)
+		}
+	}
+	return nil, r.err
+}
+
+func (ti typeInfo) isPLP() bool {
+	switch ti.id {
+	case typeBigVarBin, typeBigBinary, typeBigVarChar, typeBigChar, typeNVarChar, typeNChar:
+		return ti.size == 0xffff
+	case typeXml:
+		return true
+	}
+	return false
+}
+
+// readValue reads a parameter value described by ti.
+func (r *reader) value(ti typeInfo) (interface{}, error) {
+	var buf []byte
+	switch {
+	case ti.id == typeNull:
+		return nil, r.err
+	case ti.id == typeTvp:
+		tvp := TVP{TypeName: ti.typeName}
+		for r.byte() == 1 && r.err == nil {
+			row := make([]interface{}, len(ti.columns))
+			for i, col := range ti.columns {
+				v, err := r.value(col)
+				if err != nil {
+					return nil, err
+				}
+				row[i] = v
+			}
+			tvp.Rows = append(tvp.Rows, row)
+		}
+		return tvp, r.err
+	case ti.isPLP():
+		total := r.uint64()
+		if total == math.MaxUint64 {
+			return nil, r.err
+		}
+		buf = []byte{}
+		for {
+			n := r.uint32()
+			if n == 0 || r.err != nil {
+				break
+			}
+			buf = append(buf, r.next(int(n))...)
+		}
+	case ti.id == typeBigVarBin || ti.id == typeBigBinary || ti.id == typeBigVarChar ||
+		ti.id == typeBigChar || ti.id == typeNVarChar || ti.id == typeNChar:
+		n := r.uint16()
+		if n == 0xffff {
+			return nil, r.err
+		}
+		buf = r.next(int(n))
+	case ti.id == typeText || ti.id == typeNText || ti.id == typeImage:
+		n := r.uint32()
+		if n == 0xffffffff {
+			return nil, r.err
+		}
+		buf = r.next(int(n))
+	case ti.size > 0 && isFixed(ti.id):
+		buf = r.next(ti.size)
+	default:
+		n := r.byte()
+		if n == 0 {
+			return nil, r.err
+		}
+		buf = r.next(int(n))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return decodeValue(ti, buf)
+}
+
+func isFixed(id byte) bool {
+	switch id {
+	case typeInt1, typeBit, typeInt2, typeInt4, typeDateTim4, typeFlt4, typeMoney4,
+		typeMoney, typeDateTime, typeFlt8, typeInt8:
+		return true
+	}
+	return false
+}
+
+func decodeValue(ti typeInfo, buf []byte) (interface{}, error) {
+	switch ti.id {
+	case typeInt1, typeInt2, typeInt4, typeInt8, typeIntN:
+		switch len(buf) {
+		case 1:
+			return int64(buf[0]), nil
+		case 2:
+			return int64(int16(binary.LittleEndian.Uint16(buf))), nil
+		case 4:
+			return int64(int32(binary.LittleEndian.Uint32(buf))), nil
+		case 8:
+			return int64(binary.LittleEndian.Uint64(buf)), nil
+		}
+	case typeBit, typeBitN:
+		return buf[0] != 0, nil
+	case typeFlt4, typeFlt8, typeFltN:
+		switch len(buf) {
+		case 4:
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(buf))), nil
+		case 8:
+			return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+		}
+	case typeMoney, typeMoney4, typeMoneyN:
+		var v int64
+		switch len(buf) {
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(buf)))
+		case 8:
+			v = int64(int32(binary.LittleEndian.Uint32(buf)))<<32 | int64(binary.LittleEndian.Uint32(buf[4:]))
+		default:
+			return nil, fmt.Errorf("mssqltest: invalid money size %d", len(buf))
+		}
+		return decimalString(big.NewInt(v), 4), nil
+	case typeDecimalN, typeNumericN:
+		mag := make([]byte, len(buf)-1)
+		for i := range mag {
+			mag[i] = buf[len(buf)-1-i]
+		}
+		v := new(big.Int).SetBytes(mag)
+		if buf[0] == 0 {
+			v.Neg(v)
+		}
+		return decimalString(v, int(ti.scale)), nil
+	case typeDateTim4:
+		days := binary.LittleEndian.Uint16(buf)
+		mins := binary.LittleEndian.Uint16(buf[2:])
+		return time.Date(1900, 1, 1+int(days), 0, int(mins), 0, 0, time.UTC), nil
+	case typeDateTime, typeDateTimeN:
+		if len(buf) == 4 {
+			return decodeValue(typeInfo{id: typeDateTim4}, buf)
+		}
+		days := int32(binary.LittleEndian.Uint32(buf))
+		ticks := binary.LittleEndian.Uint32(buf[4:])
+		return time.Date(1900, 1, 1+int(days), 0, 0, 0, 0, time.UTC).Add(time.Duration(ticks) * time.Second / 300), nil
+	case typeDateN:
+		return decodeDate(buf), nil
+	case typeTimeN:
+		return time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).Add(decodeTime(ti.scale, buf)), nil
+	case typeDateTime2N:
+		n := len(buf) - 3
+		return decodeDate(buf[n:]).Add(decodeTime(ti.scale, buf[:n])), nil
+	case typeDateTimeOffsetN:
+		n := len(buf) - 5
+		offset := int(int16(binary.LittleEndian.Uint16(buf[n+3:])))
+		t := decodeDate(buf[n : n+3]).Add(decodeTime(ti.scale, buf[:n]))
+		return t.In(time.FixedZone("", offset*60)), nil
+	case typeGuid:
+		return buf, nil
+	case typeNVarChar, typeNChar, typeNText, typeXml:
+		return ucs22str(buf)
+	case typeBigVarChar, typeBigChar, typeText:
+		return string(buf), nil
+	case typeBigVarBin, typeBigBinary, typeImage:
+		return buf, nil
+	}
+	return nil, fmt.Errorf("mssqltest: cannot decode value of type 0x%x and size %d", ti.id, len(buf))
+}
+
+func decimalString(v *big.Int, scale int) string {
+	s := new(big.Int).Abs(v).String()
+	if scale > 0 {
+		for len(s) <= scale {
+			s = "0" + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if v.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+func decodeDate(buf []byte) time.Time {
+	days := int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16
+	return time.Date(1, 1, 1+days, 0, 0, 0, 0, time.UTC)
+}
+
+func decodeTime(scale uint8, buf []byte) time.Duration {
+	var ticks uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		ticks = ticks<<8 | uint64(buf[i])
+	}
+	for i := scale; i < 9; i++ {
+		ticks *= 10
+	}
+	return time.Duration(ticks)
+}
+
+func encodeDate(t time.Time) []byte {
+	days := daysSinceYearOne(t)
+	return []byte{byte(days), byte(days >> 8), byte(days >> 16)}
+}
+
+func daysSinceYearOne(t time.Time) int {
+	y := t.Year() - 1
+	return y*365 + y/4 - y/100 + y/400 + t.YearDay() - 1
+}
+
+// encodeTime encodes the time of day of t with a scale of 7.
+func encodeTime(t time.Time) []byte {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	ticks := uint64(t.Sub(midnight) / 100)
+	buf := make([]byte, 5)
+	for i := range buf {
+		buf[i] = byte(ticks)
+		ticks >>= 8
+	}
+	return buf
+}
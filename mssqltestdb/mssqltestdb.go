@@ -0,0 +1,266 @@
+// Package mssqltestdb provides SQL Server instances and isolated databases
+// for integration tests.
+//
+// Start attaches to the server given by Options.DSN or the SQLSERVER_DSN
+// environment variable, or else runs a SQL Server container with docker
+// (or the command named by the DOCKER environment variable, e.g. podman).
+// Each test then gets a database of its own:
+//
+//	var inst *mssqltestdb.Instance
+//
+//	func TestMain(m *testing.M) {
+//		var err error
+//		inst, err = mssqltestdb.Start(context.Background(), mssqltestdb.Options{})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		code := m.Run()
+//		inst.Close()
+//		os.Exit(code)
+//	}
+//
+//	func TestSomething(t *testing.T) {
+//		db, err := sql.Open("sqlserver", inst.ForTest(t))
+//		...
+//	}
+package mssqltestdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+)
+
+// DefaultImage is the container image used when Options.Image is empty.
+const DefaultImage = "mcr.microsoft.com/mssql/server:2019-latest"
+
+// Options configures Start.
+type Options struct {
+	// DSN of a running server to use instead of starting a container.
+	// When empty the SQLSERVER_DSN environment variable is consulted.
+	DSN string
+
+	// Image is the SQL Server container image to run.
+	Image string
+
+	// Password is the sa password of the container, a random one is
+	// generated when empty.
+	Password string
+
+	// StartTimeout bounds how long to wait for the server to accept
+	// logins. It defaults to two minutes.
+	StartTimeout time.Duration
+}
+
+// Instance is a SQL Server ready for use.
+type Instance struct {
+	// DSN connects to the master database of the instance.
+	DSN string
+
+	docker      string
+	containerID string
+}
+
+// Start attaches to or starts a SQL Server and waits until it accepts logins.
+func Start(ctx context.Context, opts Options) (*Instance, error) {
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = 2 * time.Minute
+	}
+	dsn := opts.DSN
+	if dsn == "" {
+		dsn = os.Getenv("SQLSERVER_DSN")
+	}
+	inst := &Instance{DSN: dsn}
+	if dsn == "" {
+		if err := inst.runContainer(ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.StartTimeout)
+	defer cancel()
+	if err := waitReady(ctx, inst.DSN); err != nil {
+		inst.Close()
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (inst *Instance) runContainer(ctx context.Context, opts Options) error {
+	inst.docker = os.Getenv("DOCKER")
+	if inst.docker == "" {
+		inst.docker = "docker"
+	}
+	password := opts.Password
+	if password == "" {
+		// upper and lower case letters and digits satisfy the password policy
+		password = "Pw1-" + randomHex(12)
+	}
+	out, err := command(ctx, inst.docker, containerArgs(opts.Image, password)...)
+	if err != nil {
+		return err
+	}
+	inst.containerID = strings.TrimSpace(out)
+	out, err = command(ctx, inst.docker, "port", inst.containerID, "1433/tcp")
+	if err != nil {
+		inst.Close()
+		return err
+	}
+	// docker may list one mapping per address family, use the first one
+	addr := strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+	addr = strings.Replace(addr, "0.0.0.0", "127.0.0.1", 1)
+	u := &url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword("sa", password),
+		Host:     addr,
+		RawQuery: "encrypt=disable",
+	}
+	inst.DSN = u.String()
+	return nil
+}
+
+func containerArgs(image, password string) []string {
+	if image == "" {
+		image = DefaultImage
+	}
+	return []string{"run", "--detach", "--rm",
+		"--env", "ACCEPT_EULA=Y",
+		"--env", "MSSQL_SA_PASSWORD=" + password,
+		"--publish", "127.0.0.1::1433",
+		image,
+	}
+}
+
+func command(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("mssqltestdb: %s %s: %v: %s", name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func waitReady(ctx context.Context, dsn string) error {
+	db, err := sql.Open("sqlserver", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("mssqltestdb: server did not become ready: %v", err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Close removes the container started by Start, if any.
+func (inst *Instance) Close() error {
+	if inst.containerID == "" {
+		return nil
+	}
+	_, err := command(context.Background(), inst.docker, "rm", "--force", inst.containerID)
+	inst.containerID = ""
+	return err
+}
+
+// CreateDatabase creates an empty database with a unique name. It returns
+// a DSN that connects to it and a function that drops it.
+func (inst *Instance) CreateDatabase(ctx context.Context) (dsn string, drop func() error, err error) {
+	name := "test_" + time.Now().UTC().Format("20060102150405") + "_" + randomHex(4)
+	db, err := sql.Open("sqlserver", inst.DSN)
+	if err != nil {
+		return "", nil, err
+	}
+	defer db.Close()
+	if _, err = db.ExecContext(ctx, "CREATE DATABASE "+quoteName(name)); err != nil {
+		return "", nil, err
+	}
+	dsn, err = withDatabase(inst.DSN, name)
+	if err != nil {
+		return "", nil, err
+	}
+	drop = func() error {
+		db, err := sql.Open("sqlserver", inst.DSN)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		_, err = db.Exec("ALTER DATABASE " + quoteName(name) + " SET SINGLE_USER WITH ROLLBACK IMMEDIATE; DROP DATABASE " + quoteName(name))
+		return err
+	}
+	return dsn, drop, nil
+}
+
+// TB is the subset of testing.TB used by ForTest.
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// ForTest creates a database for the running test and drops it when the
+// test finishes. It returns a DSN that connects to the new database.
+func (inst *Instance) ForTest(t TB) string {
+	t.Helper()
+	dsn, drop, err := inst.CreateDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("mssqltestdb: creating database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := drop(); err != nil {
+			t.Errorf("mssqltestdb: dropping database: %v", err)
+		}
+	})
+	return dsn
+}
+
+// withDatabase returns dsn changed to connect to database.
+func withDatabase(dsn, database string) (string, error) {
+	switch {
+	case strings.HasPrefix(dsn, "sqlserver://"):
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set("database", database)
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	case strings.HasPrefix(dsn, "odbc:"):
+		return strings.TrimRight(dsn, ";") + ";database={" + strings.Replace(database, "}", "}}", -1) + "}", nil
+	case strings.Contains(dsn, "="):
+		return strings.TrimRight(dsn, ";") + ";database=" + database, nil
+	}
+	return "", errors.New("mssqltestdb: unrecognized DSN format")
+}
+
+func quoteName(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,5 +1,3 @@
-// +build !windows
-
 package mssql
 
 import (
@@ -7,6 +5,7 @@ import (
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/rc4"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -14,6 +13,7 @@ import (
 	"time"
 	"unicode/utf16"
 
+	"github.com/denisenkom/go-mssqldb/msdsn"
 	//lint:ignore SA1019 MD4 is used by legacy NTLM
 	"golang.org/x/crypto/md4"
 )
@@ -50,29 +50,54 @@ const (
 )
 
 const _NEGOTIATE_FLAGS = _NEGOTIATE_UNICODE |
+	_NEGOTIATE_TARGET |
 	_NEGOTIATE_NTLM |
 	_NEGOTIATE_OEM_DOMAIN_SUPPLIED |
 	_NEGOTIATE_OEM_WORKSTATION_SUPPLIED |
 	_NEGOTIATE_ALWAYS_SIGN |
-	_NEGOTIATE_EXTENDED_SESSIONSECURITY
+	_NEGOTIATE_EXTENDED_SESSIONSECURITY |
+	_NEGOTIATE_128 |
+	_NEGOTIATE_KEY_EXCH
+
+// AV_PAIR ids of the target info
+const (
+	_MsvAvEOL             = 0x0000
+	_MsvAvFlags           = 0x0006
+	_MsvAvTimestamp       = 0x0007
+	_MsvAvChannelBindings = 0x000a
+)
+
+// _MsvAvFlagMICPresent in MsvAvFlags tells the server that the
+// authenticate message carries a MIC.
+const _MsvAvFlagMICPresent = 0x00000002
 
 type ntlmAuth struct {
 	Domain      string
 	UserName    string
 	Password    string
 	Workstation string
+	// V2Only refuses to send NTLMv1 and LM responses.
+	V2Only bool
+	// ChannelBindings is the application data of the TLS channel binding,
+	// sent in the NTLMv2 response for Extended Protection.
+	ChannelBindings []byte
+
+	// negotiateMessage is kept for the MIC.
+	negotiateMessage []byte
 }
 
-func getAuth(user, password, service, workstation string) (auth, bool) {
-	if !strings.ContainsRune(user, '\\') {
+// getNTLMAuth returns the NTLM authenticator of a DOMAIN\user user id.
+func getNTLMAuth(p msdsn.Config) (auth, bool) {
+	if !strings.ContainsRune(p.User, '\\') {
 		return nil, false
 	}
-	domain_user := strings.SplitN(user, "\\", 2)
+	domain_user := strings.SplitN(p.User, "\\", 2)
 	return &ntlmAuth{
 		Domain:      domain_user[0],
 		UserName:    domain_user[1],
-		Password:    password,
-		Workstation: workstation,
+		Password:    p.Password,
+		Workstation: p.Workstation,
+		V2Only:      p.NTLMv2Only,
 	}, true
 }
 
@@ -111,6 +136,7 @@ func (auth *ntlmAuth) InitialBytes() ([]byte, error) {
 	// Payload
 	copy(msg[40:], auth.Domain)
 	copy(msg[40+domain_len:], auth.Workstation)
+	auth.negotiateMessage = msg
 	return msg, nil
 }
 
@@ -206,7 +232,19 @@ func hmacMD5(passwordHash, data []byte) []byte {
 	return hmacEntity.Sum(nil)
 }
 
+// fileTime returns t as a little endian Windows FILETIME, the number of 100
+// nanosecond intervals since 1601.
+func fileTime(t time.Time) (ft [8]byte) {
+	const unixEpoch = 116444736000000000
+	binary.LittleEndian.PutUint64(ft[:], uint64(t.UnixNano()/100+unixEpoch))
+	return
+}
+
 func getNTLMv2AndLMv2ResponsePayloads(userDomain, username, password string, challenge, nonce [8]byte, targetInfoFields []byte, timestamp time.Time) (ntlmV2Payload, lmV2Payload []byte) {
+	return ntlmV2Responses(userDomain, username, password, challenge, nonce, targetInfoFields, fileTime(timestamp))
+}
+
+func ntlmV2Responses(userDomain, username, password string, challenge, nonce [8]byte, targetInfoFields []byte, timestamp [8]byte) (ntlmV2Payload, lmV2Payload []byte) {
 	// NTLMv2 response payload: http://davenport.sourceforge.net/ntlm.html#theNtlmv2Response
 
 	ntlmHash := ntlmHashNoPadding(password)
@@ -216,7 +254,7 @@ func getNTLMv2AndLMv2ResponsePayloads(userDomain, username, password string, cha
 	blob := make([]byte, 32+targetInfoLength)
 	binary.BigEndian.PutUint32(blob[:4], 0x01010000)
 	binary.BigEndian.PutUint32(blob[4:8], 0x00000000)
-	binary.BigEndian.PutUint64(blob[8:16], uint64(timestamp.UnixNano()))
+	copy(blob[8:16], timestamp[:])
 	copy(blob[16:24], nonce[:])
 	binary.BigEndian.PutUint32(blob[24:28], 0x00000000)
 	copy(blob[28:], targetInfoFields)
@@ -243,19 +281,6 @@ func getNTLMv2AndLMv2ResponsePayloads(userDomain, username, password string, cha
 func negotiateExtendedSessionSecurity(flags uint32, message []byte, challenge [8]byte, username, password, userDom string) (lm, nt []byte, err error) {
 	nonce := clientChallenge()
 
-	// Official specification: https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/b38c36ed-2804-4868-a9ff-8dd3182128e4
-	// Unofficial walk through referenced by https://www.freetds.org/userguide/domains.htm: http://davenport.sourceforge.net/ntlm.html
-	if (flags & _NEGOTIATE_TARGET_INFO) != 0 {
-		targetInfoFields, err := getNTLMv2TargetInfoFields(message)
-		if err != nil {
-			return lm, nt, err
-		}
-
-		nt, lm = getNTLMv2AndLMv2ResponsePayloads(userDom, username, password, challenge, nonce, targetInfoFields, time.Now())
-
-		return lm, nt, nil
-	}
-
 	var lm_bytes [24]byte
 	copy(lm_bytes[:8], nonce[:])
 	lm = lm_bytes[:]
@@ -292,7 +317,113 @@ func getNTLMv2TargetInfoFields(type2Message []byte) (info []byte, err error) {
 	return targetInformationBytes, nil
 }
 
-func buildNTLMResponsePayload(lm, nt []byte, flags uint32, domain, workstation, username string) ([]byte, error) {
+// splitAvPairs returns the AV_PAIRs of a target info, without the
+// terminating MsvAvEOL.
+func splitAvPairs(info []byte) (pairs map[uint16][]byte, order []uint16, err error) {
+	pairs = map[uint16][]byte{}
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == _MsvAvEOL {
+			return pairs, order, nil
+		}
+		if 4+length > len(info) {
+			break
+		}
+		pairs[id] = info[4 : 4+length]
+		order = append(order, id)
+		info = info[4+length:]
+	}
+	return nil, nil, errors.New("mssql: invalid NTLM target info")
+}
+
+// joinAvPairs encodes AV_PAIRs as a target info.
+func joinAvPairs(pairs map[uint16][]byte, order []uint16) []byte {
+	var info []byte
+	for _, id := range order {
+		var hdr [4]byte
+		binary.LittleEndian.PutUint16(hdr[:], id)
+		binary.LittleEndian.PutUint16(hdr[2:], uint16(len(pairs[id])))
+		info = append(info, hdr[:]...)
+		info = append(info, pairs[id]...)
+	}
+	return append(info, 0, 0, 0, 0) // MsvAvEOL
+}
+
+// authenticateV2 answers a challenge with NTLMv2 responses. When the
+// server sends its time the client is expected to protect the messages with
+// a MIC, keyed with the exported session key of the key exchange.
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/5e550938-91d4-459f-b67d-75d70009e3f3
+func (auth *ntlmAuth) authenticateV2(challengeMessage []byte, flags uint32, challenge [8]byte) ([]byte, error) {
+	info, err := getNTLMv2TargetInfoFields(challengeMessage)
+	if err != nil {
+		return nil, err
+	}
+	pairs, order, err := splitAvPairs(info)
+	if err != nil {
+		return nil, err
+	}
+	var timestamp [8]byte
+	ts, withMIC := pairs[_MsvAvTimestamp]
+	if withMIC && len(ts) == 8 {
+		copy(timestamp[:], ts)
+		var avFlags [4]byte
+		if f, ok := pairs[_MsvAvFlags]; ok && len(f) == 4 {
+			copy(avFlags[:], f)
+		} else {
+			order = append(order, _MsvAvFlags)
+		}
+		binary.LittleEndian.PutUint32(avFlags[:], binary.LittleEndian.Uint32(avFlags[:])|_MsvAvFlagMICPresent)
+		pairs[_MsvAvFlags] = avFlags[:]
+	} else {
+		withMIC = false
+		timestamp = fileTime(time.Now())
+	}
+	if auth.ChannelBindings != nil {
+		if _, ok := pairs[_MsvAvChannelBindings]; !ok {
+			order = append(order, _MsvAvChannelBindings)
+		}
+		pairs[_MsvAvChannelBindings] = channelBindingsHash(auth.ChannelBindings)
+	}
+
+	nt, lm := ntlmV2Responses(auth.Domain, auth.UserName, auth.Password, challenge, clientChallenge(), joinAvPairs(pairs, order), timestamp)
+	if withMIC {
+		// the LMv2 response is replaced by zeros when the MIC is sent
+		lm = make([]byte, 24)
+	}
+
+	ntlmV2Hash := hmacMD5(ntlmHashNoPadding(auth.Password), utf16le(strings.ToUpper(auth.UserName)+auth.Domain))
+	sessionBaseKey := hmacMD5(ntlmV2Hash, nt[:16])
+	exportedSessionKey := sessionBaseKey
+	var encryptedSessionKey []byte
+	if flags&_NEGOTIATE_KEY_EXCH != 0 {
+		exportedSessionKey = make([]byte, 16)
+		if _, err = rand.Read(exportedSessionKey); err != nil {
+			return nil, err
+		}
+		cipher, err := rc4.NewCipher(sessionBaseKey)
+		if err != nil {
+			return nil, err
+		}
+		encryptedSessionKey = make([]byte, 16)
+		cipher.XORKeyStream(encryptedSessionKey, exportedSessionKey)
+	}
+
+	msg, err := buildNTLMResponsePayload(lm, nt, flags, auth.Domain, auth.Workstation, auth.UserName, encryptedSessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if withMIC {
+		mic := hmac.New(md5.New, exportedSessionKey)
+		mic.Write(auth.negotiateMessage)
+		mic.Write(challengeMessage)
+		mic.Write(msg)
+		copy(msg[72:88], mic.Sum(nil))
+	}
+	return msg, nil
+}
+
+func buildNTLMResponsePayload(lm, nt []byte, flags uint32, domain, workstation, username string, encryptedSessionKey []byte) ([]byte, error) {
 	lm_len := len(lm)
 	nt_len := len(nt)
 	domain16 := utf16le(domain)
@@ -301,7 +432,8 @@ func buildNTLMResponsePayload(lm, nt []byte, flags uint32, domain, workstation,
 	user_len := len(user16)
 	workstation16 := utf16le(workstation)
 	workstation_len := len(workstation16)
-	msg := make([]byte, 88+lm_len+nt_len+domain_len+user_len+workstation_len)
+	key_len := len(encryptedSessionKey)
+	msg := make([]byte, 88+lm_len+nt_len+domain_len+user_len+workstation_len+key_len)
 	copy(msg, []byte("NTLMSSP\x00"))
 	binary.LittleEndian.PutUint32(msg[8:], _AUTHENTICATE_MESSAGE)
 
@@ -331,8 +463,8 @@ func buildNTLMResponsePayload(lm, nt []byte, flags uint32, domain, workstation,
 	binary.LittleEndian.PutUint32(msg[48:], uint32(88+lm_len+nt_len+domain_len+user_len))
 
 	// Encrypted Random Session Key Fields
-	binary.LittleEndian.PutUint16(msg[52:], 0)
-	binary.LittleEndian.PutUint16(msg[54:], 0)
+	binary.LittleEndian.PutUint16(msg[52:], uint16(key_len))
+	binary.LittleEndian.PutUint16(msg[54:], uint16(key_len))
 	binary.LittleEndian.PutUint32(msg[56:], uint32(88+lm_len+nt_len+domain_len+user_len+workstation_len))
 
 	// Negotiate Flags
@@ -354,10 +486,15 @@ func buildNTLMResponsePayload(lm, nt []byte, flags uint32, domain, workstation,
 	copy(msg[88+lm_len+nt_len:], domain16)
 	copy(msg[88+lm_len+nt_len+domain_len:], user16)
 	copy(msg[88+lm_len+nt_len+domain_len+user_len:], workstation16)
+	copy(msg[88+lm_len+nt_len+domain_len+user_len+workstation_len:], encryptedSessionKey)
 
 	return msg, nil
 }
 
+func (auth *ntlmAuth) setChannelBindings(applicationData []byte) {
+	auth.ChannelBindings = applicationData
+}
+
 func (auth *ntlmAuth) NextBytes(bytes []byte) ([]byte, error) {
 	signature := string(bytes[0:8])
 	if signature != "NTLMSSP\x00" {
@@ -372,13 +509,25 @@ func (auth *ntlmAuth) NextBytes(bytes []byte) ([]byte, error) {
 	var challenge [8]byte
 	copy(challenge[:], bytes[24:32])
 	flags := binary.LittleEndian.Uint32(bytes[20:24])
+
+	// Official specification: https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/b38c36ed-2804-4868-a9ff-8dd3182128e4
+	// Unofficial walk through referenced by https://www.freetds.org/userguide/domains.htm: http://davenport.sourceforge.net/ntlm.html
+	if (flags & _NEGOTIATE_TARGET_INFO) != 0 {
+		return auth.authenticateV2(bytes, flags, challenge)
+	}
+	if auth.V2Only {
+		return nil, errors.New("mssql: the server does not offer NTLMv2 and NTLMv1 is disabled by ntlmv2only")
+	}
+	// the session key is only exchanged with NTLMv2
+	flags &^= _NEGOTIATE_KEY_EXCH
+
 	if (flags & _NEGOTIATE_EXTENDED_SESSIONSECURITY) != 0 {
 		lm, nt, err := negotiateExtendedSessionSecurity(flags, bytes, challenge, auth.UserName, auth.Password, auth.Domain)
 		if err != nil {
 			return nil, err
 		}
 
-		return buildNTLMResponsePayload(lm, nt, flags, auth.Domain, auth.Workstation, auth.UserName)
+		return buildNTLMResponsePayload(lm, nt, flags, auth.Domain, auth.Workstation, auth.UserName, nil)
 	}
 
 	lm_bytes := lmResponse(challenge, auth.Password)
@@ -386,7 +535,7 @@ func (auth *ntlmAuth) NextBytes(bytes []byte) ([]byte, error) {
 	nt_bytes := ntResponse(challenge, auth.Password)
 	nt := nt_bytes[:]
 
-	return buildNTLMResponsePayload(lm, nt, flags, auth.Domain, auth.Workstation, auth.UserName)
+	return buildNTLMResponsePayload(lm, nt, flags, auth.Domain, auth.Workstation, auth.UserName, nil)
 }
 
 func (auth *ntlmAuth) Free() {
@@ -1,12 +1,17 @@
-// +build !windows
-
 package mssql
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
 	"encoding/hex"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
 )
 
 func TestLMOWFv1(t *testing.T) {
@@ -86,12 +91,12 @@ func TestNTLMV2Response(t *testing.T) {
 	nonceBytes, _ := hex.DecodeString("ffffff0011223344")
 	var nonce [8]byte
 	copy(nonce[:8], nonceBytes[:])
-	timestamp, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
+	timestamp, err := time.Parse(time.RFC3339, "2003-06-17T10:00:00Z")
 	if err != nil {
 		panic(err)
 	}
 
-	expectedNTLMV2Response, _ := hex.DecodeString("5c788afec59c1fef3f90bf6ea419c02501010000000000000fc4a4d5fdf6b200ffffff00112233440000000002000c0044004f004d00410049004e0001000c005300450052005600450052000400140064006f006d00610069006e002e0063006f006d00030022007300650072007600650072002e0064006f006d00610069006e002e0063006f006d000000000000000000")
+	expectedNTLMV2Response, _ := hex.DecodeString("cbabbca713eb795d04c97abc01ee498301010000000000000090d336b734c301ffffff00112233440000000002000c0044004f004d00410049004e0001000c005300450052005600450052000400140064006f006d00610069006e002e0063006f006d00030022007300650072007600650072002e0064006f006d00610069006e002e0063006f006d000000000000000000")
 	expectedLMV2Response, _ := hex.DecodeString("d6e6152ea25d03b7c6ba6629c2d6aaf0ffffff0011223344")
 	ntlmV2Response, lmV2Response := getNTLMv2AndLMv2ResponsePayloads(target, username, password, challenge, nonce, targetInformationBlock, timestamp)
 	if !bytes.Equal(ntlmV2Response, expectedNTLMV2Response) {
@@ -124,3 +129,108 @@ func TestGetNTLMv2TargetInfoFieldsInvalidMessage(t *testing.T) {
 		t.Error("expected to get an error")
 	}
 }
+
+func TestNTLMv2MICAndKeyExchange(t *testing.T) {
+	challengeMessage, _ := hex.DecodeString("4e544c4d53535000020000000600060038000000058289026999bc21067c77f40000000000000000ac00ac003e0000000a0039380000000f4600570042000200060046005700420001000c00590037004100410041003400040022006000700065002e00610058006e0071006e0070006e00650074002e0063006f006d00030030007900370041004100410034002e006000700065002e00610058006e0071006e0070006e00650074002e0063006f006d00050024006100610058006d002e00610058006e0071006e0070006e00650074002e0063006f006d00070008007d9647e8aed6d50100000000")
+	challengeMessage[23] |= _NEGOTIATE_KEY_EXCH >> 24
+
+	auth := &ntlmAuth{Domain: "DOMAIN", UserName: "user", Password: "SecREt01", Workstation: "WS"}
+	negotiateMessage, err := auth.InitialBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := auth.NextBytes(challengeMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	field := func(offset int) []byte {
+		l := binary.LittleEndian.Uint16(msg[offset:])
+		o := binary.LittleEndian.Uint32(msg[offset+4:])
+		return msg[o : o+uint32(l)]
+	}
+	lm, nt, encryptedKey := field(12), field(20), field(52)
+	if !bytes.Equal(lm, make([]byte, 24)) {
+		t.Errorf("LMv2 response should be zeroed when a MIC is sent, got %x", lm)
+	}
+	if !bytes.Equal(nt[24:32], challengeMessage[len(challengeMessage)-12:len(challengeMessage)-4]) {
+		t.Errorf("the NTLMv2 response should use the server timestamp, got %x", nt[24:32])
+	}
+	pairs, _, err := splitAvPairs(nt[44:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := pairs[_MsvAvFlags]; len(f) != 4 || binary.LittleEndian.Uint32(f)&_MsvAvFlagMICPresent == 0 {
+		t.Errorf("MsvAvFlags should announce the MIC, got %x", f)
+	}
+
+	ntlmV2Hash := hmacMD5(ntlmHashNoPadding(auth.Password), utf16le("USER"+auth.Domain))
+	cipher, err := rc4.NewCipher(hmacMD5(ntlmV2Hash, nt[:16]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportedKey := make([]byte, 16)
+	cipher.XORKeyStream(exportedKey, encryptedKey)
+
+	withoutMIC := append([]byte(nil), msg...)
+	copy(withoutMIC[72:88], make([]byte, 16))
+	mic := hmac.New(md5.New, exportedKey)
+	mic.Write(negotiateMessage)
+	mic.Write(challengeMessage)
+	mic.Write(withoutMIC)
+	if !bytes.Equal(mic.Sum(nil), msg[72:88]) {
+		t.Errorf("invalid MIC %x", msg[72:88])
+	}
+}
+
+func TestNTLMv2OnlyRefusesNTLMv1(t *testing.T) {
+	challengeMessage, _ := hex.DecodeString("4e544c4d53535000020000000000000000000000058208000123456789abcdef0000000000000000")
+
+	auth := &ntlmAuth{Domain: "DOMAIN", UserName: "user", Password: "SecREt01", V2Only: true}
+	if _, err := auth.NextBytes(challengeMessage); err == nil {
+		t.Error("expected an error for a challenge without NTLMv2 target info")
+	}
+
+	auth.V2Only = false
+	if _, err := auth.NextBytes(challengeMessage); err != nil {
+		t.Errorf("NTLMv1 should be accepted without ntlmv2only, got %v", err)
+	}
+}
+
+func TestNTLMv2ChannelBindings(t *testing.T) {
+	challengeMessage, _ := hex.DecodeString("4e544c4d53535000020000000600060038000000058289026999bc21067c77f40000000000000000ac00ac003e0000000a0039380000000f4600570042000200060046005700420001000c00590037004100410041003400040022006000700065002e00610058006e0071006e0070006e00650074002e0063006f006d00030030007900370041004100410034002e006000700065002e00610058006e0071006e0070006e00650074002e0063006f006d00050024006100610058006d002e00610058006e0071006e0070006e00650074002e0063006f006d00070008007d9647e8aed6d50100000000")
+
+	auth := &ntlmAuth{Domain: "DOMAIN", UserName: "user", Password: "SecREt01"}
+	auth.setChannelBindings([]byte("tls-server-end-point:abc"))
+	if _, err := auth.InitialBytes(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := auth.NextBytes(challengeMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ntLen := binary.LittleEndian.Uint16(msg[20:])
+	ntOffset := binary.LittleEndian.Uint32(msg[24:])
+	nt := msg[ntOffset : ntOffset+uint32(ntLen)]
+	pairs, _, err := splitAvPairs(nt[44:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb := pairs[_MsvAvChannelBindings]; !bytes.Equal(cb, channelBindingsHash(auth.ChannelBindings)) {
+		t.Errorf("unexpected MsvAvChannelBindings %x", cb)
+	}
+}
+
+func TestGetNTLMAuth(t *testing.T) {
+	a, ok := getNTLMAuth(msdsn.Config{User: "DOMAIN\\user", Password: "pwd", Workstation: "WS", NTLMv2Only: true})
+	if !ok {
+		t.Fatal("expected NTLM for a DOMAIN\\user user id")
+	}
+	expected := &ntlmAuth{Domain: "DOMAIN", UserName: "user", Password: "pwd", Workstation: "WS", V2Only: true}
+	if !reflect.DeepEqual(a, expected) {
+		t.Errorf("got %+v, expected %+v", a, expected)
+	}
+	if _, ok = getNTLMAuth(msdsn.Config{User: "user"}); ok {
+		t.Error("expected no NTLM without a domain")
+	}
+}
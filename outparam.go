@@ -0,0 +1,87 @@
+package mssql
+
+import "fmt"
+
+// OutParam is an output parameter declared with a length, or a precision
+// and a scale, see OutWithSize and OutWithPrecision. sql.Out declares the
+// type of the value of its destination, such as nvarchar(5) for the
+// string "hello", which truncates longer outputs.
+type OutParam struct {
+	// Dest is a pointer to the destination of the output, its value is
+	// passed as input.
+	Dest interface{}
+	// Size is the length of nvarchar, varchar and varbinary parameters, in
+	// characters or bytes, -1 for max. Zero keeps the length of the value.
+	Size int
+	// Precision and Scale declare decimal parameters. Zero Precision keeps
+	// decimal(38, s), s being the scale of the value.
+	Precision int
+	Scale     int
+}
+
+// OutWithSize returns an output parameter scanned into dest and declared
+// with the length size, -1 for max:
+//
+//	var name string
+//	_, err = db.ExecContext(ctx, "sp_name", sql.Named("name", mssql.OutWithSize(&name, 4000)))
+func OutWithSize(dest interface{}, size int) OutParam {
+	return OutParam{Dest: dest, Size: size}
+}
+
+// OutWithPrecision returns an output parameter scanned into dest, a *Decimal
+// or a *NullDecimal, and declared decimal(precision, scale).
+func OutWithPrecision(dest interface{}, precision, scale int) OutParam {
+	return OutParam{Dest: dest, Precision: precision, Scale: scale}
+}
+
+// param returns the parameter of the value of the destination of p, which
+// CheckNamedValue set in Dest.
+func (p OutParam) param(s *Stmt) (res param, err error) {
+	v := p.Dest
+	if p.Precision != 0 {
+		if p.Precision < 1 || p.Precision > maxDecimalDigits || p.Scale < 0 || p.Scale > p.Precision {
+			return res, fmt.Errorf("mssql: invalid output parameter decimal(%d, %d)", p.Precision, p.Scale)
+		}
+		switch d := v.(type) {
+		case Decimal:
+			v, err = d.rescale(p.Precision, p.Scale)
+		case NullDecimal:
+			d.Decimal, err = d.Decimal.rescale(p.Precision, p.Scale)
+			v = d
+		default:
+			return res, fmt.Errorf("mssql: the precision of an output parameter applies to Decimal and NullDecimal destinations, not %T", v)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	if res, err = s.makeParam(v); err != nil {
+		return res, err
+	}
+	res.Flags = fByRevValue
+	if p.Precision != 0 {
+		res.ti.Prec = uint8(p.Precision)
+	}
+	if p.Size == 0 {
+		return res, nil
+	}
+	unit, max := 1, 8000
+	switch res.ti.TypeId {
+	case typeNVarChar:
+		unit, max = 2, 4000
+	case typeBigVarChar, typeBigVarBin:
+	default:
+		return res, fmt.Errorf("mssql: the size of an output parameter does not apply to %s", makeDecl(res.ti))
+	}
+	switch {
+	case p.Size == -1 || p.Size > max:
+		res.ti.Size = 0 // max
+	case p.Size < 0:
+		return res, fmt.Errorf("mssql: invalid output parameter size %d", p.Size)
+	case len(res.buffer) > p.Size*unit:
+		return res, fmt.Errorf("mssql: the value of an output parameter is longer than its size %d", p.Size)
+	default:
+		res.ti.Size = p.Size * unit
+	}
+	return res, nil
+}
@@ -0,0 +1,206 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestOutputParamTypes(t *testing.T) {
+	guid := [16]byte{0x67, 0x45, 0x23, 0x01, 0xab, 0x89, 0xef, 0xcd, 1, 2, 3, 4, 5, 6, 7, 8}
+	when := time.Date(2020, 1, 2, 3, 4, 5, 600, time.FixedZone("", 2*3600))
+	null := false
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		out := func(name string, typ mssqltest.Type, v interface{}) mssqltest.Response {
+			if null {
+				v = nil
+			}
+			return mssqltest.OutputParam{Name: name, Type: typ, Value: v}
+		}
+		return []mssqltest.Response{
+			out("@dec", mssqltest.Decimal, "-123.4500"),
+			out("@f", mssqltest.Decimal, "1.5000"),
+			out("@guid", mssqltest.UniqueIdentifier, guid),
+			out("@dto", mssqltest.DateTimeOffset, when),
+			out("@t", mssqltest.DateTimeOffset, when),
+		}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	dec, _ := ParseDecimal("0.0000")
+	var f float64
+	var u UniqueIdentifier
+	var dto DateTimeOffset
+	var tm time.Time
+	_, err = db.Exec("p",
+		sql.Named("dec", sql.Out{Dest: &dec}),
+		sql.Named("f", sql.Out{Dest: &f}),
+		sql.Named("guid", sql.Out{Dest: &u}),
+		sql.Named("dto", sql.Out{Dest: &dto}),
+		sql.Named("t", sql.Out{Dest: &tm}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.String() != "-123.4500" {
+		t.Errorf("expected the decimal -123.4500, got %s", dec)
+	}
+	if f != 1.5 {
+		t.Errorf("expected the float 1.5, got %v", f)
+	}
+	if u.String() != "01234567-89AB-CDEF-0102-030405060708" {
+		t.Errorf("unexpected uniqueidentifier %s", u)
+	}
+	if !time.Time(dto).Equal(when) || !tm.Equal(when) {
+		t.Errorf("expected the time %v, got %v and %v", when, time.Time(dto), tm)
+	}
+	if _, offset := time.Time(dto).Zone(); offset != 2*3600 {
+		t.Errorf("expected the offset of the datetimeoffset kept, got %d", offset)
+	}
+
+	ndec := NullDecimal{Decimal: dec, Valid: true}
+	nu := NullUniqueIdentifier{}
+	ndto := NullDateTimeOffset{}
+	for _, null = range []bool{false, true} {
+		_, err = db.Exec("p",
+			sql.Named("dec", sql.Out{Dest: &ndec}),
+			sql.Named("guid", sql.Out{Dest: &nu}),
+			sql.Named("dto", sql.Out{Dest: &ndto}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ndec.Valid == null || nu.Valid == null || ndto.Valid == null {
+			t.Errorf("null %v: got valid %v, %v and %v", null, ndec.Valid, nu.Valid, ndto.Valid)
+		}
+		if !null && (ndec.Decimal.String() != "-123.4500" || nu.UUID != u || !ndto.Time.Equal(when)) {
+			t.Errorf("unexpected values %s, %s and %v", ndec.Decimal, nu.UUID, ndto.Time)
+		}
+	}
+
+	// the null destinations of the first call are passed as typed nulls
+	reqs := srv.Requests()
+	for _, name := range []string{"@guid", "@dto"} {
+		if p := reqs[len(reqs)-2].Param(name); !p.Output || p.Value != nil {
+			t.Errorf("expected %s to be a null output parameter, got %v", name, p.Value)
+		}
+	}
+}
+
+func TestNullOutputParamTypes(t *testing.T) {
+	s := &Stmt{c: &Conn{sess: &tdsSession{}}}
+	dec, _ := ParseDecimal("1.25")
+	for _, tt := range []struct {
+		value interface{}
+		decl  string
+	}{
+		{NullDecimal{Decimal: dec}, "decimal(38, 2)"},
+		{NullUniqueIdentifier{}, "uniqueidentifier"},
+		{NullDateTimeOffset{}, "datetimeoffset(7)"},
+	} {
+		p, err := s.makeParam(tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decl := makeDecl(p.ti); decl != tt.decl {
+			t.Errorf("%T: expected %s, got %s", tt.value, tt.decl, decl)
+		}
+		if len(p.buffer) != 0 {
+			t.Errorf("%T: expected a null value, got %X", tt.value, p.buffer)
+		}
+	}
+}
+
+func TestOutParamDecl(t *testing.T) {
+	s := &Stmt{c: &Conn{sess: &tdsSession{}}}
+	dec, _ := ParseDecimal("1.25")
+	for _, tt := range []struct {
+		out  OutParam
+		decl string
+		err  string
+	}{
+		{OutWithSize("abc", 4000), "nvarchar(4000)", ""},
+		{OutWithSize("abc", -1), "nvarchar(max)", ""},
+		{OutWithSize("abc", 5000), "nvarchar(max)", ""},
+		{OutWithSize(VarChar("abc"), 8000), "varchar(8000)", ""},
+		{OutWithSize([]byte{1}, 16), "varbinary(16)", ""},
+		{OutWithSize("abcdef", 3), "", "longer than its size 3"},
+		{OutWithSize(int64(1), 10), "", "does not apply to bigint"},
+		{OutWithPrecision(dec, 10, 4), "decimal(10, 4)", ""},
+		{OutWithPrecision(NullDecimal{}, 38, 10), "decimal(38, 10)", ""},
+		{OutWithPrecision(dec, 2, 2), "", "does not fit decimal(2, 2)"},
+		{OutWithPrecision(dec, 39, 0), "", "invalid output parameter decimal(39, 0)"},
+		{OutWithPrecision(1.5, 10, 2), "", "applies to Decimal and NullDecimal destinations"},
+	} {
+		p, err := tt.out.param(s)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%v: expected the error %q, got %v", tt.out, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.out, err)
+			continue
+		}
+		if decl := makeDecl(p.ti); decl != tt.decl {
+			t.Errorf("%v: expected %s, got %s", tt.out, tt.decl, decl)
+		}
+		if p.Flags&fByRevValue == 0 {
+			t.Errorf("%v: not an output parameter", tt.out)
+		}
+	}
+
+	// the value is rescaled to the declared scale
+	p, err := OutWithPrecision(dec, 10, 4).param(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.buffer[0] != 1 || p.buffer[1] != 0xd4 || p.buffer[2] != 0x30 {
+		t.Errorf("expected 12500, got %X", p.buffer)
+	}
+}
+
+func TestOutParam(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{
+			mssqltest.OutputParam{Name: "@s", Type: mssqltest.NVarChar, Value: long},
+			mssqltest.OutputParam{Name: "@d", Type: mssqltest.Decimal, Value: "12.3456"},
+		}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	s := "in"
+	var d Decimal
+	if _, err = db.Exec("p", sql.Named("s", OutWithSize(&s, -1)), sql.Named("d", OutWithPrecision(&d, 20, 4))); err != nil {
+		t.Fatal(err)
+	}
+	if s != long || d.String() != "12.3456" {
+		t.Errorf("unexpected outputs of lengths %d and %s", len(s), d)
+	}
+	reqs := srv.Requests()
+	if p := reqs[len(reqs)-1].Param("@s"); !p.Output || p.Value != "in" {
+		t.Errorf("expected the output parameter passed in, got %v", p.Value)
+	}
+	if _, err = db.Exec("p", sql.Named("s", OutWithSize(s, 10))); err == nil {
+		t.Error("expected an error for a destination that is not a pointer")
+	}
+}
@@ -0,0 +1,325 @@
+// Package paging reads the result of a query one page at a time, for
+// paginated APIs.
+//
+// The query is wrapped in a derived table that is ordered by the columns
+// of a Query and limited to a page. Each page returns an opaque token that
+// continues after its last row, clients pass it back to read the next page.
+//
+// Keyset paging, the default, continues after the ordering values of the
+// last row, it stays fast deep into the result and does not skip or repeat
+// rows when rows are inserted or deleted between pages. The ordering
+// columns must be returned by the query, must not be NULL and must be
+// unique together, e.g. end with the primary key. Offset paging counts
+// rows with OFFSET and FETCH and supports any ordering.
+package paging
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Querier runs queries, it is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// ErrInvalidToken is returned for tokens that are malformed or were
+// returned for a query with a different order.
+var ErrInvalidToken = errors.New("paging: invalid continuation token")
+
+// OrderBy is an ordering column.
+type OrderBy struct {
+	// Column is a column name of the result of the query.
+	Column string
+	Desc   bool
+}
+
+// Query is a paginated query.
+type Query struct {
+	// SQL is the query, without ORDER BY. It may refer to the positional
+	// parameters @p1 to @pN and to named parameters.
+	SQL  string
+	Args []interface{}
+	// Order lists the ordering columns.
+	Order []OrderBy
+	// PageSize is the maximum number of rows of a page, it defaults to 100.
+	PageSize int
+	// Offset selects offset paging instead of keyset paging.
+	Offset bool
+}
+
+// Fetch reads the page that starts at token, or the first page for an
+// empty token. It calls scan for every row of the page, scan reads the row
+// with rows.Scan and must not advance rows.
+//
+// It returns the token of the next page, which is empty on the last page.
+func Fetch(ctx context.Context, q Querier, query Query, token string, scan func(rows *sql.Rows) error) (next string, err error) {
+	if len(query.Order) == 0 {
+		return "", errors.New("paging: an order is required")
+	}
+	size := query.PageSize
+	if size <= 0 {
+		size = 100
+	}
+	var t pageToken
+	if token != "" {
+		if t, err = decodeToken(token); err != nil {
+			return "", err
+		}
+		valid := t.Keys == nil && t.Offset > 0
+		if !query.Offset {
+			valid = t.Keys != nil && t.Offset == 0
+		}
+		if !valid || t.Order != query.orderHash() {
+			return "", ErrInvalidToken
+		}
+	}
+	text, args, err := query.build(t, size)
+	if err != nil {
+		return "", err
+	}
+	rows, err := q.QueryContext(ctx, text, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	keyIndex, err := query.keyIndex(cols)
+	if err != nil {
+		return "", err
+	}
+
+	n := 0
+	var last []interface{}
+	for rows.Next() {
+		if n == size {
+			// a row past the page, there is a next page
+			nt := pageToken{Order: query.orderHash()}
+			if query.Offset {
+				nt.Offset = t.Offset + int64(size)
+			} else if nt.Keys, err = encodeValues(last); err != nil {
+				return "", err
+			}
+			next = nt.encode()
+			break
+		}
+		n++
+		if err = scan(rows); err != nil {
+			return "", err
+		}
+		if !query.Offset {
+			if last, err = scanKeys(rows, len(cols), keyIndex); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return next, nil
+}
+
+// build returns the wrapped query and its arguments.
+func (query *Query) build(t pageToken, size int) (string, []interface{}, error) {
+	var b strings.Builder
+	args := append([]interface{}(nil), query.Args...)
+	if query.Offset {
+		b.WriteString("SELECT * FROM (")
+	} else {
+		fmt.Fprintf(&b, "SELECT TOP (%d) * FROM (", size+1)
+	}
+	b.WriteString(query.SQL)
+	b.WriteString("\n) AS [page]")
+
+	if t.Keys != nil {
+		keys, err := decodeValues(t.Keys)
+		if err != nil || len(keys) != len(query.Order) {
+			return "", nil, ErrInvalidToken
+		}
+		first := len(args) + 1
+		args = append(args, keys...)
+		// (a > @a) OR (a = @a AND b > @b) OR ...
+		b.WriteString(" WHERE ")
+		for i := range query.Order {
+			if i > 0 {
+				b.WriteString(" OR ")
+			}
+			b.WriteByte('(')
+			for j := 0; j <= i; j++ {
+				o := query.Order[j]
+				op := " = "
+				if j == i {
+					op = " > "
+					if o.Desc {
+						op = " < "
+					}
+				}
+				if j > 0 {
+					b.WriteString(" AND ")
+				}
+				fmt.Fprintf(&b, "[page].%s%s@p%d", quoteName(o.Column), op, first+j)
+			}
+			b.WriteByte(')')
+		}
+	}
+
+	b.WriteString(" ORDER BY ")
+	for i, o := range query.Order {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("[page].")
+		b.WriteString(quoteName(o.Column))
+		if o.Desc {
+			b.WriteString(" DESC")
+		}
+	}
+	if query.Offset {
+		fmt.Fprintf(&b, " OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", t.Offset, size+1)
+	}
+	return b.String(), args, nil
+}
+
+// keyIndex returns the positions of the ordering columns in the result.
+func (query *Query) keyIndex(cols []string) ([]int, error) {
+	if query.Offset {
+		return nil, nil
+	}
+	idx := make([]int, len(query.Order))
+	for i, o := range query.Order {
+		idx[i] = -1
+		for j, c := range cols {
+			if strings.EqualFold(c, o.Column) {
+				idx[i] = j
+				break
+			}
+		}
+		if idx[i] < 0 {
+			return nil, fmt.Errorf("paging: the query does not return the ordering column %q", o.Column)
+		}
+	}
+	return idx, nil
+}
+
+// orderHash identifies the order of a query in tokens, so that a token is
+// not used with a different query by mistake.
+func (query *Query) orderHash() uint32 {
+	h := sha1.New()
+	for _, o := range query.Order {
+		fmt.Fprintf(h, "%s %t\n", strings.ToLower(o.Column), o.Desc)
+	}
+	return binary.BigEndian.Uint32(h.Sum(nil))
+}
+
+// scanKeys reads the ordering values of the current row, rows can be
+// scanned more than once.
+func scanKeys(rows *sql.Rows, n int, idx []int) ([]interface{}, error) {
+	dest := make([]interface{}, n)
+	vals := make([]interface{}, n)
+	for i := range dest {
+		dest[i] = &vals[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, len(idx))
+	for i, j := range idx {
+		if vals[j] == nil {
+			return nil, errors.New("paging: an ordering column is NULL")
+		}
+		keys[i] = vals[j]
+	}
+	return keys, nil
+}
+
+type pageToken struct {
+	Order  uint32       `json:"o"`
+	Offset int64        `json:"n,omitempty"`
+	Keys   []typedValue `json:"k,omitempty"`
+}
+
+// typedValue is a key value that keeps its type through JSON.
+type typedValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+func (t pageToken) encode() string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeToken(s string) (pageToken, error) {
+	var t pageToken
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &t) != nil || t.Offset < 0 {
+		return t, ErrInvalidToken
+	}
+	return t, nil
+}
+
+func encodeValues(vals []interface{}) ([]typedValue, error) {
+	res := make([]typedValue, len(vals))
+	for i, v := range vals {
+		switch v := v.(type) {
+		case int64:
+			res[i] = typedValue{"i", strconv.FormatInt(v, 10)}
+		case float64:
+			res[i] = typedValue{"f", strconv.FormatFloat(v, 'g', -1, 64)}
+		case bool:
+			res[i] = typedValue{"b", strconv.FormatBool(v)}
+		case string:
+			res[i] = typedValue{"s", v}
+		case []byte:
+			res[i] = typedValue{"x", base64.RawURLEncoding.EncodeToString(v)}
+		case time.Time:
+			res[i] = typedValue{"d", v.Format(time.RFC3339Nano)}
+		default:
+			return nil, fmt.Errorf("paging: unsupported ordering column type %T", v)
+		}
+	}
+	return res, nil
+}
+
+func decodeValues(vals []typedValue) ([]interface{}, error) {
+	res := make([]interface{}, len(vals))
+	var err error
+	for i, v := range vals {
+		switch v.Type {
+		case "i":
+			res[i], err = strconv.ParseInt(v.Value, 10, 64)
+		case "f":
+			res[i], err = strconv.ParseFloat(v.Value, 64)
+		case "b":
+			res[i], err = strconv.ParseBool(v.Value)
+		case "s":
+			res[i] = v.Value
+		case "x":
+			res[i], err = base64.RawURLEncoding.DecodeString(v.Value)
+		case "d":
+			res[i], err = time.Parse(time.RFC3339Nano, v.Value)
+		default:
+			err = ErrInvalidToken
+		}
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+	}
+	return res, nil
+}
+
+func quoteName(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
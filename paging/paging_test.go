@@ -0,0 +1,108 @@
+package paging
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestFetchKeyset(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		rs := mssqltest.ResultSet{Columns: []mssqltest.Column{
+			{Name: "id", Type: mssqltest.Int},
+			{Name: "name", Type: mssqltest.NVarChar},
+		}}
+		if len(req.Params) == 1 {
+			rs.Rows = [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}}
+		} else {
+			rs.Rows = [][]interface{}{{int64(3), "c"}}
+		}
+		return []mssqltest.Response{rs}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	query := Query{
+		SQL:      "SELECT id, name FROM users WHERE active = @p1",
+		Args:     []interface{}{true},
+		Order:    []OrderBy{{Column: "name", Desc: true}, {Column: "id"}},
+		PageSize: 2,
+	}
+	var names []string
+	scan := func(rows *sql.Rows) error {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	}
+	next, err := Fetch(context.Background(), db, query, "", scan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == "" || len(names) != 2 {
+		t.Fatalf("expected a full first page and a token, got %v, %q", names, next)
+	}
+	reqs := srv.Requests()
+	want := "SELECT TOP (3) * FROM (SELECT id, name FROM users WHERE active = @p1\n) AS [page] ORDER BY [page].[name] DESC, [page].[id]"
+	if got := reqs[len(reqs)-1].SQL; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if next, err = Fetch(context.Background(), db, query, next, scan); err != nil {
+		t.Fatal(err)
+	}
+	if next != "" || len(names) != 3 {
+		t.Errorf("expected the last page, got %v, %q", names, next)
+	}
+	reqs = srv.Requests()
+	req := reqs[len(reqs)-1]
+	want = "SELECT TOP (3) * FROM (SELECT id, name FROM users WHERE active = @p1\n) AS [page] WHERE ([page].[name] < @p2) OR ([page].[name] = @p2 AND [page].[id] > @p3) ORDER BY [page].[name] DESC, [page].[id]"
+	if req.SQL != want {
+		t.Errorf("got %q, want %q", req.SQL, want)
+	}
+	if len(req.Params) != 3 || req.Params[1].Value != "b" || req.Params[2].Value != int64(2) {
+		t.Errorf("unexpected parameters %+v", req.Params)
+	}
+}
+
+func TestFetchInvalidToken(t *testing.T) {
+	keyset := Query{SQL: "SELECT id FROM t", Order: []OrderBy{{Column: "id"}}}
+	offset := keyset
+	offset.Offset = true
+	tok := pageToken{Order: offset.orderHash(), Offset: 100}.encode()
+
+	for _, c := range []struct {
+		query Query
+		token string
+	}{
+		{keyset, "not a token"},
+		{keyset, tok},
+		{Query{SQL: "SELECT id FROM t", Order: []OrderBy{{Column: "name"}}, Offset: true}, tok},
+	} {
+		if _, err := Fetch(context.Background(), nil, c.query, c.token, nil); err != ErrInvalidToken {
+			t.Errorf("expected ErrInvalidToken for %q, got %v", c.token, err)
+		}
+	}
+}
+
+func TestBuildOffset(t *testing.T) {
+	query := Query{SQL: "SELECT id FROM t", Order: []OrderBy{{Column: "created", Desc: true}}, Offset: true}
+	text, _, err := query.build(pageToken{Offset: 40}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT * FROM (SELECT id FROM t\n) AS [page] ORDER BY [page].[created] DESC OFFSET 40 ROWS FETCH NEXT 21 ROWS ONLY"
+	if text != want {
+		t.Errorf("got %q, want %q", text, want)
+	}
+}
@@ -0,0 +1,13 @@
+// +build !windows
+
+package mssql
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+func dialPipe(ctx context.Context, name string) (net.Conn, error) {
+	return nil, errors.New("named pipes are only supported on Windows")
+}
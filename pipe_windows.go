@@ -0,0 +1,132 @@
+package mssql
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const _ERROR_PIPE_BUSY syscall.Errno = 231
+
+// pipeBusyRetry is the interval between attempts to open a pipe whose
+// instances are all in use.
+const pipeBusyRetry = 50 * time.Millisecond
+
+// dialPipe opens a named pipe of SQL Server, waiting for a free instance of
+// the pipe until ctx is done.
+func dialPipe(ctx context.Context, name string) (net.Conn, error) {
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		h, err := syscall.CreateFile(path,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0, nil, syscall.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			return &pipeConn{f: os.NewFile(uintptr(h), name), h: h, addr: pipeAddr(name)}, nil
+		}
+		if err != _ERROR_PIPE_BUSY {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pipeBusyRetry):
+		}
+	}
+}
+
+// pipeAddr is the address of a named pipe.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "np" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is a net.Conn over a named pipe. Pipes opened for synchronous
+// I/O have no deadlines, they are implemented by cancelling the pending
+// I/O of the pipe when the deadline passes.
+type pipeConn struct {
+	f    *os.File
+	h    syscall.Handle
+	addr pipeAddr
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	timedOut bool
+}
+
+// pipeTimeoutError is returned by I/O cancelled by a deadline.
+type pipeTimeoutError struct{}
+
+func (pipeTimeoutError) Error() string   { return "i/o timeout" }
+func (pipeTimeoutError) Timeout() bool   { return true }
+func (pipeTimeoutError) Temporary() bool { return true }
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if c.expired() {
+		return 0, pipeTimeoutError{}
+	}
+	n, err := c.f.Read(b)
+	if err != nil && c.expired() {
+		err = pipeTimeoutError{}
+	}
+	return n, err
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	if c.expired() {
+		return 0, pipeTimeoutError{}
+	}
+	n, err := c.f.Write(b)
+	if err != nil && c.expired() {
+		err = pipeTimeoutError{}
+	}
+	return n, err
+}
+
+func (c *pipeConn) expired() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timedOut
+}
+
+func (c *pipeConn) Close() error {
+	c.SetDeadline(time.Time{})
+	return c.f.Close()
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.timedOut = false
+	if t.IsZero() {
+		return nil
+	}
+	cancel := func() {
+		c.mu.Lock()
+		c.timedOut = true
+		c.mu.Unlock()
+		syscall.CancelIoEx(c.h, nil)
+	}
+	d := time.Until(t)
+	if d <= 0 {
+		c.timedOut = true
+		return nil
+	}
+	c.timer = time.AfterFunc(d, cancel)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
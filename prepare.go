@@ -0,0 +1,189 @@
+package mssql
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// maxStmtHandles bounds the number of handles a statement is prepared with,
+// one by declarations of its parameters. The declarations past it are
+// executed by sp_executesql.
+const maxStmtHandles = 8
+
+// errPreparedHandleNotFound is the number of the error of the execution of
+// a handle the server does not know.
+const errPreparedHandleNotFound = 8179
+
+// stmtHandles are the handles of a statement prepared on the server by the
+// declarations of its parameters, shared by the statements of the same
+// text of a connection with a statement cache.
+type stmtHandles struct {
+	// executions counts the executions with parameters, the statement is
+	// prepared on the server from the second
+	executions int
+	handles    map[string]int32
+	// sess is the session of the handles
+	sess *tdsSession
+	// cached is set while the handles belong to the statement cache, which
+	// releases them, rather than to the statement
+	cached bool
+}
+
+// prepareOnServer reports whether the statement executed with args is
+// prepared on the server and executed by its handle, which it is from its
+// second execution with parameters. Encrypted parameters are always sent
+// with the statement text.
+func (s *Stmt) prepareOnServer(args []namedValue) bool {
+	if len(args) == 0 || s.c.sess.columnEncryption {
+		return false
+	}
+	if s.prep == nil {
+		s.prep = s.c.stmtHandles(s.query)
+	}
+	s.prep.executions++
+	return s.prep.executions > 1
+}
+
+// preparedCall returns the call executing the statement by the handle of
+// the declarations of its parameters, or preparing it by sp_prepexec when it
+// has none. params holds the parameters of the statement after two unused
+// ones.
+func (s *Stmt) preparedCall(params []param, decls string) (procId, []param) {
+	prep := s.prep
+	if prep.sess != s.c.sess {
+		// the handles of a previous session are gone with it
+		prep.handles = nil
+		prep.sess = s.c.sess
+	}
+	if handle, ok := prep.handles[decls]; ok {
+		s.sentHandle = handle
+		params[1] = makeHandleParam(handle)
+		return sp_Execute, params[1:]
+	}
+	if len(prep.handles) >= maxStmtHandles {
+		params[0] = makeStrParam(s.query)
+		params[1] = makeStrParam(decls)
+		return sp_ExecuteSql, params
+	}
+	handle := param{Flags: fByRevValue}
+	handle.ti.TypeId = typeIntN
+	handle.ti.Size = 4
+	params = append([]param{handle}, params...)
+	params[1] = makeStrParam(decls)
+	params[2] = makeStrParam(s.query)
+	s.c.outs.prepared = func(handle int32) {
+		if prep.handles == nil {
+			prep.handles = make(map[string]int32)
+		}
+		prep.handles[decls] = handle
+	}
+	return sp_PrepExec, params
+}
+
+// handleLost reports whether err is the error of the execution of
+// a handle the server released, such as by resetting the session, which
+// the statement forgets.
+func (s *Stmt) handleLost(err error) bool {
+	if s.sentHandle == 0 {
+		return false
+	}
+	if e, ok := err.(Error); !ok || e.Number != errPreparedHandleNotFound {
+		return false
+	}
+	for decls, handle := range s.prep.handles {
+		if handle == s.sentHandle {
+			delete(s.prep.handles, decls)
+		}
+	}
+	return true
+}
+
+// unprepare releases the handles of the statement on the server, unless
+// they belong to the statement cache.
+func (s *Stmt) unprepare(ctx context.Context) error {
+	if s.prep == nil || s.prep.cached {
+		return nil
+	}
+	handles := s.prep.handles
+	s.prep.handles = nil
+	if len(handles) == 0 || s.prep.sess != s.c.sess || !s.c.connectionGood {
+		return nil
+	}
+	list := make([]int32, 0, len(handles))
+	for _, handle := range handles {
+		list = append(list, handle)
+	}
+	return s.c.unprepare(ctx, list)
+}
+
+// releaseHandles releases the handles of the statements evicted from the
+// statement cache, before the next request of the connection.
+func (c *Conn) releaseHandles(ctx context.Context) error {
+	handles := c.evictedHandles
+	c.evictedHandles = nil
+	if len(handles) == 0 {
+		return nil
+	}
+	return c.unprepare(ctx, handles)
+}
+
+// unprepare releases handles on the server, ignoring the handles it does
+// not know.
+func (c *Conn) unprepare(ctx context.Context, handles []int32) error {
+	// keep the output parameters of a statement being executed
+	outs := c.outs
+	c.outs = outputs{}
+	defer func() { c.outs = outs }()
+	for _, handle := range handles {
+		if err := c.sendUnprepare(handle); err != nil {
+			return c.checkBadConn(err)
+		}
+		if err := c.simpleProcessResp(ctx); err != nil {
+			if _, ok := err.(Error); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Conn) sendUnprepare(handle int32) error {
+	headers := []headerStruct{
+		{hdrtype: dataStmHdrTransDescr,
+			data: transDescrHdr{c.sess.tranid, 1}.pack()},
+	}
+	var enclave []byte
+	if c.sess.enclaveType != "" {
+		enclave = []byte{}
+	}
+	reset := c.resetSession
+	c.resetSession = false
+	if err := sendRpc(c.sess.buf, headers, enclave, sp_Unprepare, 0, []param{makeHandleParam(handle)}, reset); err != nil {
+		if c.sess.logFlags&logErrors != 0 {
+			c.sess.log.Printf("Failed to send Rpc with %v", err)
+		}
+		c.connectionGood = false
+		return fmt.Errorf("failed to send RPC: %v", err)
+	}
+	return nil
+}
+
+func makeHandleParam(handle int32) (res param) {
+	res.ti.TypeId = typeIntN
+	res.ti.Size = 4
+	res.buffer = make([]byte, 4)
+	binary.LittleEndian.PutUint32(res.buffer, uint32(handle))
+	return
+}
+
+// widenParam declares a variable length parameter with the maximum length
+// of its type, unless it is longer.
+func widenParam(p *param) {
+	switch p.ti.TypeId {
+	case typeNVarChar, typeBigVarChar, typeBigVarBin:
+		if p.ti.Size > 0 && p.ti.Size <= 8000 {
+			p.ti.Size = 8000
+		}
+	}
+}
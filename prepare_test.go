@@ -0,0 +1,136 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestPreparedStatement(t *testing.T) {
+	lose := false
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if req.SQL != "select @p1" {
+			return nil
+		}
+		if lose && req.Proc == "sp_execute" {
+			lose = false
+			return []mssqltest.Response{mssqltest.Error{Number: 8179, Class: 16, Message: "Could not find prepared statement."}}
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "v", Type: mssqltest.NVarChar}},
+			Rows:    [][]interface{}{{req.Param("@p1").Value}},
+		}}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var v string
+	if err = db.QueryRow("select @p1", "once").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.Prepare("select @p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []string{"a", "bc", "def", "ghij"}
+	for i, value := range values {
+		lose = i == 3
+		if err = stmt.QueryRow(value).Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != value {
+			t.Errorf("expected %q, got %q", value, v)
+		}
+	}
+	if err = stmt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		proc   string
+		handle int32
+	}{
+		{"sp_executesql", 0},
+		{"sp_executesql", 0},
+		{"sp_prepexec", 1},
+		{"sp_execute", 1},
+		{"sp_execute", 1},
+		{"sp_prepexec", 2},
+		{"sp_unprepare", 2},
+	}
+	reqs := srv.Requests()
+	var procs []*mssqltest.Request
+	for _, req := range reqs {
+		if req.Type == mssqltest.RPC {
+			procs = append(procs, req)
+		}
+	}
+	if len(procs) != len(expected) {
+		t.Fatalf("expected %d calls, got %d", len(expected), len(procs))
+	}
+	for i, e := range expected {
+		req := procs[i]
+		if req.Proc != e.proc || req.Handle != e.handle {
+			t.Errorf("call %d: expected %s of handle %d, got %s of handle %d", i, e.proc, e.handle, req.Proc, req.Handle)
+		}
+		if e.handle != 0 && req.Proc != "sp_unprepare" && req.ParamDecls != "@p1 nvarchar(4000)" {
+			t.Errorf("call %d: expected the parameter declared nvarchar(4000), got %q", i, req.ParamDecls)
+		}
+	}
+}
+
+func TestPreparedStatementDeclarations(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	stmt, err := db.Prepare("update t set a = @p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range []interface{}{1, 2, "a", 3, "b"} {
+		if _, err = stmt.Exec(arg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = stmt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	unprepared := map[int32]bool{}
+	for _, req := range srv.Requests() {
+		if req.Type != mssqltest.RPC {
+			continue
+		}
+		calls = append(calls, req.Proc)
+		if req.Proc == "sp_unprepare" {
+			unprepared[req.Handle] = true
+		}
+	}
+	expected := []string{"sp_executesql", "sp_prepexec", "sp_prepexec", "sp_execute", "sp_execute", "sp_unprepare", "sp_unprepare"}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected the calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("expected the calls %v, got %v", expected, calls)
+		}
+	}
+	if !unprepared[1] || !unprepared[2] {
+		t.Errorf("expected the handles 1 and 2 released, got %v", unprepared)
+	}
+}
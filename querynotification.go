@@ -0,0 +1,211 @@
+package mssql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// QueryNotification asks the server to send a message to a Service Broker
+// service when the results of a query change.
+//
+// https://docs.microsoft.com/en-us/sql/relational-databases/native-client/features/working-with-query-notifications
+type QueryNotification struct {
+	// ID is returned as the Message element of the notification.
+	ID string
+	// Options names the service that receives the notification, in the
+	// form "service=<name>[;(local database=<db>|broker instance=<id>)]".
+	Options string
+	// Timeout is how long the subscription stays active. The server
+	// default is used when it is zero.
+	Timeout time.Duration
+}
+
+type queryNotificationKey struct{}
+
+// WithQueryNotification returns a context that subscribes to notifications
+// for every query run with it. It applies to queries run through
+// database/sql, statements given a subscription with
+// Stmt.SetQueryNotification keep their own.
+func WithQueryNotification(ctx context.Context, n QueryNotification) context.Context {
+	return context.WithValue(ctx, queryNotificationKey{}, n)
+}
+
+func queryNotificationFromContext(ctx context.Context) *queryNotifSub {
+	n, ok := ctx.Value(queryNotificationKey{}).(QueryNotification)
+	if !ok {
+		return nil
+	}
+	sub := &queryNotifSub{msgText: n.ID, options: n.Options}
+	if n.Timeout > 0 {
+		sub.timeout = uint32(n.Timeout / time.Millisecond)
+		if sub.timeout < 1 {
+			sub.timeout = 1
+		}
+	}
+	return sub
+}
+
+// Notification is a query notification message.
+//
+// https://docs.microsoft.com/en-us/sql/relational-databases/query-notifications/query-notification-messages
+type Notification struct {
+	// ID is the ID of the subscription, as given in QueryNotification.
+	ID string
+	// Type is "change" when the results changed and "subscribe" when the
+	// subscription could not be created.
+	Type string
+	// Source is what caused the notification, e.g. "data", "timeout",
+	// "object" or "statement".
+	Source string
+	// Info gives more detail, e.g. "insert", "update", "delete", "query"
+	// or "invalid".
+	Info string
+}
+
+// QueryNotificationMessageType is the Service Broker message type of query
+// notifications.
+const QueryNotificationMessageType = "http://schemas.microsoft.com/SQL/Notifications/QueryNotification"
+
+const (
+	endDialogMessageType   = "http://schemas.microsoft.com/SQL/ServiceBroker/EndDialog"
+	brokerErrorMessageType = "http://schemas.microsoft.com/SQL/ServiceBroker/Error"
+)
+
+// ParseNotification parses the XML body of a query notification message.
+func ParseNotification(body string) (Notification, error) {
+	var msg struct {
+		Type    string `xml:"type,attr"`
+		Source  string `xml:"source,attr"`
+		Info    string `xml:"info,attr"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal([]byte(body), &msg); err != nil {
+		return Notification{}, fmt.Errorf("mssql: invalid query notification: %v", err)
+	}
+	return Notification{
+		ID:     msg.Message,
+		Type:   msg.Type,
+		Source: msg.Source,
+		Info:   msg.Info,
+	}, nil
+}
+
+// NotificationListener receives query notifications from a Service Broker
+// queue and calls the callback of the subscription each one belongs to.
+//
+// Subscriptions are created with Subscribe, which returns a context to run
+// the watched queries with, and Listen receives the notifications:
+//
+//	l := mssql.NewNotificationListener(db, "CacheService", "CacheQueue")
+//	go l.Listen(ctx)
+//	qctx, _ := l.Subscribe(ctx, time.Hour, func(n mssql.Notification) {
+//		cache.Invalidate("products")
+//	})
+//	rows, err := db.QueryContext(qctx, "select ID, Name from dbo.Products")
+//
+// The server sends a single notification for each subscription, callers
+// subscribe again when they rerun the query.
+type NotificationListener struct {
+	// PollInterval bounds how long a single RECEIVE waits for a message.
+	// It defaults to ten seconds.
+	PollInterval time.Duration
+
+	db      *sql.DB
+	service string
+	queue   string
+
+	mu   sync.Mutex
+	subs map[string]func(Notification)
+}
+
+// NewNotificationListener returns a listener for the given service and the
+// queue it is bound to. The queue name is used verbatim and may be
+// schema qualified.
+func NewNotificationListener(db *sql.DB, service, queue string) *NotificationListener {
+	return &NotificationListener{
+		db:      db,
+		service: service,
+		queue:   queue,
+		subs:    make(map[string]func(Notification)),
+	}
+}
+
+// Subscribe registers fn and returns a context that subscribes the queries
+// run with it. The subscription is removed once fn was called or when
+// cancel is called.
+func (l *NotificationListener) Subscribe(ctx context.Context, timeout time.Duration, fn func(Notification)) (qctx context.Context, cancel func()) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	id := hex.EncodeToString(b)
+	l.mu.Lock()
+	l.subs[id] = fn
+	l.mu.Unlock()
+	qctx = WithQueryNotification(ctx, QueryNotification{
+		ID:      id,
+		Options: "service=" + l.service,
+		Timeout: timeout,
+	})
+	return qctx, func() {
+		l.mu.Lock()
+		delete(l.subs, id)
+		l.mu.Unlock()
+	}
+}
+
+// Listen receives notifications until ctx is done or an error occurs.
+func (l *NotificationListener) Listen(ctx context.Context) error {
+	poll := l.PollInterval
+	if poll <= 0 {
+		poll = 10 * time.Second
+	}
+	query := fmt.Sprintf("WAITFOR (RECEIVE TOP (1) conversation_handle, message_type_name, "+
+		"CAST(CAST(message_body AS XML) AS NVARCHAR(MAX)) FROM %s), TIMEOUT %d",
+		l.queue, int64(poll/time.Millisecond))
+	for {
+		var (
+			handle  UniqueIdentifier
+			msgType string
+			body    sql.NullString
+		)
+		err := l.db.QueryRowContext(ctx, query).Scan(&handle, &msgType, &body)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case QueryNotificationMessageType:
+			n, err := ParseNotification(body.String)
+			if err != nil {
+				return err
+			}
+			l.dispatch(n)
+		case endDialogMessageType, brokerErrorMessageType:
+			if _, err = l.db.ExecContext(ctx, "END CONVERSATION @p1", handle); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (l *NotificationListener) dispatch(n Notification) {
+	l.mu.Lock()
+	fn := l.subs[n.ID]
+	delete(l.subs, n.ID)
+	l.mu.Unlock()
+	if fn != nil {
+		fn(n)
+	}
+}
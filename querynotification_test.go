@@ -0,0 +1,141 @@
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestParseNotification(t *testing.T) {
+	body := `<qn:QueryNotification xmlns:qn="http://schemas.microsoft.com/SQL/Notifications/QueryNotification" id="3" type="change" source="data" info="insert" database_id="5" sid="0x01"><qn:Message>abc</qn:Message></qn:QueryNotification>`
+	n, err := ParseNotification(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Notification{ID: "abc", Type: "change", Source: "data", Info: "insert"}
+	if n != want {
+		t.Errorf("got %+v, want %+v", n, want)
+	}
+	if _, err = ParseNotification("<qn:Query"); err == nil {
+		t.Error("expected an error for a malformed message")
+	}
+}
+
+func TestQueryNotificationHeader(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := WithQueryNotification(context.Background(), QueryNotification{
+		ID:      "abc",
+		Options: "service=svc",
+		Timeout: time.Minute,
+	})
+	if _, err = db.ExecContext(ctx, "select 1 from t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("select 2 from t"); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	got := reqs[len(reqs)-2].Notification
+	if got == nil || got.ID != "abc" || got.Options != "service=svc" || got.Timeout != time.Minute {
+		t.Errorf("unexpected notification header %+v", got)
+	}
+	if n := reqs[len(reqs)-1].Notification; n != nil {
+		t.Errorf("query without a subscription sent %+v", n)
+	}
+}
+
+func TestNotificationListener(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		subID string
+		waits int
+		ended bool
+	)
+	cols := []mssqltest.Column{
+		{Name: "conversation_handle", Type: mssqltest.UniqueIdentifier},
+		{Name: "message_type_name", Type: mssqltest.NVarChar},
+		{Name: "message_body", Type: mssqltest.NVarChar},
+	}
+	handle := make([]byte, 16)
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case req.Notification != nil:
+			subID = req.Notification.ID
+		case strings.HasPrefix(req.SQL, "WAITFOR (RECEIVE"):
+			waits++
+			switch waits {
+			case 1:
+				body := `<qn:QueryNotification xmlns:qn="http://schemas.microsoft.com/SQL/Notifications/QueryNotification" type="change" source="data" info="update"><qn:Message>` + subID + `</qn:Message></qn:QueryNotification>`
+				return []mssqltest.Response{mssqltest.ResultSet{Columns: cols, Rows: [][]interface{}{{handle, QueryNotificationMessageType, body}}}}
+			case 2:
+				return []mssqltest.Response{mssqltest.ResultSet{Columns: cols, Rows: [][]interface{}{{handle, endDialogMessageType, nil}}}}
+			}
+			return []mssqltest.Response{mssqltest.Delay(time.Minute)}
+		case strings.HasPrefix(req.SQL, "END CONVERSATION"):
+			ended = true
+		}
+		return []mssqltest.Response{mssqltest.RowsAffected(0)}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	l := NewNotificationListener(db, "svc", "dbo.q")
+	notified := make(chan Notification, 1)
+	qctx, _ := l.Subscribe(context.Background(), time.Hour, func(n Notification) {
+		notified <- n
+	})
+	if _, err = db.ExecContext(qctx, "select ID from dbo.t"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Listen(ctx) }()
+	select {
+	case n := <-notified:
+		if n.Type != "change" || n.Info != "update" {
+			t.Errorf("unexpected notification %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+	}
+	for i := 0; i < 50; i++ {
+		mu.Lock()
+		w := waits
+		mu.Unlock()
+		if w >= 3 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Errorf("Listen returned %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !ended {
+		t.Error("the ended conversation was not closed")
+	}
+	if len(l.subs) != 0 {
+		t.Error("the subscription was not removed after its notification")
+	}
+}
@@ -0,0 +1,86 @@
+package mssql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+)
+
+type killAfterKey struct{}
+
+// WithQueryTimeout returns a context that cancels the queries run with it
+// after timeout, like context.WithTimeout.
+//
+// A query is cancelled by sending an attention signal, which the server
+// acts on at its next opportunity. A server busy in an operation that does
+// not check for it, or an unresponsive server, keeps running the query
+// until then. When the server does not acknowledge the cancellation within
+// killAfter, the driver opens a new connection to the server of the
+// session, the replica or the node the login was routed to, and ends the
+// session of the query with KILL. The connection of the query
+// is unusable afterwards and is removed from the pool. KILL requires the
+// ALTER ANY CONNECTION permission.
+//
+// A killAfter of zero or less disables KILL, the driver then waits for the
+// acknowledgement as it does for other contexts.
+func WithQueryTimeout(ctx context.Context, timeout, killAfter time.Duration) (context.Context, context.CancelFunc) {
+	if killAfter > 0 {
+		ctx = context.WithValue(ctx, killAfterKey{}, killAfter)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func killAfterFromContext(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(killAfterKey{}).(time.Duration)
+	return d
+}
+
+// killSession ends a session with KILL from a new connection.
+func (d *Driver) killSession(c *Connector, params msdsn.Config, spid uint16) error {
+	if spid == 0 {
+		return fmt.Errorf("mssql: the session id of the connection is unknown")
+	}
+	ctx, cancel := sideConnectionContext(params)
+	defer cancel()
+	conn, err := d.sideConnection(ctx, c, params)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stmt := &Stmt{c: conn, query: fmt.Sprintf("KILL %d", spid)}
+	_, err = stmt.exec(ctx, nil)
+	return err
+}
+
+// sideConnectionContext returns the context of the work of a side
+// connection, which times out after the login timeout of params.
+func sideConnectionContext(params msdsn.Config) (context.Context, context.CancelFunc) {
+	timeout := params.ConnTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// sideConnection opens a new connection to the server of a session, params
+// being those the session logged in with, for the work that cannot wait
+// for the response the session is reading.
+func (d *Driver) sideConnection(ctx context.Context, c *Connector, params msdsn.Config) (*Conn, error) {
+	if params.AdminConnection {
+		// only one admin connection is allowed, use a regular one
+		params.AdminConnection = false
+		params.Port = 0
+	}
+	sess, err := connect(ctx, c, d.log, params)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		connector:      c,
+		sess:           sess,
+		transactionCtx: context.Background(),
+		connectionGood: true,
+	}, nil
+}
@@ -0,0 +1,135 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestQueryTimeoutKill(t *testing.T) {
+	db, srv := openTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		if strings.Contains(req.SQL, "reindex") {
+			return []mssqltest.Response{mssqltest.Stall(time.Minute)}
+		}
+		return nil
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	ctx, cancel := WithQueryTimeout(context.Background(), 50*time.Millisecond, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := db.ExecContext(ctx, "alter index all on t reindex")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, ok := err.(StreamError); !ok {
+		t.Errorf("expected a StreamError, got %v", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("the query was not killed, it took %v", d)
+	}
+
+	var query, kill *mssqltest.Request
+	for _, req := range srv.Requests() {
+		if strings.Contains(req.SQL, "reindex") {
+			query = req
+		}
+		if strings.HasPrefix(req.SQL, "KILL") {
+			kill = req
+		}
+	}
+	if query == nil || kill == nil {
+		t.Fatalf("expected the query and KILL, got %v and %v", query, kill)
+	}
+	if want := fmt.Sprintf("KILL %d", query.SessionID); kill.SQL != want || kill.SessionID == query.SessionID {
+		t.Errorf("got %q on session %d, want %q on another session", kill.SQL, kill.SessionID, want)
+	}
+
+	// the killed connection is not reused
+	if err = db.PingContext(context.Background()); err != nil {
+		t.Errorf("ping failed after the kill: %v", err)
+	}
+}
+
+func TestQueryTimeoutKillRouted(t *testing.T) {
+	replica := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if strings.Contains(req.SQL, "reindex") {
+			return []mssqltest.Response{mssqltest.Stall(time.Minute)}
+		}
+		return nil
+	})
+	defer replica.Close()
+	other := mssqltest.NewServer(nil)
+	defer other.Close()
+	// the listener balances the read-only logins between the replicas
+	listener := mssqltest.NewServer(nil)
+	defer listener.Close()
+	var logins int32
+	listener.Route = func(l *mssqltest.Login) (string, uint16) {
+		if !l.ReadOnly {
+			return "", 0
+		}
+		if atomic.AddInt32(&logins, 1)%2 == 0 {
+			return "127.0.0.1", uint16(other.Addr().Port)
+		}
+		return "127.0.0.1", uint16(replica.Addr().Port)
+	}
+	c, err := NewConnector(listener.DSN() + "&database=sales&ApplicationIntent=ReadOnly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	ctx, cancel := WithQueryTimeout(context.Background(), 50*time.Millisecond, 100*time.Millisecond)
+	defer cancel()
+	if _, err = db.ExecContext(ctx, "alter index all on t reindex"); err == nil {
+		t.Fatal("expected an error")
+	}
+	var query, kill *mssqltest.Request
+	for _, req := range replica.Requests() {
+		if strings.Contains(req.SQL, "reindex") {
+			query = req
+		}
+		if strings.HasPrefix(req.SQL, "KILL") {
+			kill = req
+		}
+	}
+	if query == nil || kill == nil {
+		t.Fatalf("expected the query and KILL on the replica, got %v and %v", query, kill)
+	}
+	if want := fmt.Sprintf("KILL %d", query.SessionID); kill.SQL != want {
+		t.Errorf("got %q, want %q", kill.SQL, want)
+	}
+	if n := len(other.Requests()); n != 0 {
+		t.Errorf("expected no request on the other replica, got %d", n)
+	}
+}
+
+func TestQueryTimeoutAcknowledged(t *testing.T) {
+	db, srv := openTestServer(t, func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.Delay(time.Minute)}
+	})
+	defer srv.Close()
+	defer db.Close()
+
+	ctx, cancel := WithQueryTimeout(context.Background(), 50*time.Millisecond, time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "waitfor delay '00:01'"); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	for _, req := range srv.Requests() {
+		if strings.HasPrefix(req.SQL, "KILL") {
+			t.Error("an acknowledged cancellation killed the session")
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package mssql
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/denisenkom/go-mssqldb/internal/cp"
+)
+
+// ReuseRowBuffers is a query argument which makes the values of the
+// char, varchar, nchar, nvarchar, binary, varbinary and xml columns of the
+// rows, max ones included, []byte slices of buffers reused by the
+// following rows, instead of new strings and slices for every row. Text is
+// UTF-8. Scanned into sql.RawBytes the values are not copied at all, which
+// spares the garbage of reading many rows:
+//
+//	rows, err := db.QueryContext(ctx, "select name, data from t", mssql.ReuseRowBuffers{})
+//	...
+//	var name, data sql.RawBytes
+//	for rows.Next() {
+//		err = rows.Scan(&name, &data)
+//		...
+//	}
+//
+// Scanned into strings or []byte the values are copied as usual, scanned
+// into interface{} text is a []byte instead of a string.
+type ReuseRowBuffers struct{}
+
+// rowBufferCount is the number of rows of which the buffers are in use:
+// the row returned to the caller, the rows queued in the channel of the
+// tokens, the row waiting to be queued and the row being read.
+const rowBufferCount = tokenChanSize + 3
+
+// rowBuffers are the values and the buffers of the rows of a response
+// read with ReuseRowBuffers. Those of a row are reused rowBufferCount rows
+// later, once the caller moved on.
+type rowBuffers struct {
+	rows [rowBufferCount][]interface{}
+	bufs [rowBufferCount][]byte
+	// cur is the index of the row being read
+	cur int
+	// plp holds the chunks of a PLP value of text
+	plp []byte
+}
+
+// nextRow returns the values of the next row, with a nil b a new slice.
+func (b *rowBuffers) nextRow(n int) []interface{} {
+	if b == nil {
+		return make([]interface{}, n)
+	}
+	b.cur = (b.cur + 1) % rowBufferCount
+	b.bufs[b.cur] = b.bufs[b.cur][:0]
+	row := b.rows[b.cur]
+	if cap(row) < n {
+		row = make([]interface{}, n)
+		b.rows[b.cur] = row
+	}
+	return row[:n]
+}
+
+// readValue reads a value of the character and binary types in the
+// buffer of the current row, it reports false for the other types.
+func (b *rowBuffers) readValue(ti *typeInfo, r *tdsBuffer) (interface{}, bool) {
+	switch ti.TypeId {
+	case typeBigVarChar, typeBigChar, typeNVarChar, typeNChar, typeBigVarBin, typeBigBinary, typeXml:
+	default:
+		return nil, false
+	}
+	if ti.TypeId == typeXml || ti.Size == 0xffff {
+		size := r.uint64()
+		if size == _PLP_NULL {
+			return nil, true
+		}
+		binary := ti.TypeId == typeBigVarBin || ti.TypeId == typeBigBinary
+		buf, start := b.bufs[b.cur], len(b.bufs[b.cur])
+		b.plp = b.plp[:0]
+		for {
+			chunksize := int(r.uint32())
+			if chunksize == 0 {
+				break
+			}
+			// binary values are read in place, text is decoded
+			if binary {
+				buf = grow(buf, chunksize)
+				r.ReadFull(buf[len(buf)-chunksize:])
+			} else {
+				b.plp = grow(b.plp, chunksize)
+				r.ReadFull(b.plp[len(b.plp)-chunksize:])
+			}
+		}
+		if !binary {
+			buf = appendText(buf, ti, b.plp)
+		}
+		b.bufs[b.cur] = buf
+		return buf[start:len(buf):len(buf)], true
+	}
+	size := r.uint16()
+	if size == 0xffff {
+		return nil, true
+	}
+	raw := ti.Buffer[:size]
+	r.ReadFull(raw)
+	buf, start := b.bufs[b.cur], len(b.bufs[b.cur])
+	switch ti.TypeId {
+	case typeBigVarBin, typeBigBinary:
+		buf = append(buf, raw...)
+	default:
+		buf = appendText(buf, ti, raw)
+	}
+	b.bufs[b.cur] = buf
+	return buf[start:len(buf):len(buf)], true
+}
+
+// grow extends buf by n bytes.
+func grow(buf []byte, n int) []byte {
+	if len(buf)+n > cap(buf) {
+		grown := make([]byte, len(buf), 2*cap(buf)+n)
+		copy(grown, buf)
+		buf = grown
+	}
+	return buf[:len(buf)+n]
+}
+
+// appendText appends the UTF-8 encoding of the text raw of type ti to buf.
+func appendText(buf []byte, ti *typeInfo, raw []byte) []byte {
+	switch ti.TypeId {
+	case typeBigVarChar, typeBigChar:
+		return cp.AppendUTF8(buf, ti.Collation, raw)
+	}
+	if len(raw)%2 != 0 {
+		badStreamPanicf("Invalid UCS2 encoding: illegal UCS2 string length: %d", len(raw))
+	}
+	var enc [utf8.UTFMax]byte
+	for i := 0; i < len(raw); i += 2 {
+		r := rune(binary.LittleEndian.Uint16(raw[i:]))
+		if utf16.IsSurrogate(r) && i+3 < len(raw) {
+			if dec := utf16.DecodeRune(r, rune(binary.LittleEndian.Uint16(raw[i+2:]))); dec != utf8.RuneError {
+				r = dec
+				i += 2
+			}
+		}
+		if r < utf8.RuneSelf {
+			buf = append(buf, byte(r))
+			continue
+		}
+		n := utf8.EncodeRune(enc[:], r)
+		buf = append(buf, enc[:n]...)
+	}
+	return buf
+}
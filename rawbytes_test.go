@@ -0,0 +1,112 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/internal/cp"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestReuseRowBuffers(t *testing.T) {
+	const count = 100
+	rows := make([][]interface{}, count)
+	for i := range rows {
+		rows[i] = []interface{}{fmt.Sprintf("é%03d", i), bytes.Repeat([]byte{byte(i)}, 100)}
+	}
+	rows[3] = []interface{}{nil, nil}
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "name", Type: mssqltest.NVarChar}, {Name: "data", Type: mssqltest.VarBinary}},
+			Rows:    rows,
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	r, err := db.Query("select name, data from t", ReuseRowBuffers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var name, data sql.RawBytes
+	buffers := make(map[*byte]bool)
+	i := 0
+	for ; r.Next(); i++ {
+		if err := r.Scan(&name, &data); err != nil {
+			t.Fatal(err)
+		}
+		if i == 3 {
+			if name != nil || data != nil {
+				t.Errorf("expected nulls, got %q and %v", name, data)
+			}
+			continue
+		}
+		if string(name) != rows[i][0] || !bytes.Equal(data, rows[i][1].([]byte)) {
+			t.Errorf("row %d: expected %v, got %q and %v", i, rows[i], name, data)
+		}
+		if i >= 2*rowBufferCount {
+			// the buffers grew to fit the rows
+			buffers[&name[0]] = true
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != count {
+		t.Errorf("expected %d rows, got %d", count, i)
+	}
+	if len(buffers) > rowBufferCount {
+		t.Errorf("expected at most %d buffers, the values used %d", rowBufferCount, len(buffers))
+	}
+}
+
+func TestRowBuffersShortValues(t *testing.T) {
+	var payload []byte
+	appendValue := func(b []byte) {
+		var size [2]byte
+		binary.LittleEndian.PutUint16(size[:], uint16(len(b)))
+		payload = append(append(payload, size[:]...), b...)
+	}
+	appendValue(str2ucs2("h€llo"))
+	appendValue([]byte{'c', 0xe9})
+	appendValue([]byte{1, 2})
+	payload = append(payload, 0xff, 0xff)
+	packet := []byte{byte(packReply), 1, 0, 0, 0, 0, 1, 0}
+	binary.BigEndian.PutUint16(packet[2:], uint16(8+len(payload)))
+	r := newTdsBuffer(4096, closableBuffer{bytes.NewBuffer(append(packet, payload...))})
+	if _, err := r.BeginRead(); err != nil {
+		t.Fatal(err)
+	}
+
+	latin1 := cp.Collation{LcidAndFlags: 0x409, SortId: 52}
+	columns := []columnStruct{
+		{ti: typeInfo{TypeId: typeNVarChar, Size: 20, Buffer: make([]byte, 20)}},
+		{ti: typeInfo{TypeId: typeBigVarChar, Size: 10, Buffer: make([]byte, 10), Collation: latin1}},
+		{ti: typeInfo{TypeId: typeBigVarBin, Size: 10, Buffer: make([]byte, 10)}},
+		{ti: typeInfo{TypeId: typeBigVarChar, Size: 10, Buffer: make([]byte, 10), Collation: latin1}},
+	}
+	bufs := new(rowBuffers)
+	row := bufs.nextRow(len(columns))
+	parseRow(r, columns, row, bufs)
+	expected := []interface{}{[]byte("h€llo"), []byte("cé"), []byte{1, 2}, nil}
+	for i := range expected {
+		if expected[i] == nil {
+			if row[i] != nil {
+				t.Errorf("column %d: expected nil, got %v", i, row[i])
+			}
+		} else if !bytes.Equal(row[i].([]byte), expected[i].([]byte)) {
+			t.Errorf("column %d: expected %q, got %q", i, expected[i], row[i])
+		}
+	}
+}
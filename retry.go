@@ -0,0 +1,97 @@
+package mssql
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+)
+
+// RetryPolicy decides whether and when an operation that failed is retried.
+type RetryPolicy interface {
+	// Retryable reports whether the operation that failed with err may
+	// succeed when retried.
+	Retryable(err error) bool
+	// Delay returns the wait before the retry of the given attempt,
+	// counted from zero.
+	Delay(attempt int) time.Duration
+	// MaxRetries returns the number of times a failed operation is
+	// retried at most.
+	MaxRetries() int
+}
+
+// ExponentialBackoff is the RetryPolicy of the connectretrycount and
+// connectretryinterval connection string parameters. It retries transient
+// errors, see IsTransientError, Retries times, waiting Interval before the
+// first retry and doubling the wait for every further retry, with up to
+// 20% of random jitter either way so that the connections of a pool do not
+// retry in lockstep.
+type ExponentialBackoff struct {
+	Retries int
+	// Interval defaults to 10s.
+	Interval time.Duration
+}
+
+func (b ExponentialBackoff) Retryable(err error) bool { return IsTransientError(err) }
+func (b ExponentialBackoff) MaxRetries() int          { return b.Retries }
+
+func (b ExponentialBackoff) Delay(attempt int) time.Duration {
+	return connectRetryDelay(b.Interval, attempt)
+}
+
+// maxConnectRetryDelay caps the exponential backoff of connect retries.
+const maxConnectRetryDelay = 2 * time.Minute
+
+// IsTransientError reports whether an operation that failed with err may
+// succeed when retried: the server raised a retryable error, such as
+// Azure SQL errors 40613 and 10928 or error 4060 while a database comes
+// online, or it reset the connection. Custom retry policies can build on
+// it.
+func IsTransientError(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case Error:
+			return e.Retryable()
+		case PasswordExpiredError:
+			return false
+		case *net.OpError:
+			err = e.Err
+			continue
+		case *os.SyscallError:
+			err = e.Err
+			continue
+		case syscall.Errno:
+			return e == syscall.ECONNRESET || e == syscall.ECONNABORTED
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return true
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = u.Unwrap()
+	}
+	return false
+}
+
+// connectRetryDelay returns the delay of ExponentialBackoff before the
+// retry of the given attempt.
+func connectRetryDelay(interval time.Duration, attempt int) time.Duration {
+	if interval <= 0 {
+		interval = msdsn.DefaultConnectRetryInterval
+	}
+	d := interval
+	for i := 0; i < attempt && d < maxConnectRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxConnectRetryDelay {
+		d = maxConnectRetryDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5*2+1)) - d/5
+	return d + jitter
+}
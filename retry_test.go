@@ -0,0 +1,142 @@
+package mssql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestIsTransientConnectError(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{Error{Number: 40613}, true},
+		{Error{Number: 4060}, true},
+		{Error{Number: 10928}, true},
+		{Error{Number: 18456}, false},
+		{PasswordExpiredError{Err: Error{Number: 18487}}, false},
+		{reset, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, false},
+		{io.EOF, true},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("no instance matching 'X'"), false},
+	}
+	for _, tt := range tests {
+		if got := IsTransientError(tt.err); got != tt.transient {
+			t.Errorf("IsTransientError(%v) = %v, want %v", tt.err, got, tt.transient)
+		}
+	}
+}
+
+func TestConnectRetryDelay(t *testing.T) {
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		for i := 0; i < 20; i++ {
+			d := connectRetryDelay(time.Second, attempt)
+			if d < want*8/10 || d > want*12/10 {
+				t.Fatalf("delay of attempt %d is %v, want %v within 20%%", attempt, d, want)
+			}
+		}
+	}
+	if d := connectRetryDelay(time.Minute, 10); d > maxConnectRetryDelay*12/10 {
+		t.Errorf("delay %v is not capped", d)
+	}
+}
+
+func TestConnectRetry(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer srv.Close()
+	var failures int32
+	srv.Authenticate = func(l *mssqltest.Login) error {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			return mssqltest.Error{Number: 40613, Class: 17, Message: "Database is not currently available."}
+		}
+		return nil
+	}
+
+	connect := func(retries int) error {
+		c, err := NewConnector(srv.DSN() + fmt.Sprintf("&connectretrycount=%d", retries))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.params.ConnectRetryInterval = time.Millisecond
+		conn, err := c.Connect(context.Background())
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	atomic.StoreInt32(&failures, 2)
+	if err := connect(2); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if n := len(srv.Logins()); n != 3 {
+		t.Errorf("expected 3 logins, got %d", n)
+	}
+
+	atomic.StoreInt32(&failures, 2)
+	err := connect(1)
+	if e, ok := err.(Error); !ok || e.Number != 40613 {
+		t.Fatalf("expected error 40613 once the retries are exhausted, got %v", err)
+	}
+
+	// login failures are not transient
+	srv.Authenticate = func(l *mssqltest.Login) error {
+		return mssqltest.Error{Number: 18456, Class: 14, Message: "Login failed"}
+	}
+	before := len(srv.Logins())
+	if err := connect(3); err == nil {
+		t.Fatal("expected the login to fail")
+	}
+	if n := len(srv.Logins()) - before; n != 1 {
+		t.Errorf("expected a single login attempt, got %d", n)
+	}
+}
+
+type countingPolicy struct {
+	retries int
+	seen    []error
+}
+
+func (p *countingPolicy) Retryable(err error) bool {
+	p.seen = append(p.seen, err)
+	return true
+}
+func (p *countingPolicy) Delay(attempt int) time.Duration { return time.Millisecond }
+func (p *countingPolicy) MaxRetries() int                 { return p.retries }
+
+func TestConnectorRetryPolicy(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer srv.Close()
+	srv.Authenticate = func(l *mssqltest.Login) error {
+		return mssqltest.Error{Number: 18456, Class: 14, Message: "Login failed"}
+	}
+
+	// the policy retries the login failure the default policy reports at once
+	c, err := NewConnector(srv.DSN() + "&connectretrycount=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &countingPolicy{retries: 2}
+	c.RetryPolicy = policy
+	if _, err = c.Connect(context.Background()); err == nil {
+		t.Fatal("expected the login to fail")
+	}
+	if n := len(srv.Logins()); n != 3 {
+		t.Errorf("expected 3 logins, got %d", n)
+	}
+	if len(policy.seen) != 2 {
+		t.Errorf("expected the policy to classify 2 errors, got %d", len(policy.seen))
+	}
+}
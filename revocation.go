@@ -0,0 +1,138 @@
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"golang.org/x/crypto/ocsp"
+)
+
+// revocationTimeout bounds each OCSP or CRL request.
+const revocationTimeout = 10 * time.Second
+
+// errRevocationUnknown is returned when the revocation status of a
+// certificate cannot be determined.
+var errRevocationUnknown = errors.New("revocation status unknown")
+
+// checkRevocationOnVerify adds the revocation check selected by mode to the
+// verification of the server certificate.
+func checkRevocationOnVerify(config *tls.Config, mode msdsn.RevocationCheck, client *http.Client) {
+	verify := config.VerifyPeerCertificate
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if verify != nil {
+			if err := verify(rawCerts, chains); err != nil {
+				return err
+			}
+		}
+		// chains are empty when verification is skipped, e.g. for pinned
+		// or trusted server certificates, whose status is then unknown
+		if len(chains) == 0 {
+			if mode == msdsn.RevocationHardFail {
+				return errors.New("revocation check failed: the server certificate was not verified")
+			}
+			return nil
+		}
+		return checkChainRevocation(client, mode, chains[0])
+	}
+}
+
+// checkChainRevocation checks every certificate of a verified chain but the
+// root.
+func checkChainRevocation(client *http.Client, mode msdsn.RevocationCheck, chain []*x509.Certificate) error {
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		err := certificateRevocation(client, cert, issuer)
+		if err == nil || (err == errRevocationUnknown && mode == msdsn.RevocationSoftFail) {
+			continue
+		}
+		return fmt.Errorf("revocation check of certificate %q failed: %v", cert.Subject.CommonName, err)
+	}
+	return nil
+}
+
+// certificateRevocation returns nil for a certificate that is not revoked
+// and errRevocationUnknown if its status cannot be determined.
+func certificateRevocation(client *http.Client, cert, issuer *x509.Certificate) error {
+	for _, server := range cert.OCSPServer {
+		err := ocspStatus(client, server, cert, issuer)
+		if err != errRevocationUnknown {
+			return err
+		}
+	}
+	for _, dp := range cert.CRLDistributionPoints {
+		err := crlStatus(client, dp, cert, issuer)
+		if err != errRevocationUnknown {
+			return err
+		}
+	}
+	return errRevocationUnknown
+}
+
+func ocspStatus(client *http.Client, server string, cert, issuer *x509.Certificate) error {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return errRevocationUnknown
+	}
+	body, err := fetch(client, http.MethodPost, server, req)
+	if err != nil {
+		return errRevocationUnknown
+	}
+	resp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return errRevocationUnknown
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("the certificate was revoked at %v", resp.RevokedAt)
+	}
+	return errRevocationUnknown
+}
+
+func crlStatus(client *http.Client, url string, cert, issuer *x509.Certificate) error {
+	body, err := fetch(client, http.MethodGet, url, nil)
+	if err != nil {
+		return errRevocationUnknown
+	}
+	crl, err := x509.ParseCRL(body)
+	if err != nil || issuer.CheckCRLSignature(crl) != nil || crl.HasExpired(time.Now()) {
+		return errRevocationUnknown
+	}
+	for _, rc := range crl.TBSCertList.RevokedCertificates {
+		if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return fmt.Errorf("the certificate was revoked at %v", rc.RevocationTime)
+		}
+	}
+	return nil
+}
+
+func fetch(client *http.Client, method, url string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), revocationTimeout)
+	defer cancel()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/ocsp-request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,74 @@
+package mssql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// RowVersion is a rowversion, or timestamp, value. The values of a
+// database increase with every change to a row, so they order the changes:
+// a row changed since it was read has a greater rowversion, and the rows
+// changed since a poll are those with a rowversion greater than the
+// greatest one it saw. As a parameter it is sent as a binary(8), which
+// compares with rowversion columns without conversion.
+type RowVersion [8]byte
+
+// RowVersionFromUint64 returns the rowversion of the number n.
+func RowVersionFromUint64(n uint64) RowVersion {
+	var v RowVersion
+	binary.BigEndian.PutUint64(v[:], n)
+	return v
+}
+
+// Uint64 returns v as a number, in the order of the rowversions.
+func (v RowVersion) Uint64() uint64 {
+	return binary.BigEndian.Uint64(v[:])
+}
+
+// Compare returns -1, 0 or +1 as v is lower than, equal to or greater than
+// w, that is v comes before, is the same as or comes after w.
+func (v RowVersion) Compare(w RowVersion) int {
+	return bytes.Compare(v[:], w[:])
+}
+
+// Less reports whether v is lower than w.
+func (v RowVersion) Less(w RowVersion) bool {
+	return v.Compare(w) < 0
+}
+
+// IsZero reports whether v is zero, lower than any rowversion of a row.
+func (v RowVersion) IsZero() bool {
+	return v == RowVersion{}
+}
+
+// String formats v as a binary literal such as 0x00000000000007D1.
+func (v RowVersion) String() string {
+	return fmt.Sprintf("0x%X", v[:])
+}
+
+// Scan implements the sql.Scanner interface, it accepts the 8 bytes of
+// rowversion and binary(8) values.
+func (v *RowVersion) Scan(src interface{}) error {
+	switch b := src.(type) {
+	case []byte:
+		if len(b) != len(v) {
+			return fmt.Errorf("mssql: invalid RowVersion length %d", len(b))
+		}
+		copy(v[:], b)
+		return nil
+	case nil:
+		return errors.New("mssql: cannot scan NULL into a RowVersion, scan into a *RowVersion")
+	}
+	return fmt.Errorf("mssql: cannot scan a value of type %T into a RowVersion", src)
+}
+
+// param returns the binary(8) parameter of v.
+func (v RowVersion) param() param {
+	var res param
+	res.ti.TypeId = typeBigBinary
+	res.ti.Size = len(v)
+	res.buffer = append([]byte(nil), v[:]...)
+	return res
+}
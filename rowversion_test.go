@@ -0,0 +1,71 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestRowVersionCompare(t *testing.T) {
+	v := RowVersionFromUint64(0x7d1)
+	w := RowVersionFromUint64(0x100000000)
+	if v.Compare(w) != -1 || w.Compare(v) != 1 || v.Compare(v) != 0 {
+		t.Error("unexpected order of the rowversions")
+	}
+	if !v.Less(w) || w.Less(v) {
+		t.Error("expected v to be less than w")
+	}
+	if v.Uint64() != 0x7d1 || v.String() != "0x00000000000007D1" {
+		t.Errorf("expected 0x7d1, got %s", v)
+	}
+	if v.IsZero() || !(RowVersion{}).IsZero() {
+		t.Error("unexpected IsZero result")
+	}
+}
+
+func TestRowVersion(t *testing.T) {
+	stored := []byte{0, 0, 0, 0, 0, 0, 0x07, 0xd1}
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if len(req.Params) > 0 {
+			return nil
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "rv", Type: mssqltest.VarBinary}, {Name: "n", Type: mssqltest.VarBinary}},
+			Rows:    [][]interface{}{{stored, nil}},
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	var v RowVersion
+	var n *RowVersion
+	if err := db.QueryRow("select rv, n from t").Scan(&v, &n); err != nil {
+		t.Fatal(err)
+	}
+	if v.Uint64() != 0x7d1 || n != nil {
+		t.Errorf("expected 0x7d1 and NULL, got %s and %v", v, n)
+	}
+	if err := db.QueryRow("select rv, n from t").Scan(&v, &v); err == nil {
+		t.Error("expected an error scanning NULL into a RowVersion")
+	}
+
+	if _, err := db.Exec("update t set x = 1 where rv = @p1", v); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	if p, ok := reqs[len(reqs)-1].Param("@p1").Value.([]byte); !ok || !bytes.Equal(p, stored) {
+		t.Errorf("expected % x, got %v", stored, reqs[len(reqs)-1].Param("@p1").Value)
+	}
+	if decl := makeDecl(v.param().ti); decl != "binary(8)" {
+		t.Errorf("expected binary(8), got %s", decl)
+	}
+}
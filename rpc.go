@@ -13,6 +13,7 @@ type procId struct {
 const (
 	fByRevValue   = 1
 	fDefaultValue = 2
+	fEncrypted    = 8
 )
 
 type param struct {
@@ -20,6 +21,11 @@ type param struct {
 	Flags  uint8
 	ti     typeInfo
 	buffer []byte
+	// crypto describes the plaintext and the key of an encrypted parameter
+	crypto *cryptoMetadata
+	// stream is the value of a parameter streamed from a reader, it is
+	// sent instead of buffer
+	stream *LOBParam
 }
 
 var (
@@ -34,15 +40,19 @@ var (
 	sp_CursorClose     = procId{9, ""}
 	sp_ExecuteSql      = procId{10, ""}
 	sp_Prepare         = procId{11, ""}
+	sp_Execute         = procId{12, ""}
 	sp_PrepExec        = procId{13, ""}
 	sp_PrepExecRpc     = procId{14, ""}
 	sp_Unprepare       = procId{15, ""}
 )
 
 // http://msdn.microsoft.com/en-us/library/dd357576.aspx
-func sendRpc(buf *tdsBuffer, headers []headerStruct, proc procId, flags uint16, params []param, resetSession bool) (err error) {
+func sendRpc(buf *tdsBuffer, headers []headerStruct, enclave []byte, proc procId, flags uint16, params []param, resetSession bool) (err error) {
 	buf.BeginPacket(packRPCRequest, resetSession)
 	writeAllHeaders(buf, headers)
+	if err = writeEnclavePackage(buf, enclave); err != nil {
+		return
+	}
 	if len(proc.name) == 0 {
 		var idswitch uint16 = 0xffff
 		err = binary.Write(buf, binary.LittleEndian, &idswitch)
@@ -74,10 +84,24 @@ func sendRpc(buf *tdsBuffer, headers []headerStruct, proc procId, flags uint16,
 		if err != nil {
 			return
 		}
-		err = param.ti.Writer(buf, param.ti, param.buffer)
+		switch param.ti.TypeId {
+		case typeText, typeNText, typeImage:
+			err = writeRPCLongLenType(buf, param.ti, param.buffer)
+		default:
+			if param.stream != nil {
+				err = param.stream.write(buf)
+			} else {
+				err = param.ti.Writer(buf, param.ti, param.buffer)
+			}
+		}
 		if err != nil {
 			return
 		}
+		if param.Flags&fEncrypted != 0 {
+			if err = writeParamCipherInfo(buf, param.crypto); err != nil {
+				return
+			}
+		}
 	}
 	return buf.FinishPacket()
 }
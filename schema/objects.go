@@ -0,0 +1,212 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectName is a schema qualified object name.
+type ObjectName struct {
+	// Database is only set for references to other databases.
+	Database string
+	Schema   string
+	Name     string
+}
+
+func (n ObjectName) String() string {
+	s := QuoteName(n.Schema) + "." + QuoteName(n.Name)
+	if n.Database != "" {
+		s = QuoteName(n.Database) + "." + s
+	}
+	return s
+}
+
+func (n ObjectName) key() string {
+	return strings.ToLower(n.Database + "\x00" + n.Schema + "\x00" + n.Name)
+}
+
+// Object is a module, a view, procedure, function or trigger, and its
+// definition.
+type Object struct {
+	ObjectName
+	// Type is the type of the object as in sys.objects.type_desc, e.g.
+	// "VIEW" or "SQL_STORED_PROCEDURE".
+	Type string
+	// Definition is the text of the CREATE statement. It is empty for
+	// objects created WITH ENCRYPTION.
+	Definition           string
+	UsesAnsiNulls        bool
+	UsesQuotedIdentifier bool
+	Modified             time.Time
+}
+
+// Objects lists the modules of a schema, or of all schemas when schemaName
+// is empty, with their definitions.
+func Objects(ctx context.Context, q Querier, schemaName string) ([]Object, error) {
+	rows, err := q.QueryContext(ctx, "SELECT s.name, o.name, o.type_desc, ISNULL(m.definition, N''), "+
+		"m.uses_ansi_nulls, m.uses_quoted_identifier, o.modify_date "+
+		"FROM sys.sql_modules m JOIN sys.objects o ON o.object_id = m.object_id "+
+		"JOIN sys.schemas s ON s.schema_id = o.schema_id "+
+		"WHERE o.is_ms_shipped = 0 AND (@p1 = N'' OR s.name = @p1) "+
+		"ORDER BY s.name, o.name", schemaName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Object
+	for rows.Next() {
+		var o Object
+		var ansiNulls, quotedIdent sql.NullBool
+		err = rows.Scan(&o.Schema, &o.Name, &o.Type, &o.Definition, &ansiNulls, &quotedIdent, &o.Modified)
+		if err != nil {
+			return nil, err
+		}
+		o.UsesAnsiNulls, o.UsesQuotedIdentifier = ansiNulls.Bool, quotedIdent.Bool
+		res = append(res, o)
+	}
+	return res, rows.Err()
+}
+
+// Definition returns the definition of a module, the same text
+// sp_helptext prints.
+func Definition(ctx context.Context, q Querier, name string) (string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT OBJECT_ID(@p1), OBJECT_DEFINITION(OBJECT_ID(@p1))", name)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var id sql.NullInt64
+	var def sql.NullString
+	if rows.Next() {
+		if err = rows.Scan(&id, &def); err != nil {
+			return "", err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	if !id.Valid {
+		return "", fmt.Errorf("schema: object %s not found", name)
+	}
+	if !def.Valid {
+		return "", fmt.Errorf("schema: the definition of %s is not available, it is encrypted or not a module", name)
+	}
+	return def.String, nil
+}
+
+// Dependency is a reference from the definition of one object to another.
+type Dependency struct {
+	Referencing ObjectName
+	Referenced  ObjectName
+	// SchemaBound is set for references of schema bound objects, which
+	// prevent the referenced object from being dropped.
+	SchemaBound bool
+	// Unresolved is set when the referenced object does not exist, or
+	// could not be resolved because it lives in another database.
+	Unresolved bool
+}
+
+// Dependencies lists the references between objects of the database.
+func Dependencies(ctx context.Context, q Querier) ([]Dependency, error) {
+	rows, err := q.QueryContext(ctx, "SELECT OBJECT_SCHEMA_NAME(d.referencing_id), OBJECT_NAME(d.referencing_id), "+
+		"ISNULL(d.referenced_database_name, N''), "+
+		"COALESCE(d.referenced_schema_name, OBJECT_SCHEMA_NAME(d.referenced_id), N''), d.referenced_entity_name, "+
+		"d.is_schema_bound_reference, CAST(CASE WHEN d.referenced_id IS NULL THEN 1 ELSE 0 END AS BIT) "+
+		"FROM sys.sql_expression_dependencies d "+
+		"WHERE d.referencing_class = 1 AND d.referenced_class = 1 "+
+		"ORDER BY 1, 2, 3, 4, 5")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Dependency
+	for rows.Next() {
+		var d Dependency
+		err = rows.Scan(&d.Referencing.Schema, &d.Referencing.Name, &d.Referenced.Database,
+			&d.Referenced.Schema, &d.Referenced.Name, &d.SchemaBound, &d.Unresolved)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// SortByDependencies orders objects so that every object comes after the
+// objects it references, keeping the given order otherwise. References to
+// objects missing from objs are ignored. It fails when the references form
+// a cycle.
+func SortByDependencies(objs []Object, deps []Dependency) ([]Object, error) {
+	index := make(map[string]int, len(objs))
+	for i, o := range objs {
+		index[o.key()] = i
+	}
+	// edges from the referenced object to the objects referencing it
+	dependents := make([][]int, len(objs))
+	pending := make([]int, len(objs))
+	for _, d := range deps {
+		from, ok1 := index[d.Referenced.key()]
+		to, ok2 := index[d.Referencing.key()]
+		if !ok1 || !ok2 || from == to {
+			continue
+		}
+		dependents[from] = append(dependents[from], to)
+		pending[to]++
+	}
+	var ready []int
+	for i := range objs {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	res := make([]Object, 0, len(objs))
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		res = append(res, objs[i])
+		for _, j := range dependents[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if len(res) != len(objs) {
+		var cycle []string
+		for i, n := range pending {
+			if n > 0 {
+				cycle = append(cycle, objs[i].ObjectName.String())
+			}
+		}
+		return nil, fmt.Errorf("schema: circular references between %s", strings.Join(cycle, ", "))
+	}
+	return res, nil
+}
+
+// Script writes the definitions of objs as a script of batches separated
+// by GO, with the SET options each object was created with.
+func Script(w io.Writer, objs []Object) error {
+	onOff := func(b bool) string {
+		if b {
+			return "ON"
+		}
+		return "OFF"
+	}
+	for _, o := range objs {
+		if o.Definition == "" {
+			return fmt.Errorf("schema: the definition of %s is not available", o.ObjectName)
+		}
+		_, err := fmt.Fprintf(w, "SET ANSI_NULLS %s\nGO\nSET QUOTED_IDENTIFIER %s\nGO\n%s\nGO\n",
+			onOff(o.UsesAnsiNulls), onOff(o.UsesQuotedIdentifier), strings.TrimRight(o.Definition, "\r\n"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
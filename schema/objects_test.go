@@ -0,0 +1,102 @@
+package schema
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestObjectsAndDependencies(t *testing.T) {
+	bit := func(name string) mssqltest.Column { return mssqltest.Column{Name: name, Type: mssqltest.Bit} }
+	modified := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	db, done := open(t, map[string]mssqltest.ResultSet{
+		"SELECT s.name, o.name, o.type_desc": {
+			Columns: append(append(nvarchar("schema", "name", "type", "definition"), bit("ansi_nulls"), bit("quoted_identifier")),
+				mssqltest.Column{Name: "modify_date", Type: mssqltest.DateTime2}),
+			Rows: [][]interface{}{
+				{"dbo", "vOrders", "VIEW", "CREATE VIEW dbo.vOrders AS SELECT * FROM dbo.Orders\r\n", true, true, modified},
+			},
+		},
+		"SELECT OBJECT_SCHEMA_NAME(d.referencing_id)": {
+			Columns: append(nvarchar("schema", "name", "ref_db", "ref_schema", "ref_name"), bit("schema_bound"), bit("unresolved")),
+			Rows: [][]interface{}{
+				{"dbo", "vOrders", "", "dbo", "Orders", false, false},
+				{"dbo", "vOrders", "archive", "dbo", "Orders", false, true},
+			},
+		},
+	})
+	defer done()
+	ctx := context.Background()
+
+	objs, err := Objects(ctx, db, "dbo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Type != "VIEW" || !objs[0].UsesAnsiNulls || !objs[0].Modified.Equal(modified) {
+		t.Fatalf("unexpected objects %+v", objs)
+	}
+	deps, err := Dependencies(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 2 || deps[0].Referenced.Name != "Orders" || deps[0].Unresolved || !deps[1].Unresolved ||
+		deps[1].Referenced.String() != "[archive].[dbo].[Orders]" {
+		t.Errorf("unexpected dependencies %+v", deps)
+	}
+
+	var b bytes.Buffer
+	if err = Script(&b, objs); err != nil {
+		t.Fatal(err)
+	}
+	want := "SET ANSI_NULLS ON\nGO\nSET QUOTED_IDENTIFIER ON\nGO\nCREATE VIEW dbo.vOrders AS SELECT * FROM dbo.Orders\nGO\n"
+	if b.String() != want {
+		t.Errorf("got script %q, want %q", b.String(), want)
+	}
+}
+
+func TestSortByDependencies(t *testing.T) {
+	obj := func(name string) Object { return Object{ObjectName: ObjectName{Schema: "dbo", Name: name}} }
+	dep := func(from, to string) Dependency {
+		return Dependency{Referencing: ObjectName{Schema: "dbo", Name: from}, Referenced: ObjectName{Schema: "DBO", Name: to}}
+	}
+	objs := []Object{obj("pReport"), obj("vTotals"), obj("fTax"), obj("vOrders")}
+	deps := []Dependency{
+		dep("pReport", "vTotals"),
+		dep("vTotals", "vOrders"),
+		dep("vTotals", "fTax"),
+		dep("vOrders", "Orders"), // a table, not scripted
+	}
+	sorted, err := SortByDependencies(objs, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, o := range sorted {
+		names = append(names, o.Name)
+	}
+	if got := strings.Join(names, " "); got != "fTax vOrders vTotals pReport" {
+		t.Errorf("got order %s", got)
+	}
+
+	deps = append(deps, dep("vOrders", "pReport"))
+	if _, err = SortByDependencies(objs, deps); err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Errorf("expected a cycle to be reported, got %v", err)
+	}
+}
+
+func TestDefinitionNotFound(t *testing.T) {
+	db, done := open(t, map[string]mssqltest.ResultSet{
+		"SELECT OBJECT_ID(@p1)": {
+			Columns: []mssqltest.Column{{Type: mssqltest.Int}, {Type: mssqltest.NVarChar}},
+			Rows:    [][]interface{}{{nil, nil}},
+		},
+	})
+	defer done()
+	if _, err := Definition(context.Background(), db, "dbo.missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
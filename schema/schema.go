@@ -0,0 +1,317 @@
+// Package schema reads table definitions, module definitions and the
+// dependencies between objects from the catalog views of a database, for
+// code generators, migration and schema drift tools.
+//
+// Table names are given as "name" or "schema.name", optionally quoted with
+// brackets, and are resolved with OBJECT_ID in the current database.
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Querier runs queries, it is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Table is a user table or view.
+type Table struct {
+	Schema string
+	Name   string
+	// View is set for views.
+	View bool
+}
+
+// Column is a column of a table.
+type Column struct {
+	Name string
+	// Ordinal is the 1 based position of the column.
+	Ordinal int
+	// TypeName is the system type, e.g. "nvarchar". For alias types it is
+	// the base type and UserType is the alias.
+	TypeName string
+	UserType string
+	// MaxLength is the maximum length in characters for character types
+	// and in bytes for other types, -1 for the (max) types.
+	MaxLength int64
+	Precision int
+	Scale     int
+	Nullable  bool
+	Identity  bool
+	Computed  bool
+	// Collation is empty for non character types.
+	Collation string
+	// Default is the definition of the default constraint, if any.
+	Default string
+}
+
+// SQLType returns the type as it is written in a column definition, e.g.
+// "nvarchar(50)", "decimal(10, 2)" or "varbinary(max)".
+func (c *Column) SQLType() string {
+	switch c.TypeName {
+	case "char", "varchar", "nchar", "nvarchar", "binary", "varbinary":
+		if c.MaxLength < 0 {
+			return c.TypeName + "(max)"
+		}
+		return fmt.Sprintf("%s(%d)", c.TypeName, c.MaxLength)
+	case "decimal", "numeric":
+		return fmt.Sprintf("%s(%d, %d)", c.TypeName, c.Precision, c.Scale)
+	case "datetime2", "datetimeoffset", "time":
+		return fmt.Sprintf("%s(%d)", c.TypeName, c.Scale)
+	case "float":
+		if c.Precision != 53 {
+			return fmt.Sprintf("float(%d)", c.Precision)
+		}
+	}
+	return c.TypeName
+}
+
+// Key is a primary key or unique constraint.
+type Key struct {
+	Name    string
+	Columns []string
+}
+
+// ForeignKey is a foreign key constraint.
+type ForeignKey struct {
+	Name       string
+	Columns    []string
+	RefSchema  string
+	RefTable   string
+	RefColumns []string
+	// OnDelete and OnUpdate are the referential actions, e.g. "NO_ACTION"
+	// or "CASCADE".
+	OnDelete string
+	OnUpdate string
+	Disabled bool
+}
+
+// Index is an index of a table.
+type Index struct {
+	Name string
+	// Type is the index type, e.g. "CLUSTERED" or "NONCLUSTERED".
+	Type       string
+	Unique     bool
+	PrimaryKey bool
+	Columns    []IndexColumn
+	// Filter is the predicate of a filtered index.
+	Filter string
+}
+
+// IndexColumn is a column of an index.
+type IndexColumn struct {
+	Name       string
+	Descending bool
+	// Included is set for non key columns added with INCLUDE.
+	Included bool
+}
+
+// Tables lists the tables and views of a schema, or of all schemas when
+// schemaName is empty.
+func Tables(ctx context.Context, q Querier, schemaName string) ([]Table, error) {
+	rows, err := q.QueryContext(ctx, "SELECT s.name, o.name, CAST(CASE o.type WHEN 'V' THEN 1 ELSE 0 END AS BIT) "+
+		"FROM sys.objects o JOIN sys.schemas s ON s.schema_id = o.schema_id "+
+		"WHERE o.type IN ('U', 'V') AND o.is_ms_shipped = 0 AND (@p1 = N'' OR s.name = @p1) "+
+		"ORDER BY s.name, o.name", schemaName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Table
+	for rows.Next() {
+		var t Table
+		if err = rows.Scan(&t.Schema, &t.Name, &t.View); err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, rows.Err()
+}
+
+// Columns lists the columns of a table in their order.
+func Columns(ctx context.Context, q Querier, table string) ([]Column, error) {
+	rows, err := q.QueryContext(ctx, "SELECT c.name, c.column_id, bt.name, "+
+		"CASE WHEN ut.is_user_defined = 1 THEN ut.name ELSE N'' END, "+
+		"CAST(c.max_length AS BIGINT), CAST(c.precision AS INT), CAST(c.scale AS INT), "+
+		"c.is_nullable, c.is_identity, c.is_computed, ISNULL(c.collation_name, N''), ISNULL(dc.definition, N'') "+
+		"FROM sys.columns c "+
+		"JOIN sys.types ut ON ut.user_type_id = c.user_type_id "+
+		"JOIN sys.types bt ON bt.user_type_id = c.system_type_id "+
+		"LEFT JOIN sys.default_constraints dc ON dc.object_id = c.default_object_id "+
+		"WHERE c.object_id = OBJECT_ID(@p1) ORDER BY c.column_id", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Column
+	for rows.Next() {
+		var c Column
+		err = rows.Scan(&c.Name, &c.Ordinal, &c.TypeName, &c.UserType, &c.MaxLength, &c.Precision, &c.Scale,
+			&c.Nullable, &c.Identity, &c.Computed, &c.Collation, &c.Default)
+		if err != nil {
+			return nil, err
+		}
+		if c.MaxLength > 0 && (c.TypeName == "nchar" || c.TypeName == "nvarchar") {
+			// sys.columns reports bytes
+			c.MaxLength /= 2
+		}
+		res = append(res, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("schema: table %s not found", table)
+	}
+	return res, nil
+}
+
+// PrimaryKey returns the primary key of a table, or nil if it has none.
+func PrimaryKey(ctx context.Context, q Querier, table string) (*Key, error) {
+	keys, err := keyConstraints(ctx, q, table, "is_primary_key")
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return &keys[0], nil
+}
+
+// UniqueKeys lists the unique constraints of a table.
+func UniqueKeys(ctx context.Context, q Querier, table string) ([]Key, error) {
+	return keyConstraints(ctx, q, table, "is_unique_constraint")
+}
+
+func keyConstraints(ctx context.Context, q Querier, table, flag string) ([]Key, error) {
+	rows, err := q.QueryContext(ctx, "SELECT i.name, c.name FROM sys.indexes i "+
+		"JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id "+
+		"JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id "+
+		"WHERE i.object_id = OBJECT_ID(@p1) AND i."+flag+" = 1 "+
+		"ORDER BY i.name, ic.key_ordinal", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Key
+	for rows.Next() {
+		var name, col string
+		if err = rows.Scan(&name, &col); err != nil {
+			return nil, err
+		}
+		if len(res) == 0 || res[len(res)-1].Name != name {
+			res = append(res, Key{Name: name})
+		}
+		k := &res[len(res)-1]
+		k.Columns = append(k.Columns, col)
+	}
+	return res, rows.Err()
+}
+
+// ForeignKeys lists the foreign keys of a table.
+func ForeignKeys(ctx context.Context, q Querier, table string) ([]ForeignKey, error) {
+	rows, err := q.QueryContext(ctx, "SELECT fk.name, pc.name, rs.name, rt.name, rc.name, "+
+		"fk.delete_referential_action_desc, fk.update_referential_action_desc, fk.is_disabled "+
+		"FROM sys.foreign_keys fk "+
+		"JOIN sys.foreign_key_columns fkc ON fkc.constraint_object_id = fk.object_id "+
+		"JOIN sys.columns pc ON pc.object_id = fkc.parent_object_id AND pc.column_id = fkc.parent_column_id "+
+		"JOIN sys.tables rt ON rt.object_id = fkc.referenced_object_id "+
+		"JOIN sys.schemas rs ON rs.schema_id = rt.schema_id "+
+		"JOIN sys.columns rc ON rc.object_id = fkc.referenced_object_id AND rc.column_id = fkc.referenced_column_id "+
+		"WHERE fk.parent_object_id = OBJECT_ID(@p1) "+
+		"ORDER BY fk.name, fkc.constraint_column_id", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []ForeignKey
+	for rows.Next() {
+		var fk ForeignKey
+		var col, refCol string
+		err = rows.Scan(&fk.Name, &col, &fk.RefSchema, &fk.RefTable, &refCol, &fk.OnDelete, &fk.OnUpdate, &fk.Disabled)
+		if err != nil {
+			return nil, err
+		}
+		if len(res) == 0 || res[len(res)-1].Name != fk.Name {
+			res = append(res, fk)
+		}
+		last := &res[len(res)-1]
+		last.Columns = append(last.Columns, col)
+		last.RefColumns = append(last.RefColumns, refCol)
+	}
+	return res, rows.Err()
+}
+
+// Indexes lists the indexes of a table, heaps are left out.
+func Indexes(ctx context.Context, q Querier, table string) ([]Index, error) {
+	rows, err := q.QueryContext(ctx, "SELECT i.name, i.type_desc, i.is_unique, i.is_primary_key, "+
+		"ISNULL(i.filter_definition, N''), c.name, ic.is_descending_key, ic.is_included_column "+
+		"FROM sys.indexes i "+
+		"JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id "+
+		"JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id "+
+		"WHERE i.object_id = OBJECT_ID(@p1) AND i.type > 0 "+
+		"ORDER BY i.index_id, ic.is_included_column, ic.key_ordinal, ic.index_column_id", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Index
+	for rows.Next() {
+		var ix Index
+		var col IndexColumn
+		err = rows.Scan(&ix.Name, &ix.Type, &ix.Unique, &ix.PrimaryKey, &ix.Filter, &col.Name, &col.Descending, &col.Included)
+		if err != nil {
+			return nil, err
+		}
+		if len(res) == 0 || res[len(res)-1].Name != ix.Name {
+			res = append(res, ix)
+		}
+		last := &res[len(res)-1]
+		last.Columns = append(last.Columns, col)
+	}
+	return res, rows.Err()
+}
+
+// ResultColumn describes a column of a query result as reported by the
+// driver.
+type ResultColumn struct {
+	Name string
+	// TypeName is the database type name, e.g. "NVARCHAR".
+	TypeName string
+	// Length is set for variable length types.
+	Length    int64
+	HasLength bool
+	Precision int64
+	Scale     int64
+	HasScale  bool
+	Nullable  bool
+}
+
+// ResultColumns returns the columns of the result of query without
+// reading any rows, the query is wrapped in SET FMTONLY.
+func ResultColumns(ctx context.Context, q Querier, query string, args ...interface{}) ([]ResultColumn, error) {
+	rows, err := q.QueryContext(ctx, "SET FMTONLY ON; "+query+"; SET FMTONLY OFF", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]ResultColumn, len(types))
+	for i, ct := range types {
+		c := ResultColumn{Name: ct.Name(), TypeName: ct.DatabaseTypeName()}
+		c.Length, c.HasLength = ct.Length()
+		c.Precision, c.Scale, c.HasScale = ct.DecimalSize()
+		c.Nullable, _ = ct.Nullable()
+		res[i] = c
+	}
+	return res, nil
+}
+
+// QuoteName quotes an identifier with brackets.
+func QuoteName(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func nvarchar(names ...string) []mssqltest.Column {
+	cols := make([]mssqltest.Column, len(names))
+	for i, n := range names {
+		cols[i] = mssqltest.Column{Name: n, Type: mssqltest.NVarChar}
+	}
+	return cols
+}
+
+func open(t *testing.T, responses map[string]mssqltest.ResultSet) (*sql.DB, func()) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		for prefix, rs := range responses {
+			if strings.HasPrefix(req.SQL, prefix) {
+				return []mssqltest.Response{rs}
+			}
+		}
+		return []mssqltest.Response{mssqltest.Error{Number: 208, Class: 16, Message: "unexpected query " + req.SQL}}
+	})
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		srv.Close()
+	}
+}
+
+func TestColumns(t *testing.T) {
+	cols := append(nvarchar("name"), mssqltest.Column{Name: "column_id", Type: mssqltest.Int})
+	cols = append(cols, nvarchar("type", "user_type")...)
+	cols = append(cols,
+		mssqltest.Column{Name: "max_length", Type: mssqltest.BigInt},
+		mssqltest.Column{Name: "precision", Type: mssqltest.Int},
+		mssqltest.Column{Name: "scale", Type: mssqltest.Int},
+		mssqltest.Column{Name: "is_nullable", Type: mssqltest.Bit},
+		mssqltest.Column{Name: "is_identity", Type: mssqltest.Bit},
+		mssqltest.Column{Name: "is_computed", Type: mssqltest.Bit},
+	)
+	cols = append(cols, nvarchar("collation", "default")...)
+	db, done := open(t, map[string]mssqltest.ResultSet{
+		"SELECT c.name, c.column_id": {Columns: cols, Rows: [][]interface{}{
+			{"ID", int64(1), "int", "", int64(4), int64(10), int64(0), false, true, false, "", ""},
+			{"Name", int64(2), "nvarchar", "", int64(100), int64(0), int64(0), true, false, false, "Latin1_General_CI_AS", ""},
+			{"Body", int64(3), "varbinary", "", int64(-1), int64(0), int64(0), true, false, false, "", ""},
+			{"Price", int64(4), "decimal", "", int64(9), int64(10), int64(2), false, false, false, "", "((0))"},
+		}},
+	})
+	defer done()
+
+	got, err := Columns(context.Background(), db, "dbo.Orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make([]string, len(got))
+	for i := range got {
+		types[i] = got[i].SQLType()
+	}
+	want := []string{"int", "nvarchar(50)", "varbinary(max)", "decimal(10, 2)"}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("got types %v, want %v", types, want)
+	}
+	if !got[0].Identity || got[0].Nullable || got[3].Default != "((0))" || got[1].Collation != "Latin1_General_CI_AS" {
+		t.Errorf("unexpected columns %+v", got)
+	}
+}
+
+func TestForeignKeysAndIndexes(t *testing.T) {
+	bit := func(name string) mssqltest.Column { return mssqltest.Column{Name: name, Type: mssqltest.Bit} }
+	db, done := open(t, map[string]mssqltest.ResultSet{
+		"SELECT fk.name": {
+			Columns: append(nvarchar("name", "col", "ref_schema", "ref_table", "ref_col", "on_delete", "on_update"), bit("is_disabled")),
+			Rows: [][]interface{}{
+				{"FK_Lines_Orders", "OrderID", "dbo", "Orders", "ID", "CASCADE", "NO_ACTION", false},
+				{"FK_Lines_Products", "ProductID", "dbo", "Products", "ID", "NO_ACTION", "NO_ACTION", false},
+				{"FK_Lines_Products", "Variant", "dbo", "Products", "Variant", "NO_ACTION", "NO_ACTION", false},
+			},
+		},
+		"SELECT i.name, i.type_desc": {
+			Columns: []mssqltest.Column{
+				{Name: "name", Type: mssqltest.NVarChar}, {Name: "type", Type: mssqltest.NVarChar},
+				bit("is_unique"), bit("is_primary_key"), {Name: "filter", Type: mssqltest.NVarChar},
+				{Name: "col", Type: mssqltest.NVarChar}, bit("is_descending_key"), bit("is_included_column"),
+			},
+			Rows: [][]interface{}{
+				{"PK_Lines", "CLUSTERED", true, true, "", "OrderID", false, false},
+				{"PK_Lines", "CLUSTERED", true, true, "", "LineNo", false, false},
+				{"IX_Product", "NONCLUSTERED", false, false, "([ProductID] IS NOT NULL)", "ProductID", true, false},
+				{"IX_Product", "NONCLUSTERED", false, false, "([ProductID] IS NOT NULL)", "Quantity", false, true},
+			},
+		},
+		"SELECT i.name, c.name": {
+			Columns: nvarchar("name", "col"),
+			Rows:    [][]interface{}{{"PK_Lines", "OrderID"}, {"PK_Lines", "LineNo"}},
+		},
+	})
+	defer done()
+	ctx := context.Background()
+
+	fks, err := ForeignKeys(ctx, db, "dbo.Lines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fks) != 2 || fks[0].OnDelete != "CASCADE" || !reflect.DeepEqual(fks[1].Columns, []string{"ProductID", "Variant"}) ||
+		!reflect.DeepEqual(fks[1].RefColumns, []string{"ID", "Variant"}) {
+		t.Errorf("unexpected foreign keys %+v", fks)
+	}
+
+	ixs, err := Indexes(ctx, db, "dbo.Lines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ixs) != 2 || !ixs[0].PrimaryKey || len(ixs[0].Columns) != 2 || ixs[1].Filter == "" ||
+		!ixs[1].Columns[0].Descending || !ixs[1].Columns[1].Included {
+		t.Errorf("unexpected indexes %+v", ixs)
+	}
+
+	pk, err := PrimaryKey(ctx, db, "dbo.Lines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk == nil || pk.Name != "PK_Lines" || !reflect.DeepEqual(pk.Columns, []string{"OrderID", "LineNo"}) {
+		t.Errorf("unexpected primary key %+v", pk)
+	}
+}
+
+func TestResultColumns(t *testing.T) {
+	db, done := open(t, map[string]mssqltest.ResultSet{
+		"SET FMTONLY ON; ": {Columns: []mssqltest.Column{
+			{Name: "ID", Type: mssqltest.BigInt},
+			{Name: "Name", Type: mssqltest.NVarChar},
+		}},
+	})
+	defer done()
+
+	cols, err := ResultColumns(context.Background(), db, "select ID, Name from dbo.Orders where ID = @p1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 2 || cols[0].Name != "ID" || cols[0].TypeName != "BIGINT" || cols[1].TypeName != "NVARCHAR" || !cols[1].HasLength {
+		t.Errorf("unexpected columns %+v", cols)
+	}
+}
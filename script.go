@@ -0,0 +1,139 @@
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/denisenkom/go-mssqldb/batch"
+)
+
+// ScriptOptions controls RunScript.
+type ScriptOptions struct {
+	// Name identifies the script in errors, e.g. its file name.
+	Name string
+	// Separator separates batches, it defaults to "GO".
+	Separator string
+	// Transaction runs all batches in a single transaction that is rolled
+	// back when a batch fails. Otherwise every batch commits on its own.
+	Transaction bool
+	// ContinueOnError runs the remaining batches after a batch failed. It
+	// is ignored when Transaction is set.
+	ContinueOnError bool
+}
+
+// ScriptError is returned by RunScript when batches failed. It lists every
+// error with the script line it happened on.
+type ScriptError struct {
+	Name   string
+	Errors []ScriptErrorLine
+}
+
+// ScriptErrorLine is an error raised by a batch of a script.
+type ScriptErrorLine struct {
+	// Batch is the index of the failed batch, counted from 1.
+	Batch int
+	// Line is the line of the script the error was raised on, counted
+	// from 1. It is the first line of the batch when the server did not
+	// report a line.
+	Line int
+	// Err is the error, an Error for errors raised by the server.
+	Err error
+}
+
+func (e *ScriptError) Error() string {
+	var b strings.Builder
+	for i, l := range e.Errors {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		name := e.Name
+		if name == "" {
+			name = "script"
+		}
+		fmt.Fprintf(&b, "%s:%d: %v", name, l.Line, l.Err)
+	}
+	return b.String()
+}
+
+// RunScript reads a script, splits it into batches and runs them in order
+// on a single connection, so that session state such as SET options and
+// temporary tables carries over from one batch to the next. Batches are
+// separated as by sqlcmd, see the batch package: a batch followed by
+// "GO n" runs n times.
+//
+// Line numbers of the errors raised by the server are mapped back to the
+// lines of the script and reported in a *ScriptError.
+func RunScript(ctx context.Context, db *sql.DB, r io.Reader, opts ScriptOptions) error {
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sep := opts.Separator
+	if sep == "" {
+		sep = "GO"
+	}
+	batches := batch.SplitLines(string(text), sep)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var (
+		exec interface {
+			ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		} = conn
+		tx *sql.Tx
+	)
+	if opts.Transaction {
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		exec = tx
+	}
+
+	scriptErr := &ScriptError{Name: opts.Name}
+	for i, b := range batches {
+		if strings.TrimSpace(b.Text) == "" {
+			continue
+		}
+		if _, err = exec.ExecContext(ctx, b.Text); err == nil {
+			continue
+		}
+		scriptErr.add(i+1, b.Line, err)
+		if opts.Transaction || !opts.ContinueOnError || ctx.Err() != nil {
+			break
+		}
+	}
+	if len(scriptErr.Errors) > 0 {
+		return scriptErr
+	}
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+func (e *ScriptError) add(batchIndex, batchLine int, err error) {
+	sqlErr, ok := err.(Error)
+	if !ok {
+		e.Errors = append(e.Errors, ScriptErrorLine{Batch: batchIndex, Line: batchLine, Err: err})
+		return
+	}
+	all := sqlErr.All
+	if len(all) == 0 {
+		all = []Error{sqlErr}
+	}
+	for _, se := range all {
+		line := batchLine
+		if se.LineNo > 0 {
+			line += int(se.LineNo) - 1
+		}
+		e.Errors = append(e.Errors, ScriptErrorLine{Batch: batchIndex, Line: line, Err: se})
+	}
+}
@@ -0,0 +1,109 @@
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+const testScript = `create table t (id int)
+GO
+insert into t values (1)
+select bad
+GO
+select 3
+`
+
+func runTestScript(t *testing.T, opts ScriptOptions) ([]*mssqltest.Request, error) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if strings.Contains(req.SQL, "select bad") {
+			return []mssqltest.Response{mssqltest.Error{Number: 207, State: 1, Class: 16, Message: "Invalid column name 'bad'.", LineNo: 3}}
+		}
+		return []mssqltest.Response{mssqltest.RowsAffected(1)}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = RunScript(context.Background(), db, strings.NewReader(testScript), opts)
+	return srv.Requests(), err
+}
+
+func TestRunScriptErrorLines(t *testing.T) {
+	reqs, err := runTestScript(t, ScriptOptions{Name: "setup.sql"})
+	serr, ok := err.(*ScriptError)
+	if !ok {
+		t.Fatalf("expected a script error, got %v", err)
+	}
+	if len(serr.Errors) != 1 || serr.Errors[0].Batch != 2 || serr.Errors[0].Line != 4 {
+		t.Fatalf("unexpected errors %+v", serr.Errors)
+	}
+	if sqlErr, ok := serr.Errors[0].Err.(Error); !ok || sqlErr.Number != 207 {
+		t.Errorf("expected the server error, got %v", serr.Errors[0].Err)
+	}
+	if got := serr.Error(); got != "setup.sql:4: mssql: Invalid column name 'bad'." {
+		t.Errorf("unexpected message %q", got)
+	}
+	for _, req := range reqs {
+		if strings.Contains(req.SQL, "select 3") {
+			t.Error("the script continued after the error")
+		}
+	}
+}
+
+func TestRunScriptContinueOnError(t *testing.T) {
+	reqs, err := runTestScript(t, ScriptOptions{ContinueOnError: true})
+	if _, ok := err.(*ScriptError); !ok {
+		t.Fatalf("expected a script error, got %v", err)
+	}
+	if last := reqs[len(reqs)-1]; !strings.Contains(last.SQL, "select 3") {
+		t.Errorf("the last batch did not run, last request %q", last.SQL)
+	}
+}
+
+func TestRunScriptTransaction(t *testing.T) {
+	reqs, err := runTestScript(t, ScriptOptions{Transaction: true, ContinueOnError: true})
+	if _, ok := err.(*ScriptError); !ok {
+		t.Fatalf("expected a script error, got %v", err)
+	}
+	var types []mssqltest.RequestType
+	for _, req := range reqs {
+		if req.Type != mssqltest.SQLBatch && req.Type != mssqltest.RPC {
+			types = append(types, req.Type)
+		}
+	}
+	if len(types) != 2 || types[0] != mssqltest.BeginTran || types[1] != mssqltest.RollbackTran {
+		t.Errorf("expected the transaction to be rolled back, got %v", types)
+	}
+}
+
+func TestRunScriptRepeatCount(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	script := "insert into t default values\nGO 3 -- three rows\nselect count(*) from t\nGO\n"
+	if err = RunScript(context.Background(), db, strings.NewReader(script), ScriptOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var inserts, selects int
+	for _, req := range srv.Requests() {
+		switch {
+		case strings.Contains(req.SQL, "insert"):
+			inserts++
+		case strings.Contains(req.SQL, "select"):
+			selects++
+		}
+	}
+	if inserts != 3 || selects != 1 {
+		t.Errorf("expected 3 inserts and 1 select, got %d and %d", inserts, selects)
+	}
+}
@@ -0,0 +1,168 @@
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// sessionRecovery is the state of a session that negotiated the
+// SESSIONRECOVERY feature extension, also known as connection resiliency.
+// When the connection of an idle session breaks, the session is
+// re-established on a new connection that sends this state back to the
+// server, which restores it.
+//
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-tds/1e9f6a4e-dd0e-4d21-a9d9-b5d5e5e34c06
+type sessionRecovery struct {
+	// initial is the InitSessionRecoveryData of the feature
+	// acknowledgement, sent back as is
+	initial []byte
+	// states holds the session states the server sent in SESSIONSTATE
+	// tokens, by state id
+	states map[byte][]byte
+	// recoverable is cleared while the server reports the session state
+	// cannot be recovered
+	recoverable bool
+}
+
+func newSessionRecovery(initial []byte) *sessionRecovery {
+	return &sessionRecovery{
+		initial:     initial,
+		states:      map[byte][]byte{},
+		recoverable: true,
+	}
+}
+
+// featureExtSessionRecovery asks for connection resiliency. Recovery is nil
+// for a new session, otherwise it holds the state of the session to
+// recover.
+type featureExtSessionRecovery struct {
+	recovery  *sessionRecovery
+	database  string
+	language  string
+	collation []byte
+}
+
+func (e *featureExtSessionRecovery) featureID() byte {
+	return featExtSESSIONRECOVERY
+}
+
+func (e *featureExtSessionRecovery) toBytes() []byte {
+	if e.recovery == nil {
+		return nil
+	}
+	// SessionRecoveryDataToBeReset, the current state of the session
+	var reset bytes.Buffer
+	reset.Write([]byte{0, 0, 0, 0})
+	writeBVarChar(&reset, e.database)
+	reset.WriteByte(byte(len(e.collation)))
+	reset.Write(e.collation)
+	writeBVarChar(&reset, e.language)
+	for id := 0; id < 256; id++ {
+		if value, ok := e.recovery.states[byte(id)]; ok {
+			writeSessionState(&reset, byte(id), value)
+		}
+	}
+	b := reset.Bytes()
+	binary.LittleEndian.PutUint32(b, uint32(len(b)-4))
+
+	data := make([]byte, 4, 4+len(e.recovery.initial)+len(b))
+	binary.LittleEndian.PutUint32(data, uint32(len(e.recovery.initial)+len(b)))
+	data = append(data, e.recovery.initial...)
+	return append(data, b...)
+}
+
+func writeSessionState(w *bytes.Buffer, id byte, value []byte) {
+	w.WriteByte(id)
+	if len(value) < 0xff {
+		w.WriteByte(byte(len(value)))
+	} else {
+		w.WriteByte(0xff)
+		binary.Write(w, binary.LittleEndian, uint32(len(value)))
+	}
+	w.Write(value)
+}
+
+// parseSessionState reads a SESSIONSTATE token into the recovery state of
+// the session, it is skipped if the session does not support recovery.
+func parseSessionState(sess *tdsSession) {
+	length := sess.buf.uint32()
+	r := &io.LimitedReader{R: sess.buf, N: int64(length)}
+	if sess.recovery == nil {
+		io.Copy(ioutil.Discard, r)
+		return
+	}
+	var hdr [5]byte // SeqNo DWORD, Status BYTE
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		badStreamPanic(err)
+	}
+	sess.recovery.recoverable = hdr[4]&0x01 != 0
+	for r.N > 0 {
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			badStreamPanic(err)
+		}
+		id := b[0]
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			badStreamPanic(err)
+		}
+		size := uint32(b[0])
+		if size == 0xff {
+			if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+				badStreamPanic(err)
+			}
+		}
+		if int64(size) > r.N {
+			badStreamPanicf("invalid session state length %d", size)
+		}
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			badStreamPanic(err)
+		}
+		sess.recovery.states[id] = value
+	}
+}
+
+// recoverSession makes sure the connection of an idle session is usable
+// before a request is sent. A connection the server or the network closed,
+// or that a failed request left unusable, is replaced by a new one that
+// recovers the session, when the server negotiated connection resiliency,
+// the session is recoverable and no transaction is open. Otherwise
+// driver.ErrBadConn is returned so that database/sql retries the request
+// on another connection.
+func (c *Conn) recoverSession(ctx context.Context) error {
+	if c.sess.recovery == nil || c.connectionGood && connAlive(c.sess.conn) {
+		return nil
+	}
+	c.sess.buf.transport.Close()
+	c.connectionGood = false
+	if !c.sess.recovery.recoverable || c.sess.tranid != 0 {
+		return driver.ErrBadConn
+	}
+	d := c.connector.driver
+	if d == nil {
+		d = driverInstanceNoProcess
+	}
+	recovery := &featureExtSessionRecovery{
+		recovery:  c.sess.recovery,
+		database:  c.sess.database,
+		language:  c.sess.language,
+		collation: c.sess.collation,
+	}
+	conn, err := d.connect(ctx, c.connector, c.connector.params, recovery)
+	if err != nil {
+		if c.sess.logFlags&logErrors != 0 {
+			c.sess.log.Printf("Failed to recover the session: %v", err)
+		}
+		return driver.ErrBadConn
+	}
+	if c.sess.logFlags&logDebug != 0 {
+		c.sess.log.Printf("recovered the session on a new connection to %s", conn.server)
+	}
+	c.sess, c.server = conn.sess, conn.server
+	c.connectionGood = true
+	return nil
+}
@@ -0,0 +1,139 @@
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestSessionRecovery(t *testing.T) {
+	var notRecoverable int32
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if strings.HasPrefix(req.SQL, "set") {
+			return []mssqltest.Response{mssqltest.SessionState{
+				SeqNo:       1,
+				Recoverable: atomic.LoadInt32(&notRecoverable) == 0,
+				States:      map[byte][]byte{1: []byte("abc")},
+			}}
+		}
+		return nil
+	})
+	defer srv.Close()
+	srv.SessionRecovery = true
+
+	c, err := NewConnector(srv.DSN() + "&database=sales&connectretrycount=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "set language us_english"); err != nil {
+		t.Fatal(err)
+	}
+	srv.DropConnections()
+	time.Sleep(10 * time.Millisecond)
+	if _, err = conn.ExecContext(ctx, "select 1"); err != nil {
+		t.Fatalf("expected the session to be recovered, got %v", err)
+	}
+
+	logins := srv.Logins()
+	if len(logins) != 2 {
+		t.Fatalf("expected 2 logins, got %d", len(logins))
+	}
+	if !logins[0].SessionRecovery || logins[0].RecoveryData != nil {
+		t.Errorf("expected the first login to ask for session recovery without recovery data")
+	}
+	data := logins[1].RecoveryData
+	if !bytes.Contains(data, str2ucs2("sales")) || !bytes.Contains(data, []byte{1, 3, 'a', 'b', 'c'}) {
+		t.Errorf("expected the database and the session state in the recovery data, got %x", data)
+	}
+
+	// a session that is not recoverable is reported as a bad connection
+	atomic.StoreInt32(&notRecoverable, 1)
+	if _, err = conn.ExecContext(ctx, "set xact_abort on"); err != nil {
+		t.Fatal(err)
+	}
+	srv.DropConnections()
+	time.Sleep(10 * time.Millisecond)
+	if _, err = conn.ExecContext(ctx, "select 1"); err != driver.ErrBadConn {
+		t.Fatalf("expected driver.ErrBadConn, got %v", err)
+	}
+	if n := len(srv.Logins()); n != 2 {
+		t.Errorf("expected no recovery login, got %d logins", n)
+	}
+}
+
+func TestSessionRecoveryNotRequested(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	srv.SessionRecovery = true
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.(*Conn).sess.recovery != nil {
+		t.Error("expected no session recovery without connectretrycount")
+	}
+	if srv.Logins()[0].SessionRecovery {
+		t.Error("expected the login not to ask for session recovery")
+	}
+}
+
+func TestIsValidDiscardsClosedConnections(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	ctx := context.Background()
+	if err = db.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pooled connection is closed by the server while idle
+	srv.DropConnections()
+	time.Sleep(10 * time.Millisecond)
+	if _, err = db.ExecContext(ctx, "select 1"); err != nil {
+		t.Fatalf("expected the closed connection to be discarded, got %v", err)
+	}
+	if n := len(srv.Logins()); n != 2 {
+		t.Errorf("expected a new connection, got %d logins", n)
+	}
+
+	conn, err := c.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	v := conn.(*Conn)
+	if !v.IsValid() {
+		t.Fatal("expected a new connection to be valid")
+	}
+	srv.DropConnections()
+	time.Sleep(10 * time.Millisecond)
+	if v.IsValid() {
+		t.Error("expected a connection closed by the server not to be valid")
+	}
+}
@@ -0,0 +1,102 @@
+package mssql
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/denisenkom/go-mssqldb/internal/spatial"
+)
+
+// Geometry is a geometry value, as the Well Known Text of its shape and its
+// spatial reference id. Scanned from a geometry column it holds the text
+// STAsText returns, such as POLYGON ((0 0, 10 0, 10 10, 0 0)), sparing the
+// conversion in the query. As a parameter its shape is sent serialized as
+// the server stores it, converted to geometry implicitly:
+//
+//	_, err = db.ExecContext(ctx, "insert into shapes (shape) values (@p1)",
+//		mssql.Geometry{WKT: "LINESTRING (1 1, 2 2)"})
+//
+// The points may have Z and M values. Shapes must be valid, in the sense of
+// STIsValid, the server does not check them. The circular arcs of SQL
+// Server 2012 have no Well Known Text and cannot be scanned. Scan nullable
+// columns into a *Geometry.
+type Geometry struct {
+	SRID int
+	WKT  string
+}
+
+// Geography is a geography value, see Geometry. The points of its text have
+// the longitude first, such as POINT (-122.349 47.651), and its SRID is
+// usually 4326 for WGS 84.
+type Geography struct {
+	SRID int
+	WKT  string
+}
+
+// Scan implements the sql.Scanner interface, it accepts the serialized
+// values of geometry columns.
+func (g *Geometry) Scan(src interface{}) (err error) {
+	g.SRID, g.WKT, err = scanSpatial(src, false)
+	return err
+}
+
+// Scan implements the sql.Scanner interface, it accepts the serialized
+// values of geography columns.
+func (g *Geography) Scan(src interface{}) (err error) {
+	g.SRID, g.WKT, err = scanSpatial(src, true)
+	return err
+}
+
+// WKB returns the Well Known Binary of the shape of g.
+func (g Geometry) WKB() ([]byte, error) {
+	v, err := spatial.ParseWKT(g.WKT)
+	if err != nil {
+		return nil, err
+	}
+	return v.WKB(), nil
+}
+
+// WKB returns the Well Known Binary of the shape of g, with the longitude
+// of the points first.
+func (g Geography) WKB() ([]byte, error) {
+	return Geometry(g).WKB()
+}
+
+func scanSpatial(src interface{}, geography bool) (int, string, error) {
+	name := "Geometry"
+	if geography {
+		name = "Geography"
+	}
+	switch b := src.(type) {
+	case []byte:
+		srid, v, err := spatial.Decode(b, geography)
+		if err != nil {
+			return 0, "", fmt.Errorf("mssql: cannot scan into a %s: %v", name, err)
+		}
+		return srid, v.WKT(), nil
+	case nil:
+		return 0, "", errors.New("mssql: cannot scan NULL into a " + name + ", scan into a *" + name)
+	}
+	return 0, "", fmt.Errorf("mssql: cannot scan a value of type %T into a %s", src, name)
+}
+
+// param returns the varbinary parameter of the serialization of g.
+func (g Geometry) param() (param, error) {
+	return spatialParam(g.SRID, g.WKT, false)
+}
+
+// param returns the varbinary parameter of the serialization of g.
+func (g Geography) param() (param, error) {
+	return spatialParam(g.SRID, g.WKT, true)
+}
+
+func spatialParam(srid int, wkt string, geography bool) (res param, err error) {
+	v, err := spatial.ParseWKT(wkt)
+	if err != nil {
+		return res, err
+	}
+	res.ti.TypeId = typeBigVarBin
+	res.buffer = spatial.Encode(srid, v, geography)
+	res.ti.Size = len(res.buffer)
+	return res, nil
+}
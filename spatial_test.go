@@ -0,0 +1,57 @@
+package mssql
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestGeography(t *testing.T) {
+	serialized, _ := hex.DecodeString("E6100000010C17D9CEF753D347407593180456965EC0")
+	var g Geography
+	if err := g.Scan(serialized); err != nil {
+		t.Fatal(err)
+	}
+	if g.SRID != 4326 || g.WKT != "POINT (-122.349 47.651)" {
+		t.Errorf("unexpected geography %+v", g)
+	}
+	p, err := g.param()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ti.TypeId != typeBigVarBin || !bytes.Equal(p.buffer, serialized) {
+		t.Errorf("expected the parameter %X, got %X", serialized, p.buffer)
+	}
+	if err := g.Scan(nil); err == nil {
+		t.Error("expected an error scanning NULL into a Geography")
+	}
+	if err := g.Scan(serialized[:10]); err == nil {
+		t.Error("expected an error scanning a truncated value")
+	}
+	if _, err := (Geography{SRID: 4326, WKT: "POINT (1)"}).param(); err == nil {
+		t.Error("expected an error for invalid text")
+	}
+}
+
+func TestGeometry(t *testing.T) {
+	g := Geometry{WKT: "POLYGON ((0 0, 10 0, 10 10, 0 0))"}
+	p, err := g.param()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanned Geometry
+	if err := scanned.Scan(p.buffer); err != nil {
+		t.Fatal(err)
+	}
+	if scanned != g {
+		t.Errorf("expected %+v, got %+v", g, scanned)
+	}
+	wkb, err := Geometry{WKT: "POINT (1 2)"}.WKB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := hex.DecodeString("0101000000000000000000F03F0000000000000040")
+	if !bytes.Equal(wkb, expected) {
+		t.Errorf("expected %X, got %X", expected, wkb)
+	}
+}
@@ -1,21 +1,40 @@
 package mssql
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"syscall"
 	"unsafe"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
 )
 
 var (
 	secur32_dll           = syscall.NewLazyDLL("secur32.dll")
 	initSecurityInterface = secur32_dll.NewProc("InitSecurityInterfaceW")
 	sec_fn                *SecurityFunctionTable
+	sec_fn_once           sync.Once
+	sec_fn_err            error
 )
 
-func init() {
-	ptr, _, _ := initSecurityInterface.Call()
-	sec_fn = (*SecurityFunctionTable)(unsafe.Pointer(ptr))
+// loadSecurityInterface loads SSPI on first use, so that processes where
+// secur32.dll is not available can still use the Go authenticators.
+func loadSecurityInterface() error {
+	sec_fn_once.Do(func() {
+		if sec_fn_err = initSecurityInterface.Find(); sec_fn_err != nil {
+			return
+		}
+		ptr, _, _ := initSecurityInterface.Call()
+		if ptr == 0 {
+			sec_fn_err = errors.New("InitSecurityInterface failed")
+			return
+		}
+		sec_fn = (*SecurityFunctionTable)(unsafe.Pointer(ptr))
+	})
+	return sec_fn_err
 }
 
 const (
@@ -33,6 +52,7 @@ const (
 	SEC_I_COMPLETE_AND_CONTINUE     = 0x00090314
 	SECBUFFER_VERSION               = 0
 	SECBUFFER_TOKEN                 = 2
+	SECBUFFER_CHANNEL_BINDINGS      = 14
 	NTLMBUF_LEN                     = 12000
 )
 
@@ -109,27 +129,56 @@ type SSPIAuth struct {
 	UserName string
 	Password string
 	Service  string
-	cred     SecHandle
-	ctxt     SecHandle
+	// ChannelBindings is the application data of the TLS channel binding
+	// passed to SSPI for Extended Protection.
+	ChannelBindings []byte
+	cred            SecHandle
+	ctxt            SecHandle
 }
 
-func getAuth(user, password, service, workstation string) (auth, bool) {
-	if user == "" {
-		return &SSPIAuth{Service: service}, true
+func getAuth(p msdsn.Config) (auth, bool) {
+	if p.User == "" {
+		return &SSPIAuth{Service: p.ServerSPN}, true
 	}
-	if !strings.ContainsRune(user, '\\') {
+	if !strings.ContainsRune(p.User, '\\') {
 		return nil, false
 	}
-	domain_user := strings.SplitN(user, "\\", 2)
+	domain_user := strings.SplitN(p.User, "\\", 2)
 	return &SSPIAuth{
 		Domain:   domain_user[0],
 		UserName: domain_user[1],
-		Password: password,
-		Service:  service,
+		Password: p.Password,
+		Service:  p.ServerSPN,
 	}, true
 }
 
+func (auth *SSPIAuth) setChannelBindings(applicationData []byte) {
+	auth.ChannelBindings = applicationData
+}
+
+// channelBindingsBuffer returns the SEC_CHANNEL_BINDINGS input buffer, nil
+// without channel bindings. The bindings are given to every call of
+// InitializeSecurityContext.
+func (auth *SSPIAuth) channelBindingsBuffer() *SecBuffer {
+	if auth.ChannelBindings == nil {
+		return nil
+	}
+	// SEC_CHANNEL_BINDINGS without addresses, followed by the application data
+	b := make([]byte, 32+len(auth.ChannelBindings))
+	binary.LittleEndian.PutUint32(b[24:], uint32(len(auth.ChannelBindings)))
+	binary.LittleEndian.PutUint32(b[28:], 32)
+	copy(b[32:], auth.ChannelBindings)
+	return &SecBuffer{
+		cbBuffer:   uint32(len(b)),
+		BufferType: SECBUFFER_CHANNEL_BINDINGS,
+		pvBuffer:   &b[0],
+	}
+}
+
 func (auth *SSPIAuth) InitialBytes() ([]byte, error) {
+	if err := loadSecurityInterface(); err != nil {
+		return nil, fmt.Errorf("SSPI is not available: %v", err)
+	}
 	var identity *SEC_WINNT_AUTH_IDENTITY
 	if auth.UserName != "" {
 		identity = &SEC_WINNT_AUTH_IDENTITY{
@@ -169,6 +218,15 @@ func (auth *SSPIAuth) InitialBytes() ([]byte, error) {
 	buf.BufferType = SECBUFFER_TOKEN
 	buf.pvBuffer = &outbuf[0]
 
+	var in_desc *SecBufferDesc
+	if cb := auth.channelBindingsBuffer(); cb != nil {
+		in_desc = &SecBufferDesc{
+			ulVersion: SECBUFFER_VERSION,
+			cBuffers:  1,
+			pBuffers:  cb,
+		}
+	}
+
 	var attrs uint32
 	sec_ok, _, _ = syscall.Syscall12(sec_fn.InitializeSecurityContext,
 		12,
@@ -178,7 +236,7 @@ func (auth *SSPIAuth) InitialBytes() ([]byte, error) {
 		ISC_REQ,
 		0,
 		SECURITY_NETWORK_DREP,
-		0,
+		uintptr(unsafe.Pointer(in_desc)),
 		0,
 		uintptr(unsafe.Pointer(&auth.ctxt)),
 		uintptr(unsafe.Pointer(&desc)),
@@ -203,12 +261,18 @@ func (auth *SSPIAuth) InitialBytes() ([]byte, error) {
 }
 
 func (auth *SSPIAuth) NextBytes(bytes []byte) ([]byte, error) {
-	var in_buf, out_buf SecBuffer
+	var in_bufs [2]SecBuffer
+	var out_buf SecBuffer
 	var in_desc, out_desc SecBufferDesc
+	in_buf := &in_bufs[0]
 
 	in_desc.ulVersion = SECBUFFER_VERSION
 	in_desc.cBuffers = 1
-	in_desc.pBuffers = &in_buf
+	in_desc.pBuffers = in_buf
+	if cb := auth.channelBindingsBuffer(); cb != nil {
+		in_bufs[1] = *cb
+		in_desc.cBuffers = 2
+	}
 
 	out_desc.ulVersion = SECBUFFER_VERSION
 	out_desc.cBuffers = 1
@@ -255,6 +319,9 @@ func (auth *SSPIAuth) NextBytes(bytes []byte) ([]byte, error) {
 }
 
 func (auth *SSPIAuth) Free() {
+	if sec_fn == nil {
+		return
+	}
 	syscall.Syscall6(sec_fn.DeleteSecurityContext,
 		1,
 		uintptr(unsafe.Pointer(&auth.ctxt)),
@@ -0,0 +1,70 @@
+package mssql
+
+// StatementResult is the outcome of a statement of a batch, see
+// StatementResults.
+type StatementResult struct {
+	// RowsAffected is the number of rows the statement affected, or
+	// returned for a SELECT, -1 when the server did not count them, such as
+	// with SET NOCOUNT ON.
+	RowsAffected int64
+	// Err is the error the statement raised, an Error whose All field lists
+	// every error of the statement, nil if it raised none.
+	Err error
+}
+
+// StatementResults receives the outcome of every statement of a batch,
+// in order, when a *StatementResults is passed as an argument of the
+// batch. The error of ExecContext and QueryContext is the first error of
+// the batch, while the statements after a failed statement may have run:
+//
+//	var results mssql.StatementResults
+//	_, err := db.ExecContext(ctx, "insert into t values (1); update u set a = 1; delete from v", &results)
+//	for i, r := range results {
+//		log.Printf("statement %d: %d rows, error %v", i+1, r.RowsAffected, r.Err)
+//	}
+//
+// The statements are those the server reports the end of, the statements
+// such as DECLARE and variable assignments are not reported. A stored
+// procedure executed by a batch is one statement, while the statements of
+// a stored procedure called by name, or of a statement with parameters sent
+// through sp_executesql, are those of its body. The results of a query are
+// complete once its rows are closed.
+type StatementResults []StatementResult
+
+// statementResultsReader tracks the statements of a response for
+// StatementResults.
+type statementResultsReader struct {
+	dest *StatementResults
+	// rpc is set for the responses of RPC requests, whose statements end
+	// with DONEINPROC tokens
+	rpc bool
+	// reported is the number of errors of the response already reported
+	reported int
+}
+
+// done reports the statement ended by a DONE, DONEPROC or DONEINPROC
+// token, with the errors of the response raised since the previous one.
+func (r *statementResultsReader) done(tok token, d doneStruct, errs []Error) {
+	if r.dest == nil || d.Status&doneAttn != 0 {
+		return
+	}
+	switch {
+	case tok == tokenDoneInProc && !r.rpc:
+		// a statement of a procedure executed by the batch
+		return
+	case tok != tokenDoneInProc && r.rpc && len(errs) == r.reported:
+		// the end of the call, but for the errors of no statement
+		return
+	}
+	res := StatementResult{RowsAffected: -1}
+	if d.Status&doneCount != 0 {
+		res.RowsAffected = int64(d.RowCount)
+	}
+	if len(errs) > r.reported {
+		err := errs[len(errs)-1]
+		err.All = append([]Error(nil), errs[r.reported:]...)
+		res.Err = err
+		r.reported = len(errs)
+	}
+	*r.dest = append(*r.dest, res)
+}
@@ -0,0 +1,83 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func statementResultsServer() *mssqltest.Server {
+	return mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if req.SQL == "" {
+			return nil
+		}
+		return []mssqltest.Response{
+			mssqltest.RowsAffected(2),
+			mssqltest.Error{Number: 2627, Class: 14, Message: "Violation of PRIMARY KEY constraint."},
+			mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "v", Type: mssqltest.Int}},
+				Rows:    [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}},
+			},
+		}
+	})
+}
+
+func checkStatementResults(t *testing.T, results StatementResults) {
+	t.Helper()
+	if len(results) != 3 {
+		t.Fatalf("expected 3 statement results, got %+v", results)
+	}
+	if results[0].RowsAffected != 2 || results[0].Err != nil {
+		t.Errorf("expected 2 rows affected, got %+v", results[0])
+	}
+	if err, ok := results[1].Err.(Error); !ok || err.Number != 2627 || len(err.All) != 1 || results[1].RowsAffected != -1 {
+		t.Errorf("expected the error 2627, got %+v", results[1])
+	}
+	if results[2].RowsAffected != 3 || results[2].Err != nil {
+		t.Errorf("expected 3 rows returned, got %+v", results[2])
+	}
+}
+
+func TestStatementResults(t *testing.T) {
+	srv := statementResultsServer()
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, args := range [][]interface{}{nil, {1}} {
+		results := StatementResults{{}}
+		_, err = db.Exec("insert into t select @p1; insert into u values (1); select v from t", append(args, &results)...)
+		if e, ok := err.(Error); !ok || e.Number != 2627 {
+			t.Errorf("expected the error 2627, got %v", err)
+		}
+		checkStatementResults(t, results)
+	}
+}
+
+func TestStatementResultsOfQuery(t *testing.T) {
+	srv := statementResultsServer()
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var results StatementResults
+	rows, err := db.Query("insert into t select @p1; insert into u values (1); select v from t", 1, &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	checkStatementResults(t, results)
+}
@@ -0,0 +1,199 @@
+package mssql
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type statementRetryKey struct{}
+
+// WithStatementRetry returns a context that retries the statements run with
+// it, queries and executions alike, when they fail outside of a
+// transaction before returning rows and policy classifies the error as
+// transient. The caller vouches that the statements are idempotent. A nil
+// policy disables the retries, including those of
+// Connector.StatementRetryPolicy.
+func WithStatementRetry(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, statementRetryKey{}, retryPolicyValue{policy})
+}
+
+// retryPolicyValue wraps the policy of a context so that a nil policy can
+// be told from no policy.
+type retryPolicyValue struct {
+	policy RetryPolicy
+}
+
+// statementRetryPolicy returns the policy that retries the statement, nil
+// if it is not retried. The policy of the connector only applies to
+// queries that read. Statements with streamed parameters are not retried,
+// their readers cannot be read again.
+func (s *Stmt) statementRetryPolicy(ctx context.Context, query bool, args []namedValue) RetryPolicy {
+	for _, arg := range args {
+		if _, ok := arg.Value.(LOBParam); ok {
+			return nil
+		}
+	}
+	if v, ok := ctx.Value(statementRetryKey{}).(retryPolicyValue); ok {
+		return v.policy
+	}
+	if !query || s.c.connector == nil || s.c.connector.StatementRetryPolicy == nil || !isReadOnlyQuery(s.query) {
+		return nil
+	}
+	return s.c.connector.StatementRetryPolicy
+}
+
+// retryStatement reports whether the attempt of a statement that failed
+// with err is retried, after waiting for the delay of the policy. The
+// statement is not retried in a transaction, which the error may have
+// rolled back, nor when the connection became unusable and the session
+// cannot be recovered on a new one.
+func (s *Stmt) retryStatement(ctx context.Context, policy RetryPolicy, attempt int, err error) bool {
+	if err == nil || policy == nil || s.c.sess.tranid != 0 {
+		return false
+	}
+	if !s.c.connectionGood && s.c.sess.recovery == nil {
+		return false
+	}
+	if attempt >= policy.MaxRetries() || !policy.Retryable(err) {
+		return false
+	}
+	if s.c.sess.logFlags&logDebug != 0 {
+		s.c.sess.log.Printf("retrying the statement after a transient error: %v", err)
+	}
+	t := time.NewTimer(policy.Delay(attempt))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// statementKeywords are the words that disqualify a SELECT statement from
+// being a read-only query: those starting the other statements, which may
+// follow it in the batch without a semicolon, INTO, and the OPEN functions,
+// which run their text on other servers, where it may write. Words that are
+// not keywords, such as names of columns, may disqualify a query too, which
+// is then not retried.
+var statementKeywords = map[string]bool{
+	"INTO": true, "INSERT": true, "UPDATE": true, "DELETE": true,
+	"MERGE": true, "EXEC": true, "EXECUTE": true, "CREATE": true,
+	"ALTER": true, "DROP": true, "TRUNCATE": true, "GRANT": true,
+	"DENY": true, "REVOKE": true, "RECEIVE": true, "SEND": true,
+	"DBCC": true, "BACKUP": true, "RESTORE": true, "DUMP": true,
+	"LOAD": true, "KILL": true, "SET": true, "DECLARE": true,
+	"BEGIN": true, "COMMIT": true, "ROLLBACK": true, "SAVE": true,
+	"USE": true, "WAITFOR": true, "IF": true, "WHILE": true,
+	"GOTO": true, "RETURN": true, "PRINT": true, "RAISERROR": true,
+	"THROW": true, "BULK": true, "CHECKPOINT": true, "SHUTDOWN": true,
+	"RECONFIGURE": true, "OPEN": true, "FETCH": true, "CLOSE": true,
+	"DEALLOCATE": true, "MOVE": true, "GET": true, "ENABLE": true,
+	"DISABLE": true, "ADD": true, "READTEXT": true, "WRITETEXT": true,
+	"UPDATETEXT": true, "SETUSER": true, "REVERT": true,
+	"OPENQUERY": true, "OPENROWSET": true, "OPENDATASOURCE": true,
+}
+
+// isReadOnlyQuery reports whether the query is a single SELECT statement
+// that only reads. The check is conservative, only queries starting with
+// SELECT qualify, and semicolons, four-part names of tables on linked
+// servers, NEXT VALUE FOR, which takes a number from a sequence, and the
+// statementKeywords anywhere outside of comments, strings and quoted names
+// disqualify them.
+func isReadOnlyQuery(query string) bool {
+	tokens := sqlTokens(query)
+	if len(tokens) == 0 || !strings.EqualFold(tokens[0], "SELECT") {
+		return false
+	}
+	dots, name := 0, false
+	for i, token := range tokens {
+		word := strings.ToUpper(token)
+		switch {
+		case token == ";" || statementKeywords[word]:
+			return false
+		case word == "NEXT" && i+2 < len(tokens) && strings.EqualFold(tokens[i+1], "VALUE") && strings.EqualFold(tokens[i+2], "FOR"):
+			return false
+		case word == "END" && i+1 < len(tokens) && strings.EqualFold(tokens[i+1], "CONVERSATION"):
+			return false
+		}
+		// the parts of a name are separated by dots, srv.db.schema.tbl
+		// and srv...tbl name a table on a linked server
+		switch {
+		case token == ".":
+			if dots++; dots >= 3 {
+				return false
+			}
+			name = false
+		case isNameToken(token):
+			if name {
+				dots = 0
+			}
+			name = true
+		default:
+			dots, name = 0, false
+		}
+	}
+	return true
+}
+
+// isNameToken reports whether a token of sqlTokens is a name or a keyword.
+func isNameToken(token string) bool {
+	r := []rune(token)[0]
+	return unicode.IsLetter(r) || r == '_' || r == '@' || r == '#' || r == '[' || r == '"'
+}
+
+// sqlTokens returns the tokens of a T-SQL text, skipping comments and white
+// space: words, numbers and single punctuation characters as they are, a
+// quoted name as its opening quote and a string literal as a single quote.
+func sqlTokens(query string) []string {
+	var tokens []string
+	r := []rune(query)
+	for i := 0; i < len(r); {
+		switch {
+		case r[i] == '-' && i+1 < len(r) && r[i+1] == '-':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case r[i] == '/' && i+1 < len(r) && r[i+1] == '*':
+			for i += 2; i+1 < len(r) && !(r[i] == '*' && r[i+1] == '/'); i++ {
+			}
+			i += 2
+		case r[i] == '\'' || r[i] == '"' || r[i] == '[':
+			quote := r[i]
+			tokens = append(tokens, string(quote))
+			if quote == '[' {
+				quote = ']'
+			}
+			for i++; i < len(r); i++ {
+				if r[i] == quote {
+					if i+1 < len(r) && r[i+1] == quote {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+		case unicode.IsLetter(r[i]) || r[i] == '_' || r[i] == '@' || r[i] == '#':
+			start := i
+			for i < len(r) && (unicode.IsLetter(r[i]) || unicode.IsDigit(r[i]) || r[i] == '_' || r[i] == '@' || r[i] == '#' || r[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, string(r[start:i]))
+		case unicode.IsDigit(r[i]):
+			start := i
+			for i < len(r) && (unicode.IsLetter(r[i]) || unicode.IsDigit(r[i]) || r[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, string(r[start:i]))
+		case unicode.IsSpace(r[i]):
+			i++
+		default:
+			tokens = append(tokens, string(r[i]))
+			i++
+		}
+	}
+	return tokens
+}
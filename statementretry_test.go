@@ -0,0 +1,116 @@
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestIsReadOnlyQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		readOnly bool
+	}{
+		{"select * from t", true},
+		{"  -- comment\n/* update */ SELECT name FROM [into] WHERE x = 'delete'", true},
+		{"select * into t2 from t", false},
+		{"select 1; delete from t", false},
+		{"with c as (select 1 a) update t set a = 1", false},
+		{"update t set a = 1", false},
+		{"exec sp_who", false},
+		{"select next value for dbo.seq", false},
+		{"SELECT NEXT\n VALUE /* x */ FOR s, a FROM t", false},
+		{"select next, value from t for xml auto", true},
+		{"select * from openquery(srv, 'select 1')", false},
+		{"select [next value for] from t", true},
+		{"select 1;", false},
+		{"select 1\nreceive top(1) * from dbo.q", false},
+		{"select 1\nsend on conversation @h (N'x')", false},
+		{"select 1\nend conversation @h", false},
+		{"select 1 dbcc freeproccache", false},
+		{"select 1\nbackup database db to disk = 'db.bak'", false},
+		{"select 1\nrestore database db from disk = 'db.bak'", false},
+		{"select 1\nkill 52", false},
+		{"select 1\ndeny select on t to u", false},
+		{"select 1\nrevoke select on t from u", false},
+		{"select 1\nset identity_insert t on", false},
+		{"select * from srv.db.dbo.t", false},
+		{"select * from [srv] . [db].\"dbo\".[t]", false},
+		{"select * from srv.db..t", false},
+		{"select * from srv...t", false},
+		{"select t.a, 1.5 from db.dbo.t join db..u on t.a = u.a", true},
+		{"select s.[a.b.c.d], 'x.y.z.w' from s", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyQuery(tt.query); got != tt.readOnly {
+			t.Errorf("isReadOnlyQuery(%q) = %v, want %v", tt.query, got, tt.readOnly)
+		}
+	}
+}
+
+// deadlockServer fails the first failures requests with a deadlock.
+func deadlockServer(failures *int32) *mssqltest.Server {
+	return mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if atomic.AddInt32(failures, -1) >= 0 {
+			return []mssqltest.Response{mssqltest.Error{Number: 1205, Class: 13, Message: "Transaction was deadlocked"}}
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "n", Type: mssqltest.Int}},
+			Rows:    [][]interface{}{{1}},
+		}}
+	})
+}
+
+func TestStatementRetry(t *testing.T) {
+	var failures int32
+	srv := deadlockServer(&failures)
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.StatementRetryPolicy = ExponentialBackoff{Retries: 2, Interval: time.Millisecond}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	ctx := context.Background()
+
+	var n int
+	atomic.StoreInt32(&failures, 2)
+	if err = db.QueryRowContext(ctx, "select n from t").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("expected the query to succeed on the third attempt, got %v", err)
+	}
+
+	// writes are only retried when the context asks for it
+	atomic.StoreInt32(&failures, 1)
+	if _, err = db.ExecContext(ctx, "update t set n = 1"); err == nil || !strings.Contains(err.Error(), "deadlocked") {
+		t.Fatalf("expected the deadlock error, got %v", err)
+	}
+	atomic.StoreInt32(&failures, 1)
+	retryCtx := WithStatementRetry(ctx, ExponentialBackoff{Retries: 1, Interval: time.Millisecond})
+	if _, err = db.ExecContext(retryCtx, "update t set n = 1"); err != nil {
+		t.Fatalf("expected the update to be retried, got %v", err)
+	}
+
+	// a nil policy disables the retries of the connector
+	atomic.StoreInt32(&failures, 1)
+	if err = db.QueryRowContext(WithStatementRetry(ctx, nil), "select n from t").Scan(&n); err == nil {
+		t.Fatal("expected the deadlock error")
+	}
+
+	// statements of a transaction are not retried
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	atomic.StoreInt32(&failures, 1)
+	if err = tx.QueryRowContext(retryCtx, "select n from t").Scan(&n); err == nil {
+		t.Fatal("expected the deadlock error in the transaction")
+	}
+}
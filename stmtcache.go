@@ -0,0 +1,113 @@
+package mssql
+
+import (
+	"container/list"
+	"sync"
+)
+
+// StatementCacheStats are the counters of the statement caches of the
+// connections of a Connector, see the statementcachesize connection
+// parameter. The statements are counted once they are executed with
+// parameters.
+type StatementCacheStats struct {
+	// Hits counts the statements whose text was in the cache of their
+	// connection, Misses those whose text was not.
+	Hits   uint64
+	Misses uint64
+	// Evictions counts the statements removed from full caches, whose
+	// handles were released on the server.
+	Evictions uint64
+}
+
+// HitRate returns the ratio of hits to the statements looked up in the
+// caches, zero when none was.
+func (s StatementCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type stmtCacheCounters struct {
+	mu    sync.Mutex
+	stats StatementCacheStats
+}
+
+func (c *stmtCacheCounters) count(hit, evicted bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	if evicted {
+		c.stats.Evictions++
+	}
+}
+
+// StatementCacheStats returns the counters of the statement caches of the
+// connections of c. The connections of sql.Open each have their own
+// connector, open the database with NewConnector and sql.OpenDB to count
+// those of the pool.
+func (c *Connector) StatementCacheStats() StatementCacheStats {
+	c.stmtCacheCounters.mu.Lock()
+	defer c.stmtCacheCounters.mu.Unlock()
+	return c.stmtCacheCounters.stats
+}
+
+// stmtCache keeps the handles of the statements of a connection by text,
+// evicting the least recently used when it is full.
+type stmtCache struct {
+	size int
+	// lru holds the entries from the most recently used
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type stmtCacheEntry struct {
+	query   string
+	handles *stmtHandles
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{size: size, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the handles of query, added to the cache if they are not in
+// it, and the handles evicted to make room for them, nil if none were.
+func (c *stmtCache) get(query string) (handles *stmtHandles, hit bool, evicted *stmtHandles) {
+	if e, ok := c.entries[query]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*stmtCacheEntry).handles, true, nil
+	}
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Remove(c.lru.Back()).(*stmtCacheEntry)
+		delete(c.entries, oldest.query)
+		oldest.handles.cached = false
+		evicted = oldest.handles
+	}
+	handles = &stmtHandles{cached: true}
+	c.entries[query] = c.lru.PushFront(&stmtCacheEntry{query, handles})
+	return handles, false, evicted
+}
+
+// stmtHandles returns the handles of the statement query, those of the
+// statement cache if the connection has one.
+func (c *Conn) stmtHandles(query string) *stmtHandles {
+	if c.connector == nil || c.connector.params.StatementCacheSize <= 0 {
+		return &stmtHandles{}
+	}
+	if c.stmtCache == nil {
+		c.stmtCache = newStmtCache(c.connector.params.StatementCacheSize)
+	}
+	handles, hit, evicted := c.stmtCache.get(query)
+	if evicted != nil && evicted.sess == c.sess {
+		for _, handle := range evicted.handles {
+			c.evictedHandles = append(c.evictedHandles, handle)
+		}
+		evicted.handles = nil
+	}
+	c.connector.stmtCacheCounters.count(hit, evicted != nil)
+	return handles
+}
@@ -0,0 +1,99 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestStatementCache(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN() + "&statementcachesize=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, query := range []string{"update a set x = @p1", "update a set x = @p1", "update b set x = @p1", "update b set x = @p1", "update a set x = @p1", "update c set x = @p1"} {
+		if _, err = db.Exec(query, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type call struct {
+		proc   string
+		handle int32
+	}
+	expected := []call{
+		{"sp_executesql", 0},
+		{"sp_prepexec", 1},
+		{"sp_executesql", 0},
+		{"sp_prepexec", 2},
+		{"sp_execute", 1},
+		{"sp_unprepare", 2},
+		{"sp_executesql", 0},
+	}
+	var calls []call
+	for _, req := range srv.Requests() {
+		if req.Type == mssqltest.RPC {
+			calls = append(calls, call{req.Proc, req.Handle})
+		}
+	}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected the calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("expected the calls %v, got %v", expected, calls)
+		}
+	}
+
+	stats := c.StatementCacheStats()
+	if stats != (StatementCacheStats{Hits: 3, Misses: 3, Evictions: 1}) {
+		t.Errorf("unexpected statistics %+v", stats)
+	}
+	if rate := stats.HitRate(); rate != 0.5 {
+		t.Errorf("expected the hit rate 0.5, got %v", rate)
+	}
+}
+
+func TestStatementCacheKeepsHandlesOfClosedStatements(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN() + "&statementcachesize=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 3; i++ {
+		stmt, err := db.Prepare("update a set x = @p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = stmt.Exec(i); err != nil {
+			t.Fatal(err)
+		}
+		if err = stmt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var procs []string
+	for _, req := range srv.Requests() {
+		if req.Type == mssqltest.RPC {
+			procs = append(procs, req.Proc)
+		}
+	}
+	expected := []string{"sp_executesql", "sp_prepexec", "sp_execute"}
+	if len(procs) != len(expected) || procs[0] != expected[0] || procs[1] != expected[1] || procs[2] != expected[2] {
+		t.Errorf("expected the calls %v, got %v", expected, procs)
+	}
+}
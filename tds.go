@@ -2,13 +2,17 @@ package mssql
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"sort"
 	"strconv"
 	"strings"
@@ -16,6 +20,7 @@ import (
 	"unicode/utf16"
 	"unicode/utf8"
 
+	"github.com/denisenkom/go-mssqldb/internal/cp"
 	"github.com/denisenkom/go-mssqldb/msdsn"
 )
 
@@ -68,6 +73,45 @@ func getInstances(ctx context.Context, d Dialer, address string) (map[string]map
 	return parseInstances(resp[:read]), nil
 }
 
+// defaultDACPort is the port of the Dedicated Admin Connection of a default
+// instance.
+const defaultDACPort = 1434
+
+// maxRoutingHops bounds the number of times a login follows a routing
+// directive, such as the redirect of an Azure SQL gateway followed by the
+// read-only routing of an Availability Group.
+const maxRoutingHops = 5
+
+// getDACPort asks the SQL Server Browser service for the port of the
+// Dedicated Admin Connection of an instance.
+func getDACPort(ctx context.Context, d Dialer, address, instance string) (uint16, error) {
+	if instance == "" {
+		instance = "MSSQLSERVER"
+	}
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(address, "1434"))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// CLNT_UCAST_DAC, protocol version 1 and the instance name
+	req := append([]byte{0x0f, 0x01}, instance...)
+	if _, err = conn.Write(append(req, 0)); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 16)
+	read, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	// SVR_RESP: 0x05, the size 6, the version 1 and the port
+	if read < 6 || resp[0] != 5 || resp[3] != 1 {
+		return 0, fmt.Errorf("invalid admin port response % x", resp[:read])
+	}
+	return binary.LittleEndian.Uint16(resp[4:6]), nil
+}
+
 // tds versions
 const (
 	verTDS70     = 0x70000000
@@ -119,8 +163,12 @@ const (
 	encryptOn     = 1 // Encryption is available and on.
 	encryptNotSup = 2 // Encryption is not available.
 	encryptReq    = 3 // Encryption is required.
+	encryptStrict = 4 // The session is encrypted with TDS 8.0.
 )
 
+// alpnTDS8 is the ALPN protocol of TDS 8.0 sessions.
+const alpnTDS8 = "tds/8.0"
+
 const (
 	featExtSESSIONRECOVERY    byte = 0x01
 	featExtFEDAUTH            byte = 0x02
@@ -134,8 +182,11 @@ const (
 
 type tdsSession struct {
 	buf          *tdsBuffer
+	conn         net.Conn
 	loginAck     loginAckStruct
 	database     string
+	language     string
+	collation    []byte
 	partner      string
 	columns      []columnStruct
 	tranid       uint64
@@ -143,6 +194,59 @@ type tdsSession struct {
 	log          optionalLogger
 	routedServer string
 	routedPort   uint16
+
+	// params are the parameters of the server the session logged in to,
+	// those of the replica or of the node after routing.
+	params msdsn.Config
+	// killSession kills a session from a new connection, see WithQueryTimeout.
+	killSession func() error
+	// partnerChanged, if set, receives the mirroring partner advertised
+	// during the session.
+	partnerChanged func(partner string)
+	// messageHandler, if set, receives the informational messages of the
+	// responses, see Connector.MessageHandler.
+	messageHandler func(msg Error)
+	// recovery is the session state of connection resiliency, nil if the
+	// server did not acknowledge it.
+	recovery *sessionRecovery
+	// columnEncryption is set when the server acknowledged Always
+	// Encrypted, decryptKey then returns the key of encrypted values.
+	columnEncryption bool
+	decryptKey       func(entry *cekTableEntry) ([]byte, error)
+	// enclaveType is the type of the secure enclave of Always Encrypted,
+	// VBS or SGX, when the server supports enclave computations
+	enclaveType string
+	// dataClassification is the version of the DATACLASSIFICATION
+	// feature extension the server acknowledged, zero if it does not
+	// send the sensitivity classification of result sets
+	dataClassification byte
+	// decimalAsString returns decimal and money values as strings, see
+	// msdsn.Config.DecimalAsString
+	decimalAsString bool
+	// location is the location of the date and time values without
+	// offset, see Connector.Location, nil for UTC
+	location *time.Location
+	// utf8 is set when the server acknowledged UTF8_SUPPORT, it then sends
+	// the text of UTF-8 collations as UTF-8
+	utf8 bool
+}
+
+// varcharCollation returns the collation of varchar parameters, the
+// collation of the database when it is a UTF-8 collation the server sends
+// as such. Otherwise it returns the zero collation, the text of the
+// parameters is then taken in the code page of the server.
+func (sess *tdsSession) varcharCollation() cp.Collation {
+	if !sess.utf8 || len(sess.collation) != 5 {
+		return cp.Collation{}
+	}
+	col := cp.Collation{
+		LcidAndFlags: binary.LittleEndian.Uint32(sess.collation),
+		SortId:       sess.collation[4],
+	}
+	if !col.IsUTF8() {
+		return cp.Collation{}
+	}
+	return col
 }
 
 const (
@@ -168,6 +272,11 @@ type columnStruct struct {
 	Flags    uint16
 	ColName  string
 	ti       typeInfo
+	// cryptoMeta describes an encrypted column, whose ti is the type of
+	// the plaintext
+	cryptoMeta *cryptoMetadata
+	// source is the base table column, in browse mode
+	source *ColumnSource
 }
 
 type keySlice []uint8
@@ -338,8 +447,16 @@ func (e featureExts) toBytes() []byte {
 	if len(e.features) == 0 {
 		return nil
 	}
+	// features are sent in the order of their ids, so that logins are
+	// reproducible
+	ids := make([]int, 0, len(e.features))
+	for id := range e.features {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
 	var d []byte
-	for featureID, f := range e.features {
+	for _, id := range ids {
+		featureID, f := byte(id), e.features[byte(id)]
 		featureData := f.toBytes()
 
 		hdr := make([]byte, 5)
@@ -355,6 +472,54 @@ func (e featureExts) toBytes() []byte {
 	return d
 }
 
+// featureExtAzureSQLSupport tells Azure SQL that the client follows the
+// routing of read-only connections and the failover partner information.
+type featureExtAzureSQLSupport struct{}
+
+func (featureExtAzureSQLSupport) featureID() byte {
+	return featExtAZURESQLSUPPORT
+}
+
+func (featureExtAzureSQLSupport) toBytes() []byte {
+	// bit 0: read-only routing and failover partner support
+	return []byte{0x01}
+}
+
+// featureExtUTF8Support tells the server that the client reads the text of
+// UTF-8 collations, it would convert it to another code page otherwise.
+type featureExtUTF8Support struct{}
+
+func (featureExtUTF8Support) featureID() byte {
+	return featExtUTF8SUPPORT
+}
+
+func (featureExtUTF8Support) toBytes() []byte {
+	return nil
+}
+
+// featureExtColumnEncryption asks for Always Encrypted, the server then
+// describes encrypted columns and accepts encrypted parameters. Version 1
+// has no secure enclave, version 3 supports enclaves attested by any
+// protocol, including none.
+type featureExtColumnEncryption struct {
+	version byte
+}
+
+func (featureExtColumnEncryption) featureID() byte {
+	return featExtCOLUMNENCRYPTION
+}
+
+func (e featureExtColumnEncryption) toBytes() []byte {
+	return []byte{e.version}
+}
+
+// columnEncryptionAck is the acknowledgement of Always Encrypted, the
+// enclave type is empty if the server has no secure enclave.
+type columnEncryptionAck struct {
+	version     byte
+	enclaveType string
+}
+
 // featureExtFedAuth tracks federated authentication state before and during login
 type featureExtFedAuth struct {
 	// FedAuthLibrary is populated by the federated authentication provider.
@@ -502,7 +667,7 @@ func sendLogin(w *tdsBuffer, login *login) error {
 	language := str2ucs2(login.Language)
 	database := str2ucs2(login.Database)
 	atchdbfile := str2ucs2(login.AtchDBFile)
-	changepassword := str2ucs2(login.ChangePassword)
+	changepassword := manglePassword(login.ChangePassword)
 	featureExt := login.FeatureExt.toBytes()
 
 	hdr := loginHeader{
@@ -762,7 +927,7 @@ func (hdr queryNotifHdr) pack() (res []byte) {
 	notifyId := str2ucs2(hdr.notifyId)
 	ssbDeployment := str2ucs2(hdr.ssbDeployment)
 
-	res = make([]byte, 2+len(notifyId)+2+len(ssbDeployment)+4)
+	res = make([]byte, 2+len(notifyId)+2+len(ssbDeployment), 2+len(notifyId)+2+len(ssbDeployment)+4)
 	b := res
 
 	binary.LittleEndian.PutUint16(b, uint16(len(notifyId)))
@@ -773,9 +938,12 @@ func (hdr queryNotifHdr) pack() (res []byte) {
 	binary.LittleEndian.PutUint16(b, uint16(len(ssbDeployment)))
 	b = b[2:]
 	copy(b, ssbDeployment)
-	b = b[len(ssbDeployment):]
 
-	binary.LittleEndian.PutUint32(b, hdr.notifyTimeout)
+	// the timeout is optional, the server default applies when it is left out
+	if hdr.notifyTimeout != 0 {
+		res = res[:len(res)+4]
+		binary.LittleEndian.PutUint32(res[len(res)-4:], hdr.notifyTimeout)
+	}
 
 	return res
 }
@@ -823,12 +991,15 @@ func writeAllHeaders(w io.Writer, headers []headerStruct) (err error) {
 	return nil
 }
 
-func sendSqlBatch72(buf *tdsBuffer, sqltext string, headers []headerStruct, resetSession bool) (err error) {
+func sendSqlBatch72(buf *tdsBuffer, sqltext string, headers []headerStruct, enclave []byte, resetSession bool) (err error) {
 	buf.BeginPacket(packSQLBatch, resetSession)
 
 	if err = writeAllHeaders(buf, headers); err != nil {
 		return
 	}
+	if err = writeEnclavePackage(buf, enclave); err != nil {
+		return
+	}
 
 	_, err = buf.Write(str2ucs2(sqltext))
 	if err != nil {
@@ -854,12 +1025,30 @@ type auth interface {
 // list of IP addresses.  So if there is more than one, try them all and
 // use the first one that allows a connection.
 func dialConnection(ctx context.Context, c *Connector, p msdsn.Config) (conn net.Conn, err error) {
+	if p.Protocol == msdsn.ProtocolNamedPipes {
+		return dialNamedPipe(ctx, c, p)
+	}
+	if hd, ok := c.getDialer(&p).(HostDialer); ok {
+		host := hd.HostName()
+		if host == "" {
+			host = p.Host
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(int(resolveServerPort(p.Port))))
+		if conn, err = hd.DialContext(ctx, "tcp", addr); err != nil {
+			f := "unable to open tcp connection with host '%v:%v': %v"
+			return nil, fmt.Errorf(f, host, resolveServerPort(p.Port), err.Error())
+		}
+		return conn, nil
+	}
 	var ips []net.IP
 	ip := net.ParseIP(p.Host)
 	if ip == nil {
-		ips, err = net.LookupIP(p.Host)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, p.Host)
 		if err != nil {
-			return
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
 		}
 	} else {
 		ips = []net.IP{ip}
@@ -870,43 +1059,11 @@ func dialConnection(ctx context.Context, c *Connector, p msdsn.Config) (conn net
 		conn, err = d.DialContext(ctx, "tcp", addr)
 
 	} else {
-		//Try Dials in parallel to avoid waiting for timeouts.
-		connChan := make(chan net.Conn, len(ips))
-		errChan := make(chan error, len(ips))
-		portStr := strconv.Itoa(int(resolveServerPort(p.Port)))
-		for _, ip := range ips {
-			go func(ip net.IP) {
-				d := c.getDialer(&p)
-				addr := net.JoinHostPort(ip.String(), portStr)
-				conn, err := d.DialContext(ctx, "tcp", addr)
-				if err == nil {
-					connChan <- conn
-				} else {
-					errChan <- err
-				}
-			}(ip)
-		}
-		// Wait for either the *first* successful connection, or all the errors
-	wait_loop:
-		for i := range ips {
-			select {
-			case conn = <-connChan:
-				// Got a connection to use, close any others
-				go func(n int) {
-					for i := 0; i < n; i++ {
-						select {
-						case conn := <-connChan:
-							conn.Close()
-						case <-errChan:
-						}
-					}
-				}(len(ips) - i - 1)
-				// Remove any earlier errors we may have collected
-				err = nil
-				break wait_loop
-			case err = <-errChan:
-			}
+		var stagger time.Duration
+		if p.MultiSubnetFailover {
+			stagger = multiSubnetFailoverStagger
 		}
+		conn, err = dialParallel(ctx, c.getDialer(&p), ips, resolveServerPort(p.Port), stagger)
 	}
 	// Can't do the usual err != nil check, as it is possible to have gotten an error before a successful connection
 	if conn == nil {
@@ -916,6 +1073,75 @@ func dialConnection(ctx context.Context, c *Connector, p msdsn.Config) (conn net
 	return conn, err
 }
 
+// dialNamedPipe opens the named pipe of p. A custom Dialer is given the
+// pipe as the address of the "np" network.
+func dialNamedPipe(ctx context.Context, c *Connector, p msdsn.Config) (conn net.Conn, err error) {
+	if c != nil && c.Dialer != nil {
+		conn, err = c.Dialer.DialContext(ctx, msdsn.ProtocolNamedPipes, p.PipeName)
+	} else {
+		conn, err = dialPipe(ctx, p.PipeName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open named pipe '%v': %v", p.PipeName, err)
+	}
+	return conn, nil
+}
+
+// multiSubnetFailoverStagger is the interval between the connection
+// attempts to the addresses of a multi-subnet listener.
+const multiSubnetFailoverStagger = 200 * time.Millisecond
+
+// dialParallel dials the addresses in parallel, starting an attempt every
+// stagger, and returns the first connection. The other attempts are
+// cancelled, an error is returned if they all fail.
+func dialParallel(ctx context.Context, d Dialer, ips []net.IP, port uint64, stagger time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	portStr := strconv.Itoa(int(port))
+	for i, ip := range ips {
+		go func(i int, ip net.IP) {
+			if i > 0 && stagger > 0 {
+				t := time.NewTimer(time.Duration(i) * stagger)
+				defer t.Stop()
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					results <- result{err: ctx.Err()}
+					return
+				}
+			}
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), portStr))
+			results <- result{conn, err}
+		}(i, ip)
+	}
+	var err error
+	for i := range ips {
+		r := <-results
+		if r.err != nil {
+			// report the error of a dial rather than of a cancellation
+			if err == nil || err == context.Canceled {
+				err = r.err
+			}
+			continue
+		}
+		// Got a connection to use, close any others
+		go func(n int) {
+			for i := 0; i < n; i++ {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}(len(ips) - i - 1)
+		return r.conn, nil
+	}
+	return nil, err
+}
+
 func preparePreloginFields(p msdsn.Config, fe *featureExtFedAuth) map[uint8][]byte {
 	instance_buf := []byte(p.Instance)
 	instance_buf = append(instance_buf, 0) // zero terminate instance name
@@ -930,6 +1156,8 @@ func preparePreloginFields(p msdsn.Config, fe *featureExtFedAuth) map[uint8][]by
 		encrypt = encryptOn
 	case msdsn.EncryptionOff:
 		encrypt = encryptOff
+	case msdsn.EncryptionStrict:
+		encrypt = encryptStrict
 	}
 
 	fields := map[uint8][]byte{
@@ -962,6 +1190,10 @@ func interpretPreloginResponse(p msdsn.Config, fe *featureExtFedAuth, fields map
 		return 0, fmt.Errorf("federated authentication is not supported by the server")
 	}
 
+	if p.Encryption == msdsn.EncryptionStrict {
+		// the session is already encrypted, the server ignores the option
+		return encryptStrict, nil
+	}
 	encryptBytes, ok := fields[preloginENCRYPTION]
 	if !ok {
 		return 0, fmt.Errorf("encrypt negotiation failed")
@@ -995,7 +1227,11 @@ func prepareLogin(ctx context.Context, c *Connector, p msdsn.Config, log optiona
 			log.Println("Starting federated authentication using security token")
 		}
 
-		fe.FedAuthToken, err = c.securityTokenProvider(ctx)
+		if c.TokenProvider != nil {
+			fe.FedAuthToken, err = c.TokenProvider.GetToken(ctx)
+		} else {
+			fe.FedAuthToken, err = c.securityTokenProvider(ctx)
+		}
 		if err != nil {
 			if uint64(p.LogFlags)&logDebug != 0 {
 				log.Printf("Failed to retrieve service principal token for federated authentication security token library: %v", err)
@@ -1029,12 +1265,46 @@ func prepareLogin(ctx context.Context, c *Connector, p msdsn.Config, log optiona
 		// Default to SQL server authentication with user and password
 		l.UserName = p.User
 		l.Password = p.Password
+		if p.NewPassword != "" {
+			l.ChangePassword = p.NewPassword
+			l.OptionFlags3 |= fChangePassword
+		}
 	}
 
 	return l, nil
 }
 
+// serverSPNTemplate returns the SPN template of p. The default SPN follows
+// the port of the instance and the routing.
+func serverSPNTemplate(p msdsn.Config) string {
+	if p.ServerSPN == msdsn.ExpandServerSPN(msdsn.DefaultServerSPNTemplate, p.Host, p.Port, p.Instance) {
+		return msdsn.DefaultServerSPNTemplate
+	}
+	return p.ServerSPN
+}
+
 func connect(ctx context.Context, c *Connector, log optionalLogger, p msdsn.Config) (res *tdsSession, err error) {
+	return connectSession(ctx, c, log, p, nil)
+}
+
+// connectSession connects and logs in. Connection resiliency is negotiated
+// when p retries connections, recovery, if set, holds the state of the
+// session to recover.
+func connectSession(ctx context.Context, c *Connector, log optionalLogger, p msdsn.Config, recovery *featureExtSessionRecovery) (res *tdsSession, err error) {
+	spnTemplate := serverSPNTemplate(p)
+	if strings.EqualFold(p.Host, msdsn.LocalDBHost) {
+		if p.AdminConnection {
+			return nil, errors.New("the dedicated admin connection is not available for LocalDB")
+		}
+		pipe, err := localDBPipe(p.Instance)
+		if err != nil {
+			return nil, err
+		}
+		// the pipe identifies the instance, whose name on the server is
+		// different from the LocalDB instance name
+		p.Host, p.Instance = "localhost", ""
+		p.Protocol, p.PipeName = msdsn.ProtocolNamedPipes, pipe
+	}
 	dialCtx := ctx
 	if p.DialTimeout >= 0 {
 		dt := p.DialTimeout
@@ -1046,13 +1316,24 @@ func connect(ctx context.Context, c *Connector, log optionalLogger, p msdsn.Conf
 		defer cancel()
 	}
 	// if instance is specified use instance resolution service
-	if len(p.Instance) > 0 && p.Port != 0 {
+	if len(p.Instance) > 0 && p.Port != 0 && p.Protocol == "" {
 		// both instance name and port specified
 		// when port is specified instance name is not used
 		// you should not provide instance name when you provide port
 		log.Println("WARN: You specified both instance name and port in the connection string, port will be used and instance name will be ignored")
 	}
-	if len(p.Instance) > 0 {
+	if p.AdminConnection && p.Port == 0 {
+		d := c.getDialer(&p)
+		port, err := getDACPort(dialCtx, d, p.Host, p.Instance)
+		if err != nil {
+			if len(p.Instance) > 0 {
+				f := "unable to get the admin port of instance '%v' from Sql Server Browser on host %v: %v"
+				return nil, fmt.Errorf(f, p.Instance, p.Host, err.Error())
+			}
+			port = defaultDACPort
+		}
+		p.Port = uint64(port)
+	} else if len(p.Instance) > 0 && p.Protocol == "" {
 		p.Instance = strings.ToUpper(p.Instance)
 		d := c.getDialer(&p)
 		instances, err := getInstances(dialCtx, d, p.Host)
@@ -1090,6 +1371,7 @@ func connect(ctx context.Context, c *Connector, log optionalLogger, p msdsn.Conf
 		packetSize = 32767
 	}
 
+	hops := 0
 initiate_connection:
 	conn, err := dialConnection(dialCtx, c, p)
 	if err != nil {
@@ -1099,16 +1381,41 @@ initiate_connection:
 	toconn := newTimeoutConn(conn, p.ConnTimeout)
 
 	outbuf := newTdsBuffer(packetSize, toconn)
+	// channelBindings bind the login to the TLS channel for Extended Protection
+	var channelBindings []byte
+	if p.Encryption == msdsn.EncryptionStrict {
+		// TDS 8.0: TLS is negotiated before PRELOGIN and covers the session
+		config, err := c.tlsConfig(p)
+		if err != nil {
+			return nil, err
+		}
+		config.NextProtos = []string{alpnTDS8}
+		if config.MinVersion < tls.VersionTLS12 {
+			config.MinVersion = tls.VersionTLS12
+		}
+		tlsConn := tls.Client(toconn, config)
+		if err = tlsConn.Handshake(); err != nil {
+			toconn.Close()
+			return nil, fmt.Errorf("TLS Handshake failed: %v", err)
+		}
+		outbuf.transport = tlsConn
+		channelBindings = tlsServerEndPoint(tlsConn.ConnectionState())
+	}
 	sess := tdsSession{
-		buf:      outbuf,
-		log:      log,
-		logFlags: uint64(p.LogFlags),
+		buf:             outbuf,
+		conn:            conn,
+		log:             log,
+		logFlags:        uint64(p.LogFlags),
+		decimalAsString: p.DecimalAsString,
+		location:        c.Location,
 	}
 
 	fedAuth := &featureExtFedAuth{
 		FedAuthLibrary: fedAuthLibraryReserved,
 	}
-	if c.fedAuthRequired {
+	if c.TokenProvider != nil {
+		fedAuth.FedAuthLibrary = fedAuthLibrarySecurityToken
+	} else if c.fedAuthRequired {
 		fedAuth.FedAuthLibrary = c.fedAuthLibrary
 		fedAuth.ADALWorkflow = c.fedAuthADALWorkflow
 	}
@@ -1130,25 +1437,10 @@ initiate_connection:
 		return nil, err
 	}
 
-	if encrypt != encryptNotSup {
-		var config *tls.Config
-		if pc := p.TLSConfig; pc != nil {
-			config = pc
-			if config.DynamicRecordSizingDisabled == false {
-				config = config.Clone()
-
-				// fix for https://github.com/denisenkom/go-mssqldb/issues/166
-				// Go implementation of TLS payload size heuristic algorithm splits single TDS package to multiple TCP segments,
-				// while SQL Server seems to expect one TCP segment per encrypted TDS package.
-				// Setting DynamicRecordSizingDisabled to true disables that algorithm and uses 16384 bytes per TLS package
-				config.DynamicRecordSizingDisabled = true
-			}
-		}
-		if config == nil {
-			config, err = msdsn.SetupTLS("", false, p.Host)
-			if err != nil {
-				return nil, err
-			}
+	if encrypt != encryptNotSup && encrypt != encryptStrict {
+		config, err := c.tlsConfig(p)
+		if err != nil {
+			return nil, err
 		}
 
 		// setting up connection handler which will allow wrapping of TLS handshake packets inside TDS stream
@@ -1161,6 +1453,7 @@ initiate_connection:
 		if err != nil {
 			return nil, fmt.Errorf("TLS Handshake failed: %v", err)
 		}
+		channelBindings = tlsServerEndPoint(tlsConn.ConnectionState())
 		if encrypt == encryptOff {
 			outbuf.afterFirst = func() {
 				outbuf.transport = toconn
@@ -1168,9 +1461,25 @@ initiate_connection:
 		}
 	}
 
-	auth, authOk := getAuth(p.User, p.Password, p.ServerSPN, p.Workstation)
+	p.ServerSPN = msdsn.ExpandServerSPN(spnTemplate, p.Host, p.Port, p.Instance)
+	var auth auth
+	authOk := false
+	switch p.Authenticator {
+	case msdsn.AuthenticatorKerberos:
+		if auth, err = newKerberosAuth(p); err != nil {
+			return nil, err
+		}
+		authOk = true
+	case msdsn.AuthenticatorNTLM:
+		auth, authOk = getNTLMAuth(p)
+	default:
+		auth, authOk = getAuth(p)
+	}
 	if authOk {
 		defer auth.Free()
+		if cb, ok := auth.(channelBinder); ok && channelBindings != nil {
+			cb.setChannelBindings(channelBindings)
+		}
 	} else {
 		auth = nil
 	}
@@ -1179,6 +1488,23 @@ initiate_connection:
 	if err != nil {
 		return nil, err
 	}
+	// lets Azure SQL route read-only connections to the new secondary
+	// after a geo-failover
+	login.FeatureExt.Add(featureExtAzureSQLSupport{})
+	login.FeatureExt.Add(featureExtDataClassification{})
+	login.FeatureExt.Add(featureExtUTF8Support{})
+	if p.ColumnEncryption {
+		ae := featureExtColumnEncryption{version: 1}
+		if p.EnclaveAttestationProtocol != "" {
+			ae.version = 3
+		}
+		login.FeatureExt.Add(ae)
+	}
+	if recovery != nil {
+		login.FeatureExt.Add(recovery)
+	} else if p.ConnectRetryCount > 0 {
+		login.FeatureExt.Add(&featureExtSessionRecovery{})
+	}
 
 	err = sendLogin(outbuf, login)
 	if err != nil {
@@ -1241,6 +1567,26 @@ initiate_connection:
 				if err != nil {
 					return nil, err
 				}
+			case map[byte]interface{}:
+				if version, ok := token[featExtDATACLASSIFICATION].(byte); ok {
+					sess.dataClassification = version
+				}
+				if utf8, ok := token[featExtUTF8SUPPORT].(bool); ok {
+					sess.utf8 = utf8
+				}
+				if ae, ok := token[featExtCOLUMNENCRYPTION].(columnEncryptionAck); ok && ae.version > 0 {
+					sess.columnEncryption = true
+					if ae.version >= 2 && p.EnclaveAttestationProtocol != "" {
+						sess.enclaveType = ae.enclaveType
+					}
+				}
+				if initial, ok := token[featExtSESSIONRECOVERY].([]byte); ok {
+					if recovery != nil {
+						sess.recovery = recovery.recovery
+					} else {
+						sess.recovery = newSessionRecovery(initial)
+					}
+				}
 			case loginAckStruct:
 				sess.loginAck = token
 				loginAck = true
@@ -1248,6 +1594,9 @@ initiate_connection:
 				if token.isError() {
 					tokenErr := token.getError()
 					tokenErr.Message = "login error: " + tokenErr.Message
+					if isPasswordExpired(tokenErr) {
+						return nil, PasswordExpiredError{Err: tokenErr}
+					}
 					return nil, tokenErr
 				}
 			case error:
@@ -1258,16 +1607,109 @@ initiate_connection:
 
 	if sess.routedServer != "" {
 		toconn.Close()
+		if p.AdminConnection {
+			return nil, fmt.Errorf("the server routed the dedicated admin connection to %s", sess.routedServer)
+		}
+		if hops++; hops > maxRoutingHops {
+			return nil, fmt.Errorf("login routed more than %d times, last to %s:%d", maxRoutingHops, sess.routedServer, sess.routedPort)
+		}
+		if uint64(p.LogFlags)&logDebug != 0 {
+			log.Printf("routed to %s:%d", sess.routedServer, sess.routedPort)
+		}
+		// the routing directive names a TCP endpoint of the replica or of
+		// the Azure SQL node, the instance and protocol of the original
+		// server no longer apply
 		p.Host = sess.routedServer
 		p.Port = uint64(sess.routedPort)
+		p.Instance = ""
+		p.Protocol, p.PipeName = "", ""
 		if !p.HostInCertificateProvided && p.TLSConfig != nil {
+			p.TLSConfig = p.TLSConfig.Clone()
 			p.TLSConfig.ServerName = sess.routedServer
 		}
 		goto initiate_connection
 	}
+	sess.params = p
 	return &sess, nil
 }
 
+// tlsConfig returns the TLS configuration of a connection to p.Host. It is a
+// copy of Connector.TLSConfig or of the configuration of the connection
+// string, with the server name defaulted to the host.
+func (c *Connector) tlsConfig(p msdsn.Config) (config *tls.Config, err error) {
+	switch {
+	case c != nil && c.TLSConfig != nil:
+		config = c.TLSConfig.Clone()
+	case p.TLSConfig != nil:
+		config = p.TLSConfig.Clone()
+	default:
+		if config, err = msdsn.SetupTLS("", false, p.Host); err != nil {
+			return nil, err
+		}
+	}
+	if config.ServerName == "" {
+		config.ServerName = p.Host
+	}
+	switch {
+	case c != nil && c.ClientCertificate != nil:
+		config.Certificates = []tls.Certificate{*c.ClientCertificate}
+	case p.ClientCertPath != "" && len(config.Certificates) == 0:
+		cert, err := p.LoadClientCertificate()
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c != nil && c.KeyLogWriter != nil {
+		config.KeyLogWriter = c.KeyLogWriter
+	}
+	if p.TLSMinVersion > config.MinVersion {
+		config.MinVersion = p.TLSMinVersion
+	}
+	if len(p.CipherSuites) > 0 {
+		config.CipherSuites = p.CipherSuites
+	}
+	if len(p.ServerCertificateFingerprints) > 0 {
+		pinCertificate(config, p.ServerCertificateFingerprints)
+	}
+	if p.RevocationCheck != msdsn.RevocationOff {
+		client := http.DefaultClient
+		if c != nil && c.RevocationClient != nil {
+			client = c.RevocationClient
+		}
+		checkRevocationOnVerify(config, p.RevocationCheck, client)
+	}
+	// fix for https://github.com/denisenkom/go-mssqldb/issues/166
+	// Go implementation of TLS payload size heuristic algorithm splits single TDS package to multiple TCP segments,
+	// while SQL Server seems to expect one TCP segment per encrypted TDS package.
+	// Setting DynamicRecordSizingDisabled to true disables that algorithm and uses 16384 bytes per TLS package
+	config.DynamicRecordSizingDisabled = true
+	return config, nil
+}
+
+// pinCertificate replaces the verification of the server certificate with
+// a comparison of its SHA-256 fingerprint to the pinned ones.
+func pinCertificate(config *tls.Config, fingerprints []string) {
+	verify := config.VerifyPeerCertificate
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("the server did not present a certificate")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		got := hex.EncodeToString(sum[:])
+		for _, f := range fingerprints {
+			if f == got {
+				if verify != nil {
+					return verify(rawCerts, chains)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("the server certificate fingerprint %s is not pinned", got)
+	}
+}
+
 func resolveServerPort(port uint64) uint64 {
 	if port == 0 {
 		return defaultServerPort
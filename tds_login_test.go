@@ -8,10 +8,12 @@ import (
 	"io"
 	"net"
 	"regexp"
+	"strings"
 	"sync/atomic"
 	"testing"
 
 	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
 )
 
 type MockTransportDialer struct {
@@ -122,18 +124,19 @@ func TestLoginWithSQLServerAuth(t *testing.T) {
 			"  12 01 00 2f 00 00 01 00  00 00 1a 00 06 01 00 20\n" +
 				"00 01 02 00 21 00 01 03  00 22 00 04 04 00 26 00\n" +
 				"01 ff 00 00 00 00 00 00  00 00 00 00 00 00 00\n",
-			"  10 01 00 b2 00 00 01 00  aa 00 00 00 04 00 00 74\n" +
+			"  10 01 00 c8 00 00 01 00  c0 00 00 00 04 00 00 74\n" +
 				"00 10 00 00 00 00 00 00  00 00 00 00 00 00 00 00\n" +
-				"00 02 00 00 00 00 00 00  00 00 00 00 5e 00 09 00\n" +
+				"00 02 00 10 00 00 00 00  00 00 00 00 5e 00 09 00\n" +
 				"70 00 04 00 78 00 06 00  84 00 0a 00 98 00 09 00\n" +
-				"00 00 00 00 aa 00 00 00  aa 00 00 00 aa 00 00 00\n" +
+				"aa 00 04 00 aa 00 00 00  aa 00 00 00 aa 00 00 00\n" +
 				"00 00 00 00 00 00 aa 00  00 00 aa 00 00 00 aa 00\n" +
 				"00 00 00 00 00 00 6c 00  6f 00 63 00 61 00 6c 00\n" +
 				"68 00 6f 00 73 00 74 00  74 00 65 00 73 00 74 00\n" +
 				"92 a5 f3 a5 93 a5 82 a5  f3 a5 e2 a5 67 00 6f 00\n" +
 				"2d 00 6d 00 73 00 73 00  71 00 6c 00 64 00 62 00\n" +
 				"6c 00 6f 00 63 00 61 00  6c 00 68 00 6f 00 73 00\n" +
-				"74 00\n",
+				"74 00 ae 00 00 00 08 01  00 00 00 01 09 01 00 00\n" +
+				"00 02 0a 00 00 00 00 ff\n",
 		},
 		[]string{
 			"  04 01 00 20  00 00 01 00   00 00 10 00  06 01 00 16\n" +
@@ -164,7 +167,7 @@ func TestLoginWithSecurityTokenAuth(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	conn, err := newSecurityTokenConnector(config,
+	conn, err := NewSecurityTokenConnector(config,
 		func(ctx context.Context) (string, error) {
 			return "<token>", nil
 		},
@@ -181,7 +184,7 @@ func TestLoginWithSecurityTokenAuth(t *testing.T) {
 				"00 01 02 00 26 00 01 03  00 27 00 04 04 00 2B 00\n" +
 				"01 06 00 2c 00 01 ff 00  00 00 00 00 00 00 00 00\n" +
 				"00 00 00 00 01\n",
-			"  10 01 00 BB 00 00 01 00  B3 00 00 00 04 00 00 74\n" +
+			"  10 01 00 CC 00 00 01 00  C4 00 00 00 04 00 00 74\n" +
 				"00 10 00 00 00 00 00 00  00 00 00 00 00 00 00 00\n" +
 				"00 02 00 10 00 00 00 00  00 00 00 00 5E 00 09 00\n" +
 				"70 00 00 00 70 00 00 00  70 00 0A 00 84 00 09 00\n" +
@@ -192,7 +195,8 @@ func TestLoginWithSecurityTokenAuth(t *testing.T) {
 				"73 00 73 00 71 00 6C 00  64 00 62 00 6C 00 6F 00\n" +
 				"63 00 61 00 6C 00 68 00  6F 00 73 00 74 00 9A 00\n" +
 				"00 00 02 13 00 00 00 03  0E 00 00 00 3C 00 74 00\n" +
-				"6F 00 6B 00 65 00 6E 00  3E 00 FF\n",
+				"6F 00 6B 00 65 00 6E 00  3E 00 08 01 00 00 00 01\n" +
+				"09 01 00 00 00 02 0A 00  00 00 00 FF\n",
 		},
 		[]string{
 			"  04 01 00 20  00 00 01 00   00 00 10 00  06 01 00 16\n" +
@@ -242,7 +246,7 @@ func TestLoginWithADALUsernamePasswordAuth(t *testing.T) {
 				"00 01 02 00 26 00 01 03  00 27 00 04 04 00 2B 00\n" +
 				"01 06 00 2C 00 01 ff 00  00 00 00 00 00 00 00 00\n" +
 				"00 00 00 00 01\n",
-			"  10 01 00 aa 00 00 01 00  a2 00 00 00 04 00 00 74\n" +
+			"  10 01 00 bb 00 00 01 00  b3 00 00 00 04 00 00 74\n" +
 				"00 10 00 00 00 00 00 00  00 00 00 00 00 00 00 00\n" +
 				"00 02 00 10 00 00 00 00  00 00 00 00 5e 00 09 00\n" +
 				"70 00 00 00 70 00 00 00  70 00 0a 00 84 00 09 00\n" +
@@ -252,7 +256,8 @@ func TestLoginWithADALUsernamePasswordAuth(t *testing.T) {
 				"68 00 6f 00 73 00 74 00  67 00 6f 00 2d 00 6d 00\n" +
 				"73 00 73 00 71 00 6c 00  64 00 62 00 6c 00 6f 00\n" +
 				"63 00 61 00 6c 00 68 00  6f 00 73 00 74 00 9a 00\n" +
-				"00 00 02 02 00 00 00 05  01 ff\n",
+				"00 00 02 02 00 00 00 05  01 08 01 00 00 00 01 09\n" +
+				"01 00 00 00 02 0a 00 00  00 00 ff\n",
 			"  08 01 00 1e 00 00 01 00  12 00 00 00 0e 00 00 00\n" +
 				"3c 00 74 00 6f 00 6b 00  65 00 6e 00 3e 00\n",
 		},
@@ -314,7 +319,7 @@ func TestLoginWithADALManagedIdentityAuth(t *testing.T) {
 				"00 01 02 00 26 00 01 03  00 27 00 04 04 00 2B 00\n" +
 				"01 06 00 2C 00 01 ff 00  00 00 00 00 00 00 00 00\n" +
 				"00 00 00 00 01\n",
-			"  10 01 00 aa 00 00 01 00  a2 00 00 00 04 00 00 74\n" +
+			"  10 01 00 bb 00 00 01 00  b3 00 00 00 04 00 00 74\n" +
 				"00 10 00 00 00 00 00 00  00 00 00 00 00 00 00 00\n" +
 				"00 02 00 10 00 00 00 00  00 00 00 00 5e 00 09 00\n" +
 				"70 00 00 00 70 00 00 00  70 00 0a 00 84 00 09 00\n" +
@@ -324,7 +329,8 @@ func TestLoginWithADALManagedIdentityAuth(t *testing.T) {
 				"68 00 6f 00 73 00 74 00  67 00 6f 00 2d 00 6d 00\n" +
 				"73 00 73 00 71 00 6c 00  64 00 62 00 6c 00 6f 00\n" +
 				"63 00 61 00 6c 00 68 00  6f 00 73 00 74 00 9a 00\n" +
-				"00 00 02 02 00 00 00 05  03 ff\n",
+				"00 00 02 02 00 00 00 05  03 08 01 00 00 00 01 09\n" +
+				"01 00 00 00 02 0a 00 00  00 00 ff\n",
 			"  08 01 00 1e 00 00 01 00  12 00 00 00 0e 00 00 00\n" +
 				"3c 00 74 00 6f 00 6b 00  65 00 6e 00 3e 00\n",
 		},
@@ -361,3 +367,175 @@ func TestLoginWithADALManagedIdentityAuth(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestLoginChangePassword(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer srv.Close()
+	srv.Authenticate = func(l *mssqltest.Login) error {
+		switch {
+		case l.Password != "old":
+			return mssqltest.Error{Number: 18456, Class: 14, Message: "Login failed"}
+		case l.NewPassword == "":
+			return mssqltest.Error{Number: 18487, Class: 14, Message: "The password of the account has expired."}
+		}
+		return nil
+	}
+
+	c, err := NewConnector(srv.DSN() + "&user+id=app&password=old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Connect(context.Background())
+	if perr, ok := err.(PasswordExpiredError); !ok || perr.Err.Number != 18487 {
+		t.Fatalf("expected PasswordExpiredError, got %#v", err)
+	}
+
+	c, err = NewConnector(srv.DSN() + "&user+id=app&password=old&newpassword=new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	logins := srv.Logins()
+	if last := logins[len(logins)-1]; last.NewPassword != "new" {
+		t.Errorf("expected the new password in the login, got %q", last.NewPassword)
+	}
+}
+
+func TestConnectorCredentials(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer srv.Close()
+	password := "first"
+	srv.Authenticate = func(l *mssqltest.Login) error {
+		if l.User != "app" || l.Password != password {
+			return mssqltest.Error{Number: 18456, Class: 14, Message: "Login failed for user '" + l.User + "'."}
+		}
+		return nil
+	}
+
+	c, err := NewConnector(srv.DSN() + "&user+id=stale&password=stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	c.Credentials = func(ctx context.Context) (string, string, error) {
+		calls++
+		return "app", password, nil
+	}
+	for _, p := range []string{"first", "rotated"} {
+		password = p
+		conn, err := c.Connect(context.Background())
+		if err != nil {
+			t.Fatalf("connecting with password %s: %v", p, err)
+		}
+		conn.Close()
+	}
+	if calls != 2 {
+		t.Errorf("expected the credentials of every connection to be fetched, got %d calls", calls)
+	}
+
+	c.Credentials = func(ctx context.Context) (string, string, error) {
+		return "", "", errors.New("vault sealed")
+	}
+	if _, err = c.Connect(context.Background()); err == nil || err.Error() != "vault sealed" {
+		t.Errorf("expected the credentials error, got %v", err)
+	}
+}
+
+func TestReadOnlyRouting(t *testing.T) {
+	replica := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer replica.Close()
+	listener := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer listener.Close()
+	listener.Route = func(l *mssqltest.Login) (string, uint16) {
+		if !l.ReadOnly {
+			return "", 0
+		}
+		return "127.0.0.1", uint16(replica.Addr().Port)
+	}
+
+	c, err := NewConnector(listener.DSN() + "&database=sales&ApplicationIntent=ReadOnly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	logins := replica.Logins()
+	if len(logins) != 1 || !logins[0].ReadOnly || logins[0].Database != "sales" {
+		t.Fatalf("expected a read-only login to the replica, got %+v", logins)
+	}
+	if logins[0].ServerName != "127.0.0.1" {
+		t.Errorf("expected the routed server name in the login, got %q", logins[0].ServerName)
+	}
+
+	c, err = NewConnector(listener.DSN() + "&database=sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err = c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := len(replica.Logins()); n != 1 {
+		t.Errorf("expected a read-write login to stay on the listener, the replica got %d logins", n)
+	}
+}
+
+func TestRoutingHopLimit(t *testing.T) {
+	node := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer node.Close()
+	gateway := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response { return nil })
+	defer gateway.Close()
+	gateway.Route = func(l *mssqltest.Login) (string, uint16) {
+		return "127.0.0.1", uint16(node.Addr().Port)
+	}
+
+	c, err := NewConnector(gateway.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := len(node.Logins()); n != 1 {
+		t.Fatalf("expected the redirected login on the node, got %d logins", n)
+	}
+
+	// a node that redirects to itself must not loop forever
+	node.Route = func(l *mssqltest.Login) (string, uint16) {
+		return "127.0.0.1", uint16(node.Addr().Port)
+	}
+	_, err = c.Connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "routed more than") {
+		t.Fatalf("expected the hop limit error, got %v", err)
+	}
+	if n := len(node.Logins()); n != 1+maxRoutingHops {
+		t.Errorf("expected %d logins on the node, got %d", 1+maxRoutingHops, n)
+	}
+}
+
+func TestLoginAzureSQLSupport(t *testing.T) {
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN() + "&ApplicationIntent=ReadOnly&database=sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if !srv.Logins()[0].AzureSQLSupport {
+		t.Error("expected the AZURESQLSUPPORT feature extension in the login")
+	}
+}
@@ -4,16 +4,21 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"io"
 	"net"
 	"net/url"
 	"os"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
 )
 
 type MockTransport struct {
@@ -167,7 +172,7 @@ func TestSendSqlBatch(t *testing.T) {
 		{hdrtype: dataStmHdrTransDescr,
 			data: transDescrHdr{0, 1}.pack()},
 	}
-	err = sendSqlBatch72(conn.buf, "select 1", headers, true)
+	err = sendSqlBatch72(conn.buf, "select 1", headers, nil, true)
 	if err != nil {
 		t.Error("Sending sql batch failed", err.Error())
 		return
@@ -636,7 +641,7 @@ func runBatch(t testing.TB, p msdsn.Config) {
 		{hdrtype: dataStmHdrTransDescr,
 			data: transDescrHdr{0, 1}.pack()},
 	}
-	err = sendSqlBatch72(conn.buf, "select 1", headers, true)
+	err = sendSqlBatch72(conn.buf, "select 1", headers, nil, true)
 	if err != nil {
 		t.Error("Sending sql batch failed", err.Error())
 		return
@@ -661,3 +666,156 @@ func runBatch(t testing.TB, p msdsn.Config) {
 		}
 	}
 }
+
+func TestConnectorTLSConfig(t *testing.T) {
+	pool := x509.NewCertPool()
+	custom := &tls.Config{RootCAs: pool}
+	c := &Connector{TLSConfig: custom}
+	p := msdsn.Config{Host: "db.example.com"}
+	config, err := c.tlsConfig(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == custom || config.RootCAs != pool || config.ServerName != "db.example.com" || !config.DynamicRecordSizingDisabled {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if custom.ServerName != "" || custom.DynamicRecordSizingDisabled {
+		t.Error("the connector config was modified")
+	}
+
+	custom.ServerName = "alias.example.com"
+	if config, _ = c.tlsConfig(p); config.ServerName != "alias.example.com" {
+		t.Errorf("the server name of the connector config was replaced by %q", config.ServerName)
+	}
+
+	// without a connector config the connection string config is used
+	p.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	if config, _ = (&Connector{}).tlsConfig(p); !config.InsecureSkipVerify || config.ServerName != "db.example.com" {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
+
+func TestServerSPNTemplate(t *testing.T) {
+	p, _, err := msdsn.Parse("server=db\\inst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl := serverSPNTemplate(p); tmpl != msdsn.DefaultServerSPNTemplate {
+		t.Errorf("the default SPN should follow the instance port, got template %s", tmpl)
+	}
+	p.ServerSPN = "MSSQLSvc/listener.example.com:1433"
+	if tmpl := serverSPNTemplate(p); tmpl != p.ServerSPN {
+		t.Errorf("a custom SPN should be kept, got %s", tmpl)
+	}
+}
+
+// browserDialer sends the UDP requests of the SQL Server Browser service to
+// browser and dials every TCP address to server.
+type browserDialer struct {
+	browser, server string
+	addrs           []string
+}
+
+func (d *browserDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var nd net.Dialer
+	if network == "udp" {
+		return nd.DialContext(ctx, network, d.browser)
+	}
+	d.addrs = append(d.addrs, addr)
+	return nd.DialContext(ctx, network, d.server)
+}
+
+func (d *browserDialer) HostName() string {
+	return ""
+}
+
+func TestAdminConnection(t *testing.T) {
+	browser, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer browser.Close()
+	requests := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := browser.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		requests <- buf[:n]
+		browser.WriteTo([]byte{5, 6, 0, 1, 0xb9, 0xc3}, addr) // port 50105
+	}()
+	srv := mssqltest.NewServer(nil)
+	defer srv.Close()
+
+	c, err := NewConnector(`server=admin:db\INST;encrypt=disable`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &browserDialer{browser: browser.LocalAddr().String(), server: srv.Addr().String()}
+	c.Dialer = d
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if req := <-requests; !bytes.Equal(req, []byte("\x0f\x01INST\x00")) {
+		t.Errorf("unexpected browser request %q", req)
+	}
+	if len(d.addrs) != 1 || d.addrs[0] != "db:50105" {
+		t.Errorf("expected the admin port to be dialed, got %v", d.addrs)
+	}
+}
+
+// hangingDialer never connects to the first address and records when the
+// other addresses are dialed.
+type hangingDialer struct {
+	hung   string
+	start  time.Time
+	dialed chan time.Duration
+	cancel chan error
+}
+
+func (d *hangingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if addr == d.hung {
+		<-ctx.Done()
+		d.cancel <- ctx.Err()
+		return nil, ctx.Err()
+	}
+	d.dialed <- time.Since(d.start)
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func TestDialParallelMultiSubnetFailover(t *testing.T) {
+	d := &hangingDialer{hung: "10.0.0.1:1433", start: time.Now(), dialed: make(chan time.Duration, 1), cancel: make(chan error, 1)}
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+	conn, err := dialParallel(context.Background(), d, ips, 1433, multiSubnetFailoverStagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if elapsed := <-d.dialed; elapsed < multiSubnetFailoverStagger {
+		t.Errorf("the second address should be dialed after %v, was dialed after %v", multiSubnetFailoverStagger, elapsed)
+	}
+	select {
+	case err := <-d.cancel:
+		if err != context.Canceled {
+			t.Errorf("unexpected error of the hung attempt %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the hung attempt was not cancelled")
+	}
+
+	_, err = dialParallel(context.Background(), failingDialer{}, ips, 1433, 0)
+	if err == nil || err.Error() != "refused" {
+		t.Errorf("expected the dial error, got %v", err)
+	}
+}
+
+type failingDialer struct{}
+
+func (failingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return nil, errors.New("refused")
+}
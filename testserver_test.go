@@ -0,0 +1,45 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+// startTestServer starts a fake server answering the requests with handler
+// and returns it with a connector to it. The caller closes the server.
+func startTestServer(t *testing.T, handler mssqltest.Handler) (*mssqltest.Server, *Connector) {
+	t.Helper()
+	srv := mssqltest.NewServer(handler)
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return srv, c
+}
+
+// openTestServer starts a fake server as startTestServer does and returns
+// a database of its connector.
+func openTestServer(t *testing.T, handler mssqltest.Handler) (*sql.DB, *mssqltest.Server) {
+	t.Helper()
+	srv, c := startTestServer(t, handler)
+	return sql.OpenDB(c), srv
+}
+
+// connectTestServer starts a fake server as startTestServer does and
+// returns a connection to it.
+func connectTestServer(t *testing.T, handler mssqltest.Handler) (*mssqltest.Server, *Conn) {
+	t.Helper()
+	srv, c := startTestServer(t, handler)
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return srv, conn.(*Conn)
+}
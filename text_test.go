@@ -0,0 +1,168 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestTextNTextImage(t *testing.T) {
+	text := strings.Repeat("legacy text ", 1000)
+	ntext := strings.Repeat("ab©☀😀", 2000)
+	image := bytes.Repeat([]byte{1, 2, 3}, 5000)
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if len(req.Params) > 0 {
+			return nil
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "t", Type: mssqltest.Text},
+				{Name: "n", Type: mssqltest.NText},
+				{Name: "i", Type: mssqltest.Image},
+			},
+			Rows: [][]interface{}{{text, ntext, image}, {nil, nil, nil}, {"", "", []byte{}}},
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	rows, err := db.Query("select t, n, i from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"TEXT", "NTEXT", "IMAGE"} {
+		if got := types[i].DatabaseTypeName(); got != name {
+			t.Errorf("expected the type %s, got %s", name, got)
+		}
+	}
+	var ts, ns []sql.NullString
+	var is [][]byte
+	for rows.Next() {
+		var tv, nv sql.NullString
+		var iv []byte
+		if err := rows.Scan(&tv, &nv, &iv); err != nil {
+			t.Fatal(err)
+		}
+		ts, ns, is = append(ts, tv), append(ns, nv), append(is, iv)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(ts))
+	}
+	if ts[0].String != text || ns[0].String != ntext || !bytes.Equal(is[0], image) {
+		t.Error("the values of the first row differ")
+	}
+	if ts[1].Valid || ns[1].Valid || is[1] != nil {
+		t.Errorf("expected nulls, got %v %v %v", ts[1], ns[1], is[1])
+	}
+	if !ts[2].Valid || ts[2].String != "" || !ns[2].Valid || ns[2].String != "" || is[2] == nil || len(is[2]) != 0 {
+		t.Errorf("expected empty values, got %v %v %v", ts[2], ns[2], is[2])
+	}
+
+	if _, err := db.Exec("exec p @p1, @p2, @p3, @p4", Text(text), NText(ntext), Image(image), Image(nil)); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	req := reqs[len(reqs)-1]
+	if v := req.Param("@p1").Value; v != text {
+		t.Errorf("unexpected text parameter of length %d", len(v.(string)))
+	}
+	if v := req.Param("@p2").Value; v != ntext {
+		t.Errorf("unexpected ntext parameter %v", v)
+	}
+	if v, _ := req.Param("@p3").Value.([]byte); !bytes.Equal(v, image) {
+		t.Error("unexpected image parameter")
+	}
+	if v := req.Param("@p4").Value; v != nil {
+		t.Errorf("expected a null image parameter, got %v", v)
+	}
+	if c := req.Param("@p3").Collation; c != nil {
+		t.Errorf("expected no collation for image, got %X", c)
+	}
+}
+
+func TestStreamTextNTextImage(t *testing.T) {
+	ntext := strings.Repeat("ab©☀😀", 3000)
+	image := bytes.Repeat([]byte{1, 2, 3}, 10000)
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		typ, value := mssqltest.NText, interface{}(ntext)
+		switch {
+		case strings.Contains(req.SQL, "image"):
+			typ, value = mssqltest.Image, image
+		case strings.Contains(req.SQL, "text"):
+			typ, value = mssqltest.Text, "plain text"
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "id", Type: mssqltest.Int}, {Name: "v", Type: typ}},
+			Rows:    [][]interface{}{{1, value}, {2, nil}, {3, value}, {4, value}},
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	for _, tt := range []struct {
+		query string
+		want  []byte
+	}{
+		{"select id, n from t", []byte(ntext)},
+		{"select id, image from t", image},
+		{"select id, text from t", []byte("plain text")},
+	} {
+		rows, err := db.Query(tt.query, StreamLOBs{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var id int
+		var lob LOB
+		for rows.Next() {
+			if err := rows.Scan(&id, &lob); err != nil {
+				t.Fatal(err)
+			}
+			switch id {
+			case 1, 4:
+				b, err := ioutil.ReadAll(&lob)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(b, tt.want) {
+					t.Errorf("%s: the streamed value of row %d differs", tt.query, id)
+				}
+			case 2:
+				if !lob.Null() {
+					t.Errorf("%s: expected a null value", tt.query)
+				}
+			}
+			// row 3 is not read, discarded by Next
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if id != 4 {
+			t.Errorf("%s: expected 4 rows, the last one is %d", tt.query, id)
+		}
+		rows.Close()
+	}
+}
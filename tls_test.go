@@ -0,0 +1,390 @@
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// tlsServerResult is what a TLS test server saw from the client.
+type tlsServerResult struct {
+	state      tls.ConnectionState
+	packetType packetType
+	err        error
+}
+
+// serveTLSOnce accepts one connection, completes a TLS handshake with
+// config and reads the first TDS packet sent over TLS.
+func serveTLSOnce(t *testing.T, config *tls.Config) (addr *net.TCPAddr, result <-chan tlsServerResult) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan tlsServerResult, 1)
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			ch <- tlsServerResult{err: err}
+			return
+		}
+		defer c.Close()
+		tc := tls.Server(c, config)
+		if err = tc.Handshake(); err != nil {
+			ch <- tlsServerResult{err: err}
+			return
+		}
+		res := tlsServerResult{state: tc.ConnectionState()}
+		res.packetType, res.err = newTdsBuffer(defaultPacketSize, tc).BeginRead()
+		ch <- res
+	}()
+	return l.Addr().(*net.TCPAddr), ch
+}
+
+func TestConnectStrictEncryption(t *testing.T) {
+	cert := testCertificate(t)
+	addr, result := serveTLSOnce(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{alpnTDS8},
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	c := NewConnectorConfig(msdsn.Config{
+		Host:       "127.0.0.1",
+		Port:       uint64(addr.Port),
+		Encryption: msdsn.EncryptionStrict,
+	})
+	c.TLSConfig = &tls.Config{RootCAs: roots}
+
+	// the server closes the connection after the first packet
+	if _, err := c.Connect(context.Background()); err == nil {
+		t.Error("expected the connection to fail")
+	}
+	res := <-result
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.state.NegotiatedProtocol != alpnTDS8 || res.state.Version < tls.VersionTLS12 {
+		t.Errorf("unexpected TLS state, protocol %q, version %x", res.state.NegotiatedProtocol, res.state.Version)
+	}
+	if res.packetType != packPrelogin {
+		t.Errorf("expected PRELOGIN inside TLS, got packet type %d", res.packetType)
+	}
+}
+
+func TestKeyLogWriter(t *testing.T) {
+	cert := testCertificate(t)
+	addr, result := serveTLSOnce(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	c := NewConnectorConfig(msdsn.Config{
+		Host:       "127.0.0.1",
+		Port:       uint64(addr.Port),
+		Encryption: msdsn.EncryptionStrict,
+	})
+	c.TLSConfig = &tls.Config{RootCAs: roots}
+	var keyLog bytes.Buffer
+	c.KeyLogWriter = &keyLog
+
+	c.Connect(context.Background())
+	if res := <-result; res.err != nil {
+		t.Fatal(res.err)
+	}
+	if !strings.Contains(keyLog.String(), "CLIENT_") {
+		t.Errorf("expected NSS key log lines, got %q", keyLog.String())
+	}
+	if c.TLSConfig.KeyLogWriter != nil {
+		t.Error("the connector TLS config was modified")
+	}
+}
+
+func TestServerCertificateFingerprint(t *testing.T) {
+	cert := testCertificate(t)
+	sum := sha256.Sum256(cert.Certificate[0])
+	for _, c := range []struct {
+		fingerprint string
+		ok          bool
+	}{
+		{hex.EncodeToString(sum[:]), true},
+		{strings.Repeat("ab", sha256.Size), false},
+	} {
+		addr, result := serveTLSOnce(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+		// the certificate is self-signed and not trusted, only pinned
+		connector := NewConnectorConfig(msdsn.Config{
+			Host:                          "127.0.0.1",
+			Port:                          uint64(addr.Port),
+			Encryption:                    msdsn.EncryptionStrict,
+			ServerCertificateFingerprints: []string{c.fingerprint},
+		})
+		_, err := connector.Connect(context.Background())
+		res := <-result
+		if c.ok && res.err != nil {
+			t.Errorf("the pinned certificate was rejected: %v", res.err)
+		}
+		if !c.ok && (res.err == nil || err == nil || !strings.Contains(err.Error(), "not pinned")) {
+			t.Errorf("a certificate that is not pinned was accepted, client error %v", err)
+		}
+	}
+}
+
+// writeCertificatePEM writes the certificate and key to PEM files.
+func writeCertificatePEM(t *testing.T, dir string, cert tls.Certificate) (certPath, keyPath string) {
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err = ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mssql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert := testCertificate(t)
+	certPath, keyPath := writeCertificatePEM(t, dir, cert)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert.Leaf)
+
+	addr, result := serveTLSOnce(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	dsn := fmt.Sprintf("sqlserver://127.0.0.1:%d?encrypt=strict&certificate=%s&clientcertpath=%s&clientkeypath=%s",
+		addr.Port, url.QueryEscape(certPath), url.QueryEscape(certPath), url.QueryEscape(keyPath))
+	connector, err := NewConnector(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector.Connect(context.Background())
+	res := <-result
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if len(res.state.PeerCertificates) != 1 || !res.state.PeerCertificates[0].Equal(cert.Leaf) {
+		t.Error("the client certificate was not presented")
+	}
+}
+
+// issueCertificate returns a certificate for 127.0.0.1 issued by ca, with
+// the revocation endpoints set in tmpl.
+func issueCertificate(t *testing.T, ca tls.Certificate, serial int64, tmpl *x509.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(serial)
+	tmpl.Subject = pkix.Name{CommonName: "127.0.0.1"}
+	tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Leaf, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.Certificate[0]}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestRevocationCheck(t *testing.T) {
+	ca := testCertificate(t)
+	const revokedSerial = 3
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			crl, err := ca.Leaf.CreateCRL(rand.Reader, ca.PrivateKey, []pkix.RevokedCertificate{
+				{SerialNumber: big.NewInt(revokedSerial), RevocationTime: time.Now()},
+			}, time.Now(), time.Now().Add(time.Hour))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(crl)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status := ocsp.Good
+		if req.SerialNumber.Int64() == revokedSerial {
+			status = ocsp.Revoked
+		}
+		resp, err := ocsp.CreateResponse(ca.Leaf, ca.Leaf, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now(),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now(),
+		}, ca.PrivateKey.(*ecdsa.PrivateKey))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(resp)
+	}))
+	defer responder.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	for _, c := range []struct {
+		name string
+		cert tls.Certificate
+		mode msdsn.RevocationCheck
+		ok   bool
+	}{
+		{"ocsp good", issueCertificate(t, ca, 2, &x509.Certificate{OCSPServer: []string{responder.URL}}), msdsn.RevocationHardFail, true},
+		{"ocsp revoked", issueCertificate(t, ca, revokedSerial, &x509.Certificate{OCSPServer: []string{responder.URL}}), msdsn.RevocationSoftFail, false},
+		{"crl good", issueCertificate(t, ca, 2, &x509.Certificate{CRLDistributionPoints: []string{responder.URL}}), msdsn.RevocationHardFail, true},
+		{"crl revoked", issueCertificate(t, ca, revokedSerial, &x509.Certificate{CRLDistributionPoints: []string{responder.URL}}), msdsn.RevocationSoftFail, false},
+		{"responder down soft", issueCertificate(t, ca, 2, &x509.Certificate{OCSPServer: []string{down.URL}}), msdsn.RevocationSoftFail, true},
+		{"responder down hard", issueCertificate(t, ca, 2, &x509.Certificate{OCSPServer: []string{down.URL}}), msdsn.RevocationHardFail, false},
+		{"no endpoints hard", issueCertificate(t, ca, 2, &x509.Certificate{}), msdsn.RevocationHardFail, false},
+	} {
+		addr, result := serveTLSOnce(t, &tls.Config{Certificates: []tls.Certificate{c.cert}})
+		connector := NewConnectorConfig(msdsn.Config{
+			Host:            "127.0.0.1",
+			Port:            uint64(addr.Port),
+			Encryption:      msdsn.EncryptionStrict,
+			RevocationCheck: c.mode,
+		})
+		connector.TLSConfig = &tls.Config{RootCAs: roots}
+		_, err := connector.Connect(context.Background())
+		res := <-result
+		if c.ok && res.err != nil {
+			t.Errorf("%s: the certificate was rejected: %v, client error %v", c.name, res.err, err)
+		}
+		if !c.ok && (res.err == nil || err == nil || !strings.Contains(err.Error(), "revocation")) {
+			t.Errorf("%s: the certificate was accepted, client error %v", c.name, err)
+		}
+	}
+
+	// certificates that are not verified have an unknown status
+	cert := issueCertificate(t, ca, 2, &x509.Certificate{OCSPServer: []string{responder.URL}})
+	sum := sha256.Sum256(cert.Certificate[0])
+	for _, c := range []struct {
+		name string
+		cfg  msdsn.Config
+		tls  *tls.Config
+		ok   bool
+	}{
+		{"skipped soft", msdsn.Config{RevocationCheck: msdsn.RevocationSoftFail}, &tls.Config{InsecureSkipVerify: true}, true},
+		{"skipped hard", msdsn.Config{RevocationCheck: msdsn.RevocationHardFail}, &tls.Config{InsecureSkipVerify: true}, false},
+		{"pinned soft", msdsn.Config{RevocationCheck: msdsn.RevocationSoftFail, ServerCertificateFingerprints: []string{hex.EncodeToString(sum[:])}}, &tls.Config{}, true},
+		{"pinned hard", msdsn.Config{RevocationCheck: msdsn.RevocationHardFail, ServerCertificateFingerprints: []string{hex.EncodeToString(sum[:])}}, &tls.Config{}, false},
+	} {
+		addr, result := serveTLSOnce(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+		c.cfg.Host, c.cfg.Port, c.cfg.Encryption = "127.0.0.1", uint64(addr.Port), msdsn.EncryptionStrict
+		connector := NewConnectorConfig(c.cfg)
+		connector.TLSConfig = c.tls
+		_, err := connector.Connect(context.Background())
+		res := <-result
+		if c.ok && res.err != nil {
+			t.Errorf("%s: the certificate was rejected: %v, client error %v", c.name, res.err, err)
+		}
+		if !c.ok && (res.err == nil || err == nil || !strings.Contains(err.Error(), "revocation")) {
+			t.Errorf("%s: the certificate was accepted, client error %v", c.name, err)
+		}
+	}
+}
+
+func TestTLSMinVersionAndCipherSuites(t *testing.T) {
+	cert := testCertificate(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	connect := func(dsn string, server *tls.Config) (tlsServerResult, error) {
+		server.Certificates = []tls.Certificate{cert}
+		addr, result := serveTLSOnce(t, server)
+		connector, err := NewConnector(fmt.Sprintf("sqlserver://127.0.0.1:%d?encrypt=strict&%s", addr.Port, dsn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		connector.TLSConfig = &tls.Config{RootCAs: roots}
+		_, err = connector.Connect(context.Background())
+		return <-result, err
+	}
+
+	res, _ := connect("tlsmin=1.3", &tls.Config{MaxVersion: tls.VersionTLS12})
+	if res.err == nil {
+		t.Error("a TLS 1.2 server was accepted with tlsmin=1.3")
+	}
+
+	res, _ = connect("ciphersuites=TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", &tls.Config{MaxVersion: tls.VersionTLS12})
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.state.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305 {
+		t.Errorf("unexpected cipher suite %x", res.state.CipherSuite)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"io/ioutil"
 	"strconv"
+	"time"
 )
 
 //go:generate go run golang.org/x/tools/cmd/stringer -type token
@@ -16,22 +17,26 @@ type token byte
 
 // token ids
 const (
-	tokenReturnStatus  token = 121 // 0x79
-	tokenColMetadata   token = 129 // 0x81
-	tokenOrder         token = 169 // 0xA9
-	tokenError         token = 170 // 0xAA
-	tokenInfo          token = 171 // 0xAB
-	tokenReturnValue   token = 0xAC
-	tokenLoginAck      token = 173 // 0xad
-	tokenFeatureExtAck token = 174 // 0xae
-	tokenRow           token = 209 // 0xd1
-	tokenNbcRow        token = 210 // 0xd2
-	tokenEnvChange     token = 227 // 0xE3
-	tokenSSPI          token = 237 // 0xED
-	tokenFedAuthInfo   token = 238 // 0xEE
-	tokenDone          token = 253 // 0xFD
-	tokenDoneProc      token = 254
-	tokenDoneInProc    token = 255
+	tokenReturnStatus       token = 121 // 0x79
+	tokenColMetadata        token = 129 // 0x81
+	tokenTabName            token = 164 // 0xA4
+	tokenColInfo            token = 165 // 0xA5
+	tokenOrder              token = 169 // 0xA9
+	tokenError              token = 170 // 0xAA
+	tokenInfo               token = 171 // 0xAB
+	tokenReturnValue        token = 0xAC
+	tokenLoginAck           token = 173 // 0xad
+	tokenFeatureExtAck      token = 174 // 0xae
+	tokenRow                token = 209 // 0xd1
+	tokenNbcRow             token = 210 // 0xd2
+	tokenEnvChange          token = 227 // 0xE3
+	tokenSessionState       token = 228 // 0xE4
+	tokenDataClassification token = 163 // 0xA3
+	tokenSSPI               token = 237 // 0xED
+	tokenFedAuthInfo        token = 238 // 0xEE
+	tokenDone               token = 253 // 0xFD
+	tokenDoneProc           token = 254
+	tokenDoneInProc         token = 255
 )
 
 // done flags
@@ -78,8 +83,18 @@ const (
 // COLMETADATA flags
 // https://msdn.microsoft.com/en-us/library/dd357363.aspx
 const (
-	colFlagNullable = 1
-	// TODO implement more flags
+	colFlagNullable        = 1
+	colFlagCaseSensitive   = 0x0002
+	colFlagUpdateable      = 0x000c
+	colFlagReadWrite       = 0x0004
+	colFlagIdentity        = 0x0010
+	colFlagComputed        = 0x0020
+	colFlagFixedLenCLRType = 0x0100
+	colFlagSparseColumnSet = 0x0400
+	colFlagEncrypted       = 0x0800
+	colFlagHidden          = 0x2000
+	colFlagKey             = 0x4000
+	colFlagNullableUnknown = 0x8000
 )
 
 // interface for all tokens
@@ -139,9 +154,8 @@ func processEnvChg(sess *tdsSession) {
 				badStreamPanic(err)
 			}
 		case envTypLanguage:
-			// currently ignored
 			// new value
-			if _, err = readBVarChar(r); err != nil {
+			if sess.language, err = readBVarChar(r); err != nil {
 				badStreamPanic(err)
 			}
 			// old value
@@ -193,7 +207,6 @@ func processEnvChg(sess *tdsSession) {
 				badStreamPanic(err)
 			}
 		case envSqlCollation:
-			// currently ignored
 			var collationSize uint8
 			err = binary.Read(r, binary.LittleEndian, &collationSize)
 			if err != nil {
@@ -218,6 +231,9 @@ func processEnvChg(sess *tdsSession) {
 			if err != nil {
 				badStreamPanic(err)
 			}
+			sess.collation = make([]byte, 5)
+			binary.LittleEndian.PutUint32(sess.collation, info)
+			sess.collation[4] = sortID
 
 			// old value, should be 0
 			if _, err = readBVarChar(r); err != nil {
@@ -281,6 +297,9 @@ func processEnvChg(sess *tdsSession) {
 			if err != nil {
 				badStreamPanic(err)
 			}
+			if sess.partnerChanged != nil {
+				sess.partnerChanged(sess.partner)
+			}
 			_, err = readBVarChar(r)
 			if err != nil {
 				badStreamPanic(err)
@@ -317,7 +336,6 @@ func processEnvChg(sess *tdsSession) {
 				badStreamPanic(err)
 			}
 		case envResetConnAck:
-			// currently ignored
 			// old value, should be 0
 			if _, err = readBVarChar(r); err != nil {
 				badStreamPanic(err)
@@ -326,6 +344,11 @@ func processEnvChg(sess *tdsSession) {
 			if _, err = readBVarChar(r); err != nil {
 				badStreamPanic(err)
 			}
+			// the reset rolled back any transaction the session left open
+			if sess.tranid != 0 && sess.logFlags&logTransaction != 0 {
+				sess.log.Printf("ROLLBACK TRANSACTION %x\n", sess.tranid)
+			}
+			sess.tranid = 0
 		case envStartedInstanceName:
 			// currently ignored
 			// old value, should be 0
@@ -347,9 +370,12 @@ func processEnvChg(sess *tdsSession) {
 				badStreamPanic(err)
 			}
 			protocol, err := readByte(r)
-			if err != nil || protocol != 0 {
+			if err != nil {
 				badStreamPanic(err)
 			}
+			if protocol != 0 {
+				badStreamPanicf("unsupported routing protocol %d", protocol)
+			}
 			newPort, err := readUshort(r)
 			if err != nil {
 				badStreamPanic(err)
@@ -520,6 +546,37 @@ func parseFeatureExtAck(r *tdsBuffer) map[byte]interface{} {
 		length := r.uint32()
 
 		switch feature {
+		case featExtSESSIONRECOVERY:
+			initial := make([]byte, length)
+			r.ReadFull(initial)
+			length = 0
+			ack[feature] = initial
+		case featExtCOLUMNENCRYPTION:
+			var ae columnEncryptionAck
+			if length >= 1 {
+				ae.version = r.byte()
+				length--
+			}
+			if ae.version >= 2 && length >= 1 {
+				ae.enclaveType = r.BVarChar()
+				length -= uint32(1 + 2*len(ae.enclaveType))
+			}
+			ack[feature] = ae
+		case featExtUTF8SUPPORT:
+			// bit 0 tells whether UTF-8 is supported
+			if length >= 1 {
+				ack[feature] = r.byte()&0x01 != 0
+				length--
+			}
+		case featExtDATACLASSIFICATION:
+			// the version and whether classification is enabled
+			if length >= 2 {
+				version, enabled := r.byte(), r.byte()
+				length -= 2
+				if enabled != 0 {
+					ack[feature] = version
+				}
+			}
 		case featExtFEDAUTH:
 			// In theory we need to know the federated authentication library to
 			// know how to parse, but the alternatives provide compatible structures.
@@ -548,12 +605,18 @@ func parseFeatureExtAck(r *tdsBuffer) map[byte]interface{} {
 }
 
 // http://msdn.microsoft.com/en-us/library/dd357363.aspx
-func parseColMetadata72(r *tdsBuffer) (columns []columnStruct) {
+// The metadata has a table of column encryption keys when columnEncryption
+// is set.
+func parseColMetadata72(r *tdsBuffer, columnEncryption bool) (columns []columnStruct) {
 	count := r.uint16()
 	if count == 0xffff {
 		// no metadata is sent
 		return nil
 	}
+	var cekTable []*cekTableEntry
+	if columnEncryption {
+		cekTable = parseCekTable(r)
+	}
 	columns = make([]columnStruct, count)
 	for i := range columns {
 		column := &columns[i]
@@ -562,30 +625,77 @@ func parseColMetadata72(r *tdsBuffer) (columns []columnStruct) {
 
 		// parsing TYPE_INFO structure
 		column.ti = readTypeInfo(r)
+		if column.Flags&colFlagEncrypted != 0 {
+			column.cryptoMeta = parseCryptoMetadata(r, cekTable)
+			column.cryptoMeta.cipherTI, column.ti = column.ti, column.cryptoMeta.baseTI
+		}
 		column.ColName = r.BVarChar()
 	}
 	return columns
 }
 
 // http://msdn.microsoft.com/en-us/library/dd357254.aspx
-func parseRow(r *tdsBuffer, columns []columnStruct, row []interface{}) {
-	for i, column := range columns {
-		row[i] = column.ti.Reader(&column.ti, r)
+func parseRow(r *tdsBuffer, columns []columnStruct, row []interface{}, bufs *rowBuffers) {
+	for i := range columns {
+		row[i] = columns[i].readValue(r, bufs)
 	}
 }
 
+// readValue reads a value of the column, the ciphertext of an encrypted
+// column. The values of the character and binary types are read in bufs,
+// unless it is nil.
+func (column *columnStruct) readValue(r *tdsBuffer, bufs *rowBuffers) interface{} {
+	if m := column.cryptoMeta; m != nil {
+		return m.cipherTI.Reader(&m.cipherTI, r)
+	}
+	if bufs != nil {
+		if v, ok := bufs.readValue(&column.ti, r); ok {
+			return v
+		}
+	}
+	return column.ti.Reader(&column.ti, r)
+}
+
 // http://msdn.microsoft.com/en-us/library/dd304783.aspx
-func parseNbcRow(r *tdsBuffer, columns []columnStruct, row []interface{}) {
+func parseNbcRow(r *tdsBuffer, columns []columnStruct, row []interface{}, bufs *rowBuffers) {
 	bitlen := (len(columns) + 7) / 8
 	pres := make([]byte, bitlen)
 	r.ReadFull(pres)
-	for i, col := range columns {
+	for i := range columns {
 		if pres[i/8]&(1<<(uint(i)%8)) != 0 {
 			row[i] = nil
 			continue
 		}
-		row[i] = col.ti.Reader(&col.ti, r)
+		row[i] = columns[i].readValue(r, bufs)
+	}
+}
+
+// parseStreamedRow reads a ROW or NBCROW token up to the value of the last
+// column, which is left in the response to be streamed. It returns the
+// stream, nil when the value is null.
+func parseStreamedRow(r *tdsBuffer, token token, columns []columnStruct, row []interface{}, bufs *rowBuffers) *lobStream {
+	last := len(columns) - 1
+	if token == tokenRow {
+		parseRow(r, columns[:last], row, bufs)
+	} else {
+		pres := make([]byte, (len(columns)+7)/8)
+		r.ReadFull(pres)
+		for i := range columns[:last] {
+			if pres[i/8]&(1<<(uint(i)%8)) != 0 {
+				row[i] = nil
+				continue
+			}
+			row[i] = columns[i].readValue(r, bufs)
+		}
+		if pres[last/8]&(1<<(uint(last)%8)) != 0 {
+			return nil
+		}
+	}
+	lob := newLOBStream(r, &columns[last].ti)
+	if lob != nil {
+		row[last] = lob
 	}
+	return lob
 }
 
 // http://msdn.microsoft.com/en-us/library/dd304156.aspx
@@ -617,7 +727,7 @@ func parseInfo(r *tdsBuffer) (res Error) {
 }
 
 // https://msdn.microsoft.com/en-us/library/dd303881.aspx
-func parseReturnValue(r *tdsBuffer) (nv namedValue) {
+func parseReturnValue(r *tdsBuffer) (nv namedValue, crypto *cryptoMetadata) {
 	/*
 		ParamOrdinal
 		ParamName
@@ -632,8 +742,11 @@ func parseReturnValue(r *tdsBuffer) (nv namedValue) {
 	nv.Name = r.BVarChar()
 	r.byte()
 	r.uint32() // UserType (uint16 prior to 7.2)
-	r.uint16()
+	flags := r.uint16()
 	ti := readTypeInfo(r)
+	if flags&colFlagEncrypted != 0 {
+		crypto = readCryptoMetadata(r)
+	}
 	nv.Value = ti.Reader(&ti, r)
 	return
 }
@@ -661,7 +774,16 @@ func processSingleResponse(sess *tdsSession, ch chan tokenStruct, outs outputs)
 		badStreamPanic(fmt.Errorf("unexpected packet type in reply: got %v, expected %v", packet_type, packReply))
 	}
 	var columns []columnStruct
+	var bufs *rowBuffers
+	if outs.reuseRowBuffers {
+		bufs = new(rowBuffers)
+	}
 	errs := make([]Error, 0, 5)
+	results := statementResultsReader{dest: outs.statementResults, rpc: outs.rpc}
+	if results.dest != nil {
+		// the results of a failed attempt of the request are replaced
+		*results.dest = nil
+	}
 	for tokens := 0; ; tokens += 1 {
 		token := token(sess.buf.byte())
 		if sess.logFlags&logDebug != 0 {
@@ -691,6 +813,7 @@ func processSingleResponse(sess *tdsSession, ch chan tokenStruct, outs outputs)
 			if sess.logFlags&logRows != 0 && done.Status&doneCount != 0 {
 				sess.log.Printf("(%d row(s) affected)\n", done.RowCount)
 			}
+			results.done(token, doneStruct(done), errs)
 			ch <- done
 		case tokenDone, tokenDoneProc:
 			done := parseDone(sess.buf)
@@ -705,23 +828,80 @@ func processSingleResponse(sess *tdsSession, ch chan tokenStruct, outs outputs)
 			if sess.logFlags&logRows != 0 && done.Status&doneCount != 0 {
 				sess.log.Printf("(%d row(s) affected)\n", done.RowCount)
 			}
+			results.done(token, done, errs)
 			ch <- done
 			if done.Status&doneMore == 0 {
 				return
 			}
 		case tokenColMetadata:
-			columns = parseColMetadata72(sess.buf)
+			columns = parseColMetadata72(sess.buf, sess.columnEncryption)
+			// the classification and the browse mode metadata of the
+			// columns, if any, are sent ahead of them so that they are
+			// known when the result set starts
+			var tables [][]string
+		metadata:
+			for {
+				b, err := sess.buf.peekByte()
+				if err != nil {
+					break
+				}
+				switch {
+				case b == byte(tokenDataClassification) && sess.dataClassification != 0:
+					sess.buf.byte()
+					ch <- parseDataClassification(sess.buf, sess.dataClassification)
+				case b == byte(tokenTabName):
+					sess.buf.byte()
+					tables = parseTabName(sess.buf)
+				case b == byte(tokenColInfo):
+					sess.buf.byte()
+					parseColInfo(sess.buf, columns, tables)
+				default:
+					break metadata
+				}
+			}
 			ch <- columns
-		case tokenRow:
-			row := make([]interface{}, len(columns))
-			parseRow(sess.buf, columns, row)
-			ch <- row
-		case tokenNbcRow:
-			row := make([]interface{}, len(columns))
-			parseNbcRow(sess.buf, columns, row)
+		case tokenRow, tokenNbcRow:
+			row := bufs.nextRow(len(columns))
+			var lob *lobStream
+			if outs.streamLOBs && streamsLastColumn(columns) {
+				lob = parseStreamedRow(sess.buf, token, columns, row, bufs)
+			} else if token == tokenRow {
+				parseRow(sess.buf, columns, row, bufs)
+			} else {
+				parseNbcRow(sess.buf, columns, row, bufs)
+			}
+			if err := decryptRow(sess, columns, row); err != nil {
+				if lob != nil {
+					lob.Close()
+				}
+				ch <- err
+				continue
+			}
+			if sess.decimalAsString {
+				decimalsToStrings(columns, row)
+			}
+			if sess.location != nil {
+				timesInLocation(columns, row, sess.location)
+			}
 			ch <- row
+			if lob != nil {
+				// the receiver of the row reads the value from the
+				// response in the meantime
+				if err := lob.wait(); err != nil {
+					badStreamPanic(err)
+				}
+			}
 		case tokenEnvChange:
 			processEnvChg(sess)
+		case tokenDataClassification:
+			// not following a COLMETADATA token
+			parseDataClassification(sess.buf, sess.dataClassification)
+		case tokenTabName:
+			parseTabName(sess.buf)
+		case tokenColInfo:
+			parseColInfo(sess.buf, nil, nil)
+		case tokenSessionState:
+			parseSessionState(sess)
 		case tokenError:
 			err := parseError72(sess.buf)
 			if sess.logFlags&logDebug != 0 {
@@ -739,8 +919,28 @@ func processSingleResponse(sess *tdsSession, ch chan tokenStruct, outs outputs)
 			if sess.logFlags&logMessages != 0 {
 				sess.log.Println(info.Message)
 			}
+			if outs.msgFunc != nil {
+				outs.msgFunc(info)
+			}
 		case tokenReturnValue:
-			nv := parseReturnValue(sess.buf)
+			nv, crypto := parseReturnValue(sess.buf)
+			if crypto != nil {
+				// the key is the one of the encrypted parameter
+				if m := outs.encrypted[nv.Name]; m != nil {
+					crypto.entry = m.entry
+				}
+				if nv.Value, err = decryptValue(sess, crypto, nv.Value); err != nil {
+					ch <- fmt.Errorf("mssql: cannot decrypt return value %s: %v", nv.Name, err)
+					continue
+				}
+			}
+			if outs.prepared != nil {
+				if handle, ok := nv.Value.(int64); ok {
+					outs.prepared(int32(handle))
+				}
+				outs.prepared = nil
+				continue
+			}
 			if len(nv.Name) > 0 {
 				name := nv.Name[1:] // Remove the leading "@".
 				if ov, has := outs.params[name]; has {
@@ -757,6 +957,36 @@ func processSingleResponse(sess *tdsSession, ch chan tokenStruct, outs outputs)
 	}
 }
 
+type messageFuncKey struct{}
+
+// withMessageFunc returns a context whose queries pass the informational
+// messages they receive to f. f is called from the goroutine reading the
+// response, before the following tokens are processed.
+func withMessageFunc(ctx context.Context, f func(Error)) context.Context {
+	return context.WithValue(ctx, messageFuncKey{}, f)
+}
+
+type messageHandlerKey struct{}
+
+// WithMessageHandler returns a context whose queries pass the informational
+// messages they receive to handler, in place of Connector.MessageHandler,
+// or to none if handler is nil. Informational messages are the output of
+// PRINT and of RAISERROR with a severity below 11, in the Class field, and
+// messages such as those of DBCC, with the number, state, line and
+// procedure of the statement that raised them. They are not errors and do
+// not fail the query.
+//
+// handler is called from the goroutine reading the response, as the
+// messages arrive, so that the progress of a long running statement can
+// be followed before it completes. It must not run queries on the same
+// connection.
+func WithMessageHandler(ctx context.Context, handler func(msg Error)) context.Context {
+	return context.WithValue(ctx, messageHandlerKey{}, handler)
+}
+
+// tokenChanSize is the number of tokens read ahead of the caller.
+const tokenChanSize = 5
+
 type tokenProcessor struct {
 	tokChan    chan tokenStruct
 	ctx        context.Context
@@ -768,7 +998,23 @@ type tokenProcessor struct {
 }
 
 func startReading(sess *tdsSession, ctx context.Context, outs outputs) *tokenProcessor {
-	tokChan := make(chan tokenStruct, 5)
+	handler := sess.messageHandler
+	if f, ok := ctx.Value(messageHandlerKey{}).(func(Error)); ok {
+		handler = f
+	}
+	if f, ok := ctx.Value(messageFuncKey{}).(func(Error)); ok && f != nil {
+		// the messages go to the driver, then to the handler
+		if next := handler; next != nil {
+			handler = func(msg Error) {
+				f(msg)
+				next(msg)
+			}
+		} else {
+			handler = f
+		}
+	}
+	outs.msgFunc = handler
+	tokChan := make(chan tokenStruct, tokenChanSize)
 	go processSingleResponse(sess, tokChan, outs)
 	return &tokenProcessor{
 		tokChan: tokChan,
@@ -789,6 +1035,7 @@ func (t *tokenProcessor) iterateResponse() error {
 				case []columnStruct:
 					t.sess.columns = token
 				case []interface{}:
+					closeLOBs(token)
 					t.lastRow = token
 				case doneInProcStruct:
 					if token.Status&doneCount != 0 {
@@ -860,17 +1107,25 @@ func (t tokenProcessor) nextToken() (tokenStruct, error) {
 		// in this case current response would not contain confirmation
 		// and we would need to read one more response
 
+		stopKill := t.scheduleKill()
+
 		// first lets finish reading current response and look
 		// for confirmation in it
-		if readCancelConfirmation(t.tokChan) {
-			// we got confirmation in current response
-			return nil, t.ctx.Err()
+		confirmed := readCancelConfirmation(t.tokChan)
+		if !confirmed {
+			// we did not get cancellation confirmation in the current response
+			// read one more response, it must be there
+			t.tokChan = make(chan tokenStruct, tokenChanSize)
+			go processSingleResponse(t.sess, t.tokChan, t.outs)
+			confirmed = readCancelConfirmation(t.tokChan)
 		}
-		// we did not get cancellation confirmation in the current response
-		// read one more response, it must be there
-		t.tokChan = make(chan tokenStruct, 5)
-		go processSingleResponse(t.sess, t.tokChan, t.outs)
-		if readCancelConfirmation(t.tokChan) {
+		if killed, err := stopKill(); killed {
+			if err != nil {
+				return nil, StreamError{Message: fmt.Sprintf("cancellation was not acknowledged and KILL failed: %v", err)}
+			}
+			return nil, StreamError{Message: "session was killed, the server did not acknowledge the cancellation in time"}
+		}
+		if confirmed {
 			return nil, t.ctx.Err()
 		}
 		// we did not get cancellation confirmation, something is not
@@ -879,11 +1134,33 @@ func (t tokenProcessor) nextToken() (tokenStruct, error) {
 	}
 }
 
+// scheduleKill kills the session when the server does not acknowledge an
+// attention in time, see WithQueryTimeout. The returned function cancels
+// it, it reports whether the session was killed and the error of KILL.
+func (t *tokenProcessor) scheduleKill() func() (bool, error) {
+	killAfter := killAfterFromContext(t.ctx)
+	if killAfter <= 0 || t.sess.killSession == nil {
+		return func() (bool, error) { return false, nil }
+	}
+	killed := make(chan error, 1)
+	timer := time.AfterFunc(killAfter, func() {
+		killed <- t.sess.killSession()
+	})
+	return func() (bool, error) {
+		if timer.Stop() {
+			return false, nil
+		}
+		return true, <-killed
+	}
+}
+
 func readCancelConfirmation(tokChan chan tokenStruct) bool {
 	for tok := range tokChan {
 		switch tok := tok.(type) {
 		default:
 		// just skip token
+		case []interface{}:
+			closeLOBs(tok)
 		case doneStruct:
 			if tok.Status&doneAttn != 0 {
 				// got cancellation confirmation, exit
@@ -9,7 +9,7 @@ const (
 	_token_name_1 = "tokenColMetadata"
 	_token_name_2 = "tokenOrdertokenErrortokenInfotokenReturnValuetokenLoginAcktokenFeatureExtAck"
 	_token_name_3 = "tokenRowtokenNbcRow"
-	_token_name_4 = "tokenEnvChange"
+	_token_name_4 = "tokenEnvChangetokenSessionState"
 	_token_name_5 = "tokenSSPItokenFedAuthInfo"
 	_token_name_6 = "tokenDonetokenDoneProctokenDoneInProc"
 )
@@ -17,6 +17,7 @@ const (
 var (
 	_token_index_2 = [...]uint8{0, 10, 20, 29, 45, 58, 76}
 	_token_index_3 = [...]uint8{0, 8, 19}
+	_token_index_4 = [...]uint8{0, 14, 31}
 	_token_index_5 = [...]uint8{0, 9, 25}
 	_token_index_6 = [...]uint8{0, 9, 22, 37}
 )
@@ -33,8 +34,9 @@ func (i token) String() string {
 	case 209 <= i && i <= 210:
 		i -= 209
 		return _token_name_3[_token_index_3[i]:_token_index_3[i+1]]
-	case i == 227:
-		return _token_name_4
+	case 227 <= i && i <= 228:
+		i -= 227
+		return _token_name_4[_token_index_4[i]:_token_index_4[i+1]]
 	case 237 <= i && i <= 238:
 		i -= 237
 		return _token_name_5[_token_index_5[i]:_token_index_5[i+1]]
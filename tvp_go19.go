@@ -4,13 +4,15 @@ package mssql
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strings"
-	"time"
 )
 
 const (
@@ -30,6 +32,14 @@ var (
 )
 
 //TVP is driver type, which allows supporting Table Valued Parameters (TVP) in SQL Server
+//
+// Value is a slice of structs with a field per column of the table type.
+// Fields tagged `tvp:"-"`, or `json:"-"` without a tvp tag, are skipped. The
+// fields are sent in order, unless a field names its column with a tag such
+// as `tvp:"column_name"`: then the columns of the table type are looked up
+// once per connection and every field is mapped to the column named by its
+// tag or, untagged, to the column with the name of the field. Nil pointer
+// and slice fields are sent as NULL.
 type TVP struct {
 	//TypeName mustn't be default value
 	TypeName string
@@ -97,24 +107,19 @@ func (tvp TVP) encode(schema, name string, columnStr []columnStruct, tvpFieldInd
 		buf.WriteByte(_TVP_ROW_TOKEN)
 		for columnStrIdx, fieldIdx := range tvpFieldIndexes {
 			field := refStr.Field(fieldIdx)
-			tvpVal := field.Interface()
-			if tvp.verifyStandardTypeOnNull(buf, tvpVal) {
-				continue
-			}
-			valOf := reflect.ValueOf(tvpVal)
-			elemKind := field.Kind()
-			if elemKind == reflect.Ptr && valOf.IsNil() {
-				switch tvpVal.(type) {
-				case *bool, *time.Time, *int8, *int16, *int32, *int64, *float32, *float64, *int:
-					binary.Write(buf, binary.LittleEndian, uint8(0))
-					continue
-				default:
-					binary.Write(buf, binary.LittleEndian, uint64(_PLP_NULL))
+			switch field.Kind() {
+			case reflect.Ptr, reflect.Slice:
+				if field.IsNil() {
+					ti := columnStr[columnStrIdx].ti
+					ti.Writer(buf, ti, nil)
 					continue
 				}
+				if field.Kind() == reflect.Ptr {
+					field = field.Elem()
+				}
 			}
-			if elemKind == reflect.Slice && valOf.IsNil() {
-				binary.Write(buf, binary.LittleEndian, uint64(_PLP_NULL))
+			tvpVal := field.Interface()
+			if tvp.verifyStandardTypeOnNull(buf, tvpVal) {
 				continue
 			}
 
@@ -133,7 +138,10 @@ func (tvp TVP) encode(schema, name string, columnStr []columnStruct, tvpFieldInd
 	return buf.Bytes(), nil
 }
 
-func (tvp TVP) columnTypes() ([]columnStruct, []int, error) {
+// columnTypes returns the columns of the table type and the indexes of
+// the fields sent for them, in field order, or in the order of columns
+// when the fields name their columns.
+func (tvp TVP) columnTypes(columns []string) ([]columnStruct, []int, error) {
 	val := reflect.ValueOf(tvp.Value)
 	var firstRow interface{}
 	if val.Len() != 0 {
@@ -144,26 +152,25 @@ func (tvp TVP) columnTypes() ([]columnStruct, []int, error) {
 
 	tvpRow := reflect.TypeOf(firstRow)
 	columnCount := tvpRow.NumField()
-	defaultValues := make([]interface{}, 0, columnCount)
-	tvpFieldIndexes := make([]int, 0, columnCount)
-	for i := 0; i < columnCount; i++ {
-		field := tvpRow.Field(i)
-		tvpTagValue, isTvpTag := field.Tag.Lookup(tvpTag)
-		jsonTagValue, isJsonTag := field.Tag.Lookup(jsonTag)
-		if IsSkipField(tvpTagValue, isTvpTag, jsonTagValue, isJsonTag) {
-			continue
-		}
-		tvpFieldIndexes = append(tvpFieldIndexes, i)
-		if field.Type.Kind() == reflect.Ptr {
-			v := reflect.New(field.Type.Elem())
-			defaultValues = append(defaultValues, v.Interface())
-			continue
+	tvpFieldIndexes := tvpFields(tvpRow)
+	if len(tvpFieldIndexes) == 0 {
+		return nil, nil, ErrorSkip
+	}
+	if columns != nil {
+		var err error
+		tvpFieldIndexes, err = tvp.orderFields(tvpRow, tvpFieldIndexes, columns)
+		if err != nil {
+			return nil, nil, err
 		}
-		defaultValues = append(defaultValues, tvp.createZeroType(reflect.Zero(field.Type).Interface()))
 	}
 
-	if columnCount-len(tvpFieldIndexes) == columnCount {
-		return nil, nil, ErrorSkip
+	defaultValues := make([]interface{}, 0, len(tvpFieldIndexes))
+	for _, i := range tvpFieldIndexes {
+		fieldType := tvpRow.Field(i).Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		defaultValues = append(defaultValues, tvp.createZeroType(reflect.Zero(fieldType).Interface()))
 	}
 
 	conn := new(Conn)
@@ -196,6 +203,140 @@ func (tvp TVP) columnTypes() ([]columnStruct, []int, error) {
 	return columnConfiguration, tvpFieldIndexes, nil
 }
 
+// tvpFields returns the indexes of the fields of row that are not skipped.
+func tvpFields(row reflect.Type) []int {
+	indexes := make([]int, 0, row.NumField())
+	for i := 0; i < row.NumField(); i++ {
+		field := row.Field(i)
+		tvpTagValue, isTvpTag := field.Tag.Lookup(tvpTag)
+		jsonTagValue, isJsonTag := field.Tag.Lookup(jsonTag)
+		if !IsSkipField(tvpTagValue, isTvpTag, jsonTagValue, isJsonTag) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// namedColumns reports whether a field of the rows names its column with a
+// tvp tag, in which case the fields are mapped to the columns by name.
+func (tvp TVP) namedColumns() bool {
+	typ := reflect.TypeOf(tvp.Value)
+	if typ == nil || typ.Kind() != reflect.Slice || typ.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	row := typ.Elem()
+	for _, i := range tvpFields(row) {
+		if _, ok := row.Field(i).Tag.Lookup(tvpTag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// orderFields returns the indexes of the fields of row sent for the given
+// columns of the table type, in column order. Column names are matched
+// case-insensitively, like SQL Server identifiers.
+func (tvp TVP) orderFields(row reflect.Type, fieldIndexes []int, columns []string) ([]int, error) {
+	byName := make(map[string]int, len(fieldIndexes))
+	for _, i := range fieldIndexes {
+		key := strings.ToLower(tvpColumnName(row.Field(i)))
+		if _, ok := byName[key]; ok {
+			return nil, fmt.Errorf("mssql: more than one field of %s for column %s", row, tvpColumnName(row.Field(i)))
+		}
+		byName[key] = i
+	}
+	ordered := make([]int, 0, len(columns))
+	for _, column := range columns {
+		key := strings.ToLower(column)
+		i, ok := byName[key]
+		if !ok {
+			return nil, fmt.Errorf("mssql: no field of %s for column %s of table type %s", row, column, tvp.TypeName)
+		}
+		delete(byName, key)
+		ordered = append(ordered, i)
+	}
+	for _, i := range fieldIndexes {
+		if _, ok := byName[strings.ToLower(tvpColumnName(row.Field(i)))]; ok {
+			return nil, fmt.Errorf("mssql: table type %s has no column %s", tvp.TypeName, tvpColumnName(row.Field(i)))
+		}
+	}
+	return ordered, nil
+}
+
+// tvpColumnName returns the column of a field, named by its tvp tag or by
+// the field name.
+func tvpColumnName(field reflect.StructField) string {
+	if tag, ok := field.Tag.Lookup(tvpTag); ok {
+		return tag
+	}
+	return field.Name
+}
+
+// tableTypeColumnsQuery lists the columns of the table type @p1, resolved
+// as the server resolves type names.
+const tableTypeColumnsQuery = `select c.name from sys.table_types t
+join sys.columns c on c.object_id = t.type_table_object_id
+where t.user_type_id = type_id(@p1)
+order by c.column_id`
+
+// lookupTVPColumns looks up the columns of the table types of the TVP
+// arguments that map their fields by name, unless the connection already
+// did.
+func (s *Stmt) lookupTVPColumns(ctx context.Context, args []namedValue) error {
+	for _, arg := range args {
+		tvp, ok := arg.Value.(TVP)
+		if !ok || !tvp.namedColumns() {
+			continue
+		}
+		if _, ok = s.c.tvpColumns[tvp.TypeName]; ok {
+			continue
+		}
+		columns, err := s.c.tableTypeColumns(ctx, tvp.TypeName)
+		if err != nil {
+			return err
+		}
+		if s.c.tvpColumns == nil {
+			s.c.tvpColumns = make(map[string][]string)
+		}
+		s.c.tvpColumns[tvp.TypeName] = columns
+	}
+	return nil
+}
+
+func (c *Conn) tableTypeColumns(ctx context.Context, typeName string) ([]string, error) {
+	// keep the output parameters of the statement being sent
+	outs := c.outs
+	c.outs = outputs{}
+	defer func() { c.outs = outs }()
+
+	stmt, err := c.prepareContext(ctx, tableTypeColumnsQuery)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.queryContext(ctx, []namedValue{{Ordinal: 1, Value: typeName}})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	dest := make([]driver.Value, 1)
+	for {
+		err = rows.Next(dest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name, _ := dest[0].(string)
+		columns = append(columns, name)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("mssql: table type %s not found", typeName)
+	}
+	return columns, nil
+}
+
 func IsSkipField(tvpTagValue string, isTvpValue bool, jsonTagValue string, isJsonTagValue bool) bool {
 	if !isTvpValue && !isJsonTagValue {
 		return false
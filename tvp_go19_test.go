@@ -3,9 +3,14 @@
 package mssql
 
 import (
+	"database/sql"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+	"github.com/golang-sql/civil"
 )
 
 type TestFields struct {
@@ -116,7 +121,7 @@ func TestTVPType_columnTypes(t *testing.T) {
 				TypeName: tt.fields.TVPName,
 				Value:    tt.fields.TVPValue,
 			}
-			_, _, err := tvp.columnTypes()
+			_, _, err := tvp.columnTypes(nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("TVP.columnTypes() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -322,7 +327,7 @@ func BenchmarkColumnTypes(b *testing.B) {
 		Value:    wal,
 	}
 	for i := 0; i < b.N; i++ {
-		_, _, err := tvp.columnTypes()
+		_, _, err := tvp.columnTypes(nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -577,3 +582,83 @@ func TestTVP_encode(t *testing.T) {
 		})
 	}
 }
+
+func TestTVPNamedColumns(t *testing.T) {
+	type row struct {
+		Note    *string     `tvp:"note"`
+		Skipped int         `tvp:"-"`
+		Day     *civil.Date `tvp:"day"`
+		ID      int64
+		Name    string `tvp:"name"`
+	}
+	var lookups int32
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if req.SQL != tableTypeColumnsQuery {
+			return nil
+		}
+		atomic.AddInt32(&lookups, 1)
+		if p := req.Param("@p1"); p == nil || p.Value != "dbo.people" {
+			t.Errorf("unexpected table type %v", req.Params)
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "name", Type: mssqltest.NVarChar}},
+			Rows:    [][]interface{}{{"id"}, {"Name"}, {"day"}, {"note"}},
+		}}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	note := "n"
+	rows := []row{
+		{ID: 1, Name: "a", Note: &note, Day: &civil.Date{Year: 2020, Month: 2, Day: 3}},
+		{ID: 2, Name: "b"},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = db.Exec("insert into people select * from @p", sql.Named("p", TVP{TypeName: "dbo.people", Value: rows})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Errorf("expected the table type to be looked up once, got %d", n)
+	}
+	reqs := srv.Requests()
+	tvp, ok := reqs[len(reqs)-1].Param("@p").Value.(mssqltest.TVP)
+	if !ok {
+		t.Fatalf("expected a TVP, got %#v", reqs[len(reqs)-1].Params)
+	}
+	want := [][]interface{}{
+		{int64(1), "a", time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC), "n"},
+		{int64(2), "b", nil, nil},
+	}
+	if tvp.TypeName != "dbo.people" || !reflect.DeepEqual(tvp.Rows, want) {
+		t.Errorf("got %s %v, want rows %v", tvp.TypeName, tvp.Rows, want)
+	}
+}
+
+func TestTVPOrderFields(t *testing.T) {
+	type row struct {
+		A int `tvp:"x"`
+		B int
+	}
+	tvp := TVP{TypeName: "t", Value: []row{}}
+	rowType := reflect.TypeOf(row{})
+	for _, tc := range []struct {
+		columns []string
+		want    []int
+		wantErr bool
+	}{
+		{columns: []string{"b", "X"}, want: []int{1, 0}},
+		{columns: []string{"x"}, wantErr: true},
+		{columns: []string{"x", "b", "c"}, wantErr: true},
+	} {
+		got, err := tvp.orderFields(rowType, []int{0, 1}, tc.columns)
+		if (err != nil) != tc.wantErr || !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %v, %v", tc.columns, got, err)
+		}
+	}
+}
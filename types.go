@@ -8,6 +8,7 @@ import (
 	"math"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/denisenkom/go-mssqldb/internal/cp"
@@ -210,8 +211,14 @@ func writeVarLen(w io.Writer, ti *typeInfo) (err error) {
 			return
 		}
 		ti.Writer = writeByteLenType
+	case typeXml:
+		// the type info has no length, values are always PLP
+		if err = binary.Write(w, binary.LittleEndian, ti.XmlInfo.SchemaPresent); err != nil {
+			return
+		}
+		ti.Writer = writePLPType
 	case typeBigVarBin, typeBigVarChar, typeBigBinary, typeBigChar,
-		typeNVarChar, typeNChar, typeXml, typeUdt:
+		typeNVarChar, typeNChar, typeUdt:
 
 		// short len types
 		if ti.Size > 8000 || ti.Size == 0 {
@@ -230,18 +237,17 @@ func writeVarLen(w io.Writer, ti *typeInfo) (err error) {
 			if err = writeCollation(w, ti.Collation); err != nil {
 				return
 			}
-		case typeXml:
-			if err = binary.Write(w, binary.LittleEndian, ti.XmlInfo.SchemaPresent); err != nil {
-				return
-			}
 		}
 	case typeText, typeImage, typeNText, typeVariant:
 		// LONGLEN_TYPE
 		if err = binary.Write(w, binary.LittleEndian, uint32(ti.Size)); err != nil {
 			return
 		}
-		if err = writeCollation(w, ti.Collation); err != nil {
-			return
+		switch ti.TypeId {
+		case typeText, typeNText:
+			if err = writeCollation(w, ti.Collation); err != nil {
+				return
+			}
 		}
 		ti.Writer = writeLongLenType
 	default:
@@ -261,14 +267,22 @@ func decodeDateTim4(buf []byte) time.Time {
 func encodeDateTim4(val time.Time) (buf []byte) {
 	buf = make([]byte, 4)
 
-	ref := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-	dur := val.Sub(ref)
-	days := dur / (24 * time.Hour)
+	// rounded to the minute as SQL Server does
+	if int64(val.Second())*1e9+int64(val.Nanosecond()) >= 29999*1e6 {
+		val = val.Add(time.Minute)
+	}
+	// days since Jan 1st 1900 (same TZ as val)
+	days := gregorianDays(val.Year(), val.YearDay()) - gregorianDays(1900, 1)
 	mins := val.Hour()*60 + val.Minute()
 	if days < 0 {
 		days = 0
 		mins = 0
 	}
+	// the maximum, Jun 6th 2079 23:59
+	if days > math.MaxUint16 {
+		days = math.MaxUint16
+		mins = 23*60 + 59
+	}
 
 	binary.LittleEndian.PutUint16(buf[:2], uint16(days))
 	binary.LittleEndian.PutUint16(buf[2:], uint16(mins))
@@ -474,10 +488,11 @@ func writeShortLenType(w io.Writer, ti typeInfo, buf []byte) (err error) {
 		err = binary.Write(w, binary.LittleEndian, uint16(0xffff))
 		return
 	}
-	if ti.Size > 0xfffe {
+	// the length of the value, which may be less than the declared one
+	if len(buf) > 0xfffe {
 		panic("Invalid size for USHORTLEN_TYPE")
 	}
-	err = binary.Write(w, binary.LittleEndian, uint16(ti.Size))
+	err = binary.Write(w, binary.LittleEndian, uint16(len(buf)))
 	if err != nil {
 		return
 	}
@@ -502,6 +517,9 @@ func readLongLenType(ti *typeInfo, r *tdsBuffer) interface{} {
 	if size == -1 {
 		return nil
 	}
+	if size < 0 {
+		badStreamPanicf("Invalid size %d of a %s value", size, makeDecl(*ti))
+	}
 	buf := make([]byte, size)
 	r.ReadFull(buf)
 	switch ti.TypeId {
@@ -517,6 +535,11 @@ func readLongLenType(ti *typeInfo, r *tdsBuffer) interface{} {
 	panic("shoulnd't get here")
 }
 func writeLongLenType(w io.Writer, ti typeInfo, buf []byte) (err error) {
+	if buf == nil {
+		// a null value has no textptr
+		_, err = w.Write([]byte{0})
+		return
+	}
 	//textptr
 	err = binary.Write(w, binary.LittleEndian, byte(0x10))
 	if err != nil {
@@ -544,6 +567,19 @@ func writeLongLenType(w io.Writer, ti typeInfo, buf []byte) (err error) {
 	return
 }
 
+// writeRPCLongLenType writes the value of a text, ntext or image parameter,
+// which unlike the values of rows has no textptr and timestamp.
+func writeRPCLongLenType(w io.Writer, ti typeInfo, buf []byte) (err error) {
+	if buf == nil {
+		return binary.Write(w, binary.LittleEndian, uint32(0xffffffff))
+	}
+	if err = binary.Write(w, binary.LittleEndian, uint32(len(buf))); err != nil {
+		return
+	}
+	_, err = w.Write(buf)
+	return
+}
+
 func readCollation(r *tdsBuffer) (res cp.Collation) {
 	res.LcidAndFlags = r.uint32()
 	res.SortId = r.byte()
@@ -567,6 +603,9 @@ func readVariantType(ti *typeInfo, r *tdsBuffer) interface{} {
 	}
 	vartype := r.byte()
 	propbytes := int32(r.byte())
+	if size < 2+propbytes {
+		badStreamPanicf("Invalid sql_variant size %d", size)
+	}
 	switch vartype {
 	case typeGuid:
 		buf := make([]byte, size-2-propbytes)
@@ -901,11 +940,9 @@ func calcTimeSize(scale int) int {
 func encodeTimeInt(seconds, ns, scale int, buf []byte) {
 	ns_total := int64(seconds)*1000*1000*1000 + int64(ns)
 	t := ns_total / int64(math.Pow10(int(scale)*-1)*1e9)
-	buf[0] = byte(t)
-	buf[1] = byte(t >> 8)
-	buf[2] = byte(t >> 16)
-	buf[3] = byte(t >> 24)
-	buf[4] = byte(t >> 32)
+	for i := 0; i < calcTimeSize(scale); i++ {
+		buf[i] = byte(t >> (8 * uint(i)))
+	}
 }
 
 func decodeTime(scale uint8, buf []byte) time.Time {
@@ -989,6 +1026,22 @@ func dateTime2(t time.Time) (days int, seconds int, ns int) {
 	return
 }
 
+// timesInLocation moves the date and time values of row of the types
+// without offset to loc, keeping their date and clock, see
+// Connector.Location.
+func timesInLocation(columns []columnStruct, row []interface{}, loc *time.Location) {
+	for i := range columns {
+		switch columns[i].ti.TypeId {
+		case typeDateTim4, typeDateTime, typeDateTimeN, typeDateN, typeTimeN, typeDateTime2N:
+		default:
+			continue
+		}
+		if t, ok := row[i].(time.Time); ok {
+			row[i] = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		}
+	}
+}
+
 func decodeChar(col cp.Collation, buf []byte) string {
 	return cp.CharsetToUTF8(col, buf)
 }
@@ -1112,7 +1165,10 @@ func makeGoLangScanType(ti typeInfo) reflect.Type {
 	case typeBigBinary:
 		return reflect.TypeOf([]byte{})
 	case typeVariant:
-		return reflect.TypeOf(nil)
+		// the type of the value depends on its base type
+		return reflect.TypeOf((*interface{})(nil)).Elem()
+	case typeUdt:
+		return reflect.TypeOf([]byte{})
 	default:
 		panic(fmt.Sprintf("not implemented makeGoLangScanType for type %d", ti.TypeId))
 	}
@@ -1217,7 +1273,7 @@ func makeDecl(ti typeInfo) string {
 			panic("invalid size of DATETIMNTYPE")
 		}
 	case typeTimeN:
-		return "time"
+		return fmt.Sprintf("time(%d)", ti.Scale)
 	case typeDateTime2N:
 		return fmt.Sprintf("datetime2(%d)", ti.Scale)
 	case typeDateTimeOffsetN:
@@ -1226,10 +1282,14 @@ func makeDecl(ti typeInfo) string {
 		return "text"
 	case typeNText:
 		return "ntext"
+	case typeImage:
+		return "image"
 	case typeUdt:
 		return ti.UdtInfo.TypeName
 	case typeGuid:
 		return "uniqueidentifier"
+	case typeXml:
+		return "xml"
 	case typeTvp:
 		if ti.UdtInfo.SchemaName != "" {
 			return fmt.Sprintf("%s.%s READONLY", ti.UdtInfo.SchemaName, ti.UdtInfo.TypeName)
@@ -1342,6 +1402,8 @@ func makeGoLangTypeName(ti typeInfo) string {
 		return "SQL_VARIANT"
 	case typeBigBinary:
 		return "BINARY"
+	case typeUdt:
+		return strings.ToUpper(ti.UdtInfo.TypeName)
 	default:
 		panic(fmt.Sprintf("not implemented makeGoLangTypeName for type %d", ti.TypeId))
 	}
@@ -1465,6 +1527,8 @@ func makeGoLangTypeLength(ti typeInfo) (int64, bool) {
 		return 0, false
 	case typeBigBinary:
 		return 0, false
+	case typeUdt:
+		return 2147483647, true
 	default:
 		panic(fmt.Sprintf("not implemented makeGoLangTypeLength for type %d", ti.TypeId))
 	}
@@ -1576,6 +1640,8 @@ func makeGoLangTypePrecisionScale(ti typeInfo) (int64, int64, bool) {
 		return 0, 0, false
 	case typeBigBinary:
 		return 0, 0, false
+	case typeUdt:
+		return 0, 0, false
 	default:
 		panic(fmt.Sprintf("not implemented makeGoLangTypePrecisionScale for type %d", ti.TypeId))
 	}
@@ -1,6 +1,8 @@
 package mssql
 
 import (
+	"bytes"
+	"encoding/binary"
 	"reflect"
 	"testing"
 	"time"
@@ -133,6 +135,9 @@ func TestMakeDecl(t *testing.T) {
 		{"varbinary(max)", 0xffff, typeBigVarBin},
 		{"varbinary(8000)", 8000, typeBigVarBin},
 		{"varbinary(4001)", 4001, typeBigVarBin},
+		{"text", 0x7fffffff, typeText},
+		{"ntext", 0x7ffffffe, typeNText},
+		{"image", 0x7fffffff, typeImage},
 	}
 
 	for _, tt := range tests {
@@ -148,3 +153,68 @@ func handlePanic(t *testing.T) {
 		t.Errorf("recovered panic")
 	}
 }
+
+func TestTimesInLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	columns := []columnStruct{
+		{ti: typeInfo{TypeId: typeDateTimeN}},
+		{ti: typeInfo{TypeId: typeDateTime2N}},
+		{ti: typeInfo{TypeId: typeDateTimeOffsetN}},
+		{ti: typeInfo{TypeId: typeDateTimeN}},
+	}
+	utc := time.Date(2020, 3, 4, 5, 6, 7, 8, time.UTC)
+	offset := time.Date(2020, 3, 4, 5, 6, 7, 8, time.FixedZone("", -60*60))
+	row := []interface{}{utc, utc, offset, nil}
+	timesInLocation(columns, row, loc)
+	expected := time.Date(2020, 3, 4, 5, 6, 7, 8, loc)
+	for i := 0; i < 2; i++ {
+		if tm := row[i].(time.Time); !tm.Equal(expected) || tm.Location() != loc {
+			t.Errorf("column %d: expected %v, got %v", i, expected, tm)
+		}
+	}
+	if tm := row[2].(time.Time); tm != offset {
+		t.Errorf("expected the datetimeoffset value unchanged, got %v", tm)
+	}
+	if row[3] != nil {
+		t.Errorf("expected nil, got %v", row[3])
+	}
+}
+
+func TestReadVariantType(t *testing.T) {
+	variant := func(baseType byte, props []byte, value []byte) []byte {
+		buf := make([]byte, 4, 6+len(props)+len(value))
+		binary.LittleEndian.PutUint32(buf, uint32(2+len(props)+len(value)))
+		buf = append(buf, baseType, byte(len(props)))
+		return append(append(buf, props...), value...)
+	}
+	latin1 := []byte{0x09, 0x04, 0xd0, 0x00, 0x34}
+	tests := []struct {
+		payload  []byte
+		expected interface{}
+	}{
+		{[]byte{0, 0, 0, 0}, nil},
+		{variant(typeInt4, nil, []byte{0xec, 0xff, 0xff, 0xff}), int64(-20)},
+		{variant(typeBit, nil, []byte{1}), true},
+		{variant(typeFlt8, nil, []byte{0, 0, 0, 0, 0, 0, 0xc0, 0x3f}), 0.125},
+		{variant(typeNVarChar, append(latin1, 20, 0), str2ucs2("h€llo")), "h€llo"},
+		{variant(typeBigVarChar, append(latin1, 10, 0), []byte{'c', 0xe9}), "cé"},
+		{variant(typeBigVarBin, []byte{10, 0}, []byte{1, 2, 3}), []byte{1, 2, 3}},
+		{variant(typeDecimalN, []byte{10, 2}, []byte{0, 0x39, 0x30, 0, 0}), []byte("-123.45")},
+		{variant(typeDateTime2N, []byte{0}, []byte{0x72, 0x39, 0x00, 0x1f, 0x42, 0x0b}), time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		packet := []byte{byte(packReply), 1, 0, 0, 0, 0, 1, 0}
+		binary.BigEndian.PutUint16(packet[2:], uint16(8+len(tt.payload)))
+		r := newTdsBuffer(4096, closableBuffer{bytes.NewBuffer(append(packet, tt.payload...))})
+		if _, err := r.BeginRead(); err != nil {
+			t.Fatal(err)
+		}
+		ti := typeInfo{TypeId: typeVariant}
+		if v := readVariantType(&ti, r); !reflect.DeepEqual(v, tt.expected) {
+			t.Errorf("expected %#v, got %#v", tt.expected, v)
+		}
+	}
+	if st := makeGoLangScanType(typeInfo{TypeId: typeVariant}); st == nil || st.Kind() != reflect.Interface {
+		t.Errorf("expected the interface{} scan type, got %v", st)
+	}
+}
@@ -0,0 +1,125 @@
+package mssql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// UDT converts the values of a CLR user-defined type, such as a type of
+// an assembly of the database or one of the built-in hierarchyid,
+// geometry and geography types, to and from a Go type. See RegisterUDT.
+type UDT struct {
+	// Type is the Go type of the values, scanned from the columns of the
+	// user-defined type and passed as parameters. ColumnTypeScanType
+	// reports it for the columns.
+	Type reflect.Type
+	// Decode converts the serialization of a value, as written by the
+	// Write method of the type, to a value of Type. Nil values are not
+	// decoded.
+	Decode func(b []byte) (interface{}, error)
+	// Encode serializes a parameter value of Type. The serialization is
+	// sent as varbinary, which the server converts to the user-defined type
+	// implicitly. When Encode is nil values of Type cannot be parameters.
+	Encode func(v interface{}) ([]byte, error)
+}
+
+var udts struct {
+	sync.RWMutex
+	byName map[string]*UDT
+	byType map[reflect.Type]*UDT
+}
+
+// RegisterUDT registers the conversion of the values of the user-defined
+// type named name, qualified by its schema, such as dbo.Point, or not
+// for the types of any schema, such as hierarchyid. Names are case
+// insensitive. The values of the types not registered are scanned as
+// their serialization in a []byte.
+//
+// RegisterUDT panics when the type name or the Go type is already
+// registered or when udt has no Type or no Decode function.
+func RegisterUDT(name string, udt UDT) {
+	if udt.Type == nil || udt.Decode == nil {
+		panic("mssql: RegisterUDT of " + name + " without a Type or a Decode function")
+	}
+	key := strings.ToLower(name)
+	udts.Lock()
+	defer udts.Unlock()
+	if _, dup := udts.byName[key]; dup {
+		panic("mssql: RegisterUDT called twice for " + name)
+	}
+	if _, dup := udts.byType[udt.Type]; dup && udt.Encode != nil {
+		panic("mssql: RegisterUDT called twice for the Go type " + udt.Type.String())
+	}
+	if udts.byName == nil {
+		udts.byName = make(map[string]*UDT)
+		udts.byType = make(map[reflect.Type]*UDT)
+	}
+	udts.byName[key] = &udt
+	if udt.Encode != nil {
+		udts.byType[udt.Type] = &udt
+	}
+}
+
+// lookupUDT returns the registered conversion of the user-defined type of
+// ti, nil if there is none.
+func lookupUDT(ti typeInfo) *UDT {
+	if ti.TypeId != typeUdt {
+		return nil
+	}
+	udts.RLock()
+	defer udts.RUnlock()
+	if len(udts.byName) == 0 {
+		return nil
+	}
+	name := strings.ToLower(ti.UdtInfo.TypeName)
+	if udt, ok := udts.byName[strings.ToLower(ti.UdtInfo.SchemaName)+"."+name]; ok {
+		return udt
+	}
+	return udts.byName[name]
+}
+
+// lookupUDTEncoder returns the registered conversion of parameters of the
+// type of v, nil if there is none.
+func lookupUDTEncoder(v interface{}) *UDT {
+	udts.RLock()
+	defer udts.RUnlock()
+	if len(udts.byType) == 0 {
+		return nil
+	}
+	return udts.byType[reflect.TypeOf(v)]
+}
+
+// decodeUDTs converts the values of row of the registered user-defined
+// types.
+func decodeUDTs(columns []columnStruct, row []interface{}) error {
+	for i := range columns {
+		b, ok := row[i].([]byte)
+		if !ok {
+			continue
+		}
+		udt := lookupUDT(columns[i].ti)
+		if udt == nil {
+			continue
+		}
+		v, err := udt.Decode(b)
+		if err != nil {
+			return fmt.Errorf("mssql: cannot decode the %s value of column %s: %v", columns[i].ti.UdtInfo.TypeName, columns[i].ColName, err)
+		}
+		row[i] = v
+	}
+	return nil
+}
+
+// param returns the varbinary parameter of the serialization of v.
+func (udt *UDT) param(v interface{}) (res param, err error) {
+	b, err := udt.Encode(v)
+	if err != nil {
+		return res, err
+	}
+	res.ti.TypeId = typeBigVarBin
+	res.buffer = b
+	res.ti.Size = len(b)
+	return res, nil
+}
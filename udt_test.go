@@ -0,0 +1,131 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+type testPoint struct {
+	X, Y int32
+}
+
+func init() {
+	RegisterUDT("Test.Point", UDT{
+		Type: reflect.TypeOf(testPoint{}),
+		Decode: func(b []byte) (interface{}, error) {
+			if len(b) != 8 {
+				return nil, errors.New("expected 8 bytes")
+			}
+			return testPoint{int32(binary.LittleEndian.Uint32(b)), int32(binary.LittleEndian.Uint32(b[4:]))}, nil
+		},
+		Encode: func(v interface{}) ([]byte, error) {
+			p := v.(testPoint)
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint32(b, uint32(p.X))
+			binary.LittleEndian.PutUint32(b[4:], uint32(p.Y))
+			return b, nil
+		},
+	})
+	RegisterUDT("testlabel", UDT{
+		Type: reflect.TypeOf(""),
+		Decode: func(b []byte) (interface{}, error) {
+			return string(b), nil
+		},
+	})
+}
+
+func TestUDT(t *testing.T) {
+	point := func(schema string) mssqltest.Column {
+		return mssqltest.Column{Name: "p", Type: mssqltest.UDT, UDTType: &mssqltest.UDTType{Database: "db", Schema: schema, Name: "point", AssemblyQualifiedName: "Point, Test"}}
+	}
+	label := mssqltest.Column{Name: "l", Type: mssqltest.UDT, UDTType: &mssqltest.UDTType{Schema: "other", Name: "TestLabel"}}
+	rows := [][]interface{}{{[]byte{1, 0, 0, 0, 2, 0, 0, 0}, []byte{1, 0, 0, 0, 2, 0, 0, 0}, []byte("l"), nil}}
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if len(req.Params) > 0 {
+			return nil
+		}
+		if req.SQL == "select bad" {
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{point("test")},
+				Rows:    [][]interface{}{{[]byte{1}}},
+			}}
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{point("test"), point("dbo"), label, point("test")},
+			Rows:    rows,
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	r, err := db.Query("select p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	types, err := r.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTypes := []reflect.Type{reflect.TypeOf(testPoint{}), reflect.TypeOf([]byte{}), reflect.TypeOf(""), reflect.TypeOf(testPoint{})}
+	for i, typ := range types {
+		if typ.ScanType() != expectedTypes[i] {
+			t.Errorf("column %d: expected the scan type %v, got %v", i, expectedTypes[i], typ.ScanType())
+		}
+	}
+	if !r.Next() {
+		t.Fatal(r.Err())
+	}
+	var p testPoint
+	var raw []byte
+	var l string
+	var null interface{}
+	if err := r.Scan(&p, &raw, &l, &null); err != nil {
+		t.Fatal(err)
+	}
+	if p != (testPoint{1, 2}) || !bytes.Equal(raw, rows[0][1].([]byte)) || l != "l" || null != nil {
+		t.Errorf("unexpected values %v, %v, %q and %v", p, raw, l, null)
+	}
+	r.Close()
+
+	r, err = db.Query("select bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Next() {
+		t.Error("expected the decoding to fail")
+	} else if err := r.Err(); err == nil {
+		t.Error("expected an error")
+	}
+	r.Close()
+
+	if _, err := db.Exec("insert into t values (@p1)", testPoint{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	if v, _ := reqs[len(reqs)-1].Param("@p1").Value.([]byte); !bytes.Equal(v, []byte{3, 0, 0, 0, 4, 0, 0, 0}) {
+		t.Errorf("unexpected parameter %v", reqs[len(reqs)-1].Param("@p1").Value)
+	}
+}
+
+func TestRegisterUDTTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	RegisterUDT("TEST.point", UDT{Type: reflect.TypeOf(0), Decode: func(b []byte) (interface{}, error) { return nil, nil }})
+}
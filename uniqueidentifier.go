@@ -7,15 +7,39 @@ import (
 	"fmt"
 )
 
+// UniqueIdentifier is a uniqueidentifier value in the canonical byte order
+// of its string form, 01234567-89AB-CDEF-0123-456789ABCDEF being the bytes
+// 0x01 to 0xEF in order. SQL Server stores the first three groups little
+// endian, so a uniqueidentifier scanned into a []byte has its bytes swapped,
+// while one scanned into a UniqueIdentifier has not. As a parameter it is
+// sent as a uniqueidentifier. Scan nullable columns into a
+// NullUniqueIdentifier.
 type UniqueIdentifier [16]byte
 
-func (u *UniqueIdentifier) Scan(v interface{}) error {
-	reverse := func(b []byte) {
-		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
-			b[i], b[j] = b[j], b[i]
+// ParseUniqueIdentifier parses the string form of a uniqueidentifier, such
+// as 01234567-89AB-CDEF-0123-456789ABCDEF, with or without the dashes and
+// the enclosing braces.
+func ParseUniqueIdentifier(s string) (UniqueIdentifier, error) {
+	var u UniqueIdentifier
+	if len(s) == 38 && s[0] == '{' && s[37] == '}' {
+		s = s[1:37]
+	}
+	if len(s) == 36 {
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return u, fmt.Errorf("mssql: invalid UniqueIdentifier %q", s)
 		}
+		s = s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
 	}
+	if len(s) != 32 {
+		return u, errors.New("mssql: invalid UniqueIdentifier string length")
+	}
+	if _, err := hex.Decode(u[:], []byte(s)); err != nil {
+		return u, fmt.Errorf("mssql: invalid UniqueIdentifier %q: %v", s, err)
+	}
+	return u, nil
+}
 
+func (u *UniqueIdentifier) Scan(v interface{}) error {
 	switch vt := v.(type) {
 	case []byte:
 		if len(vt) != 16 {
@@ -25,48 +49,40 @@ func (u *UniqueIdentifier) Scan(v interface{}) error {
 		var raw UniqueIdentifier
 
 		copy(raw[:], vt)
-
-		reverse(raw[0:4])
-		reverse(raw[4:6])
-		reverse(raw[6:8])
+		raw.swap()
 		*u = raw
 
 		return nil
 	case string:
-		if len(vt) != 36 {
-			return errors.New("mssql: invalid UniqueIdentifier string length")
-		}
-
-		b := []byte(vt)
-		for i, c := range b {
-			switch c {
-			case '-':
-				b = append(b[:i], b[i+1:]...)
-			}
+		raw, err := ParseUniqueIdentifier(vt)
+		if err != nil {
+			return err
 		}
-
-		_, err := hex.Decode(u[:], []byte(b))
-		return err
+		*u = raw
+		return nil
+	case nil:
+		return errors.New("mssql: cannot scan NULL into a UniqueIdentifier, scan into a NullUniqueIdentifier")
 	default:
 		return fmt.Errorf("mssql: cannot convert %T to UniqueIdentifier", v)
 	}
 }
 
-func (u UniqueIdentifier) Value() (driver.Value, error) {
+// swap converts between the canonical and the SQL Server byte orders.
+func (u *UniqueIdentifier) swap() {
 	reverse := func(b []byte) {
 		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
 			b[i], b[j] = b[j], b[i]
 		}
 	}
+	reverse(u[0:4])
+	reverse(u[4:6])
+	reverse(u[6:8])
+}
 
-	raw := make([]byte, len(u))
-	copy(raw, u[:])
-
-	reverse(raw[0:4])
-	reverse(raw[4:6])
-	reverse(raw[6:8])
-
-	return raw, nil
+// Value returns the bytes of u in the SQL Server byte order.
+func (u UniqueIdentifier) Value() (driver.Value, error) {
+	u.swap()
+	return u[:], nil
 }
 
 func (u UniqueIdentifier) String() string {
@@ -78,3 +94,44 @@ func (u UniqueIdentifier) String() string {
 func (u UniqueIdentifier) MarshalText() []byte {
 	return []byte(u.String())
 }
+
+// UnmarshalText parses the string form of a uniqueidentifier, see
+// ParseUniqueIdentifier.
+func (u *UniqueIdentifier) UnmarshalText(text []byte) (err error) {
+	*u, err = ParseUniqueIdentifier(string(text))
+	return err
+}
+
+// param returns the uniqueidentifier parameter of u.
+func (u UniqueIdentifier) param() param {
+	var res param
+	res.ti.TypeId = typeGuid
+	res.ti.Size = 16
+	u.swap()
+	res.buffer = u[:]
+	return res
+}
+
+// NullUniqueIdentifier is a UniqueIdentifier that may be NULL.
+type NullUniqueIdentifier struct {
+	UUID  UniqueIdentifier
+	Valid bool // Valid is true if UUID is not NULL
+}
+
+// Scan implements the sql.Scanner interface.
+func (n *NullUniqueIdentifier) Scan(v interface{}) error {
+	if v == nil {
+		*n = NullUniqueIdentifier{}
+		return nil
+	}
+	n.Valid = true
+	return n.UUID.Scan(v)
+}
+
+// Value implements the driver.Valuer interface.
+func (n NullUniqueIdentifier) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.UUID.Value()
+}
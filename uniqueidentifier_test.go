@@ -77,3 +77,69 @@ func TestUniqueIdentifierMarshalText(t *testing.T) {
 var _ fmt.Stringer = UniqueIdentifier{}
 var _ sql.Scanner = &UniqueIdentifier{}
 var _ driver.Valuer = UniqueIdentifier{}
+
+func TestParseUniqueIdentifier(t *testing.T) {
+	expected := UniqueIdentifier{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF, 0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF}
+	for _, s := range []string{
+		"01234567-89AB-CDEF-0123-456789ABCDEF",
+		"01234567-89ab-cdef-0123-456789abcdef",
+		"{01234567-89AB-CDEF-0123-456789ABCDEF}",
+		"0123456789ABCDEF0123456789ABCDEF",
+	} {
+		u, err := ParseUniqueIdentifier(s)
+		if err != nil || u != expected {
+			t.Errorf("ParseUniqueIdentifier(%q) = %s, %v", s, u, err)
+		}
+	}
+	for _, s := range []string{"", "01234567-89AB-CDEF-0123-456789ABCDEG", "0123456789AB-CDEF-0123-456789ABCDEF-", "{01234567-89AB-CDEF-0123-456789ABCDEF"} {
+		if _, err := ParseUniqueIdentifier(s); err == nil {
+			t.Errorf("expected ParseUniqueIdentifier(%q) to fail", s)
+		}
+	}
+	var u UniqueIdentifier
+	if err := u.UnmarshalText([]byte(expected.String())); err != nil || u != expected {
+		t.Errorf("UnmarshalText = %s, %v", u, err)
+	}
+}
+
+func TestNullUniqueIdentifier(t *testing.T) {
+	wire := []byte{0x67, 0x45, 0x23, 0x01, 0xAB, 0x89, 0xEF, 0xCD, 0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF}
+	var n NullUniqueIdentifier
+	if err := n.Scan(wire); err != nil || !n.Valid || n.UUID.String() != "01234567-89AB-CDEF-0123-456789ABCDEF" {
+		t.Errorf("expected a valid value, got %v and error %v", n, err)
+	}
+	if v, err := n.Value(); err != nil || !bytes.Equal(v.([]byte), wire) {
+		t.Errorf("expected the bytes in the SQL Server order, got %v and error %v", v, err)
+	}
+	if err := n.Scan(nil); err != nil || n.Valid {
+		t.Errorf("expected NULL, got %v and error %v", n, err)
+	}
+	if v, err := n.Value(); err != nil || v != nil {
+		t.Errorf("expected nil, got %v and error %v", v, err)
+	}
+	var u UniqueIdentifier
+	if err := u.Scan(nil); err == nil {
+		t.Error("expected an error scanning NULL into a UniqueIdentifier")
+	}
+}
+
+func TestUniqueIdentifierParamEncoding(t *testing.T) {
+	u := UniqueIdentifier{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF, 0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF}
+	p := u.param()
+	if decl := makeDecl(p.ti); decl != "uniqueidentifier" {
+		t.Errorf("expected uniqueidentifier, got %s", decl)
+	}
+	wire := []byte{0x67, 0x45, 0x23, 0x01, 0xAB, 0x89, 0xEF, 0xCD, 0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF}
+	if !bytes.Equal(p.buffer, wire) {
+		t.Errorf("expected % x, got % x", wire, p.buffer)
+	}
+	if u[0] != 0x01 {
+		t.Error("param modified the value")
+	}
+	col := columnStruct{ti: typeInfo{TypeId: typeGuid, Size: 16}}
+	for _, v := range []interface{}{u, u.String()} {
+		if p, err := (&Bulk{}).makeParam(v, col); err != nil || !bytes.Equal(p.buffer, wire) {
+			t.Errorf("bulk copy of %T: expected % x, got % x and error %v", v, wire, p.buffer, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestUTF8Collation(t *testing.T) {
+	const text = "h€llo wörld"
+	for _, utf8 := range []bool{false, true} {
+		srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+			if len(req.Params) > 0 {
+				return nil
+			}
+			value := "hello"
+			if utf8 {
+				value = text
+			}
+			return []mssqltest.Response{mssqltest.ResultSet{
+				Columns: []mssqltest.Column{{Name: "s", Type: mssqltest.VarChar}},
+				Rows:    [][]interface{}{{value}},
+			}}
+		})
+		srv.UTF8 = utf8
+		defer srv.Close()
+		db, err := sql.Open("sqlserver", srv.DSN())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		var s string
+		if err := db.QueryRow("select s from t").Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		if utf8 && s != text || !utf8 && s != "hello" {
+			t.Errorf("utf8 %v: unexpected text %q", utf8, s)
+		}
+		if logins := srv.Logins(); len(logins) == 0 || !logins[0].UTF8Support {
+			t.Error("expected the login to ask for UTF-8")
+		}
+
+		if _, err := db.Exec("insert into t values (@p1)", VarChar(text)); err != nil {
+			t.Fatal(err)
+		}
+		reqs := srv.Requests()
+		p := reqs[len(reqs)-1].Param("@p1")
+		if p.Value != text {
+			t.Errorf("expected the parameter %q, got %q", text, p.Value)
+		}
+		// the UTF-8 collation of the database, or none
+		collation := []byte{0, 0, 0, 0, 0}
+		if utf8 {
+			collation = []byte{0x09, 0x04, 0xd0, 0x24, 0x00}
+		}
+		if !bytes.Equal(p.Collation, collation) {
+			t.Errorf("utf8 %v: expected the collation %X, got %X", utf8, collation, p.Collation)
+		}
+	}
+}
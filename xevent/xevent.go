@@ -0,0 +1,83 @@
+// Package xevent reads the live event stream of an Extended Events session.
+//
+// The stream is read with sys.fn_MSxe_read_event_stream, the function the
+// XEvent live data viewer uses. The server keeps the query open and sends
+// rows as events are dispatched, so no event file or ring buffer target is
+// needed. The session must be started and the login needs the VIEW SERVER
+// STATE permission.
+//
+//	r, err := xevent.Open(ctx, db, "monitoring")
+//	...
+//	defer r.Close()
+//	for {
+//		b, err := r.Next()
+//		if err != nil {
+//			...
+//		}
+//		handle(b)
+//	}
+//
+// Each Buffer holds a chunk in the binary format of .xel files, the first
+// ones describe the session metadata and the following ones the events.
+// Decoding them is left to the caller.
+package xevent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+)
+
+// Querier runs queries, it is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Buffer is a chunk of the event stream.
+type Buffer struct {
+	// Type is the kind of chunk as reported by the server.
+	Type int
+	Data []byte
+}
+
+// Reader reads the event stream of a session.
+type Reader struct {
+	rows   *sql.Rows
+	cancel context.CancelFunc
+}
+
+// Open starts reading the events of the named session. The stream ends
+// when ctx is done, Close is called, or the session is stopped.
+func Open(ctx context.Context, q Querier, session string) (*Reader, error) {
+	if session == "" {
+		return nil, errors.New("xevent: a session name is required")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	rows, err := q.QueryContext(ctx, "SELECT type, data FROM sys.fn_MSxe_read_event_stream(@p1, 0)", session)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Reader{rows: rows, cancel: cancel}, nil
+}
+
+// Next blocks until the next buffer arrives. It returns io.EOF when the
+// stream ended.
+func (r *Reader) Next() (Buffer, error) {
+	var b Buffer
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return b, err
+		}
+		return b, io.EOF
+	}
+	err := r.rows.Scan(&b.Type, &b.Data)
+	return b, err
+}
+
+// Close stops reading, it cancels the running query.
+func (r *Reader) Close() error {
+	r.cancel()
+	return r.rows.Close()
+}
@@ -0,0 +1,75 @@
+package xevent_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"testing"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+	"github.com/denisenkom/go-mssqldb/xevent"
+)
+
+func TestReader(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{{Name: "type", Type: mssqltest.Int}, {Name: "data", Type: mssqltest.VarBinary}},
+			Rows:    [][]interface{}{{int64(1), []byte{1, 2}}, {int64(2), []byte{3}}},
+		}}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r, err := xevent.Open(context.Background(), db, "monitoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	want := []xevent.Buffer{{Type: 1, Data: []byte{1, 2}}, {Type: 2, Data: []byte{3}}}
+	for _, w := range want {
+		b, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Type != w.Type || !bytes.Equal(b.Data, w.Data) {
+			t.Errorf("got %+v, want %+v", b, w)
+		}
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Errorf("expected the end of the stream, got %v", err)
+	}
+	reqs := srv.Requests()
+	req := reqs[len(reqs)-1]
+	if req.SQL != "SELECT type, data FROM sys.fn_MSxe_read_event_stream(@p1, 0)" || req.Params[0].Value != "monitoring" {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestReaderCancel(t *testing.T) {
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		return []mssqltest.Response{mssqltest.Delay(time.Minute)}
+	})
+	defer srv.Close()
+	db, err := sql.Open("sqlserver", srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = xevent.Open(ctx, db, "monitoring"); err == nil {
+		t.Fatal("expected the stream to end with the context")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("cancellation took too long")
+	}
+}
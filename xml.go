@@ -0,0 +1,50 @@
+package mssql
+
+// XML is an xml parameter value. Declared as xml rather than nvarchar, it
+// needs no conversion to be inserted into or compared with xml columns and
+// is validated by the server.
+//
+// The values of xml columns are read as strings, or streamed with the
+// StreamLOBs query argument.
+type XML string
+
+// XMLSchemaCollection is the XML schema collection of a typed xml column.
+// A query given a *[]XMLSchemaCollection argument sets it to the schema
+// collections of the columns of its result sets, the zero value for the
+// columns which are not typed xml:
+//
+//	var schemas []mssql.XMLSchemaCollection
+//	rows, err := db.QueryContext(ctx, "select doc from t", &schemas)
+type XMLSchemaCollection struct {
+	Database string
+	Schema   string
+	Name     string
+}
+
+// param returns the xml parameter of x.
+func (x XML) param() param {
+	var res param
+	res.ti.TypeId = typeXml
+	res.ti.Size = 0 // the value is sent in PLP chunks
+	res.buffer = str2ucs2(string(x))
+	return res
+}
+
+// setXMLSchemaCollections sets the XML schema collections argument of the
+// query, if any, to the schema collections of the columns of a result set.
+func (o outputs) setXMLSchemaCollections(cols []columnStruct) {
+	if o.xmlSchemaCollections == nil {
+		return
+	}
+	schemas := make([]XMLSchemaCollection, len(cols))
+	for i, col := range cols {
+		if col.ti.TypeId == typeXml && col.ti.XmlInfo.SchemaPresent != 0 {
+			schemas[i] = XMLSchemaCollection{
+				Database: col.ti.XmlInfo.DBName,
+				Schema:   col.ti.XmlInfo.OwningSchema,
+				Name:     col.ti.XmlInfo.XmlSchemaCollection,
+			}
+		}
+	}
+	*o.xmlSchemaCollections = schemas
+}
@@ -0,0 +1,81 @@
+// +build go1.10
+
+package mssql
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/mssqltest"
+)
+
+func TestXML(t *testing.T) {
+	const doc = "<order id=\"1\"><item>ü</item></order>"
+	srv := mssqltest.NewServer(func(req *mssqltest.Request) []mssqltest.Response {
+		if len(req.Params) > 0 {
+			return nil
+		}
+		return []mssqltest.Response{mssqltest.ResultSet{
+			Columns: []mssqltest.Column{
+				{Name: "id", Type: mssqltest.Int},
+				{Name: "doc", Type: mssqltest.XML},
+				{Name: "typed", Type: mssqltest.XML, XMLSchemaCollection: &mssqltest.XMLSchemaCollection{Database: "db", Schema: "dbo", Name: "orders"}},
+			},
+			Rows: [][]interface{}{{1, doc, doc}},
+		}}
+	})
+	defer srv.Close()
+	c, err := NewConnector(srv.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	var schemas []XMLSchemaCollection
+	rows, err := db.Query("select id, doc, typed from t", &schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	expected := []XMLSchemaCollection{{}, {}, {Database: "db", Schema: "dbo", Name: "orders"}}
+	if len(schemas) != len(expected) {
+		t.Fatalf("expected %d schema collections, got %v", len(expected), schemas)
+	}
+	for i := range expected {
+		if schemas[i] != expected[i] {
+			t.Errorf("column %d: expected %v, got %v", i, expected[i], schemas[i])
+		}
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := types[2].DatabaseTypeName(); name != "XML" {
+		t.Errorf("expected XML, got %s", name)
+	}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	var id int
+	var untyped string
+	var typed XML
+	if err := rows.Scan(&id, &untyped, &typed); err != nil {
+		t.Fatal(err)
+	}
+	if untyped != doc || string(typed) != doc {
+		t.Errorf("expected %s, got %s and %s", doc, untyped, typed)
+	}
+	rows.Close()
+
+	if _, err := db.Exec("insert into t values (@p1)", XML(doc)); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	if v := reqs[len(reqs)-1].Param("@p1").Value; v != doc {
+		t.Errorf("expected %s, got %v", doc, v)
+	}
+	if decl := makeDecl(XML(doc).param().ti); decl != "xml" {
+		t.Errorf("expected xml, got %s", decl)
+	}
+}